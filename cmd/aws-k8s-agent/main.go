@@ -68,6 +68,7 @@ func _main() int {
 
 	// Prometheus metrics
 	go ipamContext.ServeMetrics()
+	go ipamContext.ServeMetricsTextfile()
 
 	// CNI introspection endpoints
 	go ipamContext.ServeIntrospection()
@@ -14,14 +14,14 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/containernetworking/cni/pkg/skel"
@@ -30,6 +30,7 @@ import (
 	cniSpecVersion "github.com/containernetworking/cni/pkg/version"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
 
 	"github.com/aws/amazon-vpc-cni-k8s/cmd/routed-eni-cni-plugin/driver"
@@ -43,12 +44,49 @@ import (
 )
 
 const (
-	ipamDAddress       = "127.0.0.1:50051"
+	ipamDAddress = "127.0.0.1:50051"
+	// ipamDSocket is the Unix socket ipamd serves on instead of ipamDAddress when it checks the credentials of callers
+	ipamDSocket        = "/var/run/aws-node/ipamd.sock"
 	defaultLogFilePath = "/var/log/aws-routed-eni/plugin.log"
+	// maxInterfaceNameLength is the maximum length of a linux interface name
+	maxInterfaceNameLength = 15
+	// hostNetnsPath is the network namespace of the plugin process
+	hostNetnsPath = "/proc/self/ns/net"
+	// maxNetlinkRetries is the highest number of retries of a netlink operation
+	maxNetlinkRetries = 10
+	// netlinkRetryBackoff is how long the first retry of a netlink operation waits, the wait doubles on each retry
+	netlinkRetryBackoff = 10 * time.Millisecond
 )
 
 var (
 	version string
+
+	// podSysctlPrefixes and podSysctls are the allowlist of the sysctls that may be set in the network namespace of
+	// the pods: they only change the networking of the pod, not of the node. The TCP sysctls are listed one by one,
+	// some of them, like net.ipv4.tcp_mem, are global to the node and cannot be set in a pod.
+	podSysctlPrefixes = []string{"net.ipv4.conf.", "net.ipv6.conf.", "net.ipv4.neigh.", "net.ipv6.neigh."}
+	podSysctls        = map[string]bool{
+		"net.core.somaxconn":                   true,
+		"net.ipv4.icmp_echo_ignore_all":        true,
+		"net.ipv4.icmp_echo_ignore_broadcasts": true,
+		"net.ipv4.ip_local_port_range":         true,
+		"net.ipv4.ip_local_reserved_ports":     true,
+		"net.ipv4.ip_no_pmtu_disc":             true,
+		"net.ipv4.ip_unprivileged_port_start":  true,
+		"net.ipv4.ping_group_range":            true,
+		"net.ipv4.tcp_fin_timeout":             true,
+		"net.ipv4.tcp_keepalive_intvl":         true,
+		"net.ipv4.tcp_keepalive_probes":        true,
+		"net.ipv4.tcp_keepalive_time":          true,
+		"net.ipv4.tcp_mtu_probing":             true,
+		"net.ipv4.tcp_orphan_retries":          true,
+		"net.ipv4.tcp_retries1":                true,
+		"net.ipv4.tcp_retries2":                true,
+		"net.ipv4.tcp_syn_retries":             true,
+		"net.ipv4.tcp_synack_retries":          true,
+		"net.ipv4.tcp_syncookies":              true,
+		"net.ipv4.tcp_tw_reuse":                true,
+	}
 )
 
 // NetConf stores the common network config for the CNI plugin
@@ -69,6 +107,125 @@ type NetConf struct {
 
 	// MTU for eth0
 	MTU string `json:"mtu"`
+
+	// AdditionalInterfaces are the names of the interfaces created in the pod in addition to IfName, each with its
+	// own IP address from the warm pool.
+	AdditionalInterfaces []string `json:"additionalInterfaces,omitempty"`
+
+	// NetlinkRetries is how many more times a netlink operation is done when it fails with a transient error, like
+	// EBUSY. The retries are reported to ipamd. It defaults to 0, no retry.
+	NetlinkRetries string `json:"netlinkRetries,omitempty"`
+
+	// DNS is the resolv.conf configuration returned in the result of the pods, if any. It is also sent to ipamd,
+	// which shows it in the /v1/pods introspection endpoint.
+	DNS types.DNS `json:"dns,omitempty"`
+
+	// PodSysctls is the comma separated list of name=value sysctls set in the network namespace of the pods, like
+	// "net.ipv4.conf.eth0.rp_filter=2,net.ipv4.tcp_keepalive_time=600". Each name must be in the allowlist.
+	PodSysctls string `json:"podSysctls,omitempty"`
+}
+
+// podDNS returns the DNS configuration of the network config to send to ipamd, nil if it has none
+func (conf *NetConf) podDNS() *pb.PodDNS {
+	if len(conf.DNS.Nameservers) == 0 && conf.DNS.Domain == "" && len(conf.DNS.Search) == 0 && len(conf.DNS.Options) == 0 {
+		return nil
+	}
+	return &pb.PodDNS{
+		Nameservers: conf.DNS.Nameservers,
+		Domain:      conf.DNS.Domain,
+		Search:      conf.DNS.Search,
+		Options:     conf.DNS.Options,
+	}
+}
+
+// netlinkRetries returns the number of retries of the netlink operations, 0 if it is not set or invalid
+func (conf *NetConf) netlinkRetries() int {
+	if conf.NetlinkRetries == "" {
+		return 0
+	}
+	retries, err := strconv.Atoi(conf.NetlinkRetries)
+	if err != nil || retries < 0 || retries > maxNetlinkRetries {
+		log.Errorf("Invalid netlinkRetries %q, not retrying netlink operations", conf.NetlinkRetries)
+		return 0
+	}
+	return retries
+}
+
+// podSysctls returns the sysctls of the network config to set in the pods, and an error if one is malformed or not
+// in the allowlist
+func (conf *NetConf) podSysctls() ([]driver.Sysctl, error) {
+	var sysctls []driver.Sysctl
+	for _, entry := range strings.Split(conf.PodSysctls, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid pod sysctl %q, expected name=value", entry)
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !podSysctlAllowed(name) {
+			return nil, errors.Errorf("pod sysctl %q is not allowed", name)
+		}
+		sysctls = append(sysctls, driver.Sysctl{Name: name, Value: value})
+	}
+	return sysctls, nil
+}
+
+// podSysctlAllowed returns true if the sysctl is in the allowlist of the sysctls of the pods
+func podSysctlAllowed(name string) bool {
+	if strings.Contains(name, "/") || strings.Contains(name, "..") || strings.HasSuffix(name, ".") {
+		return false
+	}
+	if podSysctls[name] {
+		return true
+	}
+	for _, prefix := range podSysctlPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// pbSysctls converts the sysctls of the pod to send to ipamd
+func pbSysctls(sysctls []driver.Sysctl) []*pb.PodSysctl {
+	var values []*pb.PodSysctl
+	for _, sysctl := range sysctls {
+		values = append(values, &pb.PodSysctl{Name: sysctl.Name, Value: sysctl.Value})
+	}
+	return values
+}
+
+// reportNetlinkRetries sends to ipamd the netlink operations retried by the driver for the command of the pod, if
+// any was
+func reportNetlinkRetries(c pb.CNIBackendClient, driverClient driver.NetworkAPIs, command string, k8sArgs *K8sArgs) {
+	retries := driverClient.NetlinkRetries()
+	if len(retries) == 0 {
+		return
+	}
+	req := &pb.NetlinkRetriesRequest{
+		K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
+		K8S_POD_NAMESPACE:          string(k8sArgs.K8S_POD_NAMESPACE),
+		K8S_POD_INFRA_CONTAINER_ID: string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
+		Command:                    command,
+	}
+	for operation, count := range retries {
+		req.Retries = append(req.Retries, &pb.NetlinkRetry{Operation: operation, Count: int32(count)})
+	}
+	log.Infof("Netlink operations retried for %s of pod %s namespace %s sandbox %s: %v", command,
+		req.K8S_POD_NAME, req.K8S_POD_NAMESPACE, req.K8S_POD_INFRA_CONTAINER_ID, retries)
+	if _, err := c.ReportNetlinkRetries(context.Background(), req); err != nil {
+		log.Warnf("Failed to report the netlink retries to ipamd: %v", err)
+	}
+}
+
+// ipamDTarget returns the gRPC target of ipamd, its Unix socket if it exists
+func ipamDTarget() string {
+	if _, err := os.Stat(ipamDSocket); err == nil {
+		return "unix://" + ipamDSocket
+	}
+	return ipamDAddress
 }
 
 // K8sArgs is the valid CNI_ARGS used for Kubernetes
@@ -86,6 +243,41 @@ type K8sArgs struct {
 
 	// K8S_POD_INFRA_CONTAINER_ID is pod's sandbox id
 	K8S_POD_INFRA_CONTAINER_ID types.UnmarshallableString
+
+	// K8S_POD_UID is pod's UID, it is only passed by some container runtimes
+	K8S_POD_UID types.UnmarshallableString
+
+	// K8S_POD_SUBNET is the subnet the pod's IP address must come from. When it is not passed, ipamd uses the
+	// k8s.amazonaws.com/subnet annotation of the pod.
+	K8S_POD_SUBNET types.UnmarshallableString
+
+	// K8S_POD_USE_RESERVED_IPS lets the pod take one of the RESERVED_IPS of ipamd. When it is not passed, ipamd
+	// checks the priority and labels of the pod.
+	K8S_POD_USE_RESERVED_IPS types.UnmarshallableBool
+
+	// K8S_POD_HOST_NETWORK tells that the pod runs in the host network namespace. The plugin also detects it when
+	// the network namespace of the pod is the one of the host.
+	K8S_POD_HOST_NETWORK types.UnmarshallableBool
+
+	// K8S_POD_EGRESS_ONLY tells that the pod only originates traffic, ipamd drops the connections opened to it. When
+	// it is not passed, ipamd uses the k8s.amazonaws.com/egress-only annotation of the pod.
+	K8S_POD_EGRESS_ONLY types.UnmarshallableBool
+
+	// K8S_POD_IP_FAMILY is the IP family the pod asks for, "ipv4", "ipv6" or "dual". When it is not passed, ipamd uses
+	// the k8s.amazonaws.com/ip-family annotation of the pod.
+	K8S_POD_IP_FAMILY types.UnmarshallableString
+}
+
+// isHostNetns returns true if netns is the network namespace the plugin runs in, the one of the host
+func isHostNetns(netns string) bool {
+	var podNS, hostNS unix.Stat_t
+	if err := unix.Stat(netns, &podNS); err != nil {
+		return false
+	}
+	if err := unix.Stat(hostNetnsPath, &hostNS); err != nil {
+		return false
+	}
+	return podNS.Dev == hostNS.Dev && podNS.Ino == hostNS.Ino
 }
 
 func init() {
@@ -123,6 +315,15 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		return errors.New("conf.VethPrefix can be at most 4 characters long")
 	}
 
+	if err := validateAdditionalInterfaces(args.IfName, conf.AdditionalInterfaces); err != nil {
+		return errors.Wrap(err, "add cmd")
+	}
+
+	sysctls, err := conf.podSysctls()
+	if err != nil {
+		return errors.Wrap(err, "add cmd")
+	}
+
 	// MTU
 	if conf.MTU == "" {
 		log.Debug("MTU not set, defaulting to 9001")
@@ -130,10 +331,15 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 	}
 	mtu := networkutils.GetEthernetMTU(conf.MTU)
 
+	netlinkRetries := conf.netlinkRetries()
+	if netlinkRetries > 0 {
+		driverClient.EnableNetlinkRetry(netlinkRetries, netlinkRetryBackoff)
+	}
+
 	cniVersion := conf.CNIVersion
 
 	// Set up a connection to the ipamD server.
-	conn, err := grpcClient.Dial(ipamDAddress, grpc.WithInsecure())
+	conn, err := grpcClient.Dial(ipamDTarget(), grpc.WithInsecure())
 	if err != nil {
 		log.Errorf("Failed to connect to backend server for pod %s namespace %s sandbox %s: %v",
 			string(k8sArgs.K8S_POD_NAME),
@@ -145,14 +351,31 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 	defer conn.Close()
 
 	c := rpcClient.NewCNIBackendClient(conn)
+	if netlinkRetries > 0 {
+		defer reportNetlinkRetries(c, driverClient, "ADD", &k8sArgs)
+	}
 
+	hostNetwork := bool(k8sArgs.K8S_POD_HOST_NETWORK) || isHostNetns(args.Netns)
+	if hostNetwork {
+		// The sysctls would change the network namespace of the host
+		sysctls = nil
+	}
 	r, err := c.AddNetwork(context.Background(),
 		&pb.AddNetworkRequest{
 			Netns:                      args.Netns,
 			K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
 			K8S_POD_NAMESPACE:          string(k8sArgs.K8S_POD_NAMESPACE),
 			K8S_POD_INFRA_CONTAINER_ID: string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
-			IfName:                     args.IfName})
+			K8S_POD_UID:                string(k8sArgs.K8S_POD_UID),
+			IfName:                     args.IfName,
+			AdditionalIfNames:          conf.AdditionalInterfaces,
+			SubnetHint:                 string(k8sArgs.K8S_POD_SUBNET),
+			UseReservedIPs:             bool(k8sArgs.K8S_POD_USE_RESERVED_IPS),
+			HostNetwork:                hostNetwork,
+			DNS:                        conf.podDNS(),
+			EgressOnly:                 bool(k8sArgs.K8S_POD_EGRESS_ONLY),
+			IPFamily:                   string(k8sArgs.K8S_POD_IP_FAMILY),
+			Sysctls:                    pbSysctls(sysctls)})
 
 	if err != nil {
 		log.Errorf("Error received from AddNetwork grpc call for pod %s namespace %s sandbox %s: %v",
@@ -171,6 +394,13 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		return fmt.Errorf("add cmd: failed to assign an IP address to container")
 	}
 
+	if hostNetwork {
+		// ipamd assigned no IP, the pod uses the network of the host
+		log.Infof("Pod %s namespace %s sandbox %s is in the host network namespace, not setting up its network",
+			string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID))
+		return cniTypes.PrintResult(&current.Result{}, cniVersion)
+	}
+
 	log.Infof("Received add network response for pod %s namespace %s sandbox %s: %s, table %d, external-SNAT: %v, vpcCIDR: %v",
 		string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
 		r.IPv4Addr, r.DeviceNumber, r.UseExternalSNAT, r.VPCcidrs)
@@ -185,6 +415,15 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 	hostVethName := generateHostVethName(conf.VethPrefix, string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
 
 	err = driverClient.SetupNS(hostVethName, args.IfName, args.Netns, addr, int(r.DeviceNumber), r.VPCcidrs, r.UseExternalSNAT, mtu)
+	if err == nil && len(r.StaticRoutes) > 0 {
+		err = setupStaticRoutes(driverClient, addr, r)
+		if err != nil {
+			if teardownErr := driverClient.TeardownNS(addr, int(r.DeviceNumber)); teardownErr != nil {
+				log.Errorf("Failed to tear down the network of pod %s namespace %s sandbox %s: %v", string(k8sArgs.K8S_POD_NAME),
+					string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), teardownErr)
+			}
+		}
+	}
 
 	if err != nil {
 		log.Errorf("Failed SetupPodNetwork for pod %s namespace %s sandbox %s: %v",
@@ -218,18 +457,121 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		},
 	}
 
+	for i, intf := range r.AdditionalInterfaces {
+		intfAddr := &net.IPNet{
+			IP:   net.ParseIP(intf.IPv4Addr),
+			Mask: net.IPv4Mask(255, 255, 255, 255),
+		}
+		intfHostVethName := networkutils.GenerateInterfaceHostVethName(conf.VethPrefix, string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME), intf.IfName)
+		// Inside the container, route table 1 is used for the first additional interface, 2 for the second, etc.
+		err = driverClient.SetupNSInterface(intfHostVethName, intf.IfName, args.Netns, intfAddr, int(intf.DeviceNumber), r.VPCcidrs, r.UseExternalSNAT, mtu, i+1)
+		if err != nil {
+			log.Errorf("Failed SetupPodNetwork for interface %s of pod %s namespace %s sandbox %s: %v", intf.IfName,
+				string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), err)
+			teardownInterfaces(driverClient, append([]*pb.PodInterface{{IfName: args.IfName, IPv4Addr: r.IPv4Addr, DeviceNumber: r.DeviceNumber}},
+				r.AdditionalInterfaces[:i]...))
+
+			// return all the allocated IPs back to IP pool
+			delReply, delErr := c.DelNetwork(context.Background(),
+				&pb.DelNetworkRequest{
+					K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
+					K8S_POD_NAMESPACE:          string(k8sArgs.K8S_POD_NAMESPACE),
+					K8S_POD_INFRA_CONTAINER_ID: string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
+					IPv4Addr:                   r.IPv4Addr,
+					Reason:                     "SetupNSFailed"})
+			if delErr != nil || !delReply.Success {
+				log.Errorf("Failed to release IPs of pod %s namespace %s sandbox %s: %v",
+					string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), delErr)
+			}
+			return errors.Wrapf(err, "add command: failed to setup network for interface %s", intf.IfName)
+		}
+		ips = append(ips, &current.IPConfig{
+			Version: "4",
+			Address: *intfAddr,
+		})
+	}
+
+	if len(sysctls) > 0 {
+		if err = driverClient.SetupPodSysctls(args.Netns, sysctls); err != nil {
+			log.Errorf("Failed to set the sysctls of pod %s namespace %s sandbox %s: %v",
+				string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), err)
+			teardownInterfaces(driverClient, append([]*pb.PodInterface{{IfName: args.IfName, IPv4Addr: r.IPv4Addr, DeviceNumber: r.DeviceNumber}},
+				r.AdditionalInterfaces...))
+
+			// return all the allocated IPs back to IP pool
+			delReply, delErr := c.DelNetwork(context.Background(),
+				&pb.DelNetworkRequest{
+					K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
+					K8S_POD_NAMESPACE:          string(k8sArgs.K8S_POD_NAMESPACE),
+					K8S_POD_INFRA_CONTAINER_ID: string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID),
+					IPv4Addr:                   r.IPv4Addr,
+					Reason:                     "SetupNSFailed"})
+			if delErr != nil || !delReply.Success {
+				log.Errorf("Failed to release IPs of pod %s namespace %s sandbox %s: %v",
+					string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), delErr)
+			}
+			return errors.Wrap(err, "add command: failed to set the sysctls of the pod")
+		}
+	}
+
 	result := &current.Result{
 		IPs: ips,
+		DNS: conf.DNS,
 	}
 
 	return cniTypes.PrintResult(result, cniVersion)
 }
 
+// setupStaticRoutes routes the traffic of the pod to the static routes of its ENIConfig through the table of its ENI
+func setupStaticRoutes(driverClient driver.NetworkAPIs, addr *net.IPNet, r *pb.AddNetworkReply) error {
+	routes := make([]driver.StaticRoute, 0, len(r.StaticRoutes))
+	for _, route := range r.StaticRoutes {
+		_, dst, err := net.ParseCIDR(route.Destination)
+		if err != nil {
+			return errors.Wrapf(err, "invalid static route destination %q", route.Destination)
+		}
+		staticRoute := driver.StaticRoute{Destination: dst}
+		if route.Gateway != "" {
+			if staticRoute.Gateway = net.ParseIP(route.Gateway); staticRoute.Gateway == nil {
+				return errors.Errorf("invalid gateway %q of static route %s", route.Gateway, route.Destination)
+			}
+		}
+		routes = append(routes, staticRoute)
+	}
+	return driverClient.SetupPodStaticRoutes(addr, int(r.DeviceNumber), routes, r.UseExternalSNAT)
+}
+
+// validateAdditionalInterfaces checks that the additional interface names are valid and distinct from ifName
+func validateAdditionalInterfaces(ifName string, additionalInterfaces []string) error {
+	names := map[string]bool{ifName: true}
+	for _, name := range additionalInterfaces {
+		if name == "" || len(name) > maxInterfaceNameLength {
+			return errors.Errorf("invalid additional interface name %q", name)
+		}
+		if names[name] {
+			return errors.Errorf("duplicate interface name %q", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// teardownInterfaces removes the host side networking of the given pod interfaces, ignoring errors
+func teardownInterfaces(driverClient driver.NetworkAPIs, interfaces []*pb.PodInterface) {
+	for _, intf := range interfaces {
+		addr := &net.IPNet{
+			IP:   net.ParseIP(intf.IPv4Addr),
+			Mask: net.IPv4Mask(255, 255, 255, 255),
+		}
+		if err := driverClient.TeardownNS(addr, int(intf.DeviceNumber)); err != nil {
+			log.Warnf("Failed on TeardownPodNetwork for interface %s with IP %s: %v", intf.IfName, intf.IPv4Addr, err)
+		}
+	}
+}
+
 // generateHostVethName returns a name to be used on the host-side veth device.
 func generateHostVethName(prefix, namespace, podname string) string {
-	h := sha1.New()
-	h.Write([]byte(fmt.Sprintf("%s.%s", namespace, podname)))
-	return fmt.Sprintf("%s%s", prefix, hex.EncodeToString(h.Sum(nil))[:11])
+	return networkutils.GenerateHostVethName(prefix, namespace, podname)
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -254,9 +596,14 @@ func del(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		return errors.Wrap(err, "del cmd: failed to load k8s config from args")
 	}
 
+	netlinkRetries := conf.netlinkRetries()
+	if netlinkRetries > 0 {
+		driverClient.EnableNetlinkRetry(netlinkRetries, netlinkRetryBackoff)
+	}
+
 	// notify local IP address manager to free secondary IP
 	// Set up a connection to the server.
-	conn, err := grpcClient.Dial(ipamDAddress, grpc.WithInsecure())
+	conn, err := grpcClient.Dial(ipamDTarget(), grpc.WithInsecure())
 	if err != nil {
 		log.Errorf("Failed to connect to backend server for pod %s namespace %s sandbox %s: %v",
 			string(k8sArgs.K8S_POD_NAME),
@@ -269,6 +616,9 @@ func del(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 	defer conn.Close()
 
 	c := rpcClient.NewCNIBackendClient(conn)
+	if netlinkRetries > 0 {
+		defer reportNetlinkRetries(c, driverClient, "DEL", &k8sArgs)
+	}
 
 	r, err := c.DelNetwork(context.Background(),
 		&pb.DelNetworkRequest{
@@ -314,6 +664,19 @@ func del(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		log.Warnf("Pod %s in namespace %s did not have a valid IP %s", string(k8sArgs.K8S_POD_NAME),
 			string(k8sArgs.K8S_POD_NAMESPACE), r.IPv4Addr)
 	}
+
+	for _, intf := range r.AdditionalInterfaces {
+		addr := &net.IPNet{
+			IP:   net.ParseIP(intf.IPv4Addr),
+			Mask: net.IPv4Mask(255, 255, 255, 255),
+		}
+		err = driverClient.TeardownNS(addr, int(intf.DeviceNumber))
+		if err != nil {
+			log.Errorf("Failed on TeardownPodNetwork for interface %s of pod %s namespace %s sandbox %s: %v", intf.IfName,
+				string(k8sArgs.K8S_POD_NAME), string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_INFRA_CONTAINER_ID), err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -20,10 +20,13 @@ import (
 	"testing"
 
 	"github.com/containernetworking/cni/pkg/skel"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
 
+	"github.com/aws/amazon-vpc-cni-k8s/cmd/routed-eni-cni-plugin/driver"
 	mock_driver "github.com/aws/amazon-vpc-cni-k8s/cmd/routed-eni-cni-plugin/driver/mocks"
 	mock_grpcwrapper "github.com/aws/amazon-vpc-cni-k8s/pkg/grpcwrapper/mocks"
 	mock_rpcwrapper "github.com/aws/amazon-vpc-cni-k8s/pkg/rpcwrapper/mocks"
@@ -101,6 +104,117 @@ func TestCmdAdd(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestCmdAddHostNetwork(t *testing.T) {
+	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
+	defer ctrl.Finish()
+
+	netconf := &NetConf{CNIVersion: cniVersion,
+		Name: cniName,
+		Type: cniType}
+	stdinData, _ := json.Marshal(netconf)
+
+	// The network namespace of the test is the one of the host
+	cmdArgs := &skel.CmdArgs{ContainerID: containerID,
+		Netns:     hostNetnsPath,
+		IfName:    ifName,
+		StdinData: stdinData}
+
+	mocksTypes.EXPECT().LoadArgs(gomock.Any(), gomock.Any()).Return(nil)
+
+	conn, _ := grpc.Dial(ipamDAddress, grpc.WithInsecure())
+
+	mocksGRPC.EXPECT().Dial(gomock.Any(), gomock.Any()).Return(conn, nil)
+	mockC := mock_rpc.NewMockCNIBackendClient(ctrl)
+	mocksRPC.EXPECT().NewCNIBackendClient(conn).Return(mockC)
+
+	mockC.EXPECT().AddNetwork(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ interface{}, in *rpc.AddNetworkRequest, _ ...interface{}) (*rpc.AddNetworkReply, error) {
+			assert.True(t, in.HostNetwork)
+			return &rpc.AddNetworkReply{Success: true}, nil
+		})
+
+	// The network of the pod is not set up
+	mocksNetwork.EXPECT().SetupNS(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(),
+		gomock.Any(), gomock.Any()).Times(0)
+
+	mocksTypes.EXPECT().PrintResult(gomock.Any(), gomock.Any()).Return(nil)
+
+	err := add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Nil(t, err)
+}
+
+func TestCmdAddAdditionalInterfaces(t *testing.T) {
+	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
+	defer ctrl.Finish()
+
+	netconf := &NetConf{CNIVersion: cniVersion,
+		Name:                 cniName,
+		Type:                 cniType,
+		AdditionalInterfaces: []string{"eth1"}}
+	stdinData, _ := json.Marshal(netconf)
+
+	cmdArgs := &skel.CmdArgs{ContainerID: containerID,
+		Netns:     netNS,
+		IfName:    ifName,
+		StdinData: stdinData}
+
+	mocksTypes.EXPECT().LoadArgs(gomock.Any(), gomock.Any()).Return(nil)
+
+	conn, _ := grpc.Dial(ipamDAddress, grpc.WithInsecure())
+
+	mocksGRPC.EXPECT().Dial(gomock.Any(), gomock.Any()).Return(conn, nil)
+	mockC := mock_rpc.NewMockCNIBackendClient(ctrl)
+	mocksRPC.EXPECT().NewCNIBackendClient(conn).Return(mockC)
+
+	addNetworkReply := &rpc.AddNetworkReply{Success: true, IPv4Addr: ipAddr, DeviceNumber: devNum,
+		AdditionalInterfaces: []*rpc.PodInterface{{IfName: "eth1", IPv4Addr: "10.0.1.16", DeviceNumber: 2}}}
+	mockC.EXPECT().AddNetwork(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ interface{}, in *rpc.AddNetworkRequest, _ ...interface{}) (*rpc.AddNetworkReply, error) {
+			assert.Equal(t, []string{"eth1"}, in.AdditionalIfNames)
+			return addNetworkReply, nil
+		})
+
+	addr := &net.IPNet{
+		IP:   net.ParseIP(addNetworkReply.IPv4Addr),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+	eth1Addr := &net.IPNet{
+		IP:   net.ParseIP("10.0.1.16"),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+
+	mocksNetwork.EXPECT().SetupNS(gomock.Any(), cmdArgs.IfName, cmdArgs.Netns,
+		addr, int(addNetworkReply.DeviceNumber), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mocksNetwork.EXPECT().SetupNSInterface(gomock.Any(), "eth1", cmdArgs.Netns,
+		eth1Addr, 2, gomock.Any(), gomock.Any(), gomock.Any(), 1).Return(nil)
+
+	mocksTypes.EXPECT().PrintResult(gomock.Any(), gomock.Any()).Return(nil)
+
+	err := add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Nil(t, err)
+}
+
+func TestCmdAddInvalidAdditionalInterfaces(t *testing.T) {
+	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
+	defer ctrl.Finish()
+
+	netconf := &NetConf{CNIVersion: cniVersion,
+		Name:                 cniName,
+		Type:                 cniType,
+		AdditionalInterfaces: []string{ifName}}
+	stdinData, _ := json.Marshal(netconf)
+
+	cmdArgs := &skel.CmdArgs{ContainerID: containerID,
+		Netns:     netNS,
+		IfName:    ifName,
+		StdinData: stdinData}
+
+	mocksTypes.EXPECT().LoadArgs(gomock.Any(), gomock.Any()).Return(nil)
+
+	err := add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Error(t, err)
+}
+
 func TestCmdAddNetworkErr(t *testing.T) {
 	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
 	defer ctrl.Finish()
@@ -173,6 +287,62 @@ func TestCmdAddErrSetupPodNetwork(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestCmdAddStaticRoutes(t *testing.T) {
+	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
+	defer ctrl.Finish()
+
+	netconf := &NetConf{CNIVersion: cniVersion,
+		Name: cniName,
+		Type: cniType}
+	stdinData, _ := json.Marshal(netconf)
+
+	cmdArgs := &skel.CmdArgs{ContainerID: containerID,
+		Netns:     netNS,
+		IfName:    ifName,
+		StdinData: stdinData}
+
+	mocksTypes.EXPECT().LoadArgs(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	conn, _ := grpc.Dial(ipamDAddress, grpc.WithInsecure())
+
+	mocksGRPC.EXPECT().Dial(gomock.Any(), gomock.Any()).Return(conn, nil).Times(2)
+	mockC := mock_rpc.NewMockCNIBackendClient(ctrl)
+	mocksRPC.EXPECT().NewCNIBackendClient(conn).Return(mockC).Times(2)
+
+	addNetworkReply := &rpc.AddNetworkReply{Success: true, IPv4Addr: ipAddr, DeviceNumber: devNum,
+		StaticRoutes: []*rpc.StaticRoute{{Destination: "172.16.0.0/16"}, {Destination: "192.168.100.0/24", Gateway: "10.0.0.5"}}}
+	mockC.EXPECT().AddNetwork(gomock.Any(), gomock.Any()).Return(addNetworkReply, nil).Times(2)
+
+	addr := &net.IPNet{
+		IP:   net.ParseIP(addNetworkReply.IPv4Addr),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+	_, onPrem, _ := net.ParseCIDR("172.16.0.0/16")
+	_, viaGateway, _ := net.ParseCIDR("192.168.100.0/24")
+	routes := []driver.StaticRoute{
+		{Destination: onPrem},
+		{Destination: viaGateway, Gateway: net.ParseIP("10.0.0.5")},
+	}
+
+	mocksNetwork.EXPECT().SetupNS(gomock.Any(), cmdArgs.IfName, cmdArgs.Netns,
+		addr, int(addNetworkReply.DeviceNumber), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	mocksNetwork.EXPECT().SetupPodStaticRoutes(addr, int(addNetworkReply.DeviceNumber), routes, false).Return(nil)
+	mocksTypes.EXPECT().PrintResult(gomock.Any(), gomock.Any()).Return(nil)
+
+	err := add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Nil(t, err)
+
+	// When the routes cannot be set up, the network of the pod is torn down and its IP returned
+	mocksNetwork.EXPECT().SetupPodStaticRoutes(addr, int(addNetworkReply.DeviceNumber), routes, false).
+		Return(errors.New("error on SetupPodStaticRoutes"))
+	mocksNetwork.EXPECT().TeardownNS(addr, int(addNetworkReply.DeviceNumber)).Return(nil)
+	delNetworkReply := &rpc.DelNetworkReply{Success: true, IPv4Addr: ipAddr, DeviceNumber: devNum}
+	mockC.EXPECT().DelNetwork(gomock.Any(), gomock.Any()).Return(delNetworkReply, nil)
+
+	err = add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Error(t, err)
+}
+
 func TestCmdDel(t *testing.T) {
 	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
 	defer ctrl.Finish()
@@ -210,6 +380,49 @@ func TestCmdDel(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestCmdDelAdditionalInterfaces(t *testing.T) {
+	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
+	defer ctrl.Finish()
+
+	netconf := &NetConf{CNIVersion: cniVersion,
+		Name: cniName,
+		Type: cniType}
+	stdinData, _ := json.Marshal(netconf)
+
+	cmdArgs := &skel.CmdArgs{ContainerID: containerID,
+		Netns:     netNS,
+		IfName:    ifName,
+		StdinData: stdinData}
+
+	mocksTypes.EXPECT().LoadArgs(gomock.Any(), gomock.Any()).Return(nil)
+
+	conn, _ := grpc.Dial(ipamDAddress, grpc.WithInsecure())
+
+	mocksGRPC.EXPECT().Dial(gomock.Any(), gomock.Any()).Return(conn, nil)
+	mockC := mock_rpc.NewMockCNIBackendClient(ctrl)
+	mocksRPC.EXPECT().NewCNIBackendClient(conn).Return(mockC)
+
+	delNetworkReply := &rpc.DelNetworkReply{Success: true, IPv4Addr: ipAddr, DeviceNumber: devNum,
+		AdditionalInterfaces: []*rpc.PodInterface{{IfName: "eth1", IPv4Addr: "10.0.1.16", DeviceNumber: 2}}}
+
+	mockC.EXPECT().DelNetwork(gomock.Any(), gomock.Any()).Return(delNetworkReply, nil)
+
+	addr := &net.IPNet{
+		IP:   net.ParseIP(delNetworkReply.IPv4Addr),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+	eth1Addr := &net.IPNet{
+		IP:   net.ParseIP("10.0.1.16"),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+
+	mocksNetwork.EXPECT().TeardownNS(addr, int(delNetworkReply.DeviceNumber)).Return(nil)
+	mocksNetwork.EXPECT().TeardownNS(eth1Addr, 2).Return(nil)
+
+	err := del(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Nil(t, err)
+}
+
 func TestCmdDelErrDelNetwork(t *testing.T) {
 	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
 	defer ctrl.Finish()
@@ -276,3 +489,163 @@ func TestCmdDelErrTeardown(t *testing.T) {
 	err := del(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
 	assert.Error(t, err)
 }
+
+func TestCmdDelNetlinkRetries(t *testing.T) {
+	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
+	defer ctrl.Finish()
+
+	netconf := &NetConf{CNIVersion: cniVersion,
+		Name:           cniName,
+		Type:           cniType,
+		NetlinkRetries: "3"}
+	stdinData, _ := json.Marshal(netconf)
+
+	cmdArgs := &skel.CmdArgs{ContainerID: containerID,
+		Netns:     netNS,
+		IfName:    ifName,
+		StdinData: stdinData}
+
+	mocksTypes.EXPECT().LoadArgs(gomock.Any(), gomock.Any()).Return(nil)
+	mocksNetwork.EXPECT().EnableNetlinkRetry(3, netlinkRetryBackoff)
+
+	conn, _ := grpc.Dial(ipamDAddress, grpc.WithInsecure())
+
+	mocksGRPC.EXPECT().Dial(gomock.Any(), gomock.Any()).Return(conn, nil)
+	mockC := mock_rpc.NewMockCNIBackendClient(ctrl)
+	mocksRPC.EXPECT().NewCNIBackendClient(conn).Return(mockC)
+
+	delNetworkReply := &rpc.DelNetworkReply{Success: true, IPv4Addr: ipAddr, DeviceNumber: devNum}
+	mockC.EXPECT().DelNetwork(gomock.Any(), gomock.Any()).Return(delNetworkReply, nil)
+	mocksNetwork.EXPECT().TeardownNS(gomock.Any(), int(delNetworkReply.DeviceNumber)).Return(nil)
+
+	// The retries of the driver are reported to ipamd
+	mocksNetwork.EXPECT().NetlinkRetries().Return(map[string]int{"RuleDel": 2})
+	mockC.EXPECT().ReportNetlinkRetries(gomock.Any(), &rpc.NetlinkRetriesRequest{
+		Command: "DEL",
+		Retries: []*rpc.NetlinkRetry{{Operation: "RuleDel", Count: 2}},
+	}).Return(&rpc.NetlinkRetriesReply{Success: true}, nil)
+
+	err := del(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Nil(t, err)
+
+	// An invalid value does not retry
+	invalid := &NetConf{NetlinkRetries: "100"}
+	assert.Equal(t, 0, invalid.netlinkRetries())
+}
+
+func TestCmdAddDNS(t *testing.T) {
+	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
+	defer ctrl.Finish()
+
+	dns := cniTypes.DNS{Nameservers: []string{"10.100.0.10"}, Search: []string{"default.svc.cluster.local"}}
+	netconf := &NetConf{CNIVersion: cniVersion,
+		Name: cniName,
+		Type: cniType,
+		DNS:  dns}
+	stdinData, _ := json.Marshal(netconf)
+
+	cmdArgs := &skel.CmdArgs{ContainerID: containerID,
+		Netns:     netNS,
+		IfName:    ifName,
+		StdinData: stdinData}
+
+	mocksTypes.EXPECT().LoadArgs(gomock.Any(), gomock.Any()).Return(nil)
+
+	conn, _ := grpc.Dial(ipamDAddress, grpc.WithInsecure())
+
+	mocksGRPC.EXPECT().Dial(gomock.Any(), gomock.Any()).Return(conn, nil)
+	mockC := mock_rpc.NewMockCNIBackendClient(ctrl)
+	mocksRPC.EXPECT().NewCNIBackendClient(conn).Return(mockC)
+
+	addNetworkReply := &rpc.AddNetworkReply{Success: true, IPv4Addr: ipAddr, DeviceNumber: devNum}
+	mockC.EXPECT().AddNetwork(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ interface{}, in *rpc.AddNetworkRequest, _ ...interface{}) (*rpc.AddNetworkReply, error) {
+			assert.Equal(t, &rpc.PodDNS{Nameservers: dns.Nameservers, Search: dns.Search}, in.DNS)
+			return addNetworkReply, nil
+		})
+
+	mocksNetwork.EXPECT().SetupNS(gomock.Any(), cmdArgs.IfName, cmdArgs.Netns,
+		gomock.Any(), int(addNetworkReply.DeviceNumber), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+	mocksTypes.EXPECT().PrintResult(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(result cniTypes.Result, _ string) error {
+			assert.Equal(t, dns, result.(*current.Result).DNS)
+			return nil
+		})
+
+	err := add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Nil(t, err)
+}
+
+func TestCmdAddSysctls(t *testing.T) {
+	ctrl, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork := setup(t)
+	defer ctrl.Finish()
+
+	netconf := &NetConf{CNIVersion: cniVersion,
+		Name:       cniName,
+		Type:       cniType,
+		PodSysctls: "net.ipv4.conf.eth0.rp_filter=2, net.ipv4.ip_local_port_range=1024 65000"}
+	stdinData, _ := json.Marshal(netconf)
+
+	cmdArgs := &skel.CmdArgs{ContainerID: containerID,
+		Netns:     netNS,
+		IfName:    ifName,
+		StdinData: stdinData}
+	sysctls := []driver.Sysctl{
+		{Name: "net.ipv4.conf.eth0.rp_filter", Value: "2"},
+		{Name: "net.ipv4.ip_local_port_range", Value: "1024 65000"},
+	}
+
+	mocksTypes.EXPECT().LoadArgs(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	conn, _ := grpc.Dial(ipamDAddress, grpc.WithInsecure())
+
+	mocksGRPC.EXPECT().Dial(gomock.Any(), gomock.Any()).Return(conn, nil).Times(2)
+	mockC := mock_rpc.NewMockCNIBackendClient(ctrl)
+	mocksRPC.EXPECT().NewCNIBackendClient(conn).Return(mockC).Times(2)
+
+	addNetworkReply := &rpc.AddNetworkReply{Success: true, IPv4Addr: ipAddr, DeviceNumber: devNum}
+	mockC.EXPECT().AddNetwork(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ interface{}, in *rpc.AddNetworkRequest, _ ...interface{}) (*rpc.AddNetworkReply, error) {
+			assert.Equal(t, []*rpc.PodSysctl{
+				{Name: "net.ipv4.conf.eth0.rp_filter", Value: "2"},
+				{Name: "net.ipv4.ip_local_port_range", Value: "1024 65000"},
+			}, in.Sysctls)
+			return addNetworkReply, nil
+		}).Times(2)
+
+	mocksNetwork.EXPECT().SetupNS(gomock.Any(), cmdArgs.IfName, cmdArgs.Netns,
+		gomock.Any(), int(addNetworkReply.DeviceNumber), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	mocksNetwork.EXPECT().SetupPodSysctls(netNS, sysctls).Return(nil)
+	mocksTypes.EXPECT().PrintResult(gomock.Any(), gomock.Any()).Return(nil)
+
+	err := add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Nil(t, err)
+
+	// When the sysctls cannot be set, the network of the pod is torn down and its IP released
+	mocksNetwork.EXPECT().SetupPodSysctls(netNS, sysctls).Return(errors.New("read-only file system"))
+	mocksNetwork.EXPECT().TeardownNS(gomock.Any(), devNum).Return(nil)
+	delNetworkReply := &rpc.DelNetworkReply{Success: true, IPv4Addr: ipAddr, DeviceNumber: devNum}
+	mockC.EXPECT().DelNetwork(gomock.Any(), gomock.Any()).Return(delNetworkReply, nil)
+
+	err = add(cmdArgs, mocksTypes, mocksGRPC, mocksRPC, mocksNetwork)
+	assert.Error(t, err)
+}
+
+func TestPodSysctls(t *testing.T) {
+	conf := &NetConf{PodSysctls: "net.ipv4.tcp_keepalive_time=600,,net.core.somaxconn=1024"}
+	sysctls, err := conf.podSysctls()
+	assert.NoError(t, err)
+	assert.Equal(t, []driver.Sysctl{
+		{Name: "net.ipv4.tcp_keepalive_time", Value: "600"},
+		{Name: "net.core.somaxconn", Value: "1024"},
+	}, sysctls)
+
+	for _, invalid := range []string{"net.ipv4.ip_forward=1", "kernel.shmmax=1", "net.ipv4.conf.eth0.rp_filter",
+		"net.ipv4.conf.../../rp_filter=1", "net.ipv4.conf/eth0=1", "net.ipv4.tcp_mem=1 2 3",
+		"net.ipv4.tcp_allowed_congestion_control=cubic"} {
+		conf = &NetConf{PodSysctls: invalid}
+		_, err = conf.podSysctls()
+		assert.Error(t, err, invalid)
+	}
+}
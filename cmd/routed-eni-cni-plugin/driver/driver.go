@@ -14,8 +14,11 @@
 package driver
 
 import (
+	"io/ioutil"
 	"net"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
@@ -43,12 +46,31 @@ const (
 // NetworkAPIs defines network API calls
 type NetworkAPIs interface {
 	SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, table int, vpcCIDRs []string, useExternalSNAT bool, mtu int) error
+	SetupNSInterface(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, table int, vpcCIDRs []string, useExternalSNAT bool, mtu int, contRouteTable int) error
 	TeardownNS(addr *net.IPNet, table int) error
+	SetupPodStaticRoutes(addr *net.IPNet, table int, routes []StaticRoute, useExternalSNAT bool) error
+	SetupPodSysctls(netnsPath string, sysctls []Sysctl) error
+	EnableNetlinkRetry(attempts int, backoff time.Duration)
+	NetlinkRetries() map[string]int
+}
+
+// StaticRoute is a destination routed through the ENI of a pod, via Gateway when it is set
+type StaticRoute struct {
+	Destination *net.IPNet
+	Gateway     net.IP
+}
+
+// Sysctl is a sysctl set in the network namespace of a pod, Name in dotted form like net.ipv4.tcp_keepalive_time
+type Sysctl struct {
+	Name  string
+	Value string
 }
 
 type linuxNetwork struct {
 	netLink netlinkwrapper.NetLink
 	ns      nswrapper.NS
+	// retryNetLink is the netLink that retries transient failures, nil if they are not retried
+	retryNetLink *retryNetLink
 }
 
 // New creates linuxNetwork object
@@ -68,16 +90,21 @@ type createVethPairContext struct {
 	netLink      netlinkwrapper.NetLink
 	ip           ipwrapper.IP
 	mtu          int
+	// contRouteTable is the route table used for the interface inside the container, 0 for the main table with the
+	// container's default route. Additional interfaces use their own table, so that only traffic from their IP uses them.
+	contRouteTable int
 }
 
-func newCreateVethPairContext(contVethName string, hostVethName string, addr *net.IPNet, mtu int) *createVethPairContext {
+func newCreateVethPairContext(contVethName string, hostVethName string, addr *net.IPNet, mtu int, contRouteTable int,
+	netLink netlinkwrapper.NetLink) *createVethPairContext {
 	return &createVethPairContext{
-		contVethName: contVethName,
-		hostVethName: hostVethName,
-		addr:         addr,
-		netLink:      netlinkwrapper.NewNetLink(),
-		ip:           ipwrapper.NewIP(),
-		mtu:          mtu,
+		contVethName:   contVethName,
+		hostVethName:   hostVethName,
+		addr:           addr,
+		netLink:        netLink,
+		ip:             ipwrapper.NewIP(),
+		mtu:            mtu,
+		contRouteTable: contRouteTable,
 	}
 }
 
@@ -128,20 +155,41 @@ func (createVethContext *createVethPairContext) run(hostNS ns.NetNS) error {
 	if err = createVethContext.netLink.RouteReplace(&netlink.Route{
 		LinkIndex: contVeth.Attrs().Index,
 		Scope:     netlink.SCOPE_LINK,
-		Dst:       gwNet}); err != nil {
+		Dst:       gwNet,
+		Table:     createVethContext.contRouteTable}); err != nil {
 		return errors.Wrap(err, "setup NS network: failed to add default gateway")
 	}
 
-	// Add a default route via dummy next hop(169.254.1.1). Then all outgoing traffic will be routed by this
-	// default route via dummy next hop (169.254.1.1).
-	if err = createVethContext.ip.AddDefaultRoute(gwNet.IP, contVeth); err != nil {
-		return errors.Wrap(err, "setup NS network: failed to add default route")
+	if createVethContext.contRouteTable == 0 {
+		// Add a default route via dummy next hop(169.254.1.1). Then all outgoing traffic will be routed by this
+		// default route via dummy next hop (169.254.1.1).
+		if err = createVethContext.ip.AddDefaultRoute(gwNet.IP, contVeth); err != nil {
+			return errors.Wrap(err, "setup NS network: failed to add default route")
+		}
+	} else {
+		// # ip route show table <contRouteTable>
+		// default via 169.254.1.1 dev eth1
+		// 169.254.1.1 dev eth1
+		if err = createVethContext.netLink.RouteReplace(&netlink.Route{
+			LinkIndex: contVeth.Attrs().Index,
+			Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+			Gw:        gwNet.IP,
+			Table:     createVethContext.contRouteTable}); err != nil {
+			return errors.Wrapf(err, "setup NS network: failed to add default route to table %d", createVethContext.contRouteTable)
+		}
 	}
 
 	if err = createVethContext.netLink.AddrAdd(contVeth, &netlink.Addr{IPNet: createVethContext.addr}); err != nil {
 		return errors.Wrapf(err, "setup NS network: failed to add IP addr to %q", createVethContext.contVethName)
 	}
 
+	if createVethContext.contRouteTable > 0 {
+		// Example: 1536:	from 10.200.202.223 lookup 1
+		if err = addContainerRule(createVethContext.netLink, false, createVethContext.addr, createVethContext.contRouteTable); err != nil {
+			return errors.Wrapf(err, "setup NS network: failed to add rule for %q", createVethContext.contVethName)
+		}
+	}
+
 	// add static ARP entry for default gateway
 	// we are using routed mode on the host and container need this static ARP entry to resolve its default gateway.
 	neigh := &netlink.Neigh{
@@ -163,14 +211,48 @@ func (createVethContext *createVethPairContext) run(hostNS ns.NetNS) error {
 	return nil
 }
 
+// EnableNetlinkRetry retries the netlink operations that fail with a transient error, like EBUSY, up to attempts
+// times, waiting backoff before the first retry and twice as long before each next one
+func (os *linuxNetwork) EnableNetlinkRetry(attempts int, backoff time.Duration) {
+	if os.retryNetLink == nil {
+		os.retryNetLink = newRetryNetLink(os.netLink, attempts, backoff)
+		os.netLink = os.retryNetLink
+	}
+	os.retryNetLink.attempts, os.retryNetLink.backoff = attempts, backoff
+}
+
+// NetlinkRetries returns how many times each netlink operation was retried, nil if they are not retried
+func (os *linuxNetwork) NetlinkRetries() map[string]int {
+	if os.retryNetLink == nil {
+		return nil
+	}
+	return os.retryNetLink.retries
+}
+
 // SetupNS wires up linux networking for a pod's network
 func (os *linuxNetwork) SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, table int, vpcCIDRs []string, useExternalSNAT bool, mtu int) error {
 	log.Debugf("SetupNS: hostVethName=%s, contVethName=%s, netnsPath=%s, table=%d, mtu=%d", hostVethName, contVethName, netnsPath, table, mtu)
 	return setupNS(hostVethName, contVethName, netnsPath, addr, table, vpcCIDRs, useExternalSNAT, os.netLink, os.ns, mtu)
 }
 
+// SetupNSInterface wires up linux networking for an additional interface of a pod. Inside the container, the
+// interface routes only the traffic from its own IP, using route table contRouteTable.
+func (os *linuxNetwork) SetupNSInterface(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, table int, vpcCIDRs []string, useExternalSNAT bool, mtu int, contRouteTable int) error {
+	log.Debugf("SetupNSInterface: hostVethName=%s, contVethName=%s, netnsPath=%s, table=%d, mtu=%d, contRouteTable=%d",
+		hostVethName, contVethName, netnsPath, table, mtu, contRouteTable)
+	if contRouteTable <= 0 {
+		return errors.Errorf("setupNS network: invalid route table %d for additional interface %q", contRouteTable, contVethName)
+	}
+	return setupNSInterface(hostVethName, contVethName, netnsPath, addr, table, vpcCIDRs, useExternalSNAT, os.netLink, os.ns, mtu, contRouteTable)
+}
+
 func setupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, table int, vpcCIDRs []string, useExternalSNAT bool,
 	netLink netlinkwrapper.NetLink, ns nswrapper.NS, mtu int) error {
+	return setupNSInterface(hostVethName, contVethName, netnsPath, addr, table, vpcCIDRs, useExternalSNAT, netLink, ns, mtu, 0)
+}
+
+func setupNSInterface(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, table int, vpcCIDRs []string,
+	useExternalSNAT bool, netLink netlinkwrapper.NetLink, ns nswrapper.NS, mtu int, contRouteTable int) error {
 	// Clean up if hostVeth exists.
 	if oldHostVeth, err := netLink.LinkByName(hostVethName); err == nil {
 		if err = netLink.LinkDel(oldHostVeth); err != nil {
@@ -179,7 +261,7 @@ func setupNS(hostVethName string, contVethName string, netnsPath string, addr *n
 		log.Debugf("Clean up old hostVeth: %v\n", hostVethName)
 	}
 
-	createVethContext := newCreateVethPairContext(contVethName, hostVethName, addr, mtu)
+	createVethContext := newCreateVethPairContext(contVethName, hostVethName, addr, mtu, contRouteTable, netLink)
 	if err := ns.WithNetNSPath(netnsPath, createVethContext.run); err != nil {
 		log.Errorf("Failed to setup NS network %v", err)
 		return errors.Wrap(err, "setupNS network: failed to setup NS network")
@@ -290,6 +372,98 @@ func addContainerRule(netLink netlinkwrapper.NetLink, isToContainer bool, addr *
 	return nil
 }
 
+// SetupPodStaticRoutes routes the traffic from the pod to the destinations of the static routes through route table
+// table, the one of its ENI. A route with a gateway is added to that table, on the interface of its default route,
+// and is shared by the pods of the ENI. The rules are removed with the other rules of the pod by TeardownNS.
+func (os *linuxNetwork) SetupPodStaticRoutes(addr *net.IPNet, table int, routes []StaticRoute, useExternalSNAT bool) error {
+	log.Debugf("SetupPodStaticRoutes: addr %s, table %d, routes %v, useExternalSNAT %v", addr.String(), table, routes, useExternalSNAT)
+	return setupPodStaticRoutes(addr, table, routes, useExternalSNAT, os.netLink)
+}
+
+func setupPodStaticRoutes(addr *net.IPNet, table int, routes []StaticRoute, useExternalSNAT bool, netLink netlinkwrapper.NetLink) error {
+	if table <= 0 {
+		return errors.Errorf("setup static routes: invalid route table %d", table)
+	}
+	linkIndex := -1
+	for _, route := range routes {
+		if route.Gateway != nil {
+			if linkIndex < 0 {
+				var err error
+				if linkIndex, err = defaultRouteLinkIndex(netLink, table); err != nil {
+					return errors.Wrap(err, "setup static routes")
+				}
+			}
+			// Example: 10.100.0.0/16 via 100.64.0.10 dev eth1 table 2 onlink
+			if err := netLink.RouteReplace(&netlink.Route{
+				LinkIndex: linkIndex,
+				Dst:       route.Destination,
+				Gw:        route.Gateway,
+				Table:     table,
+				Flags:     int(netlink.FLAG_ONLINK)}); err != nil {
+				return errors.Wrapf(err, "setup static routes: failed to add route to %s via %s in table %d",
+					route.Destination.String(), route.Gateway.String(), table)
+			}
+		}
+		// With external SNAT, all the traffic from the pod already uses the table of its ENI
+		if useExternalSNAT {
+			continue
+		}
+		// Example: 1536:	from 10.200.202.222 to 10.100.0.0/16 lookup 2
+		podRule := netLink.NewRule()
+		podRule.Src = addr
+		podRule.Dst = route.Destination
+		podRule.Table = table
+		podRule.Priority = fromContainerRulePriority
+		if err := netLink.RuleAdd(podRule); err != nil && !isRuleExistsError(err) {
+			return errors.Wrapf(err, "setup static routes: failed to add pod rule [%v]", podRule)
+		}
+		log.Infof("Added static route rule from %s to %s table %d", addr.String(), route.Destination.String(), table)
+	}
+	return nil
+}
+
+// defaultRouteLinkIndex returns the interface of the default route of route table table
+func defaultRouteLinkIndex(netLink netlinkwrapper.NetLink, table int) (int, error) {
+	routes, err := netLink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to list the routes of table %d", table)
+	}
+	for _, route := range routes {
+		if route.Dst == nil || route.Dst.IP.IsUnspecified() {
+			return route.LinkIndex, nil
+		}
+	}
+	return 0, errors.Errorf("no default route in table %d", table)
+}
+
+// SetupPodSysctls sets the sysctls, in order, in the network namespace of the pod. The names must have been checked
+// against the allowlist of the CNI plugin.
+func (os *linuxNetwork) SetupPodSysctls(netnsPath string, sysctls []Sysctl) error {
+	log.Debugf("SetupPodSysctls: netns %s, sysctls %v", netnsPath, sysctls)
+	return setupPodSysctls(netnsPath, sysctls, os.ns, writeProcSys)
+}
+
+func setupPodSysctls(netnsPath string, sysctls []Sysctl, netns nswrapper.NS,
+	writeProcSys func(path string, value string) error) error {
+	return netns.WithNetNSPath(netnsPath, func(ns.NetNS) error {
+		for _, sysctl := range sysctls {
+			if err := writeProcSys(sysctlPath(sysctl.Name), sysctl.Value); err != nil {
+				return errors.Wrapf(err, "setup NS network: failed to set sysctl %s to %q", sysctl.Name, sysctl.Value)
+			}
+		}
+		return nil
+	})
+}
+
+// sysctlPath returns the file of the sysctl under /proc/sys
+func sysctlPath(name string) string {
+	return "/proc/sys/" + strings.Replace(name, ".", "/", -1)
+}
+
+func writeProcSys(path string, value string) error {
+	return ioutil.WriteFile(path, []byte(value), 0644)
+}
+
 // TeardownPodNetwork cleanup ip rules
 func (os *linuxNetwork) TeardownNS(addr *net.IPNet, table int) error {
 	log.Debugf("TeardownNS: addr %s, table %d", addr.String(), table)
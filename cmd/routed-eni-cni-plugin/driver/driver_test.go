@@ -16,11 +16,14 @@ package driver
 import (
 	"errors"
 	"net"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/vishvananda/netlink"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/cninswrapper/mock_ns"
@@ -116,6 +119,82 @@ func TestRun(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRunAdditionalInterface(t *testing.T) {
+	ctrl, mockNetLink, mockIP, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &createVethPairContext{
+		contVethName: testContVethName,
+		hostVethName: testHostVethName,
+		netLink:      mockNetLink,
+		ip:           mockIP,
+		addr: &net.IPNet{
+			IP:   net.ParseIP(testIP),
+			Mask: net.IPv4Mask(255, 255, 255, 255),
+		},
+		contRouteTable: 1,
+	}
+
+	hwAddr, err := net.ParseMAC(testMAC)
+	assert.NoError(t, err)
+
+	mockLinkAttrs := &netlink.LinkAttrs{
+		HardwareAddr: hwAddr,
+	}
+	testRule := &netlink.Rule{
+		SuppressIfgroup:   -1,
+		SuppressPrefixlen: -1,
+		Priority:          -1,
+		Mark:              -1,
+		Mask:              -1,
+		Goto:              -1,
+		Flow:              -1,
+	}
+	mockHostVeth := mock_netlink.NewMockLink(ctrl)
+	mockContVeth := mock_netlink.NewMockLink(ctrl)
+	mockNS := mock_ns.NewMockNetNS(ctrl)
+	gomock.InOrder(
+		// veth pair
+		mockNetLink.EXPECT().LinkAdd(gomock.Any()).Return(nil),
+		//hostVeth
+		mockNetLink.EXPECT().LinkByName(gomock.Any()).Return(mockHostVeth, nil),
+		//host side setup
+		mockNetLink.EXPECT().LinkSetUp(mockHostVeth).Return(nil),
+		//container side
+		mockNetLink.EXPECT().LinkByName(gomock.Any()).Return(mockContVeth, nil),
+		// container setup
+		mockNetLink.EXPECT().LinkSetUp(mockContVeth).Return(nil),
+		// container
+		mockContVeth.EXPECT().Attrs().Return(mockLinkAttrs),
+		mockNetLink.EXPECT().RouteReplace(gomock.Any()).Return(nil),
+		// default route in the interface's own table, not in the main table
+		mockContVeth.EXPECT().Attrs().Return(mockLinkAttrs),
+		mockNetLink.EXPECT().RouteReplace(gomock.Any()).Return(nil),
+
+		// container addr
+		mockNetLink.EXPECT().AddrAdd(mockContVeth, gomock.Any()).Return(nil),
+
+		// from the interface's IP rule
+		mockNetLink.EXPECT().NewRule().Return(testRule),
+		mockNetLink.EXPECT().RuleDel(gomock.Any()).Return(nil),
+		mockNetLink.EXPECT().RuleAdd(gomock.Any()).Return(nil),
+
+		// neighbor
+		mockContVeth.EXPECT().Attrs().Return(mockLinkAttrs),
+		// hostVethMAC
+		mockHostVeth.EXPECT().Attrs().Return(mockLinkAttrs),
+		mockNetLink.EXPECT().NeighAdd(gomock.Any()).Return(nil),
+
+		mockNS.EXPECT().Fd().Return(uintptr(testFD)),
+		// move it host namespace
+		mockNetLink.EXPECT().LinkSetNsFd(mockHostVeth, testFD).Return(nil),
+	)
+
+	err = mockContext.run(mockNS)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, testRule.Table)
+}
+
 func TestRunLinkAddErr(t *testing.T) {
 	ctrl, mockNetLink, mockIP, _ := setup(t)
 	defer ctrl.Finish()
@@ -657,6 +736,83 @@ func TestSetupPodNetworkPrimaryIntf(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSetupPodStaticRoutes(t *testing.T) {
+	ctrl, mockNetLink, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	addr := &net.IPNet{
+		IP:   net.ParseIP(testIP),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+	_, onPrem, _ := net.ParseCIDR("172.16.0.0/16")
+	_, viaGateway, _ := net.ParseCIDR("192.168.100.0/24")
+	routes := []StaticRoute{
+		{Destination: onPrem},
+		{Destination: viaGateway, Gateway: net.ParseIP("10.10.0.5")},
+	}
+
+	var rules []*netlink.Rule
+	mockNetLink.EXPECT().NewRule().DoAndReturn(func() *netlink.Rule { return netlink.NewRule() }).Times(2)
+	mockNetLink.EXPECT().RuleAdd(gomock.Any()).DoAndReturn(func(rule *netlink.Rule) error {
+		rules = append(rules, rule)
+		return nil
+	}).Times(2)
+	mockNetLink.EXPECT().RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: testTable}, netlink.RT_FILTER_TABLE).
+		Return([]netlink.Route{{LinkIndex: 3, Dst: &net.IPNet{IP: net.ParseIP("10.10.0.1"), Mask: net.CIDRMask(32, 32)}},
+			{LinkIndex: 3, Gw: net.ParseIP("10.10.0.1")}}, nil)
+	mockNetLink.EXPECT().RouteReplace(&netlink.Route{LinkIndex: 3, Dst: viaGateway, Gw: routes[1].Gateway,
+		Table: testTable, Flags: int(netlink.FLAG_ONLINK)}).Return(nil)
+
+	err := setupPodStaticRoutes(addr, testTable, routes, false, mockNetLink)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+	for i, rule := range rules {
+		assert.Equal(t, addr, rule.Src)
+		assert.Equal(t, routes[i].Destination, rule.Dst)
+		assert.Equal(t, testTable, rule.Table)
+		assert.Equal(t, fromContainerRulePriority, rule.Priority)
+	}
+
+	// With external SNAT, only the routes with a gateway are added
+	mockNetLink.EXPECT().RouteListFiltered(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
+	err = setupPodStaticRoutes(addr, testTable, routes, true, mockNetLink)
+	assert.Error(t, err)
+
+	err = setupPodStaticRoutes(addr, 0, routes, false, mockNetLink)
+	assert.Error(t, err)
+}
+
+func TestSetupPodSysctls(t *testing.T) {
+	ctrl, _, _, mockNS := setup(t)
+	defer ctrl.Finish()
+
+	mockNS.EXPECT().WithNetNSPath(testnetnsPath, gomock.Any()).DoAndReturn(
+		func(_ string, toRun func(ns.NetNS) error) error {
+			return toRun(nil)
+		}).Times(2)
+
+	written := make(map[string]string)
+	writeProcSys := func(path string, value string) error {
+		if value == "bad" {
+			return errors.New("invalid argument")
+		}
+		written[path] = value
+		return nil
+	}
+	err := setupPodSysctls(testnetnsPath, []Sysctl{
+		{Name: "net.ipv4.conf.eth0.rp_filter", Value: "2"},
+		{Name: "net.ipv4.tcp_keepalive_time", Value: "600"},
+	}, mockNS, writeProcSys)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/proc/sys/net/ipv4/conf/eth0/rp_filter": "2",
+		"/proc/sys/net/ipv4/tcp_keepalive_time":  "600",
+	}, written)
+
+	err = setupPodSysctls(testnetnsPath, []Sysctl{{Name: "net.core.somaxconn", Value: "bad"}}, mockNS, writeProcSys)
+	assert.Error(t, err)
+}
+
 func TestTearDownPodNetwork(t *testing.T) {
 	ctrl, mockNetLink, _, _ := setup(t)
 	defer ctrl.Finish()
@@ -715,3 +871,31 @@ func TestTearDownPodNetworkMain(t *testing.T) {
 	err := tearDownNS(addr, 0, mockNetLink)
 	assert.NoError(t, err)
 }
+
+func TestNetlinkRetry(t *testing.T) {
+	ctrl, mockNetLink, _, mockNS := setup(t)
+	defer ctrl.Finish()
+
+	network := &linuxNetwork{netLink: mockNetLink, ns: mockNS}
+	assert.Nil(t, network.NetlinkRetries())
+	network.EnableNetlinkRetry(2, time.Millisecond)
+
+	// A transient failure is retried
+	rule := &netlink.Rule{}
+	gomock.InOrder(
+		mockNetLink.EXPECT().RuleAdd(rule).Return(syscall.EBUSY),
+		mockNetLink.EXPECT().RuleAdd(rule).Return(nil),
+	)
+	assert.NoError(t, network.netLink.RuleAdd(rule))
+
+	// Up to the number of retries
+	route := &netlink.Route{}
+	mockNetLink.EXPECT().RouteReplace(route).Return(syscall.EBUSY).Times(3)
+	assert.Equal(t, syscall.EBUSY, network.netLink.RouteReplace(route))
+
+	// Other failures are not retried
+	mockNetLink.EXPECT().RuleDel(rule).Return(syscall.ENOENT)
+	assert.Equal(t, syscall.ENOENT, network.netLink.RuleDel(rule))
+
+	assert.Equal(t, map[string]int{"RuleAdd": 1, "RouteReplace": 2}, network.NetlinkRetries())
+}
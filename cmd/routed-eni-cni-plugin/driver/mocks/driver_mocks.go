@@ -20,7 +20,9 @@ package mock_driver
 import (
 	net "net"
 	reflect "reflect"
+	time "time"
 
+	driver "github.com/aws/amazon-vpc-cni-k8s/cmd/routed-eni-cni-plugin/driver"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -47,6 +49,28 @@ func (m *MockNetworkAPIs) EXPECT() *MockNetworkAPIsMockRecorder {
 	return m.recorder
 }
 
+// EnableNetlinkRetry mocks base method
+func (m *MockNetworkAPIs) EnableNetlinkRetry(arg0 int, arg1 time.Duration) {
+	m.ctrl.Call(m, "EnableNetlinkRetry", arg0, arg1)
+}
+
+// EnableNetlinkRetry indicates an expected call of EnableNetlinkRetry
+func (mr *MockNetworkAPIsMockRecorder) EnableNetlinkRetry(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableNetlinkRetry", reflect.TypeOf((*MockNetworkAPIs)(nil).EnableNetlinkRetry), arg0, arg1)
+}
+
+// NetlinkRetries mocks base method
+func (m *MockNetworkAPIs) NetlinkRetries() map[string]int {
+	ret := m.ctrl.Call(m, "NetlinkRetries")
+	ret0, _ := ret[0].(map[string]int)
+	return ret0
+}
+
+// NetlinkRetries indicates an expected call of NetlinkRetries
+func (mr *MockNetworkAPIsMockRecorder) NetlinkRetries() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetlinkRetries", reflect.TypeOf((*MockNetworkAPIs)(nil).NetlinkRetries))
+}
+
 // SetupNS mocks base method
 func (m *MockNetworkAPIs) SetupNS(arg0, arg1, arg2 string, arg3 *net.IPNet, arg4 int, arg5 []string, arg6 bool, arg7 int) error {
 	ret := m.ctrl.Call(m, "SetupNS", arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7)
@@ -59,6 +83,42 @@ func (mr *MockNetworkAPIsMockRecorder) SetupNS(arg0, arg1, arg2, arg3, arg4, arg
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupNS", reflect.TypeOf((*MockNetworkAPIs)(nil).SetupNS), arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7)
 }
 
+// SetupNSInterface mocks base method
+func (m *MockNetworkAPIs) SetupNSInterface(arg0, arg1, arg2 string, arg3 *net.IPNet, arg4 int, arg5 []string, arg6 bool, arg7, arg8 int) error {
+	ret := m.ctrl.Call(m, "SetupNSInterface", arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetupNSInterface indicates an expected call of SetupNSInterface
+func (mr *MockNetworkAPIsMockRecorder) SetupNSInterface(arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupNSInterface", reflect.TypeOf((*MockNetworkAPIs)(nil).SetupNSInterface), arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
+}
+
+// SetupPodStaticRoutes mocks base method
+func (m *MockNetworkAPIs) SetupPodStaticRoutes(arg0 *net.IPNet, arg1 int, arg2 []driver.StaticRoute, arg3 bool) error {
+	ret := m.ctrl.Call(m, "SetupPodStaticRoutes", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetupPodStaticRoutes indicates an expected call of SetupPodStaticRoutes
+func (mr *MockNetworkAPIsMockRecorder) SetupPodStaticRoutes(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupPodStaticRoutes", reflect.TypeOf((*MockNetworkAPIs)(nil).SetupPodStaticRoutes), arg0, arg1, arg2, arg3)
+}
+
+// SetupPodSysctls mocks base method
+func (m *MockNetworkAPIs) SetupPodSysctls(arg0 string, arg1 []driver.Sysctl) error {
+	ret := m.ctrl.Call(m, "SetupPodSysctls", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetupPodSysctls indicates an expected call of SetupPodSysctls
+func (mr *MockNetworkAPIsMockRecorder) SetupPodSysctls(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupPodSysctls", reflect.TypeOf((*MockNetworkAPIs)(nil).SetupPodSysctls), arg0, arg1)
+}
+
 // TeardownNS mocks base method
 func (m *MockNetworkAPIs) TeardownNS(arg0 *net.IPNet, arg1 int) error {
 	ret := m.ctrl.Call(m, "TeardownNS", arg0, arg1)
@@ -0,0 +1,98 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package driver
+
+import (
+	"syscall"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/netlinkwrapper"
+)
+
+// retryNetLink retries the netlink operations that change links, addresses, routes and rules when they fail with a
+// transient error, up to attempts times with an exponential backoff, and counts the retries of each operation
+type retryNetLink struct {
+	netlinkwrapper.NetLink
+	attempts int
+	backoff  time.Duration
+	retries  map[string]int
+}
+
+func newRetryNetLink(netLink netlinkwrapper.NetLink, attempts int, backoff time.Duration) *retryNetLink {
+	return &retryNetLink{NetLink: netLink, attempts: attempts, backoff: backoff, retries: make(map[string]int)}
+}
+
+// isTransientNetlinkError returns true if a netlink operation that failed with err may succeed when done again
+func isTransientNetlinkError(err error) bool {
+	if errno, ok := err.(syscall.Errno); ok {
+		return errno == syscall.EBUSY || errno == syscall.EAGAIN || errno == syscall.EINTR
+	}
+	return false
+}
+
+func (n *retryNetLink) retry(operation string, f func() error) error {
+	err := f()
+	backoff := n.backoff
+	for attempt := 1; attempt <= n.attempts && isTransientNetlinkError(err); attempt++ {
+		n.retries[operation]++
+		log.Infof("Netlink %s failed: %v, retrying in %v (%d/%d)", operation, err, backoff, attempt, n.attempts)
+		time.Sleep(backoff)
+		backoff *= 2
+		err = f()
+	}
+	return err
+}
+
+func (n *retryNetLink) LinkAdd(link netlink.Link) error {
+	return n.retry("LinkAdd", func() error { return n.NetLink.LinkAdd(link) })
+}
+
+func (n *retryNetLink) LinkDel(link netlink.Link) error {
+	return n.retry("LinkDel", func() error { return n.NetLink.LinkDel(link) })
+}
+
+func (n *retryNetLink) LinkSetUp(link netlink.Link) error {
+	return n.retry("LinkSetUp", func() error { return n.NetLink.LinkSetUp(link) })
+}
+
+func (n *retryNetLink) LinkSetNsFd(link netlink.Link, fd int) error {
+	return n.retry("LinkSetNsFd", func() error { return n.NetLink.LinkSetNsFd(link, fd) })
+}
+
+func (n *retryNetLink) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	return n.retry("AddrAdd", func() error { return n.NetLink.AddrAdd(link, addr) })
+}
+
+func (n *retryNetLink) NeighAdd(neigh *netlink.Neigh) error {
+	return n.retry("NeighAdd", func() error { return n.NetLink.NeighAdd(neigh) })
+}
+
+func (n *retryNetLink) RouteReplace(route *netlink.Route) error {
+	return n.retry("RouteReplace", func() error { return n.NetLink.RouteReplace(route) })
+}
+
+func (n *retryNetLink) RouteDel(route *netlink.Route) error {
+	return n.retry("RouteDel", func() error { return n.NetLink.RouteDel(route) })
+}
+
+func (n *retryNetLink) RuleAdd(rule *netlink.Rule) error {
+	return n.retry("RuleAdd", func() error { return n.NetLink.RuleAdd(rule) })
+}
+
+func (n *retryNetLink) RuleDel(rule *netlink.Rule) error {
+	return n.retry("RuleDel", func() error { return n.NetLink.RuleDel(rule) })
+}
@@ -24,7 +24,16 @@ type ENIConfig struct {
 type ENIConfigSpec struct {
 	SecurityGroups []string `json:"securityGroups"`
 	Subnet         string   `json:"subnet"`
+	// StaticRoutes are routed through the ENI of the pods on the ENIs of this config, instead of the primary ENI
+	StaticRoutes []StaticRoute `json:"staticRoutes,omitempty"`
 }
+
+// StaticRoute is a destination CIDR routed through the ENI of a pod, via Gateway when it is set
+type StaticRoute struct {
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway,omitempty"`
+}
+
 type ENIConfigStatus struct {
 	// Fill me
 }
@@ -0,0 +1,144 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAPILatencySamples is the number of most recent latency samples kept for each API
+	maxAPILatencySamples = 1000
+	// apiLatencyWindow is how long a latency sample is kept
+	apiLatencyWindow = time.Hour
+)
+
+// APILatencyStats is the latency distribution of the recent calls to an API
+type APILatencyStats struct {
+	// Count is the number of calls in the sample window, and Errors how many of them failed
+	Count  int
+	Errors int
+	P50Ms  float64
+	P90Ms  float64
+	P99Ms  float64
+	MaxMs  float64
+}
+
+type latencySample struct {
+	time    time.Time
+	latency float64
+	failed  bool
+}
+
+// apiLatencyRecorder keeps the latency of the last maxAPILatencySamples calls of each API
+type apiLatencyRecorder struct {
+	samples map[string][]latencySample
+	lock    sync.Mutex
+}
+
+// apiLatencies keeps the latency of the EC2 API calls, and imdsLatencies that of the instance metadata calls, which
+// are local to the node and would hide the EC2 latency if they were mixed in
+var (
+	apiLatencies  = &apiLatencyRecorder{samples: make(map[string][]latencySample)}
+	imdsLatencies = &apiLatencyRecorder{samples: make(map[string][]latencySample)}
+)
+
+// observeAPILatency records the latency of an EC2 API call that started at start
+func observeAPILatency(api string, err error, start time.Time) {
+	awsAPILatency.WithLabelValues(api, fmt.Sprint(err != nil)).Observe(msSince(start))
+	apiLatencies.record(api, newLatencySample(err, start))
+}
+
+// observeIMDSLatency records the latency of an instance metadata call that started at start
+func observeIMDSLatency(api string, err error, start time.Time) {
+	awsAPILatency.WithLabelValues(api, fmt.Sprint(err != nil)).Observe(msSince(start))
+	imdsLatencies.record(api, newLatencySample(err, start))
+}
+
+func newLatencySample(err error, start time.Time) latencySample {
+	return latencySample{
+		time:    time.Now(),
+		latency: float64(time.Since(start)) / float64(time.Millisecond),
+		failed:  err != nil,
+	}
+}
+
+func (r *apiLatencyRecorder) record(api string, sample latencySample) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	samples := append(r.samples[api], sample)
+	if len(samples) > maxAPILatencySamples {
+		samples = samples[len(samples)-maxAPILatencySamples:]
+	}
+	r.samples[api] = samples
+}
+
+// stats returns the latency distribution of each API over the samples younger than window
+func (r *apiLatencyRecorder) stats(window time.Duration) map[string]APILatencyStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stats := make(map[string]APILatencyStats, len(r.samples))
+	for api, samples := range r.samples {
+		var latencies []float64
+		apiStats := APILatencyStats{}
+		for _, sample := range samples {
+			if time.Since(sample.time) > window {
+				continue
+			}
+			latencies = append(latencies, sample.latency)
+			if sample.failed {
+				apiStats.Errors++
+			}
+		}
+		if len(latencies) == 0 {
+			continue
+		}
+		sort.Float64s(latencies)
+		apiStats.Count = len(latencies)
+		apiStats.P50Ms = percentile(latencies, 50)
+		apiStats.P90Ms = percentile(latencies, 90)
+		apiStats.P99Ms = percentile(latencies, 99)
+		apiStats.MaxMs = latencies[len(latencies)-1]
+		stats[api] = apiStats
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile p of the sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// APILatencyReport is the latency distribution of the EC2 API calls and of the instance metadata calls of the last
+// hour, by API
+type APILatencyReport struct {
+	EC2  map[string]APILatencyStats
+	IMDS map[string]APILatencyStats
+}
+
+// GetAPILatencyStats returns the latency distribution of the AWS API calls of the last hour
+func GetAPILatencyStats() APILatencyReport {
+	return APILatencyReport{
+		EC2:  apiLatencies.stats(apiLatencyWindow),
+		IMDS: imdsLatencies.stats(apiLatencyWindow),
+	}
+}
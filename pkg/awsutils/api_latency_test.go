@@ -0,0 +1,53 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPILatencyStats(t *testing.T) {
+	r := &apiLatencyRecorder{samples: make(map[string][]latencySample)}
+	now := time.Now()
+	for i := 1; i <= 100; i++ {
+		r.record("DescribeNetworkInterfaces", latencySample{time: now, latency: float64(i), failed: i%10 == 0})
+	}
+	// Outside of the window
+	r.record("DescribeSubnets", latencySample{time: now.Add(-2 * time.Hour), latency: 5})
+
+	stats := r.stats(time.Hour)
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, APILatencyStats{Count: 100, Errors: 10, P50Ms: 50, P90Ms: 90, P99Ms: 99, MaxMs: 100},
+		stats["DescribeNetworkInterfaces"])
+
+	// The number of samples is bounded
+	for i := 0; i < maxAPILatencySamples; i++ {
+		r.record("DescribeNetworkInterfaces", latencySample{time: now, latency: 1})
+	}
+	stats = r.stats(time.Hour)
+	assert.Equal(t, maxAPILatencySamples, stats["DescribeNetworkInterfaces"].Count)
+	assert.Equal(t, float64(1), stats["DescribeNetworkInterfaces"].MaxMs)
+}
+
+func TestIMDSLatencyStats(t *testing.T) {
+	// The instance metadata calls are kept apart from the EC2 API calls
+	before := GetAPILatencyStats().IMDS["GetMetadata"].Count
+	observeIMDSLatency("GetMetadata", nil, time.Now())
+	report := GetAPILatencyStats()
+	assert.Equal(t, before+1, report.IMDS["GetMetadata"].Count)
+	assert.NotContains(t, report.EC2, "GetMetadata")
+}
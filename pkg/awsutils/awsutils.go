@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -33,6 +35,9 @@ import (
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/retry"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -58,6 +63,9 @@ const (
 	maxENIBackoffDelay   = time.Minute
 	eniDescriptionPrefix = "aws-K8S-"
 	metadataOwnerID      = "/owner-id"
+	metadataIAMInfo      = "iam/info"
+	// metadataClusterNameTag is the tag EKS sets on the instances of a cluster, in the instance metadata
+	metadataClusterNameTag = "tags/instance/eks:cluster-name"
 
 	// AllocENI need to choose a first free device number between 0 and maxENI
 	maxENIs                 = 128
@@ -66,6 +74,39 @@ const (
 	eniClusterTagKey        = "cluster.k8s.amazonaws.com/name"
 	additionalEniTagsEnvVar = "ADDITIONAL_ENI_TAGS"
 	reservedTagKeyPrefix    = "k8s.amazonaws.com"
+
+	// This environment variable is used to specify whether the ENIs created by ipamd are also tagged with their
+	// availability zone and subnet, for the cost allocation tools. It defaults to false.
+	eniLocationTagsEnvVar = "ENI_LOCATION_TAGS"
+	eniZoneTagKey         = "topology.k8s.amazonaws.com/zone"
+	eniSubnetTagKey       = "topology.k8s.amazonaws.com/subnet-id"
+	// maxENITags is the maximum number of tags EC2 allows on an ENI
+	maxENITags = 50
+
+	// InterfaceTypeTrunk is the type of the ENI the VPC resource controller attaches to the instance for the pods with
+	// security groups, their branch ENIs are associated with it on a VLAN each
+	InterfaceTypeTrunk = "trunk"
+	// trunkENIIDTagKey and vlanIDTagKey are the tags the VPC resource controller sets on the branch ENIs
+	trunkENIIDTagKey = "vpcresources.k8s.aws/trunk-eni-id"
+	vlanIDTagKey     = "vpcresources.k8s.aws/vlan-id"
+
+	// This environment variable is used to specify the description of the ENIs created by ipamd. The placeholders
+	// {cluster} and {instance-id} are replaced with the value of CLUSTER_NAME and the instance ID. When it is not set,
+	// or the rendered description is longer than EC2 allows, "aws-K8S-<instance-id>" is used.
+	eniDescriptionTemplateEnvVar = "ENI_DESCRIPTION_TEMPLATE"
+	eniDescriptionClusterKey     = "{cluster}"
+	eniDescriptionInstanceIDKey  = "{instance-id}"
+	// maxENIDescriptionLength is the maximum length of an ENI description accepted by EC2
+	maxENIDescriptionLength = 255
+
+	// This environment variable is used to specify, comma separated, the tag keys that an older CNI version tagged the
+	// ENIs it created with in place of node.k8s.amazonaws.com/instance_id, with the instance ID as value. The ENIs
+	// tagged with one of them are reported as legacy ENIs.
+	legacyENITagKeysEnvVar = "LEGACY_ENI_TAG_KEYS"
+	// LegacyENISchemaUntagged is the schema of the ENIs created by the CNI versions that did not tag them, and only set
+	// their description to "aws-K8S-<instance-id>"
+	LegacyENISchemaUntagged = "untagged"
+
 	// UnknownInstanceType indicates that the instance type is not yet supported
 	UnknownInstanceType = "vpc ip resource(eni ip limit): unknown instance type"
 
@@ -73,6 +114,15 @@ const (
 	eniCleanupStartupDelayMax = 300
 )
 
+const (
+	// ClusterNameSourceEnv is the source of a cluster name set with CLUSTER_NAME
+	ClusterNameSourceEnv = "env"
+	// ClusterNameSourceMetadata is the source of a cluster name found in the tags of the instance metadata
+	ClusterNameSourceMetadata = "metadata"
+	// ClusterNameSourceNone means that no cluster name was found, the ENIs are not tagged with it
+	ClusterNameSourceNone = "none"
+)
+
 // ErrENINotFound is an error when ENI is not found.
 var ErrENINotFound = errors.New("ENI is not found")
 
@@ -101,28 +151,35 @@ var (
 	prometheusRegistered = false
 )
 
-// APIs defines interfaces calls for adding/getting/deleting ENIs/secondary IPs. The APIs are not thread-safe.
+// APIs defines interfaces calls for adding/getting/deleting ENIs/secondary IPs. The APIs are not thread-safe. The calls
+// that take a context pass it to the EC2 API, cancelling it cancels the EC2 calls in flight and the retries.
 type APIs interface {
-	// AllocENI creates an ENI and attaches it to the instance
-	AllocENI(useCustomCfg bool, sg []*string, subnet string) (eni string, err error)
+	// AllocENI creates an ENI and attaches it to the instance. Without a custom config, the ENI gets the security groups
+	// of the primary ENI, and is created in subnet if it is set, or in the subnet of the primary ENI otherwise.
+	AllocENI(ctx context.Context, useCustomCfg bool, sg []*string, subnet string) (eni string, err error)
 
 	// FreeENI detaches ENI interface and deletes it
-	FreeENI(eniName string) error
+	FreeENI(ctx context.Context, eniName string) error
 
 	// GetAttachedENIs retrieves eni information from instance metadata service
-	GetAttachedENIs() (eniList []ENIMetadata, err error)
+	GetAttachedENIs(ctx context.Context) (eniList []ENIMetadata, err error)
+
+	// GetENIMACs returns the MAC address of each ENI attached to the instance, keyed by ENI ID, from the instance
+	// metadata service only
+	GetENIMACs() (map[string]string, error)
 
 	// DescribeENI returns the IPv4 addresses of ENI interface, tags, and the ENI attachment ID
-	DescribeENI(eniID string) (addrList []*ec2.NetworkInterfacePrivateIpAddress, tags map[string]string, attachemdID *string, err error)
+	DescribeENI(ctx context.Context, eniID string) (addrList []*ec2.NetworkInterfacePrivateIpAddress, tags map[string]string, attachemdID *string, err error)
 
 	// AllocIPAddress allocates an IP address for an ENI
-	AllocIPAddress(eniID string) error
+	AllocIPAddress(ctx context.Context, eniID string) error
 
-	// AllocIPAddresses allocates numIPs IP addresses on a ENI
-	AllocIPAddresses(eniID string, numIPs int) error
+	// AllocIPAddresses allocates numIPs IP addresses on a ENI, and returns the IP addresses EC2 assigned, which may be
+	// fewer than numIPs
+	AllocIPAddresses(ctx context.Context, eniID string, numIPs int) ([]string, error)
 
 	// DeallocIPAddresses deallocates the list of IP addresses from a ENI
-	DeallocIPAddresses(eniID string, ips []string) error
+	DeallocIPAddresses(ctx context.Context, eniID string, ips []string) error
 
 	// GetVPCIPv4CIDR returns VPC's 1st CIDR
 	GetVPCIPv4CIDR() string
@@ -144,6 +201,41 @@ type APIs interface {
 
 	// GetPrimaryENImac returns the mac address of the primary ENI
 	GetPrimaryENImac() string
+
+	// GetPrimaryENISubnetID returns the subnet of the primary ENI
+	GetPrimaryENISubnetID() string
+
+	// GetPrimaryENISecurityGroups returns the security groups of the primary ENI
+	GetPrimaryENISecurityGroups() []string
+
+	// GetSubnetAvailableIPCount returns the number of free IP addresses left in a subnet. An empty subnet ID
+	// means the subnet of the primary ENI.
+	GetSubnetAvailableIPCount(ctx context.Context, subnetID string) (int, error)
+
+	// GetAvailabilityZone returns the availability zone of the instance
+	GetAvailabilityZone() string
+
+	// GetSubnetAvailabilityZone returns the availability zone of a subnet
+	GetSubnetAvailabilityZone(ctx context.Context, subnetID string) (string, error)
+
+	// GetCredentialsInfo returns where the credentials used for EC2 calls come from, without any secrets
+	GetCredentialsInfo() CredentialsInfo
+
+	// GetMetadataWaitInfo returns how long the startup waited for the primary ENI to be in the instance metadata
+	GetMetadataWaitInfo() MetadataWaitInfo
+
+	// TagENI tags the ENI in subnetID with the tags of the ENIs created by ipamd
+	TagENI(ctx context.Context, eniID string, subnetID string) error
+
+	// GetClusterInfo returns the cluster name, where it comes from, and the tags and description of the ENIs created
+	// by ipamd
+	GetClusterInfo() ClusterInfo
+
+	// GetBranchENIs returns the branch ENIs associated with the trunk ENI
+	GetBranchENIs(ctx context.Context, trunkENIID string) ([]BranchENI, error)
+
+	// DeleteBranchENI deletes a branch ENI, which also removes its association with the trunk ENI
+	DeleteBranchENI(ctx context.Context, eniID string) error
 }
 
 // EC2InstanceMetadataCache caches instance metadata
@@ -162,12 +254,19 @@ type EC2InstanceMetadataCache struct {
 	availabilityZone string
 	region           string
 	accountID        string
+	// clusterName is the cluster name the ENIs are tagged with, from clusterNameSource
+	clusterName       string
+	clusterNameSource string
 
 	// dynamic
 	currentENIs int
 
 	ec2Metadata ec2metadata.EC2Metadata
 	ec2SVC      ec2wrapper.EC2
+	credentials *credentials.Credentials
+
+	// metadataWait is the wait at startup for the primary ENI to be in the instance metadata
+	metadataWait MetadataWaitInfo
 }
 
 // ENIMetadata contains information about an ENI
@@ -184,11 +283,38 @@ type ENIMetadata struct {
 	// SubnetIPv4CIDR is the ipv4 cider of network interface
 	SubnetIPv4CIDR string
 
+	// SubnetID is the ID of the subnet of the network interface
+	SubnetID string
+
 	// The ip addresses allocated for the network interface
 	IPv4Addresses []*ec2.NetworkInterfacePrivateIpAddress
 
+	// IPv6Addresses are the IPv6 addresses assigned to the network interface in AWS
+	IPv6Addresses []string
+
 	// Tags are the tags associated with this ENI in AWS
 	Tags map[string]string
+
+	// Description is the description of the ENI in AWS
+	Description string
+
+	// SecurityGroups are the IDs of the security groups of the ENI in AWS
+	SecurityGroups []string
+
+	// LegacySchema is set when the ENI was created for this instance by an older CNI version that tagged it
+	// differently. It is LegacyENISchemaUntagged, or the tag key of LEGACY_ENI_TAG_KEYS that the ENI has.
+	LegacySchema string
+
+	// InterfaceType is the type of the ENI in AWS, InterfaceTypeTrunk for a trunk ENI
+	InterfaceType string
+}
+
+// BranchENI is a branch ENI associated with a trunk ENI
+type BranchENI struct {
+	ENIID     string
+	VlanID    int
+	PrivateIP string
+	SubnetID  string
 }
 
 func (eni ENIMetadata) PrimaryIPv4Address() string {
@@ -240,6 +366,8 @@ func New() (*EC2InstanceMetadataCache, error) {
 
 	ec2SVC := ec2wrapper.New(sess)
 	cache.ec2SVC = ec2SVC
+	cache.credentials = sess.Config.Credentials
+	cache.waitForPrimaryENIMetadata(getPrimaryENIMetadataWait(), primaryENIMetadataPollPeriod)
 	err = cache.initWithEC2Metadata()
 	if err != nil {
 		return nil, err
@@ -351,9 +479,37 @@ func (cache *EC2InstanceMetadataCache) initWithEC2Metadata() error {
 		log.Debugf("Found VPC CIDR: %s", vpcCIDR)
 		cache.vpcIPv4CIDRs = append(cache.vpcIPv4CIDRs, aws.String(vpcCIDR))
 	}
+
+	cache.resolveClusterName()
 	return nil
 }
 
+// resolveClusterName sets the cluster name the ENIs are tagged with: CLUSTER_NAME, or else the eks:cluster-name tag
+// of the instance, which the instance metadata only has when the instance allows access to its tags
+func (cache *EC2InstanceMetadataCache) resolveClusterName() {
+	if clusterName := os.Getenv(clusterNameEnvVar); clusterName != "" {
+		cache.clusterName, cache.clusterNameSource = clusterName, ClusterNameSourceEnv
+		return
+	}
+	clusterName, err := cache.ec2Metadata.GetMetadata(metadataClusterNameTag)
+	if err != nil || clusterName == "" {
+		log.Infof("%s is not set and the instance metadata has no cluster name tag, the ENIs are not tagged with %s",
+			clusterNameEnvVar, eniClusterTagKey)
+		cache.clusterName, cache.clusterNameSource = "", ClusterNameSourceNone
+		return
+	}
+	log.Infof("Found cluster name %s in the instance metadata", clusterName)
+	cache.clusterName, cache.clusterNameSource = clusterName, ClusterNameSourceMetadata
+}
+
+// getClusterName returns the resolved cluster name, or CLUSTER_NAME before it is resolved
+func (cache *EC2InstanceMetadataCache) getClusterName() string {
+	if cache.clusterNameSource == "" {
+		return os.Getenv(clusterNameEnvVar)
+	}
+	return cache.clusterName
+}
+
 func (cache *EC2InstanceMetadataCache) setPrimaryENI() error {
 	if cache.primaryENI != "" {
 		return nil
@@ -417,7 +573,7 @@ func (cache *EC2InstanceMetadataCache) setPrimaryENI() error {
 }
 
 // GetAttachedENIs retrieves ENI information from meta data service
-func (cache *EC2InstanceMetadataCache) GetAttachedENIs() (eniList []ENIMetadata, err error) {
+func (cache *EC2InstanceMetadataCache) GetAttachedENIs(ctx context.Context) (eniList []ENIMetadata, err error) {
 	// retrieve number of interfaces
 	macs, err := cache.ec2Metadata.GetMetadata(metadataMACPath)
 	if err != nil {
@@ -432,7 +588,7 @@ func (cache *EC2InstanceMetadataCache) GetAttachedENIs() (eniList []ENIMetadata,
 	var enis []ENIMetadata
 	// retrieve the attached ENIs
 	for _, macStr := range macsStrs {
-		eniMetadata, err := cache.getENIMetadata(macStr)
+		eniMetadata, err := cache.getENIMetadata(ctx, macStr)
 		if err != nil {
 			return nil, errors.Wrapf(err, "get attached ENIs: failed to retrieve ENI metadata for ENI: %s", macStr)
 		}
@@ -441,7 +597,32 @@ func (cache *EC2InstanceMetadataCache) GetAttachedENIs() (eniList []ENIMetadata,
 	return enis, nil
 }
 
-func (cache *EC2InstanceMetadataCache) getENIMetadata(macStr string) (ENIMetadata, error) {
+// GetENIMACs returns the MAC address of each ENI attached to the instance, keyed by ENI ID, without describing the ENIs
+func (cache *EC2InstanceMetadataCache) GetENIMACs() (map[string]string, error) {
+	macs, err := cache.ec2Metadata.GetMetadata(metadataMACPath)
+	if err != nil {
+		awsAPIErrInc("GetMetadata", err)
+		return nil, errors.Wrap(err, "get ENI MACs: failed to retrieve interfaces data")
+	}
+	eniMACs := make(map[string]string)
+	for _, macStr := range strings.Fields(macs) {
+		mac := strings.Split(macStr, "/")[0]
+		eni, err := cache.ec2Metadata.GetMetadata(metadataMACPath + mac + metadataInterface)
+		if err != nil {
+			awsAPIErrInc("GetMetadata", err)
+			return nil, errors.Wrapf(err, "get ENI MACs: failed to retrieve interface-id for ENI %s", mac)
+		}
+		eniMACs[eni] = mac
+	}
+	return eniMACs, nil
+}
+
+// ENIMetadataPath returns the instance metadata path of the ENI with the MAC address
+func ENIMetadataPath(mac string) string {
+	return metadataMACPath + mac + "/"
+}
+
+func (cache *EC2InstanceMetadataCache) getENIMetadata(ctx context.Context, macStr string) (ENIMetadata, error) {
 	eniMACList := strings.Split(macStr, "/")
 	eniMAC := eniMACList[0]
 	log.Debugf("Found ENI mac address : %s", eniMAC)
@@ -456,10 +637,11 @@ func (cache *EC2InstanceMetadataCache) getENIMetadata(macStr string) (ENIMetadat
 	if err != nil {
 		return ENIMetadata{}, errors.Wrapf(err, "get ENI metadata: failed to retrieve IPs and CIDR for ENI: %s", eniMAC)
 	}
-	privateIPv4s, tags, _, err := cache.DescribeENI(eni)
+	networkInterface, err := cache.describeENI(ctx, eni)
 	if err != nil {
 		return ENIMetadata{}, errors.Wrapf(err, "get ENI metadata: failed to describe ENI: %s, %v", eniMAC, err)
 	}
+	privateIPv4s := networkInterface.PrivateIpAddresses
 	// getIPsAndCIDR() queries IMDS for IPv4 addresses attached to the ENI.
 	// DescribeENI() calls the DescribeNetworkInterfaces AWS API call, which
 	// technically should be the source of truth and contain the freshest
@@ -480,21 +662,56 @@ func (cache *EC2InstanceMetadataCache) getENIMetadata(macStr string) (ENIMetadat
 		strMissing := strings.Join(missingDNI, ",")
 		log.Debugf("getENIMetadata: IMDS query yielded stale IPv4 addresses %s that were not found in DescribeNetworkInterfaces(%s).", strMissing, eni)
 	}
+	tags := eniTags(eni, networkInterface)
+	description := aws.StringValue(networkInterface.Description)
 	return ENIMetadata{
 		ENIID:          eni,
 		MAC:            eniMAC,
 		DeviceNumber:   deviceNum,
 		SubnetIPv4CIDR: cidr,
+		SubnetID:       aws.StringValue(networkInterface.SubnetId),
 		IPv4Addresses:  privateIPv4s,
+		IPv6Addresses:  eniIPv6Addresses(networkInterface),
 		Tags:           tags,
+		Description:    description,
+		SecurityGroups: eniSecurityGroups(networkInterface),
+		LegacySchema:   cache.legacyENISchema(deviceNum, description, tags),
+		InterfaceType:  aws.StringValue(networkInterface.InterfaceType),
 	}, nil
 }
 
+// legacyENISchema returns the legacy schema of the ENI if an older CNI version created it for this instance, or "" if
+// it is the primary ENI, ipamd tagged it, or it was not created by the CNI
+func (cache *EC2InstanceMetadataCache) legacyENISchema(deviceNum int, description string, tags map[string]string) string {
+	if _, ok := tags[eniNodeTagKey]; ok || deviceNum == 0 {
+		return ""
+	}
+	for _, key := range strings.Split(os.Getenv(legacyENITagKeysEnvVar), ",") {
+		key = strings.TrimSpace(key)
+		if key != "" && tags[key] == cache.instanceID {
+			return key
+		}
+	}
+	if description == eniDescriptionPrefix+cache.instanceID {
+		return LegacyENISchemaUntagged
+	}
+	return ""
+}
+
+// eniSecurityGroups returns the IDs of the security groups of the ENI
+func eniSecurityGroups(networkInterface *ec2.NetworkInterface) []string {
+	var groups []string
+	for _, group := range networkInterface.Groups {
+		groups = append(groups, aws.StringValue(group.GroupId))
+	}
+	return groups
+}
+
 // getIPsAndCIDR return list of IPs, CIDR, error
 func (cache *EC2InstanceMetadataCache) getIPsAndCIDR(eniMAC string) ([]string, string, error) {
 	start := time.Now()
 	cidr, err := cache.ec2Metadata.GetMetadata(metadataMACPath + eniMAC + metadataSubnetCIDR)
-	awsAPILatency.WithLabelValues("GetMetadata", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeIMDSLatency("GetMetadata", err, start)
 
 	if err != nil {
 		awsAPIErrInc("GetMetadata", err)
@@ -505,7 +722,7 @@ func (cache *EC2InstanceMetadataCache) getIPsAndCIDR(eniMAC string) ([]string, s
 
 	start = time.Now()
 	ipv4s, err := cache.ec2Metadata.GetMetadata(metadataMACPath + eniMAC + metadataIPv4s)
-	awsAPILatency.WithLabelValues("GetMetadata", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeIMDSLatency("GetMetadata", err, start)
 	if err != nil {
 		awsAPIErrInc("GetMetadata", err)
 		log.Errorf("Failed to retrieve ENI %s local-ipv4s from instance metadata service, %v", eniMAC, err)
@@ -522,7 +739,7 @@ func (cache *EC2InstanceMetadataCache) getENIDeviceNumber(eniMAC string) (string
 	// get device-number
 	start := time.Now()
 	device, err := cache.ec2Metadata.GetMetadata(metadataMACPath + eniMAC + metadataDeviceNum)
-	awsAPILatency.WithLabelValues("GetMetadata", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeIMDSLatency("GetMetadata", err, start)
 	if err != nil {
 		awsAPIErrInc("GetMetadata", err)
 		log.Errorf("Failed to retrieve the device-number of ENI %s, %v", eniMAC, err)
@@ -537,7 +754,7 @@ func (cache *EC2InstanceMetadataCache) getENIDeviceNumber(eniMAC string) (string
 
 	start = time.Now()
 	eni, err := cache.ec2Metadata.GetMetadata(metadataMACPath + eniMAC + metadataInterface)
-	awsAPILatency.WithLabelValues("GetMetadata", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeIMDSLatency("GetMetadata", err, start)
 	if err != nil {
 		awsAPIErrInc("GetMetadata", err)
 		log.Errorf("Failed to retrieve the interface-id data from instance metadata service, %v", err)
@@ -552,14 +769,14 @@ func (cache *EC2InstanceMetadataCache) getENIDeviceNumber(eniMAC string) (string
 	return eni, int(deviceNum + 1), nil
 }
 
-func (cache *EC2InstanceMetadataCache) awsGetFreeDeviceNumber() (int, error) {
+func (cache *EC2InstanceMetadataCache) awsGetFreeDeviceNumber(ctx context.Context) (int, error) {
 	input := &ec2.DescribeInstancesInput{
 		InstanceIds: []*string{aws.String(cache.instanceID)},
 	}
 
 	start := time.Now()
-	result, err := cache.ec2SVC.DescribeInstances(input)
-	awsAPILatency.WithLabelValues("DescribeInstances", fmt.Sprint(err != nil)).Observe(msSince(start))
+	result, err := cache.ec2SVC.DescribeInstancesWithContext(ctx, input)
+	observeAPILatency("DescribeInstances", err, start)
 	if err != nil {
 		awsAPIErrInc("DescribeInstances", err)
 		log.Errorf("awsGetFreeDeviceNumber: Unable to retrieve instance data from EC2 control plane %v", err)
@@ -595,20 +812,20 @@ func (cache *EC2InstanceMetadataCache) awsGetFreeDeviceNumber() (int, error) {
 
 // AllocENI creates an ENI and attaches it to the instance
 // returns: newly created ENI ID
-func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string, subnet string) (string, error) {
-	eniID, err := cache.createENI(useCustomCfg, sg, subnet)
+func (cache *EC2InstanceMetadataCache) AllocENI(ctx context.Context, useCustomCfg bool, sg []*string, subnet string) (string, error) {
+	eniID, eniSubnet, err := cache.createENI(ctx, useCustomCfg, sg, subnet)
 	if err != nil {
 		return "", errors.Wrap(err, "AllocENI: failed to create ENI")
 	}
 
-	attachmentID, err := cache.attachENI(eniID)
+	attachmentID, err := cache.attachENI(ctx, eniID)
 	if err != nil {
-		_ = cache.deleteENI(eniID, maxENIBackoffDelay)
+		_ = cache.deleteENI(ctx, eniID, maxENIBackoffDelay)
 		return "", errors.Wrap(err, "AllocENI: error attaching ENI")
 	}
 
 	// Once the ENI is attached, tag it.
-	cache.tagENI(eniID, maxENIBackoffDelay)
+	_ = cache.tagENI(ctx, eniID, eniSubnet, maxENIBackoffDelay)
 
 	// Also change the ENI's attribute so that the ENI will be deleted when the instance is deleted.
 	attributeInput := &ec2.ModifyNetworkInterfaceAttributeInput{
@@ -620,11 +837,11 @@ func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string,
 	}
 
 	start := time.Now()
-	_, err = cache.ec2SVC.ModifyNetworkInterfaceAttribute(attributeInput)
-	awsAPILatency.WithLabelValues("ModifyNetworkInterfaceAttribute", fmt.Sprint(err != nil)).Observe(msSince(start))
+	_, err = cache.ec2SVC.ModifyNetworkInterfaceAttributeWithContext(ctx, attributeInput)
+	observeAPILatency("ModifyNetworkInterfaceAttribute", err, start)
 	if err != nil {
 		awsAPIErrInc("ModifyNetworkInterfaceAttribute", err)
-		err := cache.FreeENI(eniID)
+		err := cache.FreeENI(ctx, eniID)
 		if err != nil {
 			awsUtilsErrInc("ENICleanupUponModifyNetworkErr", err)
 		}
@@ -636,9 +853,9 @@ func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string,
 }
 
 // return attachment id, error
-func (cache *EC2InstanceMetadataCache) attachENI(eniID string) (string, error) {
+func (cache *EC2InstanceMetadataCache) attachENI(ctx context.Context, eniID string) (string, error) {
 	// attach to instance
-	freeDevice, err := cache.awsGetFreeDeviceNumber()
+	freeDevice, err := cache.awsGetFreeDeviceNumber(ctx)
 	if err != nil {
 		return "", errors.Wrap(err, "attachENI: failed to get a free device number")
 	}
@@ -649,8 +866,8 @@ func (cache *EC2InstanceMetadataCache) attachENI(eniID string) (string, error) {
 		NetworkInterfaceId: aws.String(eniID),
 	}
 	start := time.Now()
-	attachOutput, err := cache.ec2SVC.AttachNetworkInterface(attachInput)
-	awsAPILatency.WithLabelValues("AttachNetworkInterface", fmt.Sprint(err != nil)).Observe(msSince(start))
+	attachOutput, err := cache.ec2SVC.AttachNetworkInterfaceWithContext(ctx, attachInput)
+	observeAPILatency("AttachNetworkInterface", err, start)
 	if err != nil {
 		awsAPIErrInc("AttachNetworkInterface", err)
 		log.Errorf("Failed to attach ENI %s: %v", eniID, err)
@@ -660,8 +877,9 @@ func (cache *EC2InstanceMetadataCache) attachENI(eniID string) (string, error) {
 }
 
 // return ENI id, error
-func (cache *EC2InstanceMetadataCache) createENI(useCustomCfg bool, sg []*string, subnet string) (string, error) {
-	eniDescription := eniDescriptionPrefix + cache.instanceID
+// createENI creates an ENI, and returns its ID and subnet
+func (cache *EC2InstanceMetadataCache) createENI(ctx context.Context, useCustomCfg bool, sg []*string, subnet string) (string, string, error) {
+	eniDescription := cache.eniDescription()
 	input := &ec2.CreateNetworkInterfaceInput{
 		Description: aws.String(eniDescription),
 		Groups:      cache.securityGroups,
@@ -672,6 +890,9 @@ func (cache *EC2InstanceMetadataCache) createENI(useCustomCfg bool, sg []*string
 		log.Info("Using a custom network config for the new ENI")
 		input.Groups = sg
 		input.SubnetId = aws.String(subnet)
+	} else if subnet != "" {
+		log.Info("Using the security groups of the primary interface for the new ENI")
+		input.SubnetId = aws.String(subnet)
 	} else {
 		log.Info("Using same config as the primary interface for the new ENI")
 	}
@@ -679,20 +900,57 @@ func (cache *EC2InstanceMetadataCache) createENI(useCustomCfg bool, sg []*string
 	for i := range input.Groups {
 		sgs = append(sgs, *input.Groups[i])
 	}
-	log.Infof("Creating ENI with security groups: %v in subnet: %s", sgs, *input.SubnetId)
+	log.Infof("Creating ENI with security groups: %v in subnet: %s, description: %s", sgs, *input.SubnetId, eniDescription)
 	start := time.Now()
-	result, err := cache.ec2SVC.CreateNetworkInterface(input)
-	awsAPILatency.WithLabelValues("CreateNetworkInterface", fmt.Sprint(err != nil)).Observe(msSince(start))
+	result, err := cache.ec2SVC.CreateNetworkInterfaceWithContext(ctx, input)
+	observeAPILatency("CreateNetworkInterface", err, start)
 	if err != nil {
 		awsAPIErrInc("CreateNetworkInterface", err)
 		log.Errorf("Failed to CreateNetworkInterface %v", err)
-		return "", errors.Wrap(err, "failed to create network interface")
+		return "", "", errors.Wrap(err, "failed to create network interface")
 	}
 	log.Infof("Created a new ENI: %s", aws.StringValue(result.NetworkInterface.NetworkInterfaceId))
-	return aws.StringValue(result.NetworkInterface.NetworkInterfaceId), nil
+	return aws.StringValue(result.NetworkInterface.NetworkInterfaceId), aws.StringValue(input.SubnetId), nil
+}
+
+// TagENI tags the ENI in subnetID with the tags of the ENIs created by ipamd
+func (cache *EC2InstanceMetadataCache) TagENI(ctx context.Context, eniID string, subnetID string) error {
+	return cache.tagENI(ctx, eniID, subnetID, maxENIBackoffDelay)
+}
+
+func (cache *EC2InstanceMetadataCache) tagENI(ctx context.Context, eniID string, subnetID string, maxBackoffDelay time.Duration) error {
+	tags, err := cache.eniTags(subnetID)
+	if err != nil {
+		log.Warnf("Failed to add additional tags to the newly created ENI %s: %v", eniID, err)
+	}
+
+	for _, tag := range tags {
+		log.Debugf("Trying to tag newly created ENI: key=%s, value=%s", aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+	}
+
+	input := &ec2.CreateTagsInput{
+		Resources: []*string{
+			aws.String(eniID),
+		},
+		Tags: tags,
+	}
+
+	return retry.RetryNWithBackoffCtx(ctx, retry.NewSimpleBackoff(500*time.Millisecond, maxBackoffDelay, 0.3, 2), 5, func() error {
+		start := time.Now()
+		_, err := cache.ec2SVC.CreateTagsWithContext(ctx, input)
+		observeAPILatency("CreateTags", err, start)
+		if err != nil {
+			awsAPIErrInc("CreateTags", err)
+			return log.Warnf("Failed to tag the newly created ENI %s: %v", eniID, err)
+		}
+		log.Debugf("Successfully tagged ENI: %s", eniID)
+		return nil
+	})
 }
 
-func (cache *EC2InstanceMetadataCache) tagENI(eniID string, maxBackoffDelay time.Duration) {
+// eniTags returns the tags of the ENIs created by ipamd in subnetID, and the error of ADDITIONAL_ENI_TAGS if it is
+// invalid. The additional tags that would exceed the EC2 limit of tags per ENI are left out.
+func (cache *EC2InstanceMetadataCache) eniTags(subnetID string) ([]*ec2.Tag, error) {
 	// Tag the ENI with "node.k8s.amazonaws.com/instance_id=<instance_id>"
 	tags := []*ec2.Tag{
 		{
@@ -701,9 +959,9 @@ func (cache *EC2InstanceMetadataCache) tagENI(eniID string, maxBackoffDelay time
 		},
 	}
 
-	// If the CLUSTER_NAME env var is present,
+	// If the cluster name is known,
 	// tag the ENI with "cluster.k8s.amazonaws.com/name=<cluster_name>"
-	clusterName := os.Getenv(clusterNameEnvVar)
+	clusterName := cache.getClusterName()
 	if clusterName != "" {
 		tags = append(tags, &ec2.Tag{
 			Key:   aws.String(eniClusterTagKey),
@@ -711,38 +969,79 @@ func (cache *EC2InstanceMetadataCache) tagENI(eniID string, maxBackoffDelay time
 		})
 	}
 
+	// If ENI_LOCATION_TAGS is set, tag the ENI with its availability zone and subnet
+	if eniLocationTagsEnabled() {
+		if cache.availabilityZone != "" {
+			tags = append(tags, &ec2.Tag{
+				Key:   aws.String(eniZoneTagKey),
+				Value: aws.String(cache.availabilityZone),
+			})
+		}
+		if subnetID != "" {
+			tags = append(tags, &ec2.Tag{
+				Key:   aws.String(eniSubnetTagKey),
+				Value: aws.String(subnetID),
+			})
+		}
+	}
+
 	//additionalEniTags for adding additional tags on ENI
+	var err error
 	additionalEniTags := os.Getenv(additionalEniTagsEnvVar)
 	if additionalEniTags != "" {
-		tagsMap, err := parseAdditionalEniTagsMap(additionalEniTags)
-		if err != nil {
-			log.Warnf("Failed to add additional tags to the newly created ENI %s: %v", eniID, err)
-		}
+		var tagsMap map[string]string
+		tagsMap, err = parseAdditionalEniTagsMap(additionalEniTags)
 		tags = mapToTags(tagsMap, tags)
 	}
-
-	for _, tag := range tags {
-		log.Debugf("Trying to tag newly created ENI: key=%s, value=%s", aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+	if len(tags) > maxENITags {
+		log.Warnf("ENIs allow at most %d tags, ignoring %d of the %s", maxENITags, len(tags)-maxENITags,
+			additionalEniTagsEnvVar)
+		tags = tags[:maxENITags]
 	}
+	return tags, err
+}
 
-	input := &ec2.CreateTagsInput{
-		Resources: []*string{
-			aws.String(eniID),
-		},
-		Tags: tags,
-	}
+func eniLocationTagsEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv(eniLocationTagsEnvVar))
+	return err == nil && enabled
+}
 
-	_ = retry.RetryNWithBackoff(retry.NewSimpleBackoff(500*time.Millisecond, maxBackoffDelay, 0.3, 2), 5, func() error {
-		start := time.Now()
-		_, err := cache.ec2SVC.CreateTags(input)
-		awsAPILatency.WithLabelValues("CreateTags", fmt.Sprint(err != nil)).Observe(msSince(start))
-		if err != nil {
-			awsAPIErrInc("CreateTags", err)
-			return log.Warnf("Failed to tag the newly created ENI %s: %v", eniID, err)
+// ClusterInfo describes the cluster name and the tags ipamd applies to the ENIs it creates, for introspection
+type ClusterInfo struct {
+	ClusterName string `json:",omitempty"`
+	// ClusterNameSource is env, metadata or none
+	ClusterNameSource string
+	// Tags are the tags of the ENIs created by ipamd, but for the subnet tag, which depends on the ENI, and TagsError
+	// why ADDITIONAL_ENI_TAGS is ignored, if it is
+	Tags      map[string]string
+	TagsError string `json:",omitempty"`
+	// ENIDescription is the description of the ENIs created by ipamd
+	ENIDescription string
+}
+
+// GetClusterInfo returns the cluster name, where it comes from, and the tags and description of the ENIs created by
+// ipamd
+func (cache *EC2InstanceMetadataCache) GetClusterInfo() ClusterInfo {
+	info := ClusterInfo{
+		ClusterName:       cache.getClusterName(),
+		ClusterNameSource: cache.clusterNameSource,
+		Tags:              make(map[string]string),
+		ENIDescription:    cache.eniDescription(),
+	}
+	if info.ClusterNameSource == "" {
+		info.ClusterNameSource = ClusterNameSourceNone
+		if info.ClusterName != "" {
+			info.ClusterNameSource = ClusterNameSourceEnv
 		}
-		log.Debugf("Successfully tagged ENI: %s", eniID)
-		return nil
-	})
+	}
+	tags, err := cache.eniTags("")
+	if err != nil {
+		info.TagsError = err.Error()
+	}
+	for _, tag := range tags {
+		info.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return info
 }
 
 //parseAdditionalEniTagsMap will create a map for additional tags
@@ -763,7 +1062,14 @@ func mapToTags(tagsMap map[string]string, tags []*ec2.Tag) []*ec2.Tag {
 		return tags
 	}
 
-	for key, value := range tagsMap {
+	// Sort the keys, so that the same tags are left out when there are too many
+	keys := make([]string, 0, len(tagsMap))
+	for key := range tagsMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := tagsMap[key]
 		keyPrefix := reservedTagKeyPrefix
 		if strings.Contains(key, keyPrefix) {
 			log.Warnf("Additional tags has %s prefix. Ignoring %s tag as it is reserved", keyPrefix, key)
@@ -785,6 +1091,24 @@ func containsPrivateIPAddressLimitExceededError(err error) bool {
 	return false
 }
 
+// IsSubnetFullError returns whether err, or the error it wraps, is EC2 reporting that the subnet has not enough free
+// IP addresses
+func IsSubnetFullError(err error) bool {
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok {
+		return aerr.Code() == "InsufficientFreeAddressesInSubnet"
+	}
+	return false
+}
+
+// IsAttachmentLimitError returns whether err, or the error it wraps, is EC2 reporting that the instance cannot take
+// another ENI, for example because another agent attached one at the same time
+func IsAttachmentLimitError(err error) bool {
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok {
+		return aerr.Code() == "AttachmentLimitExceeded"
+	}
+	return false
+}
+
 func awsAPIErrInc(api string, err error) {
 	if aerr, ok := err.(awserr.Error); ok {
 		awsAPIErr.With(prometheus.Labels{"api": api, "error": aerr.Code()}).Inc()
@@ -796,15 +1120,15 @@ func awsUtilsErrInc(fn string, err error) {
 }
 
 // FreeENI detaches and deletes the ENI interface
-func (cache *EC2InstanceMetadataCache) FreeENI(eniName string) error {
-	return cache.freeENI(eniName, 2*time.Second, maxENIBackoffDelay)
+func (cache *EC2InstanceMetadataCache) FreeENI(ctx context.Context, eniName string) error {
+	return cache.freeENI(ctx, eniName, 2*time.Second, maxENIBackoffDelay)
 }
 
-func (cache *EC2InstanceMetadataCache) freeENI(eniName string, sleepDelayAfterDetach time.Duration, maxBackoffDelay time.Duration) error {
+func (cache *EC2InstanceMetadataCache) freeENI(ctx context.Context, eniName string, sleepDelayAfterDetach time.Duration, maxBackoffDelay time.Duration) error {
 	log.Infof("Trying to free ENI: %s", eniName)
 
 	// Find out attachment
-	_, _, attachID, err := cache.DescribeENI(eniName)
+	_, _, attachID, err := cache.DescribeENI(ctx, eniName)
 	if err != nil {
 		if err == ErrENINotFound {
 			log.Infof("ENI %s not found. It seems to be already freed", eniName)
@@ -822,10 +1146,10 @@ func (cache *EC2InstanceMetadataCache) freeENI(eniName string, sleepDelayAfterDe
 	}
 
 	// Retry detaching the ENI from the instance
-	err = retry.RetryNWithBackoff(retry.NewSimpleBackoff(time.Millisecond*200, maxBackoffDelay, 0.15, 2.0), maxENIDeleteRetries, func() error {
+	err = retry.RetryNWithBackoffCtx(ctx, retry.NewSimpleBackoff(time.Millisecond*200, maxBackoffDelay, 0.15, 2.0), maxENIDeleteRetries, func() error {
 		start := time.Now()
-		_, ec2Err := cache.ec2SVC.DetachNetworkInterface(detachInput)
-		awsAPILatency.WithLabelValues("DetachNetworkInterface", fmt.Sprint(ec2Err != nil)).Observe(msSince(start))
+		_, ec2Err := cache.ec2SVC.DetachNetworkInterfaceWithContext(ctx, detachInput)
+		observeAPILatency("DetachNetworkInterface", ec2Err, start)
 		if ec2Err != nil {
 			awsAPIErrInc("DetachNetworkInterface", ec2Err)
 			log.Errorf("Failed to detach ENI %s %v", eniName, ec2Err)
@@ -842,7 +1166,7 @@ func (cache *EC2InstanceMetadataCache) freeENI(eniName string, sleepDelayAfterDe
 
 	// It does take awhile for EC2 to detach ENI from instance, so we wait 2s before trying the delete.
 	time.Sleep(sleepDelayAfterDetach)
-	err = cache.deleteENI(eniName, maxBackoffDelay)
+	err = cache.deleteENI(ctx, eniName, maxBackoffDelay)
 	if err != nil {
 		awsUtilsErrInc("FreeENIDeleteErr", err)
 		return errors.Wrapf(err, "FreeENI: failed to free ENI: %s", eniName)
@@ -852,15 +1176,15 @@ func (cache *EC2InstanceMetadataCache) freeENI(eniName string, sleepDelayAfterDe
 	return nil
 }
 
-func (cache *EC2InstanceMetadataCache) deleteENI(eniName string, maxBackoffDelay time.Duration) error {
+func (cache *EC2InstanceMetadataCache) deleteENI(ctx context.Context, eniName string, maxBackoffDelay time.Duration) error {
 	log.Debugf("Trying to delete ENI: %s", eniName)
 	deleteInput := &ec2.DeleteNetworkInterfaceInput{
 		NetworkInterfaceId: aws.String(eniName),
 	}
-	err := retry.RetryNWithBackoff(retry.NewSimpleBackoff(time.Millisecond*500, maxBackoffDelay, 0.15, 2.0), maxENIDeleteRetries, func() error {
+	err := retry.RetryNWithBackoffCtx(ctx, retry.NewSimpleBackoff(time.Millisecond*500, maxBackoffDelay, 0.15, 2.0), maxENIDeleteRetries, func() error {
 		start := time.Now()
-		_, ec2Err := cache.ec2SVC.DeleteNetworkInterface(deleteInput)
-		awsAPILatency.WithLabelValues("DeleteNetworkInterface", fmt.Sprint(ec2Err != nil)).Observe(msSince(start))
+		_, ec2Err := cache.ec2SVC.DeleteNetworkInterfaceWithContext(ctx, deleteInput)
+		observeAPILatency("DeleteNetworkInterface", ec2Err, start)
 		if ec2Err != nil {
 			if aerr, ok := ec2Err.(awserr.Error); ok {
 				// If already deleted, we are good
@@ -881,38 +1205,61 @@ func (cache *EC2InstanceMetadataCache) deleteENI(eniName string, maxBackoffDelay
 
 // DescribeENI returns the IPv4 addresses, tags, and attachment id of the given ENI
 // return: private IP address, tags, attachment id, error
-func (cache *EC2InstanceMetadataCache) DescribeENI(eniID string) ([]*ec2.NetworkInterfacePrivateIpAddress, map[string]string, *string, error) {
+func (cache *EC2InstanceMetadataCache) DescribeENI(ctx context.Context, eniID string) ([]*ec2.NetworkInterfacePrivateIpAddress, map[string]string, *string, error) {
+	networkInterface, err := cache.describeENI(ctx, eniID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return networkInterface.PrivateIpAddresses, eniTags(eniID, networkInterface), networkInterface.Attachment.AttachmentId, nil
+}
+
+// describeENI calls DescribeNetworkInterfaces for a single ENI
+func (cache *EC2InstanceMetadataCache) describeENI(ctx context.Context, eniID string) (*ec2.NetworkInterface, error) {
 	eniIds := make([]*string, 0)
 	eniIds = append(eniIds, aws.String(eniID))
 	input := &ec2.DescribeNetworkInterfacesInput{NetworkInterfaceIds: eniIds}
 
 	start := time.Now()
-	result, err := cache.ec2SVC.DescribeNetworkInterfaces(input)
-	awsAPILatency.WithLabelValues("DescribeNetworkInterfaces", fmt.Sprint(err != nil)).Observe(msSince(start))
+	result, err := cache.ec2SVC.DescribeNetworkInterfacesWithContext(ctx, input)
+	observeAPILatency("DescribeNetworkInterfaces", err, start)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			if aerr.Code() == "InvalidNetworkInterfaceID.NotFound" {
-				return nil, nil, nil, ErrENINotFound
+				return nil, ErrENINotFound
 			}
 		}
 		awsAPIErrInc("DescribeNetworkInterfaces", err)
 		log.Errorf("Failed to get ENI %s information from EC2 control plane %v", eniID, err)
-		return nil, nil, nil, errors.Wrap(err, "failed to describe network interface")
+		return nil, errors.Wrap(err, "failed to describe network interface")
 	}
-	tags := make(map[string]string, len(result.NetworkInterfaces[0].TagSet))
-	for _, tag := range result.NetworkInterfaces[0].TagSet {
+	return result.NetworkInterfaces[0], nil
+}
+
+// eniIPv6Addresses returns the IPv6 addresses of the ENI
+func eniIPv6Addresses(networkInterface *ec2.NetworkInterface) []string {
+	var addresses []string
+	for _, addr := range networkInterface.Ipv6Addresses {
+		if addr.Ipv6Address != nil {
+			addresses = append(addresses, aws.StringValue(addr.Ipv6Address))
+		}
+	}
+	return addresses
+}
+
+func eniTags(eniID string, networkInterface *ec2.NetworkInterface) map[string]string {
+	tags := make(map[string]string, len(networkInterface.TagSet))
+	for _, tag := range networkInterface.TagSet {
 		if tag.Key == nil || tag.Value == nil {
 			log.Errorf("nil tag on ENI: %v", eniID)
 			continue
 		}
 		tags[*tag.Key] = *tag.Value
 	}
-
-	return result.NetworkInterfaces[0].PrivateIpAddresses, tags, result.NetworkInterfaces[0].Attachment.AttachmentId, nil
+	return tags
 }
 
 // AllocIPAddress allocates an IP address for an ENI
-func (cache *EC2InstanceMetadataCache) AllocIPAddress(eniID string) error {
+func (cache *EC2InstanceMetadataCache) AllocIPAddress(ctx context.Context, eniID string) error {
 	log.Infof("Trying to allocate an IP address on ENI: %s", eniID)
 
 	input := &ec2.AssignPrivateIpAddressesInput{
@@ -921,8 +1268,8 @@ func (cache *EC2InstanceMetadataCache) AllocIPAddress(eniID string) error {
 	}
 
 	start := time.Now()
-	output, err := cache.ec2SVC.AssignPrivateIpAddresses(input)
-	awsAPILatency.WithLabelValues("AssignPrivateIpAddresses", fmt.Sprint(err != nil)).Observe(msSince(start))
+	output, err := cache.ec2SVC.AssignPrivateIpAddressesWithContext(ctx, input)
+	observeAPILatency("AssignPrivateIpAddresses", err, start)
 	if err != nil {
 		awsAPIErrInc("AssignPrivateIpAddresses", err)
 		log.Errorf("Failed to allocate a private IP address  %v", err)
@@ -969,14 +1316,15 @@ func (cache *EC2InstanceMetadataCache) GetENILimit() (int, error) {
 	return eniLimit, nil
 }
 
-// AllocIPAddresses allocates numIPs of IP address on an ENI
-func (cache *EC2InstanceMetadataCache) AllocIPAddresses(eniID string, numIPs int) error {
+// AllocIPAddresses allocates numIPs of IP address on an ENI, and returns the IP addresses assigned. No IP address is
+// returned when the ENI cannot take more.
+func (cache *EC2InstanceMetadataCache) AllocIPAddresses(ctx context.Context, eniID string, numIPs int) ([]string, error) {
 	var needIPs = numIPs
 
 	ipLimit, err := cache.GetENIipLimit()
 	if err != nil {
 		awsUtilsErrInc("UnknownInstanceType", err)
-		return err
+		return nil, err
 	}
 
 	if ipLimit < needIPs {
@@ -985,7 +1333,7 @@ func (cache *EC2InstanceMetadataCache) AllocIPAddresses(eniID string, numIPs int
 
 	// If we don't need any more IPs, exit
 	if needIPs < 1 {
-		return nil
+		return nil, nil
 	}
 
 	log.Infof("Trying to allocate %d IP addresses on ENI %s", needIPs, eniID)
@@ -995,22 +1343,31 @@ func (cache *EC2InstanceMetadataCache) AllocIPAddresses(eniID string, numIPs int
 	}
 
 	start := time.Now()
-	_, err = cache.ec2SVC.AssignPrivateIpAddresses(input)
-	awsAPILatency.WithLabelValues("AssignPrivateIpAddresses", fmt.Sprint(err != nil)).Observe(msSince(start))
+	output, err := cache.ec2SVC.AssignPrivateIpAddressesWithContext(ctx, input)
+	observeAPILatency("AssignPrivateIpAddresses", err, start)
 	if err != nil {
 		awsAPIErrInc("AssignPrivateIpAddresses", err)
 		if containsPrivateIPAddressLimitExceededError(err) {
-			return nil
+			return nil, nil
 		}
 		log.Errorf("Failed to allocate a private IP address %v", err)
-		return errors.Wrap(err, "allocate IP address: failed to allocate a private IP address")
+		return nil, errors.Wrap(err, "allocate IP address: failed to allocate a private IP address")
 	}
-	return nil
+	if output == nil {
+		return nil, nil
+	}
+	ips := make([]string, 0, len(output.AssignedPrivateIpAddresses))
+	for _, addr := range output.AssignedPrivateIpAddresses {
+		ips = append(ips, aws.StringValue(addr.PrivateIpAddress))
+	}
+	if len(ips) < needIPs {
+		log.Warnf("Only %d of the %d IP addresses requested were allocated on ENI %s", len(ips), needIPs, eniID)
+	}
+	return ips, nil
 }
 
 // DeallocIPAddresses allocates numIPs of IP address on an ENI
-func (cache *EC2InstanceMetadataCache) DeallocIPAddresses(eniID string, ips []string) error {
-	ctx := context.Background()
+func (cache *EC2InstanceMetadataCache) DeallocIPAddresses(ctx context.Context, eniID string, ips []string) error {
 	log.Infof("Trying to unassign the following IPs %s from ENI %s", ips, eniID)
 	var ipsInput []*string
 	for _, ip := range ips {
@@ -1024,7 +1381,7 @@ func (cache *EC2InstanceMetadataCache) DeallocIPAddresses(eniID string, ips []st
 
 	start := time.Now()
 	_, err := cache.ec2SVC.UnassignPrivateIpAddressesWithContext(ctx, input)
-	awsAPILatency.WithLabelValues("UnassignPrivateIpAddressesWithContext", fmt.Sprint(err != nil)).Observe(msSince(start))
+	observeAPILatency("UnassignPrivateIpAddressesWithContext", err, start)
 	if err != nil {
 		awsAPIErrInc("UnassignPrivateIpAddressesWithContext", err)
 		log.Errorf("Failed to deallocate a private IP address %v", err)
@@ -1047,7 +1404,7 @@ func (cache *EC2InstanceMetadataCache) cleanUpLeakedENIs() {
 		// Clean up all the leaked ones we found
 		for _, networkInterface := range networkInterfaces {
 			eniID := aws.StringValue(networkInterface.NetworkInterfaceId)
-			err = cache.deleteENI(eniID, maxENIBackoffDelay)
+			err = cache.deleteENI(context.Background(), eniID, maxENIBackoffDelay)
 			if err != nil {
 				log.Warnf("Failed to clean up leaked ENI %s: %v", eniID, err)
 			}
@@ -1076,15 +1433,16 @@ func (cache *EC2InstanceMetadataCache) getFilteredListOfNetworkInterfaces() ([]*
 	input := &ec2.DescribeNetworkInterfacesInput{
 		Filters: []*ec2.Filter{tagFilter, statusFilter},
 	}
-	result, err := cache.ec2SVC.DescribeNetworkInterfaces(input)
+	result, err := cache.ec2SVC.DescribeNetworkInterfacesWithContext(context.Background(), input)
 	if err != nil {
 		return nil, errors.Wrap(err, "awsutils: unable to obtain filtered list of network interfaces")
 	}
 
 	networkInterfaces := make([]*ec2.NetworkInterface, 0)
 	for _, networkInterface := range result.NetworkInterfaces {
-		// Verify the description starts with "aws-K8S-"
-		if strings.HasPrefix(aws.StringValue(networkInterface.Description), eniDescriptionPrefix) {
+		// Verify the description starts with "aws-K8S-", or was rendered from ENI_DESCRIPTION_TEMPLATE
+		description := aws.StringValue(networkInterface.Description)
+		if strings.HasPrefix(description, eniDescriptionPrefix) || matchesENIDescriptionTemplate(description, cache.getClusterName()) {
 			networkInterfaces = append(networkInterfaces, networkInterface)
 		}
 	}
@@ -1098,6 +1456,82 @@ func (cache *EC2InstanceMetadataCache) getFilteredListOfNetworkInterfaces() ([]*
 	return networkInterfaces, nil
 }
 
+// GetBranchENIs returns the branch ENIs the VPC resource controller tagged with the ID of the trunk ENI, sorted by VLAN
+func (cache *EC2InstanceMetadataCache) GetBranchENIs(ctx context.Context, trunkENIID string) ([]BranchENI, error) {
+	input := &ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("tag:" + trunkENIIDTagKey),
+			Values: []*string{aws.String(trunkENIID)},
+		}},
+	}
+	start := time.Now()
+	result, err := cache.ec2SVC.DescribeNetworkInterfacesWithContext(ctx, input)
+	observeAPILatency("DescribeNetworkInterfaces", err, start)
+	if err != nil {
+		awsAPIErrInc("DescribeNetworkInterfaces", err)
+		return nil, errors.Wrapf(err, "failed to describe the branch ENIs of trunk ENI %s", trunkENIID)
+	}
+	branches := make([]BranchENI, 0, len(result.NetworkInterfaces))
+	for _, networkInterface := range result.NetworkInterfaces {
+		eniID := aws.StringValue(networkInterface.NetworkInterfaceId)
+		branch := BranchENI{
+			ENIID:     eniID,
+			PrivateIP: aws.StringValue(networkInterface.PrivateIpAddress),
+			SubnetID:  aws.StringValue(networkInterface.SubnetId),
+		}
+		if vlanID, ok := eniTags(eniID, networkInterface)[vlanIDTagKey]; ok {
+			if branch.VlanID, err = strconv.Atoi(vlanID); err != nil {
+				log.Warnf("Branch ENI %s has an invalid VLAN ID tag %q", eniID, vlanID)
+			}
+		}
+		branches = append(branches, branch)
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].VlanID < branches[j].VlanID
+	})
+	return branches, nil
+}
+
+// DeleteBranchENI deletes a branch ENI, which also removes its association with the trunk ENI
+func (cache *EC2InstanceMetadataCache) DeleteBranchENI(ctx context.Context, eniID string) error {
+	log.Infof("Deleting branch ENI %s", eniID)
+	return cache.deleteENI(ctx, eniID, maxENIBackoffDelay)
+}
+
+// eniDescription returns the description of the ENIs created by this node
+func (cache *EC2InstanceMetadataCache) eniDescription() string {
+	defaultDescription := eniDescriptionPrefix + cache.instanceID
+	template := os.Getenv(eniDescriptionTemplateEnvVar)
+	if template == "" {
+		return defaultDescription
+	}
+	description := strings.NewReplacer(
+		eniDescriptionClusterKey, cache.getClusterName(),
+		eniDescriptionInstanceIDKey, cache.instanceID,
+	).Replace(template)
+	if len(description) > maxENIDescriptionLength {
+		log.Warnf("ENI description %q rendered from %s is longer than %d characters, using %q instead",
+			description, eniDescriptionTemplateEnvVar, maxENIDescriptionLength, defaultDescription)
+		return defaultDescription
+	}
+	return description
+}
+
+// matchesENIDescriptionTemplate returns true if the description could have been rendered from ENI_DESCRIPTION_TEMPLATE
+// on any node of the cluster clusterName
+func matchesENIDescriptionTemplate(description, clusterName string) bool {
+	template := os.Getenv(eniDescriptionTemplateEnvVar)
+	if template == "" {
+		return false
+	}
+	pattern := strings.NewReplacer(
+		regexp.QuoteMeta(eniDescriptionClusterKey), regexp.QuoteMeta(clusterName),
+		regexp.QuoteMeta(eniDescriptionInstanceIDKey), "i-[0-9a-f]+",
+	).Replace(regexp.QuoteMeta(template))
+	matched, err := regexp.MatchString("^"+pattern+"$", description)
+	return err == nil && matched
+}
+
 // GetVPCIPv4CIDR returns VPC CIDR
 func (cache *EC2InstanceMetadataCache) GetVPCIPv4CIDR() string {
 	return cache.vpcIPv4CIDR
@@ -1122,3 +1556,132 @@ func (cache *EC2InstanceMetadataCache) GetPrimaryENI() string {
 func (cache *EC2InstanceMetadataCache) GetPrimaryENImac() string {
 	return cache.primaryENImac
 }
+
+// GetPrimaryENISubnetID returns the subnet of the primary ENI
+func (cache *EC2InstanceMetadataCache) GetPrimaryENISubnetID() string {
+	return cache.subnetID
+}
+
+// GetPrimaryENISecurityGroups returns the security groups of the primary ENI
+func (cache *EC2InstanceMetadataCache) GetPrimaryENISecurityGroups() []string {
+	return aws.StringValueSlice(cache.securityGroups)
+}
+
+// GetSubnetAvailableIPCount returns the number of free IP addresses EC2 reports for the subnet. If subnetID is
+// empty, the subnet of the primary ENI is used.
+func (cache *EC2InstanceMetadataCache) GetSubnetAvailableIPCount(ctx context.Context, subnetID string) (int, error) {
+	if subnetID == "" {
+		subnetID = cache.subnetID
+	}
+	subnet, err := cache.describeSubnet(ctx, subnetID)
+	if err != nil {
+		return 0, err
+	}
+	return int(aws.Int64Value(subnet.AvailableIpAddressCount)), nil
+}
+
+// GetAvailabilityZone returns the availability zone of the instance
+func (cache *EC2InstanceMetadataCache) GetAvailabilityZone() string {
+	return cache.availabilityZone
+}
+
+// GetSubnetAvailabilityZone returns the availability zone of a subnet
+func (cache *EC2InstanceMetadataCache) GetSubnetAvailabilityZone(ctx context.Context, subnetID string) (string, error) {
+	subnet, err := cache.describeSubnet(ctx, subnetID)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(subnet.AvailabilityZone), nil
+}
+
+func (cache *EC2InstanceMetadataCache) describeSubnet(ctx context.Context, subnetID string) (*ec2.Subnet, error) {
+	input := &ec2.DescribeSubnetsInput{SubnetIds: []*string{aws.String(subnetID)}}
+
+	start := time.Now()
+	result, err := cache.ec2SVC.DescribeSubnetsWithContext(ctx, input)
+	observeAPILatency("DescribeSubnets", err, start)
+	if err != nil {
+		awsAPIErrInc("DescribeSubnets", err)
+		log.Errorf("Failed to describe subnet %s: %v", subnetID, err)
+		return nil, errors.Wrap(err, "failed to describe subnet")
+	}
+	if len(result.Subnets) == 0 {
+		return nil, errors.Errorf("subnet %s not found", subnetID)
+	}
+	return result.Subnets[0], nil
+}
+
+// CredentialsInfo describes the credentials used for EC2 calls. It never contains secret material.
+type CredentialsInfo struct {
+	// Source is one of instance-profile, web-identity, assume-role, environment, shared-config, static or unknown
+	Source string
+	// Provider is the name of the AWS SDK credentials provider that resolved the credentials
+	Provider string
+	// RoleARN is the IAM role or instance profile, if it is known
+	RoleARN string `json:",omitempty"`
+	// Expiration is when the current credentials expire, if they do
+	Expiration *time.Time `json:",omitempty"`
+	// Error is set when the credentials could not be resolved
+	Error string `json:",omitempty"`
+}
+
+// iamInfo is the part of the instance metadata iam/info document we report
+type iamInfo struct {
+	InstanceProfileArn string
+}
+
+// credentialsSource maps an AWS SDK credentials provider name to a short description of the source
+func credentialsSource(provider string) string {
+	switch {
+	case provider == ec2rolecreds.ProviderName:
+		return "instance-profile"
+	case provider == stscreds.WebIdentityProviderName:
+		return "web-identity"
+	case provider == stscreds.ProviderName:
+		return "assume-role"
+	case provider == credentials.EnvProviderName:
+		return "environment"
+	case strings.HasPrefix(provider, credentials.SharedCredsProviderName):
+		return "shared-config"
+	case provider == credentials.StaticProviderName:
+		return "static"
+	}
+	return "unknown"
+}
+
+// GetCredentialsInfo resolves the credentials of the EC2 client and reports their source, role and expiry
+func (cache *EC2InstanceMetadataCache) GetCredentialsInfo() CredentialsInfo {
+	info := CredentialsInfo{Source: "unknown"}
+	if cache.credentials == nil {
+		info.Error = "no credentials configured"
+		return info
+	}
+	value, err := cache.credentials.Get()
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Provider = value.ProviderName
+	info.Source = credentialsSource(value.ProviderName)
+	if expiration, err := cache.credentials.ExpiresAt(); err == nil && !expiration.IsZero() {
+		info.Expiration = &expiration
+	}
+
+	switch info.Source {
+	case "web-identity":
+		info.RoleARN = os.Getenv("AWS_ROLE_ARN")
+	case "instance-profile":
+		doc, err := cache.ec2Metadata.GetMetadata(metadataIAMInfo)
+		if err != nil {
+			log.Debugf("Failed to retrieve IAM info from instance metadata: %v", err)
+			break
+		}
+		var iam iamInfo
+		if err := json.Unmarshal([]byte(doc), &iam); err != nil {
+			log.Debugf("Failed to parse IAM info from instance metadata: %v", err)
+			break
+		}
+		info.RoleARN = iam.InstanceProfileArn
+	}
+	return info
+}
@@ -14,9 +14,13 @@
 package awsutils
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,6 +30,10 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 
 	mock_ec2metadata "github.com/aws/amazon-vpc-cni-k8s/pkg/ec2metadata/mocks"
 	mock_ec2wrapper "github.com/aws/amazon-vpc-cni-k8s/pkg/ec2wrapper/mocks"
@@ -83,10 +91,12 @@ func TestInitWithEC2metadata(t *testing.T) {
 	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataSubnetID).Return(subnetID, nil)
 	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataVPCcidr).Return(vpcCIDR, nil)
 	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataVPCcidrs).Return(vpcCIDR, nil)
+	mockMetadata.EXPECT().GetMetadata(metadataClusterNameTag).Return("", errors.New("not found"))
 
 	ins := &EC2InstanceMetadataCache{ec2Metadata: mockMetadata}
 	err := ins.initWithEC2Metadata()
 	assert.NoError(t, err)
+	assert.Equal(t, ClusterNameSourceNone, ins.clusterNameSource)
 	assert.Equal(t, az, ins.availabilityZone)
 	assert.Equal(t, localIP, ins.localIPv4)
 	assert.Equal(t, ins.instanceID, instanceID)
@@ -226,6 +236,39 @@ func TestInitWithEC2metadataAZErr(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestWaitForPrimaryENIMetadata(t *testing.T) {
+	ctrl, mockMetadata, _ := setup(t)
+	defer ctrl.Finish()
+
+	// The subnet shows up on the third read
+	gomock.InOrder(
+		mockMetadata.EXPECT().GetMetadata(metadataMAC).Return("", errors.New("not found")),
+		mockMetadata.EXPECT().GetMetadata(metadataMAC).Return(primaryMAC, nil),
+		mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataSubnetID).Return("", nil),
+		mockMetadata.EXPECT().GetMetadata(metadataMAC).Return(primaryMAC, nil),
+		mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataSubnetID).Return(subnetID, nil),
+	)
+	ins := &EC2InstanceMetadataCache{ec2Metadata: mockMetadata}
+	ins.waitForPrimaryENIMetadata(time.Second, time.Millisecond)
+	info := ins.GetMetadataWaitInfo()
+	assert.True(t, info.Ready)
+	assert.Equal(t, 3, info.Attempts)
+	assert.Equal(t, "", info.LastError)
+
+	// The wait is bounded
+	mockMetadata.EXPECT().GetMetadata(metadataMAC).Return("", errors.New("not found")).MinTimes(1)
+	ins.waitForPrimaryENIMetadata(10*time.Millisecond, time.Millisecond)
+	info = ins.GetMetadataWaitInfo()
+	assert.False(t, info.Ready)
+	assert.Equal(t, 10*time.Millisecond, info.Timeout)
+	assert.True(t, info.Waited <= 10*time.Millisecond)
+	assert.Contains(t, info.LastError, "not found")
+
+	// No wait
+	ins.waitForPrimaryENIMetadata(0, time.Millisecond)
+	assert.Equal(t, 0, ins.GetMetadataWaitInfo().Attempts)
+}
+
 func TestSetPrimaryENs(t *testing.T) {
 	ctrl, mockMetadata, _ := setup(t)
 	defer ctrl.Finish()
@@ -245,23 +288,40 @@ func TestSetPrimaryENs(t *testing.T) {
 	assert.Equal(t, ins.primaryENI, primaryeniID)
 }
 
+func TestGetENIMACs(t *testing.T) {
+	ctrl, mockMetadata, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockMetadata.EXPECT().GetMetadata(metadataMACPath).Return(primaryMAC+"/ "+eni2MAC+"/", nil)
+	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataInterface).Return(primaryeniID, nil)
+	mockMetadata.EXPECT().GetMetadata(metadataMACPath+eni2MAC+metadataInterface).Return(eni2ID, nil)
+
+	ins := &EC2InstanceMetadataCache{ec2Metadata: mockMetadata}
+	eniMACs, err := ins.GetENIMACs()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{primaryeniID: primaryMAC, eni2ID: eni2MAC}, eniMACs)
+	assert.Equal(t, "network/interfaces/macs/"+eni2MAC+"/", ENIMetadataPath(eni2MAC))
+}
+
 func TestGetAttachedENIs(t *testing.T) {
 	ctrl, mockMetadata, mockEC2 := setup(t)
 	defer ctrl.Finish()
 
 	mockMetadata.EXPECT().GetMetadata(metadataMACPath).Return(primaryMAC+" "+eni2MAC, nil)
 
-	mockEC2.EXPECT().DescribeNetworkInterfaces(gomock.Any()).
-		DoAndReturn(func(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx aws.Context, input *ec2.DescribeNetworkInterfacesInput, opts ...request.Option) (*ec2.DescribeNetworkInterfacesOutput, error) {
 			output := []*ec2.NetworkInterface{}
 			for _, in := range input.NetworkInterfaceIds {
 				ip := ""
 				attachID := ""
+				var ipv6s []*ec2.NetworkInterfaceIpv6Address
 				switch *in {
 				case eniID:
 					ip, attachID = eni1PrivateIP, eniAttachID
 				case eni2ID:
 					ip, attachID = eni2PrivateIP, eni2AttachID
+					ipv6s = []*ec2.NetworkInterfaceIpv6Address{{Ipv6Address: aws.String("2001:db8::1")}}
 				default:
 					panic("no such id " + *in)
 				}
@@ -272,6 +332,7 @@ func TestGetAttachedENIs(t *testing.T) {
 							PrivateIpAddress: &ip,
 						},
 					},
+					Ipv6Addresses: ipv6s,
 					Attachment: &ec2.NetworkInterfaceAttachment{
 						AttachmentId: &attachID,
 					},
@@ -300,9 +361,11 @@ func TestGetAttachedENIs(t *testing.T) {
 	)
 
 	ins := &EC2InstanceMetadataCache{ec2Metadata: mockMetadata, ec2SVC: mockEC2}
-	ens, err := ins.GetAttachedENIs()
+	ens, err := ins.GetAttachedENIs(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, len(ens), 2)
+	assert.Empty(t, ens[0].IPv6Addresses)
+	assert.Equal(t, []string{"2001:db8::1"}, ens[1].IPv6Addresses)
 }
 
 func TestAWSGetFreeDeviceNumberOnErr(t *testing.T) {
@@ -310,10 +373,29 @@ func TestAWSGetFreeDeviceNumberOnErr(t *testing.T) {
 	defer ctrl.Finish()
 
 	// test error handling
-	mockEC2.EXPECT().DescribeInstances(gomock.Any()).Return(nil, errors.New("error on DescribeInstances"))
+	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("error on DescribeInstances"))
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.awsGetFreeDeviceNumber()
+	_, err := ins.awsGetFreeDeviceNumber(context.Background())
+	assert.Error(t, err)
+}
+
+func TestGetSubnetAvailabilityZone(t *testing.T) {
+	ctrl, _, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	mockEC2.EXPECT().DescribeSubnetsWithContext(gomock.Any(), &ec2.DescribeSubnetsInput{SubnetIds: []*string{aws.String(subnetID)}}).
+		Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{{
+			SubnetId:         aws.String(subnetID),
+			AvailabilityZone: aws.String(az),
+		}}}, nil)
+	mockEC2.EXPECT().DescribeSubnetsWithContext(gomock.Any(), gomock.Any()).Return(&ec2.DescribeSubnetsOutput{}, nil)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
+	subnetAZ, err := ins.GetSubnetAvailabilityZone(context.Background(), subnetID)
+	assert.NoError(t, err)
+	assert.Equal(t, az, subnetAZ)
+	_, err = ins.GetSubnetAvailabilityZone(context.Background(), "subnet-unknown")
 	assert.Error(t, err)
 }
 
@@ -335,10 +417,10 @@ func TestAWSGetFreeDeviceNumberNoDevice(t *testing.T) {
 	result := &ec2.DescribeInstancesOutput{
 		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{NetworkInterfaces: ec2ENIs}}}}}
 
-	mockEC2.EXPECT().DescribeInstances(gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any()).Return(result, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.awsGetFreeDeviceNumber()
+	_, err := ins.awsGetFreeDeviceNumber(context.Background())
 	assert.Error(t, err)
 }
 
@@ -369,10 +451,10 @@ func TestDescribeENI(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		mockEC2.EXPECT().DescribeNetworkInterfaces(gomock.Any()).Return(result, tc.awsErr)
+		mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).Return(result, tc.awsErr)
 
 		ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-		_, tags, id, err := ins.DescribeENI("test-eni")
+		_, tags, id, err := ins.DescribeENI(context.Background(), "test-eni")
 		assert.Equal(t, tc.expErr, err, tc.name)
 		assert.Equal(t, tc.expID, id, tc.name)
 		assert.Equal(t, tc.exptags, tags, tc.name)
@@ -395,16 +477,17 @@ func TestTagEni(t *testing.T) {
 	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataSubnetID).Return(subnetID, nil)
 	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataVPCcidr).Return(vpcCIDR, nil)
 	mockMetadata.EXPECT().GetMetadata(metadataMACPath+primaryMAC+metadataVPCcidrs).Return(vpcCIDR, nil)
+	mockMetadata.EXPECT().GetMetadata(metadataClusterNameTag).Return("", errors.New("not found"))
 
 	ins := &EC2InstanceMetadataCache{ec2Metadata: mockMetadata, ec2SVC: mockEC2}
 	err := ins.initWithEC2Metadata()
 	assert.NoError(t, err)
-	mockEC2.EXPECT().CreateTags(gomock.Any()).Return(nil, errors.New("tagging failed"))
-	mockEC2.EXPECT().CreateTags(gomock.Any()).Return(nil, errors.New("tagging failed"))
-	mockEC2.EXPECT().CreateTags(gomock.Any()).Return(nil, errors.New("tagging failed"))
-	mockEC2.EXPECT().CreateTags(gomock.Any()).Return(nil, errors.New("tagging failed"))
-	mockEC2.EXPECT().CreateTags(gomock.Any()).Return(nil, nil)
-	ins.tagENI(eniID, time.Millisecond)
+	mockEC2.EXPECT().CreateTagsWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("tagging failed"))
+	mockEC2.EXPECT().CreateTagsWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("tagging failed"))
+	mockEC2.EXPECT().CreateTagsWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("tagging failed"))
+	mockEC2.EXPECT().CreateTagsWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("tagging failed"))
+	mockEC2.EXPECT().CreateTagsWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
+	ins.tagENI(context.Background(), eniID, subnetID, time.Millisecond)
 	assert.NoError(t, err)
 }
 
@@ -424,14 +507,54 @@ func TestAdditionalTagsEni(t *testing.T) {
 		NetworkInterfaces: []*ec2.NetworkInterface{{TagSet: []*ec2.Tag{&tag}}}}
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	mockEC2.EXPECT().CreateTags(gomock.Any()).Return(nil, nil)
-	ins.tagENI(currentENIID, time.Millisecond)
+	mockEC2.EXPECT().CreateTagsWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
+	ins.tagENI(context.Background(), currentENIID, subnetID, time.Millisecond)
 
 	// Verify the tags are registered.
 	assert.Equal(t, aws.StringValue(result.NetworkInterfaces[0].TagSet[0].Key), tagKey1)
 	assert.Equal(t, aws.StringValue(result.NetworkInterfaces[0].TagSet[0].Value), tagValue1)
 }
 
+func TestENILocationTags(t *testing.T) {
+	defer os.Unsetenv(eniLocationTagsEnvVar)
+	defer os.Unsetenv(additionalEniTagsEnvVar)
+	_ = os.Unsetenv(clusterNameEnvVar)
+	_ = os.Unsetenv(additionalEniTagsEnvVar)
+	ins := &EC2InstanceMetadataCache{instanceID: instanceID, availabilityZone: az, clusterNameSource: ClusterNameSourceNone}
+
+	tagsOf := func(tags []*ec2.Tag) map[string]string {
+		m := make(map[string]string)
+		for _, tag := range tags {
+			m[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		return m
+	}
+
+	tags, err := ins.eniTags(subnetID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{eniNodeTagKey: instanceID}, tagsOf(tags))
+
+	_ = os.Setenv(eniLocationTagsEnvVar, "true")
+	tags, err = ins.eniTags(subnetID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{eniNodeTagKey: instanceID, eniZoneTagKey: az, eniSubnetTagKey: subnetID},
+		tagsOf(tags))
+
+	// The additional tags over the EC2 limit are left out, the location tags are kept
+	additionalTags := make(map[string]string)
+	for i := 0; i < maxENITags; i++ {
+		additionalTags[fmt.Sprintf("key-%02d", i)] = "value"
+	}
+	additionalTagsJSON, _ := json.Marshal(additionalTags)
+	_ = os.Setenv(additionalEniTagsEnvVar, string(additionalTagsJSON))
+	tags, err = ins.eniTags(subnetID)
+	assert.NoError(t, err)
+	assert.Len(t, tags, maxENITags)
+	assert.Equal(t, subnetID, tagsOf(tags)[eniSubnetTagKey])
+	assert.Equal(t, "value", tagsOf(tags)["key-46"])
+	assert.NotContains(t, tagsOf(tags), "key-47")
+}
+
 func TestMapToTags(t *testing.T) {
 	tagKey1 := "tagKey1"
 	tagKey2 := "tagKey2"
@@ -457,13 +580,129 @@ func TestMapToTags(t *testing.T) {
 	assert.Equal(t, aws.StringValue(tags[1].Value), tagValue2)
 }
 
+func TestENIDescription(t *testing.T) {
+	defer os.Unsetenv(eniDescriptionTemplateEnvVar)
+	defer os.Unsetenv(clusterNameEnvVar)
+	ins := &EC2InstanceMetadataCache{instanceID: "i-0123456789abcdef0"}
+
+	assert.Equal(t, "aws-K8S-i-0123456789abcdef0", ins.eniDescription())
+
+	_ = os.Setenv(clusterNameEnvVar, "prod")
+	_ = os.Setenv(eniDescriptionTemplateEnvVar, "eks-{cluster}-{instance-id}")
+	assert.Equal(t, "eks-prod-i-0123456789abcdef0", ins.eniDescription())
+	assert.True(t, matchesENIDescriptionTemplate("eks-prod-i-0fedcba987654321", "prod"))
+	assert.False(t, matchesENIDescriptionTemplate("eks-dev-i-0fedcba987654321", "prod"))
+	assert.False(t, matchesENIDescriptionTemplate("eks-prod-i-0fedcba987654321-extra", "prod"))
+
+	// Longer than EC2 allows
+	_ = os.Setenv(eniDescriptionTemplateEnvVar, strings.Repeat("x", maxENIDescriptionLength+1))
+	assert.Equal(t, "aws-K8S-i-0123456789abcdef0", ins.eniDescription())
+}
+
+func TestGetClusterInfo(t *testing.T) {
+	ctrl, mockMetadata, _ := setup(t)
+	defer ctrl.Finish()
+	defer os.Unsetenv(clusterNameEnvVar)
+	defer os.Unsetenv(additionalEniTagsEnvVar)
+	_ = os.Unsetenv(clusterNameEnvVar)
+	_ = os.Unsetenv(additionalEniTagsEnvVar)
+
+	// From the instance metadata
+	mockMetadata.EXPECT().GetMetadata(metadataClusterNameTag).Return("prod", nil)
+	ins := &EC2InstanceMetadataCache{ec2Metadata: mockMetadata, instanceID: instanceID}
+	ins.resolveClusterName()
+	info := ins.GetClusterInfo()
+	assert.Equal(t, "prod", info.ClusterName)
+	assert.Equal(t, ClusterNameSourceMetadata, info.ClusterNameSource)
+	assert.Equal(t, map[string]string{eniNodeTagKey: instanceID, eniClusterTagKey: "prod"}, info.Tags)
+	assert.Equal(t, "aws-K8S-"+instanceID, info.ENIDescription)
+
+	// CLUSTER_NAME takes precedence, the reserved additional tags are left out
+	_ = os.Setenv(clusterNameEnvVar, "dev")
+	_ = os.Setenv(additionalEniTagsEnvVar, `{"team": "net", "k8s.amazonaws.com/x": "y"}`)
+	ins.resolveClusterName()
+	info = ins.GetClusterInfo()
+	assert.Equal(t, ClusterNameSourceEnv, info.ClusterNameSource)
+	assert.Equal(t, map[string]string{eniNodeTagKey: instanceID, eniClusterTagKey: "dev", "team": "net"}, info.Tags)
+
+	// Invalid additional tags, and no cluster name
+	_ = os.Unsetenv(clusterNameEnvVar)
+	_ = os.Setenv(additionalEniTagsEnvVar, "team=net")
+	mockMetadata.EXPECT().GetMetadata(metadataClusterNameTag).Return("", errors.New("not found"))
+	ins.resolveClusterName()
+	info = ins.GetClusterInfo()
+	assert.Equal(t, "", info.ClusterName)
+	assert.Equal(t, ClusterNameSourceNone, info.ClusterNameSource)
+	assert.Equal(t, map[string]string{eniNodeTagKey: instanceID}, info.Tags)
+	assert.NotEmpty(t, info.TagsError)
+}
+
+func TestLegacyENISchema(t *testing.T) {
+	defer os.Unsetenv(legacyENITagKeysEnvVar)
+	ins := &EC2InstanceMetadataCache{instanceID: "i-0123456789abcdef0"}
+	legacyDescription := "aws-K8S-i-0123456789abcdef0"
+
+	assert.Equal(t, LegacyENISchemaUntagged, ins.legacyENISchema(1, legacyDescription, map[string]string{}))
+	// Tagged by this version, the primary ENI, or not created by the CNI
+	assert.Equal(t, "", ins.legacyENISchema(1, legacyDescription, map[string]string{eniNodeTagKey: "i-0123456789abcdef0"}))
+	assert.Equal(t, "", ins.legacyENISchema(0, legacyDescription, map[string]string{}))
+	assert.Equal(t, "", ins.legacyENISchema(1, "aws-K8S-i-0fedcba987654321", map[string]string{}))
+	assert.Equal(t, "", ins.legacyENISchema(1, "", map[string]string{"k8s.amazonaws.com/instance_id": "i-0123456789abcdef0"}))
+
+	_ = os.Setenv(legacyENITagKeysEnvVar, "other, k8s.amazonaws.com/instance_id")
+	assert.Equal(t, "k8s.amazonaws.com/instance_id",
+		ins.legacyENISchema(1, "", map[string]string{"k8s.amazonaws.com/instance_id": "i-0123456789abcdef0"}))
+	assert.Equal(t, "", ins.legacyENISchema(1, "", map[string]string{"k8s.amazonaws.com/instance_id": "i-0fedcba987654321"}))
+}
+
+func TestGetCredentialsInfo(t *testing.T) {
+	ctrl, mockMetadata, _ := setup(t)
+	defer ctrl.Finish()
+
+	ins := &EC2InstanceMetadataCache{ec2Metadata: mockMetadata}
+	info := ins.GetCredentialsInfo()
+	assert.Equal(t, "unknown", info.Source)
+	assert.NotEmpty(t, info.Error)
+
+	ins.credentials = credentials.NewStaticCredentials("AKID", "SECRET", "TOKEN")
+	info = ins.GetCredentialsInfo()
+	assert.Equal(t, "static", info.Source)
+	assert.Equal(t, credentials.StaticProviderName, info.Provider)
+	assert.Nil(t, info.Expiration)
+
+	mockMetadata.EXPECT().GetMetadata(metadataIAMInfo).Return(`{"Code":"Success","InstanceProfileArn":"arn:aws:iam::123456789012:instance-profile/node"}`, nil)
+	ins.credentials = credentials.NewCredentials(&stubProvider{providerName: ec2rolecreds.ProviderName})
+	info = ins.GetCredentialsInfo()
+	assert.Equal(t, "instance-profile", info.Source)
+	assert.Equal(t, "arn:aws:iam::123456789012:instance-profile/node", info.RoleARN)
+
+	_ = os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/aws-node")
+	defer os.Unsetenv("AWS_ROLE_ARN")
+	ins.credentials = credentials.NewCredentials(&stubProvider{providerName: stscreds.WebIdentityProviderName})
+	info = ins.GetCredentialsInfo()
+	assert.Equal(t, "web-identity", info.Source)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/aws-node", info.RoleARN)
+}
+
+type stubProvider struct {
+	providerName string
+}
+
+func (s *stubProvider) Retrieve() (credentials.Value, error) {
+	return credentials.Value{AccessKeyID: "AKID", SecretAccessKey: "SECRET", ProviderName: s.providerName}, nil
+}
+
+func (s *stubProvider) IsExpired() bool {
+	return false
+}
+
 func TestAllocENI(t *testing.T) {
 	ctrl, _, mockEC2 := setup(t)
 	defer ctrl.Finish()
 
 	cureniID := eniID
 	eni := ec2.CreateNetworkInterfaceOutput{NetworkInterface: &ec2.NetworkInterface{NetworkInterfaceId: &cureniID}}
-	mockEC2.EXPECT().CreateNetworkInterface(gomock.Any()).Return(&eni, nil)
+	mockEC2.EXPECT().CreateNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(&eni, nil)
 
 	// 2 ENIs, uses device number 0 3, expect to find free at 1
 	ec2ENIs := make([]*ec2.InstanceNetworkInterface, 0)
@@ -482,26 +721,45 @@ func TestAllocENI(t *testing.T) {
 	result := &ec2.DescribeInstancesOutput{
 		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{NetworkInterfaces: ec2ENIs}}}}}
 
-	mockEC2.EXPECT().DescribeInstances(gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any()).Return(result, nil)
 	attachmentID := "eni-attach-58ddda9d"
 	attachResult := &ec2.AttachNetworkInterfaceOutput{
 		AttachmentId: &attachmentID}
-	mockEC2.EXPECT().AttachNetworkInterface(gomock.Any()).Return(attachResult, nil)
-	mockEC2.EXPECT().CreateTags(gomock.Any()).Return(nil, nil)
-	mockEC2.EXPECT().ModifyNetworkInterfaceAttribute(gomock.Any()).Return(nil, nil)
+	mockEC2.EXPECT().AttachNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(attachResult, nil)
+	mockEC2.EXPECT().CreateTagsWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
+	mockEC2.EXPECT().ModifyNetworkInterfaceAttributeWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.AllocENI(false, nil, "")
+	_, err := ins.AllocENI(context.Background(), false, nil, "")
 	assert.NoError(t, err)
 }
 
+func TestCreateENIInSubnet(t *testing.T) {
+	ctrl, _, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	cureniID := eniID
+	eni := ec2.CreateNetworkInterfaceOutput{NetworkInterface: &ec2.NetworkInterface{NetworkInterfaceId: &cureniID}}
+	mockEC2.EXPECT().CreateNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx aws.Context, input *ec2.CreateNetworkInterfaceInput, opts ...request.Option) (*ec2.CreateNetworkInterfaceOutput, error) {
+		assert.Equal(t, "subnet-pinned", aws.StringValue(input.SubnetId))
+		assert.Equal(t, []string{sg1, sg2}, aws.StringValueSlice(input.Groups))
+		return &eni, nil
+	})
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, subnetID: subnetID, securityGroups: aws.StringSlice([]string{sg1, sg2})}
+	id, subnet, err := ins.createENI(context.Background(), false, nil, "subnet-pinned")
+	assert.NoError(t, err)
+	assert.Equal(t, eniID, id)
+	assert.Equal(t, "subnet-pinned", subnet)
+}
+
 func TestAllocENINoFreeDevice(t *testing.T) {
 	ctrl, _, mockEC2 := setup(t)
 	defer ctrl.Finish()
 
 	cureniID := eniID
 	eni := ec2.CreateNetworkInterfaceOutput{NetworkInterface: &ec2.NetworkInterface{NetworkInterfaceId: &cureniID}}
-	mockEC2.EXPECT().CreateNetworkInterface(gomock.Any()).Return(&eni, nil)
+	mockEC2.EXPECT().CreateNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(&eni, nil)
 
 	// test no free index
 	ec2ENIs := make([]*ec2.InstanceNetworkInterface, 0)
@@ -517,11 +775,11 @@ func TestAllocENINoFreeDevice(t *testing.T) {
 	result := &ec2.DescribeInstancesOutput{
 		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{NetworkInterfaces: ec2ENIs}}}}}
 
-	mockEC2.EXPECT().DescribeInstances(gomock.Any()).Return(result, nil)
-	mockEC2.EXPECT().DeleteNetworkInterface(gomock.Any()).Return(nil, nil)
+	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.AllocENI(false, nil, "")
+	_, err := ins.AllocENI(context.Background(), false, nil, "")
 	assert.Error(t, err)
 }
 
@@ -531,7 +789,7 @@ func TestAllocENIMaxReached(t *testing.T) {
 
 	cureniID := eniID
 	eni := ec2.CreateNetworkInterfaceOutput{NetworkInterface: &ec2.NetworkInterface{NetworkInterfaceId: &cureniID}}
-	mockEC2.EXPECT().CreateNetworkInterface(gomock.Any()).Return(&eni, nil)
+	mockEC2.EXPECT().CreateNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(&eni, nil)
 
 	// 2 ENIs, uses device number 0 3, expect to find free at 1
 	ec2ENIs := make([]*ec2.InstanceNetworkInterface, 0)
@@ -550,12 +808,12 @@ func TestAllocENIMaxReached(t *testing.T) {
 	result := &ec2.DescribeInstancesOutput{
 		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{NetworkInterfaces: ec2ENIs}}}}}
 
-	mockEC2.EXPECT().DescribeInstances(gomock.Any()).Return(result, nil)
-	mockEC2.EXPECT().AttachNetworkInterface(gomock.Any()).Return(nil, errors.New("AttachmentLimitExceeded"))
-	mockEC2.EXPECT().DeleteNetworkInterface(gomock.Any()).Return(nil, nil)
+	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().AttachNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("AttachmentLimitExceeded"))
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.AllocENI(false, nil, "")
+	_, err := ins.AllocENI(context.Background(), false, nil, "")
 	assert.Error(t, err)
 }
 
@@ -567,12 +825,12 @@ func TestFreeENI(t *testing.T) {
 	attachment := &ec2.NetworkInterfaceAttachment{AttachmentId: &attachmentID}
 	result := &ec2.DescribeNetworkInterfacesOutput{
 		NetworkInterfaces: []*ec2.NetworkInterface{{Attachment: attachment}}}
-	mockEC2.EXPECT().DescribeNetworkInterfaces(gomock.Any()).Return(result, nil)
-	mockEC2.EXPECT().DetachNetworkInterface(gomock.Any()).Return(nil, nil)
-	mockEC2.EXPECT().DeleteNetworkInterface(gomock.Any()).Return(nil, nil)
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().DetachNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	err := ins.freeENI("test-eni", time.Millisecond, time.Millisecond)
+	err := ins.freeENI(context.Background(), "test-eni", time.Millisecond, time.Millisecond)
 	assert.NoError(t, err)
 }
 
@@ -584,15 +842,15 @@ func TestFreeENIRetry(t *testing.T) {
 	attachment := &ec2.NetworkInterfaceAttachment{AttachmentId: &attachmentID}
 	result := &ec2.DescribeNetworkInterfacesOutput{
 		NetworkInterfaces: []*ec2.NetworkInterface{{Attachment: attachment}}}
-	mockEC2.EXPECT().DescribeNetworkInterfaces(gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).Return(result, nil)
 
 	// retry 2 times
-	mockEC2.EXPECT().DetachNetworkInterface(gomock.Any()).Return(nil, nil)
-	mockEC2.EXPECT().DeleteNetworkInterface(gomock.Any()).Return(nil, errors.New("testing retrying delete"))
-	mockEC2.EXPECT().DeleteNetworkInterface(gomock.Any()).Return(nil, nil)
+	mockEC2.EXPECT().DetachNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("testing retrying delete"))
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	err := ins.freeENI("test-eni", time.Millisecond, time.Millisecond)
+	err := ins.freeENI(context.Background(), "test-eni", time.Millisecond, time.Millisecond)
 	assert.NoError(t, err)
 }
 
@@ -604,15 +862,38 @@ func TestFreeENIRetryMax(t *testing.T) {
 	attachment := &ec2.NetworkInterfaceAttachment{AttachmentId: &attachmentID}
 	result := &ec2.DescribeNetworkInterfacesOutput{
 		NetworkInterfaces: []*ec2.NetworkInterface{{Attachment: attachment}}}
-	mockEC2.EXPECT().DescribeNetworkInterfaces(gomock.Any()).Return(result, nil)
-	mockEC2.EXPECT().DetachNetworkInterface(gomock.Any()).Return(nil, nil)
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().DetachNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, nil)
 
 	for i := 0; i < maxENIDeleteRetries; i++ {
-		mockEC2.EXPECT().DeleteNetworkInterface(gomock.Any()).Return(nil, errors.New("testing retrying delete"))
+		mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("testing retrying delete"))
 	}
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	err := ins.freeENI("test-eni", time.Millisecond, time.Millisecond)
+	err := ins.freeENI(context.Background(), "test-eni", time.Millisecond, time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestFreeENICancelled(t *testing.T) {
+	ctrl, _, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	attachmentID := eniAttachID
+	attachment := &ec2.NetworkInterfaceAttachment{AttachmentId: &attachmentID}
+	result := &ec2.DescribeNetworkInterfacesOutput{
+		NetworkInterfaces: []*ec2.NetworkInterface{{Attachment: attachment}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(ctx, gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().DetachNetworkInterfaceWithContext(ctx, gomock.Any()).Return(nil, nil)
+	// The delete is not retried once the context is cancelled
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(ctx, gomock.Any()).DoAndReturn(
+		func(ctx aws.Context, input *ec2.DeleteNetworkInterfaceInput, opts ...request.Option) (*ec2.DeleteNetworkInterfaceOutput, error) {
+			cancel()
+			return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+		})
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
+	err := ins.freeENI(ctx, "test-eni", time.Millisecond, time.Millisecond)
 	assert.Error(t, err)
 }
 
@@ -620,10 +901,10 @@ func TestFreeENIDescribeErr(t *testing.T) {
 	ctrl, _, mockEC2 := setup(t)
 	defer ctrl.Finish()
 
-	mockEC2.EXPECT().DescribeNetworkInterfaces(gomock.Any()).Return(nil, errors.New("Error on DescribeNetworkInterfaces"))
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("Error on DescribeNetworkInterfaces"))
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	err := ins.FreeENI("test-eni")
+	err := ins.FreeENI(context.Background(), "test-eni")
 	assert.Error(t, err)
 }
 
@@ -652,10 +933,10 @@ func TestAllocIPAddress(t *testing.T) {
 	ctrl, _, mockEC2 := setup(t)
 	defer ctrl.Finish()
 
-	mockEC2.EXPECT().AssignPrivateIpAddresses(gomock.Any()).Return(&ec2.AssignPrivateIpAddressesOutput{}, nil)
+	mockEC2.EXPECT().AssignPrivateIpAddressesWithContext(gomock.Any(), gomock.Any()).Return(&ec2.AssignPrivateIpAddressesOutput{}, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	err := ins.AllocIPAddress("eni-id")
+	err := ins.AllocIPAddress(context.Background(), "eni-id")
 	assert.NoError(t, err)
 }
 
@@ -663,10 +944,10 @@ func TestAllocIPAddressOnErr(t *testing.T) {
 	ctrl, _, mockEC2 := setup(t)
 	defer ctrl.Finish()
 
-	mockEC2.EXPECT().AssignPrivateIpAddresses(gomock.Any()).Return(nil, errors.New("Error on AssignPrivateIpAddresses"))
+	mockEC2.EXPECT().AssignPrivateIpAddressesWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("Error on AssignPrivateIpAddresses"))
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	err := ins.AllocIPAddress("eni-id")
+	err := ins.AllocIPAddress(context.Background(), "eni-id")
 	assert.Error(t, err)
 }
 
@@ -679,10 +960,10 @@ func TestAllocIPAddresses(t *testing.T) {
 		NetworkInterfaceId:             aws.String("eni-id"),
 		SecondaryPrivateIpAddressCount: aws.Int64(5),
 	}
-	mockEC2.EXPECT().AssignPrivateIpAddresses(input).Return(nil, nil)
+	mockEC2.EXPECT().AssignPrivateIpAddressesWithContext(gomock.Any(), input).Return(nil, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceType: "c5n.18xlarge"}
-	err := ins.AllocIPAddresses("eni-id", 5)
+	_, err := ins.AllocIPAddresses(context.Background(), "eni-id", 5)
 	assert.NoError(t, err)
 
 	// when required IP numbers(50) is higher than ENI's limit(49)
@@ -690,15 +971,31 @@ func TestAllocIPAddresses(t *testing.T) {
 		NetworkInterfaceId:             aws.String("eni-id"),
 		SecondaryPrivateIpAddressCount: aws.Int64(49),
 	}
-	mockEC2.EXPECT().AssignPrivateIpAddresses(input).Return(nil, nil)
+	mockEC2.EXPECT().AssignPrivateIpAddressesWithContext(gomock.Any(), input).Return(nil, nil)
 
 	ins = &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceType: "c5n.18xlarge"}
-	err = ins.AllocIPAddresses("eni-id", 50)
+	_, err = ins.AllocIPAddresses(context.Background(), "eni-id", 50)
 	assert.NoError(t, err)
 
 	// Adding 0 should do nothing
-	err = ins.AllocIPAddresses("eni-id", 0)
+	ips, err := ins.AllocIPAddresses(context.Background(), "eni-id", 0)
 	assert.NoError(t, err)
+	assert.Empty(t, ips)
+
+	// Only the IP addresses EC2 assigned are returned
+	input = &ec2.AssignPrivateIpAddressesInput{
+		NetworkInterfaceId:             aws.String("eni-id"),
+		SecondaryPrivateIpAddressCount: aws.Int64(3),
+	}
+	mockEC2.EXPECT().AssignPrivateIpAddressesWithContext(gomock.Any(), input).Return(&ec2.AssignPrivateIpAddressesOutput{
+		AssignedPrivateIpAddresses: []*ec2.AssignedPrivateIpAddress{
+			{PrivateIpAddress: aws.String("10.0.0.10")},
+			{PrivateIpAddress: aws.String("10.0.0.11")},
+		},
+	}, nil)
+	ips, err = ins.AllocIPAddresses(context.Background(), "eni-id", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.10", "10.0.0.11"}, ips)
 }
 
 func TestEC2InstanceMetadataCache_getFilteredListOfNetworkInterfaces_OneResult(t *testing.T) {
@@ -713,7 +1010,7 @@ func TestEC2InstanceMetadataCache_getFilteredListOfNetworkInterfaces_OneResult(t
 	attachment := &ec2.NetworkInterfaceAttachment{AttachmentId: &attachmentID}
 	result := &ec2.DescribeNetworkInterfacesOutput{
 		NetworkInterfaces: []*ec2.NetworkInterface{{Attachment: attachment, Status: &status, TagSet: []*ec2.Tag{&tag}, Description: &description}}}
-	mockEC2.EXPECT().DescribeNetworkInterfaces(gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).Return(result, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
 	got, err := ins.getFilteredListOfNetworkInterfaces()
@@ -727,7 +1024,7 @@ func TestEC2InstanceMetadataCache_getFilteredListOfNetworkInterfaces_NoResult(t
 
 	result := &ec2.DescribeNetworkInterfacesOutput{
 		NetworkInterfaces: []*ec2.NetworkInterface{}}
-	mockEC2.EXPECT().DescribeNetworkInterfaces(gomock.Any()).Return(result, nil)
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).Return(result, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
 	got, err := ins.getFilteredListOfNetworkInterfaces()
@@ -739,10 +1036,49 @@ func TestEC2InstanceMetadataCache_getFilteredListOfNetworkInterfaces_Error(t *te
 	ctrl, _, mockEC2 := setup(t)
 	defer ctrl.Finish()
 
-	mockEC2.EXPECT().DescribeNetworkInterfaces(gomock.Any()).Return(nil, errors.New("dummy error"))
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("dummy error"))
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
 	got, err := ins.getFilteredListOfNetworkInterfaces()
 	assert.Nil(t, got)
 	assert.Error(t, err)
 }
+
+func TestGetBranchENIs(t *testing.T) {
+	ctrl, _, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	branch := func(eniID, ip, vlanID string) *ec2.NetworkInterface {
+		return &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(eniID),
+			PrivateIpAddress:   aws.String(ip),
+			SubnetId:           aws.String(subnetID),
+			TagSet: []*ec2.Tag{
+				{Key: aws.String(trunkENIIDTagKey), Value: aws.String("eni-trunk")},
+				{Key: aws.String(vlanIDTagKey), Value: aws.String(vlanID)},
+			},
+		}
+	}
+	result := &ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: []*ec2.NetworkInterface{
+		branch("eni-branch2", "10.10.30.2", "2"),
+		branch("eni-branch1", "10.10.30.1", "1"),
+	}}
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), &ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("tag:" + trunkENIIDTagKey),
+			Values: []*string{aws.String("eni-trunk")},
+		}},
+	}).Return(result, nil)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
+	branches, err := ins.GetBranchENIs(context.Background(), "eni-trunk")
+	assert.NoError(t, err)
+	assert.Equal(t, []BranchENI{
+		{ENIID: "eni-branch1", VlanID: 1, PrivateIP: "10.10.30.1", SubnetID: subnetID},
+		{ENIID: "eni-branch2", VlanID: 2, PrivateIP: "10.10.30.2", SubnetID: subnetID},
+	}, branches)
+
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("dummy error"))
+	_, err = ins.GetBranchENIs(context.Background(), "eni-trunk")
+	assert.Error(t, err)
+}
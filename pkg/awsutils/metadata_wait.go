@@ -0,0 +1,116 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// This environment variable is used to specify, in seconds, for how long ipamd waits at startup for the MAC
+	// address and the subnet of the primary ENI to be in the instance metadata, which can take a moment on instances
+	// that boot fast. When they are still missing after the wait, the startup goes on and fails as it would without
+	// the wait. When it is not set, it defaults to 30 seconds. When set to 0, ipamd does not wait.
+	primaryENIMetadataWaitEnvVar  = "PRIMARY_ENI_METADATA_WAIT"
+	defaultPrimaryENIMetadataWait = 30 * time.Second
+	primaryENIMetadataPollPeriod  = time.Second
+)
+
+// MetadataWaitInfo describes the wait at startup for the primary ENI to be in the instance metadata, for
+// introspection
+type MetadataWaitInfo struct {
+	Timeout time.Duration
+	// Waited is how long ipamd waited, over Attempts reads of the instance metadata. Ready is false when the MAC
+	// address or the subnet of the primary ENI were still missing at the end of the wait, because of LastError.
+	Waited    time.Duration
+	Attempts  int
+	Ready     bool
+	LastError string `json:",omitempty"`
+}
+
+// primaryENIMetadataReady returns nil if the instance metadata has the MAC address and the subnet of the primary ENI
+func (cache *EC2InstanceMetadataCache) primaryENIMetadataReady() error {
+	mac, err := cache.ec2Metadata.GetMetadata(metadataMAC)
+	if err != nil {
+		return errors.Wrap(err, "failed to retrieve primary interface MAC address")
+	}
+	if strings.TrimSpace(mac) == "" {
+		return errors.New("primary interface MAC address is empty")
+	}
+	subnet, err := cache.ec2Metadata.GetMetadata(metadataMACPath + mac + metadataSubnetID)
+	if err != nil {
+		return errors.Wrap(err, "failed to retrieve primary interface subnet-id")
+	}
+	if strings.TrimSpace(subnet) == "" {
+		return errors.New("primary interface subnet-id is empty")
+	}
+	return nil
+}
+
+// waitForPrimaryENIMetadata reads the instance metadata every pollPeriod until it has the MAC address and subnet of the
+// primary ENI, for at most timeout
+func (cache *EC2InstanceMetadataCache) waitForPrimaryENIMetadata(timeout time.Duration, pollPeriod time.Duration) {
+	info := MetadataWaitInfo{Timeout: timeout}
+	defer func() { cache.metadataWait = info }()
+	if timeout == 0 {
+		return
+	}
+	start := time.Now()
+	for {
+		info.Attempts++
+		err := cache.primaryENIMetadataReady()
+		info.Waited = time.Since(start)
+		if err == nil {
+			info.Ready = true
+			info.LastError = ""
+			if info.Attempts > 1 {
+				log.Infof("The primary ENI is in the instance metadata after %v", info.Waited)
+			}
+			return
+		}
+		info.LastError = err.Error()
+		if info.Waited+pollPeriod > timeout {
+			log.Errorf("The primary ENI is still not in the instance metadata after %v: %v", info.Waited, err)
+			return
+		}
+		log.Infof("Waiting for the primary ENI to be in the instance metadata: %v", err)
+		time.Sleep(pollPeriod)
+	}
+}
+
+// GetMetadataWaitInfo returns how long ipamd waited at startup for the primary ENI to be in the instance metadata
+func (cache *EC2InstanceMetadataCache) GetMetadataWaitInfo() MetadataWaitInfo {
+	return cache.metadataWait
+}
+
+func getPrimaryENIMetadataWait() time.Duration {
+	inputStr, found := os.LookupEnv(primaryENIMetadataWaitEnvVar)
+
+	if !found {
+		return defaultPrimaryENIMetadataWait
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", primaryENIMetadataWaitEnvVar, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, using default %v", primaryENIMetadataWaitEnvVar, inputStr, defaultPrimaryENIMetadataWait)
+	return defaultPrimaryENIMetadataWait
+}
@@ -18,6 +18,7 @@
 package mock_awsutils
 
 import (
+	context "context"
 	reflect "reflect"
 
 	awsutils "github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
@@ -49,57 +50,70 @@ func (m *MockAPIs) EXPECT() *MockAPIsMockRecorder {
 }
 
 // AllocENI mocks base method
-func (m *MockAPIs) AllocENI(arg0 bool, arg1 []*string, arg2 string) (string, error) {
-	ret := m.ctrl.Call(m, "AllocENI", arg0, arg1, arg2)
+func (m *MockAPIs) AllocENI(arg0 context.Context, arg1 bool, arg2 []*string, arg3 string) (string, error) {
+	ret := m.ctrl.Call(m, "AllocENI", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // AllocENI indicates an expected call of AllocENI
-func (mr *MockAPIsMockRecorder) AllocENI(arg0, arg1, arg2 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllocENI", reflect.TypeOf((*MockAPIs)(nil).AllocENI), arg0, arg1, arg2)
+func (mr *MockAPIsMockRecorder) AllocENI(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllocENI", reflect.TypeOf((*MockAPIs)(nil).AllocENI), arg0, arg1, arg2, arg3)
 }
 
 // AllocIPAddress mocks base method
-func (m *MockAPIs) AllocIPAddress(arg0 string) error {
-	ret := m.ctrl.Call(m, "AllocIPAddress", arg0)
+func (m *MockAPIs) AllocIPAddress(arg0 context.Context, arg1 string) error {
+	ret := m.ctrl.Call(m, "AllocIPAddress", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AllocIPAddress indicates an expected call of AllocIPAddress
-func (mr *MockAPIsMockRecorder) AllocIPAddress(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllocIPAddress", reflect.TypeOf((*MockAPIs)(nil).AllocIPAddress), arg0)
+func (mr *MockAPIsMockRecorder) AllocIPAddress(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllocIPAddress", reflect.TypeOf((*MockAPIs)(nil).AllocIPAddress), arg0, arg1)
 }
 
 // AllocIPAddresses mocks base method
-func (m *MockAPIs) AllocIPAddresses(arg0 string, arg1 int) error {
-	ret := m.ctrl.Call(m, "AllocIPAddresses", arg0, arg1)
-	ret0, _ := ret[0].(error)
-	return ret0
+func (m *MockAPIs) AllocIPAddresses(arg0 context.Context, arg1 string, arg2 int) ([]string, error) {
+	ret := m.ctrl.Call(m, "AllocIPAddresses", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // AllocIPAddresses indicates an expected call of AllocIPAddresses
-func (mr *MockAPIsMockRecorder) AllocIPAddresses(arg0, arg1 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllocIPAddresses", reflect.TypeOf((*MockAPIs)(nil).AllocIPAddresses), arg0, arg1)
+func (mr *MockAPIsMockRecorder) AllocIPAddresses(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllocIPAddresses", reflect.TypeOf((*MockAPIs)(nil).AllocIPAddresses), arg0, arg1, arg2)
 }
 
 // DeallocIPAddresses mocks base method
-func (m *MockAPIs) DeallocIPAddresses(arg0 string, arg1 []string) error {
-	ret := m.ctrl.Call(m, "DeallocIPAddresses", arg0, arg1)
+func (m *MockAPIs) DeallocIPAddresses(arg0 context.Context, arg1 string, arg2 []string) error {
+	ret := m.ctrl.Call(m, "DeallocIPAddresses", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeallocIPAddresses indicates an expected call of DeallocIPAddresses
-func (mr *MockAPIsMockRecorder) DeallocIPAddresses(arg0, arg1 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeallocIPAddresses", reflect.TypeOf((*MockAPIs)(nil).DeallocIPAddresses), arg0, arg1)
+func (mr *MockAPIsMockRecorder) DeallocIPAddresses(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeallocIPAddresses", reflect.TypeOf((*MockAPIs)(nil).DeallocIPAddresses), arg0, arg1, arg2)
+}
+
+// DeleteBranchENI mocks base method
+func (m *MockAPIs) DeleteBranchENI(arg0 context.Context, arg1 string) error {
+	ret := m.ctrl.Call(m, "DeleteBranchENI", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteBranchENI indicates an expected call of DeleteBranchENI
+func (mr *MockAPIsMockRecorder) DeleteBranchENI(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBranchENI", reflect.TypeOf((*MockAPIs)(nil).DeleteBranchENI), arg0, arg1)
 }
 
 // DescribeENI mocks base method
-func (m *MockAPIs) DescribeENI(arg0 string) ([]*ec2.NetworkInterfacePrivateIpAddress, map[string]string, *string, error) {
-	ret := m.ctrl.Call(m, "DescribeENI", arg0)
+func (m *MockAPIs) DescribeENI(arg0 context.Context, arg1 string) ([]*ec2.NetworkInterfacePrivateIpAddress, map[string]string, *string, error) {
+	ret := m.ctrl.Call(m, "DescribeENI", arg0, arg1)
 	ret0, _ := ret[0].([]*ec2.NetworkInterfacePrivateIpAddress)
 	ret1, _ := ret[1].(map[string]string)
 	ret2, _ := ret[2].(*string)
@@ -108,33 +122,82 @@ func (m *MockAPIs) DescribeENI(arg0 string) ([]*ec2.NetworkInterfacePrivateIpAdd
 }
 
 // DescribeENI indicates an expected call of DescribeENI
-func (mr *MockAPIsMockRecorder) DescribeENI(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeENI", reflect.TypeOf((*MockAPIs)(nil).DescribeENI), arg0)
+func (mr *MockAPIsMockRecorder) DescribeENI(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeENI", reflect.TypeOf((*MockAPIs)(nil).DescribeENI), arg0, arg1)
 }
 
 // FreeENI mocks base method
-func (m *MockAPIs) FreeENI(arg0 string) error {
-	ret := m.ctrl.Call(m, "FreeENI", arg0)
+func (m *MockAPIs) FreeENI(arg0 context.Context, arg1 string) error {
+	ret := m.ctrl.Call(m, "FreeENI", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // FreeENI indicates an expected call of FreeENI
-func (mr *MockAPIsMockRecorder) FreeENI(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FreeENI", reflect.TypeOf((*MockAPIs)(nil).FreeENI), arg0)
+func (mr *MockAPIsMockRecorder) FreeENI(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FreeENI", reflect.TypeOf((*MockAPIs)(nil).FreeENI), arg0, arg1)
 }
 
 // GetAttachedENIs mocks base method
-func (m *MockAPIs) GetAttachedENIs() ([]awsutils.ENIMetadata, error) {
-	ret := m.ctrl.Call(m, "GetAttachedENIs")
+func (m *MockAPIs) GetAttachedENIs(arg0 context.Context) ([]awsutils.ENIMetadata, error) {
+	ret := m.ctrl.Call(m, "GetAttachedENIs", arg0)
 	ret0, _ := ret[0].([]awsutils.ENIMetadata)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAttachedENIs indicates an expected call of GetAttachedENIs
-func (mr *MockAPIsMockRecorder) GetAttachedENIs() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAttachedENIs", reflect.TypeOf((*MockAPIs)(nil).GetAttachedENIs))
+func (mr *MockAPIsMockRecorder) GetAttachedENIs(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAttachedENIs", reflect.TypeOf((*MockAPIs)(nil).GetAttachedENIs), arg0)
+}
+
+// GetAvailabilityZone mocks base method
+func (m *MockAPIs) GetAvailabilityZone() string {
+	ret := m.ctrl.Call(m, "GetAvailabilityZone")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetAvailabilityZone indicates an expected call of GetAvailabilityZone
+func (mr *MockAPIsMockRecorder) GetAvailabilityZone() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAvailabilityZone", reflect.TypeOf((*MockAPIs)(nil).GetAvailabilityZone))
+}
+
+// GetBranchENIs mocks base method
+func (m *MockAPIs) GetBranchENIs(arg0 context.Context, arg1 string) ([]awsutils.BranchENI, error) {
+	ret := m.ctrl.Call(m, "GetBranchENIs", arg0, arg1)
+	ret0, _ := ret[0].([]awsutils.BranchENI)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBranchENIs indicates an expected call of GetBranchENIs
+func (mr *MockAPIsMockRecorder) GetBranchENIs(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBranchENIs", reflect.TypeOf((*MockAPIs)(nil).GetBranchENIs), arg0, arg1)
+}
+
+// GetClusterInfo mocks base method
+func (m *MockAPIs) GetClusterInfo() awsutils.ClusterInfo {
+	ret := m.ctrl.Call(m, "GetClusterInfo")
+	ret0, _ := ret[0].(awsutils.ClusterInfo)
+	return ret0
+}
+
+// GetClusterInfo indicates an expected call of GetClusterInfo
+func (mr *MockAPIsMockRecorder) GetClusterInfo() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClusterInfo", reflect.TypeOf((*MockAPIs)(nil).GetClusterInfo))
+}
+
+// GetCredentialsInfo mocks base method
+func (m *MockAPIs) GetCredentialsInfo() awsutils.CredentialsInfo {
+	ret := m.ctrl.Call(m, "GetCredentialsInfo")
+	ret0, _ := ret[0].(awsutils.CredentialsInfo)
+	return ret0
+}
+
+// GetCredentialsInfo indicates an expected call of GetCredentialsInfo
+func (mr *MockAPIsMockRecorder) GetCredentialsInfo() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCredentialsInfo", reflect.TypeOf((*MockAPIs)(nil).GetCredentialsInfo))
 }
 
 // GetENILimit mocks base method
@@ -150,6 +213,19 @@ func (mr *MockAPIsMockRecorder) GetENILimit() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetENILimit", reflect.TypeOf((*MockAPIs)(nil).GetENILimit))
 }
 
+// GetENIMACs mocks base method
+func (m *MockAPIs) GetENIMACs() (map[string]string, error) {
+	ret := m.ctrl.Call(m, "GetENIMACs")
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetENIMACs indicates an expected call of GetENIMACs
+func (mr *MockAPIsMockRecorder) GetENIMACs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetENIMACs", reflect.TypeOf((*MockAPIs)(nil).GetENIMACs))
+}
+
 // GetENIipLimit mocks base method
 func (m *MockAPIs) GetENIipLimit() (int, error) {
 	ret := m.ctrl.Call(m, "GetENIipLimit")
@@ -175,6 +251,18 @@ func (mr *MockAPIsMockRecorder) GetLocalIPv4() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLocalIPv4", reflect.TypeOf((*MockAPIs)(nil).GetLocalIPv4))
 }
 
+// GetMetadataWaitInfo mocks base method
+func (m *MockAPIs) GetMetadataWaitInfo() awsutils.MetadataWaitInfo {
+	ret := m.ctrl.Call(m, "GetMetadataWaitInfo")
+	ret0, _ := ret[0].(awsutils.MetadataWaitInfo)
+	return ret0
+}
+
+// GetMetadataWaitInfo indicates an expected call of GetMetadataWaitInfo
+func (mr *MockAPIsMockRecorder) GetMetadataWaitInfo() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetadataWaitInfo", reflect.TypeOf((*MockAPIs)(nil).GetMetadataWaitInfo))
+}
+
 // GetPrimaryENI mocks base method
 func (m *MockAPIs) GetPrimaryENI() string {
 	ret := m.ctrl.Call(m, "GetPrimaryENI")
@@ -187,6 +275,30 @@ func (mr *MockAPIsMockRecorder) GetPrimaryENI() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPrimaryENI", reflect.TypeOf((*MockAPIs)(nil).GetPrimaryENI))
 }
 
+// GetPrimaryENISecurityGroups mocks base method
+func (m *MockAPIs) GetPrimaryENISecurityGroups() []string {
+	ret := m.ctrl.Call(m, "GetPrimaryENISecurityGroups")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// GetPrimaryENISecurityGroups indicates an expected call of GetPrimaryENISecurityGroups
+func (mr *MockAPIsMockRecorder) GetPrimaryENISecurityGroups() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPrimaryENISecurityGroups", reflect.TypeOf((*MockAPIs)(nil).GetPrimaryENISecurityGroups))
+}
+
+// GetPrimaryENISubnetID mocks base method
+func (m *MockAPIs) GetPrimaryENISubnetID() string {
+	ret := m.ctrl.Call(m, "GetPrimaryENISubnetID")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetPrimaryENISubnetID indicates an expected call of GetPrimaryENISubnetID
+func (mr *MockAPIsMockRecorder) GetPrimaryENISubnetID() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPrimaryENISubnetID", reflect.TypeOf((*MockAPIs)(nil).GetPrimaryENISubnetID))
+}
+
 // GetPrimaryENImac mocks base method
 func (m *MockAPIs) GetPrimaryENImac() string {
 	ret := m.ctrl.Call(m, "GetPrimaryENImac")
@@ -199,6 +311,32 @@ func (mr *MockAPIsMockRecorder) GetPrimaryENImac() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPrimaryENImac", reflect.TypeOf((*MockAPIs)(nil).GetPrimaryENImac))
 }
 
+// GetSubnetAvailabilityZone mocks base method
+func (m *MockAPIs) GetSubnetAvailabilityZone(arg0 context.Context, arg1 string) (string, error) {
+	ret := m.ctrl.Call(m, "GetSubnetAvailabilityZone", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSubnetAvailabilityZone indicates an expected call of GetSubnetAvailabilityZone
+func (mr *MockAPIsMockRecorder) GetSubnetAvailabilityZone(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubnetAvailabilityZone", reflect.TypeOf((*MockAPIs)(nil).GetSubnetAvailabilityZone), arg0, arg1)
+}
+
+// GetSubnetAvailableIPCount mocks base method
+func (m *MockAPIs) GetSubnetAvailableIPCount(arg0 context.Context, arg1 string) (int, error) {
+	ret := m.ctrl.Call(m, "GetSubnetAvailableIPCount", arg0, arg1)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSubnetAvailableIPCount indicates an expected call of GetSubnetAvailableIPCount
+func (mr *MockAPIsMockRecorder) GetSubnetAvailableIPCount(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubnetAvailableIPCount", reflect.TypeOf((*MockAPIs)(nil).GetSubnetAvailableIPCount), arg0, arg1)
+}
+
 // GetVPCIPv4CIDR mocks base method
 func (m *MockAPIs) GetVPCIPv4CIDR() string {
 	ret := m.ctrl.Call(m, "GetVPCIPv4CIDR")
@@ -222,3 +360,15 @@ func (m *MockAPIs) GetVPCIPv4CIDRs() []*string {
 func (mr *MockAPIsMockRecorder) GetVPCIPv4CIDRs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVPCIPv4CIDRs", reflect.TypeOf((*MockAPIs)(nil).GetVPCIPv4CIDRs))
 }
+
+// TagENI mocks base method
+func (m *MockAPIs) TagENI(arg0 context.Context, arg1, arg2 string) error {
+	ret := m.ctrl.Call(m, "TagENI", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TagENI indicates an expected call of TagENI
+func (mr *MockAPIsMockRecorder) TagENI(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TagENI", reflect.TypeOf((*MockAPIs)(nil).TagENI), arg0, arg1, arg2)
+}
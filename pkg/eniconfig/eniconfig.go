@@ -16,19 +16,29 @@ package eniconfig
 
 import (
 	"context"
+	"net"
 	"os"
 	"runtime"
 	"sync"
 	"time"
 
+	"github.com/operator-framework/operator-sdk/pkg/k8sclient"
 	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"github.com/operator-framework/operator-sdk/pkg/util/k8sutil"
 	"github.com/pkg/errors"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 
 	log "github.com/cihub/seelog"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
 )
 
 const (
@@ -45,11 +55,15 @@ const (
 	//   This will set eniConfigLabelDef to eniConfigOverride
 	envEniConfigAnnotationDef = "ENI_CONFIG_ANNOTATION_DEF"
 	envEniConfigLabelDef      = "ENI_CONFIG_LABEL_DEF"
+
+	eniConfigAPIVersion = "crd.k8s.amazonaws.com/v1alpha1"
+	eniConfigKind       = "ENIConfig"
 )
 
 type ENIConfig interface {
 	MyENIConfig() (*v1alpha1.ENIConfigSpec, error)
 	Getter() *ENIConfigInfo
+	GetWatchStatus() k8sapi.WatchStatus
 }
 
 var ErrNoENIConfig = errors.New("eniconfig: eniconfig is not available")
@@ -62,6 +76,7 @@ type ENIConfigController struct {
 	myNodeName             string
 	eniConfigAnnotationDef string
 	eniConfigLabelDef      string
+	watch                  k8sapi.WatchTracker
 }
 
 // ENIConfigInfo returns locally cached ENIConfigs
@@ -97,6 +112,7 @@ type Handler struct {
 func (h *Handler) Handle(ctx context.Context, event sdk.Event) error {
 	switch o := event.Object.(type) {
 	case *v1alpha1.ENIConfig:
+		h.controller.watch.RecordEvent(o)
 		eniConfigName := o.GetName()
 		if event.Deleted {
 			log.Debugf("Deleting ENIConfig: %s", eniConfigName)
@@ -149,16 +165,77 @@ func (eniCfg *ENIConfigController) Start() {
 
 	sdk.ExposeMetricsPort()
 
-	resource := "crd.k8s.amazonaws.com/v1alpha1"
-	kind := "ENIConfig"
 	resyncPeriod := time.Second * 5
-	log.Infof("Watching %s, %s, every %v s", resource, kind, resyncPeriod.Seconds())
-	sdk.Watch(resource, kind, "", resyncPeriod)
+	log.Infof("Watching %s, %s, every %v s", eniConfigAPIVersion, eniConfigKind, resyncPeriod.Seconds())
+	handler := NewHandler(eniCfg)
+	go eniCfg.watchENIConfigs(handler, resyncPeriod, wait.NeverStop)
 	sdk.Watch("/v1", "Node", corev1.NamespaceAll, resyncPeriod)
-	sdk.Handle(NewHandler(eniCfg))
+	sdk.Handle(handler)
 	sdk.Run(context.TODO())
 }
 
+// watchENIConfigs runs an informer of the ENIConfigs over a ListWatch that counts its errors. The sdk informers do not
+// expose their ListWatch, so unlike the nodes the ENIConfigs are not watched through the sdk.
+func (eniCfg *ENIConfigController) watchENIConfigs(handler sdk.Handler, resyncPeriod time.Duration, stopCh <-chan struct{}) {
+	resourceClient, _, err := k8sclient.GetResourceClient(eniConfigAPIVersion, eniConfigKind, "")
+	if err != nil {
+		log.Errorf("Failed to get the resource client of %s: %v", eniConfigKind, err)
+		eniCfg.watch.RecordListError(err)
+		return
+	}
+	lw := k8sapi.TrackListWatch(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (k8sruntime.Object, error) {
+			return resourceClient.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return resourceClient.Watch(options)
+		},
+	}, &eniCfg.watch)
+	informer := cache.NewSharedIndexInformer(lw, &unstructured.Unstructured{}, resyncPeriod, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			eniCfg.handleENIConfig(handler, obj, false)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			eniCfg.handleENIConfig(handler, newObj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			eniCfg.handleENIConfig(handler, obj, true)
+		},
+	})
+
+	eniCfg.watch.Start()
+	go informer.Run(stopCh)
+	if cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		eniCfg.watch.SetSynced(true)
+	}
+}
+
+// handleENIConfig hands an ENIConfig from the informer to the handler, the way the sdk hands out its events
+func (eniCfg *ENIConfigController) handleENIConfig(handler sdk.Handler, obj interface{}, deleted bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		log.Errorf("Unexpected %s object %T", eniConfigKind, obj)
+		return
+	}
+	object, err := k8sutil.RuntimeObjectFromUnstructured(u.DeepCopy())
+	if err != nil {
+		log.Errorf("Failed to decode %s %s: %v", eniConfigKind, u.GetName(), err)
+		return
+	}
+	if err := handler.Handle(context.TODO(), sdk.Event{Object: object, Deleted: deleted}); err != nil {
+		log.Errorf("Failed to handle %s %s: %v", eniConfigKind, u.GetName(), err)
+	}
+}
+
+// GetWatchStatus returns the health of the ENIConfig watch
+func (eniCfg *ENIConfigController) GetWatchStatus() k8sapi.WatchStatus {
+	return eniCfg.watch.Status()
+}
+
 func (eniCfg *ENIConfigController) Getter() *ENIConfigInfo {
 	output := &ENIConfigInfo{
 		ENI: make(map[string]v1alpha1.ENIConfigSpec),
@@ -187,11 +264,35 @@ func (eniCfg *ENIConfigController) MyENIConfig() (*v1alpha1.ENIConfigSpec, error
 		return &v1alpha1.ENIConfigSpec{
 			SecurityGroups: myENIConfig.SecurityGroups,
 			Subnet:         myENIConfig.Subnet,
+			StaticRoutes:   myENIConfig.StaticRoutes,
 		}, nil
 	}
 	return nil, ErrNoENIConfig
 }
 
+// ValidateStaticRoutes checks that the destinations of the static routes of an ENIConfig are IPv4 CIDRs other than
+// the default route, and that their gateways are IPv4 unicast addresses
+func ValidateStaticRoutes(routes []v1alpha1.StaticRoute) error {
+	for _, route := range routes {
+		ip, dst, err := net.ParseCIDR(route.Destination)
+		if err != nil || ip.To4() == nil {
+			return errors.Errorf("invalid static route destination %q, it must be an IPv4 CIDR", route.Destination)
+		}
+		if ones, _ := dst.Mask.Size(); ones == 0 {
+			return errors.Errorf("invalid static route destination %q, it must not be the default route", route.Destination)
+		}
+		if route.Gateway == "" {
+			continue
+		}
+		gw := net.ParseIP(route.Gateway)
+		if gw == nil || gw.To4() == nil || !gw.IsGlobalUnicast() {
+			return errors.Errorf("invalid gateway %q of static route %s, it must be an IPv4 unicast address",
+				route.Gateway, route.Destination)
+		}
+	}
+	return nil
+}
+
 // getEniConfigAnnotationDef returns eniConfigAnnotation
 func getEniConfigAnnotationDef() string {
 	inputStr, found := os.LookupEnv(envEniConfigAnnotationDef)
@@ -19,9 +19,15 @@ import (
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 	sdk "github.com/operator-framework/operator-sdk/pkg/sdk"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 
 	corev1 "k8s.io/api/core/v1"
@@ -131,6 +137,30 @@ func TestENIConfig(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, defaultCfg, *outputCfg)
 
+	// The static routes of the config of the node are returned too
+	defaultCfg.StaticRoutes = []v1alpha1.StaticRoute{{Destination: "10.100.0.0/16", Gateway: "100.64.0.1"}}
+	updateENIConfig(testHandler, eniConfigDefault, defaultCfg, false)
+	outputCfg, err = testENIConfigController.MyENIConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, defaultCfg, *outputCfg)
+}
+
+func TestValidateStaticRoutes(t *testing.T) {
+	assert.NoError(t, ValidateStaticRoutes(nil))
+	assert.NoError(t, ValidateStaticRoutes([]v1alpha1.StaticRoute{
+		{Destination: "10.100.0.0/16"},
+		{Destination: "172.16.1.0/24", Gateway: "100.64.0.1"},
+	}))
+	for _, route := range []v1alpha1.StaticRoute{
+		{Destination: "10.100.0.0"},
+		{Destination: "fd00::/64"},
+		{Destination: "0.0.0.0/0"},
+		{Destination: "10.100.0.0/16", Gateway: "gateway"},
+		{Destination: "10.100.0.0/16", Gateway: "fd00::1"},
+		{Destination: "10.100.0.0/16", Gateway: "0.0.0.0"},
+	} {
+		assert.Error(t, ValidateStaticRoutes([]v1alpha1.StaticRoute{route}), route.Destination+" "+route.Gateway)
+	}
 }
 
 func TestNodeENIConfig(t *testing.T) {
@@ -238,3 +268,49 @@ func TestGetEniConfigLabelDefCustom(t *testing.T) {
 	eniConfigLabelDef := getEniConfigLabelDef()
 	assert.Equal(t, eniConfigLabelDef, "k8s.amazonaws.com/eniConfigCustom")
 }
+
+func TestGetWatchStatus(t *testing.T) {
+	testENIConfigController := NewENIConfigController()
+	testHandler := NewHandler(testENIConfigController)
+
+	status := testENIConfigController.GetWatchStatus()
+	assert.False(t, status.Synced)
+	assert.Equal(t, int64(0), status.Events)
+
+	// The ENIConfigs of the informer are handed to the handler, the nodes of the sdk are not counted
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(eniConfigAPIVersion)
+	u.SetKind(eniConfigKind)
+	u.SetName(eniConfigDefault)
+	u.SetResourceVersion("42")
+	u.Object["spec"] = map[string]interface{}{"subnet": "subnet1"}
+	testENIConfigController.handleENIConfig(testHandler, u, false)
+	updateNodeAnnotation(testHandler, "node1", eniConfigDefault, false)
+	status = testENIConfigController.GetWatchStatus()
+	assert.Equal(t, int64(1), status.Events)
+	assert.Equal(t, "42", status.LastResourceVersion)
+	assert.False(t, status.LastEvent.IsZero())
+	assert.Equal(t, "subnet1", testENIConfigController.eni[eniConfigDefault].Subnet)
+
+	testENIConfigController.handleENIConfig(testHandler, cache.DeletedFinalStateUnknown{Key: eniConfigDefault, Obj: u}, true)
+	assert.Equal(t, int64(2), testENIConfigController.GetWatchStatus().Events)
+	assert.NotContains(t, testENIConfigController.eni, eniConfigDefault)
+
+	// The list and watch errors of the ListWatch of the informer are counted
+	lw := k8sapi.TrackListWatch(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return nil, errors.New("list timeout")
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return nil, errors.New("watch EOF")
+		},
+	}, &testENIConfigController.watch)
+	_, err := lw.List(metav1.ListOptions{})
+	assert.Error(t, err)
+	_, err = lw.Watch(metav1.ListOptions{})
+	assert.Error(t, err)
+	status = testENIConfigController.GetWatchStatus()
+	assert.Equal(t, int64(1), status.ListErrors)
+	assert.Equal(t, int64(1), status.WatchErrors)
+	assert.Equal(t, "watch EOF", status.LastError)
+}
@@ -22,6 +22,7 @@ import (
 
 	v1alpha1 "github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
 	eniconfig "github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
+	k8sapi "github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -48,6 +49,18 @@ func (m *MockENIConfig) EXPECT() *MockENIConfigMockRecorder {
 	return m.recorder
 }
 
+// GetWatchStatus mocks base method
+func (m *MockENIConfig) GetWatchStatus() k8sapi.WatchStatus {
+	ret := m.ctrl.Call(m, "GetWatchStatus")
+	ret0, _ := ret[0].(k8sapi.WatchStatus)
+	return ret0
+}
+
+// GetWatchStatus indicates an expected call of GetWatchStatus
+func (mr *MockENIConfigMockRecorder) GetWatchStatus() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWatchStatus", reflect.TypeOf((*MockENIConfig)(nil).GetWatchStatus))
+}
+
 // Getter mocks base method
 func (m *MockENIConfig) Getter() *eniconfig.ENIConfigInfo {
 	ret := m.ctrl.Call(m, "Getter")
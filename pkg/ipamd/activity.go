@@ -0,0 +1,102 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+)
+
+// OperationActivity describes the ADD or DEL requests served by ipamd, for introspection
+type OperationActivity struct {
+	Count    int64
+	Failures int64
+	// Last is when the last request was served, SinceLast how long ago, and LastSuccess when the last one succeeded
+	Last        time.Time     `json:",omitempty"`
+	SinceLast   time.Duration `json:",omitempty"`
+	LastSuccess time.Time     `json:",omitempty"`
+	// RatePerMinute is the moving average of the requests served per minute
+	RatePerMinute float64
+}
+
+// Activity describes how busy the node is, for introspection. IdleFor is how long ago the last ADD or DEL request
+// was served, unset when none was.
+type Activity struct {
+	Add     OperationActivity
+	Del     OperationActivity
+	IdleFor time.Duration `json:",omitempty"`
+}
+
+// operationActivityState keeps the counts and the moving rate of the ADD or DEL requests
+type operationActivityState struct {
+	activity OperationActivity
+	rate     allocationRateState
+}
+
+// activityState keeps the counts of the ADD and DEL requests
+type activityState struct {
+	add  operationActivityState
+	del  operationActivityState
+	lock sync.RWMutex
+}
+
+func (s *activityState) record(op *operationActivityState, err error) {
+	now := time.Now()
+	op.rate.record(now, 1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	op.activity.Count++
+	op.activity.Last = now
+	if err != nil {
+		op.activity.Failures++
+	} else {
+		op.activity.LastSuccess = now
+	}
+}
+
+// recordAdd counts an ADD request, which failed if err is not nil
+func (s *activityState) recordAdd(err error) {
+	s.record(&s.add, err)
+}
+
+// recordDel counts a DEL request, which failed if err is not nil
+func (s *activityState) recordDel(err error) {
+	s.record(&s.del, err)
+}
+
+// GetActivity returns the counts and the rates of the ADD and DEL requests served by ipamd
+func (c *IPAMContext) GetActivity() Activity {
+	now := time.Now()
+	addRate := c.activity.add.rate.perMinute(now)
+	delRate := c.activity.del.rate.perMinute(now)
+	c.activity.lock.RLock()
+	defer c.activity.lock.RUnlock()
+	activity := Activity{Add: c.activity.add.activity, Del: c.activity.del.activity}
+	activity.Add.RatePerMinute = addRate
+	activity.Del.RatePerMinute = delRate
+	var last time.Time
+	for _, op := range []*OperationActivity{&activity.Add, &activity.Del} {
+		if op.Last.IsZero() {
+			continue
+		}
+		op.SinceLast = now.Sub(op.Last)
+		if op.Last.After(last) {
+			last = op.Last
+		}
+	}
+	if !last.IsZero() {
+		activity.IdleFor = now.Sub(last)
+	}
+	return activity
+}
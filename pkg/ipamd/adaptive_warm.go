@@ -0,0 +1,148 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to let ipamd set the warm IP target from the IPs assigned to and released by
+	// the pods, instead of using the fixed WARM_IP_TARGET. The target covers the growth of the pods at the current rate
+	// for ADAPTIVE_WARM_IP_TARGET_WINDOW, plus the IPs released by the replaced pods that are cooling down, within
+	// ADAPTIVE_WARM_IP_TARGET_MIN and ADAPTIVE_WARM_IP_TARGET_MAX. When it is not set, it defaults to false.
+	envAdaptiveWarmIPTarget = "ADAPTIVE_WARM_IP_TARGET"
+
+	// This environment variable is used to specify, in seconds, how much of the pod growth the adaptive warm IP target
+	// keeps IPs ready for. When it is not set, it defaults to 2 minutes.
+	envAdaptiveWarmIPTargetWindow     = "ADAPTIVE_WARM_IP_TARGET_WINDOW"
+	defaultAdaptiveWarmIPTargetWindow = 2 * time.Minute
+
+	// This environment variable is used to specify the lowest adaptive warm IP target. When it is not set, it
+	// defaults to 1.
+	envAdaptiveWarmIPTargetMin     = "ADAPTIVE_WARM_IP_TARGET_MIN"
+	defaultAdaptiveWarmIPTargetMin = 1
+
+	// This environment variable is used to specify the highest adaptive warm IP target. When it is not set or set to
+	// 0, it is the number of IPs of an ENI.
+	envAdaptiveWarmIPTargetMax = "ADAPTIVE_WARM_IP_TARGET_MAX"
+	noAdaptiveWarmIPTargetMax  = 0
+
+	// adaptiveWarmIPCoolingPeriod is how long a released IP cannot be assigned again, and must be covered by another
+	// warm IP when its pod is replaced
+	adaptiveWarmIPCoolingPeriod = 30 * time.Second
+)
+
+// AdaptiveWarmIPTargetStats describes the adaptive warm IP target and the rates it is computed from, for
+// introspection
+type AdaptiveWarmIPTargetStats struct {
+	Window time.Duration
+	Min    int
+	Max    int
+	// AllocationRate and ReleaseRate are the moving averages of the IPs assigned to and released by pods per minute
+	AllocationRate float64
+	ReleaseRate    float64
+	// Unclamped is the target before it is bounded by Min and Max
+	Unclamped int
+	Target    int
+}
+
+// adaptiveWarmIPTargetMax returns ADAPTIVE_WARM_IP_TARGET_MAX, the number of IPs of an ENI when it is not set, and
+// never less than ADAPTIVE_WARM_IP_TARGET_MIN
+func (c *IPAMContext) adaptiveWarmIPTargetMax() int {
+	highest := c.adaptiveWarmIPTargetMaximum
+	if highest == noAdaptiveWarmIPTargetMax {
+		highest = c.maxIPsPerENI
+	}
+	return max(highest, c.adaptiveWarmIPTargetMin)
+}
+
+// getAdaptiveWarmIPTargetStats computes the adaptive warm IP target from the current allocation and release rates
+func (c *IPAMContext) getAdaptiveWarmIPTargetStats() AdaptiveWarmIPTargetStats {
+	now := time.Now()
+	stats := AdaptiveWarmIPTargetStats{
+		Window:         c.adaptiveWarmIPTargetWindow,
+		Min:            c.adaptiveWarmIPTargetMin,
+		Max:            c.adaptiveWarmIPTargetMax(),
+		AllocationRate: c.allocationRate.perMinute(now),
+		ReleaseRate:    c.releaseRate.perMinute(now),
+	}
+	growth := math.Max(stats.AllocationRate-stats.ReleaseRate, 0) * stats.Window.Minutes()
+	cooling := math.Min(stats.AllocationRate, stats.ReleaseRate) * adaptiveWarmIPCoolingPeriod.Minutes()
+	stats.Unclamped = int(math.Ceil(growth + cooling))
+	stats.Target = min(max(stats.Unclamped, stats.Min), stats.Max)
+	return stats
+}
+
+// adaptiveWarmIPTarget returns the warm IP target to use instead of WARM_IP_TARGET
+func (c *IPAMContext) adaptiveWarmIPTarget() int {
+	return c.getAdaptiveWarmIPTargetStats().Target
+}
+
+func adaptiveWarmIPTargetEnabled() bool {
+	return getEnvBoolWithDefault(envAdaptiveWarmIPTarget, false)
+}
+
+func getAdaptiveWarmIPTargetWindow() time.Duration {
+	inputStr, found := os.LookupEnv(envAdaptiveWarmIPTargetWindow)
+
+	if !found {
+		return defaultAdaptiveWarmIPTargetWindow
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		log.Debugf("Using %s %v", envAdaptiveWarmIPTargetWindow, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, using default %v", envAdaptiveWarmIPTargetWindow, inputStr,
+		defaultAdaptiveWarmIPTargetWindow)
+	return defaultAdaptiveWarmIPTargetWindow
+}
+
+func getAdaptiveWarmIPTargetMin() int {
+	inputStr, found := os.LookupEnv(envAdaptiveWarmIPTargetMin)
+
+	if !found {
+		return defaultAdaptiveWarmIPTargetMin
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		log.Debugf("Using %s %v", envAdaptiveWarmIPTargetMin, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envAdaptiveWarmIPTargetMin, inputStr,
+		defaultAdaptiveWarmIPTargetMin)
+	return defaultAdaptiveWarmIPTargetMin
+}
+
+func getAdaptiveWarmIPTargetMax() int {
+	inputStr, found := os.LookupEnv(envAdaptiveWarmIPTargetMax)
+
+	if !found {
+		return noAdaptiveWarmIPTargetMax
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envAdaptiveWarmIPTargetMax, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envAdaptiveWarmIPTargetMax, inputStr,
+		noAdaptiveWarmIPTargetMax)
+	return noAdaptiveWarmIPTargetMax
+}
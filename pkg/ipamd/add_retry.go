@@ -0,0 +1,162 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to specify how many more times an AddNetwork request tries to assign an IP
+	// address to the pod when it fails, for example because the pool manager could not get IPs from EC2 yet. When it
+	// is not set or set to 0, a failed assignment fails the request.
+	envAddRetryAttempts = "ADD_RETRY_ATTEMPTS"
+	noAddRetry          = 0
+	maxAddRetryAttempts = 10
+
+	// This environment variable is used to specify, in milliseconds, how long an AddNetwork request waits before its
+	// first retry. The wait doubles on each retry. When it is not set, it defaults to 200 milliseconds.
+	envAddRetryBackoff     = "ADD_RETRY_BACKOFF_MS"
+	defaultAddRetryBackoff = 200 * time.Millisecond
+)
+
+// AddRetryStats counts the retries of the IP assignment of AddNetwork requests, for introspection
+type AddRetryStats struct {
+	Attempts int
+	Backoff  time.Duration
+	// Retried is the number of requests that retried, Retries their number of retries, and SucceededAfterRetry and
+	// FailedAfterRetry how those requests ended
+	Retried             int64
+	Retries             int64
+	SucceededAfterRetry int64
+	FailedAfterRetry    int64
+	// LastError is the error of the last failed assignment that was retried, at LastErrorTime
+	LastError     string `json:",omitempty"`
+	LastErrorTime time.Time
+}
+
+// addRetryState keeps the AddNetwork retry counts
+type addRetryState struct {
+	stats AddRetryStats
+	lock  sync.RWMutex
+}
+
+func (s *addRetryState) recordRetry(first bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if first {
+		s.stats.Retried++
+	}
+	s.stats.Retries++
+	s.stats.LastError = err.Error()
+	s.stats.LastErrorTime = time.Now()
+}
+
+func (s *addRetryState) recordResult(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err == nil {
+		s.stats.SucceededAfterRetry++
+	} else {
+		s.stats.FailedAfterRetry++
+	}
+}
+
+// assignPodIPv4AddressWithRetry assigns an IP address to the pod, retrying up to ADD_RETRY_ATTEMPTS times with an
+// exponential backoff when the assignment fails. A request that already waited for the pool to grow because of
+// DATASTORE_FULL_POLICY, or whose pool cannot grow because of NODE_IP_QUOTA or SCALE_DOWN_SHRINK, is not retried.
+func (c *IPAMContext) assignPodIPv4AddressWithRetry(ctx context.Context, pod *k8sapi.K8SPodInfo) (string, int, error) {
+	addr, deviceNumber, err := c.assignPodIPv4Address(ctx, pod)
+	if err == nil || c.addRetryAttempts == noAddRetry {
+		return addr, deviceNumber, err
+	}
+
+	retried := false
+	backoff := c.addRetryBackoff
+	for attempt := 1; attempt <= c.addRetryAttempts && err != nil; attempt++ {
+		if err == datastore.ErrNoAvailableIPs && (c.datastoreFullPolicy == datastoreFullWait ||
+			c.nodeIPQuotaReached() || c.inShrinkMode()) {
+			break
+		}
+		c.addRetry.recordRetry(!retried, err)
+		retried = true
+		log.Infof("Failed to assign an IP address to Pod %s, Namespace %s: %v, retrying in %v (%d/%d)",
+			pod.Name, pod.Namespace, err, backoff, attempt, c.addRetryAttempts)
+		c.podTraces.step(pod, "assignment failed: %v, retry %d/%d in %v", err, attempt, c.addRetryAttempts, backoff)
+		if err == datastore.ErrNoAvailableIPs {
+			c.requestPoolGrowth(pod)
+		}
+		select {
+		case <-ctx.Done():
+			c.addRetry.recordResult(err)
+			return "", 0, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		addr, deviceNumber, err = c.assignPodIPv4Address(ctx, pod)
+	}
+	if retried {
+		c.addRetry.recordResult(err)
+	}
+	return addr, deviceNumber, err
+}
+
+// GetAddRetryStats returns the AddNetwork retry counts
+func (c *IPAMContext) GetAddRetryStats() AddRetryStats {
+	c.addRetry.lock.RLock()
+	defer c.addRetry.lock.RUnlock()
+	stats := c.addRetry.stats
+	stats.Attempts = c.addRetryAttempts
+	stats.Backoff = c.addRetryBackoff
+	return stats
+}
+
+func getAddRetryAttempts() int {
+	inputStr, found := os.LookupEnv(envAddRetryAttempts)
+
+	if !found {
+		return noAddRetry
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && input <= maxAddRetryAttempts {
+		log.Debugf("Using %s %v", envAddRetryAttempts, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envAddRetryAttempts, inputStr, noAddRetry)
+	return noAddRetry
+}
+
+func getAddRetryBackoff() time.Duration {
+	inputStr, found := os.LookupEnv(envAddRetryBackoff)
+
+	if !found {
+		return defaultAddRetryBackoff
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		log.Debugf("Using %s %v", envAddRetryBackoff, input)
+		return time.Duration(input) * time.Millisecond
+	}
+	log.Errorf("Invalid %s value %q, using default %v", envAddRetryBackoff, inputStr, defaultAddRetryBackoff)
+	return defaultAddRetryBackoff
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+// AddressFamilyStatus compares the addresses of a family that an ENI has in EC2 with those in the datastore
+type AddressFamilyStatus struct {
+	// EC2 is the number of secondary addresses of the ENI in EC2, and Tracked the number in the datastore
+	EC2     int
+	Tracked int
+	// Untracked are the addresses the ENI has in EC2 that are not in the datastore, and Stale those in the datastore
+	// that the ENI no longer has
+	Untracked []string `json:",omitempty"`
+	Stale     []string `json:",omitempty"`
+}
+
+// ENIAddressFamilies describes the IPv4 and IPv6 addresses of an ENI after a reconcile, for introspection
+type ENIAddressFamilies struct {
+	ENIID string
+	// DualStack is true when the ENI has both IPv4 and IPv6 addresses in EC2
+	DualStack bool
+	IPv4      AddressFamilyStatus
+	// IPv6 only has the number of IPv6 addresses of the ENI in EC2, the datastore does not hold them
+	IPv6 AddressFamilyStatus
+	// Inconsistencies describes where the IPv4 addresses of the datastore do not match EC2
+	Inconsistencies []string `json:",omitempty"`
+}
+
+// AddressFamilyStats describes the address families of the ENIs at the last reconcile, for introspection
+type AddressFamilyStats struct {
+	LastReconcile time.Time
+	// DualStackENIs is the number of ENIs with both families, and InconsistentENIs the number with inconsistencies
+	DualStackENIs    int
+	InconsistentENIs int
+	ENIs             []ENIAddressFamilies
+}
+
+// addressFamilyState keeps the address families of the ENIs at the last reconcile
+type addressFamilyState struct {
+	lastReconcile time.Time
+	enis          []ENIAddressFamilies
+	lock          sync.RWMutex
+}
+
+// checkAddressFamilies compares, family by family, the addresses of the attached ENIs in EC2 with the datastore once
+// the reconcile has updated it. The datastore only holds IPv4 addresses, so the IPv6 addresses of an ENI are only
+// counted. The IPv4 addresses in their reconcile cooldown are expected to be missing from the datastore and are not
+// reported.
+func (c *IPAMContext) checkAddressFamilies(attachedENIs []awsutils.ENIMetadata) {
+	var enis []ENIAddressFamilies
+	for _, attachedENI := range attachedENIs {
+		ipPool, err := c.dataStore.GetENIIPPools(attachedENI.ENIID)
+		if err != nil {
+			continue
+		}
+		eni := ENIAddressFamilies{ENIID: attachedENI.ENIID}
+
+		ec2IPv4s := make(map[string]bool)
+		for _, addr := range attachedENI.IPv4Addresses {
+			ip := aws.StringValue(addr.PrivateIpAddress)
+			if aws.BoolValue(addr.Primary) || ip == c.primaryIP[attachedENI.ENIID] {
+				continue
+			}
+			ec2IPv4s[ip] = true
+			if _, ok := ipPool[ip]; !ok {
+				if _, recentlyFreed := c.reconcileCooldownCache.RecentlyFreed(ip); !recentlyFreed {
+					eni.IPv4.Untracked = append(eni.IPv4.Untracked, ip)
+				}
+			}
+		}
+		for ip := range ipPool {
+			if !ec2IPv4s[ip] {
+				eni.IPv4.Stale = append(eni.IPv4.Stale, ip)
+			}
+		}
+		eni.IPv4.EC2 = len(ec2IPv4s)
+		eni.IPv4.Tracked = len(ipPool)
+		eni.IPv6.EC2 = len(attachedENI.IPv6Addresses)
+		eni.DualStack = len(attachedENI.IPv4Addresses) > 0 && len(attachedENI.IPv6Addresses) > 0
+		sort.Strings(eni.IPv4.Untracked)
+		sort.Strings(eni.IPv4.Stale)
+
+		if len(eni.IPv4.Untracked) > 0 {
+			eni.Inconsistencies = append(eni.Inconsistencies,
+				fmt.Sprintf("IPv4: %d addresses of the ENI are not in the datastore", len(eni.IPv4.Untracked)))
+		}
+		if len(eni.IPv4.Stale) > 0 {
+			eni.Inconsistencies = append(eni.Inconsistencies,
+				fmt.Sprintf("IPv4: %d addresses in the datastore are no longer on the ENI", len(eni.IPv4.Stale)))
+		}
+		if len(eni.Inconsistencies) > 0 {
+			log.Debugf("Address families of ENI %s do not match the datastore: %v", eni.ENIID, eni.Inconsistencies)
+		}
+		enis = append(enis, eni)
+	}
+	sort.Slice(enis, func(i, j int) bool { return enis[i].ENIID < enis[j].ENIID })
+
+	c.addressFamilies.lock.Lock()
+	defer c.addressFamilies.lock.Unlock()
+	c.addressFamilies.lastReconcile = time.Now()
+	c.addressFamilies.enis = enis
+}
+
+// GetAddressFamilyStats returns the IPv4 and IPv6 addresses of each ENI at the last reconcile
+func (c *IPAMContext) GetAddressFamilyStats() AddressFamilyStats {
+	c.addressFamilies.lock.RLock()
+	defer c.addressFamilies.lock.RUnlock()
+	stats := AddressFamilyStats{
+		LastReconcile: c.addressFamilies.lastReconcile,
+		ENIs:          append([]ENIAddressFamilies{}, c.addressFamilies.enis...),
+	}
+	for _, eni := range stats.ENIs {
+		if eni.DualStack {
+			stats.DualStackENIs++
+		}
+		if len(eni.Inconsistencies) > 0 {
+			stats.InconsistentENIs++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do when a call to allocate IP addresses on an ENI fails,
+	// for example on a timeout, while EC2 may still have allocated them. AssignPrivateIpAddresses takes no client
+	// token, so a retried call would allocate the IPs a second time:
+	//   "recover" (default): describe the ENI, and take the IPs it has that are not in the datastore as allocated by
+	//     the failed call, instead of failing it and letting the caller retry
+	//   "off": return the error, the IPs are only found by the next reconcile
+	envAllocIdempotencyPolicy = "ALLOC_IP_IDEMPOTENCY_POLICY"
+	allocIdempotencyRecover   = "recover"
+	allocIdempotencyOff       = "off"
+
+	// maxAllocRecoveries is how many of the recent recoveries are kept
+	maxAllocRecoveries = 32
+)
+
+// AllocRecovery is a failed allocation of IP addresses on an ENI that EC2 actually did, for introspection
+type AllocRecovery struct {
+	ENIID string
+	Time  time.Time
+	// Requested is the number of IPs requested, IPs those found on the ENI and kept as allocated
+	Requested int
+	IPs       []string
+	// Error is the error of the failed call
+	Error string
+}
+
+// AllocIdempotencyStats counts the failed allocations checked against EC2 and those recovered, for introspection
+type AllocIdempotencyStats struct {
+	Policy string
+	// Checks is the number of failed allocations checked, Recoveries how many of them EC2 actually did, and
+	// RecoveredIPs the total of their IPs
+	Checks       int64
+	Recoveries   int64
+	RecoveredIPs int64
+	// CheckErrors is the number of checks that could not describe the ENI
+	CheckErrors int64
+	// Recent are the most recent recoveries, oldest first
+	Recent []AllocRecovery
+}
+
+// allocIdempotencyState keeps the recent recovered allocations
+type allocIdempotencyState struct {
+	checks       int64
+	recoveries   int64
+	recoveredIPs int64
+	checkErrors  int64
+	recent       []AllocRecovery
+	lock         sync.RWMutex
+}
+
+// record counts a check of a failed allocation, which recovered the IPs of recovery if it is not nil
+func (s *allocIdempotencyState) record(recovery *AllocRecovery, checkErr error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.checks++
+	if checkErr != nil {
+		s.checkErrors++
+	}
+	if recovery == nil {
+		return
+	}
+	s.recoveries++
+	s.recoveredIPs += int64(len(recovery.IPs))
+	s.recent = append(s.recent, *recovery)
+	s.recent = s.recent[keepLast(len(s.recent), maxAllocRecoveries):]
+}
+
+// allocIPAddressesOnce allocates numIPs IP addresses on the ENI. When the call fails and ALLOC_IP_IDEMPOTENCY_POLICY
+// is "recover", the ENI is described, and the secondary IPs it has that are not in the datastore are returned as
+// allocated, so that the caller does not retry an allocation EC2 already did.
+func (c *IPAMContext) allocIPAddressesOnce(eniID string, numIPs int) ([]string, error) {
+	ips, err := c.awsClient.AllocIPAddresses(c.poolManagerContext(), eniID, numIPs)
+	if err == nil || c.allocIdempotencyPolicy != allocIdempotencyRecover {
+		return ips, err
+	}
+
+	recovered, checkErr := c.unknownENIIPs(eniID)
+	if checkErr != nil {
+		log.Warnf("Failed to check whether the failed allocation of %d IP addresses on ENI %s was done: %v",
+			numIPs, eniID, checkErr)
+	}
+	if len(recovered) == 0 {
+		c.allocIdempotency.record(nil, checkErr)
+		return ips, err
+	}
+	if len(recovered) > numIPs {
+		// The IPs of an earlier failed allocation may not be in the datastore yet either
+		recovered = recovered[:numIPs]
+	}
+	log.Warnf("Allocation of %d IP addresses on ENI %s failed, but EC2 allocated %v: %v", numIPs, eniID, recovered, err)
+	ipamdErrInc("allocIPAddressesRecovered")
+	c.eniTimelines.record(eniID, eniEventError, "recovered %d IPs of a failed allocation: %v", len(recovered), err)
+	c.allocIdempotency.record(&AllocRecovery{ENIID: eniID, Time: time.Now(), Requested: numIPs, IPs: recovered,
+		Error: err.Error()}, nil)
+	return recovered, nil
+}
+
+// unknownENIIPs returns the secondary IPs EC2 lists on the ENI that are not in the datastore
+func (c *IPAMContext) unknownENIIPs(eniID string) ([]string, error) {
+	addrs, _, _, err := c.awsClient.DescribeENI(c.poolManagerContext(), eniID)
+	if err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for _, addr := range addrs {
+		if aws.BoolValue(addr.Primary) {
+			continue
+		}
+		ip := aws.StringValue(addr.PrivateIpAddress)
+		if ip != "" && c.dataStore.GetIPv4AddressENI(ip) == "" {
+			unknown = append(unknown, ip)
+		}
+	}
+	return unknown, nil
+}
+
+// GetAllocIdempotencyStats returns the counts of the failed allocations checked and the recent ones recovered
+func (c *IPAMContext) GetAllocIdempotencyStats() AllocIdempotencyStats {
+	c.allocIdempotency.lock.RLock()
+	defer c.allocIdempotency.lock.RUnlock()
+	return AllocIdempotencyStats{
+		Policy:       c.allocIdempotencyPolicy,
+		Checks:       c.allocIdempotency.checks,
+		Recoveries:   c.allocIdempotency.recoveries,
+		RecoveredIPs: c.allocIdempotency.recoveredIPs,
+		CheckErrors:  c.allocIdempotency.checkErrors,
+		Recent:       append([]AllocRecovery{}, c.allocIdempotency.recent...),
+	}
+}
+
+func getAllocIdempotencyPolicy() string {
+	return envsetting.Choice(envAllocIdempotencyPolicy, allocIdempotencyRecover,
+		allocIdempotencyRecover, allocIdempotencyOff)
+}
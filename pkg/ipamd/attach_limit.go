@@ -0,0 +1,128 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// This environment variable is used to specify, in seconds, how long ipamd waits before attaching an ENI again
+	// after EC2 reported that the instance ENI limit was exceeded, which happens when another agent attaches ENIs to
+	// the node at the same time. The wait doubles on each consecutive failure, up to 10 times this value. When it is
+	// not set, it defaults to 30 seconds.
+	envAttachLimitBackoff     = "ENI_ATTACH_LIMIT_BACKOFF"
+	defaultAttachLimitBackoff = 30 * time.Second
+	maxAttachLimitBackoffMult = 10
+)
+
+// errAttachLimitBackoff is returned when no ENI is attached because the instance ENI limit was exceeded recently
+var errAttachLimitBackoff = errors.New("not attaching an ENI, the instance ENI limit was exceeded recently")
+
+// AttachLimitStats describes the ENI attachments that failed because the instance ENI limit was exceeded, for
+// introspection
+type AttachLimitStats struct {
+	// Races is the number of attachments that failed, and Consecutive those since the last successful one
+	Races       int64
+	Consecutive int
+	LastRace    time.Time
+	LastError   string `json:",omitempty"`
+	// RetryAfter is when ipamd attaches an ENI again
+	RetryAfter time.Time
+	// AttachedENIs is the number of ENIs attached to the instance found by the re-sync that followed the last failure,
+	// and UnmanagedENIs those tagged for ipamd not to manage them
+	AttachedENIs  int
+	UnmanagedENIs int
+}
+
+// attachLimitState keeps the ENI attachments that failed because the instance ENI limit was exceeded
+type attachLimitState struct {
+	stats AttachLimitStats
+	lock  sync.RWMutex
+}
+
+// waiting returns true if the last attachment failed less than the current backoff ago
+func (s *attachLimitState) waiting() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return time.Now().Before(s.stats.RetryAfter)
+}
+
+func (s *attachLimitState) clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stats.Consecutive > 0 {
+		log.Infof("Attached an ENI after %d failures because the instance ENI limit was exceeded", s.stats.Consecutive)
+	}
+	s.stats.Consecutive = 0
+	s.stats.RetryAfter = time.Time{}
+}
+
+// handleAttachLimitExceeded backs off ENI attachments and re-syncs the datastore with the ENIs attached to the
+// instance, so that the ENIs another agent attached are counted before attaching one again
+func (c *IPAMContext) handleAttachLimitExceeded(err error) {
+	c.attachLimit.lock.Lock()
+	now := time.Now()
+	c.attachLimit.stats.Races++
+	c.attachLimit.stats.Consecutive++
+	c.attachLimit.stats.LastRace = now
+	c.attachLimit.stats.LastError = err.Error()
+	backoff := c.attachLimitBackoff
+	for i := 1; i < c.attachLimit.stats.Consecutive && backoff < maxAttachLimitBackoffMult*c.attachLimitBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxAttachLimitBackoffMult*c.attachLimitBackoff {
+		backoff = maxAttachLimitBackoffMult * c.attachLimitBackoff
+	}
+	c.attachLimit.stats.RetryAfter = now.Add(backoff)
+	c.attachLimit.lock.Unlock()
+
+	ipamdErrInc("attachLimitExceeded")
+	log.Warnf("The instance ENI limit was exceeded, another agent may be attaching ENIs. Re-syncing the ENIs and "+
+		"retrying in %v: %v", backoff, err)
+	c.nodeIPPoolReconcile(0)
+
+	c.attachLimit.lock.Lock()
+	defer c.attachLimit.lock.Unlock()
+	c.attachLimit.stats.AttachedENIs = c.dataStore.GetENIs() + c.unmanagedENI
+	c.attachLimit.stats.UnmanagedENIs = c.unmanagedENI
+}
+
+// GetAttachLimitStats returns the ENI attachments that failed because the instance ENI limit was exceeded
+func (c *IPAMContext) GetAttachLimitStats() AttachLimitStats {
+	c.attachLimit.lock.RLock()
+	defer c.attachLimit.lock.RUnlock()
+	return c.attachLimit.stats
+}
+
+func getAttachLimitBackoff() time.Duration {
+	inputStr, found := os.LookupEnv(envAttachLimitBackoff)
+
+	if !found {
+		return defaultAttachLimitBackoff
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		log.Debugf("Using %s %v", envAttachLimitBackoff, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, using default %v", envAttachLimitBackoff, inputStr, defaultAttachLimitBackoff)
+	return defaultAttachLimitBackoff
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+func TestAllocateENIAttachLimitExceeded(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:          mockAWS,
+		networkClient:      mockNetwork,
+		dataStore:          datastoreWith3FreeIPs(),
+		primaryIP:          make(map[string]string),
+		maxIPsPerENI:       14,
+		attachLimitBackoff: time.Minute,
+	}
+	limitExceeded := errors.Wrap(awserr.New("AttachmentLimitExceeded", "interface count exceeded", nil),
+		"AllocENI: error attaching ENI")
+	ip1, ip2, ip3 := ipaddr01, ipaddr02, ipaddr03
+	// Another agent attached an ENI, tagged for ipamd not to manage it
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return([]awsutils.ENIMetadata{
+		{ENIID: primaryENIid, MAC: primaryMAC, DeviceNumber: 1, IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+			{PrivateIpAddress: &ip1}, {PrivateIpAddress: &ip2}, {PrivateIpAddress: &ip3}}},
+		{ENIID: "eni-other", DeviceNumber: 2, Tags: map[string]string{eniNoManageTagKey: "true"}},
+	}, nil).Times(2)
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid).AnyTimes()
+	mockAWS.EXPECT().GetLocalIPv4().Return(ipaddr01).AnyTimes()
+	mockNetwork.EXPECT().HasLinkIPv4Address(gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+
+	mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "").Return("", limitExceeded)
+	assert.Equal(t, limitExceeded, mockContext.tryAllocateENI())
+	stats := mockContext.GetAttachLimitStats()
+	assert.Equal(t, int64(1), stats.Races)
+	assert.Equal(t, 2, stats.AttachedENIs)
+	assert.Equal(t, 1, stats.UnmanagedENIs)
+	assert.Equal(t, 1, mockContext.unmanagedENI)
+	assert.WithinDuration(t, stats.LastRace.Add(time.Minute), stats.RetryAfter, time.Second)
+
+	// No ENI is attached during the backoff
+	assert.Equal(t, errAttachLimitBackoff, mockContext.tryAllocateENI())
+
+	// The backoff doubles on the next failure
+	mockContext.attachLimit.stats.RetryAfter = time.Now()
+	mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "").Return("", limitExceeded)
+	assert.Equal(t, limitExceeded, mockContext.tryAllocateENI())
+	stats = mockContext.GetAttachLimitStats()
+	assert.Equal(t, int64(2), stats.Races)
+	assert.Equal(t, 2, stats.Consecutive)
+	assert.WithinDuration(t, stats.LastRace.Add(2*time.Minute), stats.RetryAfter, time.Second)
+
+	mockContext.attachLimit.clear()
+	assert.False(t, mockContext.attachLimit.waiting())
+	assert.Equal(t, 0, mockContext.GetAttachLimitStats().Consecutive)
+}
@@ -0,0 +1,259 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do with a branch ENI of the trunk ENI that no pod of the
+	// node lists in its vpc.amazonaws.com/pod-eni annotation, because its DEL cleanup was missed:
+	//   "report" (default): only report it on /v1/branch-eni-cleanup
+	//   "delete": also delete it with EC2
+	// The trunk and branch ENIs are owned by the VPC resource controller, which creates them and writes the
+	// annotations, so ipamd never deletes a branch ENI while the annotation of a pod of the node cannot be parsed.
+	envBranchENICleanupPolicy = "BRANCH_ENI_CLEANUP_POLICY"
+	branchENICleanupReport    = "report"
+	branchENICleanupDelete    = "delete"
+
+	// This environment variable is used to specify, in seconds, for how long a branch ENI must be orphaned before it
+	// is deleted. When it is not set, it defaults to 10 minutes, long enough for the annotation of a new pod to be
+	// seen.
+	envBranchENICleanupGracePeriod     = "BRANCH_ENI_CLEANUP_GRACE_PERIOD"
+	defaultBranchENICleanupGracePeriod = 10 * time.Minute
+
+	// branchENICheckInterval is how often the branch ENIs of the trunk ENI are checked against the pods of the node
+	branchENICheckInterval = time.Minute
+	// maxBranchENICleanups is how many of the recent deletions are kept
+	maxBranchENICleanups = 32
+)
+
+// OrphanedBranchENI is a branch ENI of the trunk ENI that no pod of the node lists
+type OrphanedBranchENI struct {
+	ENIID  string
+	VlanID int
+	// FirstSeen is when the branch ENI was first found orphaned
+	FirstSeen time.Time
+}
+
+// BranchENICleanup is the deletion of an orphaned branch ENI, which failed if Error is set
+type BranchENICleanup struct {
+	ENIID         string
+	VlanID        int
+	OrphanedSince time.Time
+	Time          time.Time
+	Error         string `json:",omitempty"`
+}
+
+// BranchENICleanupStats describes the orphaned branch ENIs and their deletions, for introspection
+type BranchENICleanupStats struct {
+	Policy      string
+	GracePeriod time.Duration
+	// TrunkENI is the trunk ENI seen by the last reconcile, the branch ENIs are only checked when there is one
+	TrunkENI  string `json:",omitempty"`
+	LastCheck time.Time
+	// LastError is why the last check could not be done
+	LastError string `json:",omitempty"`
+	Orphaned  []OrphanedBranchENI
+	// Deleted is the number of orphaned branch ENIs deleted, Failed the number of failed deletions, that are retried
+	// at the next check
+	Deleted int64
+	Failed  int64
+	// Recent are the most recent deletions, oldest first
+	Recent []BranchENICleanup
+}
+
+// branchENICleanupState keeps the trunk ENI and the branch ENIs found orphaned by the last checks
+type branchENICleanupState struct {
+	trunkENI string
+	// branches are the branch ENIs of the trunk ENI found by the last successful check
+	branches  []TrunkBranch
+	orphaned  map[string]OrphanedBranchENI
+	lastCheck time.Time
+	lastError string
+	deleted   int64
+	failed    int64
+	recent    []BranchENICleanup
+	lock      sync.RWMutex
+}
+
+// setTrunkENI records the trunk ENI among the ENIs attached to the instance, if any
+func (s *branchENICleanupState) setTrunkENI(enis []awsutils.ENIMetadata) {
+	trunk, _ := trunkENI(enis)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.trunkENI = trunk.ENIID
+}
+
+func (s *branchENICleanupState) record(cleanup BranchENICleanup) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if cleanup.Error == "" {
+		s.deleted++
+	} else {
+		s.failed++
+	}
+	s.recent = append(s.recent, cleanup)
+	s.recent = s.recent[keepLast(len(s.recent), maxBranchENICleanups):]
+}
+
+// checkBranchENIsIfDue checks the branch ENIs of the trunk ENI against the pods of the node every
+// branchENICheckInterval
+func (c *IPAMContext) checkBranchENIsIfDue() {
+	c.branchENICleanup.lock.RLock()
+	lastCheck := c.branchENICleanup.lastCheck
+	trunk := c.branchENICleanup.trunkENI
+	c.branchENICleanup.lock.RUnlock()
+	if time.Since(lastCheck) < branchENICheckInterval {
+		return
+	}
+	var err error
+	if trunk != "" {
+		err = c.checkBranchENIs(trunk)
+	}
+	c.branchENICleanup.lock.Lock()
+	defer c.branchENICleanup.lock.Unlock()
+	c.branchENICleanup.lastCheck = time.Now()
+	c.branchENICleanup.lastError = ""
+	if trunk == "" {
+		c.branchENICleanup.branches = nil
+		c.branchENICleanup.orphaned = nil
+	}
+	if err != nil {
+		log.Warnf("Skipping branch ENI check: %v", err)
+		c.branchENICleanup.lastError = err.Error()
+	}
+}
+
+// checkBranchENIs finds the branch ENIs of the trunk ENI that no pod of the node lists, and deletes those orphaned
+// for longer than BRANCH_ENI_CLEANUP_GRACE_PERIOD when BRANCH_ENI_CLEANUP_POLICY is "delete". While a pod has an
+// annotation that cannot be parsed, the branch ENIs it lists are unknown, so none is deleted.
+func (c *IPAMContext) checkBranchENIs(trunk string) error {
+	branches, unknownPods, err := c.trunkBranches(trunk)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var toDelete []OrphanedBranchENI
+	c.branchENICleanup.lock.Lock()
+	c.branchENICleanup.branches = branches
+	previous := c.branchENICleanup.orphaned
+	c.branchENICleanup.orphaned = make(map[string]OrphanedBranchENI)
+	for _, branch := range branches {
+		if !branch.Orphaned {
+			continue
+		}
+		orphaned, ok := previous[branch.ENIID]
+		if !ok {
+			orphaned = OrphanedBranchENI{ENIID: branch.ENIID, VlanID: branch.VlanID, FirstSeen: now}
+			log.Warnf("Branch ENI %s on VLAN %d of trunk ENI %s is orphaned, no pod of the node lists it",
+				branch.ENIID, branch.VlanID, trunk)
+		}
+		if c.branchENICleanupPolicy == branchENICleanupDelete &&
+			now.Sub(orphaned.FirstSeen) >= c.branchENICleanupGracePeriod {
+			toDelete = append(toDelete, orphaned)
+		}
+		c.branchENICleanup.orphaned[branch.ENIID] = orphaned
+	}
+	c.branchENICleanup.lock.Unlock()
+
+	if len(unknownPods) > 0 {
+		return errors.Errorf("not deleting the orphaned branch ENIs, the %s annotation of pods %s cannot be parsed",
+			k8sapi.PodENIAnnotation, strings.Join(unknownPods, ", "))
+	}
+	for _, orphaned := range toDelete {
+		c.deleteOrphanedBranchENI(orphaned)
+	}
+	return nil
+}
+
+// deleteOrphanedBranchENI deletes an orphaned branch ENI with EC2. A branch ENI that could not be deleted stays
+// orphaned, and is deleted again at the next check.
+func (c *IPAMContext) deleteOrphanedBranchENI(orphaned OrphanedBranchENI) {
+	cleanup := BranchENICleanup{ENIID: orphaned.ENIID, VlanID: orphaned.VlanID, OrphanedSince: orphaned.FirstSeen}
+	err := c.awsClient.DeleteBranchENI(c.poolManagerContext(), orphaned.ENIID)
+	cleanup.Time = time.Now()
+	if err != nil {
+		log.Errorf("Failed to delete orphaned branch ENI %s: %v", orphaned.ENIID, err)
+		ipamdErrInc("deleteOrphanedBranchENI")
+		cleanup.Error = err.Error()
+	} else {
+		log.Infof("Deleted orphaned branch ENI %s on VLAN %d, orphaned since %s",
+			orphaned.ENIID, orphaned.VlanID, orphaned.FirstSeen.Format(time.RFC3339))
+		c.branchENICleanup.lock.Lock()
+		delete(c.branchENICleanup.orphaned, orphaned.ENIID)
+		c.branchENICleanup.lock.Unlock()
+	}
+	c.branchENICleanup.record(cleanup)
+}
+
+// GetBranchENICleanupStats returns the branch ENIs found orphaned by the last check, oldest first, and the recent
+// deletions
+func (c *IPAMContext) GetBranchENICleanupStats() BranchENICleanupStats {
+	c.branchENICleanup.lock.RLock()
+	defer c.branchENICleanup.lock.RUnlock()
+	stats := BranchENICleanupStats{
+		Policy:      c.branchENICleanupPolicy,
+		GracePeriod: c.branchENICleanupGracePeriod,
+		TrunkENI:    c.branchENICleanup.trunkENI,
+		LastCheck:   c.branchENICleanup.lastCheck,
+		LastError:   c.branchENICleanup.lastError,
+		Orphaned:    make([]OrphanedBranchENI, 0, len(c.branchENICleanup.orphaned)),
+		Deleted:     c.branchENICleanup.deleted,
+		Failed:      c.branchENICleanup.failed,
+		Recent:      append([]BranchENICleanup{}, c.branchENICleanup.recent...),
+	}
+	for _, orphaned := range c.branchENICleanup.orphaned {
+		stats.Orphaned = append(stats.Orphaned, orphaned)
+	}
+	sort.Slice(stats.Orphaned, func(i, j int) bool {
+		return stats.Orphaned[i].FirstSeen.Before(stats.Orphaned[j].FirstSeen)
+	})
+	return stats
+}
+
+func getBranchENICleanupPolicy() string {
+	return envsetting.Choice(envBranchENICleanupPolicy, branchENICleanupReport,
+		branchENICleanupReport, branchENICleanupDelete)
+}
+
+func getBranchENICleanupGracePeriod() time.Duration {
+	inputStr, found := os.LookupEnv(envBranchENICleanupGracePeriod)
+
+	if !found {
+		return defaultBranchENICleanupGracePeriod
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envBranchENICleanupGracePeriod, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, using default %v", envBranchENICleanupGracePeriod, inputStr,
+		defaultBranchENICleanupGracePeriod)
+	return defaultBranchENICleanupGracePeriod
+}
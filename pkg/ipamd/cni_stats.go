@@ -0,0 +1,141 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// cniLatencySamples is the number of the last requests of a verb its latency percentiles are computed on
+	cniLatencySamples = 1024
+	// cniStatsMinutes is the number of one minute buckets the recent requests of a verb are counted in
+	cniStatsMinutes = 60
+)
+
+// CNIMinuteStats counts the requests of a verb served during a minute, for introspection
+type CNIMinuteStats struct {
+	Minute time.Time
+	Count  int64
+	Errors int64
+}
+
+// CNIVerbStats describes the requests of a CNI verb served by ipamd, for introspection
+type CNIVerbStats struct {
+	Count     int64
+	Errors    int64
+	ErrorRate float64
+	// Samples is the number of the last requests the latency percentiles are computed on
+	Samples    int
+	LatencyP50 time.Duration
+	LatencyP90 time.Duration
+	LatencyP99 time.Duration
+	LatencyMax time.Duration
+	// LastHour counts the requests of each minute of the last hour that had some, oldest first
+	LastHour []CNIMinuteStats
+}
+
+// CNIStats describes the CNI requests served by ipamd, by verb, for introspection. The plugin does not implement
+// CHECK, so only ADD and DEL reach ipamd.
+type CNIStats struct {
+	Add CNIVerbStats
+	Del CNIVerbStats
+}
+
+// cniVerbState keeps the counts, the latencies and the per-minute counts of the requests of a verb
+type cniVerbState struct {
+	count     int64
+	errors    int64
+	latencies []time.Duration
+	minutes   [cniStatsMinutes]CNIMinuteStats
+}
+
+// cniStatsState keeps the stats of the ADD and DEL requests
+type cniStatsState struct {
+	add  cniVerbState
+	del  cniVerbState
+	lock sync.RWMutex
+}
+
+func (s *cniStatsState) record(verb *cniVerbState, start time.Time, err error) {
+	now := time.Now()
+	latency := now.Sub(start)
+	minute := now.Truncate(time.Minute)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(verb.latencies) < cniLatencySamples {
+		verb.latencies = append(verb.latencies, latency)
+	} else {
+		verb.latencies[verb.count%cniLatencySamples] = latency
+	}
+	verb.count++
+	bucket := &verb.minutes[(minute.Unix()/60)%cniStatsMinutes]
+	if !bucket.Minute.Equal(minute) {
+		*bucket = CNIMinuteStats{Minute: minute}
+	}
+	bucket.Count++
+	if err != nil {
+		verb.errors++
+		bucket.Errors++
+	}
+}
+
+// recordAdd records an ADD request that started at start, which failed if err is not nil
+func (s *cniStatsState) recordAdd(start time.Time, err error) {
+	s.record(&s.add, start, err)
+}
+
+// recordDel records a DEL request that started at start, which failed if err is not nil
+func (s *cniStatsState) recordDel(start time.Time, err error) {
+	s.record(&s.del, start, err)
+}
+
+func (s *cniStatsState) verbStats(verb *cniVerbState, now time.Time) CNIVerbStats {
+	stats := CNIVerbStats{Count: verb.count, Errors: verb.errors, Samples: len(verb.latencies)}
+	if verb.count > 0 {
+		stats.ErrorRate = float64(verb.errors) / float64(verb.count)
+	}
+	latencies := append([]time.Duration{}, verb.latencies...)
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		last := len(latencies) - 1
+		stats.LatencyP50 = latencies[last*50/100]
+		stats.LatencyP90 = latencies[last*90/100]
+		stats.LatencyP99 = latencies[last*99/100]
+		stats.LatencyMax = latencies[last]
+	}
+	since := now.Truncate(time.Minute).Add(-(cniStatsMinutes - 1) * time.Minute)
+	for _, bucket := range verb.minutes {
+		if bucket.Count > 0 && !bucket.Minute.Before(since) {
+			stats.LastHour = append(stats.LastHour, bucket)
+		}
+	}
+	sort.Slice(stats.LastHour, func(i, j int) bool {
+		return stats.LastHour[i].Minute.Before(stats.LastHour[j].Minute)
+	})
+	return stats
+}
+
+// GetCNIStats returns the counts, error rates and latency percentiles of the ADD and DEL requests
+func (c *IPAMContext) GetCNIStats() CNIStats {
+	now := time.Now()
+	c.cniStats.lock.RLock()
+	defer c.cniStats.lock.RUnlock()
+	return CNIStats{
+		Add: c.cniStats.verbStats(&c.cniStats.add, now),
+		Del: c.cniStats.verbStats(&c.cniStats.del, now),
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what ipamd does while the instance metadata service (IMDS) cannot
+	// be reached by the reconcile, so that the ENIs and IPs it knows may be stale:
+	//   "continue" (default): log the outage and keep managing the pool with the ENIs and IPs of the datastore
+	//   "readonly": also not allocate or release ENIs and IPs until IMDS is reached again. Pods still get the free
+	//   IPs of the datastore.
+	envIMDSUnavailablePolicy = "IMDS_UNAVAILABLE_POLICY"
+	imdsUnavailableContinue  = "continue"
+	imdsUnavailableReadOnly  = "readonly"
+)
+
+// IMDSStatus describes whether the reconcile reaches the instance metadata service, for introspection
+type IMDSStatus struct {
+	Policy    string
+	Available bool
+	// UnavailableSince is when the current outage started, and ConsecutiveFailures the number of reconciles that
+	// failed since then
+	UnavailableSince    time.Time `json:",omitempty"`
+	ConsecutiveFailures int       `json:",omitempty"`
+	// Outages is the number of times IMDS became unreachable
+	Outages     int64
+	LastSuccess time.Time
+	LastFailure time.Time
+	LastError   string `json:",omitempty"`
+	// ReadOnly is true when the pool is not changed because of the outage
+	ReadOnly bool
+}
+
+// Connectivity describes whether ipamd reaches the services it depends on, for introspection
+type Connectivity struct {
+	IMDS IMDSStatus
+}
+
+// imdsState keeps whether the last reconciles reached IMDS
+type imdsState struct {
+	status IMDSStatus
+	lock   sync.RWMutex
+}
+
+// recordIMDSResult keeps whether a reconcile reached IMDS, logging when an outage starts and ends
+func (c *IPAMContext) recordIMDSResult(err error) {
+	c.imds.lock.Lock()
+	defer c.imds.lock.Unlock()
+	now := time.Now()
+	status := &c.imds.status
+	if err == nil {
+		if !status.UnavailableSince.IsZero() {
+			log.Infof("The instance metadata service is reachable again, after %v", now.Sub(status.UnavailableSince))
+		}
+		status.UnavailableSince = time.Time{}
+		status.ConsecutiveFailures = 0
+		status.LastSuccess = now
+		return
+	}
+	if status.UnavailableSince.IsZero() {
+		status.UnavailableSince = now
+		status.Outages++
+		log.Errorf("The instance metadata service cannot be reached, the ENIs and IPs of the datastore may be stale. "+
+			"%s is %q: %v", envIMDSUnavailablePolicy, c.imdsUnavailablePolicy, err)
+		ipamdErrInc("imdsUnavailable")
+	}
+	status.ConsecutiveFailures++
+	status.LastFailure = now
+	status.LastError = err.Error()
+}
+
+// imdsReadOnly returns true if the pool must not change because IMDS cannot be reached and IMDS_UNAVAILABLE_POLICY
+// is "readonly"
+func (c *IPAMContext) imdsReadOnly() bool {
+	if c.imdsUnavailablePolicy != imdsUnavailableReadOnly {
+		return false
+	}
+	c.imds.lock.RLock()
+	defer c.imds.lock.RUnlock()
+	return !c.imds.status.UnavailableSince.IsZero()
+}
+
+// GetConnectivity returns whether ipamd reaches the services it depends on
+func (c *IPAMContext) GetConnectivity() Connectivity {
+	readOnly := c.imdsReadOnly()
+	c.imds.lock.RLock()
+	defer c.imds.lock.RUnlock()
+	imds := c.imds.status
+	imds.Policy = c.imdsUnavailablePolicy
+	imds.Available = imds.UnavailableSince.IsZero()
+	imds.ReadOnly = readOnly
+	return Connectivity{IMDS: imds}
+}
+
+func getIMDSUnavailablePolicy() string {
+	return envsetting.Choice(envIMDSUnavailablePolicy, imdsUnavailableContinue,
+		imdsUnavailableContinue, imdsUnavailableReadOnly)
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to delete the connection tracking entries of the IP of a deleted pod when its
+	// DelNetwork request releases it, so that the stale entries do not misroute the connections of the next pod that
+	// takes the IP. It defaults to false.
+	envConntrackCleanupOnDel = "CONNTRACK_CLEANUP_ON_DEL"
+)
+
+// ConntrackCleanupStats counts the deletions of the connection tracking entries of released IPs, for introspection
+type ConntrackCleanupStats struct {
+	Enabled bool
+	// Cleanups is the number of IPs cleaned up, Deleted the number of entries deleted, and Failures the number of IPs
+	// whose entries could not be deleted
+	Cleanups int64
+	Deleted  int64
+	Failures int64
+	// LastError is why the entries of LastErrorIP could not be deleted, at LastErrorTime
+	LastError     string `json:",omitempty"`
+	LastErrorIP   string `json:",omitempty"`
+	LastErrorTime time.Time
+}
+
+// conntrackCleanupState keeps the deletions of the connection tracking entries of released IPs
+type conntrackCleanupState struct {
+	stats ConntrackCleanupStats
+	lock  sync.RWMutex
+}
+
+// cleanupConntrack deletes the connection tracking entries of an IP released by a DelNetwork request
+func (c *IPAMContext) cleanupConntrack(ip string) {
+	deleted, err := c.networkClient.DeleteConntrackEntries(ip)
+	c.conntrackCleanup.lock.Lock()
+	defer c.conntrackCleanup.lock.Unlock()
+	c.conntrackCleanup.stats.Deleted += int64(deleted)
+	if err != nil {
+		log.Warnf("Failed to delete the conntrack entries of released IP %s: %v", ip, err)
+		ipamdErrInc("conntrackCleanupFailed")
+		c.conntrackCleanup.stats.Failures++
+		c.conntrackCleanup.stats.LastError = err.Error()
+		c.conntrackCleanup.stats.LastErrorIP = ip
+		c.conntrackCleanup.stats.LastErrorTime = time.Now()
+		return
+	}
+	c.conntrackCleanup.stats.Cleanups++
+	if deleted > 0 {
+		log.Debugf("Deleted %d conntrack entries of released IP %s", deleted, ip)
+	}
+}
+
+// GetConntrackCleanupStats returns the deletions of the connection tracking entries of released IPs
+func (c *IPAMContext) GetConntrackCleanupStats() ConntrackCleanupStats {
+	c.conntrackCleanup.lock.RLock()
+	defer c.conntrackCleanup.lock.RUnlock()
+	stats := c.conntrackCleanup.stats
+	stats.Enabled = c.conntrackCleanupOnDel
+	return stats
+}
+
+func conntrackCleanupOnDelEnabled() bool {
+	return getEnvBoolWithDefault(envConntrackCleanupOnDel, false)
+}
@@ -14,9 +14,14 @@
 package datastore
 
 import (
+	"bytes"
+	"math/rand"
+	"net"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	log "github.com/cihub/seelog"
 	"github.com/pkg/errors"
@@ -54,12 +59,24 @@ const (
 	UnknownENIError = "datastore: unknown ENI"
 )
 
+const (
+	// IPOrderLowest assigns pods the free IP address with the lowest value
+	IPOrderLowest = "lowest"
+	// IPOrderHighest assigns pods the free IP address with the highest value
+	IPOrderHighest = "highest"
+	// IPOrderRandom assigns pods a free IP address picked at random
+	IPOrderRandom = "random"
+)
+
 // ErrUnknownPod is an error when there is no pod in data store matching pod name, namespace, sandbox id
 var ErrUnknownPod = errors.New("datastore: unknown pod")
 
 // ErrUnknownPodIP is an error where pod's IP address is not found in data store
 var ErrUnknownPodIP = errors.New("datastore: pod using unknown IP address")
 
+// ErrNoAvailableIPs is an error when there is no free IP address left to assign to a pod
+var ErrNoAvailableIPs = errors.New("assignPodIPv4AddressUnsafe: no available IP addresses")
+
 var (
 	enis = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -103,6 +120,14 @@ type ENIIPPool struct {
 	ID        string
 	// DeviceNumber is the device number of ENI
 	DeviceNumber int
+	// Description is the description of the ENI in EC2
+	Description string `json:",omitempty"`
+	// SubnetID is the subnet of the ENI
+	SubnetID string `json:",omitempty"`
+	// PrimaryIP is the primary IP address of the ENI, which is not in IPv4Addresses
+	PrimaryIP string `json:",omitempty"`
+	// Tags are the tags of the ENI in EC2
+	Tags map[string]string `json:",omitempty"`
 	// AssignedIPv4Addresses is the number of IP addresses already been assigned
 	AssignedIPv4Addresses int
 	// IPv4Addresses shows whether each address is assigned, the key is IP address, which must
@@ -115,6 +140,8 @@ type AddressInfo struct {
 	Address        string
 	Assigned       bool // true if it is assigned to a pod
 	UnassignedTime time.Time
+	// CleanupPending is true while the host side networking of the last pod of the IP may still exist
+	CleanupPending bool `json:",omitempty"`
 }
 
 // PodKey is used to locate pod IP
@@ -122,6 +149,8 @@ type PodKey struct {
 	name      string
 	namespace string
 	sandbox   string
+	// ifName is the name of an additional interface of the pod, it is empty for the pod's default interface
+	ifName string
 }
 
 // PodIPInfo contains pod's IP and the device number of the ENI
@@ -130,6 +159,66 @@ type PodIPInfo struct {
 	IP string
 	// DeviceNumber is the device number of  pod
 	DeviceNumber int
+	// UID is the UID of the pod, when known
+	UID string `json:",omitempty"`
+	// Sandbox is the ID of the pod's sandbox container from the CNI request, the link to the container runtime logs
+	Sandbox string `json:",omitempty"`
+	// IfName is the name of the additional interface this IP is assigned to, empty for the pod's default interface
+	IfName string `json:",omitempty"`
+	// RequestedSubnet is the subnet the pod asked for its IP to come from, if any
+	RequestedSubnet string `json:",omitempty"`
+	// SubnetID is the subnet of the ENI the IP is on
+	SubnetID string `json:",omitempty"`
+	// UseReservedIPs is true if the pod may take one of the reserved IPs
+	UseReservedIPs bool `json:",omitempty"`
+	// DNS is the DNS configuration the CNI plugin returned for the pod, if its network config has one
+	DNS *PodDNS `json:",omitempty"`
+	// Sysctls are the sysctls the CNI plugin applies to the network namespace of the pod, if its network config has
+	// some
+	Sysctls map[string]string `json:",omitempty"`
+	// AssignedTime is when the IP was assigned to the pod, or restored to it after a restart of ipamd
+	AssignedTime time.Time
+}
+
+// PodIPAssignment is an IP address assigned to a pod and when it was assigned
+type PodIPAssignment struct {
+	// Pod is the namespace and name of the pod
+	Pod     string
+	Sandbox string
+	UID     string `json:",omitempty"`
+	// IfName is the additional interface of the pod the IP is assigned to, empty for its default interface
+	IfName       string `json:",omitempty"`
+	IP           string
+	AssignedTime time.Time
+}
+
+// PodDNS is the resolv.conf configuration the CNI plugin returned for a pod
+type PodDNS struct {
+	Nameservers []string `json:",omitempty"`
+	Domain      string   `json:",omitempty"`
+	Search      []string `json:",omitempty"`
+	Options     []string `json:",omitempty"`
+}
+
+// ReservedIPStats contains the usage of the IPs reserved for critical pods, for introspection
+type ReservedIPStats struct {
+	// Reserved is the number of free IPs only critical pods may take, ReservedFree how many of them are free and
+	// GeneralFree the number of free IPs other pods may take
+	Reserved     int
+	ReservedFree int
+	GeneralFree  int
+	// CriticalPodIPs and GeneralPodIPs are the number of IPs assigned to critical and other pods
+	CriticalPodIPs int
+	GeneralPodIPs  int
+	// Rejected is the number of IP requests of other pods that failed because only reserved IPs were free
+	Rejected int64
+}
+
+// PodInterfaceIP contains the IP and the device number of the ENI of an additional interface of a pod
+type PodInterfaceIP struct {
+	IfName       string
+	IP           string
+	DeviceNumber int
 }
 
 // DataStore contains node level ENI/IP
@@ -138,10 +227,125 @@ type DataStore struct {
 	assigned   int
 	eniIPPools map[string]*ENIIPPool
 	podsIP     map[PodKey]PodIPInfo
-	lock       sync.RWMutex
+	// reservedIPs is the number of free IPs that only pods with UseReservedIPs may take
+	reservedIPs      int
+	reservedRejected int64
+	// ipOrder is the order free IPs are assigned to pods in, IPOrderRandom when it is not set
+	ipOrder string
+	// hintSubnets are the subnets whose ENIs, other than the primary ENI, only assign IPs to the pods asking for them
+	hintSubnets map[string]bool
+	lock        timedMutex
+}
+
+// lockSamples is the number of the last acquisitions of the datastore lock its wait and hold times are computed on
+const lockSamples = 1024
+
+// LockStats describes how long the last acquisitions of the datastore lock waited for it and held it, for
+// introspection
+type LockStats struct {
+	// Acquisitions is the number of acquisitions, and Contended those that waited for another holder
+	Acquisitions int64
+	Contended    int64
+	Samples      int
+	WaitP50      time.Duration
+	WaitP99      time.Duration
+	WaitMax      time.Duration
+	HoldP50      time.Duration
+	HoldP99      time.Duration
+	HoldMax      time.Duration
+}
+
+// timedMutex is a mutex recording how long each acquisition waited for it and held it
+type timedMutex struct {
+	mu       sync.Mutex
+	acquired time.Time
+	// held is 1 while the mutex is locked, so that an acquisition can tell it waits for another holder
+	held int32
+
+	statsLock    sync.Mutex
+	acquisitions int64
+	contended    int64
+	waits        []time.Duration
+	holds        []time.Duration
+}
+
+func (m *timedMutex) Lock() {
+	start := time.Now()
+	contended := atomic.LoadInt32(&m.held) == 1
+	m.mu.Lock()
+	atomic.StoreInt32(&m.held, 1)
+	m.acquired = time.Now()
+	wait := m.acquired.Sub(start)
+
+	m.statsLock.Lock()
+	defer m.statsLock.Unlock()
+	if contended {
+		m.contended++
+	}
+	m.waits = appendSample(m.waits, m.acquisitions, wait)
+	m.acquisitions++
+}
+
+func (m *timedMutex) Unlock() {
+	hold := time.Since(m.acquired)
+	// The acquisition is counted once it is locked, so its hold time goes in the same slot as its wait time
+	m.statsLock.Lock()
+	m.holds = appendSample(m.holds, m.acquisitions-1, hold)
+	m.statsLock.Unlock()
+	atomic.StoreInt32(&m.held, 0)
+	m.mu.Unlock()
 }
 
-// PodInfos contains pods IP information which uses key name_namespace_sandbox
+// appendSample keeps the sample of the nth acquisition in a ring of lockSamples samples
+func appendSample(samples []time.Duration, n int64, sample time.Duration) []time.Duration {
+	if len(samples) < lockSamples {
+		return append(samples, sample)
+	}
+	samples[n%lockSamples] = sample
+	return samples
+}
+
+// stats returns the percentiles of the recorded wait and hold times
+func (m *timedMutex) stats() LockStats {
+	m.statsLock.Lock()
+	stats := LockStats{Acquisitions: m.acquisitions, Contended: m.contended, Samples: len(m.holds)}
+	waits := append([]time.Duration{}, m.waits...)
+	holds := append([]time.Duration{}, m.holds...)
+	m.statsLock.Unlock()
+
+	stats.WaitP50, stats.WaitP99, stats.WaitMax = percentiles(waits)
+	stats.HoldP50, stats.HoldP99, stats.HoldMax = percentiles(holds)
+	return stats
+}
+
+func percentiles(samples []time.Duration) (p50, p99, maximum time.Duration) {
+	if len(samples) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	last := len(samples) - 1
+	return samples[last*50/100], samples[last*99/100], samples[last]
+}
+
+// mapEntryOverhead approximates what a Go map adds to each of its entries: the hash byte, the overflow pointers and the
+// free slots of the buckets at the average load factor
+const mapEntryOverhead = 16
+
+// SizeStats estimates the memory used by the ENI and pod maps of the datastore, for introspection. The bytes count the
+// keys and values of the maps, the strings, slices and maps they point to, and mapEntryOverhead per entry; they do not
+// count the allocator overhead, so they are a lower bound.
+type SizeStats struct {
+	ENIs int
+	IPs  int
+	// PodIPs is the number of IPs assigned to pods, including those of their additional interfaces
+	PodIPs     int
+	ENIBytes   int64
+	PodBytes   int64
+	TotalBytes int64
+}
+
+// PodInfos contains pods IP information which uses key name_namespace_sandbox, or name_namespace_sandbox_ifname for
+// additional interfaces
 type PodInfos map[string]PodIPInfo
 
 // ENIInfos contains ENI IP information
@@ -174,6 +378,40 @@ func NewDataStore() *DataStore {
 	}
 }
 
+// SetReservedIPs sets the number of free IPs that only pods with UseReservedIPs may take
+func (ds *DataStore) SetReservedIPs(reservedIPs int) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.reservedIPs = reservedIPs
+}
+
+// SetIPAssignmentOrder sets the order free IPs are assigned to pods in: IPOrderLowest, IPOrderHighest or IPOrderRandom
+func (ds *DataStore) SetIPAssignmentOrder(order string) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.ipOrder = order
+}
+
+// SetHintSubnets sets the subnets pods may ask for. The IPs of the ENIs in them, other than the primary ENI, are only
+// assigned to the pods asking for their subnet.
+func (ds *DataStore) SetHintSubnets(subnets []string) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.hintSubnets = make(map[string]bool, len(subnets))
+	for _, subnet := range subnets {
+		ds.hintSubnets[subnet] = true
+	}
+}
+
+// eniServesPodUnsafe returns true if the ENI may assign an IP to the pod: an ENI in the subnet the pod asks for, or,
+// for a pod that asks for none, an ENI that is not reserved for the pods asking for its subnet
+func (ds *DataStore) eniServesPodUnsafe(eni *ENIIPPool, k8sPod *k8sapi.K8SPodInfo) bool {
+	if k8sPod.SubnetHint != "" {
+		return eni.SubnetID == k8sPod.SubnetHint
+	}
+	return eni.IsPrimary || !ds.hintSubnets[eni.SubnetID]
+}
+
 // AddENI add ENI to data store
 func (ds *DataStore) AddENI(eniID string, deviceNumber int, isPrimary bool) error {
 	ds.lock.Lock()
@@ -195,6 +433,70 @@ func (ds *DataStore) AddENI(eniID string, deviceNumber int, isPrimary bool) erro
 	return nil
 }
 
+// SetENIDescription records the EC2 description of an ENI in the data store
+func (ds *DataStore) SetENIDescription(eniID string, description string) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	curENI, ok := ds.eniIPPools[eniID]
+	if !ok {
+		return errors.New("set ENI description: unknown ENI")
+	}
+	curENI.Description = description
+	return nil
+}
+
+// SetENISubnet records the subnet of an ENI in the data store
+func (ds *DataStore) SetENISubnet(eniID string, subnetID string) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	curENI, ok := ds.eniIPPools[eniID]
+	if !ok {
+		return errors.New("set ENI subnet: unknown ENI")
+	}
+	curENI.SubnetID = subnetID
+	return nil
+}
+
+// SetENIPrimaryIP records the primary IP address of an ENI in the data store
+func (ds *DataStore) SetENIPrimaryIP(eniID string, ip string) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	curENI, ok := ds.eniIPPools[eniID]
+	if !ok {
+		return errors.New("set ENI primary IP: unknown ENI")
+	}
+	curENI.PrimaryIP = ip
+	return nil
+}
+
+// SetENITags sets the tags of the ENI in EC2
+func (ds *DataStore) SetENITags(eniID string, tags map[string]string) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	curENI, ok := ds.eniIPPools[eniID]
+	if !ok {
+		return errors.New("set ENI tags: unknown ENI")
+	}
+	curENI.Tags = tags
+	return nil
+}
+
+// GetENICreateTime returns when the ENI was added to the datastore
+func (ds *DataStore) GetENICreateTime(eniID string) (time.Time, error) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	curENI, ok := ds.eniIPPools[eniID]
+	if !ok {
+		return time.Time{}, errors.New(UnknownENIError)
+	}
+	return curENI.createTime, nil
+}
+
 // AddIPv4AddressToStore add an IP of an ENI to data store
 func (ds *DataStore) AddIPv4AddressToStore(eniID string, ipv4 string) error {
 	ds.lock.Lock()
@@ -240,6 +542,9 @@ func (ds *DataStore) DelIPv4AddressFromStore(eniID string, ipv4 string, force bo
 		return errors.New(UnknownIPError)
 	}
 
+	if ipAddr.CleanupPending && !force {
+		return errors.New(IPInUseError)
+	}
 	if ipAddr.Assigned {
 		if !force {
 			return errors.New(IPInUseError)
@@ -265,7 +570,7 @@ func (ds *DataStore) DelIPv4AddressFromStore(eniID string, ipv4 string, force bo
 	return nil
 }
 
-// AssignPodIPv4Address assigns an IPv4 address to pod
+// AssignPodIPv4Address assigns an IPv4 address to pod, from an ENI in k8sPod.SubnetHint if it is set
 // It returns the assigned IPv4 address, device number, error
 func (ds *DataStore) AssignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip string, deviceNumber int, err error) {
 	ds.lock.Lock()
@@ -293,36 +598,392 @@ func (ds *DataStore) AssignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip string,
 }
 
 // It returns the assigned IPv4 address, device number, error
+// AssignPodIPv4AddressPreferred assigns preferredIP to the pod if it is in the datastore and not assigned, otherwise
+// it assigns any available IP address. preferred is true if the pod got preferredIP.
+func (ds *DataStore) AssignPodIPv4AddressPreferred(k8sPod *k8sapi.K8SPodInfo, preferredIP string) (ip string, deviceNumber int, preferred bool, err error) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	podKey := PodKey{
+		name:      k8sPod.Name,
+		namespace: k8sPod.Namespace,
+		sandbox:   k8sPod.Sandbox,
+	}
+	if ipAddr, ok := ds.podsIP[podKey]; ok {
+		log.Errorf("AssignPodIPv4AddressPreferred: pod(name %s, namespace %s, sandbox %s) already has IP %s",
+			k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox, ipAddr.IP)
+		return "", 0, false, errors.New("AssignPodIPv4Address: invalid pod with multiple IP addresses")
+	}
+	if ds.onlyReservedIPsFreeUnsafe(k8sPod) {
+		return "", 0, false, ErrNoAvailableIPs
+	}
+
+	for _, eni := range ds.eniIPPools {
+		addr, ok := eni.IPv4Addresses[preferredIP]
+		if ok && !addr.Assigned && ds.eniServesPodUnsafe(eni, k8sPod) {
+			incrementAssignedCount(ds, eni, addr)
+			log.Infof("AssignPodIPv4AddressPreferred: Assign preferred IP %v to pod (name %s, namespace %s sandbox %s)",
+				addr.Address, k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox)
+			ds.podsIP[podKey] = newPodIPInfo(podKey, k8sPod, eni, addr.Address)
+			return addr.Address, eni.DeviceNumber, true, nil
+		}
+	}
+	log.Infof("AssignPodIPv4AddressPreferred: preferred IP %s is not available for pod (name %s, namespace %s)",
+		preferredIP, k8sPod.Name, k8sPod.Namespace)
+	ip, deviceNumber, err = ds.assignPodIPv4AddressUnsafe(podKey, &k8sapi.K8SPodInfo{
+		Name:           k8sPod.Name,
+		Namespace:      k8sPod.Namespace,
+		Sandbox:        k8sPod.Sandbox,
+		UID:            k8sPod.UID,
+		SubnetHint:     k8sPod.SubnetHint,
+		UseReservedIPs: k8sPod.UseReservedIPs,
+	})
+	return ip, deviceNumber, false, err
+}
+
 func (ds *DataStore) assignPodIPv4AddressUnsafe(podKey PodKey, k8sPod *k8sapi.K8SPodInfo) (ip string, deviceNumber int, err error) {
+	if k8sPod.IP != "" {
+		for _, eni := range ds.eniIPPools {
+			addr, ok := eni.IPv4Addresses[k8sPod.IP]
+			if !ok {
+				continue
+			}
+			// After L-IPAM restart and built IP warm-pool, it needs to take the existing running pod IP out of the pool.
+			if !addr.Assigned {
+				incrementAssignedCount(ds, eni, addr)
+			}
+			log.Infof("AssignPodIPv4Address: Reassign IP %v to pod (name %s, namespace %s)",
+				addr.Address, k8sPod.Name, k8sPod.Namespace)
+			ds.podsIP[podKey] = newPodIPInfo(podKey, k8sPod, eni, addr.Address)
+			return addr.Address, eni.DeviceNumber, nil
+		}
+		log.Errorf("DataStore has no available IP addresses")
+		return "", 0, ErrNoAvailableIPs
+	}
+
+	if ds.onlyReservedIPsFreeUnsafe(k8sPod) {
+		return "", 0, ErrNoAvailableIPs
+	}
+	var candidates []freeIP
 	for _, eni := range ds.eniIPPools {
-		if (k8sPod.IP == "") && (len(eni.IPv4Addresses) == eni.AssignedIPv4Addresses) {
+		if len(eni.IPv4Addresses) == eni.AssignedIPv4Addresses {
 			// Skip this ENI, since it has no available IP addresses
 			log.Debugf("AssignPodIPv4Address: Skip ENI %s that does not have available addresses", eni.ID)
 			continue
 		}
+		if !ds.eniServesPodUnsafe(eni, k8sPod) {
+			log.Debugf("AssignPodIPv4Address: Skip ENI %s in subnet %s, the pod asks for subnet %q", eni.ID,
+				eni.SubnetID, k8sPod.SubnetHint)
+			continue
+		}
 		for _, addr := range eni.IPv4Addresses {
-			if k8sPod.IP == addr.Address {
-				// After L-IPAM restart and built IP warm-pool, it needs to take the existing running pod IP out of the pool.
-				if !addr.Assigned {
-					incrementAssignedCount(ds, eni, addr)
-				}
-				log.Infof("AssignPodIPv4Address: Reassign IP %v to pod (name %s, namespace %s)",
-					addr.Address, k8sPod.Name, k8sPod.Namespace)
-				ds.podsIP[podKey] = PodIPInfo{IP: addr.Address, DeviceNumber: eni.DeviceNumber}
-				return addr.Address, eni.DeviceNumber, nil
+			if !addr.Assigned && !addr.inCoolingPeriod() && !addr.CleanupPending {
+				candidates = append(candidates, freeIP{eni: eni, addr: addr})
 			}
-			if !addr.Assigned && k8sPod.IP == "" && !addr.inCoolingPeriod() {
-				// This is triggered by a pod's Add Network command from CNI plugin
-				incrementAssignedCount(ds, eni, addr)
-				log.Infof("AssignPodIPv4Address: Assign IP %v to pod (name %s, namespace %s sandbox %s)",
-					addr.Address, k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox)
-				ds.podsIP[podKey] = PodIPInfo{IP: addr.Address, DeviceNumber: eni.DeviceNumber}
-				return addr.Address, eni.DeviceNumber, nil
+		}
+	}
+	if len(candidates) == 0 {
+		log.Errorf("DataStore has no available IP addresses")
+		return "", 0, ErrNoAvailableIPs
+	}
+
+	// This is triggered by a pod's Add Network command from CNI plugin
+	picked := ds.pickFreeIPUnsafe(candidates)
+	incrementAssignedCount(ds, picked.eni, picked.addr)
+	log.Infof("AssignPodIPv4Address: Assign IP %v to pod (name %s, namespace %s sandbox %s)",
+		picked.addr.Address, k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox)
+	ds.podsIP[podKey] = newPodIPInfo(podKey, k8sPod, picked.eni, picked.addr.Address)
+	return picked.addr.Address, picked.eni.DeviceNumber, nil
+}
+
+// freeIP is an IP address that may be assigned to a pod, and its ENI
+type freeIP struct {
+	eni  *ENIIPPool
+	addr *AddressInfo
+}
+
+// pickFreeIPUnsafe returns the candidate that comes first in the IP assignment order
+func (ds *DataStore) pickFreeIPUnsafe(candidates []freeIP) freeIP {
+	if ds.ipOrder != IPOrderLowest && ds.ipOrder != IPOrderHighest {
+		return candidates[rand.Intn(len(candidates))]
+	}
+	picked := candidates[0]
+	for _, candidate := range candidates[1:] {
+		cmp := bytes.Compare(net.ParseIP(candidate.addr.Address).To16(), net.ParseIP(picked.addr.Address).To16())
+		if (ds.ipOrder == IPOrderLowest && cmp < 0) || (ds.ipOrder == IPOrderHighest && cmp > 0) {
+			picked = candidate
+		}
+	}
+	return picked
+}
+
+func newPodIPInfo(podKey PodKey, k8sPod *k8sapi.K8SPodInfo, eni *ENIIPPool, ip string) PodIPInfo {
+	return PodIPInfo{
+		IP:              ip,
+		DeviceNumber:    eni.DeviceNumber,
+		UID:             k8sPod.UID,
+		Sandbox:         podKey.sandbox,
+		IfName:          podKey.ifName,
+		RequestedSubnet: k8sPod.SubnetHint,
+		SubnetID:        eni.SubnetID,
+		UseReservedIPs:  k8sPod.UseReservedIPs,
+		AssignedTime:    time.Now(),
+	}
+}
+
+// GetFreeIPs returns the number of IPs that may be assigned to a pod right away, that are neither assigned nor in
+// their cooling period
+func (ds *DataStore) GetFreeIPs() int {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	return ds.freeIPsUnsafe()
+}
+
+// freeIPsUnsafe returns the number of IPs that are neither assigned nor in their cooling period
+func (ds *DataStore) freeIPsUnsafe() int {
+	free := 0
+	for _, eni := range ds.eniIPPools {
+		for _, addr := range eni.IPv4Addresses {
+			if !addr.Assigned && !addr.inCoolingPeriod() && !addr.CleanupPending {
+				free++
 			}
 		}
 	}
-	log.Errorf("DataStore has no available IP addresses")
-	return "", 0, errors.New("assignPodIPv4AddressUnsafe: no available IP addresses")
+	return free
+}
+
+// onlyReservedIPsFreeUnsafe returns true, and counts the rejection, if the pod may not take a reserved IP and no
+// other IP is free
+func (ds *DataStore) onlyReservedIPsFreeUnsafe(k8sPod *k8sapi.K8SPodInfo) bool {
+	if ds.reservedIPs == 0 || k8sPod.UseReservedIPs || ds.freeIPsUnsafe() > ds.reservedIPs {
+		return false
+	}
+	ds.reservedRejected++
+	log.Infof("AssignPodIPv4Address: only the %d reserved IP addresses are free, none for pod (name %s, namespace %s)",
+		ds.reservedIPs, k8sPod.Name, k8sPod.Namespace)
+	return true
+}
+
+// GetReservedIPStats returns the usage of the reserved IPs
+func (ds *DataStore) GetReservedIPStats() ReservedIPStats {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	stats := ReservedIPStats{Reserved: ds.reservedIPs, Rejected: ds.reservedRejected}
+	free := ds.freeIPsUnsafe()
+	if free > ds.reservedIPs {
+		stats.ReservedFree = ds.reservedIPs
+		stats.GeneralFree = free - ds.reservedIPs
+	} else {
+		stats.ReservedFree = free
+	}
+	for _, ipAddr := range ds.podsIP {
+		if ipAddr.UseReservedIPs {
+			stats.CriticalPodIPs++
+		} else {
+			stats.GeneralPodIPs++
+		}
+	}
+	return stats
+}
+
+// AssignPodInterfaceIPv4Address assigns an IPv4 address to an additional interface of a pod, k8sPod.IP if it is set
+// It returns the assigned IPv4 address, device number, error
+func (ds *DataStore) AssignPodInterfaceIPv4Address(k8sPod *k8sapi.K8SPodInfo, ifName string) (ip string, deviceNumber int, err error) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	podKey := PodKey{
+		name:      k8sPod.Name,
+		namespace: k8sPod.Namespace,
+		sandbox:   k8sPod.Sandbox,
+		ifName:    ifName,
+	}
+	if ipAddr, ok := ds.podsIP[podKey]; ok {
+		log.Errorf("AssignPodInterfaceIPv4Address: interface %s of pod(name %s, namespace %s, sandbox %s) already has IP %s",
+			ifName, k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox, ipAddr.IP)
+		return "", 0, errors.New("AssignPodInterfaceIPv4Address: invalid pod interface with multiple IP addresses")
+	}
+	return ds.assignPodIPv4AddressUnsafe(podKey, k8sPod)
+}
+
+// GetPodIPv4Address returns the IP address assigned to the default interface of the pod, if any
+func (ds *DataStore) GetPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip string, deviceNumber int, found bool) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	ipAddr, ok := ds.podsIP[PodKey{
+		name:      k8sPod.Name,
+		namespace: k8sPod.Namespace,
+		sandbox:   k8sPod.Sandbox,
+	}]
+	if !ok {
+		return "", 0, false
+	}
+	return ipAddr.IP, ipAddr.DeviceNumber, true
+}
+
+// SetPodDNS records the DNS configuration of the pod on the entry of its default interface
+func (ds *DataStore) SetPodDNS(k8sPod *k8sapi.K8SPodInfo, dns *PodDNS) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	podKey := PodKey{
+		name:      k8sPod.Name,
+		namespace: k8sPod.Namespace,
+		sandbox:   k8sPod.Sandbox,
+	}
+	ipAddr, ok := ds.podsIP[podKey]
+	if !ok {
+		return ErrUnknownPod
+	}
+	ipAddr.DNS = dns
+	ds.podsIP[podKey] = ipAddr
+	return nil
+}
+
+// SetPodSysctls records the sysctls applied to the network namespace of the pod on the entry of its default interface
+func (ds *DataStore) SetPodSysctls(k8sPod *k8sapi.K8SPodInfo, sysctls map[string]string) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	podKey := PodKey{
+		name:      k8sPod.Name,
+		namespace: k8sPod.Namespace,
+		sandbox:   k8sPod.Sandbox,
+	}
+	ipAddr, ok := ds.podsIP[podKey]
+	if !ok {
+		return ErrUnknownPod
+	}
+	ipAddr.Sysctls = sysctls
+	ds.podsIP[podKey] = ipAddr
+	return nil
+}
+
+// GetAssignedPods returns the pods that have an IP address for their default interface, with their IP and UID
+func (ds *DataStore) GetAssignedPods() []k8sapi.K8SPodInfo {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	pods := make([]k8sapi.K8SPodInfo, 0, len(ds.podsIP))
+	for podKey, ipAddr := range ds.podsIP {
+		if podKey.ifName != "" {
+			continue
+		}
+		pods = append(pods, k8sapi.K8SPodInfo{
+			Name:      podKey.name,
+			Namespace: podKey.namespace,
+			Sandbox:   podKey.sandbox,
+			IP:        ipAddr.IP,
+			UID:       ipAddr.UID,
+		})
+	}
+	return pods
+}
+
+// GetENIPods returns the pods that have an IP address of the ENI, once per interface, sorted by namespace and name
+func (ds *DataStore) GetENIPods(eniID string) []k8sapi.K8SPodInfo {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	pods := make([]k8sapi.K8SPodInfo, 0)
+	eni, ok := ds.eniIPPools[eniID]
+	if !ok {
+		return pods
+	}
+	for podKey, ipAddr := range ds.podsIP {
+		if _, ok := eni.IPv4Addresses[ipAddr.IP]; !ok {
+			continue
+		}
+		pods = append(pods, k8sapi.K8SPodInfo{
+			Name:      podKey.name,
+			Namespace: podKey.namespace,
+			Sandbox:   podKey.sandbox,
+			IP:        ipAddr.IP,
+			UID:       ipAddr.UID,
+		})
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		if pods[i].Name != pods[j].Name {
+			return pods[i].Name < pods[j].Name
+		}
+		return pods[i].IP < pods[j].IP
+	})
+	return pods
+}
+
+// GetPodIPsAssignedBefore returns the IP addresses assigned to pods before the time, the oldest first
+func (ds *DataStore) GetPodIPsAssignedBefore(before time.Time) []PodIPAssignment {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	assignments := make([]PodIPAssignment, 0)
+	for podKey, ipAddr := range ds.podsIP {
+		if !ipAddr.AssignedTime.Before(before) {
+			continue
+		}
+		assignments = append(assignments, PodIPAssignment{
+			Pod:          podKey.namespace + "/" + podKey.name,
+			Sandbox:      podKey.sandbox,
+			UID:          ipAddr.UID,
+			IfName:       podKey.ifName,
+			IP:           ipAddr.IP,
+			AssignedTime: ipAddr.AssignedTime,
+		})
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].AssignedTime.Before(assignments[j].AssignedTime)
+	})
+	return assignments
+}
+
+// GetNamespaceIPCounts returns the number of IP addresses assigned to the pods of each namespace, counting the IP
+// addresses of the additional interfaces of the pods
+func (ds *DataStore) GetNamespaceIPCounts() map[string]int {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	counts := make(map[string]int)
+	for podKey := range ds.podsIP {
+		counts[podKey.namespace]++
+	}
+	return counts
+}
+
+// GetIPv4AddressENI returns the ID of the ENI the IP address is on, or an empty string if it is not in the datastore
+func (ds *DataStore) GetIPv4AddressENI(ip string) string {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	for eniID, eni := range ds.eniIPPools {
+		if _, ok := eni.IPv4Addresses[ip]; ok {
+			return eniID
+		}
+	}
+	return ""
+}
+
+// GetPodInterfaces returns the IP addresses assigned to the additional interfaces of the pod, sorted by interface name
+func (ds *DataStore) GetPodInterfaces(k8sPod *k8sapi.K8SPodInfo) []PodInterfaceIP {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	interfaces := make([]PodInterfaceIP, 0)
+	for podKey, ipAddr := range ds.podsIP {
+		if podKey.ifName == "" || podKey.name != k8sPod.Name || podKey.namespace != k8sPod.Namespace ||
+			podKey.sandbox != k8sPod.Sandbox {
+			continue
+		}
+		interfaces = append(interfaces, PodInterfaceIP{IfName: podKey.ifName, IP: ipAddr.IP, DeviceNumber: ipAddr.DeviceNumber})
+	}
+	sort.Slice(interfaces, func(i, j int) bool {
+		return interfaces[i].IfName < interfaces[j].IfName
+	})
+	return interfaces
 }
 
 func incrementAssignedCount(ds *DataStore, eni *ENIIPPool, addr *AddressInfo) {
@@ -438,6 +1099,10 @@ func (ds *DataStore) GetENINeedsIP(maxIPperENI int, skipPrimary bool) *ENIIPPool
 			log.Debugf("Skip the primary ENI for need IP check")
 			continue
 		}
+		if !eni.IsPrimary && ds.hintSubnets[eni.SubnetID] {
+			// The pool of the ENIs reserved for the pods asking for their subnet only grows for those pods
+			continue
+		}
 		eniIDs = append(eniIDs, eniID)
 	}
 	sort.Strings(eniIDs)
@@ -522,6 +1187,16 @@ func (ds *DataStore) RemoveENIFromDataStore(eni string, force bool) error {
 // UnassignPodIPv4Address a) find out the IP address based on PodName and PodNameSpace
 // b)  mark IP address as unassigned c) returns IP address, ENI's device number, error
 func (ds *DataStore) UnassignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip string, deviceNumber int, err error) {
+	return ds.unassignPodIPv4Address(k8sPod, true)
+}
+
+// UnassignPodIPv4AddressNoCooling unassigns the IPv4 address of a pod that never used it, such as a synthetic pod, so
+// that the address is free again right away, without going through its cooling period
+func (ds *DataStore) UnassignPodIPv4AddressNoCooling(k8sPod *k8sapi.K8SPodInfo) (ip string, deviceNumber int, err error) {
+	return ds.unassignPodIPv4Address(k8sPod, false)
+}
+
+func (ds *DataStore) unassignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo, cool bool) (ip string, deviceNumber int, err error) {
 	ds.lock.Lock()
 	defer ds.lock.Unlock()
 	log.Debugf("UnassignPodIPv4Address: IP address pool stats: total:%d, assigned %d, pod(Name: %s, Namespace: %s, Sandbox %s)",
@@ -542,7 +1217,11 @@ func (ds *DataStore) UnassignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip strin
 	for _, eni := range ds.eniIPPools {
 		ip, ok := eni.IPv4Addresses[ipAddr.IP]
 		if ok && ip.Assigned {
+			lastUnassignedTime, unassignedTime := eni.lastUnassignedTime, ip.UnassignedTime
 			decrementAssignedCount(ds, eni, ip)
+			if !cool {
+				eni.lastUnassignedTime, ip.UnassignedTime = lastUnassignedTime, unassignedTime
+			}
 			log.Infof("UnassignPodIPv4Address: pod (Name: %s, NameSpace %s Sandbox %s)'s ipAddr %s, DeviceNumber%d",
 				k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox, ip.Address, eni.DeviceNumber)
 			delete(ds.podsIP, podKey)
@@ -555,6 +1234,36 @@ func (ds *DataStore) UnassignPodIPv4Address(k8sPod *k8sapi.K8SPodInfo) (ip strin
 	return "", 0, ErrUnknownPodIP
 }
 
+// UnassignPodInterfaces unassigns the IPv4 addresses of all the additional interfaces of a pod
+// It returns the released interfaces, sorted by interface name
+func (ds *DataStore) UnassignPodInterfaces(k8sPod *k8sapi.K8SPodInfo) []PodInterfaceIP {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	released := make([]PodInterfaceIP, 0)
+	for podKey, ipAddr := range ds.podsIP {
+		if podKey.ifName == "" || podKey.name != k8sPod.Name || podKey.namespace != k8sPod.Namespace ||
+			podKey.sandbox != k8sPod.Sandbox {
+			continue
+		}
+		delete(ds.podsIP, podKey)
+		for _, eni := range ds.eniIPPools {
+			ip, ok := eni.IPv4Addresses[ipAddr.IP]
+			if ok && ip.Assigned {
+				decrementAssignedCount(ds, eni, ip)
+				log.Infof("UnassignPodInterfaces: pod (Name: %s, NameSpace %s Sandbox %s)'s interface %s ipAddr %s, DeviceNumber%d",
+					k8sPod.Name, k8sPod.Namespace, k8sPod.Sandbox, podKey.ifName, ip.Address, eni.DeviceNumber)
+				released = append(released, PodInterfaceIP{IfName: podKey.ifName, IP: ip.Address, DeviceNumber: eni.DeviceNumber})
+				break
+			}
+		}
+	}
+	sort.Slice(released, func(i, j int) bool {
+		return released[i].IfName < released[j].IfName
+	})
+	return released
+}
+
 // GetPodInfos provides pod IP information to introspection endpoint
 func (ds *DataStore) GetPodInfos() *map[string]PodIPInfo {
 	ds.lock.Lock()
@@ -564,6 +1273,9 @@ func (ds *DataStore) GetPodInfos() *map[string]PodIPInfo {
 
 	for podKey, podInfo := range ds.podsIP {
 		key := podKey.name + "_" + podKey.namespace + "_" + podKey.sandbox
+		if podKey.ifName != "" {
+			key += "_" + podKey.ifName
+		}
 		podInfos[key] = podInfo
 		log.Debugf("GetPodInfos: key %s", key)
 	}
@@ -589,6 +1301,185 @@ func (ds *DataStore) GetENIInfos() *ENIInfos {
 	return &eniInfos
 }
 
+// CoolingIPInfo describes an unassigned IP that can not be assigned again until its cooling period is over
+type CoolingIPInfo struct {
+	IP  string
+	ENI string
+	// UnassignedTime is when the IP was unassigned from its last pod and entered the cooling period
+	UnassignedTime time.Time
+	Remaining      time.Duration
+}
+
+// GetCoolingIPs provides the IPs in their cooling period to introspection endpoint, sorted by IP
+func (ds *DataStore) GetCoolingIPs() []CoolingIPInfo {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	coolingIPs := make([]CoolingIPInfo, 0)
+	for eniID, eni := range ds.eniIPPools {
+		for _, addr := range eni.IPv4Addresses {
+			if addr.Assigned || !addr.inCoolingPeriod() {
+				continue
+			}
+			coolingIPs = append(coolingIPs, CoolingIPInfo{
+				IP:             addr.Address,
+				ENI:            eniID,
+				UnassignedTime: addr.UnassignedTime,
+				Remaining:      addressCoolingPeriod - time.Since(addr.UnassignedTime),
+			})
+		}
+	}
+	sort.Slice(coolingIPs, func(i, j int) bool {
+		return coolingIPs[i].IP < coolingIPs[j].IP
+	})
+	return coolingIPs
+}
+
+// DetachBlockedENI is an ENI that would be detached if its IPs were not assigned to pods
+type DetachBlockedENI struct {
+	ENIID        string
+	DeviceNumber int
+	AssignedIPs  int
+	FreeIPs      int
+	// Pods are the pods holding the IPs of the ENI, sorted by namespace and name
+	Pods []DetachBlockingPod
+}
+
+// DetachBlockingPod is a pod holding an IP of an ENI that would otherwise be detached
+type DetachBlockingPod struct {
+	Name      string
+	Namespace string
+	Sandbox   string
+	IP        string
+	// IfName is the additional interface the IP is assigned to, empty for the pod's default interface
+	IfName string `json:",omitempty"`
+}
+
+// GetDetachBlockedENIs returns the ENIs that are only kept because pods still hold some of their IPs: they are not
+// primary, old enough to be deleted, and not needed for the warm IP and minimum IP targets. They are sorted by ENI ID.
+func (ds *DataStore) GetDetachBlockedENIs(warmIPTarget int, minimumIPTarget int) []DetachBlockedENI {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	blocked := make(map[string]*DetachBlockedENI)
+	for _, eni := range ds.eniIPPools {
+		if eni.IsPrimary || eni.isTooYoung() || !eni.hasPods() {
+			continue
+		}
+		if warmIPTarget != 0 && ds.isRequiredForWarmIPTarget(warmIPTarget, eni) {
+			continue
+		}
+		if minimumIPTarget != 0 && ds.isRequiredForMinimumIPTarget(minimumIPTarget, eni) {
+			continue
+		}
+		blocked[eni.ID] = &DetachBlockedENI{
+			ENIID:        eni.ID,
+			DeviceNumber: eni.DeviceNumber,
+			AssignedIPs:  eni.AssignedIPv4Addresses,
+			FreeIPs:      len(eni.IPv4Addresses) - eni.AssignedIPv4Addresses,
+			Pods:         make([]DetachBlockingPod, 0, eni.AssignedIPv4Addresses),
+		}
+	}
+	for podKey, ipInfo := range ds.podsIP {
+		for eniID, blockedENI := range blocked {
+			if _, ok := ds.eniIPPools[eniID].IPv4Addresses[ipInfo.IP]; !ok {
+				continue
+			}
+			blockedENI.Pods = append(blockedENI.Pods, DetachBlockingPod{
+				Name:      podKey.name,
+				Namespace: podKey.namespace,
+				Sandbox:   podKey.sandbox,
+				IP:        ipInfo.IP,
+				IfName:    podKey.ifName,
+			})
+		}
+	}
+
+	enis := make([]DetachBlockedENI, 0, len(blocked))
+	for _, blockedENI := range blocked {
+		pods := blockedENI.Pods
+		sort.Slice(pods, func(i, j int) bool {
+			if pods[i].Namespace != pods[j].Namespace {
+				return pods[i].Namespace < pods[j].Namespace
+			}
+			if pods[i].Name != pods[j].Name {
+				return pods[i].Name < pods[j].Name
+			}
+			return pods[i].IfName < pods[j].IfName
+		})
+		enis = append(enis, *blockedENI)
+	}
+	sort.Slice(enis, func(i, j int) bool {
+		return enis[i].ENIID < enis[j].ENIID
+	})
+	return enis
+}
+
+// SetCleanupPending marks an unassigned IP as waiting for the host side networking of its last pod to be removed, so
+// that it is not assigned again, or clears the mark
+func (ds *DataStore) SetCleanupPending(ip string, pending bool) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	for _, eni := range ds.eniIPPools {
+		addr, ok := eni.IPv4Addresses[ip]
+		if !ok {
+			continue
+		}
+		if pending && addr.Assigned {
+			return errors.New(IPInUseError)
+		}
+		addr.CleanupPending = pending
+		return nil
+	}
+	return errors.New(UnknownIPError)
+}
+
+// GetLockStats returns how long the last acquisitions of the datastore lock waited for it and held it
+func (ds *DataStore) GetLockStats() LockStats {
+	return ds.lock.stats()
+}
+
+// GetSizeStats returns the number of entries of the ENI and pod maps and an estimate of the memory they use
+func (ds *DataStore) GetSizeStats() SizeStats {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	stats := SizeStats{ENIs: len(ds.eniIPPools), PodIPs: len(ds.podsIP)}
+	for eniID, eni := range ds.eniIPPools {
+		stats.IPs += len(eni.IPv4Addresses)
+		stats.ENIBytes += int64(len(eniID)+int(unsafe.Sizeof(eniID))+int(unsafe.Sizeof(eni))) + mapEntryOverhead
+		stats.ENIBytes += int64(unsafe.Sizeof(*eni)) + int64(len(eni.ID)+len(eni.Description)+len(eni.SubnetID)+
+			len(eni.PrimaryIP))
+		for key, value := range eni.Tags {
+			stats.ENIBytes += int64(len(key)+len(value)+2*int(unsafe.Sizeof(key))) + mapEntryOverhead
+		}
+		for ip, addr := range eni.IPv4Addresses {
+			stats.ENIBytes += int64(len(ip)+int(unsafe.Sizeof(ip))+int(unsafe.Sizeof(addr))) + mapEntryOverhead
+			stats.ENIBytes += int64(unsafe.Sizeof(*addr)) + int64(len(addr.Address))
+		}
+	}
+	for key, info := range ds.podsIP {
+		stats.PodBytes += int64(unsafe.Sizeof(key)+unsafe.Sizeof(info)) + mapEntryOverhead
+		stats.PodBytes += int64(len(key.name) + len(key.namespace) + len(key.sandbox) + len(key.ifName))
+		stats.PodBytes += int64(len(info.IP) + len(info.UID) + len(info.IfName) + len(info.RequestedSubnet) +
+			len(info.SubnetID))
+		if info.DNS != nil {
+			stats.PodBytes += int64(unsafe.Sizeof(*info.DNS)) + int64(len(info.DNS.Domain))
+			for _, values := range [][]string{info.DNS.Nameservers, info.DNS.Search, info.DNS.Options} {
+				for _, value := range values {
+					stats.PodBytes += int64(len(value) + int(unsafe.Sizeof(value)))
+				}
+			}
+		}
+		for name, value := range info.Sysctls {
+			stats.PodBytes += int64(len(name)+len(value)+2*int(unsafe.Sizeof(name))) + mapEntryOverhead
+		}
+	}
+	stats.TotalBytes = stats.ENIBytes + stats.PodBytes
+	return stats
+}
+
 // GetENIs provides the number of ENI in the datastore
 func (ds *DataStore) GetENIs() int {
 	ds.lock.Lock()
@@ -37,6 +37,20 @@ func TestAddENI(t *testing.T) {
 
 	eniInfos := ds.GetENIInfos()
 	assert.Equal(t, len(eniInfos.ENIIPPools), 2)
+
+	createTime, err := ds.GetENICreateTime("eni-2")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), createTime, time.Minute)
+	_, err = ds.GetENICreateTime("unknown-eni")
+	assert.Error(t, err)
+
+	assert.NoError(t, ds.SetENIPrimaryIP("eni-2", "1.1.1.1"))
+	assert.Equal(t, "1.1.1.1", ds.GetENIInfos().ENIIPPools["eni-2"].PrimaryIP)
+	assert.Error(t, ds.SetENIPrimaryIP("unknown-eni", "1.1.1.2"))
+
+	assert.NoError(t, ds.SetENITags("eni-2", map[string]string{"team": "net"}))
+	assert.Equal(t, map[string]string{"team": "net"}, ds.GetENIInfos().ENIIPPools["eni-2"].Tags)
+	assert.Error(t, ds.SetENITags("unknown-eni", nil))
 }
 
 func TestDeleteENI(t *testing.T) {
@@ -346,6 +360,230 @@ func TestPodIPv4Address(t *testing.T) {
 	assert.Equal(t, ds.assigned, 2)
 }
 
+func TestAssignPodIPv4AddressPreferred(t *testing.T) {
+	ds := NewDataStore()
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+
+	podInfo := k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1", UID: "uid-1"}
+	ip, _, preferred, err := ds.AssignPodIPv4AddressPreferred(&podInfo, "1.1.1.2")
+	assert.NoError(t, err)
+	assert.True(t, preferred)
+	assert.Equal(t, "1.1.1.2", ip)
+	assert.Equal(t, "uid-1", (*ds.GetPodInfos())["pod-1_ns-1_sandbox-1"].UID)
+
+	// The preferred IP is taken, any free IP is assigned instead
+	podInfo = k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2"}
+	ip, _, preferred, err = ds.AssignPodIPv4AddressPreferred(&podInfo, "1.1.1.2")
+	assert.NoError(t, err)
+	assert.False(t, preferred)
+	assert.Equal(t, "1.1.1.1", ip)
+	assert.Equal(t, 2, ds.assigned)
+
+	// Same pod again
+	_, _, _, err = ds.AssignPodIPv4AddressPreferred(&podInfo, "1.1.1.2")
+	assert.Error(t, err)
+}
+
+func TestReservedIPs(t *testing.T) {
+	ds := NewDataStore()
+	ds.SetReservedIPs(1)
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+
+	_, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"})
+	assert.NoError(t, err)
+
+	// Only the reserved IP is left
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2"})
+	assert.Equal(t, ErrNoAvailableIPs, err)
+	_, _, _, err = ds.AssignPodIPv4AddressPreferred(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2"}, "1.1.1.2")
+	assert.Equal(t, ErrNoAvailableIPs, err)
+	assert.Equal(t, ReservedIPStats{Reserved: 1, ReservedFree: 1, GeneralPodIPs: 1, Rejected: 2}, ds.GetReservedIPStats())
+
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "critical", Namespace: "kube-system", Sandbox: "sandbox-3",
+		UseReservedIPs: true})
+	assert.NoError(t, err)
+	stats := ds.GetReservedIPStats()
+	assert.Equal(t, 0, stats.ReservedFree)
+	assert.Equal(t, 1, stats.CriticalPodIPs)
+	assert.True(t, (*ds.GetPodInfos())["critical_kube-system_sandbox-3"].UseReservedIPs)
+}
+
+func TestHintSubnets(t *testing.T) {
+	ds := NewDataStore()
+	ds.SetHintSubnets([]string{"subnet-b"})
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.SetENISubnet("eni-1", "subnet-a")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddENI("eni-2", 2, false)
+	_ = ds.SetENISubnet("eni-2", "subnet-b")
+	_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.1")
+	_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.2")
+
+	ip, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1.1.1", ip)
+
+	// The IPs of eni-2 are reserved for the pods asking for subnet-b, even as preferred IPs
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2"})
+	assert.Equal(t, ErrNoAvailableIPs, err)
+	_, _, _, err = ds.AssignPodIPv4AddressPreferred(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2"}, "1.1.2.1")
+	assert.Equal(t, ErrNoAvailableIPs, err)
+	// Nor does the pool grow on eni-2 for the other pods
+	assert.Equal(t, "eni-1", ds.GetENINeedsIP(14, false).ID)
+	assert.Nil(t, ds.GetENINeedsIP(14, true))
+
+	_, _, preferred, err := ds.AssignPodIPv4AddressPreferred(&k8sapi.K8SPodInfo{Name: "pod-3", Namespace: "ns-1",
+		Sandbox: "sandbox-3", SubnetHint: "subnet-b"}, "1.1.2.1")
+	assert.NoError(t, err)
+	assert.True(t, preferred)
+	ip, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-4", Namespace: "ns-1", Sandbox: "sandbox-4",
+		SubnetHint: "subnet-b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1.2.2", ip)
+}
+
+func TestIPAssignmentOrder(t *testing.T) {
+	for _, tc := range []struct {
+		order    string
+		expected []string
+	}{
+		{IPOrderLowest, []string{"1.1.1.2", "1.1.1.10", "1.1.2.1"}},
+		{IPOrderHighest, []string{"1.1.2.1", "1.1.1.10", "1.1.1.2"}},
+	} {
+		ds := NewDataStore()
+		ds.SetIPAssignmentOrder(tc.order)
+		_ = ds.AddENI("eni-1", 1, true)
+		_ = ds.AddENI("eni-2", 2, false)
+		_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.10")
+		_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+		_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.1")
+
+		for i, expected := range tc.expected {
+			ip, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-" + expected, Namespace: "ns-1",
+				Sandbox: "sandbox"})
+			assert.NoError(t, err)
+			assert.Equal(t, expected, ip, "%s order, pod %d", tc.order, i)
+		}
+	}
+
+	// Random still assigns every free IP once
+	ds := NewDataStore()
+	ds.SetIPAssignmentOrder(IPOrderRandom)
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	assigned := make(map[string]bool)
+	for _, name := range []string{"pod-1", "pod-2"} {
+		ip, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: name, Namespace: "ns-1", Sandbox: "sandbox"})
+		assert.NoError(t, err)
+		assigned[ip] = true
+	}
+	assert.Equal(t, map[string]bool{"1.1.1.1": true, "1.1.1.2": true}, assigned)
+	_, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-3", Namespace: "ns-1", Sandbox: "sandbox"})
+	assert.Equal(t, ErrNoAvailableIPs, err)
+}
+
+func TestPodInterfaceIPv4Address(t *testing.T) {
+	ds := NewDataStore()
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.3")
+
+	podInfo := k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"}
+	ip, _, err := ds.AssignPodIPv4Address(&podInfo)
+	assert.NoError(t, err)
+	eth1IP, _, err := ds.AssignPodInterfaceIPv4Address(&podInfo, "eth1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, ip, eth1IP)
+	_, _, err = ds.AssignPodInterfaceIPv4Address(&podInfo, "eth1")
+	assert.Error(t, err)
+
+	foundIP, deviceNumber, found := ds.GetPodIPv4Address(&podInfo)
+	assert.True(t, found)
+	assert.Equal(t, ip, foundIP)
+	assert.Equal(t, 1, deviceNumber)
+	assert.Equal(t, []PodInterfaceIP{{IfName: "eth1", IP: eth1IP, DeviceNumber: 1}}, ds.GetPodInterfaces(&podInfo))
+	_, _, found = ds.GetPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-2"})
+	assert.False(t, found)
+
+	// Only the default interface is listed with the assigned pods
+	assert.Equal(t, []k8sapi.K8SPodInfo{{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1", IP: ip}}, ds.GetAssignedPods())
+	// Both interfaces are listed with the pods of their ENI
+	assert.Equal(t, 2, len(ds.GetENIPods("eni-1")))
+	assert.Equal(t, 0, len(ds.GetENIPods("eni-2")))
+	assert.Equal(t, 2, len(ds.GetPodIPsAssignedBefore(time.Now().Add(time.Second))))
+	assert.Equal(t, 0, len(ds.GetPodIPsAssignedBefore(time.Now().Add(-time.Minute))))
+
+	podInfos := *ds.GetPodInfos()
+	assert.Equal(t, 2, len(podInfos))
+	assert.Equal(t, eth1IP, podInfos["pod-1_ns-1_sandbox-1_eth1"].IP)
+	assert.Equal(t, "eth1", podInfos["pod-1_ns-1_sandbox-1_eth1"].IfName)
+	assert.Equal(t, "sandbox-1", podInfos["pod-1_ns-1_sandbox-1_eth1"].Sandbox)
+
+	// The default interface is released on its own, the additional ones all together
+	_, _, err = ds.UnassignPodIPv4Address(&podInfo)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ds.assigned)
+	released := ds.UnassignPodInterfaces(&podInfo)
+	assert.Equal(t, []PodInterfaceIP{{IfName: "eth1", IP: eth1IP, DeviceNumber: 1}}, released)
+	assert.Equal(t, 0, ds.assigned)
+	assert.Equal(t, 0, len(ds.UnassignPodInterfaces(&podInfo)))
+}
+
+func TestGetCoolingIPs(t *testing.T) {
+	ds := NewDataStore()
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	assert.Equal(t, 0, len(ds.GetCoolingIPs()))
+
+	podInfo := k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"}
+	ip, _, err := ds.AssignPodIPv4Address(&podInfo)
+	assert.NoError(t, err)
+	_, _, err = ds.UnassignPodIPv4Address(&podInfo)
+	assert.NoError(t, err)
+
+	coolingIPs := ds.GetCoolingIPs()
+	assert.Equal(t, 1, len(coolingIPs))
+	assert.Equal(t, ip, coolingIPs[0].IP)
+	assert.Equal(t, "eni-1", coolingIPs[0].ENI)
+	assert.True(t, coolingIPs[0].Remaining > 0 && coolingIPs[0].Remaining <= addressCoolingPeriod)
+
+	// Out of the cooling period
+	ds.eniIPPools["eni-1"].IPv4Addresses[ip].UnassignedTime = time.Now().Add(-addressCoolingPeriod - time.Second)
+	assert.Equal(t, 0, len(ds.GetCoolingIPs()))
+}
+
+func TestSetCleanupPending(t *testing.T) {
+	ds := NewDataStore()
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+
+	podInfo := k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"}
+	ip, _, err := ds.AssignPodIPv4Address(&podInfo)
+	assert.NoError(t, err)
+	assert.Error(t, ds.SetCleanupPending(ip, true))
+	_, _, err = ds.UnassignPodIPv4Address(&podInfo)
+	assert.NoError(t, err)
+	assert.NoError(t, ds.SetCleanupPending(ip, true))
+	assert.Error(t, ds.SetCleanupPending("1.1.1.9", true))
+
+	// Not assigned again, nor deleted, after its cooling period
+	ds.eniIPPools["eni-1"].IPv4Addresses[ip].UnassignedTime = time.Now().Add(-addressCoolingPeriod - time.Second)
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1"})
+	assert.Equal(t, ErrNoAvailableIPs, err)
+	assert.Error(t, ds.DelIPv4AddressFromStore("eni-1", ip, false))
+
+	assert.NoError(t, ds.SetCleanupPending(ip, false))
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1"})
+	assert.NoError(t, err)
+}
+
 func TestWarmENIInteractions(t *testing.T) {
 	ds := NewDataStore()
 
@@ -401,3 +639,95 @@ func TestWarmENIInteractions(t *testing.T) {
 
 	assert.NotEqual(t, removedEni, secondRemovedEni, "The two removed ENIs should not be the same ENI.")
 }
+
+func TestGetLockStats(t *testing.T) {
+	ds := NewDataStore()
+	assert.Equal(t, LockStats{}, ds.GetLockStats())
+
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	stats := ds.GetLockStats()
+	assert.Equal(t, int64(2), stats.Acquisitions)
+	assert.Equal(t, int64(0), stats.Contended)
+	assert.Equal(t, 2, stats.Samples)
+
+	// An acquisition waiting for another holder is contended, and waits about as long as the lock is held
+	ds.lock.Lock()
+	done := make(chan struct{})
+	go func() {
+		ds.GetENIs()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	ds.lock.Unlock()
+	<-done
+	stats = ds.GetLockStats()
+	assert.Equal(t, int64(4), stats.Acquisitions)
+	assert.Equal(t, int64(1), stats.Contended)
+	assert.Equal(t, 4, stats.Samples)
+	assert.True(t, stats.WaitMax >= 10*time.Millisecond)
+	assert.True(t, stats.HoldMax >= 10*time.Millisecond)
+	assert.True(t, stats.WaitP50 <= stats.WaitP99)
+
+	// Only the last lockSamples acquisitions are kept
+	for i := 0; i < lockSamples; i++ {
+		ds.GetENIs()
+	}
+	assert.Equal(t, lockSamples, ds.GetLockStats().Samples)
+}
+
+func TestGetSizeStats(t *testing.T) {
+	ds := NewDataStore()
+	assert.Equal(t, SizeStats{}, ds.GetSizeStats())
+
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.2")
+	stats := ds.GetSizeStats()
+	assert.Equal(t, 1, stats.ENIs)
+	assert.Equal(t, 2, stats.IPs)
+	assert.Equal(t, 0, stats.PodIPs)
+	assert.True(t, stats.ENIBytes > 0)
+	assert.Equal(t, int64(0), stats.PodBytes)
+
+	// Each pod adds at least its names and its entry
+	_, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"})
+	assert.NoError(t, err)
+	withPod := ds.GetSizeStats()
+	assert.Equal(t, 1, withPod.PodIPs)
+	assert.True(t, withPod.PodBytes >= int64(len("pod-1ns-1sandbox-1")+mapEntryOverhead))
+	assert.Equal(t, withPod.ENIBytes+withPod.PodBytes, withPod.TotalBytes)
+}
+
+func TestGetDetachBlockedENIs(t *testing.T) {
+	ds := NewDataStore()
+	_ = ds.AddENI("eni-1", 1, true)
+	_ = ds.AddIPv4AddressToStore("eni-1", "1.1.1.1")
+	_, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"})
+	assert.NoError(t, err)
+	_ = ds.AddENI("eni-2", 2, false)
+	_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.1")
+	ip, _, err := ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2"})
+	assert.NoError(t, err)
+	_ = ds.AddIPv4AddressToStore("eni-2", "1.1.2.2")
+	_ = ds.AddENI("eni-3", 3, false)
+	_ = ds.AddIPv4AddressToStore("eni-3", "1.1.3.1")
+
+	// The ENIs are too young to be detached
+	assert.Equal(t, 0, len(ds.GetDetachBlockedENIs(0, 0)))
+
+	// Only eni-2 is kept for its pod, eni-3 has none and the primary ENI is never detached
+	ds.eniIPPools["eni-2"].createTime = time.Time{}
+	ds.eniIPPools["eni-3"].createTime = time.Time{}
+	blocked := ds.GetDetachBlockedENIs(1, 0)
+	assert.Equal(t, 1, len(blocked))
+	assert.Equal(t, "eni-2", blocked[0].ENIID)
+	assert.Equal(t, 2, blocked[0].DeviceNumber)
+	assert.Equal(t, 1, blocked[0].AssignedIPs)
+	assert.Equal(t, 1, blocked[0].FreeIPs)
+	assert.Equal(t, []DetachBlockingPod{{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2", IP: ip}}, blocked[0].Pods)
+
+	// An ENI needed for the warm or minimum IP targets is not a candidate for detachment
+	assert.Equal(t, 0, len(ds.GetDetachBlockedENIs(2, 0)))
+	assert.Equal(t, 0, len(ds.GetDetachBlockedENIs(0, 4)))
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+// maxDelFailures is the number of pods whose most recent DelNetwork failure is kept
+const maxDelFailures = 100
+
+// DelFailure is a DelNetwork request that failed and was not retried successfully yet, for introspection
+type DelFailure struct {
+	Name      string
+	Namespace string
+	Sandbox   string
+	// IP is the IP the CNI plugin asked to release, if it knew it
+	IP     string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+	Error  string
+	// Failures is the number of failed requests for the pod, the first at FirstFailure and the last at Time
+	Failures     int
+	FirstFailure time.Time
+	Time         time.Time
+}
+
+// DelFailureStats contains the recent DelNetwork failures, for introspection
+type DelFailureStats struct {
+	// Failures are the pods whose DelNetwork failed, oldest first
+	Failures []DelFailure
+	// Cleared is the number of failures cleared by a successful retry, and Dropped the number of failures dropped to
+	// keep the most recent ones
+	Cleared int64
+	Dropped int64
+}
+
+// delFailureState keeps the recent DelNetwork failures, keyed by pod
+type delFailureState struct {
+	failures []DelFailure
+	cleared  int64
+	dropped  int64
+	lock     sync.RWMutex
+}
+
+// indexUnsafe returns the position of the failure of the pod, or -1
+func (s *delFailureState) indexUnsafe(pod *k8sapi.K8SPodInfo) int {
+	for i, failure := range s.failures {
+		if failure.Name == pod.Name && failure.Namespace == pod.Namespace && failure.Sandbox == pod.Sandbox {
+			return i
+		}
+	}
+	return -1
+}
+
+// record keeps the failure of a DelNetwork request for the pod as its most recent one
+func (s *delFailureState) record(pod *k8sapi.K8SPodInfo, ip string, reason string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	failure := DelFailure{Name: pod.Name, Namespace: pod.Namespace, Sandbox: pod.Sandbox, IP: ip, Reason: reason,
+		Error: err.Error(), Failures: 1, FirstFailure: now, Time: now}
+	if i := s.indexUnsafe(pod); i >= 0 {
+		failure.Failures += s.failures[i].Failures
+		failure.FirstFailure = s.failures[i].FirstFailure
+		s.failures = append(s.failures[:i], s.failures[i+1:]...)
+	}
+	if len(s.failures) >= maxDelFailures {
+		s.failures = s.failures[1:]
+		s.dropped++
+	}
+	s.failures = append(s.failures, failure)
+}
+
+// clear forgets the failure of the pod, if any, after a successful DelNetwork request
+func (s *delFailureState) clear(pod *k8sapi.K8SPodInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if i := s.indexUnsafe(pod); i >= 0 {
+		s.failures = append(s.failures[:i], s.failures[i+1:]...)
+		s.cleared++
+	}
+}
+
+// GetDelFailureStats returns the recent DelNetwork failures
+func (c *IPAMContext) GetDelFailureStats() DelFailureStats {
+	c.delFailures.lock.RLock()
+	defer c.delFailures.lock.RUnlock()
+	return DelFailureStats{
+		Failures: append([]DelFailure{}, c.delFailures.failures...),
+		Cleared:  c.delFailures.cleared,
+		Dropped:  c.delFailures.dropped,
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to enable the verification that the host side networking of a deleted pod is
+	// removed before its IP is assigned again. The CNI plugin removes the host route to the pod after its DelNetwork
+	// request returns, so the IP is held until the main route table no longer routes it to a host side veth. It
+	// defaults to false.
+	envVerifyDelCleanup = "VERIFY_DEL_CLEANUP"
+
+	// delVerifyAttempts is how many times the host route of a deleted pod is checked, every delVerifyInterval, before
+	// the verification is reported as failed. The IP stays held and is checked again by the pool manager.
+	delVerifyAttempts = 10
+	delVerifyInterval = time.Second
+)
+
+// DelVerifyFailure is an IP held because the host route of its deleted pod is still on the host
+type DelVerifyFailure struct {
+	Name      string
+	Namespace string
+	Sandbox   string
+	IP        string
+	// Since is when the pod was deleted, and Checks the number of times the host route was found
+	Since     time.Time
+	Checks    int
+	LastError string `json:",omitempty"`
+}
+
+// DelVerifyStats describes the verification of the host side networking cleanup of deleted pods, for introspection
+type DelVerifyStats struct {
+	Enabled bool
+	// Verifying is the number of IPs whose host route is being checked, and Verified and Failed the number of
+	// verifications that found the route removed and that gave up
+	Verifying int
+	Verified  int64
+	Failed    int64
+	Held      []DelVerifyFailure
+}
+
+// delVerifyState keeps the IPs whose host route was still found after delVerifyAttempts checks
+type delVerifyState struct {
+	held      map[string]DelVerifyFailure
+	verifying int
+	verified  int64
+	failed    int64
+	lock      sync.RWMutex
+}
+
+// holdForDelVerify keeps an IP unassigned by a DelNetwork request from being assigned again until the host route of
+// the pod is removed
+func (c *IPAMContext) holdForDelVerify(pod *k8sapi.K8SPodInfo, ip string) {
+	if err := c.dataStore.SetCleanupPending(ip, true); err != nil {
+		log.Warnf("Failed to hold IP %s of Pod %s, Namespace %s until its host route is removed: %v",
+			ip, pod.Name, pod.Namespace, err)
+		return
+	}
+	c.delVerify.lock.Lock()
+	c.delVerify.verifying++
+	c.delVerify.lock.Unlock()
+	go c.verifyDelCleanup(DelVerifyFailure{Name: pod.Name, Namespace: pod.Namespace, Sandbox: pod.Sandbox, IP: ip,
+		Since: time.Now()})
+}
+
+// verifyDelCleanup checks the host route of a deleted pod up to delVerifyAttempts times, and releases its IP once the
+// route is removed
+func (c *IPAMContext) verifyDelCleanup(pending DelVerifyFailure) {
+	for attempt := 1; ; attempt++ {
+		if c.checkDelCleanup(&pending) {
+			c.delVerify.lock.Lock()
+			defer c.delVerify.lock.Unlock()
+			c.delVerify.verifying--
+			c.delVerify.verified++
+			return
+		}
+		if attempt == delVerifyAttempts {
+			break
+		}
+		time.Sleep(c.delVerifyInterval)
+	}
+	log.Warnf("The host route of IP %s of deleted Pod %s, Namespace %s, Sandbox %s is still present after %d checks, "+
+		"holding the IP until it is removed", pending.IP, pending.Name, pending.Namespace, pending.Sandbox, pending.Checks)
+	ipamdErrInc("delVerifyFailed")
+	c.delVerify.lock.Lock()
+	defer c.delVerify.lock.Unlock()
+	c.delVerify.verifying--
+	c.delVerify.failed++
+	if c.delVerify.held == nil {
+		c.delVerify.held = make(map[string]DelVerifyFailure)
+	}
+	c.delVerify.held[pending.IP] = pending
+}
+
+// checkDelCleanup returns true, and releases the IP, if its host route is removed
+func (c *IPAMContext) checkDelCleanup(pending *DelVerifyFailure) bool {
+	found, err := c.networkClient.HasPodHostRoute(pending.IP)
+	if err != nil {
+		pending.LastError = err.Error()
+		return false
+	}
+	if found {
+		pending.Checks++
+		return false
+	}
+	if err := c.dataStore.SetCleanupPending(pending.IP, false); err != nil {
+		// The IP was removed from the datastore meanwhile
+		log.Debugf("IP %s of deleted Pod %s, Namespace %s is no longer held: %v", pending.IP, pending.Name,
+			pending.Namespace, err)
+	}
+	log.Debugf("The host route of IP %s of deleted Pod %s, Namespace %s is removed", pending.IP, pending.Name,
+		pending.Namespace)
+	return true
+}
+
+// recheckDelVerifyFailures checks again the host route of the IPs held after their verification failed
+func (c *IPAMContext) recheckDelVerifyFailures() {
+	c.delVerify.lock.Lock()
+	defer c.delVerify.lock.Unlock()
+	for ip, pending := range c.delVerify.held {
+		if c.checkDelCleanup(&pending) {
+			log.Infof("Releasing IP %s of deleted Pod %s, Namespace %s, its host route is removed", ip, pending.Name,
+				pending.Namespace)
+			delete(c.delVerify.held, ip)
+			continue
+		}
+		c.delVerify.held[ip] = pending
+	}
+}
+
+// GetDelVerifyStats returns the verifications of the host side networking cleanup of deleted pods, oldest held IP first
+func (c *IPAMContext) GetDelVerifyStats() DelVerifyStats {
+	c.delVerify.lock.RLock()
+	defer c.delVerify.lock.RUnlock()
+	stats := DelVerifyStats{
+		Enabled:   c.delVerifyEnabled,
+		Verifying: c.delVerify.verifying,
+		Verified:  c.delVerify.verified,
+		Failed:    c.delVerify.failed,
+		Held:      make([]DelVerifyFailure, 0, len(c.delVerify.held)),
+	}
+	for _, held := range c.delVerify.held {
+		stats.Held = append(stats.Held, held)
+	}
+	sort.Slice(stats.Held, func(i, j int) bool {
+		return stats.Held[i].Since.Before(stats.Held[j].Since)
+	})
+	return stats
+}
+
+func verifyDelCleanupEnabled() bool {
+	return getEnvBoolWithDefault(envVerifyDelCleanup, false)
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+// DetachBlockedStats lists the ENIs the pool would detach if pods did not hold some of their IPs, for introspection
+type DetachBlockedStats struct {
+	// RemoveExtraENIs is true when the pool has more free IPs than its targets need and tries to free an ENI
+	RemoveExtraENIs bool
+	// RequiredForENIHeadroom is true when no ENI is freed because of MIN_FREE_IPS_PER_ENI
+	RequiredForENIHeadroom bool
+	WarmIPTarget           int
+	MinimumIPTarget        int
+	ENIs                   []datastore.DetachBlockedENI
+}
+
+// GetDetachBlocked returns the ENIs that are only kept because pods still hold some of their IPs, with those pods
+func (c *IPAMContext) GetDetachBlocked() DetachBlockedStats {
+	warmIPTarget := c.effectiveWarmIPTarget()
+	minimumIPTarget := c.effectiveMinimumIPTarget()
+	return DetachBlockedStats{
+		RemoveExtraENIs:        c.shouldRemoveExtraENIs(),
+		RequiredForENIHeadroom: c.requiredForENIHeadroom(),
+		WarmIPTarget:           warmIPTarget,
+		MinimumIPTarget:        minimumIPTarget,
+		ENIs:                   c.dataStore.GetDetachBlockedENIs(warmIPTarget, minimumIPTarget),
+	}
+}
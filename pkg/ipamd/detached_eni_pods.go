@@ -0,0 +1,128 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do about the pods that still have an IP of an ENI the
+	// reconcile finds detached out-of-band, for example by a user or another controller. Their IPs are removed from the
+	// datastore with the ENI, and the pods lose their connectivity:
+	//   "log" (default): log the pods, and list them on /v1/detached-eni-pods
+	//   "event": also create a Warning event on each pod
+	envDetachedENIPodPolicy = "DETACHED_ENI_POD_POLICY"
+	detachedENIPodLog       = "log"
+	detachedENIPodEvent     = "event"
+
+	// detachedENIPodEventReason is the reason of the Warning event created on the pods
+	detachedENIPodEventReason = "ENIDetached"
+
+	// maxDetachedENIPods is how many of the recent pods of detached ENIs are kept
+	maxDetachedENIPods = 32
+)
+
+// DetachedENIPod is a pod that had an IP of an ENI detached out-of-band, for introspection
+type DetachedENIPod struct {
+	Pod     string
+	Sandbox string
+	IP      string
+	ENIID   string
+	Time    time.Time
+	// Event is true if a Warning event was created on the pod, EventError why it could not be
+	Event      bool
+	EventError string `json:",omitempty"`
+}
+
+// DetachedENIPodStats counts the ENIs detached out-of-band while pods used them, for introspection
+type DetachedENIPodStats struct {
+	Policy string
+	// ENIs is the number of detached ENIs that had pods, Pods the total of their pods
+	ENIs int64
+	Pods int64
+	// Recent are the most recent pods, oldest first
+	Recent []DetachedENIPod
+}
+
+// detachedENIPodState keeps the recent pods of detached ENIs
+type detachedENIPodState struct {
+	enis   int64
+	pods   int64
+	recent []DetachedENIPod
+	lock   sync.RWMutex
+}
+
+func (s *detachedENIPodState) record(pods []DetachedENIPod) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.enis++
+	s.pods += int64(len(pods))
+	s.recent = append(s.recent, pods...)
+	s.recent = s.recent[keepLast(len(s.recent), maxDetachedENIPods):]
+}
+
+// reportDetachedENIPods reports the pods that had an IP of the ENI the reconcile removed from the datastore for being
+// detached, and, when DETACHED_ENI_POD_POLICY is "event", creates a Warning event on each of them
+func (c *IPAMContext) reportDetachedENIPods(eniID string, pods []k8sapi.K8SPodInfo) {
+	if len(pods) == 0 {
+		return
+	}
+	now := time.Now()
+	detached := make([]DetachedENIPod, 0, len(pods))
+	for _, pod := range pods {
+		log.Warnf("Pod %s, Namespace %s, Sandbox %s lost IP %s, its ENI %s was detached", pod.Name, pod.Namespace,
+			pod.Sandbox, pod.IP, eniID)
+		record := DetachedENIPod{Pod: pod.Namespace + "/" + pod.Name, Sandbox: pod.Sandbox, IP: pod.IP, ENIID: eniID,
+			Time: now}
+		if c.detachedENIPodPolicy == detachedENIPodEvent {
+			message := fmt.Sprintf("ENI %s of IP %s was detached from the node, the pod has no connectivity on it",
+				eniID, pod.IP)
+			if err := c.k8sClient.K8SRecordPodEvent(pod.Name, pod.Namespace, detachedENIPodEventReason,
+				message); err != nil {
+				log.Errorf("Failed to record the detached ENI %s on pod %s/%s: %v", eniID, pod.Namespace, pod.Name, err)
+				record.EventError = err.Error()
+			} else {
+				record.Event = true
+			}
+		}
+		detached = append(detached, record)
+	}
+	ipamdErrInc("detachedENIPods")
+	c.eniTimelines.record(eniID, eniEventError, "detached while %d pods used its IPs", len(pods))
+	c.detachedENIPods.record(detached)
+}
+
+// GetDetachedENIPodStats returns the counts of the ENIs detached while pods used them and the recent pods
+func (c *IPAMContext) GetDetachedENIPodStats() DetachedENIPodStats {
+	c.detachedENIPods.lock.RLock()
+	defer c.detachedENIPods.lock.RUnlock()
+	return DetachedENIPodStats{
+		Policy: c.detachedENIPodPolicy,
+		ENIs:   c.detachedENIPods.enis,
+		Pods:   c.detachedENIPods.pods,
+		Recent: append([]DetachedENIPod{}, c.detachedENIPods.recent...),
+	}
+}
+
+func getDetachedENIPodPolicy() string {
+	return envsetting.Choice(envDetachedENIPodPolicy, detachedENIPodLog, detachedENIPodLog, detachedENIPodEvent)
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"time"
+)
+
+// DiagnosisCheck is the result of one of the checks of the node diagnosis
+type DiagnosisCheck struct {
+	Name   string
+	Passed bool
+	// Message is why the check failed, or a summary of its result
+	Message string `json:",omitempty"`
+	// Details is what the introspection endpoint of the check returns
+	Details interface{} `json:",omitempty"`
+}
+
+// Diagnosis is the result of all the checks of the networking of the node, for introspection
+type Diagnosis struct {
+	Time     time.Time
+	Duration time.Duration
+	// Passed is true when all the checks passed, Failed are the names of those that did not
+	Passed bool
+	Failed []string
+	Checks []DiagnosisCheck
+}
+
+// Diagnose runs the checks of the networking of the node and returns their results. The security group check
+// describes the ENIs with EC2, the others use the results of the last reconcile.
+func (c *IPAMContext) Diagnose() Diagnosis {
+	start := time.Now()
+	diagnosis := Diagnosis{Time: start, Passed: true, Failed: []string{}}
+	for _, check := range []func() DiagnosisCheck{
+		c.diagnoseENIConsistency,
+		c.diagnoseConnectivity,
+		c.diagnoseSecurityGroups,
+		c.diagnoseIptablesBackend,
+		c.diagnosePrimaryENI,
+		c.diagnosePool,
+	} {
+		result := check()
+		if !result.Passed {
+			diagnosis.Passed = false
+			diagnosis.Failed = append(diagnosis.Failed, result.Name)
+		}
+		diagnosis.Checks = append(diagnosis.Checks, result)
+	}
+	diagnosis.Duration = time.Since(start)
+	return diagnosis
+}
+
+// diagnoseENIConsistency fails while EC2 does not list some ENIs of the datastore yet
+func (c *IPAMContext) diagnoseENIConsistency() DiagnosisCheck {
+	stats := c.GetENIConsistencyStats()
+	check := DiagnosisCheck{Name: "eni-consistency", Passed: len(stats.Pending) == 0, Details: stats}
+	if !check.Passed {
+		check.Message = fmt.Sprintf("%d ENIs of the datastore are not listed by EC2 yet", len(stats.Pending))
+	}
+	return check
+}
+
+// diagnoseConnectivity fails while the instance metadata service cannot be reached
+func (c *IPAMContext) diagnoseConnectivity() DiagnosisCheck {
+	connectivity := c.GetConnectivity()
+	check := DiagnosisCheck{Name: "connectivity", Passed: connectivity.IMDS.Available, Details: connectivity}
+	if !check.Passed {
+		check.Message = fmt.Sprintf("the instance metadata service cannot be reached since %s: %s",
+			connectivity.IMDS.UnavailableSince.Format(time.RFC3339), connectivity.IMDS.LastError)
+	}
+	return check
+}
+
+// diagnoseSecurityGroups fails when some ENIs do not have the expected security groups, or they are not known yet
+func (c *IPAMContext) diagnoseSecurityGroups() DiagnosisCheck {
+	drift, err := c.GetSecurityGroupDrift()
+	if err != nil {
+		return DiagnosisCheck{Name: "sg-drift", Message: err.Error()}
+	}
+	check := DiagnosisCheck{Name: "sg-drift", Passed: drift.Drifted == 0, Details: drift}
+	if !check.Passed {
+		check.Message = fmt.Sprintf("%d ENIs do not have the security groups %v", drift.Drifted, drift.Expected)
+	}
+	return check
+}
+
+// diagnoseIptablesBackend fails when another component of the node programs the other iptables backend
+func (c *IPAMContext) diagnoseIptablesBackend() DiagnosisCheck {
+	backend := c.networkClient.GetIptablesBackend()
+	check := DiagnosisCheck{Name: "iptables-backend", Passed: !backend.Mismatch && backend.Error == "",
+		Details: backend}
+	switch {
+	case backend.Error != "":
+		check.Message = backend.Error
+	case backend.Mismatch:
+		check.Message = fmt.Sprintf("iptables uses the %s backend, but the other one has %d rules", backend.Mode,
+			backend.OtherModeRules)
+	}
+	return check
+}
+
+// diagnosePrimaryENI fails when the last check found the primary IP missing from EC2 or from the host
+func (c *IPAMContext) diagnosePrimaryENI() DiagnosisCheck {
+	status := c.GetPrimaryENIStatus()
+	check := DiagnosisCheck{Name: "primary-eni", Passed: status.Healthy || status.LastCheck.IsZero(), Details: status}
+	switch {
+	case status.LastCheck.IsZero():
+		check.Message = "not checked yet"
+	case !status.Healthy:
+		check.Message = status.LastError
+	}
+	return check
+}
+
+// diagnosePool fails when the pool has no free IP for new pods
+func (c *IPAMContext) diagnosePool() DiagnosisCheck {
+	stats := c.GetPoolStats()
+	return DiagnosisCheck{Name: "pool", Passed: stats.AssignedIPs < stats.TotalIPs, Details: stats,
+		Message: fmt.Sprintf("%d of the %d IPs are assigned", stats.AssignedIPs, stats.TotalIPs)}
+}
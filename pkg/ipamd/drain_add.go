@@ -0,0 +1,95 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do with the AddNetwork requests of new pods while the node
+	// is draining, that is while it is marked for scale-down and SCALE_DOWN_SHRINK is set:
+	//   "allow" (default): assign them a free IP, if any is left
+	//   "reject": fail them, so that the IPs being released are not taken again
+	envDrainAddPolicy = "DRAIN_ADD_POLICY"
+	drainAddAllow     = "allow"
+	drainAddReject    = "reject"
+)
+
+// errDrainAddRejected is returned to the pods added while the node is draining when DRAIN_ADD_POLICY is "reject"
+var errDrainAddRejected = errors.New("the node is draining, no IP address is assigned to new pods")
+
+// DrainAddStats counts the AddNetwork requests received while the node is draining, for introspection
+type DrainAddStats struct {
+	Policy   string
+	Draining bool
+	// Attempts is the number of requests received while the node was draining, Rejected those that failed because
+	// of DRAIN_ADD_POLICY
+	Attempts int64
+	Rejected int64
+	// LastPod is the last pod added while the node was draining, at LastAttempt
+	LastPod     string    `json:",omitempty"`
+	LastAttempt time.Time `json:",omitempty"`
+}
+
+// drainAddState keeps the counts of the AddNetwork requests received while the node is draining
+type drainAddState struct {
+	stats DrainAddStats
+	lock  sync.RWMutex
+}
+
+// checkDrainAdd counts the request of a pod added while the node is draining, and returns errDrainAddRejected when
+// DRAIN_ADD_POLICY is "reject"
+func (c *IPAMContext) checkDrainAdd(pod *k8sapi.K8SPodInfo) error {
+	if !c.inShrinkMode() {
+		return nil
+	}
+	reject := c.drainAddPolicy == drainAddReject
+	c.drainAdds.lock.Lock()
+	c.drainAdds.stats.Attempts++
+	if reject {
+		c.drainAdds.stats.Rejected++
+	}
+	c.drainAdds.stats.LastPod = pod.Namespace + "/" + pod.Name
+	c.drainAdds.stats.LastAttempt = time.Now()
+	c.drainAdds.lock.Unlock()
+
+	if !reject {
+		log.Infof("Pod %s, Namespace %s is added while the node is draining", pod.Name, pod.Namespace)
+		return nil
+	}
+	log.Warnf("Rejecting Pod %s, Namespace %s, the node is draining and %s is %q",
+		pod.Name, pod.Namespace, envDrainAddPolicy, drainAddReject)
+	return errDrainAddRejected
+}
+
+func (c *IPAMContext) getDrainAddStats() DrainAddStats {
+	c.drainAdds.lock.RLock()
+	defer c.drainAdds.lock.RUnlock()
+	stats := c.drainAdds.stats
+	stats.Policy = c.drainAddPolicy
+	stats.Draining = c.inShrinkMode()
+	return stats
+}
+
+func getDrainAddPolicy() string {
+	return envsetting.Choice(envDrainAddPolicy, drainAddAllow, drainAddAllow, drainAddReject)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+	"github.com/aws/amazon-vpc-cni-k8s/rpc"
+)
+
+const (
+	// This environment variable is used to specify how an AddNetwork request is handled when the pod, with the same
+	// sandbox, already has an IP address, for example when kubelet retries an ADD:
+	//   "reuse" (default): return the IP address the pod already has
+	//   "reject": fail the request
+	envDuplicateAddPolicy = "DUPLICATE_ADD_POLICY"
+
+	duplicateAddReuse  = "reuse"
+	duplicateAddReject = "reject"
+)
+
+// DuplicateAddStats counts the AddNetwork requests for pods that already had an IP address, for introspection
+type DuplicateAddStats struct {
+	Policy   string
+	Reused   int64
+	Rejected int64
+	// LastPod is the namespace/name of the pod of the last duplicate request, at LastTime
+	LastPod  string `json:",omitempty"`
+	LastTime time.Time
+}
+
+// duplicateAddState keeps the duplicate AddNetwork counts
+type duplicateAddState struct {
+	stats DuplicateAddStats
+	lock  sync.RWMutex
+}
+
+// duplicatePodIPv4Address returns the IP address the pod already has if it has one. A duplicate request is rejected
+// when DUPLICATE_ADD_POLICY is "reject".
+func (c *IPAMContext) duplicatePodIPv4Address(pod *k8sapi.K8SPodInfo) (addr string, deviceNumber int, duplicate bool, err error) {
+	addr, deviceNumber, duplicate = c.dataStore.GetPodIPv4Address(pod)
+	if !duplicate {
+		return "", 0, false, nil
+	}
+
+	c.duplicateAdd.lock.Lock()
+	defer c.duplicateAdd.lock.Unlock()
+	c.duplicateAdd.stats.LastPod = pod.Namespace + "/" + pod.Name
+	c.duplicateAdd.stats.LastTime = time.Now()
+	if c.duplicateAddPolicy == duplicateAddReject {
+		c.duplicateAdd.stats.Rejected++
+		c.podTraces.step(pod, "pod already has IP %s, duplicate request rejected", addr)
+		log.Errorf("Rejecting duplicate AddNetwork for Pod %s, Namespace %s, Sandbox %s, which already has IP %s",
+			pod.Name, pod.Namespace, pod.Sandbox, addr)
+		return "", 0, true, errors.Errorf("pod already has IP address %s", addr)
+	}
+	c.duplicateAdd.stats.Reused++
+	c.podTraces.step(pod, "pod already has IP %s, reused", addr)
+	c.podTraces.setSource(pod, podIPSourceDuplicate)
+	log.Infof("Duplicate AddNetwork for Pod %s, Namespace %s, Sandbox %s, returning its IP %s",
+		pod.Name, pod.Namespace, pod.Sandbox, addr)
+	return addr, deviceNumber, true, nil
+}
+
+// duplicatePodInterfaces returns the IP addresses the additional interfaces of a pod already have. It fails if the
+// pod does not have an IP address for one of them.
+func (c *IPAMContext) duplicatePodInterfaces(pod *k8sapi.K8SPodInfo, ifNames []string) ([]*rpc.PodInterface, error) {
+	existing := make(map[string]datastore.PodInterfaceIP)
+	for _, intf := range c.dataStore.GetPodInterfaces(pod) {
+		existing[intf.IfName] = intf
+	}
+	var interfaces []*rpc.PodInterface
+	for _, ifName := range ifNames {
+		intf, ok := existing[ifName]
+		if !ok {
+			return nil, errors.Errorf("pod already has an IP address but none for interface %s", ifName)
+		}
+		interfaces = append(interfaces, &rpc.PodInterface{IfName: ifName, IPv4Addr: intf.IP, DeviceNumber: int32(intf.DeviceNumber)})
+	}
+	return interfaces, nil
+}
+
+// GetDuplicateAddStats returns the duplicate AddNetwork counts
+func (c *IPAMContext) GetDuplicateAddStats() DuplicateAddStats {
+	c.duplicateAdd.lock.RLock()
+	defer c.duplicateAdd.lock.RUnlock()
+	stats := c.duplicateAdd.stats
+	stats.Policy = c.duplicateAddPolicy
+	return stats
+}
+
+func getDuplicateAddPolicy() string {
+	return envsetting.Choice(envDuplicateAddPolicy, duplicateAddReuse, duplicateAddReuse, duplicateAddReject)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+)
+
+const (
+	// The modes of the warm pool
+	warmPolicyShrinkOnly = "shrink-only"
+	warmPolicyWarmIP     = "warm-ip"
+	warmPolicyWarmENI    = "warm-eni"
+)
+
+// EffectivePolicyReason is a setting that shapes the warm pool, and how, for introspection
+type EffectivePolicyReason struct {
+	Setting string
+	Effect  string
+}
+
+// EffectivePolicy is the warm pool behavior of the node once all the settings are combined, for introspection
+type EffectivePolicy struct {
+	// Mode is "warm-ip" when the pool is sized by the warm and minimum IP targets, "warm-eni" when it keeps
+	// WarmENITarget ENIs worth of free IPs, and "shrink-only" when the node is marked for scale-down
+	Mode string
+	// WarmIPTarget, MinimumIPTarget and WarmENITarget are the targets in effect, 0 when they do not apply
+	WarmIPTarget    int
+	MinimumIPTarget int
+	WarmENITarget   int
+	// Reasons are the settings that shaped the policy, in their order of precedence
+	Reasons []EffectivePolicyReason
+}
+
+// GetEffectivePolicy returns the warm pool behavior that governs the node, and the settings it comes from. The
+// computation follows effectiveWarmIPTarget and ipTargetState.
+func (c *IPAMContext) GetEffectivePolicy() EffectivePolicy {
+	var policy EffectivePolicy
+	reason := func(setting string, format string, args ...interface{}) {
+		policy.Reasons = append(policy.Reasons, EffectivePolicyReason{Setting: setting,
+			Effect: fmt.Sprintf(format, args...)})
+	}
+
+	for _, clamp := range c.warmTargetClamps {
+		reason(clamp.Name, "lowered from %d to %d, the capacity of the instance", clamp.Requested, clamp.Clamped)
+	}
+
+	if c.inShrinkMode() {
+		policy.Mode = warmPolicyShrinkOnly
+		reason(envScaleDownShrink, "the node is marked for scale-down (%s), no IP is kept warm and the pool only "+
+			"shrinks, whatever the warm targets", c.getShrinkModeStats().Reason)
+	} else {
+		warmIPTarget := c.warmIPTarget
+		if c.adaptiveWarmIPTargetEnabled {
+			warmIPTarget = c.adaptiveWarmIPTarget()
+			reason(envAdaptiveWarmIPTarget, "the warm IP target is %d, computed from the pod churn instead of %s",
+				warmIPTarget, envWarmIPTarget)
+		}
+		if c.idlePoolShrinking() && (warmIPTarget == noWarmIPTarget || warmIPTarget > idleShrinkWarmIPTarget) {
+			warmIPTarget = idleShrinkWarmIPTarget
+			reason(envIdlePoolShrinkThreshold, "the pool is idle, the warm IP target is lowered to %d",
+				idleShrinkWarmIPTarget)
+		} else if c.isSubnetPressured() &&
+			(warmIPTarget == noWarmIPTarget || warmIPTarget > subnetPressureWarmIPTarget) {
+			warmIPTarget = subnetPressureWarmIPTarget
+			reason(envSubnetPressureThreshold, "the subnet is under IP pressure, the warm IP target is lowered to %d",
+				subnetPressureWarmIPTarget)
+		}
+		policy.WarmIPTarget = warmIPTarget
+		policy.MinimumIPTarget = c.minimumIPTarget
+
+		if warmIPTarget == noWarmIPTarget && c.minimumIPTarget == noMinimumIPTarget {
+			policy.Mode = warmPolicyWarmENI
+			policy.WarmENITarget = c.warmENITarget
+			reason(envWarmENITarget, "neither %s nor %s is set, the pool keeps %d ENIs worth of free IPs, %d IPs",
+				envWarmIPTarget, envMinimumIPTarget, c.warmENITarget, c.warmENITarget*c.maxIPsPerENI)
+		} else {
+			policy.Mode = warmPolicyWarmIP
+			if warmIPTarget != noWarmIPTarget {
+				reason(envWarmIPTarget, "the pool keeps %d free IPs", warmIPTarget)
+			}
+			if c.minimumIPTarget != noMinimumIPTarget {
+				reason(envMinimumIPTarget, "the pool holds at least %d IPs, assigned or free", c.minimumIPTarget)
+			}
+			reason(envWarmENITarget, "ignored, %s or %s is set", envWarmIPTarget, envMinimumIPTarget)
+		}
+	}
+
+	if c.minFreeIPsPerENI != noMinFreeIPsPerENI && policy.Mode != warmPolicyShrinkOnly {
+		reason(envMinFreeIPsPerENI, "an ENI is added when no ENI has room for %d more pods, and kept while needed "+
+			"for it", c.minFreeIPsPerENI)
+	}
+	if c.reservedIPs != noReservedIPs {
+		reason(envReservedIPs, "%d free IPs are kept on top of the warm IPs for the pods allowed to use them",
+			c.reservedIPs)
+	}
+	if c.nodeIPQuota != noNodeIPQuota {
+		reason(envNodeIPQuota, "the pool never holds more than %d IPs, whatever the targets", c.nodeIPQuota)
+	}
+	return policy
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// efficiencyWindow is how far back the recent calls to allocate IP addresses are counted
+	efficiencyWindow = time.Hour
+	// maxEfficiencyCalls is the number of the last calls to allocate IP addresses that are kept
+	maxEfficiencyCalls = 512
+)
+
+// AllocEfficiency counts the EC2 calls that allocated IP addresses on ENIs and what they got
+type AllocEfficiency struct {
+	// Calls is the number of calls, FailedCalls those that returned an error, and ENIs the number of ENIs created
+	Calls       int64
+	FailedCalls int64
+	ENIs        int64
+	// IPsRequested and IPsAllocated are the IPs the calls requested and got
+	IPsRequested int64
+	IPsAllocated int64
+	// IPsPerCall is the average of the IPs a call got, and AvgBatchSize the average of the IPs a call requested
+	IPsPerCall   float64
+	AvgBatchSize float64
+}
+
+// EfficiencyStats describes how many IPs the growth of the pool got from each EC2 call, for introspection
+type EfficiencyStats struct {
+	Lifetime AllocEfficiency
+	// Recent counts the calls of the last Window, up to the last 512, and FillRate is the IPs they allocated per minute
+	Recent   AllocEfficiency
+	Window   time.Duration
+	FillRate float64
+}
+
+// allocCall is a call to allocate IP addresses on an ENI
+type allocCall struct {
+	time      time.Time
+	requested int
+	allocated int
+	failed    bool
+}
+
+// efficiencyState keeps the totals and the last calls to allocate IP addresses, and when the last ENIs were created
+type efficiencyState struct {
+	lifetime AllocEfficiency
+	calls    []allocCall
+	enis     []time.Time
+	lock     sync.RWMutex
+}
+
+// recordAllocCall records a call that requested IP addresses and got allocated of them, or failed with err
+func (s *efficiencyState) recordAllocCall(requested int, allocated []string, err error) {
+	if err == nil && len(allocated) == 0 {
+		// Nothing to allocate, or the ENI is full: EC2 was not called
+		return
+	}
+	call := allocCall{time: time.Now(), requested: requested, allocated: len(allocated), failed: err != nil}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	addAllocCall(&s.lifetime, call)
+	s.calls = append(s.calls, call)
+	s.calls = s.calls[keepLast(len(s.calls), maxEfficiencyCalls):]
+}
+
+// recordENI records the creation of an ENI
+func (s *efficiencyState) recordENI() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lifetime.ENIs++
+	s.enis = append(s.enis, time.Now())
+	s.enis = s.enis[keepLast(len(s.enis), maxEfficiencyCalls):]
+}
+
+func addAllocCall(efficiency *AllocEfficiency, call allocCall) {
+	efficiency.Calls++
+	if call.failed {
+		efficiency.FailedCalls++
+	}
+	efficiency.IPsRequested += int64(call.requested)
+	efficiency.IPsAllocated += int64(call.allocated)
+}
+
+// withAverages returns the efficiency with its averages computed
+func (efficiency AllocEfficiency) withAverages() AllocEfficiency {
+	if efficiency.Calls > 0 {
+		efficiency.IPsPerCall = float64(efficiency.IPsAllocated) / float64(efficiency.Calls)
+		efficiency.AvgBatchSize = float64(efficiency.IPsRequested) / float64(efficiency.Calls)
+	}
+	return efficiency
+}
+
+// GetEfficiencyStats returns how many IPs the growth of the pool got from each EC2 call, over the lifetime of ipamd
+// and recently
+func (c *IPAMContext) GetEfficiencyStats() EfficiencyStats {
+	since := time.Now().Add(-efficiencyWindow)
+	c.efficiency.lock.RLock()
+	defer c.efficiency.lock.RUnlock()
+	var recent AllocEfficiency
+	for _, call := range c.efficiency.calls {
+		if call.time.After(since) {
+			addAllocCall(&recent, call)
+		}
+	}
+	for _, created := range c.efficiency.enis {
+		if created.After(since) {
+			recent.ENIs++
+		}
+	}
+	return EfficiencyStats{
+		Lifetime: c.efficiency.lifetime.withAverages(),
+		Recent:   recent.withAverages(),
+		Window:   efficiencyWindow,
+		FillRate: float64(recent.IPsAllocated) / efficiencyWindow.Minutes(),
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/rpc"
+)
+
+// EgressOnlyPod is an IP of a pod that only originates traffic, the connections opened to it are dropped, for
+// introspection
+type EgressOnlyPod struct {
+	Name      string
+	Namespace string
+	Sandbox   string
+	IP        string
+	// IfName is the additional interface the IP is assigned to, empty for the pod's default interface
+	IfName string `json:",omitempty"`
+	// Since is when the rule of the IP was installed, or found when ipamd started
+	Since time.Time
+}
+
+// EgressOnlyStats contains the egress-only pods, for introspection
+type EgressOnlyStats struct {
+	Pods []EgressOnlyPod
+	// Failed is the number of AddNetwork requests that failed because the rules could not be installed, LastError
+	// why the last one did
+	Failed    int64
+	LastError string `json:",omitempty"`
+}
+
+// egressOnlyState keeps the IPs the egress-only rules are installed for
+type egressOnlyState struct {
+	ips       map[string]EgressOnlyPod
+	failed    int64
+	lastError string
+	lock      sync.RWMutex
+}
+
+func (s *egressOnlyState) add(pod EgressOnlyPod) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ips == nil {
+		s.ips = make(map[string]EgressOnlyPod)
+	}
+	if _, ok := s.ips[pod.IP]; !ok {
+		s.ips[pod.IP] = pod
+	}
+}
+
+// remove forgets the IP, and returns true if it was egress-only
+func (s *egressOnlyState) remove(ip string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.ips[ip]
+	delete(s.ips, ip)
+	return ok
+}
+
+func (s *egressOnlyState) recordFailure(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failed++
+	s.lastError = err.Error()
+}
+
+// podEgressOnly returns true if the CNI plugin asked for the pod to be egress-only, or else if the pod has the
+// egress-only annotation
+func (c *IPAMContext) podEgressOnly(pod *k8sapi.K8SPodInfo, requested bool) bool {
+	if requested {
+		return true
+	}
+	podInfo := c.k8sClient.K8SGetPodInfo(pod.Name, pod.Namespace)
+	return podInfo != nil && podInfo.EgressOnly
+}
+
+// setupEgressOnly installs the rules dropping the connections opened to the IPs of an egress-only pod
+func (c *IPAMContext) setupEgressOnly(pod *k8sapi.K8SPodInfo, addr string, interfaces []*rpc.PodInterface) error {
+	now := time.Now()
+	pods := []EgressOnlyPod{{Name: pod.Name, Namespace: pod.Namespace, Sandbox: pod.Sandbox, IP: addr, Since: now}}
+	for _, intf := range interfaces {
+		pods = append(pods, EgressOnlyPod{Name: pod.Name, Namespace: pod.Namespace, Sandbox: pod.Sandbox,
+			IP: intf.IPv4Addr, IfName: intf.IfName, Since: now})
+	}
+	for _, egressOnlyPod := range pods {
+		if err := c.networkClient.SetupPodEgressOnly(egressOnlyPod.IP); err != nil {
+			err = errors.Wrapf(err, "failed to drop the connections opened to IP %s", egressOnlyPod.IP)
+			c.egressOnly.recordFailure(err)
+			ipamdErrInc("setupEgressOnly")
+			return err
+		}
+		c.egressOnly.add(egressOnlyPod)
+	}
+	return nil
+}
+
+// releaseEgressOnlyPod releases the IPs of a pod whose egress-only rules could not be installed, as the CNI plugin
+// fails the ADD
+func (c *IPAMContext) releaseEgressOnlyPod(pod *k8sapi.K8SPodInfo) {
+	for _, intf := range c.dataStore.UnassignPodInterfaces(pod) {
+		c.teardownEgressOnly(intf.IP)
+	}
+	ip, _, err := c.dataStore.UnassignPodIPv4Address(pod)
+	if err != nil {
+		return
+	}
+	c.teardownEgressOnly(ip)
+	if c.podIPState != nil {
+		if err := c.podIPState.remove(ip); err != nil {
+			log.Warnf("Failed to remove persisted IP %s of Pod %s, Namespace %s: %v", ip, pod.Name, pod.Namespace, err)
+		}
+	}
+}
+
+// teardownEgressOnly removes the egress-only rule of a released IP, if it has one
+func (c *IPAMContext) teardownEgressOnly(ip string) {
+	if !c.egressOnly.remove(ip) {
+		return
+	}
+	if err := c.networkClient.TeardownPodEgressOnly(ip); err != nil {
+		log.Errorf("Failed to remove the egress-only rule of IP %s: %v", ip, err)
+		ipamdErrInc("teardownEgressOnly")
+	}
+}
+
+// restoreEgressOnlyPods keeps track of the egress-only rules installed before ipamd restarted, and removes those of
+// the IPs that are no longer assigned
+func (c *IPAMContext) restoreEgressOnlyPods() {
+	ips, err := c.networkClient.GetEgressOnlyIPs()
+	if err != nil {
+		log.Warnf("Failed to get the egress-only rules: %v", err)
+		return
+	}
+	if len(ips) == 0 {
+		return
+	}
+	now := time.Now()
+	assigned := make(map[string]EgressOnlyPod)
+	for _, pod := range c.dataStore.GetAssignedPods() {
+		assigned[pod.IP] = EgressOnlyPod{Name: pod.Name, Namespace: pod.Namespace, Sandbox: pod.Sandbox, IP: pod.IP, Since: now}
+		podInfo := pod
+		for _, intf := range c.dataStore.GetPodInterfaces(&podInfo) {
+			assigned[intf.IP] = EgressOnlyPod{Name: pod.Name, Namespace: pod.Namespace, Sandbox: pod.Sandbox,
+				IP: intf.IP, IfName: intf.IfName, Since: now}
+		}
+	}
+	for _, ip := range ips {
+		if egressOnlyPod, ok := assigned[ip]; ok {
+			c.egressOnly.add(egressOnlyPod)
+			continue
+		}
+		log.Infof("Removing the egress-only rule of IP %s, it is no longer assigned", ip)
+		if err := c.networkClient.TeardownPodEgressOnly(ip); err != nil {
+			log.Errorf("Failed to remove the egress-only rule of IP %s: %v", ip, err)
+		}
+	}
+}
+
+// GetEgressOnlyStats returns the egress-only pods, sorted by namespace and name
+func (c *IPAMContext) GetEgressOnlyStats() EgressOnlyStats {
+	c.egressOnly.lock.RLock()
+	defer c.egressOnly.lock.RUnlock()
+	stats := EgressOnlyStats{
+		Pods:      make([]EgressOnlyPod, 0, len(c.egressOnly.ips)),
+		Failed:    c.egressOnly.failed,
+		LastError: c.egressOnly.lastError,
+	}
+	for _, pod := range c.egressOnly.ips {
+		stats.Pods = append(stats.Pods, pod)
+	}
+	sort.Slice(stats.Pods, func(i, j int) bool {
+		a, b := stats.Pods[i], stats.Pods[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.IfName < b.IfName
+	})
+	return stats
+}
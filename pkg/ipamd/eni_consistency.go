@@ -0,0 +1,155 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to specify, in seconds, for how long after it was added to the datastore an
+	// ENI missing from the instance metadata or from DescribeNetworkInterfaces is not considered gone, because EC2
+	// can take a while to list a new ENI. When it is not set, it defaults to 30 seconds. When set to 0, a missing ENI
+	// is removed from the datastore by the next reconcile.
+	envENIConsistencyGracePeriod     = "ENI_CONSISTENCY_GRACE_PERIOD"
+	defaultENIConsistencyGracePeriod = 30 * time.Second
+
+	eniMissingFromMetadata = "missing from the instance metadata"
+	eniMissingFromDescribe = "not found by DescribeNetworkInterfaces"
+)
+
+// PendingENI is a recently added ENI that EC2 does not list yet
+type PendingENI struct {
+	ENIID string
+	// Reason is where the ENI is missing from, since FirstMissing. It is considered gone after Deadline.
+	Reason       string
+	CreateTime   time.Time
+	FirstMissing time.Time
+	Deadline     time.Time
+}
+
+// ENIConsistencyStats describes the recently added ENIs waiting for EC2 to list them, for introspection
+type ENIConsistencyStats struct {
+	GracePeriod time.Duration
+	Pending     []PendingENI
+	// Confirmed is the number of ENIs that EC2 listed within the grace period, and Expired of those it did not
+	Confirmed int64
+	Expired   int64
+}
+
+// eniConsistencyState keeps the ENIs waiting for EC2 to list them
+type eniConsistencyState struct {
+	pending   map[string]PendingENI
+	confirmed int64
+	expired   int64
+	lock      sync.RWMutex
+}
+
+// eniPendingConfirmation returns true if the ENI was added to the datastore less than ENI_CONSISTENCY_GRACE_PERIOD ago,
+// and keeps it as pending until EC2 lists it or the grace period ends
+func (c *IPAMContext) eniPendingConfirmation(eni string, reason string) bool {
+	if c.eniConsistencyGracePeriod == 0 {
+		return false
+	}
+	createTime, err := c.dataStore.GetENICreateTime(eni)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	deadline := createTime.Add(c.eniConsistencyGracePeriod)
+
+	c.eniConsistency.lock.Lock()
+	defer c.eniConsistency.lock.Unlock()
+	pending, ok := c.eniConsistency.pending[eni]
+	if now.After(deadline) {
+		if ok {
+			delete(c.eniConsistency.pending, eni)
+			c.eniConsistency.expired++
+			log.Warnf("ENI %s is still %s %v after it was added, considering it gone", eni, reason,
+				now.Sub(createTime).Round(time.Second))
+		}
+		return false
+	}
+	if !ok {
+		if c.eniConsistency.pending == nil {
+			c.eniConsistency.pending = make(map[string]PendingENI)
+		}
+		pending = PendingENI{ENIID: eni, CreateTime: createTime, FirstMissing: now, Deadline: deadline}
+		log.Infof("ENI %s added %v ago is %s, waiting until %s before considering it gone", eni,
+			now.Sub(createTime).Round(time.Second), reason, deadline.Format(time.RFC3339))
+	}
+	pending.Reason = reason
+	c.eniConsistency.pending[eni] = pending
+	return true
+}
+
+// confirmENI records that the ENI is no longer missing for reason
+func (c *IPAMContext) confirmENI(eni string, reason string) {
+	c.eniConsistency.lock.Lock()
+	defer c.eniConsistency.lock.Unlock()
+	pending, ok := c.eniConsistency.pending[eni]
+	if !ok || pending.Reason != reason {
+		return
+	}
+	delete(c.eniConsistency.pending, eni)
+	c.eniConsistency.confirmed++
+	log.Infof("ENI %s is no longer %s, after %v", eni, pending.Reason, time.Since(pending.FirstMissing).Round(time.Second))
+}
+
+// forgetPendingENI stops waiting for an ENI that is no longer in the datastore
+func (c *IPAMContext) forgetPendingENI(eni string) {
+	c.eniConsistency.lock.Lock()
+	defer c.eniConsistency.lock.Unlock()
+	delete(c.eniConsistency.pending, eni)
+}
+
+// GetENIConsistencyStats returns the ENIs waiting for EC2 to list them, oldest first
+func (c *IPAMContext) GetENIConsistencyStats() ENIConsistencyStats {
+	c.eniConsistency.lock.RLock()
+	defer c.eniConsistency.lock.RUnlock()
+	stats := ENIConsistencyStats{
+		GracePeriod: c.eniConsistencyGracePeriod,
+		Pending:     make([]PendingENI, 0, len(c.eniConsistency.pending)),
+		Confirmed:   c.eniConsistency.confirmed,
+		Expired:     c.eniConsistency.expired,
+	}
+	for _, pending := range c.eniConsistency.pending {
+		stats.Pending = append(stats.Pending, pending)
+	}
+	sort.Slice(stats.Pending, func(i, j int) bool {
+		return stats.Pending[i].CreateTime.Before(stats.Pending[j].CreateTime)
+	})
+	return stats
+}
+
+func getENIConsistencyGracePeriod() time.Duration {
+	inputStr, found := os.LookupEnv(envENIConsistencyGracePeriod)
+
+	if !found {
+		return defaultENIConsistencyGracePeriod
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envENIConsistencyGracePeriod, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, using default %v", envENIConsistencyGracePeriod, inputStr, defaultENIConsistencyGracePeriod)
+	return defaultENIConsistencyGracePeriod
+}
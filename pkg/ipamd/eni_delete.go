@@ -0,0 +1,159 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to free unused ENIs in the background. When it is enabled, the pool manager
+	// removes the ENI from the datastore and goes on, while the ENI is detached and deleted with EC2 by a background
+	// worker that retries up to eniDeleteAttempts times. It defaults to false.
+	envENIDeleteAsync = "ENI_DELETE_ASYNC"
+
+	// eniDeleteAttempts is how many times a background worker tries to free an ENI, waiting eniDeleteRetryBackoff
+	// before the first retry and twice as long before each next one. An ENI that could not be freed is still
+	// attached, the next reconcile adds it back to the datastore.
+	eniDeleteAttempts     = 5
+	eniDeleteRetryBackoff = 10 * time.Second
+
+	// maxENIDeleteFailures is the number of ENIs that could not be freed kept for introspection
+	maxENIDeleteFailures = 16
+)
+
+// ENIDelete is an ENI freed in the background
+type ENIDelete struct {
+	ENIID string
+	// Since is when the ENI was removed from the datastore, and Attempts the number of times it was freed with EC2
+	Since     time.Time
+	Attempts  int
+	LastError string `json:",omitempty"`
+}
+
+// ENIDeleteStats describes the ENIs freed in the background, for introspection
+type ENIDeleteStats struct {
+	Async bool
+	// Depth is the number of ENIs being freed, Deleted the number of ENIs freed, Retries the number of failed
+	// attempts that were retried and Failed the number of ENIs the workers gave up on
+	Depth   int
+	Deleted int64
+	Retries int64
+	Failed  int64
+	Pending []ENIDelete
+	// Failures are the most recent ENIs that could not be freed, most recent first
+	Failures []ENIDelete
+}
+
+// eniDeleteState keeps the ENIs freed in the background
+type eniDeleteState struct {
+	pending  map[string]ENIDelete
+	deleted  int64
+	retries  int64
+	failed   int64
+	failures []ENIDelete
+	lock     sync.RWMutex
+}
+
+// enqueueENIDelete starts a background worker freeing an ENI removed from the datastore
+func (c *IPAMContext) enqueueENIDelete(eni string) {
+	pending := ENIDelete{ENIID: eni, Since: time.Now()}
+	c.eniDeletes.lock.Lock()
+	if c.eniDeletes.pending == nil {
+		c.eniDeletes.pending = make(map[string]ENIDelete)
+	}
+	c.eniDeletes.pending[eni] = pending
+	c.eniDeletes.lock.Unlock()
+	log.Debugf("Freeing ENI %s in the background", eni)
+	go c.freeENIInBackground(pending)
+}
+
+// freeENIInBackground frees an ENI with EC2, up to eniDeleteAttempts times
+func (c *IPAMContext) freeENIInBackground(pending ENIDelete) {
+	backoff := c.eniDeleteRetryBackoff
+	for {
+		pending.Attempts++
+		err := c.awsClient.FreeENI(context.Background(), pending.ENIID)
+		c.eniDeletes.lock.Lock()
+		if err == nil {
+			delete(c.eniDeletes.pending, pending.ENIID)
+			c.eniDeletes.deleted++
+			c.eniDeletes.lock.Unlock()
+			c.eniTimelines.record(pending.ENIID, eniEventFreed, "detached and deleted in the background")
+			return
+		}
+		pending.LastError = err.Error()
+		ipamdErrInc("decreaseIPPoolFreeENIFailed")
+		c.eniTimelines.record(pending.ENIID, eniEventError, "failed to free: %v", err)
+		if pending.Attempts == eniDeleteAttempts {
+			delete(c.eniDeletes.pending, pending.ENIID)
+			c.eniDeletes.failed++
+			c.eniDeletes.failures = append([]ENIDelete{pending}, c.eniDeletes.failures...)
+			if len(c.eniDeletes.failures) > maxENIDeleteFailures {
+				c.eniDeletes.failures = c.eniDeletes.failures[:maxENIDeleteFailures]
+			}
+			c.eniDeletes.lock.Unlock()
+			log.Errorf("Failed to free ENI %s after %d attempts, giving up: %v", pending.ENIID, pending.Attempts, err)
+			return
+		}
+		c.eniDeletes.pending[pending.ENIID] = pending
+		c.eniDeletes.retries++
+		c.eniDeletes.lock.Unlock()
+		log.Warnf("Failed to free ENI %s, retrying in %v (%d/%d): %v", pending.ENIID, backoff, pending.Attempts,
+			eniDeleteAttempts, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// eniDeleteQueued returns true if the ENI is being freed in the background, so that the reconcile does not add it
+// back to the datastore
+func (c *IPAMContext) eniDeleteQueued(eni string) bool {
+	c.eniDeletes.lock.RLock()
+	defer c.eniDeletes.lock.RUnlock()
+	_, ok := c.eniDeletes.pending[eni]
+	return ok
+}
+
+// GetENIDeleteStats returns the ENIs freed in the background, oldest pending first
+func (c *IPAMContext) GetENIDeleteStats() ENIDeleteStats {
+	c.eniDeletes.lock.RLock()
+	defer c.eniDeletes.lock.RUnlock()
+	stats := ENIDeleteStats{
+		Async:    c.eniDeleteAsync,
+		Depth:    len(c.eniDeletes.pending),
+		Deleted:  c.eniDeletes.deleted,
+		Retries:  c.eniDeletes.retries,
+		Failed:   c.eniDeletes.failed,
+		Pending:  make([]ENIDelete, 0, len(c.eniDeletes.pending)),
+		Failures: append([]ENIDelete{}, c.eniDeletes.failures...),
+	}
+	for _, pending := range c.eniDeletes.pending {
+		stats.Pending = append(stats.Pending, pending)
+	}
+	sort.Slice(stats.Pending, func(i, j int) bool {
+		return stats.Pending[i].Since.Before(stats.Pending[j].Since)
+	})
+	return stats
+}
+
+func eniDeleteAsyncEnabled() bool {
+	return getEnvBoolWithDefault(envENIDeleteAsync, false)
+}
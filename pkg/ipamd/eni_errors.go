@@ -0,0 +1,77 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+)
+
+// ENIProvisionErrors counts the failures to provision the IPs of an ENI, for introspection
+type ENIProvisionErrors struct {
+	// Count is the number of failures, and Consecutive those since the last IPs were provisioned on the ENI
+	Count       int64
+	Consecutive int
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// eniErrorState keeps the IP provisioning failures of each ENI of the datastore
+type eniErrorState struct {
+	errors map[string]ENIProvisionErrors
+	lock   sync.RWMutex
+}
+
+// recordENIProvisionError counts a failure to allocate, find or set up the IPs of an ENI
+func (c *IPAMContext) recordENIProvisionError(eni string, err error) {
+	c.eniErrors.lock.Lock()
+	defer c.eniErrors.lock.Unlock()
+	if c.eniErrors.errors == nil {
+		c.eniErrors.errors = make(map[string]ENIProvisionErrors)
+	}
+	eniErrors := c.eniErrors.errors[eni]
+	eniErrors.Count++
+	eniErrors.Consecutive++
+	eniErrors.LastError = err.Error()
+	eniErrors.LastErrorAt = time.Now()
+	c.eniErrors.errors[eni] = eniErrors
+}
+
+// recordENIProvisioned resets the consecutive failures of an ENI once IPs were provisioned on it
+func (c *IPAMContext) recordENIProvisioned(eni string) {
+	c.eniErrors.lock.Lock()
+	defer c.eniErrors.lock.Unlock()
+	if eniErrors, ok := c.eniErrors.errors[eni]; ok {
+		eniErrors.Consecutive = 0
+		c.eniErrors.errors[eni] = eniErrors
+	}
+}
+
+// forgetENIProvisionErrors drops the failures of an ENI removed from the datastore
+func (c *IPAMContext) forgetENIProvisionErrors(eni string) {
+	c.eniErrors.lock.Lock()
+	defer c.eniErrors.lock.Unlock()
+	delete(c.eniErrors.errors, eni)
+}
+
+// getENIProvisionErrors returns the failures of an ENI, nil if it never failed
+func (c *IPAMContext) getENIProvisionErrors(eni string) *ENIProvisionErrors {
+	c.eniErrors.lock.RLock()
+	defer c.eniErrors.lock.RUnlock()
+	eniErrors, ok := c.eniErrors.errors[eni]
+	if !ok {
+		return nil
+	}
+	return &eniErrors
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+// attachedENIsState keeps the ENIs attached to the instance, with their EC2 metadata, as of the last node init or
+// reconcile. The introspection endpoints use them, so that a client polling them does not call the instance
+// metadata service and EC2 on each request and get the node throttled.
+type attachedENIsState struct {
+	enis    []awsutils.ENIMetadata
+	updated time.Time
+	lock    sync.RWMutex
+}
+
+func (s *attachedENIsState) set(enis []awsutils.ENIMetadata) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.enis = enis
+	s.updated = time.Now()
+}
+
+// getAttachedENIs returns the ENIs attached to the instance as of the last reconcile, or an error if there was none
+func (c *IPAMContext) getAttachedENIs() ([]awsutils.ENIMetadata, error) {
+	c.attachedENIs.lock.RLock()
+	defer c.attachedENIs.lock.RUnlock()
+	if c.attachedENIs.updated.IsZero() {
+		return nil, errors.New("the attached ENIs are not known yet")
+	}
+	return append([]awsutils.ENIMetadata{}, c.attachedENIs.enis...), nil
+}
+
+// ENIInfo is an ENI of the datastore with its MAC address and instance metadata path, for introspection
+type ENIInfo struct {
+	datastore.ENIIPPool
+	MAC          string `json:",omitempty"`
+	MetadataPath string `json:",omitempty"`
+	// MissingFromMetadata is true when the instance metadata service does not list the ENI as attached
+	MissingFromMetadata bool `json:",omitempty"`
+	// ProvisionErrors are the failures to allocate, find or set up the IPs of the ENI, nil if there were none
+	ProvisionErrors *ENIProvisionErrors `json:",omitempty"`
+}
+
+// ENIInfos contains the ENIs of the datastore, for introspection
+type ENIInfos struct {
+	TotalIPs    int
+	AssignedIPs int
+	ENIIPPools  map[string]ENIInfo
+	// MetadataError is why the MAC addresses could not be read from the instance metadata service
+	MetadataError string `json:",omitempty"`
+}
+
+// GetENIInfos returns the ENIs of the datastore with the MAC address the instance metadata service has for each
+func (c *IPAMContext) GetENIInfos() ENIInfos {
+	eniMACs, err := c.awsClient.GetENIMACs()
+	dsInfos := c.dataStore.GetENIInfos()
+	eniInfos := ENIInfos{
+		TotalIPs:    dsInfos.TotalIPs,
+		AssignedIPs: dsInfos.AssignedIPs,
+		ENIIPPools:  make(map[string]ENIInfo, len(dsInfos.ENIIPPools)),
+	}
+	if err != nil {
+		eniInfos.MetadataError = err.Error()
+	}
+	for eniID, pool := range dsInfos.ENIIPPools {
+		eniInfo := ENIInfo{ENIIPPool: pool, ProvisionErrors: c.getENIProvisionErrors(eniID)}
+		if err == nil {
+			if mac, ok := eniMACs[eniID]; ok {
+				eniInfo.MAC = mac
+				eniInfo.MetadataPath = awsutils.ENIMetadataPath(mac)
+			} else {
+				eniInfo.MissingFromMetadata = true
+			}
+		}
+		eniInfos.ENIIPPools[eniID] = eniInfo
+	}
+	return eniInfos
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// maxENITimelineEvents is the number of most recent events kept for each ENI, and maxENITimelines the number of
+	// ENIs, attached or not, whose events are kept
+	maxENITimelineEvents = 64
+	maxENITimelines      = 32
+
+	// The kinds of ENI events
+	eniEventCreated      = "created"
+	eniEventAdded        = "added"
+	eniEventIPsAllocated = "ips-allocated"
+	eniEventIPsReleased  = "ips-released"
+	eniEventReconciled   = "reconciled"
+	eniEventRemoved      = "removed"
+	eniEventFreed        = "freed"
+	eniEventError        = "error"
+)
+
+// ENIEvent is a step of the lifecycle of an ENI
+type ENIEvent struct {
+	Time   time.Time
+	Kind   string
+	Detail string `json:",omitempty"`
+}
+
+// ENITimeline is the lifecycle of an ENI, from the events ipamd recorded and the datastore, for introspection
+type ENITimeline struct {
+	ENIID string
+	// InDatastore is true when the datastore still has the ENI, with its current DeviceNumber and IPs
+	InDatastore  bool
+	IsPrimary    bool `json:",omitempty"`
+	DeviceNumber int
+	TotalIPs     int
+	AssignedIPs  int
+	// Events are the recorded events, oldest first. DroppedEvents is the number of older ones no longer kept.
+	Events        []ENIEvent
+	DroppedEvents int `json:",omitempty"`
+}
+
+type eniTimeline struct {
+	events  []ENIEvent
+	dropped int
+}
+
+// eniTimelineState keeps the most recent events of the most recently active ENIs
+type eniTimelineState struct {
+	timelines map[string]*eniTimeline
+	lock      sync.Mutex
+}
+
+// record adds an event to the timeline of the ENI, dropping the oldest event of the ENI, and the timeline of the
+// least recently active ENI, beyond their bounds
+func (s *eniTimelineState) record(eniID string, kind string, format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.timelines == nil {
+		s.timelines = make(map[string]*eniTimeline)
+	}
+	timeline, ok := s.timelines[eniID]
+	if !ok {
+		if len(s.timelines) >= maxENITimelines {
+			s.evictLeastRecentUnsafe()
+		}
+		timeline = &eniTimeline{}
+		s.timelines[eniID] = timeline
+	}
+	if len(timeline.events) >= maxENITimelineEvents {
+		timeline.events = timeline.events[1:]
+		timeline.dropped++
+	}
+	timeline.events = append(timeline.events, ENIEvent{Time: time.Now(), Kind: kind, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (s *eniTimelineState) evictLeastRecentUnsafe() {
+	var oldestID string
+	var oldest time.Time
+	for eniID, timeline := range s.timelines {
+		last := timeline.events[len(timeline.events)-1].Time
+		if oldestID == "" || last.Before(oldest) {
+			oldestID, oldest = eniID, last
+		}
+	}
+	delete(s.timelines, oldestID)
+}
+
+// GetENITimeline returns the lifecycle of the ENI, or nil if ipamd knows nothing of it
+func (c *IPAMContext) GetENITimeline(eniID string) *ENITimeline {
+	result := &ENITimeline{ENIID: eniID, Events: make([]ENIEvent, 0)}
+	if eni, ok := c.dataStore.GetENIInfos().ENIIPPools[eniID]; ok {
+		result.InDatastore = true
+		result.IsPrimary = eni.IsPrimary
+		result.DeviceNumber = eni.DeviceNumber
+		result.TotalIPs = len(eni.IPv4Addresses)
+		result.AssignedIPs = eni.AssignedIPv4Addresses
+	}
+
+	c.eniTimelines.lock.Lock()
+	defer c.eniTimelines.lock.Unlock()
+	timeline, ok := c.eniTimelines.timelines[eniID]
+	if !ok && !result.InDatastore {
+		return nil
+	}
+	if ok {
+		result.Events = append(result.Events, timeline.events...)
+		result.DroppedEvents = timeline.dropped
+	}
+	return result
+}
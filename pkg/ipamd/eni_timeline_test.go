@@ -0,0 +1,73 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestENITimeline(t *testing.T) {
+	c := &IPAMContext{dataStore: datastoreWith3FreeIPs()}
+	c.eniTimelines.record(primaryENIid, eniEventAdded, "added to the datastore as device %d", 1)
+	c.eniTimelines.record(secENIid, eniEventCreated, "created and attached")
+	c.eniTimelines.record(secENIid, eniEventFreed, "detached and deleted")
+
+	timeline := c.GetENITimeline(primaryENIid)
+	assert.True(t, timeline.InDatastore)
+	assert.Equal(t, 3, timeline.TotalIPs)
+	assert.Equal(t, 1, len(timeline.Events))
+	assert.Equal(t, "added to the datastore as device 1", timeline.Events[0].Detail)
+
+	// A freed ENI keeps its events
+	timeline = c.GetENITimeline(secENIid)
+	assert.False(t, timeline.InDatastore)
+	assert.Equal(t, []string{eniEventCreated, eniEventFreed}, []string{timeline.Events[0].Kind, timeline.Events[1].Kind})
+	assert.Nil(t, c.GetENITimeline("eni-unknown"))
+
+	// The history is bounded
+	for i := 0; i < maxENITimelineEvents+5; i++ {
+		c.eniTimelines.record(secENIid, eniEventIPsAllocated, "allocated IPs %d", i)
+	}
+	timeline = c.GetENITimeline(secENIid)
+	assert.Equal(t, maxENITimelineEvents, len(timeline.Events))
+	assert.Equal(t, 7, timeline.DroppedEvents)
+	for i := 0; i < maxENITimelines; i++ {
+		c.eniTimelines.record(fmt.Sprintf("eni-%d", i), eniEventCreated, "created and attached")
+	}
+	assert.Equal(t, maxENITimelines, len(c.eniTimelines.timelines))
+	assert.NotNil(t, c.GetENITimeline(primaryENIid), "the datastore still has the ENI")
+	assert.Nil(t, c.GetENITimeline(secENIid))
+
+	handler := eniTimelineRequestHandler(c)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/v1/eni/eni-1/timeline", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result ENITimeline
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, "eni-1", result.ENIID)
+	assert.Equal(t, 1, len(result.Events))
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/v1/eni/eni-1", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/v1/eni/"+secENIid+"/timeline", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do when, with custom networking, the subnet of the
+	// ENIConfig of the node is not in the availability zone of the node, where EC2 cannot attach its ENIs:
+	//   "warn" (default): log an error and still try to allocate ENIs
+	//   "block": also not allocate ENIs until the ENIConfig is fixed
+	envENIConfigAZMismatchPolicy = "ENICONFIG_AZ_MISMATCH_POLICY"
+	eniConfigAZMismatchWarn      = "warn"
+	eniConfigAZMismatchBlock     = "block"
+)
+
+// errENIConfigAZMismatch is returned when no ENI is allocated because the ENIConfig subnet is in another zone
+var errENIConfigAZMismatch = errors.New("the subnet of the ENIConfig of the node is not in the availability zone of the node")
+
+// ENIConfigAZCheck is the result of the last check of the availability zone of the ENIConfig subnet, for introspection
+type ENIConfigAZCheck struct {
+	Policy   string
+	NodeAZ   string
+	Subnet   string
+	SubnetAZ string `json:",omitempty"`
+	// Mismatch is true when SubnetAZ is not NodeAZ, and LastError why the zone of the subnet could not be found
+	Mismatch  bool
+	LastCheck time.Time
+	LastError string `json:",omitempty"`
+}
+
+// ENIConfigStatus is the ENIConfigs known to ipamd, with the check of the zone of the subnet of the ENIConfig of the
+// node. AZCheck is nil when custom networking is disabled or the subnet was not checked yet. InvalidStaticRoutes are
+// the ENIConfigs whose static routes are not applied, with the reason.
+type ENIConfigStatus struct {
+	*eniconfig.ENIConfigInfo
+	AZCheck             *ENIConfigAZCheck `json:",omitempty"`
+	InvalidStaticRoutes map[string]string `json:",omitempty"`
+}
+
+// eniConfigAZState keeps the zone of the ENIConfig subnets and the last check
+type eniConfigAZState struct {
+	// subnetAZs are the availability zones of the subnets checked, they never change
+	subnetAZs map[string]string
+	last      *ENIConfigAZCheck
+	lock      sync.RWMutex
+}
+
+// checkENIConfigAZ checks that the subnet of the ENIConfig of the node is in the availability zone of the node. It
+// returns errENIConfigAZMismatch if it is not and ENICONFIG_AZ_MISMATCH_POLICY is "block".
+func (c *IPAMContext) checkENIConfigAZ(subnet string) error {
+	check := ENIConfigAZCheck{
+		Policy:    c.eniConfigAZMismatchPolicy,
+		NodeAZ:    c.awsClient.GetAvailabilityZone(),
+		Subnet:    subnet,
+		LastCheck: time.Now(),
+	}
+	c.eniConfigAZ.lock.Lock()
+	defer c.eniConfigAZ.lock.Unlock()
+	subnetAZ, ok := c.eniConfigAZ.subnetAZs[subnet]
+	if !ok {
+		var err error
+		if subnetAZ, err = c.awsClient.GetSubnetAvailabilityZone(c.poolManagerContext(), subnet); err != nil {
+			// Let the allocation fail on its own, the subnet may be unknown
+			check.LastError = err.Error()
+			c.eniConfigAZ.last = &check
+			return nil
+		}
+		if c.eniConfigAZ.subnetAZs == nil {
+			c.eniConfigAZ.subnetAZs = make(map[string]string)
+		}
+		c.eniConfigAZ.subnetAZs[subnet] = subnetAZ
+	}
+	check.SubnetAZ = subnetAZ
+	check.Mismatch = subnetAZ != check.NodeAZ
+	last := c.eniConfigAZ.last
+	c.eniConfigAZ.last = &check
+	if !check.Mismatch {
+		return nil
+	}
+	if last == nil || !last.Mismatch || last.Subnet != subnet {
+		log.Errorf("The subnet %s of the ENIConfig of the node is in availability zone %s, but the node is in %s. "+
+			"EC2 cannot attach ENIs in this subnet to the node, fix the ENIConfig of the node", subnet, subnetAZ,
+			check.NodeAZ)
+		ipamdErrInc("eniConfigAZMismatch")
+	}
+	if c.eniConfigAZMismatchPolicy == eniConfigAZMismatchBlock {
+		return errENIConfigAZMismatch
+	}
+	return nil
+}
+
+// subnetInNodeAZ returns the availability zone of the subnet, and false if it is not the zone of the node, where EC2
+// cannot attach its ENIs. A subnet whose zone cannot be found is taken to be in the zone of the node.
+func (c *IPAMContext) subnetInNodeAZ(subnet string) (string, bool) {
+	c.eniConfigAZ.lock.Lock()
+	defer c.eniConfigAZ.lock.Unlock()
+	subnetAZ, ok := c.eniConfigAZ.subnetAZs[subnet]
+	if !ok {
+		var err error
+		if subnetAZ, err = c.awsClient.GetSubnetAvailabilityZone(c.poolManagerContext(), subnet); err != nil {
+			log.Warnf("Failed to find the availability zone of subnet %s: %v", subnet, err)
+			return "", true
+		}
+		if c.eniConfigAZ.subnetAZs == nil {
+			c.eniConfigAZ.subnetAZs = make(map[string]string)
+		}
+		c.eniConfigAZ.subnetAZs[subnet] = subnetAZ
+	}
+	return subnetAZ, subnetAZ == c.awsClient.GetAvailabilityZone()
+}
+
+// GetENIConfigStatus returns the ENIConfigs known to ipamd, checking the zone of the subnet of the node's ENIConfig
+func (c *IPAMContext) GetENIConfigStatus() ENIConfigStatus {
+	info := c.eniConfig.Getter()
+	status := ENIConfigStatus{ENIConfigInfo: info, InvalidStaticRoutes: invalidStaticRoutes(info)}
+	if !c.useCustomNetworking {
+		return status
+	}
+	if eniCfg, err := c.eniConfig.MyENIConfig(); err == nil && eniCfg.Subnet != "" {
+		_ = c.checkENIConfigAZ(eniCfg.Subnet)
+	}
+	c.eniConfigAZ.lock.RLock()
+	defer c.eniConfigAZ.lock.RUnlock()
+	if c.eniConfigAZ.last != nil {
+		check := *c.eniConfigAZ.last
+		status.AZCheck = &check
+	}
+	return status
+}
+
+func getENIConfigAZMismatchPolicy() string {
+	return envsetting.Choice(envENIConfigAZMismatchPolicy, eniConfigAZMismatchWarn,
+		eniConfigAZMismatchWarn, eniConfigAZMismatchBlock)
+}
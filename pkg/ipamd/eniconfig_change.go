@@ -0,0 +1,241 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do about an ENI created with custom networking while the
+	// ENIConfig of the node changed, so that its subnet or security groups are no longer the ones of the ENIConfig:
+	//   "flag" (default): log the ENI, and list it on /v1/eniconfig-changes until the ENIConfig matches it again or
+	//     the ENI is freed
+	//   "replace": also free the ENI at the next reconcile once no pod uses its IPs, the pool then creates a new ENI
+	//     with the current ENIConfig if it needs one
+	envENIConfigChangePolicy = "ENICONFIG_CHANGE_POLICY"
+	eniConfigChangeFlag      = "flag"
+	eniConfigChangeReplace   = "replace"
+
+	// The resolutions of an ENIConfig change
+	eniConfigChangeStale    = "stale"
+	eniConfigChangeReverted = "reverted"
+	eniConfigChangeRemoved  = "removed"
+	eniConfigChangeReplaced = "replaced"
+
+	// maxENIConfigChanges is how many of the recent ENIConfig changes are kept
+	maxENIConfigChanges = 32
+)
+
+// ENIConfigChange is an ENI created while the ENIConfig of the node changed, for introspection
+type ENIConfigChange struct {
+	ENIID string
+	Time  time.Time
+	// CreatedSubnet and CreatedSecurityGroups are the ENIConfig the ENI was created with, Subnet and SecurityGroups
+	// the ENIConfig when it was set up
+	CreatedSubnet         string
+	CreatedSecurityGroups []string
+	Subnet                string
+	SecurityGroups        []string
+	// Resolution is "stale" while the ENI does not match the ENIConfig, then "reverted", "removed" or "replaced"
+	Resolution string
+}
+
+// ENIConfigChangeStats counts the ENIs created while the ENIConfig changed, for introspection
+type ENIConfigChangeStats struct {
+	Policy string
+	// Changes is the number of ENIs created while the ENIConfig changed, Replaced the number of them freed for it
+	Changes  int64
+	Replaced int64
+	// Stale are the ENIs that still do not match the ENIConfig
+	Stale []ENIConfigChange
+	// Recent are the most recent changes, oldest first
+	Recent []ENIConfigChange
+}
+
+// eniConfigChangeState keeps the ENIs that do not match the ENIConfig and the recent changes
+type eniConfigChangeState struct {
+	// stale are the ENIs that do not match the ENIConfig, by ENI ID
+	stale    map[string]ENIConfigChange
+	changes  int64
+	replaced int64
+	recent   []ENIConfigChange
+	lock     sync.RWMutex
+}
+
+func (s *eniConfigChangeState) record(change ENIConfigChange) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if change.Resolution == eniConfigChangeStale {
+		if s.stale == nil {
+			s.stale = make(map[string]ENIConfigChange)
+		}
+		s.stale[change.ENIID] = change
+		s.changes++
+	} else {
+		delete(s.stale, change.ENIID)
+		if change.Resolution == eniConfigChangeReplaced {
+			s.replaced++
+		}
+	}
+	s.recent = append(s.recent, change)
+	s.recent = s.recent[keepLast(len(s.recent), maxENIConfigChanges):]
+}
+
+func (s *eniConfigChangeState) staleENIs() []ENIConfigChange {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	stale := make([]ENIConfigChange, 0, len(s.stale))
+	for _, change := range s.stale {
+		stale = append(stale, change)
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Time.Before(stale[j].Time)
+	})
+	return stale
+}
+
+// checkENIConfigChange compares the ENIConfig the ENI was created with to the current one, and flags the ENI if the
+// ENIConfig changed while the ENI was being created
+func (c *IPAMContext) checkENIConfigChange(eniID string, created *v1alpha1.ENIConfigSpec) {
+	current, err := c.eniConfig.MyENIConfig()
+	if err != nil {
+		log.Warnf("Failed to get the ENIConfig to check ENI %s against: %v", eniID, err)
+		return
+	}
+	if sameENIConfig(created, current) {
+		return
+	}
+	log.Warnf("ENIConfig changed while ENI %s was created, it has subnet %s and security groups %v instead of %s and %v",
+		eniID, created.Subnet, created.SecurityGroups, current.Subnet, current.SecurityGroups)
+	ipamdErrInc("eniConfigChanged")
+	c.eniTimelines.record(eniID, eniEventError, "ENIConfig changed to subnet %s and security groups %v while created",
+		current.Subnet, current.SecurityGroups)
+	c.eniConfigChanges.record(ENIConfigChange{
+		ENIID:                 eniID,
+		Time:                  time.Now(),
+		CreatedSubnet:         created.Subnet,
+		CreatedSecurityGroups: created.SecurityGroups,
+		Subnet:                current.Subnet,
+		SecurityGroups:        current.SecurityGroups,
+		Resolution:            eniConfigChangeStale,
+	})
+}
+
+// reevaluateENIConfigChanges checks again the ENIs that did not match the ENIConfig, and when
+// ENICONFIG_CHANGE_POLICY is "replace", frees the ones no pod uses
+func (c *IPAMContext) reevaluateENIConfigChanges() {
+	stale := c.eniConfigChanges.staleENIs()
+	if len(stale) == 0 {
+		return
+	}
+	current, err := c.eniConfig.MyENIConfig()
+	if err != nil {
+		log.Warnf("Failed to get the ENIConfig to check %d stale ENIs against: %v", len(stale), err)
+		return
+	}
+	eniPools := c.dataStore.GetENIInfos().ENIIPPools
+	for _, change := range stale {
+		change.Time = time.Now()
+		change.Subnet = current.Subnet
+		change.SecurityGroups = current.SecurityGroups
+		if _, ok := eniPools[change.ENIID]; !ok {
+			log.Infof("ENI %s created with a previous ENIConfig is no longer in the datastore", change.ENIID)
+			change.Resolution = eniConfigChangeRemoved
+			c.eniConfigChanges.record(change)
+			continue
+		}
+		if sameENIConfig(&v1alpha1.ENIConfigSpec{Subnet: change.CreatedSubnet,
+			SecurityGroups: change.CreatedSecurityGroups}, current) {
+			log.Infof("ENI %s matches the ENIConfig again", change.ENIID)
+			change.Resolution = eniConfigChangeReverted
+			c.eniConfigChanges.record(change)
+			continue
+		}
+		if c.eniConfigChangePolicy == eniConfigChangeReplace && c.replaceStaleENI(change.ENIID) {
+			change.Resolution = eniConfigChangeReplaced
+			c.eniConfigChanges.record(change)
+		}
+	}
+}
+
+// replaceStaleENI frees an ENI that does not match the ENIConfig, unless pods still use its IPs, and returns true if
+// it was removed from the datastore
+func (c *IPAMContext) replaceStaleENI(eniID string) bool {
+	if err := c.dataStore.RemoveENIFromDataStore(eniID, false); err != nil {
+		if err.Error() != datastore.ENIInUseError {
+			log.Errorf("Failed to remove stale ENI %s from the datastore: %v", eniID, err)
+		} else {
+			log.Debugf("Not replacing stale ENI %s yet, pods still use its IPs", eniID)
+		}
+		return false
+	}
+	log.Infof("Replacing ENI %s created with a previous ENIConfig", eniID)
+	c.eniTimelines.record(eniID, eniEventRemoved, "removed from the datastore to replace it with the current ENIConfig")
+	c.forgetPendingENI(eniID)
+	c.forgetENIProvisionErrors(eniID)
+	if c.eniDeleteAsync {
+		c.enqueueENIDelete(eniID)
+		return true
+	}
+	if err := c.awsClient.FreeENI(c.poolManagerContext(), eniID); err != nil {
+		ipamdErrInc("replaceStaleENIFailed")
+		log.Errorf("Failed to free stale ENI %s, err: %v", eniID, err)
+		c.eniTimelines.record(eniID, eniEventError, "failed to free: %v", err)
+		return true
+	}
+	c.eniTimelines.record(eniID, eniEventFreed, "detached and deleted")
+	return true
+}
+
+// GetENIConfigChangeStats returns the ENIs that do not match the ENIConfig and the recent ENIConfig changes
+func (c *IPAMContext) GetENIConfigChangeStats() ENIConfigChangeStats {
+	stale := c.eniConfigChanges.staleENIs()
+	c.eniConfigChanges.lock.RLock()
+	defer c.eniConfigChanges.lock.RUnlock()
+	return ENIConfigChangeStats{
+		Policy:   c.eniConfigChangePolicy,
+		Changes:  c.eniConfigChanges.changes,
+		Replaced: c.eniConfigChanges.replaced,
+		Stale:    stale,
+		Recent:   append([]ENIConfigChange{}, c.eniConfigChanges.recent...),
+	}
+}
+
+// sameENIConfig returns true if the two ENIConfigs have the same subnet and security groups, in any order
+func sameENIConfig(a, b *v1alpha1.ENIConfigSpec) bool {
+	if a.Subnet != b.Subnet || len(a.SecurityGroups) != len(b.SecurityGroups) {
+		return false
+	}
+	return strings.Join(sortedStrings(a.SecurityGroups), ",") == strings.Join(sortedStrings(b.SecurityGroups), ",")
+}
+
+func sortedStrings(in []string) []string {
+	out := append([]string{}, in...)
+	sort.Strings(out)
+	return out
+}
+
+func getENIConfigChangePolicy() string {
+	return envsetting.Choice(envENIConfigChangePolicy, eniConfigChangeFlag, eniConfigChangeFlag, eniConfigChangeReplace)
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to specify, in milliseconds, for how long the AddNetwork requests that find
+	// no free IP are batched before the pool manager is woken up, so that a burst of pods is served by one pool growth
+	// sized for all of them instead of one per pod. When it is not set or set to 0, each request wakes up the pool
+	// manager right away.
+	envPoolGrowthBatchWindow = "POOL_GROWTH_BATCH_WINDOW_MS"
+	noPoolGrowthBatchWindow  = 0
+	maxPoolGrowthBatchWindow = time.Second
+
+	// recentPoolGrowthBatches is the number of batch sizes kept for introspection
+	recentPoolGrowthBatches = 16
+)
+
+// PoolGrowthBatchStats describes the batches of AddNetwork requests that found no free IP, for introspection
+type PoolGrowthBatchStats struct {
+	Window time.Duration
+	// Batches is the number of batches, Requests the number of pods in them, and Pending the pods of the open batch
+	Batches  int64
+	Requests int64
+	Pending  int
+	// RecentSizes are the number of pods of the last batches, oldest first
+	RecentSizes []int
+	MaxSize     int
+	LastBatch   time.Time
+	// Increases is the number of pool increases the batches made
+	Increases int64
+}
+
+// poolGrowthBatchState keeps the open batch and the demand of the closed ones the pool manager did not serve yet
+type poolGrowthBatchState struct {
+	pending map[string]bool
+	demand  int
+	stats   PoolGrowthBatchStats
+	lock    sync.RWMutex
+}
+
+// requestPoolGrowth asks the pool manager for a free IP for the pod. With POOL_GROWTH_BATCH_WINDOW_MS, the pod joins
+// the open batch, and the first pod of a batch closes it once the window has passed.
+func (c *IPAMContext) requestPoolGrowth(pod *k8sapi.K8SPodInfo) {
+	if c.poolGrowthBatchWindow == noPoolGrowthBatchWindow {
+		c.triggerPoolGrowth()
+		return
+	}
+	c.poolGrowthBatch.lock.Lock()
+	defer c.poolGrowthBatch.lock.Unlock()
+	if c.poolGrowthBatch.pending == nil {
+		c.poolGrowthBatch.pending = make(map[string]bool)
+	}
+	if len(c.poolGrowthBatch.pending) == 0 {
+		time.AfterFunc(c.poolGrowthBatchWindow, c.closePoolGrowthBatch)
+	}
+	c.poolGrowthBatch.pending[pod.Namespace+"/"+pod.Name+"/"+pod.Sandbox] = true
+}
+
+// closePoolGrowthBatch adds the pods of the open batch to the demand of the pool manager, and wakes it up
+func (c *IPAMContext) closePoolGrowthBatch() {
+	c.poolGrowthBatch.lock.Lock()
+	size := len(c.poolGrowthBatch.pending)
+	c.poolGrowthBatch.pending = nil
+	c.poolGrowthBatch.demand += size
+	stats := &c.poolGrowthBatch.stats
+	stats.Batches++
+	stats.Requests += int64(size)
+	stats.LastBatch = time.Now()
+	stats.MaxSize = max(stats.MaxSize, size)
+	stats.RecentSizes = append(stats.RecentSizes, size)
+	if len(stats.RecentSizes) > recentPoolGrowthBatches {
+		stats.RecentSizes = stats.RecentSizes[len(stats.RecentSizes)-recentPoolGrowthBatches:]
+	}
+	c.poolGrowthBatch.lock.Unlock()
+
+	log.Debugf("Batched %d AddNetwork requests waiting for a free IP", size)
+	c.triggerPoolGrowth()
+}
+
+// takePoolGrowthDemand returns the number of pods of the closed batches, and clears it
+func (c *IPAMContext) takePoolGrowthDemand() int {
+	c.poolGrowthBatch.lock.Lock()
+	defer c.poolGrowthBatch.lock.Unlock()
+	demand := c.poolGrowthBatch.demand
+	c.poolGrowthBatch.demand = 0
+	return demand
+}
+
+// growPoolForBatches increases the pool until it has a free IP for each pod of the closed batches, or it cannot grow
+// anymore
+func (c *IPAMContext) growPoolForBatches() {
+	demand := c.takePoolGrowthDemand()
+	if demand == 0 {
+		return
+	}
+	for i := 0; i < c.maxENI; i++ {
+		total, used := c.dataStore.GetStats()
+		if total-used-c.reservedIPs >= demand {
+			return
+		}
+		c.increaseIPPool()
+		c.poolGrowthBatch.lock.Lock()
+		c.poolGrowthBatch.stats.Increases++
+		c.poolGrowthBatch.lock.Unlock()
+		if grown, _ := c.dataStore.GetStats(); grown == total {
+			log.Debugf("The IP pool cannot grow for the %d batched AddNetwork requests", demand)
+			return
+		}
+	}
+}
+
+// GetPoolGrowthBatchStats returns the batches of AddNetwork requests that found no free IP
+func (c *IPAMContext) GetPoolGrowthBatchStats() PoolGrowthBatchStats {
+	c.poolGrowthBatch.lock.RLock()
+	defer c.poolGrowthBatch.lock.RUnlock()
+	stats := c.poolGrowthBatch.stats
+	stats.Window = c.poolGrowthBatchWindow
+	stats.Pending = len(c.poolGrowthBatch.pending)
+	stats.RecentSizes = append([]int{}, c.poolGrowthBatch.stats.RecentSizes...)
+	return stats
+}
+
+func getPoolGrowthBatchWindow() time.Duration {
+	inputStr, found := os.LookupEnv(envPoolGrowthBatchWindow)
+
+	if !found {
+		return noPoolGrowthBatchWindow
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 &&
+		time.Duration(input)*time.Millisecond <= maxPoolGrowthBatchWindow {
+		log.Debugf("Using %s %v", envPoolGrowthBatchWindow, input)
+		return time.Duration(input) * time.Millisecond
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envPoolGrowthBatchWindow, inputStr, noPoolGrowthBatchWindow)
+	return noPoolGrowthBatchWindow
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/rpc"
+)
+
+const (
+	// This environment variable is used to log at info level each AddNetwork request of a pod in the host network
+	// namespace. Such a request gets no IP address. It defaults to false, and the requests are logged at debug level.
+	envLogHostNetworkAdds = "LOG_HOST_NETWORK_ADDS"
+)
+
+// HostNetworkStats counts the AddNetwork requests of pods in the host network namespace, for introspection
+type HostNetworkStats struct {
+	LogRequests bool
+	// Adds is the number of requests, and LastPod, LastNamespace and LastAdd tell the last one
+	Adds          int64
+	LastPod       string    `json:",omitempty"`
+	LastNamespace string    `json:",omitempty"`
+	LastAdd       time.Time `json:",omitempty"`
+}
+
+// hostNetworkState keeps the host network AddNetwork counts
+type hostNetworkState struct {
+	stats HostNetworkStats
+	lock  sync.RWMutex
+}
+
+// hostNetworkAdd records an AddNetwork request of a pod in the host network namespace, which is answered without
+// assigning an IP address
+func (c *IPAMContext) hostNetworkAdd(in *rpc.AddNetworkRequest) {
+	if c.logHostNetworkAdds {
+		log.Infof("Pod %s, Namespace %s, Sandbox %s is in the host network namespace, not assigning an IP address",
+			in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID)
+	} else {
+		log.Debugf("Pod %s, Namespace %s, Sandbox %s is in the host network namespace, not assigning an IP address",
+			in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID)
+	}
+	c.hostNetwork.lock.Lock()
+	defer c.hostNetwork.lock.Unlock()
+	c.hostNetwork.stats.Adds++
+	c.hostNetwork.stats.LastPod = in.K8S_POD_NAME
+	c.hostNetwork.stats.LastNamespace = in.K8S_POD_NAMESPACE
+	c.hostNetwork.stats.LastAdd = time.Now()
+}
+
+// GetHostNetworkStats returns the host network AddNetwork counts
+func (c *IPAMContext) GetHostNetworkStats() HostNetworkStats {
+	c.hostNetwork.lock.RLock()
+	defer c.hostNetwork.lock.RUnlock()
+	stats := c.hostNetwork.stats
+	stats.LogRequests = c.logHostNetworkAdds
+	return stats
+}
+
+func logHostNetworkAddsEnabled() bool {
+	return getEnvBoolWithDefault(envLogHostNetworkAdds, false)
+}
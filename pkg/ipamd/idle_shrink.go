@@ -0,0 +1,161 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to specify, in percent, the utilization of the IP pool, the share of its IPs
+	// assigned to pods, below which the pool is idle. When the pool stays idle for IDLE_POOL_SHRINK_DURATION, the warm
+	// targets are lowered to idleShrinkWarmIPTarget, so that the pool shrinks down to MINIMUM_IP_TARGET, until the
+	// utilization against the size of the pool before the shrink goes back above the threshold. When it is not set or
+	// set to 0, the pool is never shrunk for being idle.
+	envIdlePoolShrinkThreshold = "IDLE_POOL_SHRINK_THRESHOLD"
+	noIdlePoolShrink           = 0
+
+	// This environment variable is used to specify, in seconds, how long the utilization of the IP pool must stay below
+	// IDLE_POOL_SHRINK_THRESHOLD before the pool is shrunk. When it is not set, it defaults to 30 minutes.
+	envIdlePoolShrinkDuration     = "IDLE_POOL_SHRINK_DURATION"
+	defaultIdlePoolShrinkDuration = 30 * time.Minute
+
+	// idleShrinkWarmIPTarget is the warm IP target of an idle pool, the IP kept for the next pod
+	idleShrinkWarmIPTarget = 1
+)
+
+// IdleShrinkStats describes the shrinking of the IP pool after a sustained low utilization, for introspection
+type IdleShrinkStats struct {
+	// Threshold is IDLE_POOL_SHRINK_THRESHOLD in percent, 0 when the pool is never shrunk for being idle
+	Threshold int
+	Duration  time.Duration
+	// Utilization is the percentage of the IPs of the pool assigned to pods at the last check, below the threshold
+	// since BelowSince. While the pool is shrunk, it is against UnshrunkTotal rather than the shrunk pool, which is
+	// mostly assigned by design.
+	Utilization float64
+	BelowSince  time.Time `json:",omitempty"`
+	// Active is true while the pool is shrunk, since Since, because of Trigger
+	Active        bool
+	Since         time.Time `json:",omitempty"`
+	Trigger       string    `json:",omitempty"`
+	UnshrunkTotal int       `json:",omitempty"`
+	// Shrinks is the number of times the pool was shrunk, the last one ended at LastEnd
+	Shrinks   int64
+	LastEnd   time.Time `json:",omitempty"`
+	LastCheck time.Time
+}
+
+// idleShrinkState keeps the utilization of the IP pool seen by the last check
+type idleShrinkState struct {
+	stats IdleShrinkStats
+	lock  sync.RWMutex
+}
+
+// checkIdlePool shrinks the IP pool once its utilization has stayed below IDLE_POOL_SHRINK_THRESHOLD for
+// IDLE_POOL_SHRINK_DURATION, and restores the warm targets as soon as it goes back above. While the pool is shrunk, the
+// utilization is measured against the size of the pool before the shrink, otherwise releasing the free IPs would
+// raise it above the threshold, restore the warm targets, and the pool would grow and shrink again at every duration.
+func (c *IPAMContext) checkIdlePool() {
+	if c.idlePoolShrinkThreshold == noIdlePoolShrink {
+		return
+	}
+	total, assigned := c.dataStore.GetStats()
+
+	c.idleShrink.lock.Lock()
+	defer c.idleShrink.lock.Unlock()
+	stats := &c.idleShrink.stats
+	if stats.Active && stats.UnshrunkTotal > total {
+		total = stats.UnshrunkTotal
+	}
+	utilization := 100.0
+	if total > 0 {
+		utilization = float64(assigned) * 100 / float64(total)
+	}
+	now := time.Now()
+	stats.LastCheck = now
+	stats.Utilization = utilization
+	if utilization >= float64(c.idlePoolShrinkThreshold) {
+		if stats.Active {
+			log.Infof("IP pool utilization is back to %.1f%%, restoring the warm targets", utilization)
+			stats.Active, stats.Since, stats.Trigger, stats.LastEnd = false, time.Time{}, "", now
+			stats.UnshrunkTotal = 0
+		}
+		stats.BelowSince = time.Time{}
+		return
+	}
+	if stats.BelowSince.IsZero() {
+		stats.BelowSince = now
+	}
+	if !stats.Active && now.Sub(stats.BelowSince) >= c.idlePoolShrinkDuration {
+		stats.Active, stats.Since, stats.UnshrunkTotal = true, now, total
+		stats.Trigger = fmt.Sprintf("utilization %.1f%% below %s %d%% since %s", utilization,
+			envIdlePoolShrinkThreshold, c.idlePoolShrinkThreshold, stats.BelowSince.Format(time.RFC3339))
+		stats.Shrinks++
+		log.Infof("IP pool is idle, %s, shrinking it down to %s %d", stats.Trigger, envMinimumIPTarget,
+			c.minimumIPTarget)
+	}
+}
+
+// idlePoolShrinking returns true while the IP pool is shrunk for being idle
+func (c *IPAMContext) idlePoolShrinking() bool {
+	c.idleShrink.lock.RLock()
+	defer c.idleShrink.lock.RUnlock()
+	return c.idleShrink.stats.Active
+}
+
+func (c *IPAMContext) getIdleShrinkStats() IdleShrinkStats {
+	c.idleShrink.lock.RLock()
+	defer c.idleShrink.lock.RUnlock()
+	stats := c.idleShrink.stats
+	stats.Threshold = c.idlePoolShrinkThreshold
+	stats.Duration = c.idlePoolShrinkDuration
+	return stats
+}
+
+func getIdlePoolShrinkThreshold() int {
+	inputStr, found := os.LookupEnv(envIdlePoolShrinkThreshold)
+
+	if !found {
+		return noIdlePoolShrink
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && input <= 100 {
+		log.Debugf("Using %s %v", envIdlePoolShrinkThreshold, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, the pool is never shrunk for being idle", envIdlePoolShrinkThreshold, inputStr)
+	return noIdlePoolShrink
+}
+
+func getIdlePoolShrinkDuration() time.Duration {
+	inputStr, found := os.LookupEnv(envIdlePoolShrinkDuration)
+
+	if !found {
+		return defaultIdlePoolShrinkDuration
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envIdlePoolShrinkDuration, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, using default %v", envIdlePoolShrinkDuration, inputStr,
+		defaultIdlePoolShrinkDuration)
+	return defaultIdlePoolShrinkDuration
+}
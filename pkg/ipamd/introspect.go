@@ -15,6 +15,7 @@ package ipamd
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
@@ -25,6 +26,7 @@ import (
 
 	log "github.com/cihub/seelog"
 
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/retry"
 )
@@ -89,11 +91,74 @@ func (c *IPAMContext) ServeIntrospection() {
 
 func (c *IPAMContext) setupIntrospectionServer() *http.Server {
 	serverFunctions := map[string]func(w http.ResponseWriter, r *http.Request){
-		"/v1/enis":                      eniV1RequestHandler(c),
-		"/v1/eni-configs":               eniConfigRequestHandler(c),
-		"/v1/pods":                      podV1RequestHandler(c),
-		"/v1/networkutils-env-settings": networkEnvV1RequestHandler(),
-		"/v1/ipamd-env-settings":        ipamdEnvV1RequestHandler(),
+		"/v1/enis":                      jsonRequestHandler(func() interface{} { return c.GetENIInfos() }),
+		"/v1/eni-configs":               jsonRequestHandler(func() interface{} { return c.GetENIConfigStatus() }),
+		"/v1/pods":                      jsonRequestHandler(func() interface{} { return c.GetPodInfos() }),
+		"/v1/pool-stats":                jsonRequestHandler(func() interface{} { return c.GetPoolStats() }),
+		"/v1/routes":                    routesRequestHandler(c),
+		"/v1/pod-ip-restore":            jsonRequestHandler(func() interface{} { return c.GetPodIPRestoreStats() }),
+		"/v1/reconcile-status":          jsonRequestHandler(func() interface{} { return c.GetReconcileStatus() }),
+		"/v1/cooldown":                  jsonRequestHandler(func() interface{} { return c.dataStore.GetCoolingIPs() }),
+		"/v1/credentials":               jsonRequestHandler(func() interface{} { return c.awsClient.GetCredentialsInfo() }),
+		"/v1/metadata-wait":             jsonRequestHandler(func() interface{} { return c.awsClient.GetMetadataWaitInfo() }),
+		"/v1/ec2-api-latency":           jsonRequestHandler(func() interface{} { return awsutils.GetAPILatencyStats() }),
+		"/v1/primary-eni":               jsonRequestHandler(func() interface{} { return c.GetPrimaryENIStatus() }),
+		"/v1/near-full-enis":            nearFullENIsRequestHandler(c),
+		"/v1/k8s-status":                jsonRequestHandler(func() interface{} { return c.GetK8SStatus() }),
+		"/v1/duplicate-adds":            jsonRequestHandler(func() interface{} { return c.GetDuplicateAddStats() }),
+		"/v1/pod-trace":                 podTraceRequestHandler(c),
+		"/v1/subnet-full":               jsonRequestHandler(func() interface{} { return c.GetSubnetFullStats() }),
+		"/v1/networking-mode":           jsonRequestHandler(func() interface{} { return c.GetNetworkingMode() }),
+		"/v1/add-retries":               jsonRequestHandler(func() interface{} { return c.GetAddRetryStats() }),
+		"/v1/orphaned-by-sandbox":       jsonRequestHandler(func() interface{} { return c.GetOrphanedIPStats() }),
+		"/v1/attach-limit":              jsonRequestHandler(func() interface{} { return c.GetAttachLimitStats() }),
+		"/v1/del-verify":                jsonRequestHandler(func() interface{} { return c.GetDelVerifyStats() }),
+		"/v1/eni/":                      eniTimelineRequestHandler(c),
+		"/v1/host-network-adds":         jsonRequestHandler(func() interface{} { return c.GetHostNetworkStats() }),
+		"/v1/subnet-selection":          jsonRequestHandler(func() interface{} { return c.GetSubnetSelectionStats() }),
+		"/v1/eni-consistency":           jsonRequestHandler(func() interface{} { return c.GetENIConsistencyStats() }),
+		"/v1/sg-drift":                  sgDriftRequestHandler(c),
+		"/v1/namespace-usage":           jsonRequestHandler(func() interface{} { return c.GetNamespaceUsage() }),
+		"/v1/pod-routing":               podRoutingRequestHandler(c),
+		"/v1/eni-deletes":               jsonRequestHandler(func() interface{} { return c.GetENIDeleteStats() }),
+		"/v1/ip-classification":         jsonRequestHandler(func() interface{} { return c.GetIPClassification() }),
+		"/v1/conntrack-cleanup":         jsonRequestHandler(func() interface{} { return c.GetConntrackCleanupStats() }),
+		"/v1/connectivity":              jsonRequestHandler(func() interface{} { return c.GetConnectivity() }),
+		"/v1/pool-growth-batches":       jsonRequestHandler(func() interface{} { return c.GetPoolGrowthBatchStats() }),
+		"/v1/lock-stats":                jsonRequestHandler(func() interface{} { return c.dataStore.GetLockStats() }),
+		"/v1/activity":                  jsonRequestHandler(func() interface{} { return c.GetActivity() }),
+		"/v1/detach-blocked":            jsonRequestHandler(func() interface{} { return c.GetDetachBlocked() }),
+		"/v1/pod-cidrs":                 podCIDRsRequestHandler(c),
+		"/v1/netlink-retries":           jsonRequestHandler(func() interface{} { return c.GetNetlinkRetryStats() }),
+		"/v1/legacy-enis":               jsonRequestHandler(func() interface{} { return c.GetLegacyENIStats() }),
+		"/v1/del-failures":              jsonRequestHandler(func() interface{} { return c.GetDelFailureStats() }),
+		"/v1/same-pod-ip-reuse":         jsonRequestHandler(func() interface{} { return c.GetSamePodIPReuseStats() }),
+		"/v1/egress-only-pods":          jsonRequestHandler(func() interface{} { return c.GetEgressOnlyStats() }),
+		"/v1/network-topology":          networkTopologyRequestHandler(c),
+		"/v1/partial-allocations":       jsonRequestHandler(func() interface{} { return c.GetPartialAllocStats() }),
+		"/v1/cni-stats":                 jsonRequestHandler(func() interface{} { return c.GetCNIStats() }),
+		"/v1/cluster-info":              jsonRequestHandler(func() interface{} { return c.awsClient.GetClusterInfo() }),
+		"/v1/startup-profile":           jsonRequestHandler(func() interface{} { return c.GetStartupProfile() }),
+		"/v1/address-families":          jsonRequestHandler(func() interface{} { return c.GetAddressFamilyStats() }),
+		"/v1/efficiency":                jsonRequestHandler(func() interface{} { return c.GetEfficiencyStats() }),
+		"/v1/ip-family-mismatches":      jsonRequestHandler(func() interface{} { return c.GetIPFamilyMismatchStats() }),
+		"/v1/trunk-topology":            trunkTopologyRequestHandler(c),
+		"/v1/branch-eni-cleanup":        jsonRequestHandler(func() interface{} { return c.GetBranchENICleanupStats() }),
+		"/v1/iptables-backend":          jsonRequestHandler(func() interface{} { return c.networkClient.GetIptablesBackend() }),
+		"/v1/subnet-distribution":       jsonRequestHandler(func() interface{} { return c.GetSubnetDistribution() }),
+		"/v1/datastore-size":            jsonRequestHandler(func() interface{} { return c.dataStore.GetSizeStats() }),
+		"/v1/alloc-recoveries":          jsonRequestHandler(func() interface{} { return c.GetAllocIdempotencyStats() }),
+		"/v1/diagnose":                  diagnoseRequestHandler(c),
+		"/v1/detached-eni-pods":         jsonRequestHandler(func() interface{} { return c.GetDetachedENIPodStats() }),
+		"/v1/ip-ages":                   jsonRequestHandler(func() interface{} { return c.GetIPAssignmentAgeStats() }),
+		"/v1/eniconfig-changes":         jsonRequestHandler(func() interface{} { return c.GetENIConfigChangeStats() }),
+		"/v1/effective-policy":          jsonRequestHandler(func() interface{} { return c.GetEffectivePolicy() }),
+		"/healthz":                      healthzRequestHandler(c),
+		"/v1/networkutils-env-settings": jsonRequestHandler(func() interface{} { return networkutils.GetConfigForDebug() }),
+		"/v1/ipamd-env-settings":        jsonRequestHandler(func() interface{} { return GetConfigForDebug() }),
+	}
+	if selfTestEnabled() {
+		serverFunctions["/v1/selftest"] = selfTestRequestHandler(c)
 	}
 	paths := make([]string, 0, len(serverFunctions))
 	for path := range serverFunctions {
@@ -112,13 +177,22 @@ func (c *IPAMContext) setupIntrospectionServer() *http.Server {
 	}
 	serveMux := http.NewServeMux()
 	serveMux.HandleFunc("/", defaultHandler)
+	maxResponseSize := getIntrospectionMaxResponseSize()
 	for key, fn := range serverFunctions {
+		if maxResponseSize != noIntrospectionMaxResponseSize {
+			fn = limitResponseSize(key, maxResponseSize, fn)
+		}
 		serveMux.HandleFunc(key, fn)
 	}
 
+	var handler http.Handler = serveMux
+	if rateLimit := getIntrospectionRateLimit(); rateLimit != noIntrospectionRateLimit {
+		handler = limitRequestRate(rateLimit, serveMux)
+	}
+
 	// Log all requests and then pass through to serveMux
 	loggingServeMux := http.NewServeMux()
-	loggingServeMux.Handle("/", LoggingHandler{serveMux})
+	loggingServeMux.Handle("/", LoggingHandler{handler})
 
 	addr, ok := os.LookupEnv(introspectionBindAddress)
 	if !ok {
@@ -136,66 +210,183 @@ func (c *IPAMContext) setupIntrospectionServer() *http.Server {
 	return server
 }
 
-func eniV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+func routesRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(ipam.dataStore.GetENIInfos())
+		eniRoutes, err := ipam.GetENIRoutes()
 		if err != nil {
-			log.Errorf("Failed to marshal ENI data: %v", err)
+			log.Errorf("Failed to get ENI routes: %v", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		logErr(w.Write(responseJSON))
+		writeJSON(w, r, eniRoutes)
+	}
+}
+
+// eniTimelineRequestHandler returns the lifecycle of the ENI of a /v1/eni/{id}/timeline path
+func eniTimelineRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/eni/"), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] != "timeline" {
+			http.NotFound(w, r)
+			return
+		}
+		timeline := ipam.GetENITimeline(parts[0])
+		if timeline == nil {
+			http.Error(w, fmt.Sprintf("unknown ENI %q", parts[0]), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, timeline)
 	}
 }
 
-func podV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+// sgDriftRequestHandler compares the security groups of the ENIs with the expected ones, it describes the ENIs with EC2
+func sgDriftRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(ipam.dataStore.GetPodInfos())
+		drift, err := ipam.GetSecurityGroupDrift()
 		if err != nil {
-			log.Errorf("Failed to marshal pod data: %v", err)
+			log.Errorf("Failed to get the security groups of the ENIs: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, r, drift)
+	}
+}
+
+func podRoutingRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var vpcCIDRs []string
+		for _, cidr := range ipam.awsClient.GetVPCIPv4CIDRs() {
+			vpcCIDRs = append(vpcCIDRs, *cidr)
+		}
+		writeJSON(w, r, ipam.networkClient.GetPodRoutingPolicy(vpcCIDRs))
+	}
+}
+
+func podCIDRsRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		podCIDRs, err := ipam.GetPodCIDRs()
+		if err != nil {
+			log.Errorf("Failed to get pod CIDRs: %v", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		logErr(w.Write(responseJSON))
+		writeJSON(w, r, podCIDRs)
 	}
 }
 
-func eniConfigRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+func networkTopologyRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(ipam.eniConfig.Getter())
+		topology, err := ipam.GetNetworkTopology()
 		if err != nil {
-			log.Errorf("Failed to marshal ENI config: %v", err)
+			log.Errorf("Failed to get network topology: %v", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		logErr(w.Write(responseJSON))
+		writeJSON(w, r, topology)
 	}
 }
 
-func networkEnvV1RequestHandler() func(http.ResponseWriter, *http.Request) {
+func trunkTopologyRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(networkutils.GetConfigForDebug())
+		topology, err := ipam.GetTrunkTopology()
 		if err != nil {
-			log.Errorf("Failed to marshal network env var data: %v", err)
+			log.Errorf("Failed to get trunk topology: %v", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		logErr(w.Write(responseJSON))
+		writeJSON(w, r, topology)
+	}
+}
+
+// diagnoseRequestHandler runs all the checks of the networking of the node, it describes the ENIs with EC2
+func diagnoseRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, ipam.Diagnose())
+	}
+}
+
+// podTraceRequestHandler returns the most recent AddNetwork decision traces of the pod given by the "name" and
+// "namespace" query parameters, or of all pods when they are not set
+func podTraceRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		writeJSON(w, r, ipam.GetPodTraces(query.Get("name"), query.Get("namespace")))
+	}
+}
+
+// nearFullENIsRequestHandler lists the ENIs that have room for at most the "headroom" query parameter more pods
+func nearFullENIsRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		headroom := ipam.nearFullHeadroom()
+		if value := r.URL.Query().Get("headroom"); value != "" {
+			var err error
+			headroom, err = strconv.Atoi(value)
+			if err != nil || headroom < 0 {
+				http.Error(w, fmt.Sprintf("invalid headroom %q", value), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, r, ipam.GetNearFullENIs(headroom))
 	}
 }
 
-func ipamdEnvV1RequestHandler() func(http.ResponseWriter, *http.Request) {
+// selfTestRequestHandler runs a self-test of the IP assignment path on POST
+func selfTestRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		responseJSON, err := json.Marshal(GetConfigForDebug())
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		result := ipam.selfTest()
+		responseJSON, err := json.Marshal(result)
 		if err != nil {
-			log.Errorf("Failed to marshal ipamd env var data: %v", err)
+			log.Errorf("Failed to marshal self-test result: %v", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
+		if !result.Success {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
 		logErr(w.Write(responseJSON))
 	}
 }
 
+// healthzRequestHandler reports ipamd as healthy as long as the IP pool reconciles do not keep failing
+func healthzRequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := ipam.GetReconcileStatus()
+		if !status.Healthy {
+			msg := "no successful reconcile yet"
+			if !status.LastSuccess.IsZero() {
+				msg = fmt.Sprintf("%d reconciles failed since %s, last error: %s", status.ConsecutiveFailures,
+					status.LastSuccess.Format(time.RFC3339), status.LastError)
+			}
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+		logErr(w.Write([]byte("ok")))
+	}
+}
+
+// jsonRequestHandler returns a handler that responds with the JSON encoding of what get returns
+func jsonRequestHandler(get func() interface{}) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, get())
+	}
+}
+
+// writeJSON responds to the introspection request r with the JSON encoding of v
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	responseJSON, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("Failed to marshal the response of %s: %v", r.URL.Path, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logErr(w.Write(responseJSON))
+}
+
 func logErr(_ int, err error) {
 	if err != nil {
 		log.Errorf("Write failed: %v", err)
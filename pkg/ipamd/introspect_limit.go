@@ -0,0 +1,194 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to specify, in bytes, the maximum size of the response of an introspection
+	// endpoint. A larger response is truncated: the largest list or map of the response keeps as many entries as fit,
+	// and the response is wrapped in {"Truncated": true, "OmittedEntries": <count>, "Response": <response>}. When it is
+	// not set or set to 0, the responses are not limited.
+	envIntrospectionMaxResponseSize = "INTROSPECTION_MAX_RESPONSE_SIZE"
+	noIntrospectionMaxResponseSize  = 0
+)
+
+// truncatedResponse wraps an introspection response that was larger than INTROSPECTION_MAX_RESPONSE_SIZE
+type truncatedResponse struct {
+	Truncated bool
+	// OmittedEntries is the number of entries left out of the largest list or map of the response
+	OmittedEntries int
+	Response       interface{} `json:",omitempty"`
+}
+
+// bufferedResponseWriter keeps the response of a handler so that its size can be checked before it is sent
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// limitResponseSize truncates the successful responses of the handler that are larger than maxSize bytes
+func limitResponseSize(path string, maxSize int, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buffered := &bufferedResponseWriter{ResponseWriter: w}
+		handler(buffered, r)
+		response := buffered.body.Bytes()
+		if (buffered.status == 0 || buffered.status == http.StatusOK) && len(response) > maxSize {
+			truncated, omitted := truncateResponse(response, maxSize)
+			log.Warnf("Truncated the %d bytes response of %s to %d bytes, omitting %d entries, it is larger than %s",
+				len(response), path, len(truncated), omitted, envIntrospectionMaxResponseSize)
+			response = truncated
+		}
+		if buffered.status != 0 {
+			w.WriteHeader(buffered.status)
+		}
+		logErr(w.Write(response))
+	}
+}
+
+// truncateResponse drops the last entries of the largest list or map of the JSON response, the response itself or one
+// of its fields, until the wrapped response fits in maxSize bytes. It returns the wrapped response and the number of
+// entries omitted.
+func truncateResponse(response []byte, maxSize int) ([]byte, int) {
+	var decoded interface{}
+	if err := json.Unmarshal(response, &decoded); err != nil {
+		return marshalJSON(truncatedResponse{Truncated: true}), 0
+	}
+
+	// The entries are truncated in place, in the response or in its largest field
+	var field string
+	entries := decoded
+	if object, ok := decoded.(map[string]interface{}); ok {
+		largest := 0
+		for key, value := range object {
+			if !isCollection(value) {
+				continue
+			}
+			if size := len(marshalJSON(value)); size > largest {
+				field, largest = key, size
+			}
+		}
+		if field != "" {
+			entries = object[field]
+		}
+	}
+	if !isCollection(entries) {
+		return marshalJSON(truncatedResponse{Truncated: true}), 0
+	}
+
+	total := collectionLen(entries)
+	render := func(keep int) []byte {
+		kept := truncateCollection(entries, keep)
+		wrapped := truncatedResponse{Truncated: true, OmittedEntries: total - keep, Response: kept}
+		if field != "" {
+			object := make(map[string]interface{}, len(decoded.(map[string]interface{})))
+			for key, value := range decoded.(map[string]interface{}) {
+				object[key] = value
+			}
+			object[field] = kept
+			wrapped.Response = object
+		}
+		return marshalJSON(wrapped)
+	}
+
+	// Find the most entries that fit
+	keep := sort.Search(total+1, func(keep int) bool {
+		return len(render(keep)) > maxSize
+	}) - 1
+	if keep < 0 {
+		return marshalJSON(truncatedResponse{Truncated: true, OmittedEntries: total}), total
+	}
+	return render(keep), total - keep
+}
+
+func isCollection(value interface{}) bool {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+func collectionLen(value interface{}) int {
+	switch v := value.(type) {
+	case []interface{}:
+		return len(v)
+	case map[string]interface{}:
+		return len(v)
+	}
+	return 0
+}
+
+// truncateCollection returns the first keep entries of the list, or of the map in key order
+func truncateCollection(value interface{}, keep int) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v[:keep]
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		kept := make(map[string]interface{}, keep)
+		for _, key := range keys[:keep] {
+			kept[key] = v[key]
+		}
+		return kept
+	}
+	return value
+}
+
+func marshalJSON(value interface{}) []byte {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		log.Errorf("Failed to marshal truncated response: %v", err)
+		return nil
+	}
+	return encoded
+}
+
+func getIntrospectionMaxResponseSize() int {
+	inputStr, found := os.LookupEnv(envIntrospectionMaxResponseSize)
+
+	if !found {
+		return noIntrospectionMaxResponseSize
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envIntrospectionMaxResponseSize, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envIntrospectionMaxResponseSize, inputStr,
+		noIntrospectionMaxResponseSize)
+	return noIntrospectionMaxResponseSize
+}
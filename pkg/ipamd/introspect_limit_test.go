@@ -0,0 +1,66 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitResponseSize(t *testing.T) {
+	serve := func(maxSize int, response interface{}) *httptest.ResponseRecorder {
+		handler := limitResponseSize("/v1/test", maxSize, func(w http.ResponseWriter, r *http.Request) {
+			responseJSON, _ := json.Marshal(response)
+			logErr(w.Write(responseJSON))
+		})
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodGet, "/v1/test", nil))
+		return recorder
+	}
+
+	// A response that fits is not changed
+	pods := make(map[string]string)
+	for i := 0; i < 6; i++ {
+		pods[fmt.Sprintf("pod-%d", i)] = fmt.Sprintf("10.0.0.%d", i)
+	}
+	recorder := serve(1024, pods)
+	podsJSON, _ := json.Marshal(pods)
+	assert.Equal(t, string(podsJSON), recorder.Body.String())
+
+	// The entries of a larger map are truncated in key order
+	recorder = serve(100, pods)
+	assert.True(t, recorder.Body.Len() <= 100)
+	assert.JSONEq(t, `{"Truncated":true,"OmittedEntries":4,"Response":{"pod-0":"10.0.0.0","pod-1":"10.0.0.1"}}`,
+		recorder.Body.String())
+
+	// The largest collection of an object is truncated, the other fields are kept
+	var ips []string
+	for i := 10; i < 20; i++ {
+		ips = append(ips, fmt.Sprintf("10.0.0.%d", i))
+	}
+	stats := map[string]interface{}{"Total": 3, "IPs": ips, "ENIs": []string{"eni-1"}}
+	recorder = serve(130, stats)
+	assert.True(t, recorder.Body.Len() <= 130)
+	assert.JSONEq(t, `{"Truncated":true,"OmittedEntries":7,"Response":{"Total":3,"IPs":["10.0.0.10","10.0.0.11","10.0.0.12"],"ENIs":["eni-1"]}}`,
+		recorder.Body.String())
+
+	// Without a collection, only the marker is left
+	recorder = serve(10, "a long response string")
+	assert.JSONEq(t, `{"Truncated":true,"OmittedEntries":0}`, recorder.Body.String())
+}
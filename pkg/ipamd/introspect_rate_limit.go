@@ -0,0 +1,123 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to specify the number of introspection requests per second each client may
+	// send, so that a scraper polling the endpoints aggressively cannot contend the datastore lock. A client may send
+	// that many requests at once, then that many per second, the others are rejected with 429 Too Many Requests. When
+	// it is not set or set to 0, the requests are not limited.
+	envIntrospectionRateLimit = "INTROSPECTION_RATE_LIMIT"
+	noIntrospectionRateLimit  = 0
+
+	// idleRateLimitClient is how long a client is kept after its last request, the next one starts with a full bucket
+	idleRateLimitClient = time.Minute
+)
+
+// tokenBucket is the requests a client may still send, refilled at the rate limit up to the burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// introspectionRateLimiter limits the rate of the introspection requests of each client, by remote host
+type introspectionRateLimiter struct {
+	rate    float64
+	clients map[string]*tokenBucket
+	// lastPrune is when the idle clients were last removed
+	lastPrune time.Time
+	lock      sync.Mutex
+}
+
+func newIntrospectionRateLimiter(rate int) *introspectionRateLimiter {
+	return &introspectionRateLimiter{rate: float64(rate), clients: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the bucket of the client, and returns false when it has none left
+func (l *introspectionRateLimiter) allow(client string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.lastPrune) > idleRateLimitClient {
+		for key, bucket := range l.clients {
+			if now.Sub(bucket.last) > idleRateLimitClient {
+				delete(l.clients, key)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	bucket, ok := l.clients[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.rate, last: now}
+		l.clients[client] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.rate {
+		bucket.tokens = l.rate
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// limitRequestRate rejects the requests of a client beyond rate per second with 429 Too Many Requests
+func limitRequestRate(rate int, handler http.Handler) http.Handler {
+	limiter := newIntrospectionRateLimiter(rate)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			// The requests on a unix socket have no remote port, they all count as the same client
+			client = r.RemoteAddr
+		}
+		if !limiter.allow(client, time.Now()) {
+			log.Debugf("Rejected introspection request %s from %s, over %s %d", r.RequestURI, r.RemoteAddr,
+				envIntrospectionRateLimit, rate)
+			ipamdErrInc("introspectionRateLimited")
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func getIntrospectionRateLimit() int {
+	inputStr, found := os.LookupEnv(envIntrospectionRateLimit)
+
+	if !found {
+		return noIntrospectionRateLimit
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envIntrospectionRateLimit, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envIntrospectionRateLimit, inputStr, noIntrospectionRateLimit)
+	return noIntrospectionRateLimit
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntrospectionRateLimiter(t *testing.T) {
+	limiter := newIntrospectionRateLimiter(2)
+	now := time.Now()
+
+	// A client may send the rate at once, then gets a token back every 1/rate second
+	assert.True(t, limiter.allow("10.0.0.1", now))
+	assert.True(t, limiter.allow("10.0.0.1", now))
+	assert.False(t, limiter.allow("10.0.0.1", now))
+	assert.True(t, limiter.allow("10.0.0.2", now))
+	assert.True(t, limiter.allow("10.0.0.1", now.Add(500*time.Millisecond)))
+	assert.False(t, limiter.allow("10.0.0.1", now.Add(500*time.Millisecond)))
+
+	// The tokens do not accumulate beyond the rate
+	later := now.Add(10 * time.Second)
+	assert.True(t, limiter.allow("10.0.0.1", later))
+	assert.True(t, limiter.allow("10.0.0.1", later))
+	assert.False(t, limiter.allow("10.0.0.1", later))
+
+	// Idle clients are forgotten
+	assert.True(t, limiter.allow("10.0.0.1", later.Add(2*idleRateLimitClient)))
+	assert.Equal(t, 1, len(limiter.clients))
+}
+
+func TestLimitRequestRate(t *testing.T) {
+	handler := limitRequestRate(1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logErr(w.Write([]byte("{}")))
+	}))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/v1/enis", nil)
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:40000").Code)
+	// The port does not make another client
+	recorder := serve("10.0.0.1:40001")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2:40000").Code)
+	// The requests on a unix socket have no port
+	assert.Equal(t, http.StatusOK, serve("@").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("@").Code)
+}
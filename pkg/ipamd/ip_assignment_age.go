@@ -0,0 +1,166 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify, in seconds, the maximum age of the assignment of an IP to a pod,
+	// for clusters that rotate the pod IPs. The assignments older than that are flagged on /v1/ip-ages. When it is not
+	// set or set to 0, the age of the assignments is not checked.
+	envMaxIPAssignmentAge = "MAX_IP_ASSIGNMENT_AGE"
+	noMaxIPAssignmentAge  = 0
+
+	// This environment variable is used to specify what to do about the IPs assigned for longer than
+	// MAX_IP_ASSIGNMENT_AGE:
+	//   "flag" (default): only list them on /v1/ip-ages
+	//   "reassign": also give the pod another IP at the next opportunity, when it gets a new sandbox, instead of the
+	//     IP it released as SAME_POD_IP_REUSE_WINDOW would
+	envMaxIPAssignmentAgePolicy = "MAX_IP_ASSIGNMENT_AGE_POLICY"
+	ipAssignmentAgeFlag         = "flag"
+	ipAssignmentAgeReassign     = "reassign"
+)
+
+// AgedIPAssignment is an IP assigned to a pod for longer than MAX_IP_ASSIGNMENT_AGE, for introspection
+type AgedIPAssignment struct {
+	datastore.PodIPAssignment
+	Age time.Duration
+}
+
+// IPAssignmentAgeStats describes the IPs assigned to pods for longer than MAX_IP_ASSIGNMENT_AGE, for introspection
+type IPAssignmentAgeStats struct {
+	MaxAge time.Duration
+	Policy string
+	// Flagged is the number of assignments found older than MaxAge, Reassigned the number of their IPs released and
+	// not given back to their pod
+	Flagged    int64
+	Reassigned int64
+	LastCheck  time.Time `json:",omitempty"`
+	// Aged are the assignments older than MaxAge at the last check, the oldest first
+	Aged []AgedIPAssignment
+}
+
+// ipAssignmentAgeState keeps the assignments older than MAX_IP_ASSIGNMENT_AGE found by the last check
+type ipAssignmentAgeState struct {
+	// aged are the aged assignments by IP
+	aged       map[string]datastore.PodIPAssignment
+	flagged    int64
+	reassigned int64
+	lastCheck  time.Time
+	lock       sync.RWMutex
+}
+
+// checkIPAssignmentAges flags the IPs assigned to pods for longer than MAX_IP_ASSIGNMENT_AGE
+func (c *IPAMContext) checkIPAssignmentAges() {
+	if c.maxIPAssignmentAge == noMaxIPAssignmentAge {
+		return
+	}
+	now := time.Now()
+	assignments := c.dataStore.GetPodIPsAssignedBefore(now.Add(-c.maxIPAssignmentAge))
+
+	s := &c.ipAssignmentAge
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	aged := make(map[string]datastore.PodIPAssignment, len(assignments))
+	for _, assignment := range assignments {
+		if previous, ok := s.aged[assignment.IP]; !ok || !previous.AssignedTime.Equal(assignment.AssignedTime) {
+			log.Warnf("IP %s of pod %s, Sandbox %s is assigned since %s, longer than %s %v", assignment.IP,
+				assignment.Pod, assignment.Sandbox, assignment.AssignedTime.Format(time.RFC3339), envMaxIPAssignmentAge,
+				c.maxIPAssignmentAge)
+			ipamdErrInc("ipAssignmentAged")
+			s.flagged++
+		}
+		aged[assignment.IP] = assignment
+	}
+	s.aged = aged
+	s.lastCheck = now
+}
+
+// releaseAgedIP forgets the IP released by a pod, and returns true if it was assigned for longer than
+// MAX_IP_ASSIGNMENT_AGE and MAX_IP_ASSIGNMENT_AGE_POLICY is "reassign", in which case it must not be given back to
+// the pod
+func (c *IPAMContext) releaseAgedIP(ip string) bool {
+	if c.maxIPAssignmentAge == noMaxIPAssignmentAge {
+		return false
+	}
+	s := &c.ipAssignmentAge
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	assignment, ok := s.aged[ip]
+	if !ok {
+		return false
+	}
+	delete(s.aged, ip)
+	if c.maxIPAssignmentAgePolicy != ipAssignmentAgeReassign {
+		return false
+	}
+	log.Infof("Released aged IP %s of pod %s, the pod gets another IP for its next sandbox", ip, assignment.Pod)
+	s.reassigned++
+	return true
+}
+
+// GetIPAssignmentAgeStats returns the IPs assigned to pods for longer than MAX_IP_ASSIGNMENT_AGE at the last check
+func (c *IPAMContext) GetIPAssignmentAgeStats() IPAssignmentAgeStats {
+	s := &c.ipAssignmentAge
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	stats := IPAssignmentAgeStats{
+		MaxAge:     c.maxIPAssignmentAge,
+		Policy:     c.maxIPAssignmentAgePolicy,
+		Flagged:    s.flagged,
+		Reassigned: s.reassigned,
+		LastCheck:  s.lastCheck,
+		Aged:       make([]AgedIPAssignment, 0, len(s.aged)),
+	}
+	now := time.Now()
+	for _, assignment := range s.aged {
+		stats.Aged = append(stats.Aged, AgedIPAssignment{PodIPAssignment: assignment,
+			Age: now.Sub(assignment.AssignedTime)})
+	}
+	sort.Slice(stats.Aged, func(i, j int) bool {
+		return stats.Aged[i].AssignedTime.Before(stats.Aged[j].AssignedTime)
+	})
+	return stats
+}
+
+func getMaxIPAssignmentAge() time.Duration {
+	inputStr, found := os.LookupEnv(envMaxIPAssignmentAge)
+
+	if !found {
+		return noMaxIPAssignmentAge
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envMaxIPAssignmentAge, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, the age of the IP assignments is not checked", envMaxIPAssignmentAge, inputStr)
+	return noMaxIPAssignmentAge
+}
+
+func getMaxIPAssignmentAgePolicy() string {
+	return envsetting.Choice(envMaxIPAssignmentAgePolicy, ipAssignmentAgeFlag,
+		ipAssignmentAgeFlag, ipAssignmentAgeReassign)
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to specify where an audit entry is written each time an IP address is
+	// assigned to a pod or released, one JSON object per line, apart from the logs of ipamd. It is either the path of
+	// a file, which entries are appended to, or "stdout". When it is not set, no audit entry is written.
+	envPodIPAuditLog    = "POD_IP_AUDIT_LOG"
+	podIPAuditLogStdout = "stdout"
+
+	ipAuditAssign  = "assign"
+	ipAuditRelease = "release"
+)
+
+// IPAuditEntry is the audit entry of an IP address assigned to a pod or released
+type IPAuditEntry struct {
+	Time      time.Time
+	Action    string
+	PodUID    string `json:",omitempty"`
+	Pod       string
+	Namespace string
+	Sandbox   string `json:",omitempty"`
+	IP        string
+	ENI       string `json:",omitempty"`
+	// IfName is the additional interface of the pod the IP address is on, empty for its default interface
+	IfName string `json:",omitempty"`
+	// Reason is why the IP address was released
+	Reason string `json:",omitempty"`
+}
+
+// ipAuditLog writes the audit entries of the pod IP addresses
+type ipAuditLog struct {
+	out  io.Writer
+	lock sync.Mutex
+}
+
+// newIPAuditLog opens the destination of the audit entries
+func newIPAuditLog(destination string) (*ipAuditLog, error) {
+	if destination == podIPAuditLogStdout {
+		return &ipAuditLog{out: os.Stdout}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create the directory of audit log %s", destination)
+	}
+	file, err := os.OpenFile(destination, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open audit log %s", destination)
+	}
+	return &ipAuditLog{out: file}, nil
+}
+
+// record writes an audit entry, it does nothing when POD_IP_AUDIT_LOG is not set
+func (a *ipAuditLog) record(entry IPAuditEntry) {
+	if a == nil {
+		return
+	}
+	entry.Time = time.Now()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("Failed to marshal IP audit entry: %v", err)
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, err := a.out.Write(append(line, '\n')); err != nil {
+		log.Errorf("Failed to write IP audit entry %s: %v", line, err)
+		ipamdErrInc("ipAuditLogWrite")
+	}
+}
+
+// auditIP writes the audit entry of an IP address of the pod
+func (c *IPAMContext) auditIP(action string, pod *k8sapi.K8SPodInfo, ip string, ifName string, reason string) {
+	if c.ipAudit == nil {
+		return
+	}
+	c.ipAudit.record(IPAuditEntry{
+		Action:    action,
+		PodUID:    pod.UID,
+		Pod:       pod.Name,
+		Namespace: pod.Namespace,
+		Sandbox:   pod.Sandbox,
+		IP:        ip,
+		ENI:       c.dataStore.GetIPv4AddressENI(ip),
+		IfName:    ifName,
+		Reason:    reason,
+	})
+}
+
+// podUID returns the UID of the pod the datastore knows, for the audit entries of the released IP addresses and to
+// give back the IP to a new sandbox of the pod
+func (c *IPAMContext) podUID(pod *k8sapi.K8SPodInfo) string {
+	if c.ipAudit == nil && c.samePodIPReuseWindow == noSamePodIPReuse {
+		return ""
+	}
+	for _, assigned := range c.dataStore.GetAssignedPods() {
+		if assigned.Name == pod.Name && assigned.Namespace == pod.Namespace &&
+			(assigned.Sandbox == pod.Sandbox || assigned.Sandbox == "") {
+			return assigned.UID
+		}
+	}
+	return ""
+}
+
+func getPodIPAuditLog() string {
+	return os.Getenv(envPodIPAuditLog)
+}
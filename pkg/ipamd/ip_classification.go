@@ -0,0 +1,117 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+)
+
+// The classes of the IPs of the datastore on /v1/ip-classification
+const (
+	// ipClassAssigned is an IP assigned to a pod
+	ipClassAssigned = "assigned"
+	// ipClassWarm is a free IP the next pod may take
+	ipClassWarm = "warm"
+	// ipClassCooling is an IP unassigned less than the cooling period ago, not assigned again until it is over
+	ipClassCooling = "cooling"
+	// ipClassCleanupPending is an IP held until the host side networking of its last pod is removed
+	ipClassCleanupPending = "cleanup-pending"
+	// ipClassReserved is a free IP the pool keeps for RESERVED_IPS, or only because of MINIMUM_IP_TARGET
+	ipClassReserved = "reserved"
+	// ipClassExcluded is the primary IP of an ENI, which is never assigned to pods
+	ipClassExcluded = "excluded"
+)
+
+// ClassifiedIP is an IP of the datastore and its class
+type ClassifiedIP struct {
+	IP    string
+	ENI   string
+	Class string
+	// Reason is why a reserved IP is kept: RESERVED_IPS or MINIMUM_IP_TARGET
+	Reason string `json:",omitempty"`
+}
+
+// IPClassification explains the accounting of the IPs of the datastore, for introspection
+type IPClassification struct {
+	Counts map[string]int
+	IPs    []ClassifiedIP
+}
+
+// GetIPClassification returns the class of each IP of the datastore, by ENI and IP. The reserved IPs are counts, not
+// particular IPs, the last free IPs are classified as reserved.
+func (c *IPAMContext) GetIPClassification() IPClassification {
+	cooling := make(map[string]bool)
+	for _, ip := range c.dataStore.GetCoolingIPs() {
+		cooling[ip.IP] = true
+	}
+
+	classification := IPClassification{Counts: make(map[string]int)}
+	eniInfos := c.dataStore.GetENIInfos()
+	for _, eni := range eniInfos.ENIIPPools {
+		if eni.PrimaryIP != "" {
+			classification.IPs = append(classification.IPs, ClassifiedIP{IP: eni.PrimaryIP, ENI: eni.ID, Class: ipClassExcluded})
+		}
+		for ip, addr := range eni.IPv4Addresses {
+			class := ipClassWarm
+			switch {
+			case addr.Assigned:
+				class = ipClassAssigned
+			case addr.CleanupPending:
+				class = ipClassCleanupPending
+			case cooling[ip]:
+				class = ipClassCooling
+			}
+			classification.IPs = append(classification.IPs, ClassifiedIP{IP: ip, ENI: eni.ID, Class: class})
+		}
+	}
+	sort.Slice(classification.IPs, func(i, j int) bool {
+		if classification.IPs[i].ENI != classification.IPs[j].ENI {
+			return classification.IPs[i].ENI < classification.IPs[j].ENI
+		}
+		return classification.IPs[i].IP < classification.IPs[j].IP
+	})
+	var free []int
+	for i, ip := range classification.IPs {
+		if ip.Class == ipClassWarm {
+			free = append(free, i)
+		}
+	}
+
+	// Classify the last free IPs as reserved, the very last ones for RESERVED_IPS
+	reserved := min(c.reservedIPs, len(free))
+	minimumTargetReserved := min(c.minimumIPTargetReserved(), len(free)-reserved)
+	for n, i := range free[len(free)-reserved-minimumTargetReserved:] {
+		classification.IPs[i].Class = ipClassReserved
+		classification.IPs[i].Reason = envMinimumIPTarget
+		if n >= minimumTargetReserved {
+			classification.IPs[i].Reason = envReservedIPs
+		}
+	}
+	for _, ip := range classification.IPs {
+		classification.Counts[ip.Class]++
+	}
+	return classification
+}
+
+// minimumIPTargetReserved returns the number of free IPs the pool keeps only because of MINIMUM_IP_TARGET, those that
+// would be released beyond the warm IP target without it
+func (c *IPAMContext) minimumIPTargetReserved() int {
+	minimumIPTarget := c.effectiveMinimumIPTarget()
+	if minimumIPTarget == noMinimumIPTarget {
+		return 0
+	}
+	total, assigned := c.dataStore.GetStats()
+	over := max(total-assigned-c.reservedIPs-c.effectiveWarmIPTarget(), 0)
+	return over - max(min(over, total-minimumIPTarget), 0)
+}
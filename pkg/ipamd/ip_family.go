@@ -0,0 +1,149 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do with the AddNetwork requests of pods that ask for an IP
+	// family not enabled on the node. ipamd only assigns IPv4 addresses, so this applies to the pods asking for "ipv6"
+	// or "dual":
+	//   "reject" (default): fail them with an error naming the family asked for and the one enabled
+	//   "ipv4": assign them an IPv4 address, as to the other pods
+	envIPFamilyMismatchPolicy = "IP_FAMILY_MISMATCH_POLICY"
+	ipFamilyMismatchReject    = "reject"
+	ipFamilyMismatchIPv4      = "ipv4"
+
+	ipFamilyIPv4 = "ipv4"
+	ipFamilyIPv6 = "ipv6"
+	ipFamilyDual = "dual"
+
+	// maxIPFamilyMismatches is how many of the recent mismatches are kept
+	maxIPFamilyMismatches = 32
+)
+
+// enabledIPFamilies are the IP families ipamd assigns addresses of
+var enabledIPFamilies = []string{ipFamilyIPv4}
+
+// IPFamilyMismatch is a pod that asked for an IP family not enabled on the node, for introspection
+type IPFamilyMismatch struct {
+	Pod       string
+	Sandbox   string
+	Requested string
+	Time      time.Time
+	// Rejected is true if the request failed, false if the pod got an IPv4 address
+	Rejected bool
+}
+
+// IPFamilyMismatchStats counts the pods that asked for an IP family not enabled on the node, for introspection
+type IPFamilyMismatchStats struct {
+	Policy  string
+	Enabled []string
+	// Mismatches is the number of requests for a family not enabled, Rejected those that failed because of
+	// IP_FAMILY_MISMATCH_POLICY
+	Mismatches int64
+	Rejected   int64
+	// Recent are the most recent mismatches, oldest first
+	Recent []IPFamilyMismatch
+}
+
+// ipFamilyMismatchState keeps the recent requests for an IP family not enabled on the node
+type ipFamilyMismatchState struct {
+	mismatches int64
+	rejected   int64
+	recent     []IPFamilyMismatch
+	lock       sync.RWMutex
+}
+
+func (s *ipFamilyMismatchState) record(mismatch IPFamilyMismatch) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mismatches++
+	if mismatch.Rejected {
+		s.rejected++
+	}
+	s.recent = append(s.recent, mismatch)
+	s.recent = s.recent[keepLast(len(s.recent), maxIPFamilyMismatches):]
+}
+
+// podIPFamily returns the IP family the pod asks for, the one passed by the plugin or else the one of its annotation
+func (c *IPAMContext) podIPFamily(pod *k8sapi.K8SPodInfo, requested string) string {
+	if requested != "" {
+		return strings.ToLower(requested)
+	}
+	podInfo := c.k8sClient.K8SGetPodInfo(pod.Name, pod.Namespace)
+	if podInfo == nil {
+		return ""
+	}
+	return strings.ToLower(podInfo.IPFamily)
+}
+
+// checkIPFamily returns an error naming the family asked for when the pod asks for an IP family not enabled on the
+// node and IP_FAMILY_MISMATCH_POLICY is "reject". An unknown family is always rejected.
+func (c *IPAMContext) checkIPFamily(pod *k8sapi.K8SPodInfo, family string) error {
+	switch family {
+	case "", ipFamilyIPv4:
+		return nil
+	case ipFamilyIPv6, ipFamilyDual:
+	default:
+		c.ipFamilyMismatches.record(IPFamilyMismatch{Pod: pod.Namespace + "/" + pod.Name, Sandbox: pod.Sandbox,
+			Requested: family, Time: time.Now(), Rejected: true})
+		log.Warnf("Rejecting Pod %s, Namespace %s, it asks for the unknown IP family %q", pod.Name, pod.Namespace, family)
+		return errors.Errorf("pod asks for the unknown IP family %q, the valid families are %q, %q and %q",
+			family, ipFamilyIPv4, ipFamilyIPv6, ipFamilyDual)
+	}
+
+	reject := c.ipFamilyMismatchPolicy == ipFamilyMismatchReject
+	c.ipFamilyMismatches.record(IPFamilyMismatch{Pod: pod.Namespace + "/" + pod.Name, Sandbox: pod.Sandbox,
+		Requested: family, Time: time.Now(), Rejected: reject})
+	if !reject {
+		log.Infof("Pod %s, Namespace %s asks for the %s IP family, assigning it an IPv4 address",
+			pod.Name, pod.Namespace, family)
+		return nil
+	}
+	log.Warnf("Rejecting Pod %s, Namespace %s, it asks for the %s IP family and %s is %q",
+		pod.Name, pod.Namespace, family, envIPFamilyMismatchPolicy, ipFamilyMismatchReject)
+	return errors.Errorf("pod asks for the %s IP family, but only %s is enabled on this node; "+
+		"remove the %s annotation of the pod or set %s to %q to assign it an IPv4 address",
+		family, strings.Join(enabledIPFamilies, ", "), k8sapi.PodIPFamilyAnnotation, envIPFamilyMismatchPolicy,
+		ipFamilyMismatchIPv4)
+}
+
+// GetIPFamilyMismatchStats returns the recent requests for an IP family not enabled on the node
+func (c *IPAMContext) GetIPFamilyMismatchStats() IPFamilyMismatchStats {
+	c.ipFamilyMismatches.lock.RLock()
+	defer c.ipFamilyMismatches.lock.RUnlock()
+	return IPFamilyMismatchStats{
+		Policy:     c.ipFamilyMismatchPolicy,
+		Enabled:    enabledIPFamilies,
+		Mismatches: c.ipFamilyMismatches.mismatches,
+		Rejected:   c.ipFamilyMismatches.rejected,
+		Recent:     append([]IPFamilyMismatch{}, c.ipFamilyMismatches.recent...),
+	}
+}
+
+func getIPFamilyMismatchPolicy() string {
+	return envsetting.Choice(envIPFamilyMismatchPolicy, ipFamilyMismatchReject,
+		ipFamilyMismatchReject, ipFamilyMismatchIPv4)
+}
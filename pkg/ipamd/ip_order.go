@@ -0,0 +1,34 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify which of the free IP addresses of the warm pool a pod is assigned:
+	//   "random" (default): any of them, picked at random
+	//   "lowest": the one with the lowest address
+	//   "highest": the one with the highest address
+	// A pod that asked for a specific IP, or for an IP in a subnet, still gets it.
+	envIPAssignmentOrder     = "IP_ASSIGNMENT_ORDER"
+	defaultIPAssignmentOrder = datastore.IPOrderRandom
+)
+
+func getIPAssignmentOrder() string {
+	return envsetting.Choice(envIPAssignmentOrder, defaultIPAssignmentOrder,
+		datastore.IPOrderLowest, datastore.IPOrderHighest, datastore.IPOrderRandom)
+}
@@ -15,8 +15,10 @@ package ipamd
 
 import (
 	"fmt"
+	"hash/fnv"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,12 +32,14 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/apimachinery/pkg/util/sets"
 
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/cri"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
 )
 
 // The package ipamd is a long running daemon which manages a warm pool of available IP addresses.
@@ -55,6 +59,11 @@ const (
 	// during reconciliation after being discovered on the EC2 instance metadata.
 	ipReconcileCooldown = 60 * time.Second
 
+	// maxReconcileFailures is how many reconciles in a row must fail for ipamd to be considered unhealthy, so that a
+	// single transient EC2 or IMDS error does not fail the health check. Reconcile is skipped while the pool is
+	// changing, so the time since the last success alone does not tell that ipamd is stuck.
+	maxReconcileFailures = 3
+
 	// This environment variable is used to specify the desired number of free IPs always available in the "warm pool".
 	// When it is not set, ipamd defaults to use all available IPs per ENI for that instance type.
 	// For example, for a m4.4xlarge node,
@@ -106,6 +115,46 @@ const (
 	// When it is NOT set or set to false, ipamd will use primary interface security group and subnet for Pod network.
 	envCustomNetworkCfg = "AWS_VPC_K8S_CNI_CUSTOM_NETWORK_CFG"
 
+	// This environment variable is used to specify the number of free IP addresses in the node's subnet below which
+	// the subnet is considered to be under pressure. While it is under pressure, ipamd shrinks its effective warm
+	// target to a single IP so that unused addresses are released back to the subnet for other nodes to use.
+	// When it is not set or set to 0, ipamd does not poll the subnet.
+	envSubnetPressureThreshold  = "SUBNET_PRESSURE_THRESHOLD"
+	noSubnetPressureThreshold   = 0
+	subnetPressureCheckInterval = 60 * time.Second
+	subnetPressureWarmIPTarget  = 1
+
+	// This environment variable is used to specify how an AddNetwork request is handled when the datastore has no
+	// free IP address. With "fail-fast", the default, the request fails right away. With "wait", ipamd asks the pool
+	// manager to grow the pool and blocks the request for up to DATASTORE_FULL_WAIT_TIMEOUT seconds until an IP is free.
+	envDatastoreFullPolicy = "DATASTORE_FULL_POLICY"
+	datastoreFullFailFast  = "fail-fast"
+	datastoreFullWait      = "wait"
+
+	// This environment variable is used to specify, in seconds, how long an AddNetwork request waits for a free IP
+	// when DATASTORE_FULL_POLICY is "wait". When it is not set, it defaults to 10 seconds.
+	envDatastoreFullWaitTimeout     = "DATASTORE_FULL_WAIT_TIMEOUT"
+	defaultDatastoreFullWaitTimeout = 10 * time.Second
+	datastoreFullRetryInterval      = 500 * time.Millisecond
+
+	// This environment variable is used to spread the IP pool reconcile of the nodes of a cluster over time. Each node
+	// adds a fixed jitter of up to this percentage of the 60 second reconcile interval, derived from its node name, so
+	// that nodes started together do not call EC2 in lockstep. When it is not set or set to 0, there is no jitter.
+	envReconcileJitterPercent     = "RECONCILE_JITTER_PERCENT"
+	defaultReconcileJitterPercent = 0
+	maxReconcileJitterPercent     = 100
+
+	// This environment variable is used to specify the minimum number of pods that the ENIs must be able to take
+	// without a new ENI. When no ENI has room for at least this many more pods, counting both its free IPs and the IPs
+	// it can still allocate, ipamd attaches a new ENI right away instead of waiting for the ENIs to be full. When it
+	// is not set or set to 0, ENIs are filled before a new one is attached.
+	envMinFreeIPsPerENI = "MIN_FREE_IPS_PER_ENI"
+	noMinFreeIPsPerENI  = 0
+
+	// defaultNearFullHeadroom is the headroom under which /v1/near-full-enis reports an ENI when MIN_FREE_IPS_PER_ENI
+	// is not set
+	defaultNearFullHeadroom = 2
+
 	// eniNoManageTagKey is the tag that may be set on an ENI to indicate ipamd
 	// should not manage it in any form.
 	eniNoManageTagKey = "node.k8s.amazonaws.com/no_manage"
@@ -158,33 +207,230 @@ var (
 		},
 		[]string{"reason"},
 	)
+	rpcRejectedCnt = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "awscni_rpc_rejected_connection_count",
+			Help: "The number of gRPC connections rejected because the peer is not in the allowlist",
+		},
+	)
 	prometheusRegistered = false
 )
 
 // IPAMContext contains node level control information
 type IPAMContext struct {
-	awsClient            awsutils.APIs
-	dataStore            *datastore.DataStore
-	k8sClient            k8sapi.K8SAPIs
-	useCustomNetworking  bool
-	eniConfig            eniconfig.ENIConfig
-	criClient            cri.APIs
-	networkClient        networkutils.NetworkAPIs
-	maxIPsPerENI         int
-	maxENI               int
-	unmanagedENI         int
-	warmENITarget        int
-	warmIPTarget         int
-	minimumIPTarget      int
+	awsClient           awsutils.APIs
+	dataStore           *datastore.DataStore
+	k8sClient           k8sapi.K8SAPIs
+	useCustomNetworking bool
+	eniConfig           eniconfig.ENIConfig
+	criClient           cri.APIs
+	networkClient       networkutils.NetworkAPIs
+	maxIPsPerENI        int
+	maxENI              int
+	unmanagedENI        int
+	warmENITarget       int
+	warmIPTarget        int
+	minimumIPTarget     int
+	minFreeIPsPerENI    int
+	// warmTargetClamps are the warm targets lowered to the capacity of the instance
+	warmTargetClamps []WarmTargetClamp
+	// nodeIPQuota is the maximum number of IPs the node may hold, nodeIPQuotaBlocked the pool increases it prevented
+	nodeIPQuota        int
+	nodeIPQuotaBlocked int64
+	// reservedIPs are free IPs only the pods with a priority of at least reservedIPsMinPriority, or the
+	// reservedIPsPodLabel label, may take
+	reservedIPs            int
+	reservedIPsMinPriority int32
+	reservedIPsPodLabel    string
+	// ipAssignmentOrder is the order the free IPs of the warm pool are assigned to pods in
+	ipAssignmentOrder string
+	// subnetPressureThreshold is the subnet free IP count under which the warm pool is shrunk, 0 if disabled
+	subnetPressureThreshold  int
+	subnetPressure           subnetPressureState
+	datastoreFullPolicy      string
+	datastoreFullWaitTimeout time.Duration
+	// poolGrowthTrigger wakes up the pool manager when an AddNetwork request is waiting for a free IP
+	poolGrowthTrigger         chan struct{}
+	datastoreFullWaiting      int32
+	datastoreFullWaits        int64
+	datastoreFullWaitTimeouts int64
+	// poolGrowthBatchWindow is how long the requests waiting for a free IP are batched, 0 if they are not
+	poolGrowthBatchWindow time.Duration
+	poolGrowthBatch       poolGrowthBatchState
+	// podIPState persists pod IP assignments when PRESERVE_POD_IPS is enabled, nil otherwise
+	podIPState           *podIPStateStore
 	primaryIP            map[string]string
 	lastNodeIPPoolAction time.Time
 	lastDecreaseIPPool   time.Time
+	// reconcileInterval is nodeIPPoolReconcileInterval plus the jitter of this node
+	reconcileInterval time.Duration
+	reconcile         reconcileState
+	// reconcileDescribeConcurrency is the maximum number of ENIs a reconcile describes with EC2 at the same time
+	reconcileDescribeConcurrency int
+	// subnetHints are the subnets pods are waiting for an IP in, podSubnetAllowlist those they may ask for
+	subnetHints        subnetHintState
+	podSubnetAllowlist []string
+	// primaryIPLostPolicy is what to do when the primary IP is missing from the host, primaryENI the last check
+	primaryIPLostPolicy string
+	primaryENI          primaryENIState
+	// duplicateAddPolicy is how an AddNetwork request for a pod that already has an IP is handled
+	duplicateAddPolicy string
+	duplicateAdd       duplicateAddState
+	// addRetryAttempts is how many more times an AddNetwork request tries to assign an IP, waiting addRetryBackoff
+	// before the first retry
+	addRetryAttempts int
+	addRetryBackoff  time.Duration
+	addRetry         addRetryState
+	// orphanedIPPolicy is what to do with the IPs of pods that no longer exist, once orphaned for orphanedIPGracePeriod
+	orphanedIPPolicy      string
+	orphanedIPGracePeriod time.Duration
+	orphanedIPs           orphanedIPState
+	// podTraces are the decision traces of the most recent AddNetwork requests
+	podTraces podTraceState
+	// alternateSubnets are where new ENIs are created when their subnet is full
+	alternateSubnets []string
+	subnetFull       subnetFullState
+	// subnetSelection is how the subnet of a new ENI is chosen
+	subnetSelection      string
+	subnetSelectionStats subnetSelectionState
+	// ipAudit writes an audit entry for each IP assigned to a pod or released, it is nil when POD_IP_AUDIT_LOG is not set
+	ipAudit *ipAuditLog
+	// eniConsistencyGracePeriod is how long a new ENI that EC2 does not list yet is not considered gone
+	eniConsistencyGracePeriod time.Duration
+	eniConsistency            eniConsistencyState
+	// imdsUnavailablePolicy is what to do while the reconcile cannot reach IMDS
+	imdsUnavailablePolicy string
+	imds                  imdsState
+	// eniErrors are the IP provisioning failures of each ENI
+	eniErrors eniErrorState
+	// conntrackCleanupOnDel deletes the connection tracking entries of the IPs DelNetwork requests release
+	conntrackCleanupOnDel bool
+	conntrackCleanup      conntrackCleanupState
+	// eniConfigAZMismatchPolicy is what to do when the ENIConfig subnet is not in the zone of the node
+	eniConfigAZMismatchPolicy string
+	eniConfigAZ               eniConfigAZState
+	// eniDeleteAsync frees unused ENIs in the background, retrying after eniDeleteRetryBackoff
+	eniDeleteAsync        bool
+	eniDeleteRetryBackoff time.Duration
+	eniDeletes            eniDeleteState
+	// attachLimitBackoff is how long ENIs are not attached after the instance ENI limit was exceeded
+	attachLimitBackoff time.Duration
+	attachLimit        attachLimitState
+	// delVerifyEnabled holds the IPs of deleted pods until their host route is removed, checked every
+	// delVerifyInterval
+	delVerifyEnabled  bool
+	delVerifyInterval time.Duration
+	delVerify         delVerifyState
+	// scaleDownShrink switches the pool to shrink-only mode while the node is marked for scale-down
+	scaleDownShrink bool
+	shrinkMode      shrinkModeState
+	// drainAddPolicy is what to do with the pods added while the node is in shrink-only mode
+	drainAddPolicy string
+	drainAdds      drainAddState
+	// namespaceIPQuotas are the maximum numbers of IPs the pods of a namespace may hold on the node
+	namespaceIPQuotas map[string]int
+	namespaceIPQuota  namespaceIPQuotaState
+	// allocationRate is the moving average of the IPs assigned to pods
+	allocationRate allocationRateState
+	// releaseRate is the moving average of the IPs released by pods
+	releaseRate allocationRateState
+	// adaptiveWarmIPTarget sets the warm IP target from the allocation and release rates over
+	// adaptiveWarmIPTargetWindow, between adaptiveWarmIPTargetMin and adaptiveWarmIPTargetMaximum
+	adaptiveWarmIPTargetEnabled bool
+	adaptiveWarmIPTargetWindow  time.Duration
+	adaptiveWarmIPTargetMin     int
+	adaptiveWarmIPTargetMaximum int
+	// activity counts the ADD and DEL requests served
+	activity activityState
+	// cniStats keeps the counts and latencies of the ADD and DEL requests
+	cniStats cniStatsState
+	// reconcileMaxDuration is how long an iteration of the pool manager loop may run before the watchdog cancels it
+	reconcileMaxDuration time.Duration
+	reconcileWatchdog    reconcileWatchdogState
+	// startupProfile keeps how long each phase of the startup took
+	startupProfile startupProfileState
+	// addressFamilies keeps the IPv4 and IPv6 addresses of each ENI at the last reconcile
+	addressFamilies addressFamilyState
+	// efficiency keeps the EC2 calls that allocated IPs, and what they got
+	efficiency efficiencyState
+	// ipFamilyMismatchPolicy is what to do with the pods asking for an IP family not enabled on the node
+	ipFamilyMismatchPolicy string
+	ipFamilyMismatches     ipFamilyMismatchState
+	// branchENICleanupPolicy is what to do with the branch ENIs no pod lists, once orphaned for
+	// branchENICleanupGracePeriod
+	branchENICleanupPolicy      string
+	branchENICleanupGracePeriod time.Duration
+	branchENICleanup            branchENICleanupState
+	// idlePoolShrinkThreshold is the utilization of the IP pool below which, for idlePoolShrinkDuration, the pool is
+	// shrunk
+	idlePoolShrinkThreshold int
+	idlePoolShrinkDuration  time.Duration
+	idleShrink              idleShrinkState
+	// allocIdempotencyPolicy is what to do when a call to allocate IPs on an ENI fails while EC2 may have allocated
+	// them
+	allocIdempotencyPolicy string
+	allocIdempotency       allocIdempotencyState
+	// detachedENIPodPolicy is what to do about the pods of the ENIs detached out-of-band
+	detachedENIPodPolicy string
+	detachedENIPods      detachedENIPodState
+	// maxIPAssignmentAge is the age beyond which the assignment of an IP to a pod is flagged
+	maxIPAssignmentAge       time.Duration
+	maxIPAssignmentAgePolicy string
+	ipAssignmentAge          ipAssignmentAgeState
+	// eniConfigChangePolicy is what to do about the ENIs created while the ENIConfig changed
+	eniConfigChangePolicy string
+	eniConfigChanges      eniConfigChangeState
+	// netlinkRetries counts the netlink operations the CNI plugin retried
+	netlinkRetries netlinkRetryState
+	// legacyENIPolicy is what to do with the ENIs created by an older CNI version
+	legacyENIPolicy string
+	legacyENIs      legacyENIState
+	// delFailures keeps the recent DelNetwork failures
+	delFailures delFailureState
+	// samePodIPReuseWindow is for how long the IP released by a pod is preferred for a new sandbox of the pod
+	samePodIPReuseWindow time.Duration
+	samePodIPReuse       samePodIPReuseState
+	// egressOnly keeps the IPs of the egress-only pods
+	egressOnly egressOnlyState
+	// partialAllocPolicy is what to do when EC2 allocates fewer IPs on an ENI than requested
+	partialAllocPolicy string
+	partialAllocs      partialAllocState
+	// logHostNetworkAdds logs at info level the AddNetwork requests of pods in the host network namespace
+	logHostNetworkAdds bool
+	hostNetwork        hostNetworkState
+	// eniTimelines are the most recent lifecycle events of the ENIs
+	eniTimelines eniTimelineState
+	// attachedENIs are the ENIs attached to the instance as of the last reconcile, for introspection
+	attachedENIs attachedENIsState
 	// reconcileCooldownCache keeps timestamps of the last time an IP address was unassigned from an ENI,
 	// so that we don't reconcile and add it back too quickly if IMDS lags behind reality.
 	reconcileCooldownCache ReconcileCooldownCache
 	terminating            int32 // Flag to warn that the pod is about to shut down.
 }
 
+// subnetPressureState keeps the result of the last subnet free IP poll.
+type subnetPressureState struct {
+	lastCheck    time.Time
+	availableIPs int
+	pressured    bool
+	lock         sync.RWMutex
+}
+
+// reconcileState keeps the outcome of the last reconciles of the IP pool with EC2
+type reconcileState struct {
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	// failures is the number of reconciles that failed since the last success
+	failures int
+	// lastDuration is how long the last reconcile took, lastDescribes how many ENIs it described with EC2 and
+	// lastDescribeDuration how long describing them took
+	lastDuration         time.Duration
+	lastDescribes        int
+	lastDescribeDuration time.Duration
+	lock                 sync.RWMutex
+}
+
 // Keep track of recently freed IPs to avoid reading stale EC2 metadata
 type ReconcileCooldownCache struct {
 	cache map[string]time.Time
@@ -230,6 +476,7 @@ func prometheusRegister() {
 		prometheus.MustRegister(reconcileCnt)
 		prometheus.MustRegister(addIPCnt)
 		prometheus.MustRegister(delIPCnt)
+		prometheus.MustRegister(rpcRejectedCnt)
 		prometheusRegistered = true
 	}
 }
@@ -239,6 +486,7 @@ func prometheusRegister() {
 func New(k8sapiClient k8sapi.K8SAPIs, eniConfig *eniconfig.ENIConfigController) (*IPAMContext, error) {
 	prometheusRegister()
 	c := &IPAMContext{}
+	c.startupProfile.start()
 
 	c.k8sClient = k8sapiClient
 	c.networkClient = networkutils.New()
@@ -251,12 +499,74 @@ func New(k8sapiClient k8sapi.K8SAPIs, eniConfig *eniconfig.ENIConfigController)
 		return nil, errors.Wrap(err, "ipamd: can not initialize with AWS SDK interface")
 	}
 	c.awsClient = client
+	c.startupProfile.endPhase(startupPhaseIMDS)
 
 	c.primaryIP = make(map[string]string)
 	c.reconcileCooldownCache.cache = make(map[string]time.Time)
 	c.warmENITarget = getWarmENITarget()
 	c.warmIPTarget = getWarmIPTarget()
 	c.minimumIPTarget = getMinimumIPTarget()
+	c.adaptiveWarmIPTargetEnabled = adaptiveWarmIPTargetEnabled()
+	c.adaptiveWarmIPTargetWindow = getAdaptiveWarmIPTargetWindow()
+	c.adaptiveWarmIPTargetMin = getAdaptiveWarmIPTargetMin()
+	c.adaptiveWarmIPTargetMaximum = getAdaptiveWarmIPTargetMax()
+	c.minFreeIPsPerENI = getMinFreeIPsPerENI()
+	c.nodeIPQuota = getNodeIPQuota()
+	c.reservedIPs = getReservedIPs()
+	c.reservedIPsMinPriority = getReservedIPsMinPriority()
+	c.reservedIPsPodLabel = getReservedIPsPodLabel()
+	c.ipAssignmentOrder = getIPAssignmentOrder()
+	c.subnetPressureThreshold = getSubnetPressureThreshold()
+	c.datastoreFullPolicy = getDatastoreFullPolicy()
+	c.datastoreFullWaitTimeout = getDatastoreFullWaitTimeout()
+	c.poolGrowthTrigger = make(chan struct{}, 1)
+	c.poolGrowthBatchWindow = getPoolGrowthBatchWindow()
+	c.reconcileInterval = getReconcileInterval()
+	c.reconcileDescribeConcurrency = getReconcileDescribeConcurrency()
+	c.primaryIPLostPolicy = getPrimaryIPLostPolicy()
+	c.duplicateAddPolicy = getDuplicateAddPolicy()
+	c.addRetryAttempts = getAddRetryAttempts()
+	c.addRetryBackoff = getAddRetryBackoff()
+	c.orphanedIPPolicy = getOrphanedIPPolicy()
+	c.orphanedIPGracePeriod = getOrphanedIPGracePeriod()
+	c.alternateSubnets = getAlternateSubnets()
+	c.podSubnetAllowlist = getPodSubnetAllowlist()
+	c.subnetSelection = getSubnetSelection()
+	c.eniConsistencyGracePeriod = getENIConsistencyGracePeriod()
+	c.namespaceIPQuotas = getNamespaceIPQuotas()
+	c.attachLimitBackoff = getAttachLimitBackoff()
+	c.eniDeleteAsync = eniDeleteAsyncEnabled()
+	c.eniConfigAZMismatchPolicy = getENIConfigAZMismatchPolicy()
+	c.conntrackCleanupOnDel = conntrackCleanupOnDelEnabled()
+	c.imdsUnavailablePolicy = getIMDSUnavailablePolicy()
+	c.eniDeleteRetryBackoff = eniDeleteRetryBackoff
+	c.delVerifyEnabled = verifyDelCleanupEnabled()
+	c.delVerifyInterval = delVerifyInterval
+	c.scaleDownShrink = scaleDownShrinkEnabled()
+	c.drainAddPolicy = getDrainAddPolicy()
+	c.legacyENIPolicy = getLegacyENIPolicy()
+	c.samePodIPReuseWindow = getSamePodIPReuseWindow()
+	c.partialAllocPolicy = getPartialAllocPolicy()
+	c.reconcileMaxDuration = getReconcileMaxDuration()
+	c.ipFamilyMismatchPolicy = getIPFamilyMismatchPolicy()
+	c.branchENICleanupPolicy = getBranchENICleanupPolicy()
+	c.branchENICleanupGracePeriod = getBranchENICleanupGracePeriod()
+	c.idlePoolShrinkThreshold = getIdlePoolShrinkThreshold()
+	c.idlePoolShrinkDuration = getIdlePoolShrinkDuration()
+	c.allocIdempotencyPolicy = getAllocIdempotencyPolicy()
+	c.detachedENIPodPolicy = getDetachedENIPodPolicy()
+	c.maxIPAssignmentAge = getMaxIPAssignmentAge()
+	c.maxIPAssignmentAgePolicy = getMaxIPAssignmentAgePolicy()
+	c.eniConfigChangePolicy = getENIConfigChangePolicy()
+	c.logHostNetworkAdds = logHostNetworkAddsEnabled()
+	if preservePodIPs() {
+		c.podIPState = newPodIPStateStore(getPodIPStateDir(), getPodIPStateRetention())
+	}
+	if destination := getPodIPAuditLog(); destination != "" {
+		if c.ipAudit, err = newIPAuditLog(destination); err != nil {
+			log.Errorf("Not writing IP audit entries: %v", err)
+		}
+	}
 	c.useCustomNetworking = UseCustomNetworkCfg()
 
 	err = c.nodeInit()
@@ -273,12 +583,15 @@ func (c *IPAMContext) nodeInit() error {
 
 	log.Debugf("Start node init")
 
-	allENIs, err := c.awsClient.GetAttachedENIs()
+	allENIs, err := c.awsClient.GetAttachedENIs(c.poolManagerContext())
 	if err != nil {
 		log.Error("Failed to retrieve ENI info")
 		return errors.New("ipamd init: failed to retrieve attached ENIs info")
 	}
+	c.attachedENIs.set(allENIs)
 	enis, numUnmanaged := filterUnmanagedENIs(allENIs)
+	enis, numLegacy := c.filterLegacyENIs(enis)
+	numUnmanaged += numLegacy
 	nodeMaxENI, err := c.getMaxENI()
 	if err != nil {
 		log.Error("Failed to get ENI limit")
@@ -292,6 +605,8 @@ func (c *IPAMContext) nodeInit() error {
 		return err
 	}
 	c.updateIPStats(numUnmanaged)
+	c.clampWarmTargets()
+	c.startupProfile.endPhase(startupPhaseENIDiscovery)
 
 	var pbVPCcidrs []string
 	vpcCIDRs := c.awsClient.GetVPCIPv4CIDRs()
@@ -312,8 +627,12 @@ func (c *IPAMContext) nodeInit() error {
 		log.Error("Failed to set up host network", err)
 		return errors.Wrap(err, "ipamd init: failed to set up host network")
 	}
+	c.startupProfile.endPhase(startupPhaseHostNetwork)
 
 	c.dataStore = datastore.NewDataStore()
+	c.dataStore.SetReservedIPs(c.reservedIPs)
+	c.dataStore.SetIPAssignmentOrder(c.ipAssignmentOrder)
+	c.dataStore.SetHintSubnets(c.podSubnetAllowlist)
 	for _, eni := range enis {
 		log.Debugf("Discovered ENI %s, trying to set it up", eni.ENIID)
 		// Retry ENI sync
@@ -341,6 +660,8 @@ func (c *IPAMContext) nodeInit() error {
 			time.Sleep(eniAttachTime)
 		}
 	}
+	c.startupProfile.endPhase(startupPhaseENISetup)
+
 	localPods, err := c.getLocalPodsWithRetry()
 	if err != nil {
 		log.Warnf("During ipamd init, failed to get Pod information from Kubernetes API Server %v", err)
@@ -356,6 +677,7 @@ func (c *IPAMContext) nodeInit() error {
 		return nil
 	}
 
+	additionalInterfaces := getAdditionalInterfaces()
 	for _, ip := range localPods {
 		if ip.Sandbox == "" {
 			log.Infof("Skipping Pod %s, Namespace %s, due to no matching sandbox", ip.Name, ip.Namespace)
@@ -371,6 +693,7 @@ func (c *IPAMContext) nodeInit() error {
 			ipamdErrInc("nodeInitAssignPodIPv4AddressFailed")
 			log.Warnf("During ipamd init, failed to use pod IP %s returned from Kubernetes API Server %v", ip.IP, err)
 		}
+		c.recoverPodInterfaces(ip, additionalInterfaces)
 
 		// Update ip rules in case there is a change in VPC CIDRs, AWS_VPC_K8S_CNI_EXTERNALSNAT setting
 		srcIPNet := net.IPNet{IP: net.ParseIP(ip.IP), Mask: net.IPv4Mask(255, 255, 255, 255)}
@@ -380,11 +703,20 @@ func (c *IPAMContext) nodeInit() error {
 			log.Errorf("UpdateRuleListBySrc in nodeInit() failed for IP %s: %v", ip.IP, err)
 		}
 	}
+	// Restore pod IP assignments persisted before a reboot, now that the running pods have their IPs back
+	c.restorePodIPState()
+	c.sweepPodIPState(localPods)
+	c.restoreEgressOnlyPods()
+	// The data store now matches the attached ENIs, which is what a reconcile would have done
+	c.recordReconcile(nil)
+	c.startupProfile.endPhase(startupPhaseInitialReconcile)
+
 	// For a new node, attach IPs
 	increasedPool, err := c.tryAssignIPs()
 	if err == nil && increasedPool {
 		c.updateLastNodeIPPoolAction()
 	}
+	c.startupProfile.endPhase(startupPhaseInitialPool)
 	return err
 }
 
@@ -457,16 +789,71 @@ func (c *IPAMContext) getLocalPodsWithRetry() ([]*k8sapi.K8SPodInfo, error) {
 
 // StartNodeIPPoolManager monitors the IP pool, add or del them when it is required.
 func (c *IPAMContext) StartNodeIPPoolManager() {
+	if c.reconcileMaxDuration != noReconcileMaxDuration {
+		go c.runReconcileWatchdog()
+	}
+	c.runNodeIPPoolManager()
+}
+
+// runNodeIPPoolManager runs the pool manager loop. When the reconcile watchdog cancels an iteration, the rest of its
+// steps are skipped.
+func (c *IPAMContext) runNodeIPPoolManager() {
 	sleepDuration := ipPoolMonitorInterval / 2
 	for {
-		time.Sleep(sleepDuration)
-		c.updateIPPoolIfRequired()
-		time.Sleep(sleepDuration)
-		c.nodeIPPoolReconcile(nodeIPPoolReconcileInterval)
+		select {
+		case <-time.After(sleepDuration):
+		case <-c.poolGrowthTrigger:
+			log.Debug("Pool manager woken up by an AddNetwork request waiting for a free IP")
+		}
+		ctx := c.reconcileWatchdog.begin()
+		steps := []struct {
+			name string
+			run  func()
+		}{
+			{"checkShrinkMode", c.checkShrinkModeIfDue},
+			{"checkIdlePool", c.checkIdlePool},
+			{"updateIPPool", c.updateIPPoolIfRequired},
+			{"sleep", func() {
+				select {
+				case <-time.After(sleepDuration):
+				case <-ctx.Done():
+				}
+			}},
+			{"reconcile", func() { c.nodeIPPoolReconcile(c.reconcileInterval) }},
+			{"sweepPodIPState", c.sweepPodIPStateIfDue},
+			{"checkOrphanedIPs", c.checkOrphanedIPsIfDue},
+			{"checkBranchENIs", c.checkBranchENIsIfDue},
+			{"recheckDelVerifyFailures", c.recheckDelVerifyFailures},
+			{"checkIPAssignmentAges", c.checkIPAssignmentAges},
+		}
+		for _, step := range steps {
+			if ctx.Err() != nil {
+				break
+			}
+			c.reconcileWatchdog.enterStep(step.name)
+			step.run()
+		}
+		c.reconcileWatchdog.end()
+	}
+}
+
+// triggerPoolGrowth wakes up the pool manager without waiting for the next pool check
+func (c *IPAMContext) triggerPoolGrowth() {
+	select {
+	case c.poolGrowthTrigger <- struct{}{}:
+	default:
 	}
 }
 
 func (c *IPAMContext) updateIPPoolIfRequired() {
+	c.checkSubnetPressure(subnetPressureCheckInterval)
+	c.increaseSubnetHintPools()
+
+	if c.imdsReadOnly() {
+		log.Debugf("Not changing the IP pool, the instance metadata service cannot be reached")
+		return
+	}
+	c.growPoolForBatches()
 	if c.nodeIPPoolTooLow() {
 		c.increaseIPPool()
 	} else if c.nodeIPPoolTooHigh() {
@@ -508,18 +895,32 @@ func (c *IPAMContext) tryFreeENI() {
 		return
 	}
 
-	eni := c.dataStore.RemoveUnusedENIFromStore(c.warmIPTarget, c.minimumIPTarget)
+	if c.requiredForENIHeadroom() {
+		log.Debugf("Not freeing an ENI, it is required for MIN_FREE_IPS_PER_ENI: %d", c.minFreeIPsPerENI)
+		return
+	}
+
+	eni := c.dataStore.RemoveUnusedENIFromStore(c.effectiveWarmIPTarget(), c.effectiveMinimumIPTarget())
 	if eni == "" {
 		return
 	}
 
+	c.eniTimelines.record(eni, eniEventRemoved, "removed from the datastore as unused")
+	c.forgetPendingENI(eni)
+	c.forgetENIProvisionErrors(eni)
+	if c.eniDeleteAsync {
+		c.enqueueENIDelete(eni)
+		return
+	}
 	log.Debugf("Start freeing ENI %s", eni)
-	err := c.awsClient.FreeENI(eni)
+	err := c.awsClient.FreeENI(c.poolManagerContext(), eni)
 	if err != nil {
 		ipamdErrInc("decreaseIPPoolFreeENIFailed")
 		log.Errorf("Failed to free ENI %s, err: %v", eni, err)
+		c.eniTimelines.record(eni, eniEventError, "failed to free: %v", err)
 		return
 	}
+	c.eniTimelines.record(eni, eniEventFreed, "detached and deleted")
 }
 
 // tryUnassignIPsFromAll determines if there are IPs to free when we have extra IPs beyond the target and warmIPTargetDefined
@@ -554,10 +955,12 @@ func (c *IPAMContext) tryUnassignIPsFromAll() {
 			}
 
 			// Deallocate IPs from the instance if they aren't used by pods.
-			if err := c.awsClient.DeallocIPAddresses(eniID, deletedIPs); err != nil {
+			if err := c.awsClient.DeallocIPAddresses(c.poolManagerContext(), eniID, deletedIPs); err != nil {
 				log.Warnf("Failed to decrease IP pool by removing IPs %v from ENI %s: %s", deletedIPs, eniID, err)
+				c.eniTimelines.record(eniID, eniEventError, "failed to release IPs %v: %v", deletedIPs, err)
 			} else {
 				log.Debugf("Successfully decreased IP pool by removing IPs %v from ENI %s", deletedIPs, eniID)
+				c.eniTimelines.record(eniID, eniEventIPsReleased, "released %d IPs %v", len(deletedIPs), deletedIPs)
 			}
 
 			// Track the last time we unassigned IPs from an ENI. We won't reconcile any IPs in this cache
@@ -610,8 +1013,9 @@ func (c *IPAMContext) increaseIPPool() {
 	ipamdActionsInprogress.WithLabelValues("increaseIPPool").Add(float64(1))
 	defer ipamdActionsInprogress.WithLabelValues("increaseIPPool").Sub(float64(1))
 
+	headroomTooLow := c.eniHeadroomTooLow()
 	short, _, warmIPTargetDefined := c.ipTargetState()
-	if warmIPTargetDefined && short == 0 {
+	if warmIPTargetDefined && short == 0 && !headroomTooLow {
 		log.Debugf("Skipping increase IP pool, warm IP target reached")
 		return
 	}
@@ -621,6 +1025,19 @@ func (c *IPAMContext) increaseIPPool() {
 		return
 	}
 
+	if c.checkNodeIPQuota() != nil {
+		return
+	}
+
+	// Adding IPs to the existing ENIs does not give them more room, only a new ENI does
+	if headroomTooLow {
+		log.Infof("No ENI has room for %d more pods, allocating a new ENI", c.minFreeIPsPerENI)
+		if err := c.tryAllocateENI(); err == nil {
+			c.updateLastNodeIPPoolAction()
+		}
+		return
+	}
+
 	// Try to add more IPs to existing ENIs first.
 	increasedPool, err := c.tryAssignIPs()
 	if err != nil {
@@ -648,11 +1065,19 @@ func (c *IPAMContext) updateLastNodeIPPoolAction() {
 }
 
 func (c *IPAMContext) tryAllocateENI() error {
+	if c.subnetFull.waiting() {
+		log.Debugf("Skipping ENI allocation, the subnets were full less than %v ago", subnetFullRetryInterval)
+		return errSubnetsFull
+	}
+
 	var securityGroups []*string
 	var subnet string
+	// eniCfg is the snapshot of the ENIConfig the ENI is created with
+	var eniCfg *v1alpha1.ENIConfigSpec
 
 	if c.useCustomNetworking {
-		eniCfg, err := c.eniConfig.MyENIConfig()
+		var err error
+		eniCfg, err = c.eniConfig.MyENIConfig()
 
 		if err != nil {
 			log.Errorf("Failed to get pod ENI config")
@@ -665,32 +1090,75 @@ func (c *IPAMContext) tryAllocateENI() error {
 			securityGroups = append(securityGroups, aws.String(sgID))
 		}
 		subnet = eniCfg.Subnet
+		if err := c.checkENIConfigAZ(subnet); err != nil {
+			return err
+		}
 	}
 
-	eni, err := c.awsClient.AllocENI(c.useCustomNetworking, securityGroups, subnet)
+	ipsToAllocate := c.maxIPsPerENI
+	short, _, warmIPTargetDefined := c.ipTargetState()
+	if warmIPTargetDefined {
+		// short is 0 when the ENI is only allocated for MIN_FREE_IPS_PER_ENI
+		ipsToAllocate = max(short, 1)
+	}
+	ipsToAllocate = c.capToNodeIPQuota(ipsToAllocate)
+	subnet = c.selectSubnet(subnet)
+	err := c.allocateENI(eniCfg, securityGroups, subnet, ipsToAllocate)
+	if awsutils.IsSubnetFullError(err) {
+		if subnet == "" {
+			subnet = c.primarySubnet()
+		}
+		return c.allocateENIInAlternateSubnets(eniCfg, securityGroups, subnet, ipsToAllocate)
+	}
+	if err == nil {
+		c.subnetFull.clearAllFull()
+	}
+	return err
+}
+
+// allocateENI creates and attaches an ENI with ipsToAllocate secondary IPs, and adds it to the datastore. With custom
+// networking, eniCfg is the ENIConfig the security groups and subnet come from, it is compared with the current one
+// once the ENI is set up.
+func (c *IPAMContext) allocateENI(eniCfg *v1alpha1.ENIConfigSpec, securityGroups []*string, subnet string, ipsToAllocate int) error {
+	if c.attachLimit.waiting() {
+		log.Debugf("Skipping ENI allocation, the instance ENI limit was exceeded recently")
+		return errAttachLimitBackoff
+	}
+	eni, err := c.awsClient.AllocENI(c.poolManagerContext(), eniCfg != nil, securityGroups, subnet)
 	if err != nil {
 		log.Errorf("Failed to increase pool size due to not able to allocate ENI %v", err)
 		ipamdErrInc("increaseIPPoolAllocENI")
+		if awsutils.IsAttachmentLimitError(err) {
+			c.handleAttachLimitExceeded(err)
+		}
 		return err
 	}
-
-	ipsToAllocate := c.maxIPsPerENI
-	short, _, warmIPTargetDefined := c.ipTargetState()
-	if warmIPTargetDefined {
-		ipsToAllocate = short
+	if eniCfg != nil {
+		defer c.checkENIConfigChange(eni, eniCfg)
+	}
+	c.attachLimit.clear()
+	c.efficiency.recordENI()
+	if subnet == "" {
+		c.eniTimelines.record(eni, eniEventCreated, "created and attached")
+	} else {
+		c.eniTimelines.record(eni, eniEventCreated, "created in subnet %s and attached", subnet)
 	}
 
-	err = c.awsClient.AllocIPAddresses(eni, ipsToAllocate)
+	allocatedIPs, err := c.allocIPAddresses(eni, ipsToAllocate)
 	if err != nil {
 		log.Warnf("Failed to allocate %d IP addresses on an ENI: %v", ipsToAllocate, err)
 		// Continue to process the allocated IP addresses
 		ipamdErrInc("increaseIPPoolAllocIPAddressesFailed")
+		c.eniTimelines.record(eni, eniEventError, "failed to allocate %d IPs: %v", ipsToAllocate, err)
+		c.recordENIProvisionError(eni, err)
 	}
 
 	eniMetadata, err := c.waitENIAttached(eni)
 	if err != nil {
 		ipamdErrInc("increaseIPPoolwaitENIAttachedFailed")
 		log.Errorf("Failed to increase pool size: Unable to discover attached ENI from metadata service %v", err)
+		c.eniTimelines.record(eni, eniEventError, "not found in the instance metadata: %v", err)
+		c.recordENIProvisionError(eni, err)
 		return err
 	}
 
@@ -698,8 +1166,12 @@ func (c *IPAMContext) tryAllocateENI() error {
 	if err != nil {
 		ipamdErrInc("increaseIPPoolsetupENIFailed")
 		log.Errorf("Failed to increase pool size: %v", err)
+		c.eniTimelines.record(eni, eniEventError, "failed to set up: %v", err)
+		c.recordENIProvisionError(eni, err)
 		return err
 	}
+	// The instance metadata may not list all the IPs just allocated yet
+	c.addAllocatedIPsToDataStore(eni, allocatedIPs)
 	return nil
 }
 
@@ -715,24 +1187,33 @@ func (c *IPAMContext) tryAssignIPs() (increasedPool bool, err error) {
 	eni := c.dataStore.GetENINeedsIP(c.maxIPsPerENI, c.useCustomNetworking)
 	if eni != nil && len(eni.IPv4Addresses) < c.maxIPsPerENI {
 		currentNumberOfAllocatedIPs := len(eni.IPv4Addresses)
-		// Try to allocate all available IPs for this ENI
-		err = c.awsClient.AllocIPAddresses(eni.ID, c.maxIPsPerENI-currentNumberOfAllocatedIPs)
+		// Try to allocate all available IPs for this ENI, within NODE_IP_QUOTA
+		allocatedIPs, err := c.allocIPAddresses(eni.ID, c.capToNodeIPQuota(c.maxIPsPerENI-currentNumberOfAllocatedIPs))
 		if err != nil {
 			log.Warnf("failed to allocate all available IP addresses on ENI %s, err: %v", eni.ID, err)
 			// Try to just get one more IP
-			err = c.awsClient.AllocIPAddresses(eni.ID, 1)
+			allocatedIPs, err = c.allocIPAddresses(eni.ID, 1)
 			if err != nil {
+				if awsutils.IsSubnetFullError(err) {
+					c.subnetFull.record(eni.SubnetID, subnetFullAssignIPs, eni.ID)
+				}
 				ipamdErrInc("increaseIPPoolAllocIPAddressesFailed")
+				c.eniTimelines.record(eni.ID, eniEventError, "failed to allocate IPs: %v", err)
+				c.recordENIProvisionError(eni.ID, err)
 				return false, errors.Wrap(err, fmt.Sprintf("failed to allocate one IP addresses on ENI %s, err: %v", eni.ID, err))
 			}
 		}
+		c.addAllocatedIPsToDataStore(eni.ID, allocatedIPs)
 
 		ec2Addrs, _, err := c.getENIaddresses(eni.ID)
 		if err != nil {
 			ipamdErrInc("increaseIPPoolGetENIaddressesFailed")
+			c.recordENIProvisionError(eni.ID, err)
 			return true, errors.Wrap(err, "failed to get ENI IP addresses during IP allocation")
 		}
 		c.addENIaddressesToDataStore(ec2Addrs, eni.ID)
+		c.recordENIProvisioned(eni.ID)
+		c.eniTimelines.record(eni.ID, eniEventIPsAllocated, "allocated IPs, %d secondary IPs in total", len(ec2Addrs)-1)
 		return true, nil
 	}
 	return false, nil
@@ -748,6 +1229,18 @@ func (c *IPAMContext) setupENI(eni string, eniMetadata awsutils.ENIMetadata) err
 	if err != nil && err.Error() != datastore.DuplicatedENIError {
 		return errors.Wrapf(err, "failed to add ENI %s to data store", eni)
 	}
+	if err = c.dataStore.SetENIDescription(eni, eniMetadata.Description); err != nil {
+		return errors.Wrapf(err, "failed to set description of ENI %s in data store", eni)
+	}
+	if err = c.dataStore.SetENISubnet(eni, eniMetadata.SubnetID); err != nil {
+		return errors.Wrapf(err, "failed to set subnet of ENI %s in data store", eni)
+	}
+	if err = c.dataStore.SetENIPrimaryIP(eni, eniMetadata.PrimaryIPv4Address()); err != nil {
+		return errors.Wrapf(err, "failed to set primary IP of ENI %s in data store", eni)
+	}
+	if err = c.dataStore.SetENITags(eni, eniMetadata.Tags); err != nil {
+		return errors.Wrapf(err, "failed to set tags of ENI %s in data store", eni)
+	}
 
 	// For secondary ENIs, set up the network
 	if eni != c.awsClient.GetPrimaryENI() {
@@ -761,6 +1254,8 @@ func (c *IPAMContext) setupENI(eni string, eniMetadata awsutils.ENIMetadata) err
 	}
 
 	c.primaryIP[eni] = c.addENIaddressesToDataStore(eniMetadata.IPv4Addresses, eni)
+	c.eniTimelines.record(eni, eniEventAdded, "added to the datastore as device %d with %d secondary IPs",
+		eniMetadata.DeviceNumber, max(len(eniMetadata.IPv4Addresses)-1, 0))
 	return nil
 }
 
@@ -784,7 +1279,7 @@ func (c *IPAMContext) addENIaddressesToDataStore(ec2Addrs []*ec2.NetworkInterfac
 
 // returns all addresses on ENI, the primary address on ENI, error
 func (c *IPAMContext) getENIaddresses(eni string) ([]*ec2.NetworkInterfacePrivateIpAddress, string, error) {
-	ec2Addrs, _, _, err := c.awsClient.DescribeENI(eni)
+	ec2Addrs, _, _, err := c.awsClient.DescribeENI(c.poolManagerContext(), eni)
 	if err != nil {
 		return nil, "", errors.Wrapf(err, "failed to find ENI addresses for ENI %s", eni)
 	}
@@ -802,7 +1297,7 @@ func (c *IPAMContext) waitENIAttached(eni string) (awsutils.ENIMetadata, error)
 	// Wait until the ENI shows up in the instance metadata service
 	retry := 0
 	for {
-		enis, err := c.awsClient.GetAttachedENIs()
+		enis, err := c.awsClient.GetAttachedENIs(c.poolManagerContext())
 		if err != nil {
 			log.Warnf("Failed to increase pool, error trying to discover attached ENIs: %v ", err)
 		} else {
@@ -871,6 +1366,13 @@ func logPoolStats(total, used, maxAddrsPerENI int) {
 
 // nodeIPPoolTooLow returns true if IP pool is below low threshold
 func (c *IPAMContext) nodeIPPoolTooLow() bool {
+	if c.inShrinkMode() {
+		return false
+	}
+	if c.eniHeadroomTooLow() {
+		return true
+	}
+
 	short, _, warmIPTargetDefined := c.ipTargetState()
 	if warmIPTargetDefined {
 		return short > 0
@@ -879,8 +1381,9 @@ func (c *IPAMContext) nodeIPPoolTooLow() bool {
 	total, used := c.dataStore.GetStats()
 	logPoolStats(total, used, c.maxIPsPerENI)
 
-	available := total - used
-	poolTooLow := available < c.maxIPsPerENI*c.warmENITarget || (c.warmENITarget == 0 && available == 0)
+	// The reserved IPs are kept on top of the warm ENI
+	available := total - used - c.reservedIPs
+	poolTooLow := available < c.maxIPsPerENI*c.warmENITarget || (c.warmENITarget == 0 && available <= 0)
 	if poolTooLow {
 		log.Tracef("IP pool is too low: available (%d) < ENI target (%d) * addrsPerENI (%d)", available, c.warmENITarget, c.maxIPsPerENI)
 	} else {
@@ -889,6 +1392,54 @@ func (c *IPAMContext) nodeIPPoolTooLow() bool {
 	return poolTooLow
 }
 
+// eniHeadroom returns, for each ENI pods can use, how many more pods it can take, counting both its free IPs and
+// the IPs it can still allocate
+func (c *IPAMContext) eniHeadroom() map[string]int {
+	headroom := make(map[string]int)
+	for _, eni := range c.dataStore.GetENIInfos().ENIIPPools {
+		if eni.IsPrimary && c.useCustomNetworking {
+			continue
+		}
+		headroom[eni.ID] = max(c.maxIPsPerENI-eni.AssignedIPv4Addresses, 0)
+	}
+	return headroom
+}
+
+// eniHeadroomTooLow returns true if MIN_FREE_IPS_PER_ENI is set, no ENI has room for that many more pods, and
+// another ENI can be attached
+func (c *IPAMContext) eniHeadroomTooLow() bool {
+	if c.minFreeIPsPerENI == noMinFreeIPsPerENI || c.dataStore.GetENIs() >= c.maxENI-c.unmanagedENI {
+		return false
+	}
+	for _, headroom := range c.eniHeadroom() {
+		if headroom >= c.minFreeIPsPerENI {
+			return false
+		}
+	}
+	log.Tracef("ENI headroom is too low: no ENI has room for %d more pods", c.minFreeIPsPerENI)
+	return true
+}
+
+// requiredForENIHeadroom returns true if freeing an unused ENI could leave no ENI with room for MIN_FREE_IPS_PER_ENI
+// more pods, that is when no ENI in use has that room and at most one unused ENI has
+func (c *IPAMContext) requiredForENIHeadroom() bool {
+	if c.minFreeIPsPerENI == noMinFreeIPsPerENI || c.inShrinkMode() {
+		return false
+	}
+	unusedWithHeadroom := 0
+	eniInfos := c.dataStore.GetENIInfos()
+	for eniID, headroom := range c.eniHeadroom() {
+		if headroom < c.minFreeIPsPerENI {
+			continue
+		}
+		if eniInfos.ENIIPPools[eniID].AssignedIPv4Addresses > 0 {
+			return false
+		}
+		unusedWithHeadroom++
+	}
+	return unusedWithHeadroom <= 1
+}
+
 // nodeIPPoolTooHigh returns true if IP pool is above high threshold
 func (c *IPAMContext) nodeIPPoolTooHigh() bool {
 	_, over, warmIPTargetDefined := c.ipTargetState()
@@ -911,7 +1462,7 @@ func (c *IPAMContext) shouldRemoveExtraENIs() bool {
 	total, used := c.dataStore.GetStats()
 	logPoolStats(total, used, c.maxIPsPerENI)
 
-	available := total - used
+	available := total - used - c.reservedIPs
 	// We need the +1 to make sure we are not going below the WARM_ENI_TARGET.
 	shouldRemoveExtra := available >= (c.warmENITarget+1)*c.maxIPsPerENI
 	if shouldRemoveExtra {
@@ -939,17 +1490,33 @@ func (c *IPAMContext) nodeIPPoolReconcile(interval time.Duration) {
 	}
 
 	log.Debug("Reconciling ENI/IP pool info...")
-	allENIs, err := c.awsClient.GetAttachedENIs()
+	allENIs, err := c.awsClient.GetAttachedENIs(c.poolManagerContext())
+	c.recordIMDSResult(err)
 	if err != nil {
 		log.Errorf("IP pool reconcile: Failed to get attached ENI info: %v", err.Error())
 		ipamdErrInc("reconcileFailedGetENIs")
+		c.recordReconcile(errors.Wrap(err, "failed to get attached ENIs"))
+		c.recordReconcileDuration(time.Since(curTime))
 		return
 	}
+	c.attachedENIs.set(allENIs)
+	c.checkPrimaryIP(allENIs)
+	c.branchENICleanup.setTrunkENI(allENIs)
 	attachedENIs, numUnmanaged := filterUnmanagedENIs(allENIs)
+	attachedENIs, numLegacy := c.filterLegacyENIs(attachedENIs)
+	numUnmanaged += numLegacy
 	c.updateIPStats(numUnmanaged)
 	c.unmanagedENI = numUnmanaged
 
+	// Describe the ENIs whose IPs must be verified with EC2 before the data store is updated, so that the calls can
+	// run in parallel
+	describeStart := time.Now()
+	described := c.describeENIs(c.enisToDescribe(attachedENIs))
+	c.recordReconcileDescribes(len(described), time.Since(describeStart))
+
 	curENIs := c.dataStore.GetENIInfos()
+	// reconcileErr is the last error, the reconcile carries on with the other ENIs and IPs
+	var reconcileErr error
 
 	// Mark phase
 	for _, attachedENI := range attachedENIs {
@@ -957,19 +1524,29 @@ func (c *IPAMContext) nodeIPPoolReconcile(interval time.Duration) {
 		if err == nil {
 			// If the attached ENI is in the data store
 			log.Debugf("Reconcile existing ENI %s IP pool", attachedENI.ENIID)
+			c.confirmENI(attachedENI.ENIID, eniMissingFromMetadata)
 			// Reconcile IP pool
-			c.eniIPPoolReconcile(eniIPPool, attachedENI, attachedENI.ENIID)
+			if err := c.eniIPPoolReconcile(eniIPPool, attachedENI, attachedENI.ENIID, described); err != nil {
+				reconcileErr = err
+			}
 			// Mark action, remove this ENI from curENIs list
 			delete(curENIs.ENIIPPools, attachedENI.ENIID)
 			continue
 		}
 
+		if c.eniDeleteQueued(attachedENI.ENIID) {
+			log.Debugf("Reconcile: skipping ENI %s, it is being freed in the background", attachedENI.ENIID)
+			continue
+		}
+
 		// Add new ENI
 		log.Debugf("Reconcile and add a new ENI %s", attachedENI)
 		err = c.setupENI(attachedENI.ENIID, attachedENI)
 		if err != nil {
 			log.Errorf("IP pool reconcile: Failed to set up ENI %s network: %v", attachedENI.ENIID, err)
 			ipamdErrInc("eniReconcileAdd")
+			c.recordENIProvisionError(attachedENI.ENIID, err)
+			reconcileErr = errors.Wrapf(err, "failed to set up ENI %s", attachedENI.ENIID)
 			// Continue if having trouble with ONLY 1 ENI, instead of bailout here?
 			continue
 		}
@@ -978,22 +1555,73 @@ func (c *IPAMContext) nodeIPPoolReconcile(interval time.Duration) {
 
 	// Sweep phase: since the marked ENI have been removed, the remaining ones needs to be sweeped
 	for eni := range curENIs.ENIIPPools {
+		if c.eniPendingConfirmation(eni, eniMissingFromMetadata) {
+			continue
+		}
 		log.Infof("Reconcile and delete detached ENI %s", eni)
+		// The pods still using the ENI lost their connectivity, they are reported once it is removed
+		pods := c.dataStore.GetENIPods(eni)
 		// Force the delete, since aws local metadata has told us that this ENI is no longer
 		// attached, so any IPs assigned from this ENI will no longer work.
 		err = c.dataStore.RemoveENIFromDataStore(eni, true /* force */)
 		if err != nil {
 			log.Errorf("IP pool reconcile: Failed to delete ENI during reconcile: %v", err)
 			ipamdErrInc("eniReconcileDel")
+			reconcileErr = errors.Wrapf(err, "failed to delete detached ENI %s", eni)
+			c.eniTimelines.record(eni, eniEventError, "failed to remove detached ENI from the datastore: %v", err)
 			continue
 		}
+		c.eniTimelines.record(eni, eniEventRemoved, "removed from the datastore, no longer attached")
+		c.reportDetachedENIPods(eni, pods)
+		c.forgetPendingENI(eni)
+		c.forgetENIProvisionErrors(eni)
 		reconcileCnt.With(prometheus.Labels{"fn": "eniReconcileDel"}).Inc()
 	}
-	log.Debug("Successfully Reconciled ENI/IP pool")
+	c.checkAddressFamilies(attachedENIs)
+	c.reevaluateENIConfigChanges()
+	c.recordReconcile(reconcileErr)
+	c.recordReconcileDuration(time.Since(curTime))
+	if reconcileErr == nil {
+		log.Debug("Successfully Reconciled ENI/IP pool")
+	}
 	c.lastNodeIPPoolAction = curTime
 }
 
-func (c *IPAMContext) eniIPPoolReconcile(ipPool map[string]*datastore.AddressInfo, attachedENI awsutils.ENIMetadata, eni string) {
+// recordReconcile keeps the time of the reconcile, as the last success if err is nil, or the last failure otherwise
+func (c *IPAMContext) recordReconcile(err error) {
+	c.reconcile.lock.Lock()
+	defer c.reconcile.lock.Unlock()
+	if err == nil {
+		c.reconcile.lastSuccess = time.Now()
+		c.reconcile.failures = 0
+		return
+	}
+	c.reconcile.failures++
+	c.reconcile.lastFailure = time.Now()
+	c.reconcile.lastError = err.Error()
+}
+
+// recordReconcileDescribes keeps how many ENIs the reconcile described with EC2 and how long it took
+func (c *IPAMContext) recordReconcileDescribes(describes int, duration time.Duration) {
+	c.reconcile.lock.Lock()
+	defer c.reconcile.lock.Unlock()
+	c.reconcile.lastDescribes = describes
+	c.reconcile.lastDescribeDuration = duration
+}
+
+// recordReconcileDuration keeps how long the reconcile took
+func (c *IPAMContext) recordReconcileDuration(duration time.Duration) {
+	c.reconcile.lock.Lock()
+	defer c.reconcile.lock.Unlock()
+	c.reconcile.lastDuration = duration
+}
+
+// eniIPPoolReconcile reconciles the IPs of an ENI in the data store, and returns the last error it carried on after.
+// The EC2 addresses of the ENI are taken from described when the ENI was described beforehand.
+func (c *IPAMContext) eniIPPoolReconcile(ipPool map[string]*datastore.AddressInfo, attachedENI awsutils.ENIMetadata, eni string,
+	described map[string]describedENI) error {
+	var reconcileErr error
+	var added, deleted []string
 	for _, privateIPv4 := range attachedENI.IPv4Addresses {
 		strPrivateIPv4 := aws.StringValue(privateIPv4.PrivateIpAddress)
 		if strPrivateIPv4 == c.primaryIP[eni] {
@@ -1009,10 +1637,22 @@ func (c *IPAMContext) eniIPPoolReconcile(ipPool map[string]*datastore.AddressInf
 				continue
 			} else {
 				log.Debugf("This IP was recently freed, but is out of cooldown. We need to verify with EC2 control plane.")
-				// Call EC2 to verify
-				ec2Addresses, _, err := c.getENIaddresses(eni)
+				// Call EC2 to verify, unless the ENI was described before the mark phase
+				result, ok := described[eni]
+				if !ok {
+					result.addresses, _, result.err = c.getENIaddresses(eni)
+					described[eni] = result
+				}
+				ec2Addresses, err := result.addresses, result.err
+				if errors.Cause(err) == awsutils.ErrENINotFound && c.eniPendingConfirmation(eni, eniMissingFromDescribe) {
+					continue
+				}
+				if err == nil {
+					c.confirmENI(eni, eniMissingFromDescribe)
+				}
 				if err != nil {
 					log.Error("Failed to fetch ENI IP addresses!")
+					reconcileErr = errors.Wrapf(err, "failed to get IP addresses of ENI %s", eni)
 					continue
 				} else {
 					// Verify that the IP really belongs to this ENI
@@ -1045,10 +1685,12 @@ func (c *IPAMContext) eniIPPoolReconcile(ipPool map[string]*datastore.AddressInf
 		if err != nil {
 			log.Errorf("Failed to reconcile IP %s on ENI %s", strPrivateIPv4, eni)
 			ipamdErrInc("ipReconcileAdd")
+			reconcileErr = errors.Wrapf(err, "failed to add IP %s on ENI %s", strPrivateIPv4, eni)
 			// continue instead of bailout due to one IP
 			continue
 		}
 		reconcileCnt.With(prometheus.Labels{"fn": "eniIPPoolReconcileAdd"}).Inc()
+		added = append(added, strPrivateIPv4)
 	}
 
 	// Sweep phase, delete remaining IPs
@@ -1060,11 +1702,20 @@ func (c *IPAMContext) eniIPPoolReconcile(ipPool map[string]*datastore.AddressInf
 		if err != nil {
 			log.Errorf("Failed to reconcile and delete IP %s on ENI %s, %v", existingIP, eni, err)
 			ipamdErrInc("ipReconcileDel")
+			reconcileErr = errors.Wrapf(err, "failed to delete IP %s on ENI %s", existingIP, eni)
 			// continue instead of bailout due to one ip
 			continue
 		}
 		reconcileCnt.With(prometheus.Labels{"fn": "eniIPPoolReconcileDel"}).Inc()
+		deleted = append(deleted, existingIP)
+	}
+	if len(added) > 0 || len(deleted) > 0 {
+		c.eniTimelines.record(eni, eniEventReconciled, "added IPs %v, deleted IPs %v", added, deleted)
 	}
+	if reconcileErr != nil {
+		c.eniTimelines.record(eni, eniEventError, "reconcile failed: %v", reconcileErr)
+	}
+	return reconcileErr
 }
 
 // UseCustomNetworkCfg returns whether Pods needs to use pod specific configuration or not.
@@ -1111,6 +1762,172 @@ func getMinimumIPTarget() int {
 	return noMinimumIPTarget
 }
 
+func getSubnetPressureThreshold() int {
+	inputStr, found := os.LookupEnv(envSubnetPressureThreshold)
+
+	if !found {
+		return noSubnetPressureThreshold
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil {
+		if input >= 0 {
+			log.Debugf("Using SUBNET_PRESSURE_THRESHOLD %v", input)
+			return input
+		}
+	}
+	return noSubnetPressureThreshold
+}
+
+// checkSubnetPressure polls the number of free IPs in the subnet ENIs are allocated from, at most once per `interval`,
+// and records whether the subnet is below SUBNET_PRESSURE_THRESHOLD.
+func (c *IPAMContext) checkSubnetPressure(interval time.Duration) {
+	if c.subnetPressureThreshold == noSubnetPressureThreshold {
+		return
+	}
+	// The lock is not held across the EC2 call, the pool stats and the warm target read the state meanwhile
+	c.subnetPressure.lock.Lock()
+	now := time.Now()
+	if now.Sub(c.subnetPressure.lastCheck) < interval {
+		c.subnetPressure.lock.Unlock()
+		return
+	}
+	c.subnetPressure.lastCheck = now
+	c.subnetPressure.lock.Unlock()
+
+	var subnet string
+	if c.useCustomNetworking {
+		eniCfg, err := c.eniConfig.MyENIConfig()
+		if err != nil {
+			log.Errorf("Failed to get pod ENI config to check subnet pressure: %v", err)
+			return
+		}
+		subnet = eniCfg.Subnet
+	}
+
+	available, err := c.awsClient.GetSubnetAvailableIPCount(c.poolManagerContext(), subnet)
+	if err != nil {
+		ipamdErrInc("checkSubnetPressureFailed")
+		log.Warnf("Failed to check subnet free IP count: %v", err)
+		return
+	}
+	pressured := available < c.subnetPressureThreshold
+
+	c.subnetPressure.lock.Lock()
+	defer c.subnetPressure.lock.Unlock()
+	if pressured != c.subnetPressure.pressured {
+		if pressured {
+			log.Infof("Subnet is under IP pressure: %d free IPs < threshold %d, shrinking warm target to %d",
+				available, c.subnetPressureThreshold, subnetPressureWarmIPTarget)
+		} else {
+			log.Infof("Subnet is no longer under IP pressure: %d free IPs >= threshold %d", available, c.subnetPressureThreshold)
+		}
+	}
+	c.subnetPressure.availableIPs = available
+	c.subnetPressure.pressured = pressured
+}
+
+func (c *IPAMContext) isSubnetPressured() bool {
+	c.subnetPressure.lock.RLock()
+	defer c.subnetPressure.lock.RUnlock()
+	return c.subnetPressure.pressured
+}
+
+// effectiveWarmIPTarget returns the warm IP target currently in use. This is WARM_IP_TARGET, or the adaptive target
+// when ADAPTIVE_WARM_IP_TARGET is set, unless the pool is idle or the subnet is under pressure, in which case it is
+// lowered to idleShrinkWarmIPTarget or subnetPressureWarmIPTarget, or the pool is in shrink-only mode, in which case
+// no IP is kept warm.
+func (c *IPAMContext) effectiveWarmIPTarget() int {
+	if c.inShrinkMode() {
+		return noWarmIPTarget
+	}
+	warmIPTarget := c.warmIPTarget
+	if c.adaptiveWarmIPTargetEnabled {
+		warmIPTarget = c.adaptiveWarmIPTarget()
+	}
+	if c.idlePoolShrinking() && (warmIPTarget == noWarmIPTarget || warmIPTarget > idleShrinkWarmIPTarget) {
+		return idleShrinkWarmIPTarget
+	}
+	if c.isSubnetPressured() && (warmIPTarget == noWarmIPTarget || warmIPTarget > subnetPressureWarmIPTarget) {
+		return subnetPressureWarmIPTarget
+	}
+	return warmIPTarget
+}
+
+func getDatastoreFullPolicy() string {
+	return envsetting.Choice(envDatastoreFullPolicy, datastoreFullFailFast, datastoreFullFailFast, datastoreFullWait)
+}
+
+func getDatastoreFullWaitTimeout() time.Duration {
+	inputStr, found := os.LookupEnv(envDatastoreFullWaitTimeout)
+
+	if !found {
+		return defaultDatastoreFullWaitTimeout
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil {
+		if input > 0 {
+			log.Debugf("Using DATASTORE_FULL_WAIT_TIMEOUT %v", input)
+			return time.Duration(input) * time.Second
+		}
+	}
+	return defaultDatastoreFullWaitTimeout
+}
+
+func getReconcileJitterPercent() int {
+	inputStr, found := os.LookupEnv(envReconcileJitterPercent)
+
+	if !found {
+		return defaultReconcileJitterPercent
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil {
+		if input >= 0 && input <= maxReconcileJitterPercent {
+			log.Debugf("Using RECONCILE_JITTER_PERCENT %v", input)
+			return input
+		}
+	}
+	log.Errorf("Invalid RECONCILE_JITTER_PERCENT %q, using default %d", inputStr, defaultReconcileJitterPercent)
+	return defaultReconcileJitterPercent
+}
+
+func getMinFreeIPsPerENI() int {
+	inputStr, found := os.LookupEnv(envMinFreeIPsPerENI)
+
+	if !found {
+		return noMinFreeIPsPerENI
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil {
+		if input >= 0 {
+			log.Debugf("Using MIN_FREE_IPS_PER_ENI %v", input)
+			return input
+		}
+	}
+	log.Errorf("Invalid MIN_FREE_IPS_PER_ENI %q, using default %d", inputStr, noMinFreeIPsPerENI)
+	return noMinFreeIPsPerENI
+}
+
+// getReconcileInterval returns the reconcile interval of this node, with the jitter for RECONCILE_JITTER_PERCENT
+func getReconcileInterval() time.Duration {
+	nodeName := os.Getenv("MY_NODE_NAME")
+	if nodeName == "" {
+		nodeName, _ = os.Hostname()
+	}
+	return reconcileIntervalWithJitter(nodeIPPoolReconcileInterval, getReconcileJitterPercent(), nodeName)
+}
+
+// reconcileIntervalWithJitter adds up to percent of the interval to it. The jitter is derived from the node name, so
+// it is spread evenly over a fleet but does not change when ipamd restarts.
+func reconcileIntervalWithJitter(interval time.Duration, percent int, nodeName string) time.Duration {
+	maxJitter := int64(interval) * int64(percent) / 100
+	if maxJitter <= 0 {
+		return interval
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(nodeName))
+	return interval + time.Duration(h.Sum64()%uint64(maxJitter))
+}
+
 func filterUnmanagedENIs(enis []awsutils.ENIMetadata) ([]awsutils.ENIMetadata, int) {
 	numFiltered := 0
 	ret := make([]awsutils.ENIMetadata, 0, len(enis))
@@ -1128,27 +1945,30 @@ func filterUnmanagedENIs(enis []awsutils.ENIMetadata) ([]awsutils.ENIMetadata, i
 // ipTargetState determines the number of IPs `short` or `over` our WARM_IP_TARGET,
 // accounting for the MINIMUM_IP_TARGET
 func (c *IPAMContext) ipTargetState() (short int, over int, enabled bool) {
-	if c.warmIPTarget == noWarmIPTarget && c.minimumIPTarget == noMinimumIPTarget {
+	warmIPTarget := c.effectiveWarmIPTarget()
+	minimumIPTarget := c.effectiveMinimumIPTarget()
+	if warmIPTarget == noWarmIPTarget && minimumIPTarget == noMinimumIPTarget && !c.inShrinkMode() {
 		// there is no WARM_IP_TARGET defined and no MINIMUM_IP_TARGET, fallback to use all IP addresses on ENI
 		return 0, 0, false
 	}
 
 	total, assigned := c.dataStore.GetStats()
-	available := total - assigned
+	// The reserved IPs are kept on top of the warm IPs
+	available := total - assigned - c.reservedIPs
 
 	// short is greater than 0 when we have fewer available IPs than the warm IP target
-	short = max(c.warmIPTarget-available, 0)
+	short = max(warmIPTarget-available, 0)
 
 	// short is greater than the warm IP target alone when we have fewer total IPs than the minimum target
-	short = max(short, c.minimumIPTarget-total)
+	short = max(short, minimumIPTarget-total)
 
 	// over is the number of available IPs we have beyond the warm IP target
-	over = max(available-c.warmIPTarget, 0)
+	over = max(available-warmIPTarget, 0)
 
 	// over is less than the warm IP target alone if it would imply reducing total IPs below the minimum target
-	over = max(min(over, total-c.minimumIPTarget), 0)
+	over = max(min(over, total-minimumIPTarget), 0)
 
-	log.Tracef("Current warm IP stats: target: %d, total: %d, assigned: %d, available: %d, short: %d, over %d", c.warmIPTarget, total, assigned, available, short, over)
+	log.Tracef("Current warm IP stats: target: %d, total: %d, assigned: %d, available: %d, short: %d, over %d", warmIPTarget, total, assigned, available, short, over)
 	return short, over, true
 }
 
@@ -1161,12 +1981,283 @@ func (c *IPAMContext) isTerminating() bool {
 	return atomic.LoadInt32(&c.terminating) > 0
 }
 
-// GetConfigForDebug returns the active values of the configuration env vars (for debugging purposes).
-func GetConfigForDebug() map[string]interface{} {
-	return map[string]interface{}{
-		envWarmIPTarget:     getWarmIPTarget(),
-		envWarmENITarget:    getWarmENITarget(),
-		envCustomNetworkCfg: UseCustomNetworkCfg(),
+// PoolStats contains the warm pool targets and usage of the node, for introspection
+type PoolStats struct {
+	TotalIPs              int
+	AssignedIPs           int
+	WarmENITarget         int
+	WarmIPTarget          int
+	MinimumIPTarget       int
+	EffectiveWarmIPTarget int
+	// ClampedTargets are the targets set higher than the instance can hold, the values above are the clamped ones
+	ClampedTargets []WarmTargetClamp `json:",omitempty"`
+	// MinFreeIPsPerENI is MIN_FREE_IPS_PER_ENI, and ENIHeadroom how many more pods each ENI can take
+	MinFreeIPsPerENI int
+	ENIHeadroom      map[string]int
+	SubnetPressure   SubnetPressureStats
+	DatastoreFull    DatastoreFullStats
+	// ReservedIPs is the usage of the RESERVED_IPS, and of the rest of the pool
+	ReservedIPs datastore.ReservedIPStats
+	NodeIPQuota NodeIPQuotaStats
+	ShrinkMode  ShrinkModeStats
+	// IdleShrink describes the shrinking of the pool after a sustained low utilization
+	IdleShrink IdleShrinkStats
+	// DrainAdds counts the pods added while the node is in shrink-only mode
+	DrainAdds DrainAddStats
+	// Exhaustion estimates when the free IPs run out at the current allocation rate
+	Exhaustion PoolExhaustionStats
+	// AdaptiveWarmIPTarget is set when ADAPTIVE_WARM_IP_TARGET is
+	AdaptiveWarmIPTarget *AdaptiveWarmIPTargetStats `json:",omitempty"`
+}
+
+// DatastoreFullStats describes how AddNetwork requests are handled when there is no free IP
+type DatastoreFullStats struct {
+	Policy string
+	// WaitTimeoutSeconds is DATASTORE_FULL_WAIT_TIMEOUT, in seconds like the environment variable
+	WaitTimeoutSeconds float64
+	Waiting            int32
+	Waits              int64
+	WaitTimeouts       int64
+}
+
+// SubnetPressureStats describes the last subnet pressure check
+type SubnetPressureStats struct {
+	Threshold    int
+	AvailableIPs int
+	Pressured    bool
+	LastCheck    time.Time
+}
+
+// GetPoolStats returns the current warm pool stats and the targets in effect
+func (c *IPAMContext) GetPoolStats() PoolStats {
+	total, assigned := c.dataStore.GetStats()
+	c.subnetPressure.lock.RLock()
+	pressure := SubnetPressureStats{
+		Threshold:    c.subnetPressureThreshold,
+		AvailableIPs: c.subnetPressure.availableIPs,
+		Pressured:    c.subnetPressure.pressured,
+		LastCheck:    c.subnetPressure.lastCheck,
+	}
+	c.subnetPressure.lock.RUnlock()
+	var adaptive *AdaptiveWarmIPTargetStats
+	if c.adaptiveWarmIPTargetEnabled {
+		stats := c.getAdaptiveWarmIPTargetStats()
+		adaptive = &stats
+	}
+	return PoolStats{
+		TotalIPs:              total,
+		AssignedIPs:           assigned,
+		WarmENITarget:         c.warmENITarget,
+		WarmIPTarget:          c.warmIPTarget,
+		MinimumIPTarget:       c.minimumIPTarget,
+		EffectiveWarmIPTarget: c.effectiveWarmIPTarget(),
+		ClampedTargets:        c.warmTargetClamps,
+		MinFreeIPsPerENI:      c.minFreeIPsPerENI,
+		ENIHeadroom:           c.eniHeadroom(),
+		SubnetPressure:        pressure,
+		DatastoreFull: DatastoreFullStats{
+			Policy:             c.datastoreFullPolicy,
+			WaitTimeoutSeconds: c.datastoreFullWaitTimeout.Seconds(),
+			Waiting:            atomic.LoadInt32(&c.datastoreFullWaiting),
+			Waits:              atomic.LoadInt64(&c.datastoreFullWaits),
+			WaitTimeouts:       atomic.LoadInt64(&c.datastoreFullWaitTimeouts),
+		},
+		ReservedIPs: c.dataStore.GetReservedIPStats(),
+		NodeIPQuota: c.getNodeIPQuotaStats(),
+		ShrinkMode:  c.getShrinkModeStats(),
+		IdleShrink:  c.getIdleShrinkStats(),
+		DrainAdds:   c.getDrainAddStats(),
+		Exhaustion:  c.getPoolExhaustionStats(total, assigned),
+
+		AdaptiveWarmIPTarget: adaptive,
+	}
+}
+
+// ENIRoutes contains the route table of an ENI as programmed on the host
+type ENIRoutes struct {
+	ENIID        string
+	MAC          string
+	DeviceNumber int
+	networkutils.ENIRouteTable
+	Error string `json:",omitempty"`
+}
+
+// NearFullENI is an ENI that can take at most Headroom more pods before reaching MaxIPs, for introspection
+type NearFullENI struct {
+	ENIID        string
+	DeviceNumber int
+	AssignedIPs  int
+	AllocatedIPs int
+	MaxIPs       int
+	Headroom     int
+}
+
+// GetNearFullENIs returns the ENIs pods can use that have room for at most headroom more pods, fullest first
+func (c *IPAMContext) GetNearFullENIs(headroom int) []NearFullENI {
+	eniInfos := c.dataStore.GetENIInfos()
+	nearFull := make([]NearFullENI, 0)
+	for eniID, eniHeadroom := range c.eniHeadroom() {
+		if eniHeadroom > headroom {
+			continue
+		}
+		eni := eniInfos.ENIIPPools[eniID]
+		nearFull = append(nearFull, NearFullENI{
+			ENIID:        eniID,
+			DeviceNumber: eni.DeviceNumber,
+			AssignedIPs:  eni.AssignedIPv4Addresses,
+			AllocatedIPs: len(eni.IPv4Addresses),
+			MaxIPs:       c.maxIPsPerENI,
+			Headroom:     eniHeadroom,
+		})
+	}
+	sort.Slice(nearFull, func(i, j int) bool {
+		if nearFull[i].Headroom != nearFull[j].Headroom {
+			return nearFull[i].Headroom < nearFull[j].Headroom
+		}
+		return nearFull[i].ENIID < nearFull[j].ENIID
+	})
+	return nearFull
+}
+
+// nearFullHeadroom is the default headroom of /v1/near-full-enis, MIN_FREE_IPS_PER_ENI when it is set
+func (c *IPAMContext) nearFullHeadroom() int {
+	if c.minFreeIPsPerENI != noMinFreeIPsPerENI {
+		return c.minFreeIPsPerENI
+	}
+	return defaultNearFullHeadroom
+}
+
+// K8SStatus is the health of the watches of the API server ipamd depends on, for introspection. ENIConfigs is only
+// set when custom networking is enabled.
+type K8SStatus struct {
+	Pods       k8sapi.WatchStatus
+	ENIConfigs *k8sapi.WatchStatus `json:",omitempty"`
+}
+
+// GetK8SStatus returns the health of the pod and ENIConfig watches
+func (c *IPAMContext) GetK8SStatus() K8SStatus {
+	status := K8SStatus{Pods: c.k8sClient.K8SGetWatchStatus()}
+	if c.useCustomNetworking && c.eniConfig != nil {
+		eniConfigs := c.eniConfig.GetWatchStatus()
+		status.ENIConfigs = &eniConfigs
+	}
+	return status
+}
+
+// GetENIRoutes returns, for each ENI attached as of the last reconcile, the route table it uses and the routes it
+// contains
+func (c *IPAMContext) GetENIRoutes() ([]ENIRoutes, error) {
+	enis, err := c.getAttachedENIs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get attached ENIs")
+	}
+	result := make([]ENIRoutes, 0, len(enis))
+	for _, eni := range enis {
+		eniRoutes := ENIRoutes{ENIID: eni.ENIID, MAC: eni.MAC, DeviceNumber: eni.DeviceNumber}
+		routeTable, err := c.networkClient.GetENIRouteTable(eni.MAC, eni.DeviceNumber, eni.SubnetIPv4CIDR)
+		if err != nil {
+			log.Warnf("Failed to get route table of ENI %s: %v", eni.ENIID, err)
+			eniRoutes.Error = err.Error()
+		} else {
+			eniRoutes.ENIRouteTable = routeTable
+		}
+		result = append(result, eniRoutes)
+	}
+	return result, nil
+}
+
+// GetConfigForDebug returns the active values of the configuration env vars, with their source and default values
+// (for debugging purposes).
+func GetConfigForDebug() map[string]envsetting.Setting {
+	return map[string]envsetting.Setting{
+		envWarmIPTarget:             envsetting.New(envWarmIPTarget, getWarmIPTarget(), noWarmIPTarget),
+		envWarmENITarget:            envsetting.New(envWarmENITarget, getWarmENITarget(), defaultWarmENITarget),
+		envMinFreeIPsPerENI:         envsetting.New(envMinFreeIPsPerENI, getMinFreeIPsPerENI(), noMinFreeIPsPerENI),
+		envReservedIPs:              envsetting.New(envReservedIPs, getReservedIPs(), noReservedIPs),
+		envReservedIPsMinPriority:   envsetting.New(envReservedIPsMinPriority, getReservedIPsMinPriority(), int32(defaultReservedIPsMinPriority)),
+		envReservedIPsPodLabel:      envsetting.New(envReservedIPsPodLabel, getReservedIPsPodLabel(), ""),
+		envIPAssignmentOrder:        envsetting.New(envIPAssignmentOrder, getIPAssignmentOrder(), defaultIPAssignmentOrder),
+		envCustomNetworkCfg:         envsetting.New(envCustomNetworkCfg, UseCustomNetworkCfg(), false),
+		envSubnetPressureThreshold:  envsetting.New(envSubnetPressureThreshold, getSubnetPressureThreshold(), noSubnetPressureThreshold),
+		envDatastoreFullPolicy:      envsetting.New(envDatastoreFullPolicy, getDatastoreFullPolicy(), datastoreFullFailFast),
+		envDatastoreFullWaitTimeout: envsetting.New(envDatastoreFullWaitTimeout, getDatastoreFullWaitTimeout().Seconds(), defaultDatastoreFullWaitTimeout.Seconds()),
+		envPreservePodIPs:           envsetting.New(envPreservePodIPs, preservePodIPs(), false),
+		envPodIPStateDir:            envsetting.New(envPodIPStateDir, getPodIPStateDir(), defaultPodIPStateDir),
+		envPodIPStateRetention:      envsetting.New(envPodIPStateRetention, getPodIPStateRetention().Seconds(), defaultPodIPStateRetention.Seconds()),
+		envReconcileJitterPercent:   envsetting.New(envReconcileJitterPercent, getReconcileJitterPercent(), defaultReconcileJitterPercent),
+		"reconcileIntervalSeconds":  envsetting.Derived(getReconcileInterval().Seconds()),
+		envRPCAllowedUIDs:           envsetting.New(envRPCAllowedUIDs, getRPCAllowedIDsForDebug(envRPCAllowedUIDs), []uint32{}),
+		envRPCAllowedGIDs:           envsetting.New(envRPCAllowedGIDs, getRPCAllowedIDsForDebug(envRPCAllowedGIDs), []uint32{}),
+		envPrimaryIPLostPolicy:      envsetting.New(envPrimaryIPLostPolicy, getPrimaryIPLostPolicy(), primaryIPLostLog),
+		envEnableSelfTest:           envsetting.New(envEnableSelfTest, selfTestEnabled(), false),
+		envDuplicateAddPolicy:       envsetting.New(envDuplicateAddPolicy, getDuplicateAddPolicy(), duplicateAddReuse),
+		envAlternateSubnets:         envsetting.New(envAlternateSubnets, getAlternateSubnets(), []string(nil)),
+		envPodSubnetAllowlist:       envsetting.New(envPodSubnetAllowlist, getPodSubnetAllowlist(), []string(nil)),
+		envReconcileDescribeConcurrency: envsetting.New(envReconcileDescribeConcurrency, getReconcileDescribeConcurrency(),
+			defaultReconcileDescribeConcurrency),
+		envAddRetryAttempts: envsetting.New(envAddRetryAttempts, getAddRetryAttempts(), noAddRetry),
+		envAddRetryBackoff:  envsetting.New(envAddRetryBackoff, getAddRetryBackoff().Milliseconds(), defaultAddRetryBackoff.Milliseconds()),
+		envOrphanedIPPolicy: envsetting.New(envOrphanedIPPolicy, getOrphanedIPPolicy(), orphanedIPReport),
+		envNodeIPQuota:      envsetting.New(envNodeIPQuota, getNodeIPQuota(), noNodeIPQuota),
+		envVerifyDelCleanup: envsetting.New(envVerifyDelCleanup, verifyDelCleanupEnabled(), false),
+		envScaleDownShrink:  envsetting.New(envScaleDownShrink, scaleDownShrinkEnabled(), false),
+		envAttachLimitBackoff: envsetting.New(envAttachLimitBackoff, getAttachLimitBackoff().Seconds(),
+			defaultAttachLimitBackoff.Seconds()),
+		envOrphanedIPGracePeriod: envsetting.New(envOrphanedIPGracePeriod, getOrphanedIPGracePeriod().Seconds(),
+			defaultOrphanedIPGracePeriod.Seconds()),
+		envLogHostNetworkAdds: envsetting.New(envLogHostNetworkAdds, logHostNetworkAddsEnabled(), false),
+		envSubnetSelection:    envsetting.New(envSubnetSelection, getSubnetSelection(), subnetSelectionDefault),
+		envPodIPAuditLog:      envsetting.New(envPodIPAuditLog, getPodIPAuditLog(), ""),
+		envENIConsistencyGracePeriod: envsetting.New(envENIConsistencyGracePeriod, getENIConsistencyGracePeriod().Seconds(),
+			defaultENIConsistencyGracePeriod.Seconds()),
+		envNamespaceIPQuota: envsetting.New(envNamespaceIPQuota, getNamespaceIPQuotas(), map[string]int(nil)),
+		envENIDeleteAsync:   envsetting.New(envENIDeleteAsync, eniDeleteAsyncEnabled(), false),
+		envENIConfigAZMismatchPolicy: envsetting.New(envENIConfigAZMismatchPolicy, getENIConfigAZMismatchPolicy(),
+			eniConfigAZMismatchWarn),
+		envConntrackCleanupOnDel: envsetting.New(envConntrackCleanupOnDel, conntrackCleanupOnDelEnabled(), false),
+		envIMDSUnavailablePolicy: envsetting.New(envIMDSUnavailablePolicy, getIMDSUnavailablePolicy(), imdsUnavailableContinue),
+		envPoolGrowthBatchWindow: envsetting.New(envPoolGrowthBatchWindow, getPoolGrowthBatchWindow().Milliseconds(), 0),
+		envAdaptiveWarmIPTarget:  envsetting.New(envAdaptiveWarmIPTarget, adaptiveWarmIPTargetEnabled(), false),
+		envAdaptiveWarmIPTargetWindow: envsetting.New(envAdaptiveWarmIPTargetWindow,
+			getAdaptiveWarmIPTargetWindow().Seconds(), defaultAdaptiveWarmIPTargetWindow.Seconds()),
+		envAdaptiveWarmIPTargetMin: envsetting.New(envAdaptiveWarmIPTargetMin, getAdaptiveWarmIPTargetMin(),
+			defaultAdaptiveWarmIPTargetMin),
+		envAdaptiveWarmIPTargetMax: envsetting.New(envAdaptiveWarmIPTargetMax, getAdaptiveWarmIPTargetMax(),
+			noAdaptiveWarmIPTargetMax),
+		envDrainAddPolicy:      envsetting.New(envDrainAddPolicy, getDrainAddPolicy(), drainAddAllow),
+		envNetlinkRetries:      envsetting.New(envNetlinkRetries, getNetlinkRetries(), noNetlinkRetries),
+		envMetricsTextfilePath: envsetting.New(envMetricsTextfilePath, getMetricsTextfilePath(), ""),
+		envMetricsTextfileInterval: envsetting.New(envMetricsTextfileInterval,
+			int(getMetricsTextfileInterval().Seconds()), int(defaultMetricsTextfileInterval.Seconds())),
+		envLegacyENIPolicy: envsetting.New(envLegacyENIPolicy, getLegacyENIPolicy(), legacyENIAdopt),
+		envIntrospectionMaxResponseSize: envsetting.New(envIntrospectionMaxResponseSize,
+			getIntrospectionMaxResponseSize(), noIntrospectionMaxResponseSize),
+		envIntrospectionRateLimit: envsetting.New(envIntrospectionRateLimit, getIntrospectionRateLimit(),
+			noIntrospectionRateLimit),
+		envSamePodIPReuseWindow: envsetting.New(envSamePodIPReuseWindow, int(getSamePodIPReuseWindow().Seconds()),
+			noSamePodIPReuse),
+		envPartialAllocPolicy: envsetting.New(envPartialAllocPolicy, getPartialAllocPolicy(), partialAllocAccept),
+		envReconcileMaxDuration: envsetting.New(envReconcileMaxDuration, int(getReconcileMaxDuration().Seconds()),
+			noReconcileMaxDuration),
+		envIPFamilyMismatchPolicy: envsetting.New(envIPFamilyMismatchPolicy, getIPFamilyMismatchPolicy(),
+			ipFamilyMismatchReject),
+		envBranchENICleanupPolicy: envsetting.New(envBranchENICleanupPolicy, getBranchENICleanupPolicy(),
+			branchENICleanupReport),
+		envBranchENICleanupGracePeriod: envsetting.New(envBranchENICleanupGracePeriod,
+			getBranchENICleanupGracePeriod().Seconds(), defaultBranchENICleanupGracePeriod.Seconds()),
+		envIdlePoolShrinkThreshold: envsetting.New(envIdlePoolShrinkThreshold, getIdlePoolShrinkThreshold(),
+			noIdlePoolShrink),
+		envIdlePoolShrinkDuration: envsetting.New(envIdlePoolShrinkDuration, int(getIdlePoolShrinkDuration().Seconds()),
+			int(defaultIdlePoolShrinkDuration.Seconds())),
+		envAllocIdempotencyPolicy: envsetting.New(envAllocIdempotencyPolicy, getAllocIdempotencyPolicy(),
+			allocIdempotencyRecover),
+		envDetachedENIPodPolicy: envsetting.New(envDetachedENIPodPolicy, getDetachedENIPodPolicy(), detachedENIPodLog),
+		envMaxIPAssignmentAge: envsetting.New(envMaxIPAssignmentAge, int(getMaxIPAssignmentAge().Seconds()),
+			noMaxIPAssignmentAge),
+		envMaxIPAssignmentAgePolicy: envsetting.New(envMaxIPAssignmentAgePolicy, getMaxIPAssignmentAgePolicy(),
+			ipAssignmentAgeFlag),
+		envENIConfigChangePolicy: envsetting.New(envENIConfigChangePolicy, getENIConfigChangePolicy(),
+			eniConfigChangeFlag),
 	}
 }
 
@@ -1183,3 +2274,60 @@ func min(x, y int) int {
 	}
 	return x
 }
+
+// keepLast returns the index from which a slice of n items holds its last max items, which bounds the recent events
+// kept for introspection
+func keepLast(n, max int) int {
+	if n > max {
+		return n - max
+	}
+	return 0
+}
+
+// ReconcileStatus contains the outcome of the last reconciles of the IP pool with EC2, for introspection
+type ReconcileStatus struct {
+	// LastSuccess is the time of the last reconcile that completed without any error
+	LastSuccess time.Time
+	// LastFailure is the time of the last reconcile that had an error, and LastError is that error
+	LastFailure time.Time
+	LastError   string `json:",omitempty"`
+	// ConsecutiveFailures is the number of reconciles that failed since the last success. Healthy is true once a
+	// reconcile succeeded, until MaxConsecutiveFailures reconciles in a row fail.
+	ConsecutiveFailures    int
+	MaxConsecutiveFailures int
+	Healthy                bool
+	// LastDuration is how long the last reconcile took. It described LastDescribes ENIs with EC2, at most
+	// DescribeConcurrency at a time, in LastDescribeDuration.
+	LastDuration         time.Duration
+	LastDescribes        int
+	LastDescribeDuration time.Duration
+	DescribeConcurrency  int
+	// Watchdog describes the cancellations of the pool manager iterations, when RECONCILE_MAX_DURATION is set
+	Watchdog *ReconcileWatchdogStats `json:",omitempty"`
+}
+
+// GetReconcileStatus returns the outcome of the last reconciles of the IP pool
+func (c *IPAMContext) GetReconcileStatus() ReconcileStatus {
+	var watchdog *ReconcileWatchdogStats
+	if c.reconcileMaxDuration != noReconcileMaxDuration {
+		stats := c.GetReconcileWatchdogStats()
+		watchdog = &stats
+	}
+	c.reconcile.lock.RLock()
+	defer c.reconcile.lock.RUnlock()
+	return ReconcileStatus{
+		LastSuccess: c.reconcile.lastSuccess,
+		LastFailure: c.reconcile.lastFailure,
+		LastError:   c.reconcile.lastError,
+
+		ConsecutiveFailures:    c.reconcile.failures,
+		MaxConsecutiveFailures: maxReconcileFailures,
+		Healthy:                !c.reconcile.lastSuccess.IsZero() && c.reconcile.failures < maxReconcileFailures,
+
+		LastDuration:         c.reconcile.lastDuration,
+		LastDescribes:        c.reconcile.lastDescribes,
+		LastDescribeDuration: c.reconcile.lastDescribeDuration,
+		DescribeConcurrency:  c.reconcileDescribeConcurrency,
+		Watchdog:             watchdog,
+	}
+}
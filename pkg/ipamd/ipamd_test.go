@@ -14,26 +14,34 @@
 package ipamd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
 	mock_awsutils "github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils/mocks"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/cri"
 	mock_cri "github.com/aws/amazon-vpc-cni-k8s/pkg/cri/mocks"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
 	mock_eniconfig "github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig/mocks"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 	mock_k8sapi "github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi/mocks"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	mock_networkutils "github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils/mocks"
+	"github.com/aws/amazon-vpc-cni-k8s/rpc"
 )
 
 const (
@@ -123,7 +131,7 @@ func TestNodeInit(t *testing.T) {
 	var cidrs []*string
 	mockAWS.EXPECT().GetENILimit().Return(4, nil)
 	mockAWS.EXPECT().GetENIipLimit().Return(14, nil)
-	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{eni1, eni2}, nil)
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return([]awsutils.ENIMetadata{eni1, eni2}, nil)
 	mockAWS.EXPECT().GetVPCIPv4CIDR().Return(vpcCIDR)
 
 	_, vpcCIDR, _ := net.ParseCIDR(vpcCIDR)
@@ -141,7 +149,7 @@ func TestNodeInit(t *testing.T) {
 			PrivateIpAddress: &testAddr1, Primary: &primary},
 		{
 			PrivateIpAddress: &testAddr2, Primary: &notPrimary}}
-	mockAWS.EXPECT().DescribeENI(primaryENIid).Return(eniResp, map[string]string{}, &attachmentID, nil)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(eniResp, map[string]string{}, &attachmentID, nil)
 	mockNetwork.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, secSubnet)
 
 	mockAWS.EXPECT().GetLocalIPv4().Return(ipaddr01)
@@ -159,11 +167,27 @@ func TestNodeInit(t *testing.T) {
 
 	mockNetwork.EXPECT().UseExternalSNAT().Return(false)
 	mockNetwork.EXPECT().UpdateRuleListBySrc(gomock.Any(), gomock.Any(), gomock.Any(), true)
+	mockNetwork.EXPECT().GetEgressOnlyIPs().Return(nil, nil)
 	// Add IPs
-	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), gomock.Any())
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), gomock.Any(), gomock.Any())
 
+	mockContext.startupProfile.start()
 	err := mockContext.nodeInit()
 	assert.NoError(t, err)
+
+	mockContext.startupProfile.markReady()
+	profile := mockContext.GetStartupProfile()
+	var phases []string
+	var total time.Duration
+	for _, phase := range profile.Phases {
+		phases = append(phases, phase.Name)
+		total += phase.Duration
+	}
+	assert.Equal(t, []string{startupPhaseENIDiscovery, startupPhaseHostNetwork, startupPhaseENISetup,
+		startupPhaseInitialReconcile, startupPhaseInitialPool, startupPhaseReadiness}, phases)
+	assert.False(t, profile.Ready.IsZero())
+	assert.Equal(t, profile.Ready.Sub(profile.Started), profile.Total)
+	assert.Equal(t, profile.Total, total)
 }
 
 func TestIncreaseIPPoolDefault(t *testing.T) {
@@ -214,13 +238,16 @@ func testIncreaseIPPool(t *testing.T, useENIConfig bool) {
 	}
 
 	if useENIConfig {
-		mockENIConfig.EXPECT().MyENIConfig().Return(podENIConfig, nil)
-		mockAWS.EXPECT().AllocENI(true, sg, podENIConfig.Subnet).Return(eni2, nil)
+		// Once to create the ENI, once to check that it did not change meanwhile
+		mockENIConfig.EXPECT().MyENIConfig().Return(podENIConfig, nil).Times(2)
+		mockAWS.EXPECT().GetAvailabilityZone().Return("us-west-2a")
+		mockAWS.EXPECT().GetSubnetAvailabilityZone(gomock.Any(), podENIConfig.Subnet).Return("us-west-2a", nil)
+		mockAWS.EXPECT().AllocENI(gomock.Any(), true, sg, podENIConfig.Subnet).Return(eni2, nil)
 	} else {
-		mockAWS.EXPECT().AllocENI(false, nil, "").Return(eni2, nil)
+		mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "").Return(eni2, nil)
 	}
 
-	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return([]awsutils.ENIMetadata{
 		{
 			ENIID:          primaryENIid,
 			MAC:            primaryMAC,
@@ -254,7 +281,7 @@ func testIncreaseIPPool(t *testing.T, useENIConfig bool) {
 	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
 	mockNetwork.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, secSubnet)
 
-	mockAWS.EXPECT().AllocIPAddresses(eni2, 14)
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), eni2, 14)
 	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
 
 	mockContext.increaseIPPool()
@@ -297,9 +324,9 @@ func TestTryAddIPToENI(t *testing.T) {
 		sg = append(sg, aws.String(sgID))
 	}
 
-	mockAWS.EXPECT().AllocENI(false, nil, "").Return(secENIid, nil)
-	mockAWS.EXPECT().AllocIPAddresses(secENIid, warmIpTarget)
-	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{
+	mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "").Return(secENIid, nil)
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), secENIid, warmIpTarget)
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return([]awsutils.ENIMetadata{
 		{
 			ENIID:          primaryENIid,
 			MAC:            primaryMAC,
@@ -355,7 +382,7 @@ func TestNodeIPPoolReconcile(t *testing.T) {
 	testAddr1 := ipaddr01
 	testAddr2 := ipaddr02
 
-	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return([]awsutils.ENIMetadata{
 		{
 			ENIID:          primaryENIid,
 			MAC:            primaryMAC,
@@ -376,14 +403,23 @@ func TestNodeIPPoolReconcile(t *testing.T) {
 
 	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
 
+	// Primary IP check
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid).Times(3)
+	mockAWS.EXPECT().GetLocalIPv4().Return(ipaddr01).Times(3)
+	mockNetwork.EXPECT().HasLinkIPv4Address(primaryMAC, ipaddr01).Return(true, nil).Times(2)
+
 	mockContext.nodeIPPoolReconcile(0)
 
 	curENIs := mockContext.dataStore.GetENIInfos()
 	assert.Equal(t, len(curENIs.ENIIPPools), 1)
 	assert.Equal(t, curENIs.TotalIPs, 1)
+	families := mockContext.GetAddressFamilyStats()
+	assert.Equal(t, 0, families.InconsistentENIs)
+	assert.Equal(t, []ENIAddressFamilies{{ENIID: primaryENIid, IPv4: AddressFamilyStatus{EC2: 1, Tracked: 1}}},
+		families.ENIs)
 
 	// remove 1 IP
-	mockAWS.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return([]awsutils.ENIMetadata{
 		{
 			ENIID:          primaryENIid,
 			MAC:            primaryMAC,
@@ -403,7 +439,7 @@ func TestNodeIPPoolReconcile(t *testing.T) {
 	assert.Equal(t, curENIs.TotalIPs, 0)
 
 	// remove eni
-	mockAWS.EXPECT().GetAttachedENIs().Return(nil, nil)
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return(nil, nil)
 
 	mockContext.nodeIPPoolReconcile(0)
 	curENIs = mockContext.dataStore.GetENIInfos()
@@ -411,6 +447,147 @@ func TestNodeIPPoolReconcile(t *testing.T) {
 	assert.Equal(t, curENIs.TotalIPs, 0)
 }
 
+func TestReconcileStatus(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient: mockAWS,
+		dataStore: datastore.NewDataStore(),
+		primaryIP: make(map[string]string),
+	}
+	assert.False(t, mockContext.GetReconcileStatus().Healthy)
+
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid).AnyTimes()
+	mockAWS.EXPECT().GetLocalIPv4().Return(ipaddr01).AnyTimes()
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return(nil, errors.New("imds unavailable"))
+	mockContext.nodeIPPoolReconcile(0)
+	status := mockContext.GetReconcileStatus()
+	assert.False(t, status.Healthy)
+	assert.True(t, status.LastSuccess.IsZero())
+	assert.False(t, status.LastFailure.IsZero())
+	assert.Contains(t, status.LastError, "imds unavailable")
+
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return(nil, nil)
+	mockContext.nodeIPPoolReconcile(0)
+	status = mockContext.GetReconcileStatus()
+	assert.True(t, status.Healthy)
+	assert.False(t, status.LastSuccess.IsZero())
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+	// The last failure is still reported
+	assert.Contains(t, status.LastError, "imds unavailable")
+
+	// Transient failures keep ipamd healthy, until maxReconcileFailures reconciles in a row failed
+	for i := 1; i <= maxReconcileFailures; i++ {
+		mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return(nil, errors.New("imds unavailable"))
+		mockContext.nodeIPPoolReconcile(0)
+		status = mockContext.GetReconcileStatus()
+		assert.Equal(t, i, status.ConsecutiveFailures)
+		assert.Equal(t, i < maxReconcileFailures, status.Healthy)
+	}
+
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return(nil, nil)
+	mockContext.nodeIPPoolReconcile(0)
+	status = mockContext.GetReconcileStatus()
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+}
+
+func TestReconcileWatchdog(t *testing.T) {
+	c := &IPAMContext{reconcileMaxDuration: minReconcileMaxDuration}
+	watchdog := &c.reconcileWatchdog
+
+	// Outside of the loop, the EC2 calls are not cancelled
+	assert.NoError(t, c.poolManagerContext().Err())
+
+	// An iteration within the max duration is left alone
+	ctx := watchdog.begin()
+	assert.Equal(t, ctx, c.poolManagerContext())
+	watchdog.enterStep("reconcile")
+	assert.False(t, watchdog.trip(c.reconcileMaxDuration))
+	assert.Equal(t, "reconcile", c.GetReconcileStatus().Watchdog.Step)
+
+	// A wedged iteration is cancelled, with the EC2 calls of its step, once
+	watchdog.started = time.Now().Add(-time.Hour)
+	assert.True(t, watchdog.trip(c.reconcileMaxDuration))
+	assert.Error(t, ctx.Err())
+	assert.Error(t, c.poolManagerContext().Err())
+	assert.False(t, watchdog.trip(c.reconcileMaxDuration))
+
+	stats := c.GetReconcileWatchdogStats()
+	assert.Equal(t, int64(1), stats.Trips)
+	assert.Equal(t, "reconcile", stats.LastTripStep)
+	assert.True(t, stats.LastTripDuration >= time.Hour)
+	// The cancelled step has not returned yet, no other iteration runs until it does
+	assert.Equal(t, "reconcile", stats.Step)
+	assert.True(t, stats.Running >= time.Hour)
+
+	// The loop starts the next iteration once the step returns
+	watchdog.end()
+	assert.NoError(t, c.poolManagerContext().Err())
+	ctx = watchdog.begin()
+	assert.NoError(t, ctx.Err())
+	watchdog.end()
+	assert.False(t, watchdog.trip(c.reconcileMaxDuration))
+
+	c.reconcileMaxDuration = noReconcileMaxDuration
+	assert.Nil(t, c.GetReconcileStatus().Watchdog)
+}
+
+func TestReconcileDescribesENIs(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:                    mockAWS,
+		networkClient:                mockNetwork,
+		dataStore:                    datastore.NewDataStore(),
+		primaryIP:                    map[string]string{primaryENIid: ipaddr01, secENIid: ipaddr11},
+		reconcileDescribeConcurrency: 2,
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddENI(secENIid, secDevice, false)
+
+	primary := true
+	notPrimary := false
+	addrs := []string{ipaddr01, ipaddr02, ipaddr03, ipaddr11, ipaddr12}
+	ec2Addrs := func(primaryAddr int, indexes ...int) []*ec2.NetworkInterfacePrivateIpAddress {
+		var result []*ec2.NetworkInterfacePrivateIpAddress
+		for _, i := range indexes {
+			result = append(result, &ec2.NetworkInterfacePrivateIpAddress{PrivateIpAddress: &addrs[i], Primary: &notPrimary})
+		}
+		result[primaryAddr].Primary = &primary
+		return result
+	}
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return([]awsutils.ENIMetadata{
+		{ENIID: primaryENIid, MAC: primaryMAC, DeviceNumber: primaryDevice, IPv4Addresses: ec2Addrs(0, 0, 1, 2)},
+		{ENIID: secENIid, MAC: secMAC, DeviceNumber: secDevice, IPv4Addresses: ec2Addrs(0, 3, 4)},
+	}, nil)
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid).AnyTimes()
+	mockAWS.EXPECT().GetLocalIPv4().Return(ipaddr01).AnyTimes()
+	mockNetwork.EXPECT().HasLinkIPv4Address(primaryMAC, ipaddr01).Return(true, nil).AnyTimes()
+
+	// Both IPs of the primary ENI and the IP of the secondary ENI are out of their cooldown, each ENI is described once
+	mockContext.reconcileCooldownCache.cache = map[string]time.Time{
+		ipaddr02: time.Now().Add(-time.Minute),
+		ipaddr03: time.Now().Add(-time.Minute),
+		ipaddr12: time.Now().Add(-time.Minute),
+	}
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(ec2Addrs(0, 0, 1), map[string]string{}, nil, nil)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), secENIid).Return(ec2Addrs(0, 3, 4), map[string]string{}, nil, nil)
+
+	mockContext.nodeIPPoolReconcile(0)
+
+	curENIs := mockContext.dataStore.GetENIInfos()
+	// ipaddr03 does not belong to the primary ENI in EC2
+	assert.Equal(t, 2, curENIs.TotalIPs)
+	status := mockContext.GetReconcileStatus()
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 2, status.LastDescribes)
+	assert.Equal(t, 2, status.DescribeConcurrency)
+	assert.True(t, status.LastDuration >= status.LastDescribeDuration)
+}
+
 func TestGetWarmENITarget(t *testing.T) {
 	ctrl, _, _, _, _, _ := setup(t)
 	defer ctrl.Finish()
@@ -428,6 +605,23 @@ func TestGetWarmENITarget(t *testing.T) {
 	assert.Equal(t, warmIPTarget, noWarmIPTarget)
 }
 
+func TestReconcileIntervalWithJitter(t *testing.T) {
+	defer os.Unsetenv(envReconcileJitterPercent)
+
+	assert.Equal(t, defaultReconcileJitterPercent, getReconcileJitterPercent())
+	_ = os.Setenv(envReconcileJitterPercent, "150")
+	assert.Equal(t, defaultReconcileJitterPercent, getReconcileJitterPercent())
+	_ = os.Setenv(envReconcileJitterPercent, "50")
+	assert.Equal(t, 50, getReconcileJitterPercent())
+
+	assert.Equal(t, time.Minute, reconcileIntervalWithJitter(time.Minute, 0, "node-1"))
+	interval := reconcileIntervalWithJitter(time.Minute, 50, "node-1")
+	assert.True(t, interval >= time.Minute && interval < 90*time.Second)
+	// Stable for a node, different between nodes
+	assert.Equal(t, interval, reconcileIntervalWithJitter(time.Minute, 50, "node-1"))
+	assert.NotEqual(t, interval, reconcileIntervalWithJitter(time.Minute, 50, "node-2"))
+}
+
 func TestGetWarmIPTargetState(t *testing.T) {
 	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
 	defer ctrl.Finish()
@@ -472,6 +666,43 @@ func TestGetWarmIPTargetState(t *testing.T) {
 	assert.Equal(t, 0, over)
 }
 
+func TestSubnetPressureShrinksWarmTarget(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:               mockAWS,
+		k8sClient:               mockK8S,
+		networkClient:           mockNetwork,
+		dataStore:               datastoreWith3FreeIPs(),
+		warmIPTarget:            3,
+		subnetPressureThreshold: 10,
+	}
+
+	_, over, _ := mockContext.ipTargetState()
+	assert.Equal(t, 0, over)
+
+	// Subnet is about to run out, shrink the warm target
+	mockAWS.EXPECT().GetSubnetAvailableIPCount(gomock.Any(), "").Return(5, nil)
+	mockContext.checkSubnetPressure(subnetPressureCheckInterval)
+	assert.Equal(t, subnetPressureWarmIPTarget, mockContext.effectiveWarmIPTarget())
+	_, over, _ = mockContext.ipTargetState()
+	assert.Equal(t, 2, over)
+
+	// Within the interval, the subnet is not polled again
+	mockContext.checkSubnetPressure(subnetPressureCheckInterval)
+
+	// Subnet has recovered
+	mockAWS.EXPECT().GetSubnetAvailableIPCount(gomock.Any(), "").Return(50, nil)
+	mockContext.checkSubnetPressure(0)
+	assert.Equal(t, 3, mockContext.effectiveWarmIPTarget())
+
+	stats := mockContext.GetPoolStats()
+	assert.Equal(t, 3, stats.TotalIPs)
+	assert.Equal(t, 50, stats.SubnetPressure.AvailableIPs)
+	assert.False(t, stats.SubnetPressure.Pressured)
+}
+
 func TestIPAMContext_nodeIPPoolTooLow(t *testing.T) {
 	ctrl, mockAWS, mockK8S, _, mockNetwork, mockENIConfig := setup(t)
 	defer ctrl.Finish()
@@ -518,6 +749,457 @@ func TestIPAMContext_nodeIPPoolTooLow(t *testing.T) {
 	}
 }
 
+func TestENIHeadroom(t *testing.T) {
+	c := &IPAMContext{
+		dataStore:        datastoreWith3Pods(),
+		maxIPsPerENI:     4,
+		maxENI:           3,
+		minFreeIPsPerENI: 2,
+	}
+	// The primary ENI can only take one more pod
+	assert.True(t, c.eniHeadroomTooLow())
+	assert.True(t, c.nodeIPPoolTooLow())
+
+	_ = c.dataStore.AddENI(secENIid, secDevice, false)
+	assert.False(t, c.eniHeadroomTooLow())
+	// The new ENI is the only one with room
+	assert.True(t, c.requiredForENIHeadroom())
+
+	_ = c.dataStore.AddENI("eni-00000002", 3, false)
+	assert.False(t, c.requiredForENIHeadroom())
+
+	stats := c.GetPoolStats()
+	assert.Equal(t, 2, stats.MinFreeIPsPerENI)
+	assert.Equal(t, map[string]int{primaryENIid: 1, secENIid: 4, "eni-00000002": 4}, stats.ENIHeadroom)
+
+	// No room left for another ENI
+	c.dataStore = datastoreWith3Pods()
+	c.maxENI = 1
+	assert.False(t, c.eniHeadroomTooLow())
+
+	// Disabled
+	c.maxENI = 3
+	c.minFreeIPsPerENI = noMinFreeIPsPerENI
+	assert.False(t, c.eniHeadroomTooLow())
+	assert.False(t, c.requiredForENIHeadroom())
+}
+
+func TestGetNearFullENIs(t *testing.T) {
+	c := &IPAMContext{
+		dataStore:    datastoreWith1Pod1(),
+		maxIPsPerENI: 4,
+	}
+	_ = c.dataStore.AddENI(secENIid, secDevice, false)
+	assert.Equal(t, defaultNearFullHeadroom, c.nearFullHeadroom())
+	assert.Empty(t, c.GetNearFullENIs(c.nearFullHeadroom()))
+
+	assert.Equal(t, []NearFullENI{{
+		ENIID:        primaryENIid,
+		DeviceNumber: 1,
+		AssignedIPs:  1,
+		AllocatedIPs: 3,
+		MaxIPs:       4,
+		Headroom:     3,
+	}}, c.GetNearFullENIs(3))
+
+	nearFull := c.GetNearFullENIs(4)
+	assert.Equal(t, 2, len(nearFull))
+	assert.Equal(t, primaryENIid, nearFull[0].ENIID)
+	assert.Equal(t, secENIid, nearFull[1].ENIID)
+
+	c.minFreeIPsPerENI = 3
+	assert.Equal(t, 3, c.nearFullHeadroom())
+}
+
+func TestGetK8SStatus(t *testing.T) {
+	ctrl, _, mockK8S, _, _, mockENIConfig := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		k8sClient: mockK8S,
+		eniConfig: mockENIConfig,
+	}
+	pods := k8sapi.WatchStatus{Started: true, Synced: true, LastResourceVersion: "42", Events: 3, WatchErrors: 1}
+	mockK8S.EXPECT().K8SGetWatchStatus().Return(pods).Times(2)
+	assert.Equal(t, K8SStatus{Pods: pods}, c.GetK8SStatus())
+
+	c.useCustomNetworking = true
+	eniConfigs := k8sapi.WatchStatus{Started: true, ListErrors: 2}
+	mockENIConfig.EXPECT().GetWatchStatus().Return(eniConfigs)
+	assert.Equal(t, K8SStatus{Pods: pods, ENIConfigs: &eniConfigs}, c.GetK8SStatus())
+}
+
+func TestGetNetworkingMode(t *testing.T) {
+	ctrl, mockAWS, _, _, _, mockENIConfig := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient: mockAWS,
+		eniConfig: mockENIConfig,
+	}
+	mockAWS.EXPECT().GetPrimaryENISubnetID().Return("subnet-primary")
+	mockAWS.EXPECT().GetPrimaryENISecurityGroups().Return([]string{"sg-primary"})
+	assert.Equal(t, NetworkingMode{Subnet: "subnet-primary", SecurityGroups: []string{"sg-primary"}}, c.GetNetworkingMode())
+
+	c.useCustomNetworking = true
+	mockENIConfig.EXPECT().Getter().Return(&eniconfig.ENIConfigInfo{MyENI: "us-west-2a"}).Times(2)
+	mockENIConfig.EXPECT().MyENIConfig().Return(nil, eniconfig.ErrNoENIConfig)
+	mode := c.GetNetworkingMode()
+	assert.True(t, mode.CustomNetworking)
+	assert.Equal(t, "us-west-2a", mode.ENIConfig)
+	assert.False(t, mode.ENIConfigFound)
+	assert.Equal(t, eniconfig.ErrNoENIConfig.Error(), mode.Error)
+
+	mockENIConfig.EXPECT().MyENIConfig().Return(&v1alpha1.ENIConfigSpec{SecurityGroups: []string{"sg-pods"}, Subnet: "subnet-pods"}, nil)
+	assert.Equal(t, NetworkingMode{
+		CustomNetworking: true,
+		ENIConfig:        "us-west-2a",
+		ENIConfigFound:   true,
+		Subnet:           "subnet-pods",
+		SecurityGroups:   []string{"sg-pods"},
+	}, c.GetNetworkingMode())
+}
+
+func TestCheckOrphanedIPs(t *testing.T) {
+	ctrl, _, mockK8S, mockCRI, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		k8sClient:        mockK8S,
+		criClient:        mockCRI,
+		dataStore:        datastoreWith3FreeIPs(),
+		orphanedIPPolicy: orphanedIPReport,
+	}
+	for i, sandbox := range []string{"sandbox-1", "sandbox-2", "sandbox-3"} {
+		_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{
+			Name: fmt.Sprintf("pod-%d", i+1), Namespace: "ns-1", Sandbox: sandbox})
+	}
+	// pod-1 is known to the API server and pod-2 still has a running sandbox
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{{Name: "pod-1", Namespace: "ns-1"}}, nil).Times(2)
+	mockCRI.EXPECT().GetRunningPodSandboxes().Return(map[string]*cri.SandboxInfo{
+		"uid-2": {ID: "sandbox-2", Name: "pod-2", Namespace: "ns-1", K8SUID: "uid-2"}}, nil).Times(2)
+
+	c.checkOrphanedIPsIfDue()
+	stats := c.GetOrphanedIPStats()
+	assert.Empty(t, stats.LastError)
+	assert.Equal(t, 1, len(stats.Orphaned))
+	assert.Equal(t, "pod-3", stats.Orphaned[0].Name)
+	assert.Equal(t, "sandbox-3", stats.Orphaned[0].Sandbox)
+	// Only reporting, the check is not done again before orphanedIPReportCheckInterval
+	c.orphanedIPs.lastCheck = time.Now().Add(-2 * orphanedIPReleaseCheckInterval)
+	c.checkOrphanedIPsIfDue()
+
+	c.orphanedIPPolicy = orphanedIPRelease
+	c.checkOrphanedIPsIfDue()
+	stats = c.GetOrphanedIPStats()
+	assert.Empty(t, stats.Orphaned)
+	assert.Equal(t, int64(1), stats.Released)
+	total, assigned := c.dataStore.GetStats()
+	assert.Equal(t, 3, total)
+	assert.Equal(t, 2, assigned)
+
+	// Without the pods of the API server, nothing is orphaned
+	c.orphanedIPs.lastCheck = time.Time{}
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return(nil, k8sapi.ErrInformerNotSynced)
+	c.checkOrphanedIPsIfDue()
+	assert.Contains(t, c.GetOrphanedIPStats().LastError, "failed to get the local pods")
+}
+
+func TestCheckBranchENIs(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:                   mockAWS,
+		k8sClient:                   mockK8S,
+		branchENICleanupPolicy:      branchENICleanupReport,
+		branchENICleanupGracePeriod: time.Hour,
+	}
+
+	// Without a trunk ENI, nothing is checked
+	c.branchENICleanup.setTrunkENI([]awsutils.ENIMetadata{{ENIID: primaryENIid}})
+	c.checkBranchENIsIfDue()
+	assert.Equal(t, 0, len(c.GetBranchENICleanupStats().Orphaned))
+
+	c.branchENICleanup.setTrunkENI([]awsutils.ENIMetadata{
+		{ENIID: primaryENIid},
+		{ENIID: "eni-trunk", InterfaceType: awsutils.InterfaceTypeTrunk},
+	})
+	mockAWS.EXPECT().GetBranchENIs(gomock.Any(), "eni-trunk").Return([]awsutils.BranchENI{
+		{ENIID: "eni-branch1", VlanID: 1},
+		{ENIID: "eni-branch2", VlanID: 2},
+	}, nil).Times(2)
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{
+		{Name: "pod1", Namespace: "default", BranchENIs: []k8sapi.PodBranchENI{{ENIID: "eni-branch1", VlanID: 1}}},
+	}, nil).Times(2)
+
+	// The branch ENI no pod lists is reported
+	assert.NoError(t, c.checkBranchENIs("eni-trunk"))
+	stats := c.GetBranchENICleanupStats()
+	assert.Equal(t, "eni-trunk", stats.TrunkENI)
+	assert.Equal(t, 1, len(stats.Orphaned))
+	assert.Equal(t, "eni-branch2", stats.Orphaned[0].ENIID)
+
+	// It is not deleted before the grace period
+	c.branchENICleanupPolicy = branchENICleanupDelete
+	assert.NoError(t, c.checkBranchENIs("eni-trunk"))
+	assert.Equal(t, int64(0), c.GetBranchENICleanupStats().Deleted)
+
+	// While the annotation of a pod cannot be parsed, the branch ENI it may list is not deleted
+	c.branchENICleanupGracePeriod = 0
+	mockAWS.EXPECT().GetBranchENIs(gomock.Any(), "eni-trunk").Return([]awsutils.BranchENI{{ENIID: "eni-branch2", VlanID: 2}}, nil)
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{
+		{Name: "pod2", Namespace: "default", BranchENIsUnknown: true},
+	}, nil)
+	err := c.checkBranchENIs("eni-trunk")
+	assert.EqualError(t, err, "not deleting the orphaned branch ENIs, the vpc.amazonaws.com/pod-eni annotation of pods "+
+		"default/pod2 cannot be parsed")
+	stats = c.GetBranchENICleanupStats()
+	assert.Equal(t, 1, len(stats.Orphaned))
+	assert.Equal(t, 0, len(stats.Recent))
+
+	// A failed deletion is retried at the next check
+	mockAWS.EXPECT().GetBranchENIs(gomock.Any(), "eni-trunk").Return([]awsutils.BranchENI{{ENIID: "eni-branch2", VlanID: 2}}, nil)
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return(nil, nil)
+	mockAWS.EXPECT().DeleteBranchENI(gomock.Any(), "eni-branch2").Return(errors.New("throttled"))
+	assert.NoError(t, c.checkBranchENIs("eni-trunk"))
+	stats = c.GetBranchENICleanupStats()
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, 1, len(stats.Orphaned))
+	assert.Equal(t, "throttled", stats.Recent[0].Error)
+
+	mockAWS.EXPECT().GetBranchENIs(gomock.Any(), "eni-trunk").Return([]awsutils.BranchENI{{ENIID: "eni-branch2", VlanID: 2}}, nil)
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return(nil, nil)
+	mockAWS.EXPECT().DeleteBranchENI(gomock.Any(), "eni-branch2").Return(nil)
+	assert.NoError(t, c.checkBranchENIs("eni-trunk"))
+	stats = c.GetBranchENICleanupStats()
+	assert.Equal(t, int64(1), stats.Deleted)
+	assert.Equal(t, 0, len(stats.Orphaned))
+	assert.Equal(t, 2, len(stats.Recent))
+
+	// Without the pods of the node, the branch ENIs are not checked
+	mockAWS.EXPECT().GetBranchENIs(gomock.Any(), "eni-trunk").Return([]awsutils.BranchENI{{ENIID: "eni-branch2", VlanID: 2}}, nil)
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return(nil, k8sapi.ErrInformerNotSynced)
+	c.branchENICleanup.lastCheck = time.Time{}
+	c.checkBranchENIsIfDue()
+	assert.NotEmpty(t, c.GetBranchENICleanupStats().LastError)
+}
+
+func TestNodeIPQuota(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:                mockAWS,
+		dataStore:                datastoreWith3Pods(),
+		maxIPsPerENI:             14,
+		maxENI:                   4,
+		warmENITarget:            1,
+		nodeIPQuota:              3,
+		datastoreFullPolicy:      datastoreFullWait,
+		datastoreFullWaitTimeout: time.Minute,
+	}
+	// The pool is too low but the quota is reached, no IP or ENI is allocated
+	assert.True(t, c.nodeIPPoolTooLow())
+	c.increaseIPPool()
+	stats := c.GetPoolStats().NodeIPQuota
+	assert.Equal(t, NodeIPQuotaStats{Quota: 3, Used: 3, Reached: true, Blocked: 1}, stats)
+
+	// An ADD does not wait for the pool to grow
+	_, _, err := c.assignPodIPv4Address(context.Background(), &k8sapi.K8SPodInfo{Name: "pod-4", Namespace: "ns-1"})
+	assert.Equal(t, datastore.ErrNoAvailableIPs, err)
+	assert.Equal(t, int64(0), c.GetPoolStats().DatastoreFull.Waits)
+	assert.Equal(t, float64(60), c.GetPoolStats().DatastoreFull.WaitTimeoutSeconds)
+
+	// Only the IPs left in the quota are allocated
+	c.nodeIPQuota = 5
+	assert.Equal(t, 2, c.capToNodeIPQuota(11))
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, 2).Return(nil, nil)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(nil, nil, nil, errors.New("describe failed"))
+	increased, _ := c.tryAssignIPs()
+	assert.True(t, increased)
+
+	c.nodeIPQuota = noNodeIPQuota
+	assert.Equal(t, 11, c.capToNodeIPQuota(11))
+	assert.False(t, c.nodeIPQuotaReached())
+}
+
+func TestShrinkMode(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:       mockAWS,
+		k8sClient:       mockK8S,
+		dataStore:       datastoreWith3FreeIPs(),
+		maxIPsPerENI:    14,
+		maxENI:          4,
+		warmENITarget:   1,
+		scaleDownShrink: true,
+	}
+	c.reconcileCooldownCache.cache = make(map[string]time.Time)
+	assert.True(t, c.nodeIPPoolTooLow())
+	assert.False(t, c.nodeIPPoolTooHigh())
+
+	// The cluster autoscaler marks the node for deletion, the free IPs are released
+	mockK8S.EXPECT().K8SGetNodeScaleDownReason().Return("taint ToBeDeletedByClusterAutoscaler", nil)
+	c.checkShrinkModeIfDue()
+	stats := c.GetPoolStats().ShrinkMode
+	assert.True(t, stats.Enabled)
+	assert.True(t, stats.Active)
+	assert.Equal(t, "taint ToBeDeletedByClusterAutoscaler", stats.Reason)
+	assert.False(t, c.nodeIPPoolTooLow())
+	assert.True(t, c.nodeIPPoolTooHigh())
+	assert.True(t, c.shouldRemoveExtraENIs())
+	mockAWS.EXPECT().DeallocIPAddresses(gomock.Any(), primaryENIid, gomock.Any()).Return(nil)
+	c.decreaseIPPool(0)
+	total, _ := c.dataStore.GetStats()
+	assert.Equal(t, 0, total)
+
+	// Checks are rate limited, and a failed one keeps the mode
+	c.checkShrinkModeIfDue()
+	c.shrinkMode.stats.LastCheck = time.Time{}
+	mockK8S.EXPECT().K8SGetNodeScaleDownReason().Return("", errors.New("API server unavailable"))
+	c.checkShrinkModeIfDue()
+	assert.True(t, c.inShrinkMode())
+	assert.Equal(t, "API server unavailable", c.GetPoolStats().ShrinkMode.LastError)
+
+	c.shrinkMode.stats.LastCheck = time.Time{}
+	mockK8S.EXPECT().K8SGetNodeScaleDownReason().Return("", nil)
+	c.checkShrinkModeIfDue()
+	assert.False(t, c.inShrinkMode())
+	assert.True(t, c.nodeIPPoolTooLow())
+}
+
+func TestIdlePoolShrink(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:               mockAWS,
+		dataStore:               datastoreWith1Pod1(),
+		maxIPsPerENI:            14,
+		maxENI:                  4,
+		warmENITarget:           1,
+		idlePoolShrinkThreshold: 50,
+		idlePoolShrinkDuration:  time.Hour,
+	}
+	c.reconcileCooldownCache.cache = make(map[string]time.Time)
+	assert.False(t, c.nodeIPPoolTooHigh())
+
+	// 1 of the 3 IPs is assigned, the pool must stay idle for the duration before it is shrunk
+	c.checkIdlePool()
+	stats := c.GetPoolStats().IdleShrink
+	assert.Equal(t, 50, stats.Threshold)
+	assert.InDelta(t, 33.3, stats.Utilization, 0.1)
+	assert.False(t, stats.BelowSince.IsZero())
+	assert.False(t, stats.Active)
+	assert.False(t, c.nodeIPPoolTooHigh())
+
+	c.idleShrink.stats.BelowSince = time.Now().Add(-time.Hour)
+	c.checkIdlePool()
+	stats = c.GetPoolStats().IdleShrink
+	assert.True(t, stats.Active)
+	assert.Equal(t, int64(1), stats.Shrinks)
+	assert.Contains(t, stats.Trigger, "utilization 33.3% below IDLE_POOL_SHRINK_THRESHOLD 50%")
+	assert.Equal(t, idleShrinkWarmIPTarget, c.effectiveWarmIPTarget())
+	assert.True(t, c.nodeIPPoolTooHigh())
+	mockAWS.EXPECT().DeallocIPAddresses(gomock.Any(), primaryENIid, gomock.Any()).Return(nil)
+	c.decreaseIPPool(0)
+	total, _ := c.dataStore.GetStats()
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 3, c.GetPoolStats().IdleShrink.UnshrunkTotal)
+
+	// The shrunk pool is half assigned, but against the pool before the shrink, it stays idle over the next checks
+	for i := 0; i < 3; i++ {
+		c.checkIdlePool()
+		stats = c.GetPoolStats().IdleShrink
+		assert.True(t, stats.Active)
+		assert.InDelta(t, 33.3, stats.Utilization, 0.1)
+		assert.Equal(t, int64(1), stats.Shrinks)
+		assert.False(t, c.nodeIPPoolTooLow())
+		assert.False(t, c.nodeIPPoolTooHigh())
+	}
+
+	// A new pod brings the utilization back above the threshold, the warm targets are restored
+	_, _, err := c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1"})
+	assert.NoError(t, err)
+	c.checkIdlePool()
+	stats = c.GetPoolStats().IdleShrink
+	assert.False(t, stats.Active)
+	assert.True(t, stats.BelowSince.IsZero())
+	assert.False(t, stats.LastEnd.IsZero())
+	assert.Equal(t, 0, stats.UnshrunkTotal)
+	assert.True(t, c.nodeIPPoolTooLow())
+}
+
+func TestGetPodInfos(t *testing.T) {
+	ctrl, _, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		networkClient: mockNetwork,
+		dataStore:     datastore.NewDataStore(),
+	}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = c.dataStore.AddENI(secENIid, secDevice, false)
+	_ = c.dataStore.AddIPv4AddressToStore(secENIid, ipaddr11)
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", IP: ipaddr01})
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", IP: ipaddr11})
+
+	_, toPod1, _ := net.ParseCIDR(ipaddr01 + "/32")
+	_, toPod2, _ := net.ParseCIDR(ipaddr11 + "/32")
+	mockNetwork.EXPECT().GetRuleList().Return([]netlink.Rule{
+		{Priority: 512, Dst: toPod1, Table: unix.RT_TABLE_MAIN},
+		{Priority: 512, Dst: toPod2, Table: unix.RT_TABLE_MAIN},
+	}, nil)
+	podInfos := c.GetPodInfos()
+	pod1 := podInfos["pod-1_ns-1_"]
+	assert.Equal(t, ipaddr01, pod1.IP)
+	assert.Equal(t, unix.RT_TABLE_MAIN, pod1.RouteTable)
+	assert.Equal(t, 1, len(pod1.Rules))
+	assert.False(t, pod1.RulesMissing)
+	// The rule of the traffic from pod-2 on the secondary ENI is missing
+	pod2 := podInfos["pod-2_ns-1_"]
+	assert.Equal(t, secDevice, pod2.RouteTable)
+	assert.Equal(t, 2, len(pod2.Rules))
+	assert.True(t, pod2.Rules[1].Missing)
+	assert.True(t, pod2.RulesMissing)
+
+	mockNetwork.EXPECT().GetRuleList().Return(nil, errors.New("netlink failed"))
+	assert.Equal(t, "netlink failed", c.GetPodInfos()["pod-1_ns-1_"].RulesError)
+}
+
+func TestGetENIInfos(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient: mockAWS,
+		dataStore: datastore.NewDataStore(),
+	}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = c.dataStore.AddENI(secENIid, secDevice, false)
+
+	// The secondary ENI is no longer attached
+	mockAWS.EXPECT().GetENIMACs().Return(map[string]string{primaryENIid: primaryMAC}, nil)
+	eniInfos := c.GetENIInfos()
+	assert.Equal(t, 1, eniInfos.TotalIPs)
+	primary := eniInfos.ENIIPPools[primaryENIid]
+	assert.Equal(t, primaryMAC, primary.MAC)
+	assert.Equal(t, "network/interfaces/macs/"+primaryMAC+"/", primary.MetadataPath)
+	assert.False(t, primary.MissingFromMetadata)
+	assert.Equal(t, 1, len(primary.IPv4Addresses))
+	assert.True(t, eniInfos.ENIIPPools[secENIid].MissingFromMetadata)
+
+	mockAWS.EXPECT().GetENIMACs().Return(nil, errors.New("IMDS unavailable"))
+	eniInfos = c.GetENIInfos()
+	assert.Equal(t, "IMDS unavailable", eniInfos.MetadataError)
+	assert.False(t, eniInfos.ENIIPPools[secENIid].MissingFromMetadata)
+}
+
 func datastoreWith3FreeIPs() *datastore.DataStore {
 	datastoreWith3FreeIPs := datastore.NewDataStore()
 	_ = datastoreWith3FreeIPs.AddENI(primaryENIid, 1, true)
@@ -564,3 +1246,1161 @@ func datastoreWith3Pods() *datastore.DataStore {
 	_, _, _ = datastoreWith3Pods.AssignPodIPv4Address(&podInfo3)
 	return datastoreWith3Pods
 }
+
+func TestPoolExhaustion(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		dataStore:     datastoreWith3FreeIPs(),
+	}
+
+	// No IP was assigned yet
+	stats := mockContext.getPoolExhaustionStats(3, 0)
+	assert.Equal(t, float64(0), stats.AllocationRate)
+	assert.Equal(t, 3, stats.FreeIPs)
+	assert.Equal(t, time.Duration(0), stats.TimeToExhaustion)
+	assert.False(t, stats.Exhausted)
+
+	// 10 IPs assigned over the last window
+	mockContext = &IPAMContext{dataStore: datastoreWith3FreeIPs()}
+	start := time.Now().Add(-allocationRateWindow)
+	mockContext.allocationRate.record(start, 0)
+	mockContext.allocationRate.record(start.Add(time.Second), 10)
+	stats = mockContext.getPoolExhaustionStats(3, 0)
+	assert.InDelta(t, 3, stats.AllocationRate, 0.001)
+	assert.Equal(t, time.Minute, stats.TimeToExhaustion.Round(time.Second))
+	assert.True(t, stats.ExhaustedAt.After(time.Now()))
+
+	stats = mockContext.getPoolExhaustionStats(3, 3)
+	assert.True(t, stats.Exhausted)
+	assert.Equal(t, time.Duration(0), stats.TimeToExhaustion)
+
+	// The rate decays to zero when no IP is assigned
+	assert.Equal(t, float64(0), mockContext.allocationRate.perMinute(time.Now().Add(time.Hour)))
+}
+
+func TestENIPendingConfirmation(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:                 mockAWS,
+		k8sClient:                 mockK8S,
+		networkClient:             mockNetwork,
+		dataStore:                 datastoreWith3FreeIPs(),
+		eniConsistencyGracePeriod: time.Minute,
+	}
+
+	// A new ENI missing from the instance metadata is not gone yet
+	assert.True(t, mockContext.eniPendingConfirmation(primaryENIid, eniMissingFromMetadata))
+	stats := mockContext.GetENIConsistencyStats()
+	assert.Equal(t, 1, len(stats.Pending))
+	assert.Equal(t, primaryENIid, stats.Pending[0].ENIID)
+	assert.Equal(t, eniMissingFromMetadata, stats.Pending[0].Reason)
+	assert.True(t, stats.Pending[0].Deadline.After(time.Now()))
+
+	// It is confirmed once it is listed
+	mockContext.confirmENI(primaryENIid, eniMissingFromDescribe)
+	assert.Equal(t, 1, len(mockContext.GetENIConsistencyStats().Pending))
+	mockContext.confirmENI(primaryENIid, eniMissingFromMetadata)
+	stats = mockContext.GetENIConsistencyStats()
+	assert.Equal(t, 0, len(stats.Pending))
+	assert.Equal(t, int64(1), stats.Confirmed)
+
+	// Once the grace period is over, it is gone
+	assert.True(t, mockContext.eniPendingConfirmation(primaryENIid, eniMissingFromDescribe))
+	mockContext.eniConsistencyGracePeriod = time.Nanosecond
+	assert.False(t, mockContext.eniPendingConfirmation(primaryENIid, eniMissingFromDescribe))
+	stats = mockContext.GetENIConsistencyStats()
+	assert.Equal(t, 0, len(stats.Pending))
+	assert.Equal(t, int64(1), stats.Expired)
+
+	// Unknown ENIs and a disabled grace period
+	assert.False(t, mockContext.eniPendingConfirmation("eni-unknown", eniMissingFromMetadata))
+	mockContext.eniConsistencyGracePeriod = 0
+	assert.False(t, mockContext.eniPendingConfirmation(primaryENIid, eniMissingFromMetadata))
+}
+
+func TestGetSecurityGroupDrift(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, mockENIConfig := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		eniConfig:     mockENIConfig,
+		dataStore:     datastoreWith3FreeIPs(),
+	}
+	attachedENIs := []awsutils.ENIMetadata{
+		{ENIID: primaryENIid, SecurityGroups: []string{"sg-other"}},
+		{ENIID: secENIid, SecurityGroups: []string{"sg-2", "sg-1"}},
+		{ENIID: "eni-drifted", SecurityGroups: []string{"sg-1", "sg-3"}},
+		{ENIID: "eni-unmanaged", SecurityGroups: []string{"sg-4"}, Tags: map[string]string{eniNoManageTagKey: "true"}},
+	}
+
+	mockContext.attachedENIs.set(attachedENIs)
+
+	// The ENIs get the security groups of the primary ENI, which is not compared
+	mockAWS.EXPECT().GetPrimaryENISecurityGroups().Return([]string{"sg-1", "sg-2"})
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	drift, err := mockContext.GetSecurityGroupDrift()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sg-1", "sg-2"}, drift.Expected)
+	assert.Equal(t, 1, drift.Drifted)
+	assert.Equal(t, []ENISecurityGroups{
+		{ENIID: secENIid, SecurityGroups: []string{"sg-1", "sg-2"}, Missing: []string{}, Unexpected: []string{}},
+		{ENIID: "eni-drifted", SecurityGroups: []string{"sg-1", "sg-3"}, Missing: []string{"sg-2"},
+			Unexpected: []string{"sg-3"}, Drifted: true},
+	}, drift.ENIs)
+
+	// With custom networking, they get the security groups of the ENIConfig
+	mockContext.useCustomNetworking = true
+	mockENIConfig.EXPECT().MyENIConfig().Return(&v1alpha1.ENIConfigSpec{SecurityGroups: []string{"sg-1", "sg-3"}}, nil)
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	drift, err = mockContext.GetSecurityGroupDrift()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, drift.Drifted)
+	assert.True(t, drift.ENIs[0].Drifted)
+	assert.False(t, drift.ENIs[1].Drifted)
+}
+
+func TestDiagnose(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		dataStore:     datastoreWith3FreeIPs(),
+	}
+	attachedENIs := []awsutils.ENIMetadata{
+		{ENIID: primaryENIid, SecurityGroups: []string{"sg-1"}},
+		{ENIID: secENIid, SecurityGroups: []string{"sg-1"}},
+	}
+
+	mockContext.attachedENIs.set(attachedENIs)
+
+	mockAWS.EXPECT().GetPrimaryENISecurityGroups().Return([]string{"sg-1"}).Times(2)
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid).Times(2)
+	gomock.InOrder(
+		mockNetwork.EXPECT().GetIptablesBackend().Return(networkutils.IptablesBackend{Mode: "legacy"}),
+		mockNetwork.EXPECT().GetIptablesBackend().Return(networkutils.IptablesBackend{Mode: "legacy",
+			OtherModeRules: 12, Mismatch: true}),
+	)
+
+	diagnosis := mockContext.Diagnose()
+	assert.True(t, diagnosis.Passed)
+	assert.Empty(t, diagnosis.Failed)
+	names := make([]string, 0, len(diagnosis.Checks))
+	for _, check := range diagnosis.Checks {
+		names = append(names, check.Name)
+	}
+	assert.Equal(t, []string{"eni-consistency", "connectivity", "sg-drift", "iptables-backend", "primary-eni", "pool"},
+		names)
+	assert.Equal(t, "0 of the 3 IPs are assigned", diagnosis.Checks[5].Message)
+
+	// IMDS cannot be reached and kube-proxy programs the other iptables backend
+	mockContext.recordIMDSResult(errors.New("connection refused"))
+	diagnosis = mockContext.Diagnose()
+	assert.False(t, diagnosis.Passed)
+	assert.Equal(t, []string{"connectivity", "iptables-backend"}, diagnosis.Failed)
+	assert.Equal(t, "iptables uses the legacy backend, but the other one has 12 rules", diagnosis.Checks[3].Message)
+}
+
+func TestENIDeleteAsync(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:             mockAWS,
+		dataStore:             datastore.NewDataStore(),
+		eniDeleteAsync:        true,
+		eniDeleteRetryBackoff: time.Millisecond,
+	}
+	waitForENIDeletes := func() {
+		for deadline := time.Now().Add(5 * time.Second); c.GetENIDeleteStats().Depth > 0; {
+			if time.Now().After(deadline) {
+				t.Fatal("the ENI delete did not end")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The first attempt fails, the retry frees the ENI
+	gomock.InOrder(
+		mockAWS.EXPECT().FreeENI(gomock.Any(), secENIid).Return(errors.New("DetachNetworkInterface timed out")),
+		mockAWS.EXPECT().FreeENI(gomock.Any(), secENIid).Return(nil),
+	)
+	c.enqueueENIDelete(secENIid)
+	waitForENIDeletes()
+	stats := c.GetENIDeleteStats()
+	assert.True(t, stats.Async)
+	assert.Equal(t, int64(1), stats.Deleted)
+	assert.Equal(t, int64(1), stats.Retries)
+	assert.Equal(t, int64(0), stats.Failed)
+	assert.False(t, c.eniDeleteQueued(secENIid))
+
+	// The worker gives up after eniDeleteAttempts attempts
+	mockAWS.EXPECT().FreeENI(gomock.Any(), primaryENIid).Return(errors.New("UnauthorizedOperation")).Times(eniDeleteAttempts)
+	c.enqueueENIDelete(primaryENIid)
+	waitForENIDeletes()
+	stats = c.GetENIDeleteStats()
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, 1, len(stats.Failures))
+	assert.Equal(t, primaryENIid, stats.Failures[0].ENIID)
+	assert.Equal(t, eniDeleteAttempts, stats.Failures[0].Attempts)
+	assert.Equal(t, "UnauthorizedOperation", stats.Failures[0].LastError)
+}
+
+func TestGetIPClassification(t *testing.T) {
+	ds := datastoreWith3FreeIPs()
+	_ = ds.AddIPv4AddressToStore(primaryENIid, "10.10.10.14")
+	_ = ds.SetENIPrimaryIP(primaryENIid, "10.10.10.10")
+	c := &IPAMContext{dataStore: ds, minimumIPTarget: 4}
+
+	pod1 := &k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns-1", Sandbox: "sandbox-1"}
+	pod2 := &k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2"}
+	_, _, err := ds.AssignPodIPv4Address(pod1)
+	assert.NoError(t, err)
+	_, _, err = ds.AssignPodIPv4Address(pod2)
+	assert.NoError(t, err)
+	_, _, err = ds.UnassignPodIPv4Address(pod2)
+	assert.NoError(t, err)
+
+	// Without MINIMUM_IP_TARGET the 2 free IPs would be released, they are kept for it
+	classification := c.GetIPClassification()
+	assert.Equal(t, map[string]int{ipClassExcluded: 1, ipClassAssigned: 1, ipClassCooling: 1, ipClassReserved: 2},
+		classification.Counts)
+	assert.Equal(t, ClassifiedIP{IP: "10.10.10.10", ENI: primaryENIid, Class: ipClassExcluded}, classification.IPs[0])
+	reasons := func() []string {
+		var reasons []string
+		for _, ip := range classification.IPs {
+			if ip.Class == ipClassReserved {
+				reasons = append(reasons, ip.Reason)
+			}
+		}
+		return reasons
+	}
+	assert.Equal(t, []string{envMinimumIPTarget, envMinimumIPTarget}, reasons())
+
+	// The last free IP is kept for RESERVED_IPS, and with a warm IP target of 1 the other one is warm
+	c.reservedIPs = 1
+	c.warmIPTarget = 1
+	c.minimumIPTarget = 3
+	classification = c.GetIPClassification()
+	assert.Equal(t, 1, classification.Counts[ipClassWarm])
+	assert.Equal(t, 1, classification.Counts[ipClassReserved])
+	assert.Equal(t, []string{envReservedIPs}, reasons())
+}
+
+func TestCheckENIConfigAZ(t *testing.T) {
+	ctrl, mockAWS, _, _, _, mockENIConfig := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:                 mockAWS,
+		eniConfig:                 mockENIConfig,
+		useCustomNetworking:       true,
+		eniConfigAZMismatchPolicy: eniConfigAZMismatchWarn,
+	}
+	mockAWS.EXPECT().GetAvailabilityZone().Return("us-west-2a").AnyTimes()
+	// The zone of each subnet is described once
+	mockAWS.EXPECT().GetSubnetAvailabilityZone(gomock.Any(), "subnet-a").Return("us-west-2a", nil)
+	mockAWS.EXPECT().GetSubnetAvailabilityZone(gomock.Any(), "subnet-b").Return("us-west-2b", nil)
+
+	assert.NoError(t, c.checkENIConfigAZ("subnet-a"))
+	assert.NoError(t, c.checkENIConfigAZ("subnet-a"))
+	assert.NoError(t, c.checkENIConfigAZ("subnet-b"))
+	c.eniConfigAZMismatchPolicy = eniConfigAZMismatchBlock
+	assert.Equal(t, errENIConfigAZMismatch, c.checkENIConfigAZ("subnet-b"))
+
+	mockENIConfig.EXPECT().Getter().Return(&eniconfig.ENIConfigInfo{MyENI: "us-west-2b"})
+	mockENIConfig.EXPECT().MyENIConfig().Return(&v1alpha1.ENIConfigSpec{Subnet: "subnet-b"}, nil)
+	status := c.GetENIConfigStatus()
+	assert.Equal(t, "us-west-2b", status.MyENI)
+	assert.True(t, status.AZCheck.Mismatch)
+	assert.Equal(t, "us-west-2a", status.AZCheck.NodeAZ)
+	assert.Equal(t, "us-west-2b", status.AZCheck.SubnetAZ)
+	assert.Equal(t, eniConfigAZMismatchBlock, status.AZCheck.Policy)
+}
+
+func TestENIProvisionErrors(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:    mockAWS,
+		dataStore:    datastore.NewDataStore(),
+		maxIPsPerENI: 14,
+	}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+
+	// Both the allocation of the missing IPs and of one IP fail, twice
+	allocErr := errors.New("UnauthorizedOperation")
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, gomock.Any()).Return(nil, allocErr).Times(4)
+	for i := 0; i < 2; i++ {
+		_, err := c.tryAssignIPs()
+		assert.Error(t, err)
+	}
+	mockAWS.EXPECT().GetENIMACs().Return(map[string]string{primaryENIid: primaryMAC}, nil)
+	provisionErrors := c.GetENIInfos().ENIIPPools[primaryENIid].ProvisionErrors
+	assert.Equal(t, int64(2), provisionErrors.Count)
+	assert.Equal(t, 2, provisionErrors.Consecutive)
+	assert.Equal(t, allocErr.Error(), provisionErrors.LastError)
+
+	// Once IPs are allocated, the consecutive failures are reset
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, 13).Return(nil, nil)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return([]*ec2.NetworkInterfacePrivateIpAddress{
+		{PrivateIpAddress: aws.String(ipaddr01), Primary: aws.Bool(true)},
+		{PrivateIpAddress: aws.String(ipaddr02), Primary: aws.Bool(false)},
+	}, nil, nil, nil)
+	increased, err := c.tryAssignIPs()
+	assert.NoError(t, err)
+	assert.True(t, increased)
+	provisionErrors = c.getENIProvisionErrors(primaryENIid)
+	assert.Equal(t, int64(2), provisionErrors.Count)
+	assert.Equal(t, 0, provisionErrors.Consecutive)
+
+	c.forgetENIProvisionErrors(primaryENIid)
+	assert.Nil(t, c.getENIProvisionErrors(primaryENIid))
+}
+
+func TestPartialAllocation(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:          mockAWS,
+		dataStore:          datastore.NewDataStore(),
+		maxIPsPerENI:       4,
+		partialAllocPolicy: partialAllocAccept,
+	}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+
+	// Only the IPs EC2 returned are tracked, even when the ENI description does not list them yet
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, 3).Return([]string{ipaddr02}, nil)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(nil, nil, nil, errors.New("describe failed"))
+	increased, _ := c.tryAssignIPs()
+	assert.True(t, increased)
+	total, _ := c.dataStore.GetStats()
+	assert.Equal(t, 2, total)
+	stats := c.GetPartialAllocStats()
+	assert.Equal(t, int64(1), stats.Count)
+	assert.Equal(t, int64(2), stats.ShortIPs)
+	assert.Equal(t, PartialAllocation{ENIID: primaryENIid, Time: stats.Recent[0].Time, Requested: 3, Allocated: 1},
+		stats.Recent[0])
+
+	// The shortfall is requested again until EC2 returns no IP
+	c.partialAllocPolicy = partialAllocRetry
+	gomock.InOrder(
+		mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, 2).Return([]string{"10.10.10.13"}, nil),
+		mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, 1).Return([]string{"10.10.10.14"}, nil),
+	)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(nil, nil, nil, errors.New("describe failed"))
+	increased, _ = c.tryAssignIPs()
+	assert.True(t, increased)
+	total, _ = c.dataStore.GetStats()
+	assert.Equal(t, 4, total)
+	assert.Equal(t, int64(1), c.GetPartialAllocStats().Count)
+
+	c.maxIPsPerENI = 6
+	gomock.InOrder(
+		mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, 2).Return([]string{"10.10.10.15"}, nil),
+		mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, 1).Return(nil, nil),
+	)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(nil, nil, nil, errors.New("describe failed"))
+	increased, _ = c.tryAssignIPs()
+	assert.True(t, increased)
+	stats = c.GetPartialAllocStats()
+	assert.Equal(t, int64(2), stats.Count)
+	assert.Equal(t, int64(3), stats.ShortIPs)
+	assert.Equal(t, 1, stats.Recent[1].Retries)
+}
+
+func TestAllocIdempotency(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:              mockAWS,
+		dataStore:              datastore.NewDataStore(),
+		maxIPsPerENI:           3,
+		allocIdempotencyPolicy: allocIdempotencyRecover,
+	}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+
+	// The call times out but EC2 allocated the IPs, they are kept instead of allocating one more IP
+	timeoutErr := errors.New("RequestError: send request failed: timeout")
+	eniIPs := []*ec2.NetworkInterfacePrivateIpAddress{
+		{PrivateIpAddress: aws.String("10.10.10.1"), Primary: aws.Bool(true)},
+		{PrivateIpAddress: aws.String(ipaddr01), Primary: aws.Bool(false)},
+		{PrivateIpAddress: aws.String(ipaddr02), Primary: aws.Bool(false)},
+		{PrivateIpAddress: aws.String(ipaddr03), Primary: aws.Bool(false)},
+	}
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, 2).Return(nil, timeoutErr)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(eniIPs, nil, nil, nil)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(nil, nil, nil, errors.New("describe failed"))
+	increased, _ := c.tryAssignIPs()
+	assert.True(t, increased)
+	total, _ := c.dataStore.GetStats()
+	assert.Equal(t, 3, total)
+	stats := c.GetAllocIdempotencyStats()
+	assert.Equal(t, int64(1), stats.Checks)
+	assert.Equal(t, int64(1), stats.Recoveries)
+	assert.Equal(t, int64(2), stats.RecoveredIPs)
+	assert.Equal(t, AllocRecovery{ENIID: primaryENIid, Time: stats.Recent[0].Time, Requested: 2,
+		IPs: []string{ipaddr02, ipaddr03}, Error: timeoutErr.Error()}, stats.Recent[0])
+
+	// Nothing to recover, the error is returned
+	c.maxIPsPerENI = 4
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, gomock.Any()).Return(nil, timeoutErr).Times(2)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(eniIPs, nil, nil, nil)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), primaryENIid).Return(nil, nil, nil, errors.New("describe failed"))
+	_, err := c.tryAssignIPs()
+	assert.Error(t, err)
+	stats = c.GetAllocIdempotencyStats()
+	assert.Equal(t, int64(3), stats.Checks)
+	assert.Equal(t, int64(1), stats.Recoveries)
+	assert.Equal(t, int64(1), stats.CheckErrors)
+
+	// With the "off" policy, the ENI is not described
+	c.allocIdempotencyPolicy = allocIdempotencyOff
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), primaryENIid, gomock.Any()).Return(nil, timeoutErr).Times(2)
+	_, err = c.tryAssignIPs()
+	assert.Error(t, err)
+	assert.Equal(t, int64(3), c.GetAllocIdempotencyStats().Checks)
+}
+
+func TestDetachedENIPods(t *testing.T) {
+	ctrl, _, mockK8S, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		k8sClient:            mockK8S,
+		dataStore:            datastore.NewDataStore(),
+		detachedENIPodPolicy: detachedENIPodEvent,
+	}
+	_ = c.dataStore.AddENI(secENIid, secDevice, false)
+	_ = c.dataStore.AddIPv4AddressToStore(secENIid, ipaddr11)
+	_ = c.dataStore.AddIPv4AddressToStore(secENIid, ipaddr12)
+	for _, name := range []string{"pod-1", "pod-2"} {
+		_, _, err := c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: name, Namespace: "ns", Sandbox: name})
+		assert.NoError(t, err)
+	}
+
+	// The ENI is detached out-of-band, a Warning event is created on each of its pods
+	pods := c.dataStore.GetENIPods(secENIid)
+	assert.Equal(t, 2, len(pods))
+	assert.NoError(t, c.dataStore.RemoveENIFromDataStore(secENIid, true))
+	mockK8S.EXPECT().K8SRecordPodEvent("pod-1", "ns", detachedENIPodEventReason, gomock.Any()).Return(nil)
+	mockK8S.EXPECT().K8SRecordPodEvent("pod-2", "ns", detachedENIPodEventReason, gomock.Any()).Return(
+		errors.New("forbidden"))
+	c.reportDetachedENIPods(secENIid, pods)
+
+	stats := c.GetDetachedENIPodStats()
+	assert.Equal(t, detachedENIPodEvent, stats.Policy)
+	assert.Equal(t, int64(1), stats.ENIs)
+	assert.Equal(t, int64(2), stats.Pods)
+	assert.Equal(t, "ns/pod-1", stats.Recent[0].Pod)
+	assert.Equal(t, secENIid, stats.Recent[0].ENIID)
+	assert.True(t, stats.Recent[0].Event)
+	assert.False(t, stats.Recent[1].Event)
+	assert.Equal(t, "forbidden", stats.Recent[1].EventError)
+
+	// With the "log" policy, or an ENI without pods, no event is created
+	c.detachedENIPodPolicy = detachedENIPodLog
+	c.reportDetachedENIPods(secENIid, pods)
+	c.reportDetachedENIPods(secENIid, nil)
+	stats = c.GetDetachedENIPodStats()
+	assert.Equal(t, int64(2), stats.ENIs)
+	assert.Equal(t, int64(4), stats.Pods)
+	assert.False(t, stats.Recent[3].Event)
+}
+
+func TestIPAssignmentAges(t *testing.T) {
+	c := &IPAMContext{
+		dataStore:                datastore.NewDataStore(),
+		maxIPAssignmentAge:       time.Millisecond,
+		maxIPAssignmentAgePolicy: ipAssignmentAgeFlag,
+	}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	pod1 := &k8sapi.K8SPodInfo{Name: "pod-1", Namespace: "ns", Sandbox: "sandbox-1"}
+	pod2 := &k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns", Sandbox: "sandbox-2"}
+	ip1, _, _ := c.dataStore.AssignPodIPv4Address(pod1)
+	ip2, _, _ := c.dataStore.AssignPodIPv4Address(pod2)
+	time.Sleep(2 * time.Millisecond)
+
+	// Both assignments are flagged once, the oldest first
+	c.checkIPAssignmentAges()
+	c.checkIPAssignmentAges()
+	stats := c.GetIPAssignmentAgeStats()
+	assert.Equal(t, int64(2), stats.Flagged)
+	assert.Equal(t, 2, len(stats.Aged))
+	assert.Equal(t, "ns/pod-1", stats.Aged[0].Pod)
+	assert.Equal(t, ip1, stats.Aged[0].IP)
+	assert.True(t, stats.Aged[0].Age >= 2*time.Millisecond)
+
+	// With the "flag" policy, the released IP may be given back to the pod
+	assert.False(t, c.releaseAgedIP(ip1))
+	c.maxIPAssignmentAgePolicy = ipAssignmentAgeReassign
+	assert.True(t, c.releaseAgedIP(ip2))
+	assert.False(t, c.releaseAgedIP(ip2))
+	stats = c.GetIPAssignmentAgeStats()
+	assert.Equal(t, int64(1), stats.Reassigned)
+	assert.Equal(t, 0, len(stats.Aged))
+
+	// When MAX_IP_ASSIGNMENT_AGE is not set, nothing is checked
+	c.maxIPAssignmentAge = noMaxIPAssignmentAge
+	c.checkIPAssignmentAges()
+	assert.Equal(t, int64(2), c.GetIPAssignmentAgeStats().Flagged)
+}
+
+func TestENIConfigChanges(t *testing.T) {
+	ctrl, mockAWS, _, _, _, mockENIConfig := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:             mockAWS,
+		eniConfig:             mockENIConfig,
+		dataStore:             datastore.NewDataStore(),
+		eniConfigChangePolicy: eniConfigChangeFlag,
+	}
+	_ = c.dataStore.AddENI(secENIid, secDevice, false)
+	_ = c.dataStore.AddIPv4AddressToStore(secENIid, ipaddr11)
+	created := &v1alpha1.ENIConfigSpec{Subnet: "subnet1", SecurityGroups: []string{"sg1-id", "sg2-id"}}
+	updated := &v1alpha1.ENIConfigSpec{Subnet: "subnet2", SecurityGroups: []string{"sg2-id", "sg1-id"}}
+
+	// The order of the security groups does not matter
+	mockENIConfig.EXPECT().MyENIConfig().Return(&v1alpha1.ENIConfigSpec{Subnet: "subnet1",
+		SecurityGroups: []string{"sg2-id", "sg1-id"}}, nil)
+	c.checkENIConfigChange(secENIid, created)
+	assert.Equal(t, int64(0), c.GetENIConfigChangeStats().Changes)
+
+	mockENIConfig.EXPECT().MyENIConfig().Return(updated, nil)
+	c.checkENIConfigChange(secENIid, created)
+	stats := c.GetENIConfigChangeStats()
+	assert.Equal(t, int64(1), stats.Changes)
+	assert.Equal(t, 1, len(stats.Stale))
+	assert.Equal(t, "subnet1", stats.Stale[0].CreatedSubnet)
+	assert.Equal(t, "subnet2", stats.Stale[0].Subnet)
+
+	// With the "flag" policy, the ENI stays stale
+	mockENIConfig.EXPECT().MyENIConfig().Return(updated, nil)
+	c.reevaluateENIConfigChanges()
+	assert.Equal(t, 1, len(c.GetENIConfigChangeStats().Stale))
+
+	// With the "replace" policy, the ENI is not freed while a pod uses its IP
+	c.eniConfigChangePolicy = eniConfigChangeReplace
+	_, _, _ = c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", Sandbox: "sandbox"})
+	mockENIConfig.EXPECT().MyENIConfig().Return(updated, nil)
+	c.reevaluateENIConfigChanges()
+	assert.Equal(t, 1, len(c.GetENIConfigChangeStats().Stale))
+
+	_, _, _ = c.dataStore.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", Sandbox: "sandbox"})
+	mockENIConfig.EXPECT().MyENIConfig().Return(updated, nil)
+	mockAWS.EXPECT().FreeENI(gomock.Any(), secENIid).Return(nil)
+	c.reevaluateENIConfigChanges()
+	stats = c.GetENIConfigChangeStats()
+	assert.Equal(t, 0, len(stats.Stale))
+	assert.Equal(t, int64(1), stats.Replaced)
+	assert.Equal(t, eniConfigChangeReplaced, stats.Recent[len(stats.Recent)-1].Resolution)
+
+	// An ENI the ENIConfig matches again is no longer stale
+	_ = c.dataStore.AddENI(secENIid, secDevice, false)
+	mockENIConfig.EXPECT().MyENIConfig().Return(updated, nil)
+	c.checkENIConfigChange(secENIid, created)
+	mockENIConfig.EXPECT().MyENIConfig().Return(created, nil)
+	c.reevaluateENIConfigChanges()
+	stats = c.GetENIConfigChangeStats()
+	assert.Equal(t, 0, len(stats.Stale))
+	assert.Equal(t, eniConfigChangeReverted, stats.Recent[len(stats.Recent)-1].Resolution)
+}
+
+func TestEffectivePolicy(t *testing.T) {
+	c := &IPAMContext{
+		dataStore:     datastore.NewDataStore(),
+		maxIPsPerENI:  14,
+		warmENITarget: 1,
+	}
+
+	// Without a warm or minimum IP target, WARM_ENI_TARGET sizes the pool
+	policy := c.GetEffectivePolicy()
+	assert.Equal(t, warmPolicyWarmENI, policy.Mode)
+	assert.Equal(t, 1, policy.WarmENITarget)
+	assert.Equal(t, 1, len(policy.Reasons))
+	assert.Equal(t, envWarmENITarget, policy.Reasons[0].Setting)
+
+	// WARM_IP_TARGET takes over, and the subnet pressure lowers it
+	c.warmIPTarget = 5
+	c.minimumIPTarget = 10
+	c.nodeIPQuota = 20
+	c.subnetPressure.pressured = true
+	policy = c.GetEffectivePolicy()
+	assert.Equal(t, warmPolicyWarmIP, policy.Mode)
+	assert.Equal(t, subnetPressureWarmIPTarget, policy.WarmIPTarget)
+	assert.Equal(t, c.effectiveWarmIPTarget(), policy.WarmIPTarget)
+	assert.Equal(t, 10, policy.MinimumIPTarget)
+	assert.Equal(t, 0, policy.WarmENITarget)
+	var settings []string
+	for _, reason := range policy.Reasons {
+		settings = append(settings, reason.Setting)
+	}
+	assert.Equal(t, []string{envSubnetPressureThreshold, envWarmIPTarget, envMinimumIPTarget, envWarmENITarget,
+		envNodeIPQuota}, settings)
+
+	// In shrink-only mode, no target applies
+	c.shrinkMode.stats.Active = true
+	policy = c.GetEffectivePolicy()
+	assert.Equal(t, warmPolicyShrinkOnly, policy.Mode)
+	assert.Equal(t, 0, policy.WarmIPTarget)
+	assert.Equal(t, envScaleDownShrink, policy.Reasons[0].Setting)
+}
+
+func TestClampWarmTargets(t *testing.T) {
+	c := &IPAMContext{
+		maxENI:          4,
+		unmanagedENI:    1,
+		maxIPsPerENI:    14,
+		warmIPTarget:    100,
+		minimumIPTarget: 20,
+		warmENITarget:   5,
+	}
+
+	// The instance holds 3 managed ENIs of 14 IPs
+	c.clampWarmTargets()
+	assert.Equal(t, 42, c.warmIPTarget)
+	assert.Equal(t, 20, c.minimumIPTarget)
+	assert.Equal(t, 3, c.warmENITarget)
+	assert.Equal(t, []WarmTargetClamp{
+		{Name: envWarmIPTarget, Requested: 100, Clamped: 42},
+		{Name: envWarmENITarget, Requested: 5, Clamped: 3},
+	}, c.warmTargetClamps)
+
+	// Targets within the capacity are kept
+	c.clampWarmTargets()
+	assert.Equal(t, 42, c.warmIPTarget)
+	assert.Nil(t, c.warmTargetClamps)
+}
+
+func TestIMDSUnavailablePolicy(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		awsClient:             mockAWS,
+		dataStore:             datastore.NewDataStore(),
+		imdsUnavailablePolicy: imdsUnavailableReadOnly,
+	}
+	connectivity := c.GetConnectivity()
+	assert.True(t, connectivity.IMDS.Available)
+	assert.False(t, connectivity.IMDS.ReadOnly)
+
+	// Every reconcile that cannot reach IMDS counts in the same outage
+	imdsErr := errors.New("EC2MetadataError: failed to make EC2Metadata request")
+	mockAWS.EXPECT().GetAttachedENIs(gomock.Any()).Return(nil, imdsErr).Times(2)
+	c.nodeIPPoolReconcile(0)
+	c.nodeIPPoolReconcile(0)
+	connectivity = c.GetConnectivity()
+	assert.False(t, connectivity.IMDS.Available)
+	assert.True(t, connectivity.IMDS.ReadOnly)
+	assert.Equal(t, int64(1), connectivity.IMDS.Outages)
+	assert.Equal(t, 2, connectivity.IMDS.ConsecutiveFailures)
+	assert.Equal(t, imdsErr.Error(), connectivity.IMDS.LastError)
+	assert.True(t, c.imdsReadOnly())
+
+	// With the default policy, the pool keeps being managed during the outage
+	c.imdsUnavailablePolicy = imdsUnavailableContinue
+	assert.False(t, c.imdsReadOnly())
+	c.imdsUnavailablePolicy = imdsUnavailableReadOnly
+
+	c.recordIMDSResult(nil)
+	connectivity = c.GetConnectivity()
+	assert.True(t, connectivity.IMDS.Available)
+	assert.False(t, connectivity.IMDS.ReadOnly)
+	assert.Equal(t, 0, connectivity.IMDS.ConsecutiveFailures)
+	assert.Equal(t, int64(1), connectivity.IMDS.Outages)
+}
+
+func TestPoolGrowthBatch(t *testing.T) {
+	c := &IPAMContext{
+		dataStore:             datastore.NewDataStore(),
+		poolGrowthTrigger:     make(chan struct{}, 1),
+		poolGrowthBatchWindow: 10 * time.Millisecond,
+		maxENI:                4,
+		maxIPsPerENI:          14,
+		terminating:           int32(1),
+	}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+
+	// The requests of the same pod count once in the batch
+	pods := []*k8sapi.K8SPodInfo{
+		{Name: "pod-1", Namespace: "default", Sandbox: "sandbox-1"},
+		{Name: "pod-2", Namespace: "default", Sandbox: "sandbox-2"},
+		{Name: "pod-3", Namespace: "default", Sandbox: "sandbox-3"},
+		{Name: "pod-1", Namespace: "default", Sandbox: "sandbox-1"},
+	}
+	for _, pod := range pods {
+		c.requestPoolGrowth(pod)
+	}
+	assert.Equal(t, 3, c.GetPoolGrowthBatchStats().Pending)
+	select {
+	case <-c.poolGrowthTrigger:
+	case <-time.After(time.Second):
+		t.Fatal("the pool manager was not woken up")
+	}
+	stats := c.GetPoolGrowthBatchStats()
+	assert.Equal(t, int64(1), stats.Batches)
+	assert.Equal(t, int64(3), stats.Requests)
+	assert.Equal(t, []int{3}, stats.RecentSizes)
+	assert.Equal(t, 0, stats.Pending)
+
+	// The pool is increased for the pods the free IPs do not cover, and stops when it cannot grow
+	c.growPoolForBatches()
+	assert.Equal(t, int64(1), c.GetPoolGrowthBatchStats().Increases)
+	assert.Equal(t, 0, c.takePoolGrowthDemand())
+
+	// Without a window, each request wakes up the pool manager right away
+	c.poolGrowthBatchWindow = noPoolGrowthBatchWindow
+	c.requestPoolGrowth(pods[0])
+	assert.Len(t, c.poolGrowthTrigger, 1)
+	assert.Equal(t, int64(1), c.GetPoolGrowthBatchStats().Batches)
+}
+
+func TestPodStaticRoutes(t *testing.T) {
+	ctrl, _, _, _, _, mockENIConfig := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{eniConfig: mockENIConfig}
+	// Without custom networking, the ENIConfig is not used
+	assert.Nil(t, c.podStaticRoutes(1))
+
+	c.useCustomNetworking = true
+	assert.Nil(t, c.podStaticRoutes(0))
+
+	routes := []v1alpha1.StaticRoute{{Destination: "172.16.0.0/16"}, {Destination: "192.168.100.0/24", Gateway: "100.64.0.5"}}
+	mockENIConfig.EXPECT().MyENIConfig().Return(&v1alpha1.ENIConfigSpec{Subnet: "subnet-1", StaticRoutes: routes}, nil)
+	assert.Equal(t, []*rpc.StaticRoute{
+		{Destination: "172.16.0.0/16"},
+		{Destination: "192.168.100.0/24", Gateway: "100.64.0.5"},
+	}, c.podStaticRoutes(1))
+
+	// A single invalid route prevents all the routes from being applied
+	invalid := append(routes, v1alpha1.StaticRoute{Destination: "10.0.0.0/8", Gateway: "not-an-ip"})
+	mockENIConfig.EXPECT().MyENIConfig().Return(&v1alpha1.ENIConfigSpec{Subnet: "subnet-1", StaticRoutes: invalid}, nil)
+	assert.Nil(t, c.podStaticRoutes(1))
+
+	info := &eniconfig.ENIConfigInfo{ENI: map[string]v1alpha1.ENIConfigSpec{
+		"valid":   {StaticRoutes: routes},
+		"invalid": {StaticRoutes: invalid},
+	}}
+	invalidRoutes := invalidStaticRoutes(info)
+	assert.Len(t, invalidRoutes, 1)
+	assert.Contains(t, invalidRoutes["invalid"], "not-an-ip")
+}
+
+func TestGetActivity(t *testing.T) {
+	c := &IPAMContext{}
+	activity := c.GetActivity()
+	assert.Equal(t, int64(0), activity.Add.Count)
+	assert.Equal(t, time.Duration(0), activity.IdleFor)
+
+	c.activity.recordAdd(nil)
+	c.activity.recordAdd(errors.New("no available IP addresses"))
+	c.activity.recordDel(nil)
+	activity = c.GetActivity()
+	assert.Equal(t, int64(2), activity.Add.Count)
+	assert.Equal(t, int64(1), activity.Add.Failures)
+	assert.False(t, activity.Add.LastSuccess.After(activity.Add.Last))
+	assert.Equal(t, int64(1), activity.Del.Count)
+	assert.Equal(t, int64(0), activity.Del.Failures)
+	assert.Equal(t, activity.Del.SinceLast, activity.IdleFor)
+	assert.True(t, activity.Add.SinceLast >= activity.IdleFor)
+}
+
+func TestGetCNIStats(t *testing.T) {
+	c := &IPAMContext{}
+	stats := c.GetCNIStats()
+	assert.Equal(t, int64(0), stats.Add.Count)
+	assert.Empty(t, stats.Add.LastHour)
+
+	now := time.Now()
+	for i := 1; i <= 10; i++ {
+		c.cniStats.recordAdd(now.Add(-time.Duration(i)*time.Millisecond), nil)
+	}
+	c.cniStats.recordAdd(now.Add(-time.Second), errors.New("no available IP addresses"))
+	c.cniStats.recordDel(now, nil)
+	stats = c.GetCNIStats()
+	assert.Equal(t, int64(11), stats.Add.Count)
+	assert.Equal(t, int64(1), stats.Add.Errors)
+	assert.InDelta(t, 1.0/11, stats.Add.ErrorRate, 0.001)
+	assert.Equal(t, 11, stats.Add.Samples)
+	assert.True(t, stats.Add.LatencyP50 >= 5*time.Millisecond)
+	assert.True(t, stats.Add.LatencyP50 < time.Second)
+	assert.True(t, stats.Add.LatencyMax >= time.Second)
+	lastHourCount := func(minutes []CNIMinuteStats) int64 {
+		total := int64(0)
+		for _, minute := range minutes {
+			total += minute.Count
+		}
+		return total
+	}
+	assert.Equal(t, int64(11), lastHourCount(stats.Add.LastHour))
+	assert.Equal(t, int64(1), stats.Del.Count)
+	assert.Equal(t, 0.0, stats.Del.ErrorRate)
+
+	// A bucket older than an hour is left out
+	old := now.Add(-90 * time.Minute).Truncate(time.Minute)
+	c.cniStats.add.minutes[(old.Unix()/60)%cniStatsMinutes] = CNIMinuteStats{Minute: old, Count: 5}
+	assert.Equal(t, int64(11), lastHourCount(c.GetCNIStats().Add.LastHour))
+}
+
+func TestAdaptiveWarmIPTarget(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:                   mockAWS,
+		k8sClient:                   mockK8S,
+		networkClient:               mockNetwork,
+		dataStore:                   datastoreWith3FreeIPs(),
+		maxIPsPerENI:                14,
+		warmIPTarget:                3,
+		adaptiveWarmIPTargetEnabled: true,
+		adaptiveWarmIPTargetWindow:  defaultAdaptiveWarmIPTargetWindow,
+		adaptiveWarmIPTargetMin:     defaultAdaptiveWarmIPTargetMin,
+	}
+
+	// Without churn, the target is the lowest one instead of WARM_IP_TARGET
+	assert.Equal(t, 1, mockContext.effectiveWarmIPTarget())
+	_, over, _ := mockContext.ipTargetState()
+	assert.Equal(t, 2, over)
+
+	// 10 IPs assigned over the last window, the pool keeps 2 minutes of growth at 3 IPs per minute
+	start := time.Now().Add(-allocationRateWindow)
+	mockContext.allocationRate = allocationRateState{}
+	mockContext.releaseRate = allocationRateState{}
+	mockContext.allocationRate.record(start, 0)
+	mockContext.allocationRate.record(start.Add(time.Second), 10)
+	mockContext.releaseRate.record(start, 0)
+	assert.Equal(t, 6, mockContext.effectiveWarmIPTarget())
+	short, _, _ := mockContext.ipTargetState()
+	assert.Equal(t, 3, short)
+
+	// The target is bounded by ADAPTIVE_WARM_IP_TARGET_MAX
+	mockContext.adaptiveWarmIPTargetMaximum = 4
+	assert.Equal(t, 4, mockContext.effectiveWarmIPTarget())
+	mockContext.adaptiveWarmIPTargetMaximum = noAdaptiveWarmIPTargetMax
+
+	// As many IPs released as assigned, only the replaced pods' IPs cooling down are covered
+	mockContext.releaseRate = allocationRateState{}
+	mockContext.releaseRate.record(start, 0)
+	mockContext.releaseRate.record(start.Add(time.Second), 10)
+	assert.Equal(t, 2, mockContext.effectiveWarmIPTarget())
+
+	stats := mockContext.GetPoolStats()
+	assert.Equal(t, 3, stats.WarmIPTarget)
+	assert.Equal(t, 2, stats.EffectiveWarmIPTarget)
+	assert.NotNil(t, stats.AdaptiveWarmIPTarget)
+	assert.InDelta(t, 3, stats.AdaptiveWarmIPTarget.AllocationRate, 0.001)
+	assert.InDelta(t, 3, stats.AdaptiveWarmIPTarget.ReleaseRate, 0.001)
+	assert.Equal(t, 2, stats.AdaptiveWarmIPTarget.Unclamped)
+	assert.Equal(t, 1, stats.AdaptiveWarmIPTarget.Min)
+	assert.Equal(t, 14, stats.AdaptiveWarmIPTarget.Max)
+
+	// Subnet pressure still lowers the target
+	mockContext.subnetPressure.pressured = true
+	mockContext.releaseRate = allocationRateState{}
+	assert.Equal(t, subnetPressureWarmIPTarget, mockContext.effectiveWarmIPTarget())
+
+	// Without ADAPTIVE_WARM_IP_TARGET, the stats are not reported
+	mockContext.adaptiveWarmIPTargetEnabled = false
+	assert.Nil(t, mockContext.GetPoolStats().AdaptiveWarmIPTarget)
+}
+
+func TestGetENIRoutes(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	// The ENIs come from the last reconcile, not from the instance metadata service and EC2
+	mockContext := &IPAMContext{awsClient: mockAWS, networkClient: mockNetwork}
+	_, err := mockContext.GetENIRoutes()
+	assert.Error(t, err)
+
+	mockContext.attachedENIs.set([]awsutils.ENIMetadata{
+		{ENIID: primaryENIid, MAC: primaryMAC, DeviceNumber: primaryDevice, SubnetIPv4CIDR: primarySubnet},
+		{ENIID: secENIid, MAC: secMAC, DeviceNumber: secDevice, SubnetIPv4CIDR: secSubnet},
+	})
+	mockNetwork.EXPECT().GetENIRouteTable(primaryMAC, primaryDevice, primarySubnet).Return(
+		networkutils.ENIRouteTable{Table: 254}, nil)
+	mockNetwork.EXPECT().GetENIRouteTable(secMAC, secDevice, secSubnet).Return(
+		networkutils.ENIRouteTable{}, errors.New("link not found"))
+	routes, err := mockContext.GetENIRoutes()
+	assert.NoError(t, err)
+	assert.Equal(t, []ENIRoutes{
+		{ENIID: primaryENIid, MAC: primaryMAC, DeviceNumber: primaryDevice,
+			ENIRouteTable: networkutils.ENIRouteTable{Table: 254}},
+		{ENIID: secENIid, MAC: secMAC, DeviceNumber: secDevice, Error: "link not found"},
+	}, routes)
+}
+
+func TestGetPodCIDRs(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	ds := datastoreWith3FreeIPs()
+	_ = ds.AddENI(secENIid, secDevice, false)
+	_ = ds.AddIPv4AddressToStore(secENIid, ipaddr11)
+	_ = ds.AddENI("eni-00000003", 3, false)
+	_ = ds.AddIPv4AddressToStore("eni-00000003", "10.10.10.21")
+	mockContext := &IPAMContext{awsClient: mockAWS, dataStore: ds}
+
+	// No reconcile yet
+	_, err := mockContext.GetPodCIDRs()
+	assert.Error(t, err)
+
+	mockContext.attachedENIs.set([]awsutils.ENIMetadata{
+		{ENIID: primaryENIid, MAC: primaryMAC, DeviceNumber: primaryDevice, SubnetIPv4CIDR: primarySubnet, SubnetID: "subnet-1"},
+		{ENIID: "eni-00000003", DeviceNumber: 3, SubnetIPv4CIDR: primarySubnet, SubnetID: "subnet-1"},
+		{ENIID: secENIid, MAC: secMAC, DeviceNumber: secDevice, SubnetIPv4CIDR: secSubnet, SubnetID: "subnet-2"},
+		// Not managed, its IPs are not in the datastore
+		{ENIID: "eni-00000004", DeviceNumber: 4, SubnetIPv4CIDR: "10.10.30.0/24"},
+	})
+	podCIDRs, err := mockContext.GetPodCIDRs()
+	assert.NoError(t, err)
+	assert.Equal(t, []PodCIDR{
+		{CIDR: primarySubnet, SubnetID: "subnet-1", ENIs: []string{primaryENIid, "eni-00000003"}},
+		{CIDR: secSubnet, SubnetID: "subnet-2", ENIs: []string{secENIid}},
+	}, podCIDRs)
+}
+
+func TestGetNetworkTopology(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	ds := datastoreWith3FreeIPs()
+	_ = ds.AddENI(secENIid, secDevice, false)
+	mockContext := &IPAMContext{awsClient: mockAWS, networkClient: mockNetwork, dataStore: ds}
+
+	mockContext.attachedENIs.set([]awsutils.ENIMetadata{
+		{ENIID: secENIid, MAC: secMAC, DeviceNumber: secDevice, SubnetIPv4CIDR: secSubnet, SubnetID: "subnet-2"},
+		{ENIID: primaryENIid, MAC: primaryMAC, DeviceNumber: primaryDevice, SubnetIPv4CIDR: primarySubnet, SubnetID: "subnet-1"},
+		// Not managed
+		{ENIID: "eni-00000004", MAC: "12:ef:2a:98:e5:5c", DeviceNumber: 4, SubnetIPv4CIDR: "10.10.30.0/24"},
+	})
+	mockNetwork.EXPECT().GetENIRouteTable(primaryMAC, primaryDevice, primarySubnet).Return(
+		networkutils.ENIRouteTable{Table: 254, Gateway: "10.10.10.1"}, nil)
+	mockNetwork.EXPECT().GetENIRouteTable(secMAC, secDevice, secSubnet).Return(
+		networkutils.ENIRouteTable{Table: secDevice, MissingRoutes: []string{"default via 10.10.20.1"}}, nil)
+	mockNetwork.EXPECT().GetENIRouteTable("12:ef:2a:98:e5:5c", 4, "10.10.30.0/24").Return(
+		networkutils.ENIRouteTable{}, errors.New("link not found"))
+
+	topology, err := mockContext.GetNetworkTopology()
+	assert.NoError(t, err)
+	assert.Equal(t, []ENITopology{
+		{ENIID: primaryENIid, MAC: primaryMAC, DeviceNumber: primaryDevice, Managed: true, SubnetID: "subnet-1",
+			SubnetCIDR: primarySubnet, RouteTable: 254, Gateway: "10.10.10.1"},
+		{ENIID: secENIid, MAC: secMAC, DeviceNumber: secDevice, Managed: true, SubnetID: "subnet-2",
+			SubnetCIDR: secSubnet, RouteTable: secDevice, MissingRoutes: []string{"default via 10.10.20.1"}},
+		{ENIID: "eni-00000004", MAC: "12:ef:2a:98:e5:5c", DeviceNumber: 4, SubnetCIDR: "10.10.30.0/24",
+			Error: "link not found"},
+	}, topology)
+}
+
+func TestGetTrunkTopology(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{awsClient: mockAWS, k8sClient: mockK8S}
+
+	// Before the first reconcile, the attached ENIs are not known
+	_, err := mockContext.GetTrunkTopology()
+	assert.Error(t, err)
+
+	// Without a trunk ENI, there is nothing to report
+	mockContext.attachedENIs.set([]awsutils.ENIMetadata{
+		{ENIID: primaryENIid, DeviceNumber: primaryDevice},
+	})
+	topology, err := mockContext.GetTrunkTopology()
+	assert.NoError(t, err)
+	assert.Equal(t, "", topology.TrunkENI)
+	assert.Equal(t, 0, len(topology.Branches))
+
+	enis := []awsutils.ENIMetadata{
+		{ENIID: primaryENIid, DeviceNumber: primaryDevice},
+		{ENIID: "eni-trunk", DeviceNumber: 3, InterfaceType: awsutils.InterfaceTypeTrunk},
+	}
+	mockContext.attachedENIs.set(enis)
+	mockContext.branchENICleanup.setTrunkENI(enis)
+	mockAWS.EXPECT().GetBranchENIs(gomock.Any(), "eni-trunk").Return([]awsutils.BranchENI{
+		{ENIID: "eni-branch1", VlanID: 1, PrivateIP: "10.10.30.1", SubnetID: "subnet-1"},
+		{ENIID: "eni-branch2", VlanID: 2, PrivateIP: "10.10.30.2", SubnetID: "subnet-1"},
+	}, nil)
+	mockK8S.EXPECT().K8SGetLocalPodIPs().Return([]*k8sapi.K8SPodInfo{
+		{Name: "pod1", Namespace: "default", BranchENIs: []k8sapi.PodBranchENI{
+			{ENIID: "eni-branch1", PrivateIP: "10.10.30.1", VlanID: 1}}},
+		{Name: "pod3", Namespace: "default", BranchENIs: []k8sapi.PodBranchENI{
+			{ENIID: "eni-branch3", PrivateIP: "10.10.30.3", VlanID: 3}}},
+		{Name: "pod4", Namespace: "default"},
+	}, nil)
+	mockContext.checkBranchENIsIfDue()
+
+	// The branch ENIs are served from the last check, without calling EC2 again
+	topology, err = mockContext.GetTrunkTopology()
+	assert.NoError(t, err)
+	assert.Equal(t, TrunkTopology{
+		TrunkENI:     "eni-trunk",
+		DeviceNumber: 3,
+		Branches: []TrunkBranch{
+			{ENIID: "eni-branch1", VlanID: 1, IP: "10.10.30.1", SubnetID: "subnet-1", Pod: "default/pod1"},
+			{ENIID: "eni-branch2", VlanID: 2, IP: "10.10.30.2", SubnetID: "subnet-1", Orphaned: true},
+			{ENIID: "eni-branch3", VlanID: 3, IP: "10.10.30.3", Pod: "default/pod3", Unassociated: true},
+		},
+		Orphaned:  1,
+		LastCheck: mockContext.branchENICleanup.lastCheck,
+	}, topology)
+}
+
+func TestGetSubnetDistribution(t *testing.T) {
+	ds := datastoreWith1Pod1()
+	_ = ds.SetENISubnet(primaryENIid, "subnet-z")
+	_ = ds.AddENI(secENIid, secDevice, false)
+	_ = ds.SetENISubnet(secENIid, "subnet-a")
+	_ = ds.AddIPv4AddressToStore(secENIid, ipaddr11)
+	_ = ds.AddENI("eni-00000002", 3, false)
+	_ = ds.SetENISubnet("eni-00000002", "subnet-a")
+	_ = ds.AddIPv4AddressToStore("eni-00000002", ipaddr12)
+	_ = ds.AddENI("eni-00000003", 4, false)
+	mockContext := &IPAMContext{dataStore: ds}
+
+	// The subnet of the primary ENI comes first, even after the others
+	assert.Equal(t, []SubnetDistribution{
+		{SubnetID: "subnet-z", Primary: true, ENIs: []string{primaryENIid}, IPs: 3, AssignedIPs: 1},
+		{SubnetID: "", ENIs: []string{"eni-00000003"}},
+		{SubnetID: "subnet-a", ENIs: []string{secENIid, "eni-00000002"}, IPs: 2},
+	}, mockContext.GetSubnetDistribution())
+}
+
+func TestFilterLegacyENIs(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{awsClient: mockAWS, legacyENIPolicy: legacyENIAdopt}
+	enis := []awsutils.ENIMetadata{
+		{ENIID: primaryENIid, DeviceNumber: primaryDevice},
+		{ENIID: secENIid, DeviceNumber: secDevice, SubnetID: "subnet-1", LegacySchema: awsutils.LegacyENISchemaUntagged},
+		{ENIID: "eni-00000003", DeviceNumber: 3, SubnetID: "subnet-1", LegacySchema: "k8s.amazonaws.com/instance_id"},
+	}
+
+	// Adopted legacy ENIs are managed, even when they could not be tagged
+	mockAWS.EXPECT().TagENI(gomock.Any(), secENIid, "subnet-1").Return(nil)
+	mockAWS.EXPECT().TagENI(gomock.Any(), "eni-00000003", "subnet-1").Return(errors.New("tagging failed"))
+	managed, numIgnored := mockContext.filterLegacyENIs(enis)
+	assert.Equal(t, enis, managed)
+	assert.Equal(t, 0, numIgnored)
+	stats := mockContext.GetLegacyENIStats()
+	assert.Equal(t, legacyENIAdopt, stats.Policy)
+	assert.Equal(t, int64(1), stats.Adopted)
+	assert.False(t, stats.LastAdopted.IsZero())
+	assert.Equal(t, []LegacyENI{{ENIID: "eni-00000003", Schema: "k8s.amazonaws.com/instance_id", Error: "tagging failed"}},
+		stats.ENIs)
+
+	// Ignored legacy ENIs are left out, as unmanaged ENIs
+	mockContext.legacyENIPolicy = legacyENIIgnore
+	managed, numIgnored = mockContext.filterLegacyENIs(enis)
+	assert.Equal(t, enis[:1], managed)
+	assert.Equal(t, 2, numIgnored)
+	stats = mockContext.GetLegacyENIStats()
+	assert.Equal(t, int64(1), stats.Adopted)
+	assert.Len(t, stats.ENIs, 2)
+	assert.True(t, stats.ENIs[0].Ignored)
+}
+
+func TestCheckAddressFamilies(t *testing.T) {
+	ds := datastore.NewDataStore()
+	_ = ds.AddENI(primaryENIid, primaryDevice, true)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, "10.10.10.99")
+	_ = ds.AddENI(secENIid, secDevice, false)
+	_ = ds.AddIPv4AddressToStore(secENIid, ipaddr12)
+	mockContext := &IPAMContext{
+		dataStore:              ds,
+		primaryIP:              map[string]string{primaryENIid: ipaddr01},
+		reconcileCooldownCache: ReconcileCooldownCache{cache: make(map[string]time.Time)},
+	}
+	// ipaddr11 was just freed, it is expected to be on the ENI and not in the datastore
+	mockContext.reconcileCooldownCache.Add([]string{ipaddr11})
+
+	primary := true
+	mockContext.checkAddressFamilies([]awsutils.ENIMetadata{
+		{
+			ENIID: primaryENIid,
+			IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+				{PrivateIpAddress: aws.String(ipaddr01), Primary: &primary},
+				{PrivateIpAddress: aws.String(ipaddr02)},
+				{PrivateIpAddress: aws.String(ipaddr03)},
+				{PrivateIpAddress: aws.String(ipaddr11)},
+			},
+			IPv6Addresses: []string{"2001:db8::1"},
+		},
+		// A healthy dual-stack ENI
+		{
+			ENIID: secENIid,
+			IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+				{PrivateIpAddress: aws.String(ipaddr11), Primary: &primary},
+				{PrivateIpAddress: aws.String(ipaddr12)},
+			},
+			IPv6Addresses: []string{"2001:db8::2", "2001:db8::3"},
+		},
+		// Not in the datastore
+		{ENIID: "eni-00000002"},
+	})
+
+	stats := mockContext.GetAddressFamilyStats()
+	assert.Equal(t, 2, stats.DualStackENIs)
+	assert.Equal(t, 1, stats.InconsistentENIs)
+	assert.Len(t, stats.ENIs, 2)
+	eni := stats.ENIs[0]
+	assert.True(t, eni.DualStack)
+	assert.Equal(t, AddressFamilyStatus{EC2: 3, Tracked: 2, Untracked: []string{ipaddr03}, Stale: []string{"10.10.10.99"}},
+		eni.IPv4)
+	assert.Equal(t, AddressFamilyStatus{EC2: 1}, eni.IPv6)
+	assert.Len(t, eni.Inconsistencies, 2)
+
+	// The IPv6 addresses are only counted
+	eni = stats.ENIs[1]
+	assert.True(t, eni.DualStack)
+	assert.Equal(t, AddressFamilyStatus{EC2: 1, Tracked: 1}, eni.IPv4)
+	assert.Equal(t, AddressFamilyStatus{EC2: 2}, eni.IPv6)
+	assert.Len(t, eni.Inconsistencies, 0)
+}
+
+func TestGetEfficiencyStats(t *testing.T) {
+	mockContext := &IPAMContext{}
+	mockContext.efficiency.recordENI()
+	mockContext.efficiency.recordAllocCall(10, []string{ipaddr01, ipaddr02, ipaddr03}, nil)
+	mockContext.efficiency.recordAllocCall(7, []string{ipaddr11}, nil)
+	mockContext.efficiency.recordAllocCall(4, nil, errors.New("throttled"))
+	// EC2 was not called
+	mockContext.efficiency.recordAllocCall(0, nil, nil)
+	// A call from before the window only counts in the lifetime
+	mockContext.efficiency.calls[0].time = time.Now().Add(-2 * efficiencyWindow)
+
+	stats := mockContext.GetEfficiencyStats()
+	assert.Equal(t, AllocEfficiency{Calls: 3, FailedCalls: 1, ENIs: 1, IPsRequested: 21, IPsAllocated: 4,
+		IPsPerCall: 4.0 / 3, AvgBatchSize: 7}, stats.Lifetime)
+	assert.Equal(t, AllocEfficiency{Calls: 2, FailedCalls: 1, ENIs: 1, IPsRequested: 11, IPsAllocated: 1,
+		IPsPerCall: 0.5, AvgBatchSize: 5.5}, stats.Recent)
+	assert.Equal(t, 1/efficiencyWindow.Minutes(), stats.FillRate)
+}
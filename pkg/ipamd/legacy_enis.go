@@ -0,0 +1,119 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do with the ENIs attached to the node that an older CNI
+	// version created and tagged differently, for example before it tagged them with node.k8s.amazonaws.com/instance_id:
+	//   "adopt" (default): tag them with the current tags and manage them, so that they are cleaned up once detached
+	//   "ignore": leave them alone, as if they were tagged node.k8s.amazonaws.com/no_manage
+	envLegacyENIPolicy = "LEGACY_ENI_POLICY"
+	legacyENIAdopt     = "adopt"
+	legacyENIIgnore    = "ignore"
+)
+
+// LegacyENI is an ENI attached to the node that an older CNI version created
+type LegacyENI struct {
+	ENIID string
+	// Schema is how the older CNI version tagged the ENI, awsutils.LegacyENISchemaUntagged or a tag key of
+	// LEGACY_ENI_TAG_KEYS
+	Schema  string
+	Ignored bool `json:",omitempty"`
+	// Error is why the ENI could not be tagged with the current tags, it is tagged again by the next reconcile
+	Error string `json:",omitempty"`
+}
+
+// LegacyENIStats describes the ENIs created by an older CNI version, for introspection
+type LegacyENIStats struct {
+	Policy string
+	// Adopted is the number of legacy ENIs tagged with the current tags, at LastAdopted for the last one
+	Adopted     int64
+	LastAdopted time.Time
+	// ENIs are the legacy ENIs found by the last reconcile that are ignored or could not be tagged
+	ENIs []LegacyENI
+}
+
+// legacyENIState keeps the legacy ENIs found by the last reconcile
+type legacyENIState struct {
+	stats LegacyENIStats
+	lock  sync.RWMutex
+}
+
+// filterLegacyENIs adopts or leaves out the attached ENIs created by an older CNI version, depending on
+// LEGACY_ENI_POLICY. It returns the ENIs ipamd manages, and the number of legacy ENIs left out.
+func (c *IPAMContext) filterLegacyENIs(enis []awsutils.ENIMetadata) ([]awsutils.ENIMetadata, int) {
+	numIgnored := 0
+	ret := make([]awsutils.ENIMetadata, 0, len(enis))
+	legacyENIs := make([]LegacyENI, 0)
+	var adopted int64
+	for _, eni := range enis {
+		if eni.LegacySchema == "" {
+			ret = append(ret, eni)
+			continue
+		}
+		legacyENI := LegacyENI{ENIID: eni.ENIID, Schema: eni.LegacySchema}
+		if c.legacyENIPolicy == legacyENIIgnore {
+			log.Debugf("skipping ENI %s: created by an older CNI version, tagged with the %s schema", eni.ENIID, eni.LegacySchema)
+			legacyENI.Ignored = true
+			legacyENIs = append(legacyENIs, legacyENI)
+			numIgnored++
+			continue
+		}
+		// The ENI is managed even if it could not be tagged, as it was before the upgrade
+		ret = append(ret, eni)
+		if err := c.awsClient.TagENI(c.poolManagerContext(), eni.ENIID, eni.SubnetID); err != nil {
+			log.Warnf("Failed to tag ENI %s, created by an older CNI version with the %s schema: %v",
+				eni.ENIID, eni.LegacySchema, err)
+			ipamdErrInc("adoptLegacyENI")
+			legacyENI.Error = err.Error()
+			legacyENIs = append(legacyENIs, legacyENI)
+			continue
+		}
+		log.Infof("Adopted ENI %s, created by an older CNI version with the %s schema", eni.ENIID, eni.LegacySchema)
+		adopted++
+	}
+
+	c.legacyENIs.lock.Lock()
+	defer c.legacyENIs.lock.Unlock()
+	c.legacyENIs.stats.ENIs = legacyENIs
+	if adopted > 0 {
+		c.legacyENIs.stats.Adopted += adopted
+		c.legacyENIs.stats.LastAdopted = time.Now()
+	}
+	return ret, numIgnored
+}
+
+// GetLegacyENIStats returns the ENIs created by an older CNI version
+func (c *IPAMContext) GetLegacyENIStats() LegacyENIStats {
+	c.legacyENIs.lock.RLock()
+	defer c.legacyENIs.lock.RUnlock()
+	stats := c.legacyENIs.stats
+	stats.Policy = c.legacyENIPolicy
+	stats.ENIs = append([]LegacyENI(nil), stats.ENIs...)
+	return stats
+}
+
+func getLegacyENIPolicy() string {
+	return envsetting.Choice(envLegacyENIPolicy, legacyENIAdopt, legacyENIAdopt, legacyENIIgnore)
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	// This environment variable is used to specify the file that ipamd periodically writes its metrics to, in the
+	// Prometheus text exposition format, for example for the textfile collector of the node exporter, which only reads
+	// files ending in ".prom". The file is written whether or not the metrics endpoint is disabled. When it is not set,
+	// no file is written.
+	envMetricsTextfilePath = "METRICS_TEXTFILE_PATH"
+
+	// This environment variable is used to specify, in seconds, how often the metrics file is written. When it is not
+	// set, it defaults to 60 seconds.
+	envMetricsTextfileInterval     = "METRICS_TEXTFILE_INTERVAL"
+	defaultMetricsTextfileInterval = 60 * time.Second
+
+	// metricsTextfilePrefix is the prefix of the ipamd metrics written to the file. The Go runtime and process metrics
+	// are left out, the node exporter has its own.
+	metricsTextfilePrefix = "awscni_"
+)
+
+// ServeMetricsTextfile periodically writes the ipamd metrics to METRICS_TEXTFILE_PATH
+func (c *IPAMContext) ServeMetricsTextfile() {
+	path := getMetricsTextfilePath()
+	if path == "" {
+		return
+	}
+	interval := getMetricsTextfileInterval()
+	log.Infof("Writing metrics to %s every %v", path, interval)
+	for {
+		if err := writeMetricsTextfile(path, prometheus.DefaultGatherer); err != nil {
+			log.Errorf("Failed to write metrics to %s: %v", path, err)
+			ipamdErrInc("writeMetricsTextfile")
+		}
+		time.Sleep(interval)
+	}
+}
+
+// writeMetricsTextfile writes the ipamd metrics of the gatherer to path. The metrics are written to a temporary file
+// in the same directory first and then renamed, so that a collector never reads a partially written file.
+func writeMetricsTextfile(path string, gatherer prometheus.Gatherer) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return errors.Wrap(err, "failed to gather the metrics")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create the temporary metrics file")
+	}
+	defer os.Remove(tmp.Name())
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), metricsTextfilePrefix) {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(tmp, family); err != nil {
+			tmp.Close()
+			return errors.Wrapf(err, "failed to write metric %s", family.GetName())
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to close the temporary metrics file")
+	}
+	// TempFile creates the file readable by its owner only
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return errors.Wrap(err, "failed to set the mode of the temporary metrics file")
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func getMetricsTextfilePath() string {
+	path, _ := os.LookupEnv(envMetricsTextfilePath)
+	return path
+}
+
+func getMetricsTextfileInterval() time.Duration {
+	inputStr, found := os.LookupEnv(envMetricsTextfileInterval)
+
+	if !found {
+		return defaultMetricsTextfileInterval
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		log.Debugf("Using %s %v", envMetricsTextfileInterval, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, using default %v", envMetricsTextfileInterval, inputStr, defaultMetricsTextfileInterval)
+	return defaultMetricsTextfileInterval
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetricsTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics-textfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "aws-cni.prom")
+
+	registry := prometheus.NewRegistry()
+	assigned := prometheus.NewGauge(prometheus.GaugeOpts{Name: "awscni_assigned_ip_addresses", Help: "assigned IPs"})
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "go_goroutines", Help: "goroutines"})
+	registry.MustRegister(assigned, other)
+	assigned.Set(3)
+	other.Set(10)
+
+	assert.NoError(t, writeMetricsTextfile(path, registry))
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "# HELP awscni_assigned_ip_addresses assigned IPs\n"+
+		"# TYPE awscni_assigned_ip_addresses gauge\n"+
+		"awscni_assigned_ip_addresses 3\n", string(content))
+
+	// The file is replaced, and no temporary file is left behind
+	assigned.Set(5)
+	assert.NoError(t, writeMetricsTextfile(path, registry))
+	content, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "awscni_assigned_ip_addresses 5\n")
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, os.FileMode(0644), files[0].Mode().Perm())
+
+	assert.Error(t, writeMetricsTextfile(filepath.Join(dir, "missing", "aws-cni.prom"), registry))
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to specify the maximum number of IPs the pods of a namespace may hold on the
+	// node, as a comma separated list of namespace=count, for example "team-a=20,team-b=10". The count of "*" applies
+	// to the namespaces that are not listed. The IPs of the additional interfaces of the pods count towards the quota.
+	// When it is not set, there is no quota.
+	envNamespaceIPQuota = "NAMESPACE_IP_QUOTA"
+	anyNamespace        = "*"
+)
+
+// errNamespaceIPQuotaReached is returned when a pod gets no IP because its namespace holds its NAMESPACE_IP_QUOTA IPs
+var errNamespaceIPQuotaReached = errors.New("the namespace IP quota is reached")
+
+// NamespaceUsage is the number of IPs the pods of a namespace hold on the node, for introspection
+type NamespaceUsage struct {
+	Namespace string
+	IPs       int
+	// Limited is true when the namespace has a quota of Quota IPs, and Rejected is the number of AddNetwork requests
+	// that failed because the quota was reached
+	Limited  bool
+	Quota    int   `json:",omitempty"`
+	Rejected int64 `json:",omitempty"`
+}
+
+// namespaceIPQuotaState keeps the AddNetwork requests rejected because of NAMESPACE_IP_QUOTA
+type namespaceIPQuotaState struct {
+	rejected map[string]int64
+	lock     sync.RWMutex
+}
+
+// namespaceIPQuotaOf returns the quota of the namespace, and whether it has one
+func (c *IPAMContext) namespaceIPQuotaOf(namespace string) (int, bool) {
+	if quota, ok := c.namespaceIPQuotas[namespace]; ok {
+		return quota, true
+	}
+	quota, ok := c.namespaceIPQuotas[anyNamespace]
+	return quota, ok
+}
+
+// checkNamespaceIPQuota returns errNamespaceIPQuotaReached, and counts the rejected request, if ips more IPs would
+// exceed the quota of the namespace of the pod
+func (c *IPAMContext) checkNamespaceIPQuota(pod *k8sapi.K8SPodInfo, ips int) error {
+	quota, ok := c.namespaceIPQuotaOf(pod.Namespace)
+	if !ok {
+		return nil
+	}
+	used := c.dataStore.GetNamespaceIPCounts()[pod.Namespace]
+	if used+ips <= quota {
+		return nil
+	}
+	c.namespaceIPQuota.lock.Lock()
+	defer c.namespaceIPQuota.lock.Unlock()
+	if c.namespaceIPQuota.rejected == nil {
+		c.namespaceIPQuota.rejected = make(map[string]int64)
+	}
+	c.namespaceIPQuota.rejected[pod.Namespace]++
+	log.Warnf("Not assigning %d IPs to Pod %s, Namespace %s, the namespace holds %d of its %s of %d IPs",
+		ips, pod.Name, pod.Namespace, used, envNamespaceIPQuota, quota)
+	return errNamespaceIPQuotaReached
+}
+
+// GetNamespaceUsage returns the number of IPs held by the pods of each namespace that has pods or a quota, by name
+func (c *IPAMContext) GetNamespaceUsage() []NamespaceUsage {
+	counts := c.dataStore.GetNamespaceIPCounts()
+	for namespace := range c.namespaceIPQuotas {
+		if _, ok := counts[namespace]; !ok && namespace != anyNamespace {
+			counts[namespace] = 0
+		}
+	}
+
+	c.namespaceIPQuota.lock.RLock()
+	defer c.namespaceIPQuota.lock.RUnlock()
+	usage := make([]NamespaceUsage, 0, len(counts))
+	for namespace, ips := range counts {
+		quota, limited := c.namespaceIPQuotaOf(namespace)
+		usage = append(usage, NamespaceUsage{
+			Namespace: namespace,
+			IPs:       ips,
+			Limited:   limited,
+			Quota:     quota,
+			Rejected:  c.namespaceIPQuota.rejected[namespace],
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Namespace < usage[j].Namespace
+	})
+	return usage
+}
+
+func getNamespaceIPQuotas() map[string]int {
+	var quotas map[string]int
+	for _, entry := range strings.Split(os.Getenv(envNamespaceIPQuota), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			log.Errorf("Invalid %s entry %q, ignoring it", envNamespaceIPQuota, entry)
+			continue
+		}
+		quota, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || quota < 0 {
+			log.Errorf("Invalid %s entry %q, ignoring it", envNamespaceIPQuota, entry)
+			continue
+		}
+		if quotas == nil {
+			quotas = make(map[string]int)
+		}
+		quotas[strings.TrimSpace(parts[0])] = quota
+	}
+	return quotas
+}
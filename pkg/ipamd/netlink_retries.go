@@ -0,0 +1,108 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"golang.org/x/net/context"
+
+	"github.com/aws/amazon-vpc-cni-k8s/rpc"
+)
+
+const (
+	// This environment variable is used to specify how many more times the CNI plugin does a netlink operation that
+	// failed with a transient error, like EBUSY, while it sets up or tears down the network of a pod. It is written to
+	// the CNI config by the entrypoint, ipamd only reads it to show it with the retries the plugin reports. When it is
+	// not set or set to 0, the operations are not retried.
+	envNetlinkRetries = "AWS_VPC_K8S_CNI_NETLINK_RETRIES"
+	noNetlinkRetries  = 0
+	maxNetlinkRetries = 10
+)
+
+// NetlinkRetryStats counts the netlink operations the CNI plugin retried, for introspection
+type NetlinkRetryStats struct {
+	Retries int
+	// Reports is the number of ADD and DEL commands that retried an operation, and ByOperation and ByCommand the
+	// retries of each netlink operation and each command
+	Reports     int64
+	ByOperation map[string]int64
+	ByCommand   map[string]int64
+	// LastPod is the pod of the last command that retried an operation, at LastReport
+	LastPod    string    `json:",omitempty"`
+	LastReport time.Time `json:",omitempty"`
+}
+
+// netlinkRetryState keeps the netlink retries reported by the CNI plugin
+type netlinkRetryState struct {
+	stats NetlinkRetryStats
+	lock  sync.RWMutex
+}
+
+// ReportNetlinkRetries counts the netlink operations the CNI plugin retried during a command
+func (s *server) ReportNetlinkRetries(ctx context.Context, in *rpc.NetlinkRetriesRequest) (*rpc.NetlinkRetriesReply, error) {
+	log.Infof("Received ReportNetlinkRetries for %s of Pod %s, Namespace %s, Sandbox %s: %v",
+		in.Command, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID, in.Retries)
+	state := &s.ipamContext.netlinkRetries
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.stats.ByOperation == nil {
+		state.stats.ByOperation = make(map[string]int64)
+		state.stats.ByCommand = make(map[string]int64)
+	}
+	state.stats.Reports++
+	for _, retry := range in.Retries {
+		state.stats.ByOperation[retry.Operation] += int64(retry.Count)
+		state.stats.ByCommand[in.Command] += int64(retry.Count)
+	}
+	state.stats.LastPod = in.K8S_POD_NAMESPACE + "/" + in.K8S_POD_NAME
+	state.stats.LastReport = time.Now()
+	return &rpc.NetlinkRetriesReply{Success: true}, nil
+}
+
+// GetNetlinkRetryStats returns the netlink retries reported by the CNI plugin
+func (c *IPAMContext) GetNetlinkRetryStats() NetlinkRetryStats {
+	c.netlinkRetries.lock.RLock()
+	defer c.netlinkRetries.lock.RUnlock()
+	stats := c.netlinkRetries.stats
+	stats.Retries = getNetlinkRetries()
+	stats.ByOperation = make(map[string]int64, len(c.netlinkRetries.stats.ByOperation))
+	for operation, count := range c.netlinkRetries.stats.ByOperation {
+		stats.ByOperation[operation] = count
+	}
+	stats.ByCommand = make(map[string]int64, len(c.netlinkRetries.stats.ByCommand))
+	for command, count := range c.netlinkRetries.stats.ByCommand {
+		stats.ByCommand[command] = count
+	}
+	return stats
+}
+
+func getNetlinkRetries() int {
+	inputStr, found := os.LookupEnv(envNetlinkRetries)
+
+	if !found {
+		return noNetlinkRetries
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && input <= maxNetlinkRetries {
+		log.Debugf("Using %s %v", envNetlinkRetries, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envNetlinkRetries, inputStr, noNetlinkRetries)
+	return noNetlinkRetries
+}
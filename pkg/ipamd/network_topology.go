@@ -0,0 +1,75 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// ENITopology correlates an attached ENI with its subnet and the host route table its pods' traffic uses, for
+// introspection
+type ENITopology struct {
+	ENIID        string
+	MAC          string
+	DeviceNumber int
+	// Managed is false for the ENIs whose IPs are not in the datastore, like the ENIs tagged no_manage
+	Managed    bool
+	SubnetID   string `json:",omitempty"`
+	SubnetCIDR string `json:",omitempty"`
+	// RouteTable is the host route table of the ENI, the main table for the primary ENI
+	RouteTable int
+	// Gateway is the gateway of the default route of RouteTable
+	Gateway       string   `json:",omitempty"`
+	MissingRoutes []string `json:",omitempty"`
+	Error         string   `json:",omitempty"`
+}
+
+// GetNetworkTopology returns the subnet, route table and gateway of each ENI attached as of the last reconcile, sorted
+// by device number
+func (c *IPAMContext) GetNetworkTopology() ([]ENITopology, error) {
+	enis, err := c.getAttachedENIs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get attached ENIs")
+	}
+	pools := c.dataStore.GetENIInfos().ENIIPPools
+	result := make([]ENITopology, 0, len(enis))
+	for _, eni := range enis {
+		_, managed := pools[eni.ENIID]
+		topology := ENITopology{
+			ENIID:        eni.ENIID,
+			MAC:          eni.MAC,
+			DeviceNumber: eni.DeviceNumber,
+			Managed:      managed,
+			SubnetID:     eni.SubnetID,
+			SubnetCIDR:   eni.SubnetIPv4CIDR,
+		}
+		routeTable, err := c.networkClient.GetENIRouteTable(eni.MAC, eni.DeviceNumber, eni.SubnetIPv4CIDR)
+		if err != nil {
+			log.Warnf("Failed to get route table of ENI %s: %v", eni.ENIID, err)
+			topology.Error = err.Error()
+		} else {
+			topology.RouteTable = routeTable.Table
+			topology.Gateway = routeTable.Gateway
+			topology.MissingRoutes = routeTable.MissingRoutes
+		}
+		result = append(result, topology)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DeviceNumber < result[j].DeviceNumber
+	})
+	return result, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+// NetworkingMode is whether the node uses custom networking and the subnet and security groups its new ENIs get, for
+// introspection
+type NetworkingMode struct {
+	CustomNetworking bool
+	// ENIConfig is the name of the ENIConfig of the node with custom networking, and ENIConfigFound whether ipamd
+	// has received it from the API server
+	ENIConfig      string `json:",omitempty"`
+	ENIConfigFound bool
+	// Subnet and SecurityGroups come from the ENIConfig with custom networking, and from the primary ENI otherwise
+	Subnet         string
+	SecurityGroups []string
+	// Error is why the ENIConfig of the node cannot be used
+	Error string `json:",omitempty"`
+}
+
+// GetNetworkingMode returns the networking mode of the node
+func (c *IPAMContext) GetNetworkingMode() NetworkingMode {
+	if !c.useCustomNetworking {
+		return NetworkingMode{
+			Subnet:         c.awsClient.GetPrimaryENISubnetID(),
+			SecurityGroups: c.awsClient.GetPrimaryENISecurityGroups(),
+		}
+	}
+
+	mode := NetworkingMode{CustomNetworking: true, SecurityGroups: []string{}}
+	if c.eniConfig == nil {
+		mode.Error = "the ENIConfig controller is not running"
+		return mode
+	}
+	mode.ENIConfig = c.eniConfig.Getter().MyENI
+	eniCfg, err := c.eniConfig.MyENIConfig()
+	if err != nil {
+		mode.Error = err.Error()
+		return mode
+	}
+	mode.ENIConfigFound = true
+	mode.Subnet = eniCfg.Subnet
+	mode.SecurityGroups = append(mode.SecurityGroups, eniCfg.SecurityGroups...)
+	return mode
+}
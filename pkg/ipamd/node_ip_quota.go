@@ -0,0 +1,102 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// This environment variable is used to specify the maximum number of IPs, assigned and warm, that the node may
+	// hold, whatever the warm targets and the instance limits. It lets the nodes of a cluster share a subnet fairly.
+	// When it is not set or set to 0, there is no quota.
+	envNodeIPQuota = "NODE_IP_QUOTA"
+	noNodeIPQuota  = 0
+)
+
+// errNodeIPQuotaReached is returned when the pool cannot grow because the node holds NODE_IP_QUOTA IPs
+var errNodeIPQuotaReached = errors.New("the node IP quota is reached")
+
+// NodeIPQuotaStats is the usage of NODE_IP_QUOTA, for introspection
+type NodeIPQuotaStats struct {
+	Quota int
+	Used  int
+	// Reached is true when the pool cannot grow, and Blocked the number of pool increases the quota prevented
+	Reached bool
+	Blocked int64
+}
+
+// nodeIPQuotaRemaining returns how many more IPs the node may allocate, and whether NODE_IP_QUOTA is set
+func (c *IPAMContext) nodeIPQuotaRemaining() (remaining int, limited bool) {
+	if c.nodeIPQuota == noNodeIPQuota {
+		return 0, false
+	}
+	total, _ := c.dataStore.GetStats()
+	return max(c.nodeIPQuota-total, 0), true
+}
+
+// nodeIPQuotaReached returns true if the node already holds NODE_IP_QUOTA IPs
+func (c *IPAMContext) nodeIPQuotaReached() bool {
+	remaining, limited := c.nodeIPQuotaRemaining()
+	return limited && remaining == 0
+}
+
+// checkNodeIPQuota returns errNodeIPQuotaReached, and counts the blocked pool increase, if the quota is reached
+func (c *IPAMContext) checkNodeIPQuota() error {
+	if !c.nodeIPQuotaReached() {
+		return nil
+	}
+	atomic.AddInt64(&c.nodeIPQuotaBlocked, 1)
+	log.Debugf("Skipping increase IP pool, the node holds its %s of %d IPs", envNodeIPQuota, c.nodeIPQuota)
+	return errNodeIPQuotaReached
+}
+
+// capToNodeIPQuota returns how many of ipsToAllocate IPs the node may allocate
+func (c *IPAMContext) capToNodeIPQuota(ipsToAllocate int) int {
+	if remaining, limited := c.nodeIPQuotaRemaining(); limited {
+		return min(ipsToAllocate, remaining)
+	}
+	return ipsToAllocate
+}
+
+// getNodeIPQuotaStats returns the usage of NODE_IP_QUOTA
+func (c *IPAMContext) getNodeIPQuotaStats() NodeIPQuotaStats {
+	total, _ := c.dataStore.GetStats()
+	return NodeIPQuotaStats{
+		Quota:   c.nodeIPQuota,
+		Used:    total,
+		Reached: c.nodeIPQuotaReached(),
+		Blocked: atomic.LoadInt64(&c.nodeIPQuotaBlocked),
+	}
+}
+
+func getNodeIPQuota() int {
+	inputStr, found := os.LookupEnv(envNodeIPQuota)
+
+	if !found {
+		return noNodeIPQuota
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envNodeIPQuota, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envNodeIPQuota, inputStr, noNodeIPQuota)
+	return noNodeIPQuota
+}
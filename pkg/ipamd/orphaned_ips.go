@@ -0,0 +1,236 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/cri"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do with the IP of a pod that no longer exists in the
+	// cluster and whose sandbox is no longer running, because its DEL never arrived:
+	//   "report" (default): only report it on /v1/orphaned-by-sandbox
+	//   "release": also unassign it so that another pod can take it
+	envOrphanedIPPolicy = "ORPHANED_IP_POLICY"
+	orphanedIPReport    = "report"
+	orphanedIPRelease   = "release"
+
+	// This environment variable is used to specify, in seconds, for how long an IP must be orphaned before it is
+	// released. When it is not set, it defaults to 5 minutes.
+	envOrphanedIPGracePeriod     = "ORPHANED_IP_GRACE_PERIOD"
+	defaultOrphanedIPGracePeriod = 5 * time.Minute
+
+	// orphanedIPReleaseCheckInterval is how often the IPs of the datastore are checked against the pods of the
+	// cluster when ORPHANED_IP_POLICY is "release", orphanedIPReportCheckInterval when they are only reported, which
+	// does not need listing the pods and sandboxes as often
+	orphanedIPReleaseCheckInterval = time.Minute
+	orphanedIPReportCheckInterval  = 15 * time.Minute
+)
+
+// OrphanedIP is an IP assigned to a pod that no longer exists in the cluster and whose sandbox is not running
+type OrphanedIP struct {
+	Name      string
+	Namespace string
+	Sandbox   string
+	IP        string
+	// FirstSeen is when the IP was first found orphaned
+	FirstSeen time.Time
+}
+
+// OrphanedIPStats contains the orphaned IPs of the datastore, for introspection
+type OrphanedIPStats struct {
+	Policy      string
+	GracePeriod time.Duration
+	LastCheck   time.Time
+	// LastError is why the last check could not be done
+	LastError string `json:",omitempty"`
+	Orphaned  []OrphanedIP
+	// Released is the number of orphaned IPs that were unassigned
+	Released int64
+}
+
+// orphanedIPState keeps the IPs found orphaned by the last checks
+type orphanedIPState struct {
+	orphaned  map[string]OrphanedIP
+	lastCheck time.Time
+	lastError string
+	released  int64
+	lock      sync.RWMutex
+}
+
+// checkOrphanedIPsIfDue checks the assigned IPs against the pods of the cluster every orphanedIPReleaseCheckInterval
+// when ORPHANED_IP_POLICY is "release", or else every orphanedIPReportCheckInterval
+func (c *IPAMContext) checkOrphanedIPsIfDue() {
+	c.orphanedIPs.lock.RLock()
+	lastCheck := c.orphanedIPs.lastCheck
+	c.orphanedIPs.lock.RUnlock()
+	interval := orphanedIPReportCheckInterval
+	if c.orphanedIPPolicy == orphanedIPRelease {
+		interval = orphanedIPReleaseCheckInterval
+	}
+	if time.Since(lastCheck) < interval {
+		return
+	}
+	err := c.checkOrphanedIPs()
+	c.orphanedIPs.lock.Lock()
+	defer c.orphanedIPs.lock.Unlock()
+	c.orphanedIPs.lastCheck = time.Now()
+	c.orphanedIPs.lastError = ""
+	if err != nil {
+		log.Warnf("Skipping orphaned IP check: %v", err)
+		c.orphanedIPs.lastError = err.Error()
+	}
+}
+
+// checkOrphanedIPs finds the assigned IPs whose pod is neither known to the API server nor has a running sandbox, and
+// releases those orphaned for longer than ORPHANED_IP_GRACE_PERIOD when ORPHANED_IP_POLICY is "release"
+func (c *IPAMContext) checkOrphanedIPs() error {
+	// Without the pods known to the API server and the running sandboxes, a pod being added cannot be told apart
+	localPods, err := c.k8sClient.K8SGetLocalPodIPs()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the local pods")
+	}
+	sandboxes, err := c.criClient.GetRunningPodSandboxes()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the running pod sandboxes")
+	}
+	knownPods := make(map[string]bool, len(localPods))
+	for _, pod := range localPods {
+		knownPods[pod.Namespace+"/"+pod.Name] = true
+	}
+	runningSandboxes := make(map[string]bool, len(sandboxes))
+	for _, sandbox := range sandboxes {
+		runningSandboxes[sandbox.ID] = true
+	}
+
+	now := time.Now()
+	var toRelease []OrphanedIP
+	c.orphanedIPs.lock.Lock()
+	previous := c.orphanedIPs.orphaned
+	c.orphanedIPs.orphaned = make(map[string]OrphanedIP)
+	for _, pod := range c.dataStore.GetAssignedPods() {
+		if knownPods[pod.Namespace+"/"+pod.Name] || sandboxRunning(pod, sandboxes, runningSandboxes) {
+			continue
+		}
+		key := pod.Namespace + "/" + pod.Name + "/" + pod.Sandbox
+		orphaned, ok := previous[key]
+		if !ok || orphaned.IP != pod.IP {
+			orphaned = OrphanedIP{Name: pod.Name, Namespace: pod.Namespace, Sandbox: pod.Sandbox, IP: pod.IP, FirstSeen: now}
+			log.Warnf("IP %s of Pod %s, Namespace %s, Sandbox %s is orphaned, the pod and its sandbox no longer exist",
+				pod.IP, pod.Name, pod.Namespace, pod.Sandbox)
+		}
+		if c.orphanedIPPolicy == orphanedIPRelease && now.Sub(orphaned.FirstSeen) >= c.orphanedIPGracePeriod {
+			toRelease = append(toRelease, orphaned)
+			continue
+		}
+		c.orphanedIPs.orphaned[key] = orphaned
+	}
+	c.orphanedIPs.lock.Unlock()
+
+	for _, orphaned := range toRelease {
+		c.releaseOrphanedIP(orphaned)
+	}
+	return nil
+}
+
+// sandboxRunning returns true if the CRI still runs the sandbox of the pod
+func sandboxRunning(pod k8sapi.K8SPodInfo, sandboxes map[string]*cri.SandboxInfo, runningSandboxes map[string]bool) bool {
+	if pod.Sandbox != "" && runningSandboxes[pod.Sandbox] {
+		return true
+	}
+	if pod.UID != "" {
+		if _, ok := sandboxes[pod.UID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseOrphanedIP unassigns the IPs of the pod of an orphaned IP, as its DEL would have
+func (c *IPAMContext) releaseOrphanedIP(orphaned OrphanedIP) {
+	pod := &k8sapi.K8SPodInfo{Name: orphaned.Name, Namespace: orphaned.Namespace, Sandbox: orphaned.Sandbox}
+	ip, _, err := c.dataStore.UnassignPodIPv4Address(pod)
+	if err != nil {
+		log.Errorf("Failed to release orphaned IP %s of Pod %s, Namespace %s: %v", orphaned.IP, pod.Name, pod.Namespace, err)
+		ipamdErrInc("releaseOrphanedIP")
+		return
+	}
+	c.auditIP(ipAuditRelease, pod, ip, "", "orphaned")
+	c.teardownEgressOnly(ip)
+	for _, intf := range c.dataStore.UnassignPodInterfaces(pod) {
+		c.auditIP(ipAuditRelease, pod, intf.IP, intf.IfName, "orphaned")
+		c.teardownEgressOnly(intf.IP)
+	}
+	if c.podIPState != nil {
+		if err := c.podIPState.remove(ip); err != nil {
+			log.Warnf("Failed to remove persisted IP %s of Pod %s, Namespace %s: %v", ip, pod.Name, pod.Namespace, err)
+		}
+	}
+	log.Infof("Released orphaned IP %s of Pod %s, Namespace %s, Sandbox %s, orphaned since %s",
+		ip, pod.Name, pod.Namespace, pod.Sandbox, orphaned.FirstSeen.Format(time.RFC3339))
+	c.orphanedIPs.lock.Lock()
+	defer c.orphanedIPs.lock.Unlock()
+	c.orphanedIPs.released++
+}
+
+// GetOrphanedIPStats returns the orphaned IPs found by the last check, oldest first
+func (c *IPAMContext) GetOrphanedIPStats() OrphanedIPStats {
+	c.orphanedIPs.lock.RLock()
+	defer c.orphanedIPs.lock.RUnlock()
+	stats := OrphanedIPStats{
+		Policy:      c.orphanedIPPolicy,
+		GracePeriod: c.orphanedIPGracePeriod,
+		LastCheck:   c.orphanedIPs.lastCheck,
+		LastError:   c.orphanedIPs.lastError,
+		Orphaned:    make([]OrphanedIP, 0, len(c.orphanedIPs.orphaned)),
+		Released:    c.orphanedIPs.released,
+	}
+	for _, orphaned := range c.orphanedIPs.orphaned {
+		stats.Orphaned = append(stats.Orphaned, orphaned)
+	}
+	sort.Slice(stats.Orphaned, func(i, j int) bool {
+		return stats.Orphaned[i].FirstSeen.Before(stats.Orphaned[j].FirstSeen)
+	})
+	return stats
+}
+
+func getOrphanedIPPolicy() string {
+	return envsetting.Choice(envOrphanedIPPolicy, orphanedIPReport, orphanedIPReport, orphanedIPRelease)
+}
+
+func getOrphanedIPGracePeriod() time.Duration {
+	inputStr, found := os.LookupEnv(envOrphanedIPGracePeriod)
+
+	if !found {
+		return defaultOrphanedIPGracePeriod
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envOrphanedIPGracePeriod, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, using default %v", envOrphanedIPGracePeriod, inputStr, defaultOrphanedIPGracePeriod)
+	return defaultOrphanedIPGracePeriod
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what to do when EC2 allocates fewer IP addresses on an ENI than
+	// requested, for example because the subnet is almost full:
+	//   "accept" (default): keep the IPs allocated, the pool manager requests the shortfall on its next run
+	//   "retry": request the shortfall again right away, up to partialAllocMaxRetries times
+	envPartialAllocPolicy = "PARTIAL_IP_ALLOCATION_POLICY"
+	partialAllocAccept    = "accept"
+	partialAllocRetry     = "retry"
+
+	// partialAllocMaxRetries is how many times the shortfall is requested again with the "retry" policy
+	partialAllocMaxRetries = 2
+	// maxPartialAllocEvents is how many of the recent partial allocations are kept
+	maxPartialAllocEvents = 32
+)
+
+// PartialAllocation is an allocation of IP addresses on an ENI that got fewer IPs than requested, for introspection
+type PartialAllocation struct {
+	ENIID string
+	Time  time.Time
+	// Requested is the number of IPs requested, Allocated how many EC2 allocated including the retries, and Retries
+	// the number of times the shortfall was requested again
+	Requested int
+	Allocated int
+	Retries   int
+}
+
+// PartialAllocStats counts the partial allocations of IP addresses, for introspection
+type PartialAllocStats struct {
+	Policy string
+	// Count is the number of partial allocations, and ShortIPs the total of the IPs that were not allocated
+	Count    int64
+	ShortIPs int64
+	// Recent are the most recent partial allocations, oldest first
+	Recent []PartialAllocation
+}
+
+// partialAllocState keeps the recent partial allocations
+type partialAllocState struct {
+	count    int64
+	shortIPs int64
+	recent   []PartialAllocation
+	lock     sync.RWMutex
+}
+
+func (s *partialAllocState) record(event PartialAllocation) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.count++
+	s.shortIPs += int64(event.Requested - event.Allocated)
+	s.recent = append(s.recent, event)
+	s.recent = s.recent[keepLast(len(s.recent), maxPartialAllocEvents):]
+}
+
+// allocIPAddresses allocates numIPs IP addresses on the ENI and returns those EC2 allocated. When EC2 allocates
+// fewer, the partial allocation is recorded and, with the "retry" PARTIAL_IP_ALLOCATION_POLICY, the shortfall is
+// requested again until a request gets no IP. The error is that of the first request; a failed retry keeps the IPs
+// already allocated.
+func (c *IPAMContext) allocIPAddresses(eniID string, numIPs int) ([]string, error) {
+	ips, err := c.allocIPAddressesOnce(eniID, numIPs)
+	c.efficiency.recordAllocCall(numIPs, ips, err)
+	if err != nil || len(ips) >= numIPs {
+		return ips, err
+	}
+
+	event := PartialAllocation{ENIID: eniID, Time: time.Now(), Requested: numIPs}
+	if c.partialAllocPolicy == partialAllocRetry {
+		for event.Retries < partialAllocMaxRetries && len(ips) > 0 && len(ips) < numIPs {
+			event.Retries++
+			more, err := c.allocIPAddressesOnce(eniID, numIPs-len(ips))
+			c.efficiency.recordAllocCall(numIPs-len(ips), more, err)
+			if err != nil {
+				log.Warnf("Failed to allocate the %d IP addresses missing on ENI %s: %v", numIPs-len(ips), eniID, err)
+				break
+			}
+			if len(more) == 0 {
+				break
+			}
+			ips = append(ips, more...)
+		}
+	}
+	event.Allocated = len(ips)
+	if event.Allocated < numIPs {
+		log.Warnf("Allocated %d of the %d IP addresses requested on ENI %s", event.Allocated, numIPs, eniID)
+		c.eniTimelines.record(eniID, eniEventError, "allocated %d of the %d IPs requested", event.Allocated, numIPs)
+		c.partialAllocs.record(event)
+	}
+	return ips, nil
+}
+
+// addAllocatedIPsToDataStore adds the IP addresses EC2 allocated on an ENI to the datastore, so that they are tracked
+// even when the ENI description or the instance metadata does not list them yet
+func (c *IPAMContext) addAllocatedIPsToDataStore(eniID string, ips []string) {
+	for _, ip := range ips {
+		err := c.dataStore.AddIPv4AddressToStore(eniID, ip)
+		if err != nil && err.Error() != datastore.IPAlreadyInStoreError {
+			log.Warnf("Failed to add IP %s allocated on ENI %s to data store: %v", ip, eniID, err)
+			ipamdErrInc("addAllocatedIPsToDataStoreFailed")
+		}
+	}
+}
+
+// GetPartialAllocStats returns the partial allocations of IP addresses
+func (c *IPAMContext) GetPartialAllocStats() PartialAllocStats {
+	c.partialAllocs.lock.RLock()
+	defer c.partialAllocs.lock.RUnlock()
+	return PartialAllocStats{
+		Policy:   c.partialAllocPolicy,
+		Count:    c.partialAllocs.count,
+		ShortIPs: c.partialAllocs.shortIPs,
+		Recent:   append([]PartialAllocation{}, c.partialAllocs.recent...),
+	}
+}
+
+func getPartialAllocPolicy() string {
+	return envsetting.Choice(envPartialAllocPolicy, partialAllocAccept, partialAllocAccept, partialAllocRetry)
+}
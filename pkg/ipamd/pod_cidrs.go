@@ -0,0 +1,62 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// PodCIDR is the subnet CIDR of the ENIs whose IPs the pods of the node can take
+type PodCIDR struct {
+	CIDR     string
+	SubnetID string `json:",omitempty"`
+	// ENIs are the IDs of the ENIs of the subnet, sorted
+	ENIs []string
+}
+
+// GetPodCIDRs returns the subnet CIDRs of the ENIs attached as of the last reconcile whose IPs are in the datastore, that is the CIDRs the pod
+// IPs of the node come from, sorted by CIDR. The unmanaged ENIs and the ENIs without IPs for pods, like the primary
+// ENI with custom networking, are left out.
+func (c *IPAMContext) GetPodCIDRs() ([]PodCIDR, error) {
+	enis, err := c.getAttachedENIs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get attached ENIs")
+	}
+	pools := c.dataStore.GetENIInfos().ENIIPPools
+	byCIDR := make(map[string]*PodCIDR)
+	for _, eni := range enis {
+		pool, ok := pools[eni.ENIID]
+		if !ok || len(pool.IPv4Addresses) == 0 || eni.SubnetIPv4CIDR == "" {
+			continue
+		}
+		podCIDR, ok := byCIDR[eni.SubnetIPv4CIDR]
+		if !ok {
+			podCIDR = &PodCIDR{CIDR: eni.SubnetIPv4CIDR, SubnetID: eni.SubnetID}
+			byCIDR[eni.SubnetIPv4CIDR] = podCIDR
+		}
+		podCIDR.ENIs = append(podCIDR.ENIs, eni.ENIID)
+	}
+
+	result := make([]PodCIDR, 0, len(byCIDR))
+	for _, podCIDR := range byCIDR {
+		sort.Strings(podCIDR.ENIs)
+		result = append(result, *podCIDR)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CIDR < result[j].CIDR
+	})
+	return result, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strings"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+const (
+	// This environment variable is used to specify the comma separated list of interfaces the CNI plugin creates in
+	// every pod in addition to eth0. It is written to the CNI config by the entrypoint, ipamd only reads it to recover
+	// the IPs of those interfaces after a restart.
+	envAdditionalInterfaces = "AWS_VPC_K8S_CNI_ADDITIONAL_INTERFACES"
+
+	// This environment variable is used to specify the prefix of the host side veth devices, see the CNI config
+	envVethPrefix     = "AWS_VPC_K8S_CNI_VETHPREFIX"
+	defaultVethPrefix = "eni"
+)
+
+// recoverPodInterfaces puts back in the datastore the IPs of the additional interfaces of a running pod, found from the
+// routes to the host side veth devices of those interfaces.
+func (c *IPAMContext) recoverPodInterfaces(pod *k8sapi.K8SPodInfo, ifNames []string) {
+	if len(ifNames) == 0 {
+		return
+	}
+	prefix := getVethPrefix()
+	for _, ifName := range ifNames {
+		hostVethName := networkutils.GenerateInterfaceHostVethName(prefix, pod.Namespace, pod.Name, ifName)
+		ips, err := c.networkClient.GetHostVethIPv4Addresses(hostVethName)
+		if err != nil {
+			log.Debugf("No interface %s found for Pod %s, Namespace %s: %v", ifName, pod.Name, pod.Namespace, err)
+			continue
+		}
+		for _, ip := range ips {
+			log.Infof("Recovered interface %s with IP %s for Pod %s, Namespace %s, Sandbox %s",
+				ifName, ip, pod.Name, pod.Namespace, pod.Sandbox)
+			_, _, err = c.dataStore.AssignPodInterfaceIPv4Address(&k8sapi.K8SPodInfo{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+				Sandbox:   pod.Sandbox,
+				UID:       pod.UID,
+				IP:        ip,
+			}, ifName)
+			if err != nil {
+				ipamdErrInc("nodeInitAssignPodInterfaceIPv4AddressFailed")
+				log.Warnf("During ipamd init, failed to use IP %s of interface %s: %v", ip, ifName, err)
+			}
+		}
+	}
+}
+
+func getAdditionalInterfaces() []string {
+	var ifNames []string
+	for _, ifName := range strings.Split(os.Getenv(envAdditionalInterfaces), ",") {
+		if ifName = strings.TrimSpace(ifName); ifName != "" {
+			ifNames = append(ifNames, ifName)
+		}
+	}
+	return ifNames
+}
+
+func getVethPrefix() string {
+	if prefix, found := os.LookupEnv(envVethPrefix); found && prefix != "" {
+		return prefix
+	}
+	return defaultVethPrefix
+}
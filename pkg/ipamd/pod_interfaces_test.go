@@ -0,0 +1,58 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+func TestRecoverPodInterfaces(t *testing.T) {
+	ctrl, _, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	_ = os.Setenv(envAdditionalInterfaces, "eth1, eth2")
+	defer os.Unsetenv(envAdditionalInterfaces)
+	ifNames := getAdditionalInterfaces()
+	assert.Equal(t, []string{"eth1", "eth2"}, ifNames)
+
+	ds := datastore.NewDataStore()
+	_ = ds.AddENI(primaryENIid, primaryDevice, true)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	mockContext := &IPAMContext{dataStore: ds, networkClient: mockNetwork}
+
+	pod := &k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", Sandbox: "cid", IP: ipaddr01}
+	_, _, err := ds.AssignPodIPv4Address(pod)
+	assert.NoError(t, err)
+
+	mockNetwork.EXPECT().GetHostVethIPv4Addresses(networkutils.GenerateInterfaceHostVethName(defaultVethPrefix, "ns", "pod", "eth1")).
+		Return([]string{ipaddr02}, nil)
+	mockNetwork.EXPECT().GetHostVethIPv4Addresses(networkutils.GenerateInterfaceHostVethName(defaultVethPrefix, "ns", "pod", "eth2")).
+		Return(nil, errors.New("link not found"))
+	mockContext.recoverPodInterfaces(pod, ifNames)
+
+	podInfos := *ds.GetPodInfos()
+	assert.Equal(t, 2, len(podInfos))
+	assert.Equal(t, ipaddr02, podInfos["pod_ns_cid_eth1"].IP)
+	_, assigned := ds.GetStats()
+	assert.Equal(t, 2, assigned)
+}
@@ -0,0 +1,376 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to enable persisting pod IP assignments to disk, so that after a node reboot a
+	// pod that comes back with the same UID gets its previous IP address again, if that IP is still free.
+	// It requires the container runtime to pass K8S_POD_UID in the CNI args. Defaults to false.
+	envPreservePodIPs = "PRESERVE_POD_IPS"
+
+	// This environment variable is used to specify the directory the pod IP assignments are written to when
+	// PRESERVE_POD_IPS is enabled. It must be on a host path that survives reboots.
+	envPodIPStateDir     = "POD_IP_STATE_DIR"
+	defaultPodIPStateDir = "/var/lib/aws-node/pod-ips"
+
+	// This environment variable is used to specify, in seconds, how long the pod IP state file of a pod that is neither
+	// running on the node nor known to the API server is kept before it is removed. The state directory is swept at
+	// startup and every podIPStateSweepInterval. When it is not set, it defaults to 600 seconds.
+	envPodIPStateRetention     = "POD_IP_STATE_RETENTION"
+	defaultPodIPStateRetention = 10 * time.Minute
+	podIPStateSweepInterval    = 5 * time.Minute
+
+	podIPStateFileSuffix = ".json"
+)
+
+// podIPState is the IP address assignment of a pod, as persisted on disk
+type podIPState struct {
+	UID       string
+	Name      string
+	Namespace string
+	IP        string
+}
+
+// PodIPRestoreStats describes the pod IP assignments restored from disk at startup
+type PodIPRestoreStats struct {
+	Enabled  bool
+	StateDir string
+	// Loaded is the number of assignments read from disk
+	Loaded int
+	// AlreadyRunning is the number of pods that were still running with their IP when ipamd started
+	AlreadyRunning int
+	// Pending is the number of pods that have not been added back yet
+	Pending int
+	// Restored is the number of pods that got their previous IP back
+	Restored int
+	// Conflicts is the number of pods whose previous IP was assigned to another pod in the meantime
+	Conflicts int
+	// Unavailable is the number of assignments dropped because the IP is no longer on the node
+	Unavailable int
+	// Cleaned is the number of state files removed by the sweeps because their pod is gone, LastSweep the last sweep
+	Cleaned   int
+	LastSweep time.Time
+}
+
+// podIPStateStore persists one file per assigned pod IP, and keeps the assignments loaded at startup that are waiting
+// for their pod to be added back.
+type podIPStateStore struct {
+	dir string
+	// retention is how long the state file of a pod that is gone is kept
+	retention time.Duration
+	lock      sync.Mutex
+	pending   map[string]podIPState
+	stats     PodIPRestoreStats
+}
+
+func newPodIPStateStore(dir string, retention time.Duration) *podIPStateStore {
+	return &podIPStateStore{
+		dir:       dir,
+		retention: retention,
+		pending:   make(map[string]podIPState),
+		stats:     PodIPRestoreStats{Enabled: true, StateDir: dir},
+	}
+}
+
+func (s *podIPStateStore) path(ip string) string {
+	return filepath.Join(s.dir, ip+podIPStateFileSuffix)
+}
+
+// load reads all persisted pod IP assignments
+func (s *podIPStateStore) load() ([]podIPState, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read pod IP state directory %s", s.dir)
+	}
+	var states []podIPState
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), podIPStateFileSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			log.Warnf("Failed to read pod IP state file %s: %v", file.Name(), err)
+			continue
+		}
+		var state podIPState
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Warnf("Failed to parse pod IP state file %s: %v", file.Name(), err)
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// save writes the assignment of a pod IP, replacing any previous owner of that IP
+func (s *podIPStateStore) save(state podIPState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create pod IP state directory %s", s.dir)
+	}
+	tmp := s.path(state.IP) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write pod IP state for %s", state.IP)
+	}
+	return os.Rename(tmp, s.path(state.IP))
+}
+
+// remove deletes the persisted assignment of a pod IP
+func (s *podIPStateStore) remove(ip string) error {
+	if err := os.Remove(s.path(ip)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove pod IP state for %s", ip)
+	}
+	return nil
+}
+
+// takePending returns and forgets the assignment loaded at startup for the pod UID
+func (s *podIPStateStore) takePending(uid string) (podIPState, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state, ok := s.pending[uid]
+	if ok {
+		delete(s.pending, uid)
+		s.stats.Pending = len(s.pending)
+	}
+	return state, ok
+}
+
+// recordRestore counts the outcome of re-adding a pod that had a pending assignment
+func (s *podIPStateStore) recordRestore(restored bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if restored {
+		s.stats.Restored++
+	} else {
+		s.stats.Conflicts++
+	}
+}
+
+func (s *podIPStateStore) getStats() PodIPRestoreStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stats
+}
+
+// restorePodIPState loads the persisted pod IP assignments and sorts them into pods that are still running, pods
+// whose IP was given to someone else, IPs that are no longer on the node, and pods that have not been added back
+// yet. It must run after the datastore has been rebuilt and before AddNetwork requests are served.
+func (c *IPAMContext) restorePodIPState() {
+	if c.podIPState == nil {
+		return
+	}
+	s := c.podIPState
+	states, err := s.load()
+	if err != nil {
+		log.Errorf("Failed to load pod IP state: %v", err)
+		return
+	}
+
+	// IPs in the datastore, and the UID of the pod they are assigned to, if any
+	assignedTo := make(map[string]string)
+	for _, podInfo := range *c.dataStore.GetPodInfos() {
+		assignedTo[podInfo.IP] = podInfo.UID
+	}
+	knownIPs := make(map[string]bool)
+	for _, eni := range c.dataStore.GetENIInfos().ENIIPPools {
+		for ip := range eni.IPv4Addresses {
+			knownIPs[ip] = true
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, state := range states {
+		s.stats.Loaded++
+		uid, assigned := assignedTo[state.IP]
+		switch {
+		case assigned && uid == state.UID:
+			s.stats.AlreadyRunning++
+			continue
+		case assigned:
+			log.Infof("IP %s of Pod %s, Namespace %s was reassigned while ipamd was down", state.IP, state.Name, state.Namespace)
+			s.stats.Conflicts++
+		case !knownIPs[state.IP]:
+			log.Infof("IP %s of Pod %s, Namespace %s is no longer on the node", state.IP, state.Name, state.Namespace)
+			s.stats.Unavailable++
+		case state.UID == "":
+			s.stats.Unavailable++
+		default:
+			log.Infof("Keeping IP %s for Pod %s, Namespace %s until it is added back", state.IP, state.Name, state.Namespace)
+			s.pending[state.UID] = state
+			continue
+		}
+		if err := s.remove(state.IP); err != nil {
+			log.Warnf("Failed to remove stale pod IP state: %v", err)
+		}
+	}
+	s.stats.Pending = len(s.pending)
+	log.Infof("Pod IP state restore: %+v", s.stats)
+}
+
+// sweep removes the state files older than the retention whose pod is not kept, along with their pending assignment,
+// and the files that cannot be parsed. It returns the number of removed files.
+func (s *podIPStateStore) sweep(keep func(state podIPState) bool) (int, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrapf(err, "failed to read pod IP state directory %s", s.dir)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cleaned := 0
+	for _, file := range files {
+		if file.IsDir() || time.Since(file.ModTime()) < s.retention {
+			continue
+		}
+		path := filepath.Join(s.dir, file.Name())
+		// Left over by a write interrupted before the rename
+		stale := strings.HasSuffix(file.Name(), podIPStateFileSuffix+".tmp")
+		if strings.HasSuffix(file.Name(), podIPStateFileSuffix) {
+			var state podIPState
+			data, err := ioutil.ReadFile(path)
+			if err == nil {
+				err = json.Unmarshal(data, &state)
+			}
+			switch {
+			case err != nil:
+				log.Warnf("Removing unreadable pod IP state file %s: %v", file.Name(), err)
+				stale = true
+			case !keep(state):
+				log.Infof("Removing pod IP state of IP %s, Pod %s, Namespace %s is gone", state.IP, state.Name, state.Namespace)
+				if pending, ok := s.pending[state.UID]; ok && pending.IP == state.IP {
+					delete(s.pending, state.UID)
+				}
+				stale = true
+			}
+		}
+		if !stale {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to remove pod IP state file %s: %v", file.Name(), err)
+			continue
+		}
+		cleaned++
+	}
+	s.stats.Pending = len(s.pending)
+	s.stats.Cleaned += cleaned
+	s.stats.LastSweep = time.Now()
+	return cleaned, nil
+}
+
+// sweepPodIPState removes the pod IP state files of the pods that are neither assigned their IP in the datastore nor
+// in localPods, the pods of the node known to the API server
+func (c *IPAMContext) sweepPodIPState(localPods []*k8sapi.K8SPodInfo) {
+	if c.podIPState == nil {
+		return
+	}
+	knownUIDs := make(map[string]bool)
+	for _, pod := range localPods {
+		knownUIDs[pod.UID] = true
+	}
+	assignedTo := make(map[string]string)
+	for _, podInfo := range *c.dataStore.GetPodInfos() {
+		assignedTo[podInfo.IP] = podInfo.UID
+	}
+
+	cleaned, err := c.podIPState.sweep(func(state podIPState) bool {
+		if uid, assigned := assignedTo[state.IP]; assigned && uid == state.UID {
+			return true
+		}
+		return state.UID != "" && knownUIDs[state.UID]
+	})
+	if err != nil {
+		log.Errorf("Failed to sweep pod IP state: %v", err)
+		return
+	}
+	if cleaned > 0 {
+		log.Infof("Removed %d stale pod IP state files", cleaned)
+	}
+}
+
+// sweepPodIPStateIfDue sweeps the pod IP state files when the last sweep is older than podIPStateSweepInterval
+func (c *IPAMContext) sweepPodIPStateIfDue() {
+	if c.podIPState == nil || time.Since(c.podIPState.getStats().LastSweep) < podIPStateSweepInterval {
+		return
+	}
+	localPods, err := c.k8sClient.K8SGetLocalPodIPs()
+	if err != nil {
+		// Without the pods known to the API server, the pods waiting to be added back cannot be told apart
+		log.Warnf("Skipping pod IP state sweep, failed to get the local pods: %v", err)
+		return
+	}
+	c.sweepPodIPState(localPods)
+}
+
+// GetPodIPRestoreStats returns the results of restoring pod IP assignments from disk
+func (c *IPAMContext) GetPodIPRestoreStats() PodIPRestoreStats {
+	if c.podIPState == nil {
+		return PodIPRestoreStats{}
+	}
+	return c.podIPState.getStats()
+}
+
+func preservePodIPs() bool {
+	return getEnvBoolWithDefault(envPreservePodIPs, false)
+}
+
+func getPodIPStateRetention() time.Duration {
+	inputStr, found := os.LookupEnv(envPodIPStateRetention)
+
+	if !found {
+		return defaultPodIPStateRetention
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil {
+		if input >= 0 {
+			log.Debugf("Using POD_IP_STATE_RETENTION %v", input)
+			return time.Duration(input) * time.Second
+		}
+	}
+	log.Errorf("Invalid POD_IP_STATE_RETENTION %q, using default %v", inputStr, defaultPodIPStateRetention)
+	return defaultPodIPStateRetention
+}
+
+func getPodIPStateDir() string {
+	if dir, found := os.LookupEnv(envPodIPStateDir); found && dir != "" {
+		return dir
+	}
+	return defaultPodIPStateDir
+}
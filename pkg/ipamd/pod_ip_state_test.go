@@ -0,0 +1,113 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+func TestRestorePodIPState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pod-ips")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stateStore := newPodIPStateStore(dir, defaultPodIPStateRetention)
+	// Saved before the reboot
+	assert.NoError(t, stateStore.save(podIPState{UID: "uid-running", Name: "running", Namespace: "ns", IP: ipaddr01}))
+	assert.NoError(t, stateStore.save(podIPState{UID: "uid-restart", Name: "restart", Namespace: "ns", IP: ipaddr02}))
+	assert.NoError(t, stateStore.save(podIPState{UID: "uid-gone", Name: "gone", Namespace: "ns", IP: ipaddr11}))
+
+	ds := datastore.NewDataStore()
+	_ = ds.AddENI(primaryENIid, primaryDevice, true)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, ipaddr03)
+	// Recovered from the API server
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "running", Namespace: "ns", Sandbox: "s1", IP: ipaddr01, UID: "uid-running"})
+	assert.NoError(t, err)
+
+	c := &IPAMContext{dataStore: ds, podIPState: stateStore}
+	c.restorePodIPState()
+
+	stats := c.GetPodIPRestoreStats()
+	assert.Equal(t, 3, stats.Loaded)
+	assert.Equal(t, 1, stats.AlreadyRunning)
+	assert.Equal(t, 1, stats.Pending)
+	assert.Equal(t, 1, stats.Unavailable)
+
+	// The restarted pod gets its previous IP back
+	addr, _, err := c.assignPodIPv4AddressOnce(&k8sapi.K8SPodInfo{Name: "restart", Namespace: "ns", Sandbox: "s2", UID: "uid-restart"})
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr02, addr)
+
+	stats = c.GetPodIPRestoreStats()
+	assert.Equal(t, 1, stats.Restored)
+	assert.Equal(t, 0, stats.Pending)
+
+	states, err := stateStore.load()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(states))
+}
+
+func TestSweepPodIPState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pod-ips")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stateStore := newPodIPStateStore(dir, 0)
+	assert.NoError(t, stateStore.save(podIPState{UID: "uid-running", Name: "running", Namespace: "ns", IP: ipaddr01}))
+	assert.NoError(t, stateStore.save(podIPState{UID: "uid-restart", Name: "restart", Namespace: "ns", IP: ipaddr02}))
+	assert.NoError(t, stateStore.save(podIPState{UID: "uid-gone", Name: "gone", Namespace: "ns", IP: ipaddr03}))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "10.10.10.14.json"), []byte("{"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "10.10.10.15.json.tmp"), []byte("{}"), 0600))
+
+	ds := datastore.NewDataStore()
+	_ = ds.AddENI(primaryENIid, primaryDevice, true)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+	_ = ds.AddIPv4AddressToStore(primaryENIid, ipaddr03)
+	_, _, err = ds.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "running", Namespace: "ns", Sandbox: "s1", IP: ipaddr01, UID: "uid-running"})
+	assert.NoError(t, err)
+
+	c := &IPAMContext{dataStore: ds, podIPState: stateStore}
+	c.restorePodIPState()
+	assert.Equal(t, 2, c.GetPodIPRestoreStats().Pending)
+
+	// The restarted pod is known to the API server, the other one was deleted while ipamd was down
+	c.sweepPodIPState([]*k8sapi.K8SPodInfo{{Name: "restart", Namespace: "ns", UID: "uid-restart"}})
+	stats := c.GetPodIPRestoreStats()
+	assert.Equal(t, 3, stats.Cleaned)
+	assert.Equal(t, 1, stats.Pending)
+	assert.False(t, stats.LastSweep.IsZero())
+
+	states, err := stateStore.load()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{ipaddr01, ipaddr02}, []string{states[0].IP, states[1].IP})
+	_, pending := stateStore.takePending("uid-gone")
+	assert.False(t, pending)
+
+	// Within the retention, nothing is removed
+	stateStore.retention = time.Hour
+	c.sweepPodIPState(nil)
+	assert.Equal(t, 3, c.GetPodIPRestoreStats().Cleaned)
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+// PodInfo is the IP of a pod and the host IP rules routing its traffic, for introspection
+type PodInfo struct {
+	datastore.PodIPInfo
+	// RouteTable is the host route table of the traffic from the pod. The CNI plugin uses the device number of the ENI
+	// of the IP, or the main table for the primary ENI.
+	RouteTable int
+	Rules      []networkutils.PodRule
+	// RulesMissing is true when one of the rules is not on the host, and RulesError why the rules could not be checked
+	RulesMissing bool   `json:",omitempty"`
+	RulesError   string `json:",omitempty"`
+}
+
+// GetPodInfos returns the pod IPs of the datastore, with the host IP rules of each pod
+func (c *IPAMContext) GetPodInfos() map[string]PodInfo {
+	ruleList, err := c.networkClient.GetRuleList()
+	podInfos := make(map[string]PodInfo)
+	for key, ipInfo := range *c.dataStore.GetPodInfos() {
+		podInfo := PodInfo{PodIPInfo: ipInfo, RouteTable: ipInfo.DeviceNumber}
+		if ipInfo.DeviceNumber == 0 {
+			podInfo.RouteTable = unix.RT_TABLE_MAIN
+		}
+		if err != nil {
+			podInfo.RulesError = err.Error()
+		} else {
+			podInfo.Rules = networkutils.CheckPodRules(ruleList, ipInfo.IP, ipInfo.DeviceNumber)
+			for _, rule := range podInfo.Rules {
+				podInfo.RulesMissing = podInfo.RulesMissing || rule.Missing
+			}
+		}
+		podInfos[key] = podInfo
+	}
+	return podInfos
+}
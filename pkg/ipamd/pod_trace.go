@@ -0,0 +1,136 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// maxPodTraces is the number of most recent AddNetwork decision traces that are kept
+	maxPodTraces = 500
+
+	// Where the IP address of a pod came from
+	podIPSourceWarmPool   = "warm-pool"
+	podIPSourcePoolGrowth = "pool-growth"
+	podIPSourceRestored   = "restored"
+	podIPSourceDuplicate  = "duplicate"
+	podIPSourceReused     = "reused"
+)
+
+// PodTrace is how the IP address of a pod was chosen by an AddNetwork request, for introspection
+type PodTrace struct {
+	Name      string
+	Namespace string
+	Sandbox   string
+	Time      time.Time
+	IP        string `json:",omitempty"`
+	ENIID     string `json:",omitempty"`
+	// DeviceNumber is the device number of ENIID
+	DeviceNumber int
+	// Source is "warm-pool" when a free IP of the pool was taken, "pool-growth" when the request waited for the pool to
+	// grow, "restored" when the pod got back the IP it had before a reboot, "reused" when a new sandbox of the pod got
+	// back the IP it released recently and "duplicate" when it already had the IP
+	Source string `json:",omitempty"`
+	// Steps are the decisions and fallbacks of the request, in order
+	Steps      []string
+	DurationMs float64
+	Error      string `json:",omitempty"`
+}
+
+// podTraceState keeps the traces of the AddNetwork requests in progress and of the most recent ones
+type podTraceState struct {
+	inProgress map[string]*PodTrace
+	recent     []PodTrace
+	lock       sync.Mutex
+}
+
+func podTraceKey(pod *k8sapi.K8SPodInfo) string {
+	return pod.Namespace + "/" + pod.Name + "/" + pod.Sandbox
+}
+
+// begin starts the trace of an AddNetwork request for the pod
+func (s *podTraceState) begin(pod *k8sapi.K8SPodInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.inProgress == nil {
+		s.inProgress = make(map[string]*PodTrace)
+	}
+	s.inProgress[podTraceKey(pod)] = &PodTrace{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Sandbox:   pod.Sandbox,
+		Time:      time.Now(),
+		Source:    podIPSourceWarmPool,
+	}
+}
+
+// step adds a decision to the trace of the request in progress for the pod, if any
+func (s *podTraceState) step(pod *k8sapi.K8SPodInfo, format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if trace, ok := s.inProgress[podTraceKey(pod)]; ok {
+		trace.Steps = append(trace.Steps, fmt.Sprintf(format, args...))
+	}
+}
+
+// setSource sets where the IP of the request in progress for the pod comes from
+func (s *podTraceState) setSource(pod *k8sapi.K8SPodInfo, source string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if trace, ok := s.inProgress[podTraceKey(pod)]; ok {
+		trace.Source = source
+	}
+}
+
+// finish ends the trace of the request for the pod and keeps it with the most recent ones
+func (s *podTraceState) finish(pod *k8sapi.K8SPodInfo, ip string, eniID string, deviceNumber int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := podTraceKey(pod)
+	trace, ok := s.inProgress[key]
+	if !ok {
+		return
+	}
+	delete(s.inProgress, key)
+	trace.IP = ip
+	trace.ENIID = eniID
+	trace.DeviceNumber = deviceNumber
+	trace.DurationMs = msSince(trace.Time)
+	if err != nil {
+		trace.Source = ""
+		trace.Error = err.Error()
+	}
+	s.recent = append(s.recent, *trace)
+	s.recent = s.recent[keepLast(len(s.recent), maxPodTraces):]
+}
+
+// GetPodTraces returns the most recent traces of the pod, newest first. An empty namespace or name matches any.
+func (c *IPAMContext) GetPodTraces(name string, namespace string) []PodTrace {
+	c.podTraces.lock.Lock()
+	defer c.podTraces.lock.Unlock()
+	traces := make([]PodTrace, 0)
+	for i := len(c.podTraces.recent) - 1; i >= 0; i-- {
+		trace := c.podTraces.recent[i]
+		if (name != "" && trace.Name != name) || (namespace != "" && trace.Namespace != namespace) {
+			continue
+		}
+		traces = append(traces, trace)
+	}
+	return traces
+}
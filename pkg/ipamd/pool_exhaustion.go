@@ -0,0 +1,98 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// allocationRateWindow is the period over which IP assignments are counted to update the allocation rate
+	allocationRateWindow = time.Minute
+	// allocationRateWeight is the weight of the last window in the moving allocation rate
+	allocationRateWeight = 0.3
+)
+
+// PoolExhaustionStats estimates when the free IPs of the pool run out at the current allocation rate, for
+// introspection
+type PoolExhaustionStats struct {
+	// AllocationRate is the moving average of the IPs assigned to pods per minute
+	AllocationRate float64
+	FreeIPs        int
+	// TimeToExhaustion is how long the free IPs last at AllocationRate, if the pool does not grow, until
+	// ExhaustedAt. Both are unset when the allocation rate is zero or the pool is already Exhausted.
+	TimeToExhaustion time.Duration `json:",omitempty"`
+	ExhaustedAt      time.Time     `json:",omitempty"`
+	Exhausted        bool
+}
+
+// allocationRateState keeps the moving average of the IP assignments per allocationRateWindow
+type allocationRateState struct {
+	rate        float64
+	count       int
+	windowStart time.Time
+	lock        sync.Mutex
+}
+
+// roll closes the windows that ended before now, and folds their counts into the moving rate
+func (s *allocationRateState) roll(now time.Time) {
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+		return
+	}
+	for now.Sub(s.windowStart) >= allocationRateWindow {
+		s.rate = allocationRateWeight*float64(s.count) + (1-allocationRateWeight)*s.rate
+		s.count = 0
+		s.windowStart = s.windowStart.Add(allocationRateWindow)
+		if s.rate < 0.01 {
+			// After a long idle period, the rate is zero and the remaining windows are skipped
+			s.rate = 0
+			if elapsed := now.Sub(s.windowStart); elapsed >= allocationRateWindow {
+				s.windowStart = s.windowStart.Add(elapsed.Truncate(allocationRateWindow))
+			}
+		}
+	}
+}
+
+// record counts ips IP addresses assigned to a pod at now
+func (s *allocationRateState) record(now time.Time, ips int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.roll(now)
+	s.count += ips
+}
+
+// perMinute returns the moving average of the IPs assigned per minute at now
+func (s *allocationRateState) perMinute(now time.Time) float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.roll(now)
+	return s.rate * float64(time.Minute) / float64(allocationRateWindow)
+}
+
+// getPoolExhaustionStats estimates when the free IPs of the pool run out at the current allocation rate
+func (c *IPAMContext) getPoolExhaustionStats(total int, assigned int) PoolExhaustionStats {
+	now := time.Now()
+	stats := PoolExhaustionStats{
+		AllocationRate: c.allocationRate.perMinute(now),
+		FreeIPs:        max(total-assigned, 0),
+	}
+	stats.Exhausted = stats.FreeIPs == 0
+	if stats.AllocationRate > 0 && !stats.Exhausted {
+		stats.TimeToExhaustion = time.Duration(float64(stats.FreeIPs) / stats.AllocationRate * float64(time.Minute))
+		stats.ExhaustedAt = now.Add(stats.TimeToExhaustion)
+	}
+	return stats
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify what ipamd does when the primary IP of the primary ENI is missing
+	// from the primary interface of the host, which breaks host networking:
+	//   "log" (default): log an error on each reconcile until the IP is back
+	//   "reassign": log an error and add the IP back to the primary interface
+	envPrimaryIPLostPolicy = "PRIMARY_IP_LOST_POLICY"
+
+	primaryIPLostLog      = "log"
+	primaryIPLostReassign = "reassign"
+)
+
+// PrimaryENIStatus is the health of the primary IP of the primary ENI, for introspection
+type PrimaryENIStatus struct {
+	ENIID string
+	MAC   string
+	// ExpectedIP is the primary IP of the instance, as reported by the instance metadata at startup
+	ExpectedIP string
+	Policy     string
+	// Healthy is true when the last check found ExpectedIP both on the primary ENI in EC2 and on the host interface
+	Healthy        bool
+	AssignedInEC2  bool
+	AssignedOnHost bool
+	LastCheck      time.Time
+	// LostSince is the first check of the current outage, zero when healthy
+	LostSince time.Time
+	// Reassigns is the number of times ipamd added the IP back to the host interface
+	Reassigns int
+	LastError string `json:",omitempty"`
+}
+
+// primaryENIState keeps the result of the last primary IP check
+type primaryENIState struct {
+	status PrimaryENIStatus
+	lock   sync.RWMutex
+}
+
+// checkPrimaryIP verifies that the primary IP of the instance is still the primary IP of the primary ENI in EC2 and
+// is still assigned to the primary interface of the host, and handles a lost IP per PRIMARY_IP_LOST_POLICY
+func (c *IPAMContext) checkPrimaryIP(allENIs []awsutils.ENIMetadata) {
+	status := PrimaryENIStatus{
+		ENIID:      c.awsClient.GetPrimaryENI(),
+		ExpectedIP: c.awsClient.GetLocalIPv4(),
+		Policy:     c.primaryIPLostPolicy,
+		LastCheck:  time.Now(),
+	}
+
+	var primaryENI *awsutils.ENIMetadata
+	for i := range allENIs {
+		if allENIs[i].ENIID == status.ENIID {
+			primaryENI = &allENIs[i]
+			break
+		}
+	}
+	var err error
+	reassigned := false
+	if primaryENI == nil {
+		err = errors.Errorf("primary ENI %s is not attached", status.ENIID)
+	} else {
+		status.MAC = primaryENI.MAC
+		status.AssignedInEC2 = primaryENI.PrimaryIPv4Address() == status.ExpectedIP
+		if !status.AssignedInEC2 {
+			err = errors.Errorf("primary IP of primary ENI %s is %s instead of %s",
+				status.ENIID, primaryENI.PrimaryIPv4Address(), status.ExpectedIP)
+		}
+		var hostErr error
+		status.AssignedOnHost, reassigned, hostErr = c.checkPrimaryIPOnHost(primaryENI, status.ExpectedIP)
+		if hostErr != nil {
+			err = hostErr
+		}
+	}
+	status.Healthy = status.AssignedInEC2 && status.AssignedOnHost
+	if err != nil {
+		status.LastError = err.Error()
+	}
+
+	c.primaryENI.lock.Lock()
+	defer c.primaryENI.lock.Unlock()
+	status.Reassigns = c.primaryENI.status.Reassigns
+	if reassigned {
+		status.Reassigns++
+	}
+	if !status.Healthy {
+		status.LostSince = c.primaryENI.status.LostSince
+		if status.LostSince.IsZero() {
+			status.LostSince = status.LastCheck
+		}
+		log.Errorf("Primary IP %s of primary ENI %s is lost since %s, host networking may be broken: %v",
+			status.ExpectedIP, status.ENIID, status.LostSince.Format(time.RFC3339), err)
+		ipamdErrInc("primaryIPLost")
+	} else if !c.primaryENI.status.LostSince.IsZero() {
+		log.Infof("Primary IP %s of primary ENI %s is back", status.ExpectedIP, status.ENIID)
+	}
+	c.primaryENI.status = status
+}
+
+// checkPrimaryIPOnHost returns whether the primary IP is assigned to the host interface of the primary ENI, and
+// whether ipamd added it back because the policy is "reassign"
+func (c *IPAMContext) checkPrimaryIPOnHost(primaryENI *awsutils.ENIMetadata, ip string) (found bool, reassigned bool, err error) {
+	found, err = c.networkClient.HasLinkIPv4Address(primaryENI.MAC, ip)
+	if err != nil {
+		return false, false, errors.Wrap(err, "failed to check the primary IP on the host")
+	}
+	if found {
+		return true, false, nil
+	}
+	if c.primaryIPLostPolicy != primaryIPLostReassign {
+		return false, false, errors.Errorf("primary IP %s is not assigned to the interface of primary ENI %s", ip, primaryENI.ENIID)
+	}
+
+	log.Warnf("Adding primary IP %s back to the interface of primary ENI %s", ip, primaryENI.ENIID)
+	if err := c.networkClient.AddLinkIPv4Address(primaryENI.MAC, ip, primaryENI.SubnetIPv4CIDR); err != nil {
+		return false, true, errors.Wrapf(err, "failed to add primary IP %s back to the host", ip)
+	}
+	return true, true, nil
+}
+
+// GetPrimaryENIStatus returns the result of the last primary IP check
+func (c *IPAMContext) GetPrimaryENIStatus() PrimaryENIStatus {
+	c.primaryENI.lock.RLock()
+	defer c.primaryENI.lock.RUnlock()
+	status := c.primaryENI.status
+	if status.Policy == "" {
+		status.Policy = c.primaryIPLostPolicy
+	}
+	return status
+}
+
+func getPrimaryIPLostPolicy() string {
+	return envsetting.Choice(envPrimaryIPLostPolicy, primaryIPLostLog, primaryIPLostLog, primaryIPLostReassign)
+}
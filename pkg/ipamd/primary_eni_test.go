@@ -0,0 +1,86 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+func TestCheckPrimaryIP(t *testing.T) {
+	ctrl, mockAWS, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:           mockAWS,
+		networkClient:       mockNetwork,
+		primaryIPLostPolicy: primaryIPLostLog,
+	}
+	enis := []awsutils.ENIMetadata{{
+		ENIID:          primaryENIid,
+		MAC:            primaryMAC,
+		SubnetIPv4CIDR: primarySubnet,
+		IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+			{PrivateIpAddress: aws.String(ipaddr01), Primary: aws.Bool(true)},
+		},
+	}}
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid).AnyTimes()
+	mockAWS.EXPECT().GetLocalIPv4().Return(ipaddr01).AnyTimes()
+
+	mockNetwork.EXPECT().HasLinkIPv4Address(primaryMAC, ipaddr01).Return(true, nil)
+	mockContext.checkPrimaryIP(enis)
+	status := mockContext.GetPrimaryENIStatus()
+	assert.True(t, status.Healthy)
+	assert.Equal(t, primaryMAC, status.MAC)
+	assert.True(t, status.LostSince.IsZero())
+
+	// Lost on the host, only logged
+	mockNetwork.EXPECT().HasLinkIPv4Address(primaryMAC, ipaddr01).Return(false, nil)
+	mockContext.checkPrimaryIP(enis)
+	status = mockContext.GetPrimaryENIStatus()
+	assert.False(t, status.Healthy)
+	assert.True(t, status.AssignedInEC2)
+	assert.False(t, status.AssignedOnHost)
+	assert.False(t, status.LostSince.IsZero())
+	assert.Equal(t, 0, status.Reassigns)
+	lostSince := status.LostSince
+
+	// Lost on the host, added back
+	mockContext.primaryIPLostPolicy = primaryIPLostReassign
+	mockNetwork.EXPECT().HasLinkIPv4Address(primaryMAC, ipaddr01).Return(false, nil)
+	mockNetwork.EXPECT().AddLinkIPv4Address(primaryMAC, ipaddr01, primarySubnet).Return(nil)
+	mockContext.checkPrimaryIP(enis)
+	status = mockContext.GetPrimaryENIStatus()
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 1, status.Reassigns)
+	assert.True(t, status.LostSince.IsZero())
+
+	// The primary ENI has another primary IP in EC2, which cannot be fixed from the host
+	mockContext.primaryIPLostPolicy = primaryIPLostLog
+	mockContext.primaryENI.status.LostSince = lostSince
+	enis[0].IPv4Addresses[0].PrivateIpAddress = aws.String(ipaddr02)
+	mockNetwork.EXPECT().HasLinkIPv4Address(primaryMAC, ipaddr01).Return(true, nil)
+	mockContext.checkPrimaryIP(enis)
+	status = mockContext.GetPrimaryENIStatus()
+	assert.False(t, status.Healthy)
+	assert.False(t, status.AssignedInEC2)
+	assert.Equal(t, lostSince, status.LostSince)
+	assert.Contains(t, status.LastError, ipaddr02)
+	assert.Equal(t, 1, status.Reassigns)
+}
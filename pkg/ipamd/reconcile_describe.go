@@ -0,0 +1,105 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+const (
+	// This environment variable is used to specify how many DescribeNetworkInterfaces calls the IP pool reconcile
+	// makes at the same time when it verifies the IPs of the ENIs with EC2. The calls still go through the retries
+	// and throttling backoff of the EC2 client. When it is not set, the ENIs are described one at a time.
+	envReconcileDescribeConcurrency     = "RECONCILE_DESCRIBE_CONCURRENCY"
+	defaultReconcileDescribeConcurrency = 1
+	maxReconcileDescribeConcurrency     = 16
+)
+
+// describedENI is the result of describing an ENI with EC2 during a reconcile
+type describedENI struct {
+	addresses []*ec2.NetworkInterfacePrivateIpAddress
+	err       error
+}
+
+// enisToDescribe returns the attached ENIs of the data store that have an IP out of its reconcile cooldown, whose IPs
+// must be verified with EC2
+func (c *IPAMContext) enisToDescribe(attachedENIs []awsutils.ENIMetadata) []string {
+	var enis []string
+	for _, attachedENI := range attachedENIs {
+		if _, err := c.dataStore.GetENIIPPools(attachedENI.ENIID); err != nil {
+			continue
+		}
+		for _, privateIPv4 := range attachedENI.IPv4Addresses {
+			strPrivateIPv4 := aws.StringValue(privateIPv4.PrivateIpAddress)
+			if strPrivateIPv4 == c.primaryIP[attachedENI.ENIID] {
+				continue
+			}
+			if found, recentlyFreed := c.reconcileCooldownCache.RecentlyFreed(strPrivateIPv4); found && !recentlyFreed {
+				enis = append(enis, attachedENI.ENIID)
+				break
+			}
+		}
+	}
+	return enis
+}
+
+// describeENIs describes the ENIs with EC2, with at most reconcileDescribeConcurrency calls at a time, and returns the
+// results by ENI ID once all the calls are done
+func (c *IPAMContext) describeENIs(enis []string) map[string]describedENI {
+	concurrency := c.reconcileDescribeConcurrency
+	if concurrency < 1 {
+		concurrency = defaultReconcileDescribeConcurrency
+	}
+	results := make(map[string]describedENI, len(enis))
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, eni := range enis {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(eni string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			addresses, _, err := c.getENIaddresses(eni)
+			lock.Lock()
+			results[eni] = describedENI{addresses: addresses, err: err}
+			lock.Unlock()
+		}(eni)
+	}
+	wg.Wait()
+	return results
+}
+
+func getReconcileDescribeConcurrency() int {
+	inputStr, found := os.LookupEnv(envReconcileDescribeConcurrency)
+
+	if !found {
+		return defaultReconcileDescribeConcurrency
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 1 && input <= maxReconcileDescribeConcurrency {
+		log.Debugf("Using %s %v", envReconcileDescribeConcurrency, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envReconcileDescribeConcurrency, inputStr, defaultReconcileDescribeConcurrency)
+	return defaultReconcileDescribeConcurrency
+}
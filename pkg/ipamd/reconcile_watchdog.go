@@ -0,0 +1,181 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to specify, in seconds, how long an iteration of the pool manager loop, that
+	// checks the pool and reconciles it with EC2, may run. When an iteration runs longer, for example because an EC2
+	// call hangs, the watchdog cancels the EC2 calls of the step in progress, and the loop skips the rest of the
+	// iteration once the step returns. When it is not set or set to 0, there is no watchdog.
+	envReconcileMaxDuration = "RECONCILE_MAX_DURATION"
+	noReconcileMaxDuration  = 0
+	// minReconcileMaxDuration keeps the watchdog from cancelling healthy iterations: allocating or freeing an ENI
+	// retries its EC2 calls with a backoff of up to a minute, and a full subnet is retried after a minute
+	minReconcileMaxDuration = 5 * time.Minute
+)
+
+// ReconcileWatchdogStats describes the trips of the watchdog of the pool manager loop, for introspection
+type ReconcileWatchdogStats struct {
+	MaxDuration time.Duration
+	// Running is how long the current iteration has been running, in Step
+	Running time.Duration `json:",omitempty"`
+	Step    string        `json:",omitempty"`
+	// Trips is the number of iterations cancelled, the last one at LastTrip after LastTripDuration in LastTripStep
+	Trips            int64
+	LastTrip         time.Time     `json:",omitempty"`
+	LastTripStep     string        `json:",omitempty"`
+	LastTripDuration time.Duration `json:",omitempty"`
+}
+
+// reconcileWatchdogState keeps the iteration of the pool manager loop in progress and its context. There is a single
+// pool manager loop: a tripped iteration is cancelled, and the loop starts the next one once its step returns.
+type reconcileWatchdogState struct {
+	started time.Time
+	step    string
+	ctx     context.Context
+	// cancel is nil once the iteration was cancelled
+	cancel context.CancelFunc
+
+	trips            int64
+	lastTrip         time.Time
+	lastTripStep     string
+	lastTripDuration time.Duration
+	lock             sync.Mutex
+}
+
+// begin starts an iteration of the loop, and returns its context
+func (s *reconcileWatchdogState) begin() context.Context {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.started = time.Now()
+	s.step = ""
+	return s.ctx
+}
+
+// enterStep records the step the iteration is in
+func (s *reconcileWatchdogState) enterStep(step string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.step = step
+}
+
+// end ends the iteration
+func (s *reconcileWatchdogState) end() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	s.ctx = nil
+	s.started = time.Time{}
+	s.step = ""
+}
+
+// context returns the context of the iteration in progress, or the background context outside of the loop
+func (s *reconcileWatchdogState) context() context.Context {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// trip cancels the iteration in progress if it has run longer than maxDuration, and returns false if the iteration is
+// not wedged or was already cancelled
+func (s *reconcileWatchdogState) trip(maxDuration time.Duration) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started.IsZero() || s.cancel == nil {
+		return false
+	}
+	running := time.Since(s.started)
+	if running <= maxDuration {
+		return false
+	}
+	log.Errorf("The pool manager iteration has been running for %v in step %q, more than %s %v, cancelling it",
+		running, s.step, envReconcileMaxDuration, maxDuration)
+	ipamdErrInc("reconcileWatchdogTrip")
+	s.cancel()
+	s.cancel = nil
+	s.trips++
+	s.lastTrip = time.Now()
+	s.lastTripStep = s.step
+	s.lastTripDuration = running
+	return true
+}
+
+// runReconcileWatchdog checks the pool manager loop every quarter of RECONCILE_MAX_DURATION, and cancels an iteration
+// that runs longer. The EC2 calls in flight are cancelled with it, the instance metadata calls are not.
+func (c *IPAMContext) runReconcileWatchdog() {
+	for {
+		time.Sleep(c.reconcileMaxDuration / 4)
+		c.reconcileWatchdog.trip(c.reconcileMaxDuration)
+	}
+}
+
+// poolManagerContext returns the context of the EC2 calls of the pool manager, cancelled when the watchdog trips
+func (c *IPAMContext) poolManagerContext() context.Context {
+	return c.reconcileWatchdog.context()
+}
+
+// GetReconcileWatchdogStats returns the trips of the watchdog of the pool manager loop
+func (c *IPAMContext) GetReconcileWatchdogStats() ReconcileWatchdogStats {
+	c.reconcileWatchdog.lock.Lock()
+	defer c.reconcileWatchdog.lock.Unlock()
+	stats := ReconcileWatchdogStats{
+		MaxDuration:      c.reconcileMaxDuration,
+		Step:             c.reconcileWatchdog.step,
+		Trips:            c.reconcileWatchdog.trips,
+		LastTrip:         c.reconcileWatchdog.lastTrip,
+		LastTripStep:     c.reconcileWatchdog.lastTripStep,
+		LastTripDuration: c.reconcileWatchdog.lastTripDuration,
+	}
+	if !c.reconcileWatchdog.started.IsZero() {
+		stats.Running = time.Since(c.reconcileWatchdog.started)
+	}
+	return stats
+}
+
+func getReconcileMaxDuration() time.Duration {
+	inputStr, found := os.LookupEnv(envReconcileMaxDuration)
+
+	if !found {
+		return noReconcileMaxDuration
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		maxDuration := time.Duration(input) * time.Second
+		if maxDuration != noReconcileMaxDuration && maxDuration < minReconcileMaxDuration {
+			log.Warnf("%s %v is too short, using %v", envReconcileMaxDuration, maxDuration, minReconcileMaxDuration)
+			return minReconcileMaxDuration
+		}
+		log.Debugf("Using %s %v", envReconcileMaxDuration, maxDuration)
+		return maxDuration
+	}
+	log.Errorf("Invalid %s value %q, disabling the watchdog", envReconcileMaxDuration, inputStr)
+	return noReconcileMaxDuration
+}
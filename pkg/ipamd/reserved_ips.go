@@ -0,0 +1,102 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to specify a number of free IPs that only critical pods may take, so that
+	// they still get an IP when regular pods have exhausted the pool. The pool is grown to keep them on top of the
+	// warm targets. When it is not set or set to 0, no IP is reserved.
+	envReservedIPs = "RESERVED_IPS"
+	noReservedIPs  = 0
+
+	// This environment variable is used to specify the minimum priority of the critical pods. When it is not set, it
+	// defaults to the priority of the system-cluster-critical priority class.
+	envReservedIPsMinPriority     = "RESERVED_IPS_MIN_PRIORITY"
+	defaultReservedIPsMinPriority = 2000000000
+
+	// This environment variable is used to specify a pod label, as "key" or "key=value", that also makes a pod
+	// critical whatever its priority
+	envReservedIPsPodLabel = "RESERVED_IPS_POD_LABEL"
+)
+
+// podMayUseReservedIPs returns true if the pod is critical: its priority is at least RESERVED_IPS_MIN_PRIORITY or it
+// has the RESERVED_IPS_POD_LABEL label. Pods that the API server has not reported yet are not critical.
+func (c *IPAMContext) podMayUseReservedIPs(pod *k8sapi.K8SPodInfo) bool {
+	podInfo := c.k8sClient.K8SGetPodInfo(pod.Name, pod.Namespace)
+	if podInfo == nil {
+		return false
+	}
+	if podInfo.Priority >= c.reservedIPsMinPriority {
+		return true
+	}
+	if c.reservedIPsPodLabel == "" {
+		return false
+	}
+	key, value, hasValue := parsePodLabel(c.reservedIPsPodLabel)
+	podValue, ok := podInfo.Labels[key]
+	return ok && (!hasValue || podValue == value)
+}
+
+// parsePodLabel splits a "key" or "key=value" label selector
+func parsePodLabel(label string) (key string, value string, hasValue bool) {
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) == 1 {
+		return parts[0], "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func getReservedIPs() int {
+	inputStr, found := os.LookupEnv(envReservedIPs)
+
+	if !found {
+		return noReservedIPs
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envReservedIPs, input)
+		return input
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envReservedIPs, inputStr, noReservedIPs)
+	return noReservedIPs
+}
+
+func getReservedIPsMinPriority() int32 {
+	inputStr, found := os.LookupEnv(envReservedIPsMinPriority)
+
+	if !found {
+		return defaultReservedIPsMinPriority
+	}
+
+	if input, err := strconv.ParseInt(inputStr, 10, 32); err == nil {
+		log.Debugf("Using %s %v", envReservedIPsMinPriority, input)
+		return int32(input)
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envReservedIPsMinPriority, inputStr, defaultReservedIPsMinPriority)
+	return defaultReservedIPsMinPriority
+}
+
+func getReservedIPsPodLabel() string {
+	return os.Getenv(envReservedIPsPodLabel)
+}
@@ -14,19 +14,22 @@
 package ipamd
 
 import (
-	"net"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
@@ -43,15 +46,132 @@ type server struct {
 	ipamContext *IPAMContext
 }
 
+// podDNS converts the DNS configuration sent by the CNI plugin for the datastore
+func podDNS(dns *rpc.PodDNS) *datastore.PodDNS {
+	return &datastore.PodDNS{
+		Nameservers: dns.Nameservers,
+		Domain:      dns.Domain,
+		Search:      dns.Search,
+		Options:     dns.Options,
+	}
+}
+
+// podSysctls converts the sysctls sent by the CNI plugin for the datastore
+func podSysctls(sysctls []*rpc.PodSysctl) map[string]string {
+	values := make(map[string]string, len(sysctls))
+	for _, sysctl := range sysctls {
+		values[sysctl.Name] = sysctl.Value
+	}
+	return values
+}
+
 // AddNetwork processes CNI add network request and return an IP address for container
 func (s *server) AddNetwork(ctx context.Context, in *rpc.AddNetworkRequest) (*rpc.AddNetworkReply, error) {
+	start := time.Now()
 	log.Infof("Received AddNetwork for NS %s, Pod %s, NameSpace %s, Sandbox %s, ifname %s",
 		in.Netns, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID, in.IfName)
 
-	addr, deviceNumber, err := s.ipamContext.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{
-		Name:      in.K8S_POD_NAME,
-		Namespace: in.K8S_POD_NAMESPACE,
-		Sandbox:   in.K8S_POD_INFRA_CONTAINER_ID})
+	if in.HostNetwork {
+		s.ipamContext.hostNetworkAdd(in)
+		s.ipamContext.activity.recordAdd(nil)
+		s.ipamContext.cniStats.recordAdd(start, nil)
+		return &rpc.AddNetworkReply{Success: true}, nil
+	}
+
+	pod := &k8sapi.K8SPodInfo{
+		Name:           in.K8S_POD_NAME,
+		Namespace:      in.K8S_POD_NAMESPACE,
+		Sandbox:        in.K8S_POD_INFRA_CONTAINER_ID,
+		UID:            in.K8S_POD_UID,
+		SubnetHint:     in.SubnetHint,
+		UseReservedIPs: in.UseReservedIPs}
+	if pod.SubnetHint == "" {
+		pod.SubnetHint = s.ipamContext.k8sClient.K8SGetPodSubnetHint(pod.Name, pod.Namespace)
+	}
+	if s.ipamContext.reservedIPs != noReservedIPs && !pod.UseReservedIPs {
+		pod.UseReservedIPs = s.ipamContext.podMayUseReservedIPs(pod)
+	}
+	traces := &s.ipamContext.podTraces
+	traces.begin(pod)
+	if pod.SubnetHint != "" {
+		traces.step(pod, "pod asks for an IP in subnet %s", pod.SubnetHint)
+	}
+	if pod.UseReservedIPs {
+		traces.step(pod, "pod may take the reserved IPs")
+	}
+	if family := s.ipamContext.podIPFamily(pod, in.IPFamily); family != "" {
+		traces.step(pod, "pod asks for the %s IP family", family)
+		if err := s.ipamContext.checkIPFamily(pod, family); err != nil {
+			// The reply has no room for the reason, the plugin passes the error of the call on to the runtime
+			traces.finish(pod, "", "", 0, err)
+			addIPCnt.Inc()
+			s.ipamContext.activity.recordAdd(err)
+			s.ipamContext.cniStats.recordAdd(start, err)
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+	}
+	addr, deviceNumber, duplicate, err := s.ipamContext.duplicatePodIPv4Address(pod)
+	if !duplicate {
+		if err = s.ipamContext.checkSubnetHint(pod); err != nil {
+			traces.step(pod, "subnet %s is not in %s", pod.SubnetHint, envPodSubnetAllowlist)
+		} else if err = s.ipamContext.checkDrainAdd(pod); err != nil {
+			traces.step(pod, "the node is draining and %s is %q", envDrainAddPolicy, s.ipamContext.drainAddPolicy)
+		} else if err = s.ipamContext.checkNamespaceIPQuota(pod, 1+len(in.AdditionalIfNames)); err != nil {
+			traces.step(pod, "namespace %s holds its %s of IPs", pod.Namespace, envNamespaceIPQuota)
+		} else {
+			addr, deviceNumber, err = s.ipamContext.assignPodIPv4AddressWithRetry(ctx, pod)
+		}
+	}
+	var additionalInterfaces []*rpc.PodInterface
+	if err == nil && len(in.AdditionalIfNames) > 0 {
+		if duplicate {
+			additionalInterfaces, err = s.ipamContext.duplicatePodInterfaces(pod, in.AdditionalIfNames)
+		} else {
+			additionalInterfaces, err = s.ipamContext.assignPodInterfaces(pod, in.AdditionalIfNames)
+		}
+		if err != nil {
+			addr, deviceNumber = "", 0
+		}
+		for _, intf := range additionalInterfaces {
+			traces.step(pod, "interface %s got IP %s on device %d", intf.IfName, intf.IPv4Addr, intf.DeviceNumber)
+		}
+	}
+	if err == datastore.ErrNoAvailableIPs && s.ipamContext.subnetFull.isAllFull() {
+		err = errSubnetsFull
+		traces.step(pod, "no free IP, and the pool cannot grow because the subnets are full")
+	}
+	if err == nil && s.ipamContext.podEgressOnly(pod, in.EgressOnly) {
+		traces.step(pod, "pod is egress-only, dropping the connections opened to it")
+		if err = s.ipamContext.setupEgressOnly(pod, addr, additionalInterfaces); err != nil {
+			log.Errorf("Failed to set up egress-only Pod %s, Namespace %s: %v", pod.Name, pod.Namespace, err)
+			if !duplicate {
+				s.ipamContext.releaseEgressOnlyPod(pod)
+			}
+			addr, deviceNumber, additionalInterfaces = "", 0, nil
+		}
+	}
+	var eniID string
+	if addr != "" {
+		eniID = s.ipamContext.dataStore.GetIPv4AddressENI(addr)
+	}
+	traces.finish(pod, addr, eniID, deviceNumber, err)
+	if err == nil && in.DNS != nil {
+		if dnsErr := s.ipamContext.dataStore.SetPodDNS(pod, podDNS(in.DNS)); dnsErr != nil {
+			log.Warnf("Failed to record the DNS configuration of Pod %s, Namespace %s: %v", pod.Name, pod.Namespace, dnsErr)
+		}
+	}
+	if err == nil && len(in.Sysctls) > 0 {
+		if sysctlErr := s.ipamContext.dataStore.SetPodSysctls(pod, podSysctls(in.Sysctls)); sysctlErr != nil {
+			log.Warnf("Failed to record the sysctls of Pod %s, Namespace %s: %v", pod.Name, pod.Namespace, sysctlErr)
+		}
+	}
+	if err == nil && !duplicate {
+		s.ipamContext.allocationRate.record(time.Now(), 1+len(additionalInterfaces))
+		s.ipamContext.auditIP(ipAuditAssign, pod, addr, "", "")
+		for _, intf := range additionalInterfaces {
+			s.ipamContext.auditIP(ipAuditAssign, pod, intf.IPv4Addr, intf.IfName, "")
+		}
+	}
 
 	var pbVPCcidrs []string
 	for _, cidr := range s.ipamContext.awsClient.GetVPCIPv4CIDRs() {
@@ -68,24 +188,140 @@ func (s *server) AddNetwork(ctx context.Context, in *rpc.AddNetworkRequest) (*rp
 	}
 
 	resp := rpc.AddNetworkReply{
-		Success:         err == nil,
-		IPv4Addr:        addr,
-		IPv4Subnet:      "",
-		DeviceNumber:    int32(deviceNumber),
-		UseExternalSNAT: useExternalSNAT,
-		VPCcidrs:        pbVPCcidrs,
+		Success:              err == nil,
+		IPv4Addr:             addr,
+		IPv4Subnet:           "",
+		DeviceNumber:         int32(deviceNumber),
+		UseExternalSNAT:      useExternalSNAT,
+		VPCcidrs:             pbVPCcidrs,
+		AdditionalInterfaces: additionalInterfaces,
+	}
+	if err == nil {
+		resp.StaticRoutes = s.ipamContext.podStaticRoutes(deviceNumber)
 	}
 
-	log.Infof("Send AddNetworkReply: IPv4Addr %s, DeviceNumber: %d, err: %v", addr, deviceNumber, err)
+	log.Infof("Send AddNetworkReply: IPv4Addr %s, DeviceNumber: %d, AdditionalInterfaces: %v, StaticRoutes: %v, err: %v",
+		addr, deviceNumber, additionalInterfaces, resp.StaticRoutes, err)
 	addIPCnt.Inc()
+	s.ipamContext.activity.recordAdd(err)
+	s.ipamContext.cniStats.recordAdd(start, err)
 	return &resp, nil
 }
 
+// assignPodIPv4Address assigns an IP address to the pod. If the datastore has no free IP and DATASTORE_FULL_POLICY is
+// "wait", it triggers pool growth and retries until an IP is assigned or DATASTORE_FULL_WAIT_TIMEOUT expires. It does
+// not wait when the pool cannot grow because of NODE_IP_QUOTA.
+func (c *IPAMContext) assignPodIPv4Address(ctx context.Context, pod *k8sapi.K8SPodInfo) (string, int, error) {
+	addr, deviceNumber, err := c.assignPodIPv4AddressOnce(pod)
+	if err != datastore.ErrNoAvailableIPs || c.datastoreFullPolicy != datastoreFullWait {
+		return addr, deviceNumber, err
+	}
+	if c.nodeIPQuotaReached() {
+		c.podTraces.step(pod, "no free IP, and the pool cannot grow because %s is reached", envNodeIPQuota)
+		return addr, deviceNumber, err
+	}
+	if c.inShrinkMode() {
+		c.podTraces.step(pod, "no free IP, and the pool cannot grow because the node is marked for scale-down")
+		return addr, deviceNumber, err
+	}
+
+	atomic.AddInt64(&c.datastoreFullWaits, 1)
+	atomic.AddInt32(&c.datastoreFullWaiting, 1)
+	defer atomic.AddInt32(&c.datastoreFullWaiting, -1)
+	log.Infof("No free IP address for Pod %s, Namespace %s, waiting up to %v for the pool to grow",
+		pod.Name, pod.Namespace, c.datastoreFullWaitTimeout)
+	c.podTraces.step(pod, "no free IP, waiting up to %v for the pool to grow", c.datastoreFullWaitTimeout)
+
+	timeout := time.NewTimer(c.datastoreFullWaitTimeout)
+	defer timeout.Stop()
+	retry := time.NewTicker(datastoreFullRetryInterval)
+	defer retry.Stop()
+	for {
+		c.requestPoolGrowth(pod)
+		select {
+		case <-ctx.Done():
+			atomic.AddInt64(&c.datastoreFullWaitTimeouts, 1)
+			return "", 0, err
+		case <-timeout.C:
+			atomic.AddInt64(&c.datastoreFullWaitTimeouts, 1)
+			log.Warnf("Timed out waiting for a free IP address for Pod %s, Namespace %s", pod.Name, pod.Namespace)
+			return "", 0, err
+		case <-retry.C:
+		}
+		addr, deviceNumber, err = c.assignPodIPv4AddressOnce(pod)
+		if err != datastore.ErrNoAvailableIPs {
+			if err == nil {
+				c.podTraces.setSource(pod, podIPSourcePoolGrowth)
+			}
+			return addr, deviceNumber, err
+		}
+	}
+}
+
+// assignPodIPv4AddressOnce assigns an IP address to the pod, giving it back the IP it had before a reboot when
+// PRESERVE_POD_IPS is enabled or the IP it released recently when SAME_POD_IP_REUSE_WINDOW is set, and persists the
+// assignment.
+func (c *IPAMContext) assignPodIPv4AddressOnce(pod *k8sapi.K8SPodInfo) (string, int, error) {
+	if c.podIPState == nil {
+		return c.assignPodIPv4AddressReusing(pod)
+	}
+
+	var addr string
+	var deviceNumber int
+	var err error
+	if prior, ok := c.podIPState.takePending(pod.UID); ok {
+		var preferred bool
+		c.podTraces.step(pod, "pod had IP %s before the reboot", prior.IP)
+		addr, deviceNumber, preferred, err = c.dataStore.AssignPodIPv4AddressPreferred(pod, prior.IP)
+		if err == nil {
+			c.podIPState.recordRestore(preferred)
+			if preferred {
+				c.podTraces.setSource(pod, podIPSourceRestored)
+			} else {
+				c.podTraces.step(pod, "IP %s is not free, took another IP", prior.IP)
+			}
+		}
+	} else {
+		addr, deviceNumber, err = c.assignPodIPv4AddressReusing(pod)
+	}
+	if err == nil {
+		if err := c.podIPState.save(podIPState{UID: pod.UID, Name: pod.Name, Namespace: pod.Namespace, IP: addr}); err != nil {
+			log.Warnf("Failed to persist IP %s of Pod %s, Namespace %s: %v", addr, pod.Name, pod.Namespace, err)
+		}
+	}
+	return addr, deviceNumber, err
+}
+
+// assignPodInterfaces assigns an IP address to each additional interface of a pod that already has an IP for its
+// default interface. If any of them fails, all the IPs of the pod are released.
+func (c *IPAMContext) assignPodInterfaces(pod *k8sapi.K8SPodInfo, ifNames []string) ([]*rpc.PodInterface, error) {
+	var interfaces []*rpc.PodInterface
+	for _, ifName := range ifNames {
+		addr, deviceNumber, err := c.dataStore.AssignPodInterfaceIPv4Address(pod, ifName)
+		if err != nil {
+			log.Errorf("Failed to assign an IP address to interface %s of Pod %s, Namespace %s: %v",
+				ifName, pod.Name, pod.Namespace, err)
+			c.dataStore.UnassignPodInterfaces(pod)
+			if ip, _, unassignErr := c.dataStore.UnassignPodIPv4Address(pod); unassignErr == nil && c.podIPState != nil {
+				if err := c.podIPState.remove(ip); err != nil {
+					log.Warnf("Failed to remove persisted IP %s of Pod %s, Namespace %s: %v", ip, pod.Name, pod.Namespace, err)
+				}
+			}
+			return nil, err
+		}
+		interfaces = append(interfaces, &rpc.PodInterface{IfName: ifName, IPv4Addr: addr, DeviceNumber: int32(deviceNumber)})
+	}
+	return interfaces, nil
+}
+
 func (s *server) DelNetwork(ctx context.Context, in *rpc.DelNetworkRequest) (*rpc.DelNetworkReply, error) {
+	start := time.Now()
 	log.Infof("Received DelNetwork for IP %s, Pod %s, Namespace %s, Sandbox %s",
 		in.IPv4Addr, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID)
 	delIPCnt.With(prometheus.Labels{"reason": in.Reason}).Inc()
 
+	podUID := s.ipamContext.podUID(&k8sapi.K8SPodInfo{Name: in.K8S_POD_NAME, Namespace: in.K8S_POD_NAMESPACE,
+		Sandbox: in.K8S_POD_INFRA_CONTAINER_ID})
 	ip, deviceNumber, err := s.ipamContext.dataStore.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{
 		Name:      in.K8S_POD_NAME,
 		Namespace: in.K8S_POD_NAMESPACE,
@@ -97,15 +333,69 @@ func (s *server) DelNetwork(ctx context.Context, in *rpc.DelNetworkRequest) (*rp
 			Name:      in.K8S_POD_NAME,
 			Namespace: in.K8S_POD_NAMESPACE})
 	}
-	log.Infof("Send DelNetworkReply: IPv4Addr %s, DeviceNumber: %d, err: %v", ip, deviceNumber, err)
+	if err == nil && s.ipamContext.podIPState != nil {
+		if err := s.ipamContext.podIPState.remove(ip); err != nil {
+			log.Warnf("Failed to remove persisted IP %s of Pod %s, Namespace %s: %v", ip, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, err)
+		}
+	}
+	pod := &k8sapi.K8SPodInfo{
+		Name:      in.K8S_POD_NAME,
+		Namespace: in.K8S_POD_NAMESPACE,
+		Sandbox:   in.K8S_POD_INFRA_CONTAINER_ID,
+		UID:       podUID}
+	if err == nil {
+		s.ipamContext.auditIP(ipAuditRelease, pod, ip, "", in.Reason)
+	}
+	if err == nil {
+		s.ipamContext.teardownEgressOnly(ip)
+	}
+	if err == nil && s.ipamContext.conntrackCleanupOnDel {
+		s.ipamContext.cleanupConntrack(ip)
+	}
+	if err == nil && s.ipamContext.delVerifyEnabled {
+		s.ipamContext.holdForDelVerify(pod, ip)
+	}
+	var additionalInterfaces []*rpc.PodInterface
+	for _, intf := range s.ipamContext.dataStore.UnassignPodInterfaces(pod) {
+		s.ipamContext.auditIP(ipAuditRelease, pod, intf.IP, intf.IfName, in.Reason)
+		s.ipamContext.releaseAgedIP(intf.IP)
+		s.ipamContext.teardownEgressOnly(intf.IP)
+		if s.ipamContext.conntrackCleanupOnDel {
+			s.ipamContext.cleanupConntrack(intf.IP)
+		}
+		if s.ipamContext.delVerifyEnabled {
+			s.ipamContext.holdForDelVerify(pod, intf.IP)
+		}
+		additionalInterfaces = append(additionalInterfaces,
+			&rpc.PodInterface{IfName: intf.IfName, IPv4Addr: intf.IP, DeviceNumber: int32(intf.DeviceNumber)})
+	}
+	log.Infof("Send DelNetworkReply: IPv4Addr %s, DeviceNumber: %d, AdditionalInterfaces: %v, err: %v",
+		ip, deviceNumber, additionalInterfaces, err)
+	s.ipamContext.activity.recordDel(err)
+	if err == datastore.ErrUnknownPod {
+		// The CNI plugin treats an unknown pod as deleted
+		s.ipamContext.cniStats.recordDel(start, nil)
+	} else {
+		s.ipamContext.cniStats.recordDel(start, err)
+	}
+	if err == nil {
+		s.ipamContext.releaseRate.record(time.Now(), 1+len(additionalInterfaces))
+		s.ipamContext.delFailures.clear(pod)
+		if !s.ipamContext.releaseAgedIP(ip) {
+			s.ipamContext.recordSamePodIPFreed(podUID, ip)
+		}
+	} else if err != datastore.ErrUnknownPod {
+		// The CNI plugin treats an unknown pod as deleted, kubelet sends a DEL again for a pod already deleted
+		s.ipamContext.delFailures.record(pod, in.IPv4Addr, in.Reason, err)
+	}
 
-	return &rpc.DelNetworkReply{Success: err == nil, IPv4Addr: ip, DeviceNumber: int32(deviceNumber)}, err
+	return &rpc.DelNetworkReply{Success: err == nil, IPv4Addr: ip, DeviceNumber: int32(deviceNumber),
+		AdditionalInterfaces: additionalInterfaces}, err
 }
 
 // RunRPCHandler handles request from gRPC
 func (c *IPAMContext) RunRPCHandler() error {
-	log.Info("Serving RPC Handler on ", ipamdgRPCaddress)
-	listener, err := net.Listen("tcp", ipamdgRPCaddress)
+	listener, healthListener, err := rpcListener()
 	if err != nil {
 		log.Errorf("Failed to listen gRPC port: %v", err)
 		return errors.Wrap(err, "ipamd: failed to listen to gRPC port")
@@ -117,11 +407,22 @@ func (c *IPAMContext) RunRPCHandler() error {
 	// No need to ever change this to HealthCheckResponse_NOT_SERVING since it's a local service only
 	healthServer.SetServingStatus(grpcHealthServiceName, healthpb.HealthCheckResponse_SERVING)
 	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	if healthListener != nil {
+		// The CNI API is on the Unix socket, only the health service is served on TCP for the probes
+		healthGRPCServer := grpc.NewServer()
+		healthpb.RegisterHealthServer(healthGRPCServer, healthServer)
+		go func() {
+			if err := healthGRPCServer.Serve(healthListener); err != nil {
+				log.Errorf("Failed to serve the gRPC health service: %v", err)
+			}
+		}()
+	}
 
 	// Register reflection service on gRPC server.
 	reflection.Register(grpcServer)
 	// Add shutdown hook
 	go c.shutdownListener()
+	c.startupProfile.markReady()
 	if err := grpcServer.Serve(listener); err != nil {
 		log.Errorf("Failed to start server on gRPC port: %v", err)
 		return errors.Wrap(err, "ipamd: failed to start server on gPRC port")
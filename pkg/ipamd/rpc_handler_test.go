@@ -14,11 +14,21 @@
 package ipamd
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
 
 	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
 
@@ -72,6 +82,8 @@ func TestServer_AddNetwork(t *testing.T) {
 		},
 	}
 	for _, tc := range testCases {
+		mockK8S.EXPECT().K8SGetPodSubnetHint("pod", "ns").Return("")
+		mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
 		mockAWS.EXPECT().GetVPCIPv4CIDRs().Return(tc.vpcCIDRs)
 		mockNetwork.EXPECT().UseExternalSNAT().Return(tc.useExternalSNAT)
 		if !tc.useExternalSNAT {
@@ -91,3 +103,956 @@ func TestServer_AddNetwork(t *testing.T) {
 		assert.Equal(t, expectedCIDRs, addNetworkReply.VPCcidrs, tc.name)
 	}
 }
+
+func TestAssignPodIPv4AddressWaitPolicy(t *testing.T) {
+	ctrl, mockAWS, mockK8S, mockCRI, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:                mockAWS,
+		k8sClient:                mockK8S,
+		criClient:                mockCRI,
+		networkClient:            mockNetwork,
+		dataStore:                datastore.NewDataStore(),
+		datastoreFullPolicy:      datastoreFullWait,
+		datastoreFullWaitTimeout: 5 * time.Second,
+		poolGrowthTrigger:        make(chan struct{}, 1),
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+
+	// The pool grows while the request is waiting
+	go func() {
+		<-mockContext.poolGrowthTrigger
+		_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	}()
+
+	pod := &k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", Sandbox: "cid"}
+	addr, _, err := mockContext.assignPodIPv4Address(context.TODO(), pod)
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr01, addr)
+	assert.Equal(t, int64(1), mockContext.datastoreFullWaits)
+	assert.Equal(t, int64(0), mockContext.datastoreFullWaitTimeouts)
+
+	// No more free IPs, the request gives up once the timeout expires
+	mockContext.datastoreFullWaitTimeout = datastoreFullRetryInterval
+	pod2 := &k8sapi.K8SPodInfo{Name: "pod2", Namespace: "ns", Sandbox: "cid2"}
+	_, _, err = mockContext.assignPodIPv4Address(context.TODO(), pod2)
+	assert.Equal(t, datastore.ErrNoAvailableIPs, err)
+	assert.Equal(t, int64(1), mockContext.datastoreFullWaitTimeouts)
+
+	// With fail-fast, no wait is counted
+	mockContext.datastoreFullPolicy = datastoreFullFailFast
+	_, _, err = mockContext.assignPodIPv4Address(context.TODO(), pod2)
+	assert.Equal(t, datastore.ErrNoAvailableIPs, err)
+	assert.Equal(t, int64(2), mockContext.datastoreFullWaits)
+}
+
+func TestSubnetHint(t *testing.T) {
+	ctrl, mockAWS, mockK8S, mockCRI, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:         mockAWS,
+		k8sClient:         mockK8S,
+		criClient:         mockCRI,
+		networkClient:     mockNetwork,
+		dataStore:         datastore.NewDataStore(),
+		maxIPsPerENI:      3,
+		maxENI:            2,
+		poolGrowthTrigger: make(chan struct{}, 1),
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.SetENISubnet(primaryENIid, "subnet-a")
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = mockContext.dataStore.AddENI(secENIid, secDevice, false)
+	_ = mockContext.dataStore.SetENISubnet(secENIid, "subnet-b")
+	_ = mockContext.dataStore.AddIPv4AddressToStore(secENIid, ipaddr11)
+
+	pod := &k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", Sandbox: "cid", SubnetHint: "subnet-b"}
+	addr, _, err := mockContext.assignPodIPv4AddressOnce(pod)
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr11, addr)
+	podInfo := (*mockContext.dataStore.GetPodInfos())["pod_ns_cid"]
+	assert.Equal(t, "subnet-b", podInfo.RequestedSubnet)
+	assert.Equal(t, "subnet-b", podInfo.SubnetID)
+
+	// subnet-b is full, the pool manager is asked to grow it
+	pod2 := &k8sapi.K8SPodInfo{Name: "pod2", Namespace: "ns", Sandbox: "cid2", SubnetHint: "subnet-b"}
+	_, _, err = mockContext.assignPodIPv4AddressOnce(pod2)
+	assert.Equal(t, datastore.ErrNoAvailableIPs, err)
+	assert.Equal(t, []string{"subnet-b"}, mockContext.subnetHints.active(subnetHintTTL))
+
+	primary := false
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), secENIid, 2).Return(nil, nil)
+	mockAWS.EXPECT().DescribeENI(gomock.Any(), secENIid).Return([]*ec2.NetworkInterfacePrivateIpAddress{
+		{PrivateIpAddress: aws.String("10.10.20.10"), Primary: aws.Bool(true)},
+		{PrivateIpAddress: aws.String(ipaddr11), Primary: &primary},
+		{PrivateIpAddress: aws.String(ipaddr12), Primary: &primary},
+	}, nil, nil, nil)
+	mockContext.increaseSubnetHintPools()
+	addr, _, err = mockContext.assignPodIPv4AddressOnce(pod2)
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr12, addr)
+
+	// The ENI is full and no other ENI can be attached, the request fails with the reason
+	pod3 := &k8sapi.K8SPodInfo{Name: "pod3", Namespace: "ns", Sandbox: "cid3", SubnetHint: "subnet-b"}
+	_, _, err = mockContext.assignPodIPv4AddressOnce(pod3)
+	assert.Equal(t, datastore.ErrNoAvailableIPs, err)
+	mockAWS.EXPECT().AllocIPAddresses(gomock.Any(), secENIid, 1).Return(nil, errors.New("subnet is full"))
+	mockContext.increaseSubnetHintPools()
+	_, _, err = mockContext.assignPodIPv4AddressOnce(pod3)
+	assert.Error(t, err)
+	assert.NotEqual(t, datastore.ErrNoAvailableIPs, err)
+	assert.Contains(t, err.Error(), "subnet is full")
+}
+
+func TestCheckSubnetHint(t *testing.T) {
+	mockContext := &IPAMContext{podSubnetAllowlist: []string{"subnet-b"}}
+
+	assert.NoError(t, mockContext.checkSubnetHint(&k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns"}))
+	assert.NoError(t, mockContext.checkSubnetHint(&k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", SubnetHint: "subnet-b"}))
+	err := mockContext.checkSubnetHint(&k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", SubnetHint: "subnet-c"})
+	assert.EqualError(t, err, "subnet subnet-c is not in POD_SUBNET_ALLOWLIST")
+
+	// Without an allowlist, no pod may ask for a subnet
+	mockContext.podSubnetAllowlist = nil
+	assert.Error(t, mockContext.checkSubnetHint(&k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", SubnetHint: "subnet-b"}))
+}
+
+func TestAssignPodInterfaces(t *testing.T) {
+	ctrl, mockAWS, mockK8S, mockCRI, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		criClient:     mockCRI,
+		networkClient: mockNetwork,
+		dataStore:     datastore.NewDataStore(),
+	}
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr02)
+
+	pod := &k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", Sandbox: "cid"}
+	_, _, err := mockContext.assignPodIPv4AddressOnce(pod)
+	assert.NoError(t, err)
+
+	// Not enough IPs for both interfaces, all the IPs of the pod are released
+	_, err = mockContext.assignPodInterfaces(pod, []string{"eth1", "eth2"})
+	assert.Error(t, err)
+	_, assigned := mockContext.dataStore.GetStats()
+	assert.Equal(t, 0, assigned)
+
+	// The released IPs are cooling down
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr03)
+	_ = mockContext.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr11)
+	_, _, err = mockContext.assignPodIPv4AddressOnce(pod)
+	assert.NoError(t, err)
+	interfaces, err := mockContext.assignPodInterfaces(pod, []string{"eth1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(interfaces))
+	assert.Equal(t, "eth1", interfaces[0].IfName)
+	assert.Equal(t, 2, len(*mockContext.dataStore.GetPodInfos()))
+	assert.Equal(t, "eth1", (*mockContext.dataStore.GetPodInfos())["pod_ns_cid_eth1"].IfName)
+
+	// DEL releases every interface
+	rpcServer := server{ipamContext: mockContext}
+	delReply, err := rpcServer.DelNetwork(context.TODO(), &pb.DelNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(delReply.AdditionalInterfaces))
+	assert.Equal(t, interfaces[0].IPv4Addr, delReply.AdditionalInterfaces[0].IPv4Addr)
+	_, assigned = mockContext.dataStore.GetStats()
+	assert.Equal(t, 0, assigned)
+}
+
+func TestDuplicateAddNetwork(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:          mockAWS,
+		k8sClient:          mockK8S,
+		networkClient:      mockNetwork,
+		dataStore:          datastoreWith3FreeIPs(),
+		duplicateAddPolicy: duplicateAddReuse,
+	}
+	rpcServer := server{ipamContext: mockContext}
+	addNetworkRequest := &pb.AddNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+		AdditionalIfNames:          []string{"eth1"},
+	}
+	mockK8S.EXPECT().K8SGetPodSubnetHint("pod", "ns").Return("").Times(4)
+	mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).Times(4)
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).Times(4)
+
+	first, err := rpcServer.AddNetwork(context.TODO(), addNetworkRequest)
+	assert.NoError(t, err)
+	assert.True(t, first.Success)
+
+	// A retried ADD gets the same IPs
+	retry, err := rpcServer.AddNetwork(context.TODO(), addNetworkRequest)
+	assert.NoError(t, err)
+	assert.True(t, retry.Success)
+	assert.Equal(t, first.IPv4Addr, retry.IPv4Addr)
+	assert.Equal(t, first.AdditionalInterfaces[0].IPv4Addr, retry.AdditionalInterfaces[0].IPv4Addr)
+	_, assigned := mockContext.dataStore.GetStats()
+	assert.Equal(t, 2, assigned)
+
+	// An ADD for an interface the pod does not have fails
+	addNetworkRequest.AdditionalIfNames = []string{"eth2"}
+	reply, _ := rpcServer.AddNetwork(context.TODO(), addNetworkRequest)
+	assert.False(t, reply.Success)
+
+	mockContext.duplicateAddPolicy = duplicateAddReject
+	addNetworkRequest.AdditionalIfNames = nil
+	reply, _ = rpcServer.AddNetwork(context.TODO(), addNetworkRequest)
+	assert.False(t, reply.Success)
+
+	stats := mockContext.GetDuplicateAddStats()
+	assert.Equal(t, duplicateAddReject, stats.Policy)
+	assert.Equal(t, int64(2), stats.Reused)
+	assert.Equal(t, int64(1), stats.Rejected)
+	assert.Equal(t, "ns/pod", stats.LastPod)
+	_, assigned = mockContext.dataStore.GetStats()
+	assert.Equal(t, 2, assigned)
+}
+
+func TestReservedIPs(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:              mockAWS,
+		k8sClient:              mockK8S,
+		networkClient:          mockNetwork,
+		dataStore:              datastoreWith3FreeIPs(),
+		reservedIPs:            2,
+		reservedIPsMinPriority: defaultReservedIPsMinPriority,
+		reservedIPsPodLabel:    "critical=true",
+	}
+	mockContext.dataStore.SetReservedIPs(mockContext.reservedIPs)
+	rpcServer := server{ipamContext: mockContext}
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).AnyTimes()
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+	mockK8S.EXPECT().K8SGetPodSubnetHint(gomock.Any(), gomock.Any()).Return("").AnyTimes()
+
+	add := func(name string) bool {
+		reply, _ := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+			K8S_POD_NAME:               name,
+			K8S_POD_NAMESPACE:          "ns",
+			K8S_POD_INFRA_CONTAINER_ID: name,
+		})
+		return reply.Success
+	}
+	mockK8S.EXPECT().K8SGetPodInfo("regular1", "ns").Return(&k8sapi.K8SPodInfo{}).Times(3)
+	assert.True(t, add("regular1"))
+	mockK8S.EXPECT().K8SGetPodInfo("regular2", "ns").Return(&k8sapi.K8SPodInfo{Labels: map[string]string{"critical": "false"}}).Times(2)
+	assert.False(t, add("regular2"))
+	mockK8S.EXPECT().K8SGetPodInfo("unknown", "ns").Return(nil).Times(2)
+	assert.False(t, add("unknown"))
+
+	mockK8S.EXPECT().K8SGetPodInfo("labelled", "ns").Return(&k8sapi.K8SPodInfo{Labels: map[string]string{"critical": "true"}}).Times(3)
+	assert.True(t, add("labelled"))
+	mockK8S.EXPECT().K8SGetPodInfo("daemonset", "ns").Return(&k8sapi.K8SPodInfo{Priority: 2000001000}).Times(3)
+	assert.True(t, add("daemonset"))
+
+	stats := mockContext.GetPoolStats().ReservedIPs
+	assert.Equal(t, 2, stats.CriticalPodIPs)
+	assert.Equal(t, 1, stats.GeneralPodIPs)
+	assert.Equal(t, int64(2), stats.Rejected)
+
+	// The pool is grown to keep the reserved IPs on top of the warm IPs
+	mockContext.warmIPTarget = 1
+	short, _, _ := mockContext.ipTargetState()
+	assert.Equal(t, 3, short)
+}
+
+func TestPodTrace(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		dataStore:     datastoreWith3FreeIPs(),
+	}
+	rpcServer := server{ipamContext: mockContext}
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).AnyTimes()
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+	mockK8S.EXPECT().K8SGetPodSubnetHint(gomock.Any(), gomock.Any()).Return("").AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	addNetworkRequest := &pb.AddNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+	}
+	reply, _ := rpcServer.AddNetwork(context.TODO(), addNetworkRequest)
+	assert.True(t, reply.Success)
+	_, _ = rpcServer.AddNetwork(context.TODO(), addNetworkRequest)
+	_, _ = rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{K8S_POD_NAME: "other", K8S_POD_NAMESPACE: "ns"})
+
+	traces := mockContext.GetPodTraces("pod", "ns")
+	assert.Equal(t, 2, len(traces))
+	assert.Equal(t, podIPSourceDuplicate, traces[0].Source)
+	assert.Equal(t, podIPSourceWarmPool, traces[1].Source)
+	assert.Equal(t, reply.IPv4Addr, traces[1].IP)
+	assert.Equal(t, primaryENIid, traces[1].ENIID)
+	assert.Empty(t, traces[1].Steps)
+	assert.Equal(t, 3, len(mockContext.GetPodTraces("", "")))
+
+	// Only the most recent traces are kept
+	for i := 0; i < maxPodTraces; i++ {
+		pod := &k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns"}
+		mockContext.podTraces.begin(pod)
+		mockContext.podTraces.finish(pod, "", "", 0, errors.New("no IP"))
+	}
+	traces = mockContext.GetPodTraces("", "")
+	assert.Equal(t, maxPodTraces, len(traces))
+	assert.Equal(t, "no IP", traces[0].Error)
+	assert.Empty(t, mockContext.GetPodTraces("other", "ns"))
+}
+
+func TestAddRetry(t *testing.T) {
+	ctrl, _, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	c := &IPAMContext{
+		dataStore:         datastore.NewDataStore(),
+		addRetryAttempts:  2,
+		addRetryBackoff:   time.Millisecond,
+		poolGrowthTrigger: make(chan struct{}, 1),
+	}
+	_ = c.dataStore.AddENI(primaryENIid, primaryDevice, true)
+	pod := &k8sapi.K8SPodInfo{Name: "pod1", Namespace: "default", Sandbox: "sandbox1"}
+
+	_, _, err := c.assignPodIPv4AddressWithRetry(context.Background(), pod)
+	assert.Equal(t, datastore.ErrNoAvailableIPs, err)
+	stats := c.GetAddRetryStats()
+	assert.Equal(t, int64(1), stats.Retried)
+	assert.Equal(t, int64(2), stats.Retries)
+	assert.Equal(t, int64(1), stats.FailedAfterRetry)
+	assert.Equal(t, datastore.ErrNoAvailableIPs.Error(), stats.LastError)
+
+	// The pool grows once the request asked for it
+	c.addRetryAttempts = 5
+	go func() {
+		<-c.poolGrowthTrigger
+		_ = c.dataStore.AddIPv4AddressToStore(primaryENIid, ipaddr01)
+	}()
+	addr, _, err := c.assignPodIPv4AddressWithRetry(context.Background(), pod)
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr01, addr)
+	stats = c.GetAddRetryStats()
+	assert.Equal(t, int64(2), stats.Retried)
+	assert.Equal(t, int64(1), stats.SucceededAfterRetry)
+	assert.Equal(t, 5, stats.Attempts)
+}
+
+func TestDelNetworkVerifyCleanup(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:        mockAWS,
+		k8sClient:        mockK8S,
+		networkClient:    mockNetwork,
+		dataStore:        datastoreWith3FreeIPs(),
+		delVerifyEnabled: true,
+	}
+	rpcServer := server{ipamContext: mockContext}
+	pod := &k8sapi.K8SPodInfo{Name: "pod", Namespace: "ns", Sandbox: "cid"}
+	ip, _, err := mockContext.dataStore.AssignPodIPv4Address(pod)
+	assert.NoError(t, err)
+
+	// The CNI plugin has not removed the host route yet
+	mockNetwork.EXPECT().HasPodHostRoute(ip).Return(true, nil).Times(delVerifyAttempts)
+	reply, err := rpcServer.DelNetwork(context.TODO(), &pb.DelNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ip, reply.IPv4Addr)
+	for deadline := time.Now().Add(5 * time.Second); mockContext.GetDelVerifyStats().Verifying > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the verification did not end")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := mockContext.GetDelVerifyStats()
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, 1, len(stats.Held))
+	assert.Equal(t, ip, stats.Held[0].IP)
+	assert.Equal(t, delVerifyAttempts, stats.Held[0].Checks)
+	assert.True(t, mockContext.dataStore.GetENIInfos().ENIIPPools[primaryENIid].IPv4Addresses[ip].CleanupPending)
+
+	// The pool manager releases the IP once the route is removed
+	mockNetwork.EXPECT().HasPodHostRoute(ip).Return(false, nil)
+	mockContext.recheckDelVerifyFailures()
+	assert.Equal(t, 0, len(mockContext.GetDelVerifyStats().Held))
+	assert.False(t, mockContext.dataStore.GetENIInfos().ENIIPPools[primaryENIid].IPv4Addresses[ip].CleanupPending)
+}
+
+func TestDelNetworkConntrackCleanup(t *testing.T) {
+	ctrl, _, _, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		networkClient:         mockNetwork,
+		dataStore:             datastoreWith3FreeIPs(),
+		conntrackCleanupOnDel: true,
+	}
+	rpcServer := server{ipamContext: mockContext}
+	del := func(name string) {
+		pod := &k8sapi.K8SPodInfo{Name: name, Namespace: "ns", Sandbox: name + "-sandbox"}
+		ip, _, err := mockContext.dataStore.AssignPodIPv4Address(pod)
+		assert.NoError(t, err)
+		if name == "pod1" {
+			mockNetwork.EXPECT().DeleteConntrackEntries(ip).Return(uint(4), nil)
+		} else {
+			mockNetwork.EXPECT().DeleteConntrackEntries(ip).Return(uint(0), errors.New("operation not permitted"))
+		}
+		reply, err := rpcServer.DelNetwork(context.TODO(), &pb.DelNetworkRequest{
+			K8S_POD_NAME:               pod.Name,
+			K8S_POD_NAMESPACE:          pod.Namespace,
+			K8S_POD_INFRA_CONTAINER_ID: pod.Sandbox,
+		})
+		// A failed cleanup does not fail the request
+		assert.NoError(t, err)
+		assert.True(t, reply.Success)
+	}
+	del("pod1")
+	del("pod2")
+
+	stats := mockContext.GetConntrackCleanupStats()
+	assert.True(t, stats.Enabled)
+	assert.Equal(t, int64(1), stats.Cleanups)
+	assert.Equal(t, int64(4), stats.Deleted)
+	assert.Equal(t, int64(1), stats.Failures)
+	assert.Equal(t, "operation not permitted", stats.LastError)
+}
+
+func TestAddNetworkHostNetwork(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		dataStore:     datastoreWith3FreeIPs(),
+	}
+	rpcServer := server{ipamContext: mockContext}
+
+	reply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+		HostNetwork:                true,
+	})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	assert.Equal(t, "", reply.IPv4Addr)
+
+	// No IP is assigned to the pod
+	_, assigned := mockContext.dataStore.GetStats()
+	assert.Equal(t, 0, assigned)
+	stats := mockContext.GetHostNetworkStats()
+	assert.Equal(t, int64(1), stats.Adds)
+	assert.Equal(t, "pod", stats.LastPod)
+	assert.Equal(t, "ns", stats.LastNamespace)
+}
+
+func TestNamespaceIPQuota(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	os.Setenv(envNamespaceIPQuota, "team-a=1, *=2,bad,team-b=-1")
+	defer os.Unsetenv(envNamespaceIPQuota)
+	mockContext := &IPAMContext{
+		awsClient:         mockAWS,
+		k8sClient:         mockK8S,
+		networkClient:     mockNetwork,
+		dataStore:         datastoreWith3FreeIPs(),
+		namespaceIPQuotas: getNamespaceIPQuotas(),
+	}
+	assert.Equal(t, map[string]int{"team-a": 1, "*": 2}, mockContext.namespaceIPQuotas)
+	rpcServer := server{ipamContext: mockContext}
+
+	mockK8S.EXPECT().K8SGetPodSubnetHint(gomock.Any(), gomock.Any()).Return("").AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).AnyTimes()
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	add := func(name, namespace string) *pb.AddNetworkReply {
+		reply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+			K8S_POD_NAME:               name,
+			K8S_POD_NAMESPACE:          namespace,
+			K8S_POD_INFRA_CONTAINER_ID: name + "-sandbox",
+		})
+		assert.NoError(t, err)
+		return reply
+	}
+	assert.True(t, add("pod1", "team-a").Success)
+	assert.False(t, add("pod2", "team-a").Success)
+	assert.True(t, add("pod3", "team-c").Success)
+
+	// A retried ADD of a pod that holds an IP is not rejected
+	assert.True(t, add("pod1", "team-a").Success)
+
+	usage := mockContext.GetNamespaceUsage()
+	assert.Equal(t, []NamespaceUsage{
+		{Namespace: "team-a", IPs: 1, Limited: true, Quota: 1, Rejected: 1},
+		{Namespace: "team-c", IPs: 1, Limited: true, Quota: 2},
+	}, usage)
+}
+
+func TestIPAudit(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	var out bytes.Buffer
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		dataStore:     datastoreWith3FreeIPs(),
+		ipAudit:       &ipAuditLog{out: &out},
+	}
+	rpcServer := server{ipamContext: mockContext}
+
+	mockK8S.EXPECT().K8SGetPodSubnetHint("pod", "ns").Return("")
+	mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return(nil)
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true)
+	addReply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+		K8S_POD_UID:                "uid",
+	})
+	assert.NoError(t, err)
+	delReply, err := rpcServer.DelNetwork(context.TODO(), &pb.DelNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+		Reason:                     "PodDeleted",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, addReply.IPv4Addr, delReply.IPv4Addr)
+
+	// One JSON entry per line, for the assignment and the release
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	var assign, release IPAuditEntry
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &assign))
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &release))
+	assert.Equal(t, ipAuditAssign, assign.Action)
+	assert.Equal(t, "uid", assign.PodUID)
+	assert.Equal(t, "ns", assign.Namespace)
+	assert.Equal(t, addReply.IPv4Addr, assign.IP)
+	assert.Equal(t, primaryENIid, assign.ENI)
+	assert.False(t, assign.Time.IsZero())
+	assert.Equal(t, ipAuditRelease, release.Action)
+	assert.Equal(t, "uid", release.PodUID)
+	assert.Equal(t, addReply.IPv4Addr, release.IP)
+	assert.Equal(t, primaryENIid, release.ENI)
+	assert.Equal(t, "PodDeleted", release.Reason)
+}
+
+func TestDrainAddPolicy(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	os.Setenv(envDrainAddPolicy, drainAddReject)
+	defer os.Unsetenv(envDrainAddPolicy)
+	mockContext := &IPAMContext{
+		awsClient:       mockAWS,
+		k8sClient:       mockK8S,
+		networkClient:   mockNetwork,
+		dataStore:       datastoreWith3FreeIPs(),
+		scaleDownShrink: true,
+		drainAddPolicy:  getDrainAddPolicy(),
+	}
+	rpcServer := server{ipamContext: mockContext}
+
+	mockK8S.EXPECT().K8SGetPodSubnetHint(gomock.Any(), gomock.Any()).Return("").AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).AnyTimes()
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	add := func(name string) *pb.AddNetworkReply {
+		reply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+			K8S_POD_NAME:               name,
+			K8S_POD_NAMESPACE:          "default",
+			K8S_POD_INFRA_CONTAINER_ID: name + "-sandbox",
+		})
+		assert.NoError(t, err)
+		return reply
+	}
+	assert.True(t, add("pod1").Success)
+	assert.Equal(t, int64(0), mockContext.getDrainAddStats().Attempts)
+
+	// While the node is draining, new pods are rejected, but not a retried ADD of a pod that holds an IP
+	mockContext.shrinkMode.stats.Active = true
+	assert.False(t, add("pod2").Success)
+	assert.True(t, add("pod1").Success)
+	stats := mockContext.GetPoolStats().DrainAdds
+	assert.Equal(t, drainAddReject, stats.Policy)
+	assert.True(t, stats.Draining)
+	assert.Equal(t, int64(1), stats.Attempts)
+	assert.Equal(t, int64(1), stats.Rejected)
+	assert.Equal(t, "default/pod2", stats.LastPod)
+
+	// With the default policy, they are counted and take a free IP
+	mockContext.drainAddPolicy = drainAddAllow
+	assert.True(t, add("pod3").Success)
+	stats = mockContext.getDrainAddStats()
+	assert.Equal(t, int64(2), stats.Attempts)
+	assert.Equal(t, int64(1), stats.Rejected)
+}
+
+func TestReportNetlinkRetries(t *testing.T) {
+	mockContext := &IPAMContext{}
+	rpcServer := server{ipamContext: mockContext}
+
+	stats := mockContext.GetNetlinkRetryStats()
+	assert.Equal(t, int64(0), stats.Reports)
+	assert.Equal(t, 0, len(stats.ByOperation))
+
+	reply, err := rpcServer.ReportNetlinkRetries(context.TODO(), &pb.NetlinkRetriesRequest{
+		K8S_POD_NAME:      "pod1",
+		K8S_POD_NAMESPACE: "default",
+		Command:           "ADD",
+		Retries:           []*pb.NetlinkRetry{{Operation: "LinkAdd", Count: 1}, {Operation: "RuleAdd", Count: 2}},
+	})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	_, err = rpcServer.ReportNetlinkRetries(context.TODO(), &pb.NetlinkRetriesRequest{
+		K8S_POD_NAME:      "pod2",
+		K8S_POD_NAMESPACE: "default",
+		Command:           "DEL",
+		Retries:           []*pb.NetlinkRetry{{Operation: "RuleAdd", Count: 1}},
+	})
+	assert.NoError(t, err)
+
+	stats = mockContext.GetNetlinkRetryStats()
+	assert.Equal(t, int64(2), stats.Reports)
+	assert.Equal(t, map[string]int64{"LinkAdd": 1, "RuleAdd": 3}, stats.ByOperation)
+	assert.Equal(t, map[string]int64{"ADD": 3, "DEL": 1}, stats.ByCommand)
+	assert.Equal(t, "default/pod2", stats.LastPod)
+}
+
+func TestAddNetworkDNS(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		dataStore:     datastoreWith3FreeIPs(),
+	}
+	rpcServer := server{ipamContext: mockContext}
+
+	mockK8S.EXPECT().K8SGetPodSubnetHint(gomock.Any(), gomock.Any()).Return("").AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).AnyTimes()
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	dns := &pb.PodDNS{Nameservers: []string{"10.100.0.10"}, Search: []string{"ns.svc.cluster.local"}, Options: []string{"ndots:5"}}
+	reply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+		DNS:                        dns,
+	})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	reply, err = rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		K8S_POD_NAME:               "other",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid2",
+	})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+
+	podInfos := *mockContext.dataStore.GetPodInfos()
+	assert.Equal(t, &datastore.PodDNS{Nameservers: dns.Nameservers, Search: dns.Search, Options: dns.Options},
+		podInfos["pod_ns_cid"].DNS)
+	assert.Nil(t, podInfos["other_ns_cid2"].DNS)
+}
+
+func TestAddNetworkSysctls(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		dataStore:     datastoreWith3FreeIPs(),
+	}
+	rpcServer := server{ipamContext: mockContext}
+
+	mockK8S.EXPECT().K8SGetPodSubnetHint(gomock.Any(), gomock.Any()).Return("").AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).AnyTimes()
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	reply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		K8S_POD_NAME:               "pod",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "cid",
+		Sysctls: []*pb.PodSysctl{
+			{Name: "net.ipv4.conf.eth0.rp_filter", Value: "2"},
+			{Name: "net.ipv4.tcp_keepalive_time", Value: "600"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+
+	podInfos := *mockContext.dataStore.GetPodInfos()
+	assert.Equal(t, map[string]string{"net.ipv4.conf.eth0.rp_filter": "2", "net.ipv4.tcp_keepalive_time": "600"},
+		podInfos["pod_ns_cid"].Sysctls)
+}
+
+func TestDelFailures(t *testing.T) {
+	mockContext := &IPAMContext{dataStore: datastoreWith3FreeIPs()}
+	rpcServer := server{ipamContext: mockContext}
+	pod := &k8sapi.K8SPodInfo{Name: "pod1", Namespace: "ns", Sandbox: "sandbox1"}
+	other := &k8sapi.K8SPodInfo{Name: "pod2", Namespace: "ns", Sandbox: "sandbox2"}
+
+	// A pod that failed again is kept once, as the most recent failure
+	mockContext.delFailures.record(pod, ipaddr01, "PodDeleted", datastore.ErrUnknownPodIP)
+	mockContext.delFailures.record(other, "", "PodDeleted", datastore.ErrUnknownPodIP)
+	mockContext.delFailures.record(pod, ipaddr01, "PodDeleted", errors.New("still failing"))
+	stats := mockContext.GetDelFailureStats()
+	assert.Len(t, stats.Failures, 2)
+	assert.Equal(t, "pod2", stats.Failures[0].Name)
+	assert.Equal(t, "pod1", stats.Failures[1].Name)
+	assert.Equal(t, 2, stats.Failures[1].Failures)
+	assert.Equal(t, "still failing", stats.Failures[1].Error)
+	assert.Equal(t, ipaddr01, stats.Failures[1].IP)
+
+	// A DEL of an unknown pod is not a failure
+	_, err := rpcServer.DelNetwork(context.TODO(), &pb.DelNetworkRequest{
+		K8S_POD_NAME: "pod3", K8S_POD_NAMESPACE: "ns", K8S_POD_INFRA_CONTAINER_ID: "sandbox3"})
+	assert.Equal(t, datastore.ErrUnknownPod, err)
+	assert.Len(t, mockContext.GetDelFailureStats().Failures, 2)
+
+	// A successful retry clears the failure
+	_, _, err = mockContext.dataStore.AssignPodIPv4Address(pod)
+	assert.NoError(t, err)
+	reply, err := rpcServer.DelNetwork(context.TODO(), &pb.DelNetworkRequest{
+		K8S_POD_NAME: pod.Name, K8S_POD_NAMESPACE: pod.Namespace, K8S_POD_INFRA_CONTAINER_ID: pod.Sandbox})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	stats = mockContext.GetDelFailureStats()
+	assert.Len(t, stats.Failures, 1)
+	assert.Equal(t, "pod2", stats.Failures[0].Name)
+	assert.Equal(t, int64(1), stats.Cleared)
+
+	// Only the most recent failures are kept
+	for i := 0; i < maxDelFailures; i++ {
+		mockContext.delFailures.record(&k8sapi.K8SPodInfo{Name: fmt.Sprintf("pod-%d", i), Namespace: "ns"}, "", "",
+			datastore.ErrUnknownPodIP)
+	}
+	stats = mockContext.GetDelFailureStats()
+	assert.Len(t, stats.Failures, maxDelFailures)
+	assert.Equal(t, "pod-0", stats.Failures[0].Name)
+	assert.Equal(t, int64(1), stats.Dropped)
+}
+
+func TestSamePodIPReuse(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:            mockAWS,
+		k8sClient:            mockK8S,
+		networkClient:        mockNetwork,
+		dataStore:            datastoreWith3FreeIPs(),
+		samePodIPReuseWindow: time.Minute,
+	}
+	rpcServer := server{ipamContext: mockContext}
+
+	mockK8S.EXPECT().K8SGetPodSubnetHint(gomock.Any(), gomock.Any()).Return("").AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).AnyTimes()
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	add := func(name, sandbox, uid string) string {
+		reply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+			K8S_POD_NAME:               name,
+			K8S_POD_NAMESPACE:          "ns",
+			K8S_POD_INFRA_CONTAINER_ID: sandbox,
+			K8S_POD_UID:                uid,
+		})
+		assert.NoError(t, err)
+		assert.True(t, reply.Success)
+		return reply.IPv4Addr
+	}
+	del := func(name, sandbox string) {
+		reply, err := rpcServer.DelNetwork(context.TODO(), &pb.DelNetworkRequest{
+			K8S_POD_NAME:               name,
+			K8S_POD_NAMESPACE:          "ns",
+			K8S_POD_INFRA_CONTAINER_ID: sandbox,
+		})
+		assert.NoError(t, err)
+		assert.True(t, reply.Success)
+	}
+
+	// The new sandbox gets back the IP in its cooling period
+	ip := add("pod1", "sandbox1", "uid1")
+	del("pod1", "sandbox1")
+	assert.Equal(t, 1, mockContext.GetSamePodIPReuseStats().Tracked)
+	assert.Equal(t, ip, add("pod1", "sandbox2", "uid1"))
+	assert.Equal(t, podIPSourceReused, mockContext.GetPodTraces("pod1", "ns")[0].Source)
+
+	// Another pod took the IP
+	del("pod1", "sandbox2")
+	mockContext.samePodIPReuse.freed["uid2"] = mockContext.samePodIPReuse.freed["uid1"]
+	delete(mockContext.samePodIPReuse.freed, "uid1")
+	assert.NotEqual(t, ip, add("pod2", "sandbox3", "uid3"))
+	_, _, _, err := mockContext.dataStore.AssignPodIPv4AddressPreferred(&k8sapi.K8SPodInfo{Name: "pod3", Namespace: "ns"}, ip)
+	assert.NoError(t, err)
+	assert.NotEqual(t, ip, add("pod2", "sandbox4", "uid2"))
+
+	stats := mockContext.GetSamePodIPReuseStats()
+	assert.Equal(t, time.Minute, stats.Window)
+	assert.Equal(t, 0, stats.Tracked)
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, "ns/pod1", stats.LastPod)
+}
+
+func TestEgressOnly(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     mockAWS,
+		k8sClient:     mockK8S,
+		networkClient: mockNetwork,
+		dataStore:     datastoreWith3FreeIPs(),
+	}
+	rpcServer := server{ipamContext: mockContext}
+
+	mockK8S.EXPECT().K8SGetPodSubnetHint(gomock.Any(), gomock.Any()).Return("").AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo("regular", "ns").Return(nil).AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo("annotated", "ns").Return(&k8sapi.K8SPodInfo{EgressOnly: true}).AnyTimes()
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).AnyTimes()
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	add := func(name string, egressOnly bool) *pb.AddNetworkReply {
+		reply, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+			K8S_POD_NAME:               name,
+			K8S_POD_NAMESPACE:          "ns",
+			K8S_POD_INFRA_CONTAINER_ID: name + "-sandbox",
+			EgressOnly:                 egressOnly,
+		})
+		assert.NoError(t, err)
+		return reply
+	}
+
+	// No rule for a regular pod
+	assert.True(t, add("regular", false).Success)
+
+	// Requested by the CNI argument
+	mockNetwork.EXPECT().SetupPodEgressOnly(gomock.Any()).Return(nil)
+	requested := add("regular", true)
+	assert.True(t, requested.Success)
+
+	// Requested by the annotation, the IP is released when the rule cannot be installed
+	mockNetwork.EXPECT().SetupPodEgressOnly(gomock.Any()).Return(errors.New("iptables failed"))
+	assert.False(t, add("annotated", false).Success)
+	_, assigned := mockContext.dataStore.GetStats()
+	assert.Equal(t, 1, assigned)
+
+	stats := mockContext.GetEgressOnlyStats()
+	assert.Equal(t, 1, len(stats.Pods))
+	assert.Equal(t, requested.IPv4Addr, stats.Pods[0].IP)
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Contains(t, stats.LastError, "iptables failed")
+
+	mockNetwork.EXPECT().TeardownPodEgressOnly(requested.IPv4Addr).Return(nil)
+	reply, err := rpcServer.DelNetwork(context.TODO(), &pb.DelNetworkRequest{
+		K8S_POD_NAME:               "regular",
+		K8S_POD_NAMESPACE:          "ns",
+		K8S_POD_INFRA_CONTAINER_ID: "regular-sandbox",
+	})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	assert.Empty(t, mockContext.GetEgressOnlyStats().Pods)
+}
+
+func TestIPFamilyMismatchPolicy(t *testing.T) {
+	ctrl, mockAWS, mockK8S, _, mockNetwork, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:              mockAWS,
+		k8sClient:              mockK8S,
+		networkClient:          mockNetwork,
+		dataStore:              datastoreWith3FreeIPs(),
+		ipFamilyMismatchPolicy: getIPFamilyMismatchPolicy(),
+	}
+	rpcServer := server{ipamContext: mockContext}
+
+	mockK8S.EXPECT().K8SGetPodSubnetHint(gomock.Any(), gomock.Any()).Return("").AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo("pod2", "default").Return(&k8sapi.K8SPodInfo{IPFamily: "IPv6"}).AnyTimes()
+	mockK8S.EXPECT().K8SGetPodInfo(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWS.EXPECT().GetVPCIPv4CIDRs().Return([]*string{aws.String(vpcCIDR)}).AnyTimes()
+	mockNetwork.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	add := func(name string, family string) (*pb.AddNetworkReply, error) {
+		return rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+			K8S_POD_NAME:               name,
+			K8S_POD_NAMESPACE:          "default",
+			K8S_POD_INFRA_CONTAINER_ID: name + "-sandbox",
+			IPFamily:                   family,
+		})
+	}
+	reply, err := add("pod1", ipFamilyIPv4)
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	assert.Equal(t, int64(0), mockContext.GetIPFamilyMismatchStats().Mismatches)
+
+	// By default, the pods asking for a family not enabled fail with an error naming it, the annotation is used when
+	// the plugin passes no family
+	_, err = add("pod2", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "pod asks for the ipv6 IP family, but only ipv4 is enabled on this node")
+	_, err = add("pod1", "ipv5")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown IP family")
+	stats := mockContext.GetIPFamilyMismatchStats()
+	assert.Equal(t, ipFamilyMismatchReject, stats.Policy)
+	assert.Equal(t, []string{ipFamilyIPv4}, stats.Enabled)
+	assert.Equal(t, int64(2), stats.Mismatches)
+	assert.Equal(t, int64(2), stats.Rejected)
+	assert.Equal(t, "default/pod2", stats.Recent[0].Pod)
+	assert.Equal(t, ipFamilyIPv6, stats.Recent[0].Requested)
+
+	// With the "ipv4" policy, they get an IPv4 address
+	mockContext.ipFamilyMismatchPolicy = ipFamilyMismatchIPv4
+	reply, err = add("pod3", ipFamilyDual)
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	assert.NotEmpty(t, reply.IPv4Addr)
+	stats = mockContext.GetIPFamilyMismatchStats()
+	assert.Equal(t, int64(3), stats.Mismatches)
+	assert.Equal(t, int64(2), stats.Rejected)
+	assert.False(t, stats.Recent[2].Rejected)
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// This environment variable is used to specify a comma separated list of the UIDs allowed to call the ipamd gRPC
+	// API. When it or RPC_ALLOWED_GIDS is set, ipamd serves gRPC on the Unix socket ipamdgRPCSocket instead of TCP
+	// and checks the credentials of each peer. The CNI plugin is run by the kubelet, usually as root (UID 0).
+	envRPCAllowedUIDs = "RPC_ALLOWED_UIDS"
+
+	// This environment variable is used to specify a comma separated list of the GIDs allowed to call the ipamd gRPC
+	// API. A peer is accepted if its UID or its GID is allowed.
+	envRPCAllowedGIDs = "RPC_ALLOWED_GIDS"
+
+	// ipamdgRPCSocket is the Unix socket ipamd serves gRPC on when an allowlist is configured. The CNI plugin uses it
+	// when it exists, so its directory must be a host path mounted at the same path in the aws-node container.
+	ipamdgRPCSocket = "/var/run/aws-node/ipamd.sock"
+)
+
+// peerCredListener is a Unix socket listener that only accepts connections from allowed UIDs and GIDs
+type peerCredListener struct {
+	net.Listener
+	allowedUIDs []uint32
+	allowedGIDs []uint32
+}
+
+// Accept waits for a connection from an allowed peer, and closes the others
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		cred, err := peerCred(conn)
+		if err != nil {
+			log.Warnf("Rejecting gRPC connection, failed to get peer credentials: %v", err)
+		} else if !l.allowed(cred) {
+			log.Warnf("Rejecting gRPC connection from PID %d, UID %d, GID %d", cred.Pid, cred.Uid, cred.Gid)
+		} else {
+			return conn, nil
+		}
+		rpcRejectedCnt.Inc()
+		_ = conn.Close()
+	}
+}
+
+func (l *peerCredListener) allowed(cred *unix.Ucred) bool {
+	for _, uid := range l.allowedUIDs {
+		if cred.Uid == uid {
+			return true
+		}
+	}
+	for _, gid := range l.allowedGIDs {
+		if cred.Gid == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// peerCred returns the credentials of the process at the other end of a Unix socket connection
+func peerCred(conn net.Conn) (*unix.Ucred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.Errorf("not a Unix socket connection: %T", conn)
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+// rpcListener listens on TCP when no allowlist is configured, and on the Unix socket ipamdgRPCSocket with peer
+// credential checking otherwise. In the latter case it also returns a TCP listener for the gRPC health service only,
+// the readiness and liveness probes and the entrypoint check ipamd with grpc-health-probe on TCP. An invalid
+// allowlist is an error rather than ignored, so that ipamd does not fall back to the unauthenticated TCP listener.
+func rpcListener() (listener net.Listener, healthListener net.Listener, err error) {
+	uids, err := getRPCAllowedIDs(envRPCAllowedUIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	gids, err := getRPCAllowedIDs(envRPCAllowedGIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(uids) == 0 && len(gids) == 0 {
+		// The CNI plugin would keep using a socket left over from a previous configuration
+		if err := os.Remove(ipamdgRPCSocket); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to remove gRPC socket %s: %v", ipamdgRPCSocket, err)
+		}
+		log.Info("Serving RPC Handler on ", ipamdgRPCaddress)
+		listener, err := net.Listen("tcp", ipamdgRPCaddress)
+		return listener, nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ipamdgRPCSocket), 0755); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to create the directory of gRPC socket %s", ipamdgRPCSocket)
+	}
+	if err := os.Remove(ipamdgRPCSocket); err != nil && !os.IsNotExist(err) {
+		return nil, nil, errors.Wrapf(err, "failed to remove stale gRPC socket %s", ipamdgRPCSocket)
+	}
+	unixListener, err := net.Listen("unix", ipamdgRPCSocket)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Anyone may connect, the peer credentials are checked on each connection
+	if err := os.Chmod(ipamdgRPCSocket, 0666); err != nil {
+		_ = unixListener.Close()
+		return nil, nil, errors.Wrapf(err, "failed to set the permissions of gRPC socket %s", ipamdgRPCSocket)
+	}
+	healthListener, err = net.Listen("tcp", ipamdgRPCaddress)
+	if err != nil {
+		_ = unixListener.Close()
+		return nil, nil, err
+	}
+	log.Infof("Serving RPC Handler on %s for UIDs %v and GIDs %v, and the health service on %s",
+		ipamdgRPCSocket, uids, gids, ipamdgRPCaddress)
+	return &peerCredListener{Listener: unixListener, allowedUIDs: uids, allowedGIDs: gids}, healthListener, nil
+}
+
+// getRPCAllowedIDs parses a comma separated list of UIDs or GIDs, and returns an error if an entry is invalid
+func getRPCAllowedIDs(envName string) ([]uint32, error) {
+	var ids []uint32
+	for _, s := range strings.Split(os.Getenv(envName), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid ID %q in %s", s, envName)
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
+
+// getRPCAllowedIDsForDebug returns the allowed UIDs or GIDs, nil if the list is invalid
+func getRPCAllowedIDsForDebug(envName string) []uint32 {
+	ids, err := getRPCAllowedIDs(envName)
+	if err != nil {
+		return nil
+	}
+	return ids
+}
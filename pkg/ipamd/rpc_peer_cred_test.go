@@ -0,0 +1,92 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerCredListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipamd-rpc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	newListener := func(name string, uids, gids []uint32) (*peerCredListener, string) {
+		socket := filepath.Join(dir, name)
+		ln, err := net.Listen("unix", socket)
+		assert.NoError(t, err)
+		return &peerCredListener{Listener: ln, allowedUIDs: uids, allowedGIDs: gids}, socket
+	}
+	uid := uint32(os.Getuid())
+	gid := uint32(os.Getgid())
+
+	// Not allowed, the connection is closed
+	listener, socket := newListener("rejected.sock", []uint32{uid + 1}, []uint32{gid + 1})
+	go func() {
+		_, _ = listener.Accept()
+	}()
+	conn, err := net.Dial("unix", socket)
+	assert.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	_ = conn.Close()
+	_ = listener.Close()
+
+	// Allowed by GID
+	listener, socket = newListener("allowed.sock", nil, []uint32{gid})
+	defer listener.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	conn, err = net.Dial("unix", socket)
+	assert.NoError(t, err)
+	defer conn.Close()
+	serverConn := <-accepted
+	defer serverConn.Close()
+	cred, err := peerCred(serverConn)
+	assert.NoError(t, err)
+	assert.Equal(t, uid, cred.Uid)
+}
+
+func TestGetRPCAllowedIDs(t *testing.T) {
+	defer os.Unsetenv(envRPCAllowedUIDs)
+
+	ids, err := getRPCAllowedIDs(envRPCAllowedUIDs)
+	assert.NoError(t, err)
+	assert.Nil(t, ids)
+	_ = os.Setenv(envRPCAllowedUIDs, "0, 1000")
+	ids, err = getRPCAllowedIDs(envRPCAllowedUIDs)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{0, 1000}, ids)
+
+	// An invalid entry must not leave an empty allowlist, which would serve gRPC on TCP to anyone
+	for _, invalid := range []string{"0,invalid", "-1", "1000,4294967296"} {
+		_ = os.Setenv(envRPCAllowedUIDs, invalid)
+		_, err = getRPCAllowedIDs(envRPCAllowedUIDs)
+		assert.Error(t, err, invalid)
+		assert.Nil(t, getRPCAllowedIDsForDebug(envRPCAllowedUIDs))
+	}
+	_, _, err = rpcListener()
+	assert.Error(t, err)
+}
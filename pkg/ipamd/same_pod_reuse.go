@@ -0,0 +1,171 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to specify, in seconds, for how long the IP released by the DEL of a pod is
+	// preferred for a new sandbox of the same pod UID, so that a pod restarting quickly keeps its IP and the conntrack
+	// entries and routes to it stay valid. When it is not set or set to 0, a new sandbox gets any free IP.
+	envSamePodIPReuseWindow = "SAME_POD_IP_REUSE_WINDOW"
+	noSamePodIPReuse        = 0
+)
+
+// SamePodIPReuseStats counts the IPs given back to a new sandbox of the same pod, for introspection
+type SamePodIPReuseStats struct {
+	Window time.Duration
+	// Tracked is the number of pods whose released IP is still preferred for them
+	Tracked int
+	// Hits is the number of pods that got their IP back, and Misses the number of those whose IP was no longer free
+	Hits    int64
+	Misses  int64
+	LastHit time.Time `json:",omitempty"`
+	LastPod string    `json:",omitempty"`
+}
+
+// freedPodIP is the IP released by the DEL of a pod
+type freedPodIP struct {
+	ip      string
+	freedAt time.Time
+}
+
+// samePodIPReuseState keeps the IPs released recently, keyed by pod UID
+type samePodIPReuseState struct {
+	freed  map[string]freedPodIP
+	hits   int64
+	misses int64
+	last   time.Time
+	pod    string
+	lock   sync.RWMutex
+}
+
+// recordSamePodIPFreed keeps the IP released by the DEL of the pod, to prefer it for the next sandbox of the pod
+func (c *IPAMContext) recordSamePodIPFreed(uid string, ip string) {
+	if c.samePodIPReuseWindow == noSamePodIPReuse || uid == "" || ip == "" {
+		return
+	}
+	now := time.Now()
+	s := &c.samePodIPReuse
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.freed == nil {
+		s.freed = make(map[string]freedPodIP)
+	}
+	for freedUID, freed := range s.freed {
+		if now.Sub(freed.freedAt) > c.samePodIPReuseWindow {
+			delete(s.freed, freedUID)
+		}
+	}
+	s.freed[uid] = freedPodIP{ip: ip, freedAt: now}
+}
+
+// takeSamePodIP returns the IP the pod released within SAME_POD_IP_REUSE_WINDOW, if any
+func (c *IPAMContext) takeSamePodIP(pod *k8sapi.K8SPodInfo) (string, bool) {
+	if c.samePodIPReuseWindow == noSamePodIPReuse || pod.UID == "" {
+		return "", false
+	}
+	s := &c.samePodIPReuse
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	freed, ok := s.freed[pod.UID]
+	if !ok {
+		return "", false
+	}
+	delete(s.freed, pod.UID)
+	if time.Since(freed.freedAt) > c.samePodIPReuseWindow {
+		return "", false
+	}
+	return freed.ip, true
+}
+
+// recordSamePodIPReuse counts whether the pod got back the IP it released
+func (c *IPAMContext) recordSamePodIPReuse(pod *k8sapi.K8SPodInfo, hit bool) {
+	s := &c.samePodIPReuse
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !hit {
+		s.misses++
+		return
+	}
+	s.hits++
+	s.last = time.Now()
+	s.pod = pod.Namespace + "/" + pod.Name
+}
+
+// assignPodIPv4AddressReusing assigns to the pod the IP it released within SAME_POD_IP_REUSE_WINDOW if it is still
+// free, even in its cooling period, or any free IP otherwise
+func (c *IPAMContext) assignPodIPv4AddressReusing(pod *k8sapi.K8SPodInfo) (string, int, error) {
+	ip, ok := c.takeSamePodIP(pod)
+	if !ok {
+		return c.assignPodIPv4AddressFromPool(pod)
+	}
+	c.podTraces.step(pod, "pod UID %s released IP %s recently", pod.UID, ip)
+	addr, deviceNumber, preferred, err := c.dataStore.AssignPodIPv4AddressPreferred(pod, ip)
+	if err != nil {
+		return addr, deviceNumber, err
+	}
+	c.recordSamePodIPReuse(pod, preferred)
+	if preferred {
+		log.Infof("Gave back IP %s to Pod %s, Namespace %s, UID %s", ip, pod.Name, pod.Namespace, pod.UID)
+		c.podTraces.setSource(pod, podIPSourceReused)
+	} else {
+		c.podTraces.step(pod, "IP %s is not free, took another IP", ip)
+	}
+	return addr, deviceNumber, nil
+}
+
+// GetSamePodIPReuseStats returns the counts of the IPs given back to a new sandbox of the same pod
+func (c *IPAMContext) GetSamePodIPReuseStats() SamePodIPReuseStats {
+	s := &c.samePodIPReuse
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	stats := SamePodIPReuseStats{
+		Window:  c.samePodIPReuseWindow,
+		Hits:    s.hits,
+		Misses:  s.misses,
+		LastHit: s.last,
+		LastPod: s.pod,
+	}
+	for _, freed := range s.freed {
+		if time.Since(freed.freedAt) <= c.samePodIPReuseWindow {
+			stats.Tracked++
+		}
+	}
+	return stats
+}
+
+func getSamePodIPReuseWindow() time.Duration {
+	inputStr, found := os.LookupEnv(envSamePodIPReuseWindow)
+
+	if !found {
+		return noSamePodIPReuse
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envSamePodIPReuseWindow, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Errorf("Invalid %s value %q, using default %d", envSamePodIPReuseWindow, inputStr, noSamePodIPReuse)
+	return noSamePodIPReuse
+}
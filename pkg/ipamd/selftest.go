@@ -0,0 +1,108 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to enable the POST /v1/selftest introspection endpoint, which assigns a free
+	// IP of the pool to a synthetic pod and releases it. When it is not set, the endpoint is not served.
+	envEnableSelfTest = "ENABLE_SELFTEST_ENDPOINT"
+
+	// selfTestNamespace is the namespace of the synthetic pods of the self-test, which no real pod can use
+	selfTestNamespace = "_ipamd-selftest"
+	// selfTestMinFreeIPs is the number of free IPs, out of their cooling period, the pool must have for the self-test
+	// to take one, so that a real pod can still get an IP while it runs
+	selfTestMinFreeIPs = 2
+)
+
+// SelfTestResult is the outcome of a self-test of the IP assignment path, for introspection
+type SelfTestResult struct {
+	Success      bool
+	IP           string `json:",omitempty"`
+	DeviceNumber int
+	// AssignMs and ReleaseMs are how long assigning and releasing the IP took, TotalMs the whole test
+	AssignMs  float64
+	ReleaseMs float64
+	TotalMs   float64
+	Error     string `json:",omitempty"`
+}
+
+// selfTest assigns a free IP of the pool to a synthetic pod and releases it. No pod ever used the released IP, so it
+// is free again right away, without the cooling period.
+func (c *IPAMContext) selfTest() SelfTestResult {
+	start := time.Now()
+	result := SelfTestResult{}
+	err := c.runSelfTest(&result)
+	result.TotalMs = msSince(start)
+	if err != nil {
+		log.Errorf("Self-test failed: %v", err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Success = true
+	log.Infof("Self-test succeeded with IP %s in %.3fms", result.IP, result.TotalMs)
+	return result
+}
+
+func (c *IPAMContext) runSelfTest(result *SelfTestResult) error {
+	if c.isTerminating() {
+		return errors.New("ipamd is terminating")
+	}
+	if free := c.dataStore.GetFreeIPs(); free < selfTestMinFreeIPs {
+		return errors.Errorf("the pool has %d free IPs, the self-test needs at least %d", free, selfTestMinFreeIPs)
+	}
+
+	pod := &k8sapi.K8SPodInfo{
+		Name:      fmt.Sprintf("selftest-%d", time.Now().UnixNano()),
+		Namespace: selfTestNamespace,
+		Sandbox:   "selftest",
+	}
+	start := time.Now()
+	ip, deviceNumber, err := c.dataStore.AssignPodIPv4Address(pod)
+	result.AssignMs = msSince(start)
+	if err != nil {
+		return errors.Wrap(err, "failed to assign an IP address")
+	}
+	result.IP = ip
+	result.DeviceNumber = deviceNumber
+
+	start = time.Now()
+	releasedIP, _, err := c.dataStore.UnassignPodIPv4AddressNoCooling(pod)
+	result.ReleaseMs = msSince(start)
+	if err != nil {
+		return errors.Wrapf(err, "failed to release IP address %s", ip)
+	}
+	if releasedIP != ip {
+		return errors.Errorf("released IP address %s instead of %s", releasedIP, ip)
+	}
+	return nil
+}
+
+// msSince returns milliseconds since start.
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start)) / float64(time.Millisecond)
+}
+
+func selfTestEnabled() bool {
+	return getEnvBoolWithDefault(envEnableSelfTest, false)
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+func TestSelfTest(t *testing.T) {
+	c := &IPAMContext{dataStore: datastoreWith1Pod1()}
+
+	result := c.selfTest()
+	assert.True(t, result.Success)
+	assert.Empty(t, result.Error)
+	assert.Contains(t, []string{ipaddr02, ipaddr03}, result.IP)
+	total, assigned := c.dataStore.GetStats()
+	assert.Equal(t, 3, total)
+	assert.Equal(t, 1, assigned)
+
+	// The released IP is not cooling, the self-test can run again
+	assert.Empty(t, c.dataStore.GetCoolingIPs())
+	result = c.selfTest()
+	assert.True(t, result.Success)
+	assert.Equal(t, 2, c.dataStore.GetFreeIPs())
+
+	// A cooling IP is not free, the last free IP is left for real pods
+	_, _, err := c.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2"})
+	assert.NoError(t, err)
+	_, _, err = c.dataStore.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{Name: "pod-2", Namespace: "ns-1", Sandbox: "sandbox-2"})
+	assert.NoError(t, err)
+	result = c.selfTest()
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, "has 1 free IPs, the self-test needs at least 2")
+	c.dataStore = datastoreWith3Pods()
+	result = c.selfTest()
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, "needs at least 2")
+
+	handler := selfTestRequestHandler(c)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/v1/selftest", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/v1/selftest", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"Success":false`)
+}
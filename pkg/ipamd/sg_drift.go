@@ -0,0 +1,91 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ENISecurityGroups are the security groups of an ENI compared with those ipamd creates its ENIs with
+type ENISecurityGroups struct {
+	ENIID          string
+	SecurityGroups []string
+	// Missing are the expected groups the ENI does not have, and Unexpected the groups of the ENI not expected
+	Missing    []string `json:",omitempty"`
+	Unexpected []string `json:",omitempty"`
+	Drifted    bool
+}
+
+// SecurityGroupDrift compares the security groups of the ENIs ipamd manages with the expected ones, for introspection
+type SecurityGroupDrift struct {
+	// Expected are the security groups of the ENIConfig of the node with custom networking, or of the primary ENI
+	Expected []string
+	ENIs     []ENISecurityGroups
+	// Drifted is the number of ENIs whose security groups are not the expected ones
+	Drifted int
+}
+
+// expectedSecurityGroups returns the security groups ipamd creates its ENIs with
+func (c *IPAMContext) expectedSecurityGroups() ([]string, error) {
+	if !c.useCustomNetworking {
+		return c.awsClient.GetPrimaryENISecurityGroups(), nil
+	}
+	eniCfg, err := c.eniConfig.MyENIConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the ENIConfig of the node")
+	}
+	return eniCfg.SecurityGroups, nil
+}
+
+// GetSecurityGroupDrift compares the security groups of the ENIs attached to the instance as of the last reconcile,
+// except for the primary ENI and the ENIs tagged for ipamd not to manage them, with the expected ones
+func (c *IPAMContext) GetSecurityGroupDrift() (*SecurityGroupDrift, error) {
+	expected, err := c.expectedSecurityGroups()
+	if err != nil {
+		return nil, err
+	}
+	allENIs, err := c.getAttachedENIs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get attached ENIs")
+	}
+	attachedENIs, _ := filterUnmanagedENIs(allENIs)
+
+	expectedSet := sets.NewString(expected...)
+	drift := &SecurityGroupDrift{Expected: expectedSet.List(), ENIs: make([]ENISecurityGroups, 0, len(attachedENIs))}
+	primaryENI := c.awsClient.GetPrimaryENI()
+	for _, eni := range attachedENIs {
+		if eni.ENIID == primaryENI {
+			continue
+		}
+		groups := sets.NewString(eni.SecurityGroups...)
+		eniGroups := ENISecurityGroups{
+			ENIID:          eni.ENIID,
+			SecurityGroups: groups.List(),
+			Missing:        expectedSet.Difference(groups).List(),
+			Unexpected:     groups.Difference(expectedSet).List(),
+		}
+		eniGroups.Drifted = len(eniGroups.Missing) > 0 || len(eniGroups.Unexpected) > 0
+		if eniGroups.Drifted {
+			drift.Drifted++
+		}
+		drift.ENIs = append(drift.ENIs, eniGroups)
+	}
+	sort.Slice(drift.ENIs, func(i, j int) bool {
+		return drift.ENIs[i].ENIID < drift.ENIs[j].ENIID
+	})
+	return drift, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// This environment variable is used to enable the shrink-only mode of the IP pool of a node marked for scale-down,
+	// either by the ToBeDeletedByClusterAutoscaler taint of the cluster autoscaler or by the
+	// k8s.amazonaws.com/shrink-ip-pool=true annotation. In that mode the pool no longer grows, and its free IPs and
+	// unused ENIs are released whatever the warm targets, except for the RESERVED_IPS. It defaults to false.
+	envScaleDownShrink = "SCALE_DOWN_SHRINK"
+
+	// shrinkModeCheckInterval is how often the node is checked for scale-down
+	shrinkModeCheckInterval = 30 * time.Second
+)
+
+// ShrinkModeStats describes the shrink-only mode of the IP pool, for introspection
+type ShrinkModeStats struct {
+	Enabled bool
+	// Active is true while the node is marked for scale-down, because of Reason, since Since
+	Active    bool
+	Reason    string    `json:",omitempty"`
+	Since     time.Time `json:",omitempty"`
+	LastCheck time.Time
+	// LastError is why the last check could not be done
+	LastError string `json:",omitempty"`
+}
+
+// shrinkModeState keeps the result of the last scale-down check of the node
+type shrinkModeState struct {
+	stats ShrinkModeStats
+	lock  sync.RWMutex
+}
+
+// checkShrinkModeIfDue checks every shrinkModeCheckInterval whether the node is marked for scale-down. A failed check
+// keeps the current mode.
+func (c *IPAMContext) checkShrinkModeIfDue() {
+	if !c.scaleDownShrink {
+		return
+	}
+	c.shrinkMode.lock.RLock()
+	lastCheck := c.shrinkMode.stats.LastCheck
+	c.shrinkMode.lock.RUnlock()
+	if time.Since(lastCheck) < shrinkModeCheckInterval {
+		return
+	}
+	reason, err := c.k8sClient.K8SGetNodeScaleDownReason()
+
+	c.shrinkMode.lock.Lock()
+	defer c.shrinkMode.lock.Unlock()
+	stats := &c.shrinkMode.stats
+	stats.LastCheck = time.Now()
+	stats.LastError = ""
+	if err != nil {
+		log.Warnf("Failed to check whether the node is marked for scale-down: %v", err)
+		stats.LastError = err.Error()
+		return
+	}
+	switch {
+	case reason != "" && !stats.Active:
+		log.Infof("The node is marked for scale-down by %s, the IP pool will only shrink", reason)
+		stats.Active, stats.Reason, stats.Since = true, reason, stats.LastCheck
+	case reason == "" && stats.Active:
+		log.Infof("The node is no longer marked for scale-down, the IP pool can grow again")
+		stats.Active, stats.Reason, stats.Since = false, "", time.Time{}
+	}
+}
+
+// inShrinkMode returns true if the node is marked for scale-down and its IP pool must only shrink
+func (c *IPAMContext) inShrinkMode() bool {
+	c.shrinkMode.lock.RLock()
+	defer c.shrinkMode.lock.RUnlock()
+	return c.shrinkMode.stats.Active
+}
+
+// effectiveMinimumIPTarget returns the minimum IP target currently in use, MINIMUM_IP_TARGET unless the pool is in
+// shrink-only mode
+func (c *IPAMContext) effectiveMinimumIPTarget() int {
+	if c.inShrinkMode() {
+		return noMinimumIPTarget
+	}
+	return c.minimumIPTarget
+}
+
+func (c *IPAMContext) getShrinkModeStats() ShrinkModeStats {
+	c.shrinkMode.lock.RLock()
+	defer c.shrinkMode.lock.RUnlock()
+	stats := c.shrinkMode.stats
+	stats.Enabled = c.scaleDownShrink
+	return stats
+}
+
+func scaleDownShrinkEnabled() bool {
+	return getEnvBoolWithDefault(envScaleDownShrink, false)
+}
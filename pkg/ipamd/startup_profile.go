@@ -0,0 +1,108 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// The phases of the startup of ipamd, in order
+const (
+	// startupPhaseIMDS fetches the instance metadata and builds the EC2 client
+	startupPhaseIMDS = "imds"
+	// startupPhaseENIDiscovery lists the attached ENIs and gets the ENI and IP limits of the instance
+	startupPhaseENIDiscovery = "eni-discovery"
+	// startupPhaseHostNetwork sets up the host network
+	startupPhaseHostNetwork = "host-network"
+	// startupPhaseENISetup adds the attached ENIs and their IPs to the datastore and sets up their network
+	startupPhaseENISetup = "eni-setup"
+	// startupPhaseInitialReconcile gets the running pods from the API server and the CRI, and gives them their IPs back
+	startupPhaseInitialReconcile = "initial-reconcile"
+	// startupPhaseInitialPool grows the pool to the warm targets
+	startupPhaseInitialPool = "initial-pool"
+	// startupPhaseReadiness starts the pool manager and the introspection and metrics servers, until the gRPC server
+	// serves the CNI plugin
+	startupPhaseReadiness = "readiness"
+)
+
+// StartupPhase is how long a phase of the startup of ipamd took
+type StartupPhase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// StartupProfile describes where the startup of ipamd spent its time, for introspection
+type StartupProfile struct {
+	Started time.Time
+	// Ready is when the gRPC server started to serve the CNI plugin, zero while ipamd is starting
+	Ready time.Time `json:",omitempty"`
+	// Total is how long ipamd took to be ready, or has been starting
+	Total  time.Duration
+	Phases []StartupPhase
+}
+
+// startupProfileState keeps the durations of the phases of the startup completed so far
+type startupProfileState struct {
+	started   time.Time
+	lastPhase time.Time
+	ready     time.Time
+	phases    []StartupPhase
+	lock      sync.RWMutex
+}
+
+// start records the start of ipamd
+func (s *startupProfileState) start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.started = time.Now()
+	s.lastPhase = s.started
+}
+
+// endPhase records that the phase name ended, it started when the previous one ended
+func (s *startupProfileState) endPhase(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	s.phases = append(s.phases, StartupPhase{Name: name, Duration: now.Sub(s.lastPhase)})
+	s.lastPhase = now
+}
+
+// markReady ends the readiness phase, when ipamd starts serving the CNI plugin
+func (s *startupProfileState) markReady() {
+	s.endPhase(startupPhaseReadiness)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ready = s.lastPhase
+	log.Infof("ipamd is ready, the startup took %v", s.ready.Sub(s.started))
+}
+
+// GetStartupProfile returns how long each phase of the startup of ipamd took
+func (c *IPAMContext) GetStartupProfile() StartupProfile {
+	c.startupProfile.lock.RLock()
+	defer c.startupProfile.lock.RUnlock()
+	profile := StartupProfile{
+		Started: c.startupProfile.started,
+		Ready:   c.startupProfile.ready,
+		Phases:  append([]StartupPhase{}, c.startupProfile.phases...),
+	}
+	if profile.Ready.IsZero() {
+		profile.Total = time.Since(profile.Started)
+	} else {
+		profile.Total = profile.Ready.Sub(profile.Started)
+	}
+	return profile
+}
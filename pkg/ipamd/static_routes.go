@@ -0,0 +1,59 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
+	"github.com/aws/amazon-vpc-cni-k8s/rpc"
+)
+
+// podStaticRoutes returns the static routes of the ENIConfig of the node, for a pod whose IP is on the ENI of
+// deviceNumber. The pods on the primary ENI do not use the ENIConfig. When one of the routes is invalid, none is
+// returned, so that a pod never gets only part of them.
+func (c *IPAMContext) podStaticRoutes(deviceNumber int) []*rpc.StaticRoute {
+	if !c.useCustomNetworking || deviceNumber == 0 {
+		return nil
+	}
+	eniCfg, err := c.eniConfig.MyENIConfig()
+	if err != nil || len(eniCfg.StaticRoutes) == 0 {
+		return nil
+	}
+	if err := eniconfig.ValidateStaticRoutes(eniCfg.StaticRoutes); err != nil {
+		log.Errorf("Not applying the static routes of the ENIConfig of the node: %v", err)
+		ipamdErrInc("invalidStaticRoutes")
+		return nil
+	}
+	routes := make([]*rpc.StaticRoute, 0, len(eniCfg.StaticRoutes))
+	for _, route := range eniCfg.StaticRoutes {
+		routes = append(routes, &rpc.StaticRoute{Destination: route.Destination, Gateway: route.Gateway})
+	}
+	return routes
+}
+
+// invalidStaticRoutes returns why the static routes of each ENIConfig are not applied, for the ENIConfigs with an
+// invalid route
+func invalidStaticRoutes(info *eniconfig.ENIConfigInfo) map[string]string {
+	var invalid map[string]string
+	for name, spec := range info.ENI {
+		if err := eniconfig.ValidateStaticRoutes(spec.StaticRoutes); err != nil {
+			if invalid == nil {
+				invalid = make(map[string]string)
+			}
+			invalid[name] = err.Error()
+		}
+	}
+	return invalid
+}
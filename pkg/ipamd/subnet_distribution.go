@@ -0,0 +1,60 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+)
+
+// SubnetDistribution counts the ENIs and IPs the node holds in a subnet, for introspection
+type SubnetDistribution struct {
+	// SubnetID is empty for the ENIs whose subnet is not known yet
+	SubnetID string
+	// Primary is true for the subnet of the primary ENI
+	Primary bool
+	ENIs    []string
+	// IPs is the number of secondary IPs of the ENIs in the datastore, AssignedIPs how many of them are assigned to pods
+	IPs         int
+	AssignedIPs int
+}
+
+// GetSubnetDistribution returns the ENIs of the datastore and their IPs by subnet, the subnet of the primary ENI
+// first and the others sorted by ID
+func (c *IPAMContext) GetSubnetDistribution() []SubnetDistribution {
+	bySubnet := make(map[string]*SubnetDistribution)
+	for _, eni := range c.dataStore.GetENIInfos().ENIIPPools {
+		subnet, ok := bySubnet[eni.SubnetID]
+		if !ok {
+			subnet = &SubnetDistribution{SubnetID: eni.SubnetID, ENIs: []string{}}
+			bySubnet[eni.SubnetID] = subnet
+		}
+		subnet.Primary = subnet.Primary || eni.IsPrimary
+		subnet.ENIs = append(subnet.ENIs, eni.ID)
+		subnet.IPs += len(eni.IPv4Addresses)
+		subnet.AssignedIPs += eni.AssignedIPv4Addresses
+	}
+
+	distribution := make([]SubnetDistribution, 0, len(bySubnet))
+	for _, subnet := range bySubnet {
+		sort.Strings(subnet.ENIs)
+		distribution = append(distribution, *subnet)
+	}
+	sort.Slice(distribution, func(i, j int) bool {
+		if distribution[i].Primary != distribution[j].Primary {
+			return distribution[i].Primary
+		}
+		return distribution[i].SubnetID < distribution[j].SubnetID
+	})
+	return distribution
+}
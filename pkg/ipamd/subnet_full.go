@@ -0,0 +1,229 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+const (
+	// This environment variable is used to specify a comma separated list of subnets in the availability zone of the
+	// node. When a new ENI cannot be created because its subnet is full, ipamd creates it in the first of these
+	// subnets that is not full, with the same security groups. EC2 only attaches to an instance the ENIs in its
+	// availability zone, the subnets in another zone are skipped.
+	envAlternateSubnets = "ALTERNATE_SUBNETS"
+
+	// subnetFullRetryInterval is how long ipamd waits before creating an ENI again once all the subnets were full
+	subnetFullRetryInterval = 60 * time.Second
+	// maxSubnetFullEvents is the number of most recent subnet full events that are kept
+	maxSubnetFullEvents = 50
+
+	subnetFullCreateENI = "CreateNetworkInterface"
+	subnetFullAssignIPs = "AssignPrivateIpAddresses"
+)
+
+// errSubnetsFull is returned when the pool cannot grow because the subnets of the node are full
+var errSubnetsFull = errors.New("no free IP address, the subnet and alternate subnets of the node are full")
+
+// SubnetFullEvent is an EC2 call that failed because the subnet was full
+type SubnetFullEvent struct {
+	Time      time.Time
+	Subnet    string
+	Operation string
+	ENIID     string `json:",omitempty"`
+}
+
+// SubnetFullStats describes the subnet full errors of the pool manager, for introspection
+type SubnetFullStats struct {
+	AlternateSubnets []string
+	// AllFull is true when the subnet and all the alternate subnets were full on the last ENI creation. ENIs are not
+	// created again before RetryAfter.
+	AllFull    bool
+	FullSince  time.Time
+	RetryAfter time.Time
+	// Counts are the number of subnet full errors of each subnet, and Events the most recent ones, newest first
+	Counts map[string]int
+	Events []SubnetFullEvent
+	// OtherAZSubnets are the alternate subnets skipped because they are not in the availability zone of the node,
+	// with their zone
+	OtherAZSubnets map[string]string `json:",omitempty"`
+}
+
+// subnetFullState keeps the subnet full errors
+type subnetFullState struct {
+	counts     map[string]int
+	events     []SubnetFullEvent
+	fullSince  time.Time
+	retryAfter time.Time
+	otherAZ    map[string]string
+	lock       sync.RWMutex
+}
+
+func (s *subnetFullState) record(subnet string, operation string, eniID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[subnet]++
+	s.events = append(s.events, SubnetFullEvent{Time: time.Now(), Subnet: subnet, Operation: operation, ENIID: eniID})
+	s.events = s.events[keepLast(len(s.events), maxSubnetFullEvents):]
+	ipamdErrInc("subnetFull")
+}
+
+// recordOtherAZ records an alternate subnet skipped because it is in another availability zone, and returns true the
+// first time
+func (s *subnetFullState) recordOtherAZ(subnet string, subnetAZ string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.otherAZ == nil {
+		s.otherAZ = make(map[string]string)
+	}
+	_, seen := s.otherAZ[subnet]
+	s.otherAZ[subnet] = subnetAZ
+	return !seen
+}
+
+func (s *subnetFullState) setAllFull() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if s.fullSince.IsZero() {
+		s.fullSince = now
+	}
+	s.retryAfter = now.Add(subnetFullRetryInterval)
+}
+
+func (s *subnetFullState) clearAllFull() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.fullSince.IsZero() {
+		log.Infof("The subnets are no longer full, they were since %s", s.fullSince.Format(time.RFC3339))
+	}
+	s.fullSince = time.Time{}
+	s.retryAfter = time.Time{}
+}
+
+func (s *subnetFullState) isAllFull() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return !s.fullSince.IsZero()
+}
+
+// waiting returns true if all the subnets were full less than subnetFullRetryInterval ago
+func (s *subnetFullState) waiting() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return time.Now().Before(s.retryAfter)
+}
+
+// usableAlternateSubnets returns the ALTERNATE_SUBNETS in the availability zone of the node
+func (c *IPAMContext) usableAlternateSubnets() []string {
+	var subnets []string
+	for _, subnet := range c.alternateSubnets {
+		subnetAZ, ok := c.subnetInNodeAZ(subnet)
+		if !ok {
+			if c.subnetFull.recordOtherAZ(subnet, subnetAZ) {
+				log.Errorf("Alternate subnet %s is in availability zone %s, but the node is in %s. EC2 cannot attach "+
+					"ENIs in this subnet to the node, skipping it", subnet, subnetAZ, c.awsClient.GetAvailabilityZone())
+				ipamdErrInc("alternateSubnetOtherAZ")
+			}
+			continue
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets
+}
+
+// allocateENIInAlternateSubnets creates the ENI in the first ALTERNATE_SUBNETS in the availability zone of the node
+// that is not full after it failed in fullSubnet. It returns errSubnetsFull if all of them are full.
+func (c *IPAMContext) allocateENIInAlternateSubnets(eniCfg *v1alpha1.ENIConfigSpec, securityGroups []*string,
+	fullSubnet string, ipsToAllocate int) error {
+	c.subnetFull.record(fullSubnet, subnetFullCreateENI, "")
+	for _, subnet := range c.usableAlternateSubnets() {
+		if subnet == fullSubnet {
+			continue
+		}
+		log.Warnf("Subnet %s is full, creating the ENI in alternate subnet %s", fullSubnet, subnet)
+		err := c.allocateENI(eniCfg, securityGroups, subnet, ipsToAllocate)
+		if err == nil {
+			c.subnetFull.clearAllFull()
+			return nil
+		}
+		if !awsutils.IsSubnetFullError(err) {
+			return err
+		}
+		c.subnetFull.record(subnet, subnetFullCreateENI, "")
+		fullSubnet = subnet
+	}
+	c.subnetFull.setAllFull()
+	log.Errorf("Unable to create an ENI, the subnet and alternate subnets %v are full, retrying in %v",
+		c.alternateSubnets, subnetFullRetryInterval)
+	return errSubnetsFull
+}
+
+// primarySubnet returns the subnet of the primary ENI
+func (c *IPAMContext) primarySubnet() string {
+	eni, ok := c.dataStore.GetENIInfos().ENIIPPools[c.awsClient.GetPrimaryENI()]
+	if !ok {
+		return ""
+	}
+	return eni.SubnetID
+}
+
+// GetSubnetFullStats returns the subnet full errors
+func (c *IPAMContext) GetSubnetFullStats() SubnetFullStats {
+	c.subnetFull.lock.RLock()
+	defer c.subnetFull.lock.RUnlock()
+	stats := SubnetFullStats{
+		AlternateSubnets: c.alternateSubnets,
+		AllFull:          !c.subnetFull.fullSince.IsZero(),
+		FullSince:        c.subnetFull.fullSince,
+		RetryAfter:       c.subnetFull.retryAfter,
+		Counts:           make(map[string]int),
+		Events:           make([]SubnetFullEvent, 0, len(c.subnetFull.events)),
+	}
+	for subnet, count := range c.subnetFull.counts {
+		stats.Counts[subnet] = count
+	}
+	for i := len(c.subnetFull.events) - 1; i >= 0; i-- {
+		stats.Events = append(stats.Events, c.subnetFull.events[i])
+	}
+	if len(c.subnetFull.otherAZ) > 0 {
+		stats.OtherAZSubnets = make(map[string]string, len(c.subnetFull.otherAZ))
+		for subnet, subnetAZ := range c.subnetFull.otherAZ {
+			stats.OtherAZSubnets[subnet] = subnetAZ
+		}
+	}
+	return stats
+}
+
+func getAlternateSubnets() []string {
+	var subnets []string
+	for _, subnet := range strings.Split(os.Getenv(envAlternateSubnets), ",") {
+		if subnet = strings.TrimSpace(subnet); subnet != "" {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
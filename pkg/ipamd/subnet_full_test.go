@@ -0,0 +1,116 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocateENIInAlternateSubnets(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:        mockAWS,
+		dataStore:        datastoreWith3FreeIPs(),
+		maxIPsPerENI:     14,
+		alternateSubnets: []string{"subnet-primary", "subnet-alt1", "subnet-other", "subnet-alt2"},
+	}
+	_ = mockContext.dataStore.SetENISubnet(primaryENIid, "subnet-primary")
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid).AnyTimes()
+	// The subnets in another availability zone are skipped, their zone is only looked up once
+	mockAWS.EXPECT().GetAvailabilityZone().Return("us-west-2a").AnyTimes()
+	mockAWS.EXPECT().GetSubnetAvailabilityZone(gomock.Any(), "subnet-primary").Return("us-west-2a", nil)
+	mockAWS.EXPECT().GetSubnetAvailabilityZone(gomock.Any(), "subnet-alt1").Return("us-west-2a", nil)
+	mockAWS.EXPECT().GetSubnetAvailabilityZone(gomock.Any(), "subnet-other").Return("us-west-2b", nil)
+	mockAWS.EXPECT().GetSubnetAvailabilityZone(gomock.Any(), "subnet-alt2").Return("", errors.New("throttled")).Times(2)
+	subnetFull := errors.Wrap(awserr.New("InsufficientFreeAddressesInSubnet", "subnet is full", nil),
+		"AllocENI: failed to create ENI")
+
+	// The other error of the second alternate subnet is returned
+	mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "").Return("", subnetFull)
+	mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "subnet-alt1").Return("", subnetFull)
+	mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "subnet-alt2").Return("", errors.New("throttled"))
+	err := mockContext.tryAllocateENI()
+	assert.EqualError(t, err, "throttled")
+	assert.False(t, mockContext.subnetFull.isAllFull())
+
+	// All the subnets are full, ENIs are not created again for a while
+	mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "").Return("", subnetFull)
+	mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "subnet-alt1").Return("", subnetFull)
+	mockAWS.EXPECT().AllocENI(gomock.Any(), false, nil, "subnet-alt2").Return("", subnetFull)
+	assert.Equal(t, errSubnetsFull, mockContext.tryAllocateENI())
+	assert.Equal(t, errSubnetsFull, mockContext.tryAllocateENI())
+
+	stats := mockContext.GetSubnetFullStats()
+	assert.True(t, stats.AllFull)
+	assert.True(t, stats.RetryAfter.After(stats.FullSince))
+	assert.Equal(t, map[string]int{"subnet-primary": 2, "subnet-alt1": 2, "subnet-alt2": 1}, stats.Counts)
+	assert.Equal(t, 5, len(stats.Events))
+	assert.Equal(t, "subnet-alt2", stats.Events[0].Subnet)
+	assert.Equal(t, subnetFullCreateENI, stats.Events[0].Operation)
+	assert.Equal(t, map[string]string{"subnet-other": "us-west-2b"}, stats.OtherAZSubnets)
+
+	mockContext.subnetFull.clearAllFull()
+	assert.False(t, mockContext.subnetFull.waiting())
+	assert.False(t, mockContext.GetSubnetFullStats().AllFull)
+}
+
+func TestSelectSubnetMostFree(t *testing.T) {
+	ctrl, mockAWS, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:        mockAWS,
+		dataStore:        datastoreWith3FreeIPs(),
+		maxIPsPerENI:     14,
+		alternateSubnets: []string{"subnet-alt1", "subnet-alt2"},
+		subnetSelection:  subnetSelectionMostFree,
+	}
+	_ = mockContext.dataStore.SetENISubnet(primaryENIid, "subnet-primary")
+	mockAWS.EXPECT().GetPrimaryENI().Return(primaryENIid).AnyTimes()
+	mockAWS.EXPECT().GetAvailabilityZone().Return("us-west-2a").AnyTimes()
+	mockAWS.EXPECT().GetSubnetAvailabilityZone(gomock.Any(), gomock.Any()).Return("us-west-2a", nil).AnyTimes()
+
+	// The ENI is created in the subnet with the most free IPs
+	mockAWS.EXPECT().GetSubnetAvailableIPCount(gomock.Any(), "subnet-primary").Return(10, nil)
+	mockAWS.EXPECT().GetSubnetAvailableIPCount(gomock.Any(), "subnet-alt1").Return(0, errors.New("throttled"))
+	mockAWS.EXPECT().GetSubnetAvailableIPCount(gomock.Any(), "subnet-alt2").Return(200, nil)
+	assert.Equal(t, "subnet-alt2", mockContext.selectSubnet(""))
+
+	stats := mockContext.GetSubnetSelectionStats()
+	assert.Equal(t, subnetSelectionMostFree, stats.Mode)
+	assert.Equal(t, "subnet-alt2", stats.Selected)
+	assert.Equal(t, []SubnetCandidate{
+		{Subnet: "subnet-primary", AvailableIPs: 10},
+		{Subnet: "subnet-alt1", Error: "throttled"},
+		{Subnet: "subnet-alt2", AvailableIPs: 200},
+	}, stats.Candidates)
+
+	// Like the primary ENI when its subnet has the most free IPs
+	mockAWS.EXPECT().GetSubnetAvailableIPCount(gomock.Any(), "subnet-primary").Return(300, nil)
+	mockAWS.EXPECT().GetSubnetAvailableIPCount(gomock.Any(), "subnet-alt1").Return(20, nil)
+	mockAWS.EXPECT().GetSubnetAvailableIPCount(gomock.Any(), "subnet-alt2").Return(200, nil)
+	assert.Equal(t, "", mockContext.selectSubnet(""))
+	assert.Equal(t, "subnet-primary", mockContext.GetSubnetSelectionStats().Selected)
+
+	// The subnet is kept by default
+	mockContext.subnetSelection = subnetSelectionDefault
+	assert.Equal(t, "subnet-custom", mockContext.selectSubnet("subnet-custom"))
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// This environment variable is used to specify the comma separated IDs of the subnets pods may ask for their IP to
+	// come from, with the k8s.amazonaws.com/subnet annotation or the K8S_POD_SUBNET CNI argument. Pods asking for any
+	// other subnet are rejected, and when it is not set, so are all pods asking for a subnet. The IPs of the ENIs in
+	// these subnets, other than the primary ENI, are only assigned to the pods asking for them.
+	envPodSubnetAllowlist = "POD_SUBNET_ALLOWLIST"
+
+	// subnetHintTTL is how long ipamd keeps growing the pool of a subnet after a pod last failed to get an IP from it
+	subnetHintTTL = 5 * time.Minute
+)
+
+// subnetHintState keeps the subnets that pods asked for and found no free IP in, and the last error growing the
+// pool of each of them
+type subnetHintState struct {
+	requested map[string]time.Time
+	lastError map[string]string
+	lock      sync.Mutex
+}
+
+// request records that a pod is waiting for an IP in the subnet, and returns the last error growing its pool
+func (s *subnetHintState) request(subnet string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.requested == nil {
+		s.requested = make(map[string]time.Time)
+		s.lastError = make(map[string]string)
+	}
+	s.requested[subnet] = time.Now()
+	return s.lastError[subnet]
+}
+
+// active returns the subnets requested within ttl, and forgets the others
+func (s *subnetHintState) active(ttl time.Duration) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var subnets []string
+	for subnet, requested := range s.requested {
+		if time.Since(requested) > ttl {
+			delete(s.requested, subnet)
+			delete(s.lastError, subnet)
+			continue
+		}
+		subnets = append(subnets, subnet)
+	}
+	sort.Strings(subnets)
+	return subnets
+}
+
+func (s *subnetHintState) setError(subnet string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err == nil {
+		delete(s.lastError, subnet)
+		return
+	}
+	s.lastError[subnet] = err.Error()
+}
+
+// checkSubnetHint returns an error when the pod asks for a subnet that is not in POD_SUBNET_ALLOWLIST, so that pods
+// cannot make ipamd attach ENIs in any subnet of the VPC
+func (c *IPAMContext) checkSubnetHint(pod *k8sapi.K8SPodInfo) error {
+	if pod.SubnetHint == "" {
+		return nil
+	}
+	for _, subnet := range c.podSubnetAllowlist {
+		if subnet == pod.SubnetHint {
+			return nil
+		}
+	}
+	log.Warnf("Rejecting Pod %s, Namespace %s, subnet %s is not in %s",
+		pod.Name, pod.Namespace, pod.SubnetHint, envPodSubnetAllowlist)
+	return errors.Errorf("subnet %s is not in %s", pod.SubnetHint, envPodSubnetAllowlist)
+}
+
+// assignPodIPv4AddressFromPool assigns any free IP address to the pod. When the pod asks for a subnet and there is no
+// free IP in it, the subnet is handed to the pool manager, and the error it last got for that subnet is returned.
+func (c *IPAMContext) assignPodIPv4AddressFromPool(pod *k8sapi.K8SPodInfo) (string, int, error) {
+	addr, deviceNumber, err := c.dataStore.AssignPodIPv4Address(pod)
+	if err != datastore.ErrNoAvailableIPs || pod.SubnetHint == "" {
+		return addr, deviceNumber, err
+	}
+	if lastErr := c.subnetHints.request(pod.SubnetHint); lastErr != "" {
+		c.podTraces.step(pod, "no free IP in subnet %s, the pool cannot grow in it: %s", pod.SubnetHint, lastErr)
+		log.Errorf("Unable to assign an IP address in subnet %s to Pod %s, Namespace %s: %s",
+			pod.SubnetHint, pod.Name, pod.Namespace, lastErr)
+		return "", 0, errors.Errorf("no free IP address in subnet %s: %s", pod.SubnetHint, lastErr)
+	}
+	log.Infof("No free IP address in subnet %s for Pod %s, Namespace %s", pod.SubnetHint, pod.Name, pod.Namespace)
+	c.podTraces.step(pod, "no free IP in subnet %s, asked the pool manager to grow it", pod.SubnetHint)
+	c.triggerPoolGrowth()
+	return "", 0, err
+}
+
+// increaseSubnetHintPools grows the pool of each subnet that pods are waiting for
+func (c *IPAMContext) increaseSubnetHintPools() {
+	if c.isTerminating() || c.inShrinkMode() {
+		return
+	}
+	for _, subnet := range c.subnetHints.active(subnetHintTTL) {
+		err := c.increaseSubnetPool(subnet)
+		if err != nil {
+			log.Errorf("Failed to increase the IP pool of subnet %s: %v", subnet, err)
+			ipamdErrInc("increaseSubnetPool")
+		}
+		c.subnetHints.setError(subnet, err)
+	}
+}
+
+// increaseSubnetPool makes sure an ENI in the subnet has a free IP, by allocating IPs on an ENI in the subnet that has
+// room for more, or else by attaching a new ENI in the subnet
+func (c *IPAMContext) increaseSubnetPool(subnet string) error {
+	eniNeedsIP := ""
+	numIPs := 0
+	for _, eni := range c.dataStore.GetENIInfos().ENIIPPools {
+		if eni.SubnetID != subnet {
+			continue
+		}
+		if eni.AssignedIPv4Addresses < len(eni.IPv4Addresses) {
+			return nil
+		}
+		if eniNeedsIP == "" && len(eni.IPv4Addresses) < c.maxIPsPerENI {
+			eniNeedsIP = eni.ID
+			numIPs = len(eni.IPv4Addresses)
+		}
+	}
+
+	if err := c.checkNodeIPQuota(); err != nil {
+		return err
+	}
+
+	if eniNeedsIP != "" {
+		log.Infof("Allocating IP addresses on ENI %s in subnet %s", eniNeedsIP, subnet)
+		allocatedIPs, err := c.allocIPAddresses(eniNeedsIP, c.subnetHintIPsToAllocate(numIPs))
+		if err != nil {
+			return errors.Wrapf(err, "failed to allocate IP addresses on ENI %s", eniNeedsIP)
+		}
+		c.addAllocatedIPsToDataStore(eniNeedsIP, allocatedIPs)
+		ec2Addrs, _, err := c.getENIaddresses(eniNeedsIP)
+		if err != nil {
+			return err
+		}
+		c.addENIaddressesToDataStore(ec2Addrs, eniNeedsIP)
+		return nil
+	}
+
+	if c.dataStore.GetENIs() >= c.maxENI-c.unmanagedENI {
+		return errors.Errorf("no ENI in subnet %s can take more IP addresses, and the instance ENI limit of %d is reached",
+			subnet, c.maxENI)
+	}
+	var securityGroups []*string
+	var eniCfg *v1alpha1.ENIConfigSpec
+	if c.useCustomNetworking {
+		var err error
+		eniCfg, err = c.eniConfig.MyENIConfig()
+		if err != nil {
+			return errors.Wrap(err, "failed to get pod ENI config")
+		}
+		for _, sgID := range eniCfg.SecurityGroups {
+			securityGroups = append(securityGroups, aws.String(sgID))
+		}
+	}
+	log.Infof("Allocating an ENI in subnet %s", subnet)
+	return c.allocateENI(eniCfg, securityGroups, subnet, c.subnetHintIPsToAllocate(0))
+}
+
+// subnetHintIPsToAllocate returns how many IPs to add to an ENI in a requested subnet that has numIPs already. It
+// is WARM_IP_TARGET if it is set, or else enough to fill the ENI, within NODE_IP_QUOTA.
+func (c *IPAMContext) subnetHintIPsToAllocate(numIPs int) int {
+	ipsToAllocate := c.maxIPsPerENI - numIPs
+	if c.warmIPTarget > 0 {
+		ipsToAllocate = min(ipsToAllocate, c.warmIPTarget)
+	}
+	return max(c.capToNodeIPQuota(ipsToAllocate), 1)
+}
+
+func getPodSubnetAllowlist() []string {
+	var subnets []string
+	for _, subnet := range strings.Split(os.Getenv(envPodSubnetAllowlist), ",") {
+		if subnet = strings.TrimSpace(subnet); subnet != "" {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
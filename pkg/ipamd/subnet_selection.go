@@ -0,0 +1,117 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
+)
+
+const (
+	// This environment variable is used to specify in which subnet a new ENI is created:
+	//   "default": the subnet of the ENIConfig, or of the primary ENI, and the ALTERNATE_SUBNETS when it is full
+	//   "most-free": the one of these subnets with the most free IP addresses reported by EC2
+	envSubnetSelection      = "SUBNET_SELECTION"
+	subnetSelectionDefault  = "default"
+	subnetSelectionMostFree = "most-free"
+)
+
+// SubnetCandidate is a subnet considered for a new ENI and its free IP addresses
+type SubnetCandidate struct {
+	Subnet       string
+	AvailableIPs int
+	// Error is why the free IP addresses of the subnet could not be counted
+	Error string `json:",omitempty"`
+}
+
+// SubnetSelectionStats describes the last subnet selection for a new ENI, for introspection
+type SubnetSelectionStats struct {
+	Mode string
+	// Selected is the subnet the last ENI was created in, chosen among Candidates at LastSelection
+	Selected      string `json:",omitempty"`
+	LastSelection time.Time
+	Candidates    []SubnetCandidate
+}
+
+// subnetSelectionState keeps the last subnet selection
+type subnetSelectionState struct {
+	stats SubnetSelectionStats
+	lock  sync.RWMutex
+}
+
+// selectSubnet returns the subnet to create a new ENI in. In "most-free" mode, it is the one of subnet, or the subnet
+// of the primary ENI if it is empty, and the ALTERNATE_SUBNETS in the zone of the node with the most free IP
+// addresses. Subnets whose free IP addresses cannot be counted are skipped, and subnet is returned when none can.
+func (c *IPAMContext) selectSubnet(subnet string) string {
+	if c.subnetSelection != subnetSelectionMostFree {
+		return subnet
+	}
+	base := subnet
+	if base == "" {
+		base = c.primarySubnet()
+	}
+	candidates := []string{base}
+	for _, alternate := range c.usableAlternateSubnets() {
+		if alternate != base {
+			candidates = append(candidates, alternate)
+		}
+	}
+
+	selected, most := base, -1
+	stats := SubnetSelectionStats{Mode: c.subnetSelection, LastSelection: time.Now()}
+	for _, candidate := range candidates {
+		available, err := c.awsClient.GetSubnetAvailableIPCount(c.poolManagerContext(), candidate)
+		considered := SubnetCandidate{Subnet: candidate, AvailableIPs: available}
+		if err != nil {
+			log.Warnf("Failed to count the free IP addresses of subnet %s, skipping it: %v", candidate, err)
+			considered.Error = err.Error()
+		} else if available > most {
+			selected, most = candidate, available
+		}
+		stats.Candidates = append(stats.Candidates, considered)
+	}
+	stats.Selected = selected
+	if most < 0 {
+		log.Warnf("Failed to count the free IP addresses of subnets %v, creating the ENI in subnet %s", candidates, base)
+	} else {
+		log.Infof("Creating the ENI in subnet %s with %d free IP addresses, out of subnets %v", selected, most, candidates)
+	}
+
+	c.subnetSelectionStats.lock.Lock()
+	c.subnetSelectionStats.stats = stats
+	c.subnetSelectionStats.lock.Unlock()
+	if selected == base {
+		// An empty subnet keeps creating the ENI like the primary ENI
+		return subnet
+	}
+	return selected
+}
+
+// GetSubnetSelectionStats returns the last subnet selection for a new ENI
+func (c *IPAMContext) GetSubnetSelectionStats() SubnetSelectionStats {
+	c.subnetSelectionStats.lock.RLock()
+	defer c.subnetSelectionStats.lock.RUnlock()
+	stats := c.subnetSelectionStats.stats
+	stats.Mode = c.subnetSelection
+	return stats
+}
+
+func getSubnetSelection() string {
+	return envsetting.Choice(envSubnetSelection, subnetSelectionDefault,
+		subnetSelectionDefault, subnetSelectionMostFree)
+}
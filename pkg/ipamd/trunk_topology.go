@@ -0,0 +1,140 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+// TrunkBranch is a branch ENI of the trunk ENI and the pod it is assigned to, for introspection
+type TrunkBranch struct {
+	ENIID    string
+	VlanID   int
+	IP       string `json:",omitempty"`
+	SubnetID string `json:",omitempty"`
+	Pod      string `json:",omitempty"`
+	// Orphaned is true for a branch ENI of the trunk that no pod of the node lists in its vpc.amazonaws.com/pod-eni
+	// annotation, which may have leaked
+	Orphaned bool
+	// Unassociated is true for a branch ENI a pod lists that is not associated with the trunk ENI in EC2
+	Unassociated bool
+}
+
+// TrunkTopology describes the trunk ENI of the instance and its branch ENIs, used by the pods with security groups,
+// for introspection
+type TrunkTopology struct {
+	// TrunkENI is empty when no trunk ENI is attached to the instance
+	TrunkENI     string `json:",omitempty"`
+	DeviceNumber int    `json:",omitempty"`
+	// Branches are those found by the branch ENI check at LastCheck, sorted by VLAN ID, Orphaned is the number of
+	// them without a pod
+	Branches  []TrunkBranch
+	Orphaned  int
+	LastCheck time.Time
+}
+
+// trunkENI returns the trunk ENI among the attached ENIs, if any
+func trunkENI(enis []awsutils.ENIMetadata) (awsutils.ENIMetadata, bool) {
+	for _, eni := range enis {
+		if eni.InterfaceType == awsutils.InterfaceTypeTrunk {
+			return eni, true
+		}
+	}
+	return awsutils.ENIMetadata{}, false
+}
+
+// GetTrunkTopology returns the trunk ENI attached to the instance at the last reconcile, and its branch ENIs with
+// their VLAN IDs and the pod each is assigned to, as found by the last branch ENI check
+func (c *IPAMContext) GetTrunkTopology() (TrunkTopology, error) {
+	topology := TrunkTopology{Branches: []TrunkBranch{}}
+	enis, err := c.getAttachedENIs()
+	if err != nil {
+		return topology, err
+	}
+	trunk, ok := trunkENI(enis)
+	if !ok {
+		return topology, nil
+	}
+	topology.TrunkENI = trunk.ENIID
+	topology.DeviceNumber = trunk.DeviceNumber
+
+	c.branchENICleanup.lock.RLock()
+	defer c.branchENICleanup.lock.RUnlock()
+	topology.LastCheck = c.branchENICleanup.lastCheck
+	for _, branch := range c.branchENICleanup.branches {
+		if branch.Orphaned {
+			topology.Orphaned++
+		}
+		topology.Branches = append(topology.Branches, branch)
+	}
+	return topology, nil
+}
+
+// trunkBranches returns the branch ENIs of the trunk ENI, with those listed by the pods of the node, sorted by VLAN
+// ID, and the pods whose vpc.amazonaws.com/pod-eni annotation cannot be parsed, which may list any of those orphaned
+func (c *IPAMContext) trunkBranches(trunkENIID string) ([]TrunkBranch, []string, error) {
+	branches := []TrunkBranch{}
+	branchENIs, err := c.awsClient.GetBranchENIs(c.poolManagerContext(), trunkENIID)
+	if err != nil {
+		return branches, nil, err
+	}
+	// Without the pods, every branch ENI would look orphaned
+	pods, err := c.k8sClient.K8SGetLocalPodIPs()
+	if err != nil {
+		return branches, nil, errors.Wrap(err, "failed to get the pods of the node")
+	}
+	var unknownPods []string
+	podBranches := make(map[string]TrunkBranch)
+	for _, pod := range pods {
+		if pod.BranchENIsUnknown {
+			unknownPods = append(unknownPods, pod.Namespace+"/"+pod.Name)
+		}
+		for _, branch := range pod.BranchENIs {
+			podBranches[branch.ENIID] = TrunkBranch{
+				ENIID:  branch.ENIID,
+				VlanID: branch.VlanID,
+				IP:     branch.PrivateIP,
+				Pod:    pod.Namespace + "/" + pod.Name,
+			}
+		}
+	}
+
+	for _, eni := range branchENIs {
+		branch := TrunkBranch{ENIID: eni.ENIID, VlanID: eni.VlanID, IP: eni.PrivateIP, SubnetID: eni.SubnetID}
+		if podBranch, ok := podBranches[eni.ENIID]; ok {
+			branch.Pod = podBranch.Pod
+			delete(podBranches, eni.ENIID)
+		} else {
+			branch.Orphaned = true
+		}
+		branches = append(branches, branch)
+	}
+	for _, branch := range podBranches {
+		branch.Unassociated = true
+		branches = append(branches, branch)
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		if branches[i].VlanID != branches[j].VlanID {
+			return branches[i].VlanID < branches[j].VlanID
+		}
+		return branches[i].ENIID < branches[j].ENIID
+	})
+	sort.Strings(unknownPods)
+	return branches, unknownPods, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	log "github.com/cihub/seelog"
+)
+
+// WarmTargetClamp is a warm target set higher than the instance can hold, lowered to its capacity, for introspection
+type WarmTargetClamp struct {
+	// Name is the environment variable of the target
+	Name      string
+	Requested int
+	Clamped   int
+}
+
+// clampWarmTargets lowers WARM_IP_TARGET and MINIMUM_IP_TARGET to the number of IPs the ENIs the instance can attach
+// hold, and WARM_ENI_TARGET to the number of these ENIs, so that a misconfigured target does not make the pool try to
+// allocate what it can never get. It must run once the ENI and IP limits of the instance are known.
+func (c *IPAMContext) clampWarmTargets() {
+	maxENIs := max(c.maxENI-c.unmanagedENI, 0)
+	maxIPs := maxENIs * c.maxIPsPerENI
+	c.warmTargetClamps = nil
+	c.warmIPTarget = c.clampWarmTarget(envWarmIPTarget, c.warmIPTarget, maxIPs, "IPs")
+	c.minimumIPTarget = c.clampWarmTarget(envMinimumIPTarget, c.minimumIPTarget, maxIPs, "IPs")
+	c.warmENITarget = c.clampWarmTarget(envWarmENITarget, c.warmENITarget, maxENIs, "ENIs")
+}
+
+// clampWarmTarget returns the target, or the capacity of the instance if the target is higher
+func (c *IPAMContext) clampWarmTarget(name string, target int, capacity int, unit string) int {
+	if target <= capacity {
+		return target
+	}
+	log.Warnf("%s %d is higher than the %d %s the instance can hold, using %d instead", name, target, capacity, unit,
+		capacity)
+	c.warmTargetClamps = append(c.warmTargetClamps, WarmTargetClamp{Name: name, Requested: target, Clamped: capacity})
+	return capacity
+}
@@ -2,6 +2,7 @@
 package k8sapi
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -17,6 +18,7 @@ import (
 	"github.com/operator-framework/operator-sdk/pkg/k8sclient"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
@@ -34,11 +36,31 @@ type controller struct {
 
 const (
 	cniPodName = "aws-node"
+
+	// PodSubnetAnnotation is the pod annotation with the ID of the subnet the pod's IP address must be allocated from
+	PodSubnetAnnotation = "k8s.amazonaws.com/subnet"
+	// PodEgressOnlyAnnotation is the pod annotation that, set to "true", drops the connections opened to the pod
+	PodEgressOnlyAnnotation = "k8s.amazonaws.com/egress-only"
+	// PodIPFamilyAnnotation is the pod annotation with the IP family the pod asks for, "ipv4", "ipv6" or "dual"
+	PodIPFamilyAnnotation = "k8s.amazonaws.com/ip-family"
+	// PodENIAnnotation is the pod annotation the VPC resource controller sets with the branch ENIs of a pod with
+	// security groups
+	PodENIAnnotation = "vpc.amazonaws.com/pod-eni"
+
+	// ScaleDownTaint is the taint the cluster autoscaler sets on a node it is about to delete
+	ScaleDownTaint = "ToBeDeletedByClusterAutoscaler"
+	// NodeShrinkAnnotation is the node annotation that, set to "true", marks the node for scale-down
+	NodeShrinkAnnotation = "k8s.amazonaws.com/shrink-ip-pool"
 )
 
 // K8SAPIs defines interface to use kubelet introspection API
 type K8SAPIs interface {
 	K8SGetLocalPodIPs() ([]*K8SPodInfo, error)
+	K8SGetPodSubnetHint(name string, namespace string) string
+	K8SGetWatchStatus() WatchStatus
+	K8SGetPodInfo(name string, namespace string) *K8SPodInfo
+	K8SGetNodeScaleDownReason() (string, error)
+	K8SRecordPodEvent(name string, namespace string, reason string, message string) error
 }
 
 // K8SPodInfo provides pod info
@@ -52,6 +74,30 @@ type K8SPodInfo struct {
 	// IP is pod's ipv4 address
 	IP  string
 	UID string
+	// SubnetHint is the subnet the pod's IP address must come from, if any
+	SubnetHint string
+	// Priority is the priority of the pod, 0 when it has none
+	Priority int32
+	// Labels are the labels of the pod
+	Labels map[string]string
+	// UseReservedIPs is true if the pod may take one of the IPs reserved for critical pods
+	UseReservedIPs bool
+	// EgressOnly is true if the pod only originates traffic, the connections opened to it are dropped
+	EgressOnly bool
+	// IPFamily is the IP family the pod asks for, empty when it does not ask for any
+	IPFamily string
+	// BranchENIs are the branch ENIs of a pod with security groups. BranchENIsUnknown is true when its
+	// vpc.amazonaws.com/pod-eni annotation cannot be parsed, the branch ENIs it lists are then unknown.
+	BranchENIs        []PodBranchENI
+	BranchENIsUnknown bool
+}
+
+// PodBranchENI is a branch ENI of a pod with security groups, as listed in its vpc.amazonaws.com/pod-eni annotation
+type PodBranchENI struct {
+	ENIID     string `json:"eniId"`
+	IfAddress string `json:"ifAddress"`
+	PrivateIP string `json:"privateIp"`
+	VlanID    int    `json:"vlanId"`
 }
 
 // ErrInformerNotSynced indicates that it has not synced with API server yet
@@ -69,6 +115,7 @@ type Controller struct {
 	kubeClient kubernetes.Interface
 	myNodeName string
 	synced     bool
+	podWatch   WatchTracker
 }
 
 // NewController creates a new DiscoveryController
@@ -134,24 +181,30 @@ func (d *Controller) DiscoverK8SPods(podListWatcher *cache.ListWatch) {
 	// create the workqueue
 	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 
+	// Count the list and watch errors, which the informer only logs
+	podListWatcher = TrackListWatch(podListWatcher, &d.podWatch)
+
 	// Bind the workqueue to a cache with the help of an informer. This way we make sure that
 	// whenever the cache is updated, the pod key is added to the workqueue.
 	// Note that when we finally process the item from the workqueue, we might see a newer version
 	// of the Pod than the version which was responsible for triggering the update.
 	indexer, informer := cache.NewIndexerInformer(podListWatcher, &v1.Pod{}, 0, cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
+			d.podWatch.RecordEvent(obj)
 			key, err := cache.MetaNamespaceKeyFunc(obj)
 			if err == nil {
 				queue.Add(key)
 			}
 		},
 		UpdateFunc: func(old interface{}, new interface{}) {
+			d.podWatch.RecordEvent(new)
 			key, err := cache.MetaNamespaceKeyFunc(new)
 			if err == nil {
 				queue.Add(key)
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
+			d.podWatch.RecordEvent(obj)
 			// IndexerInformer uses a delta queue, therefore for deletes we have to use this
 			// key function.
 			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
@@ -164,6 +217,7 @@ func (d *Controller) DiscoverK8SPods(podListWatcher *cache.ListWatch) {
 	d.controller = newController(queue, indexer, informer)
 
 	// Now let's start the controller
+	d.podWatch.Start()
 	stop := make(chan struct{})
 	defer close(stop)
 	go d.run(1, stop)
@@ -186,7 +240,7 @@ func (d *Controller) K8SGetLocalPodIPs() ([]*K8SPodInfo, error) {
 	defer d.workerPodsLock.Unlock()
 
 	for _, pod := range d.workerPods {
-		log.Infof("K8SGetLocalPodIPs discovered local Pods: %s %s %s %s",
+		log.Debugf("K8SGetLocalPodIPs discovered local Pods: %s %s %s %s",
 			pod.Name, pod.Namespace, pod.IP, pod.UID)
 		localPods = append(localPods, pod)
 	}
@@ -194,6 +248,82 @@ func (d *Controller) K8SGetLocalPodIPs() ([]*K8SPodInfo, error) {
 	return localPods, nil
 }
 
+// K8SGetPodSubnetHint returns the value of the subnet annotation of a local pod, or an empty string if the pod has
+// none or is not known yet
+func (d *Controller) K8SGetPodSubnetHint(name string, namespace string) string {
+	d.workerPodsLock.RLock()
+	defer d.workerPodsLock.RUnlock()
+
+	pod, ok := d.workerPods[namespace+"/"+name]
+	if !ok {
+		return ""
+	}
+	return pod.SubnetHint
+}
+
+// K8SGetPodInfo returns a copy of what is known of a local pod, or nil if it is not known yet
+func (d *Controller) K8SGetPodInfo(name string, namespace string) *K8SPodInfo {
+	d.workerPodsLock.RLock()
+	defer d.workerPodsLock.RUnlock()
+
+	pod, ok := d.workerPods[namespace+"/"+name]
+	if !ok {
+		return nil
+	}
+	podInfo := *pod
+	return &podInfo
+}
+
+// K8SGetNodeScaleDownReason returns why the local node is marked for scale-down, either by the taint of the cluster
+// autoscaler or by the shrink annotation, or an empty string if it is not
+func (d *Controller) K8SGetNodeScaleDownReason() (string, error) {
+	node, err := d.kubeClient.CoreV1().Nodes().Get(d.myNodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get node %s", d.myNodeName)
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == ScaleDownTaint {
+			return "taint " + ScaleDownTaint, nil
+		}
+	}
+	if node.GetAnnotations()[NodeShrinkAnnotation] == "true" {
+		return "annotation " + NodeShrinkAnnotation, nil
+	}
+	return "", nil
+}
+
+// K8SRecordPodEvent creates a Warning event on the pod, reported by aws-node on the local node
+func (d *Controller) K8SRecordPodEvent(name string, namespace string, reason string, message string) error {
+	involved := v1.ObjectReference{Kind: "Pod", Name: name, Namespace: namespace}
+	if podInfo := d.K8SGetPodInfo(name, namespace); podInfo != nil {
+		involved.UID = types.UID(podInfo.UID)
+	}
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: involved,
+		Reason:         reason,
+		Message:        message,
+		Source:         v1.EventSource{Component: cniPodName, Host: d.myNodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           v1.EventTypeWarning,
+	}
+	if _, err := d.kubeClient.CoreV1().Events(namespace).Create(event); err != nil {
+		return errors.Wrapf(err, "failed to create event %s on pod %s/%s", reason, namespace, name)
+	}
+	return nil
+}
+
+// K8SGetWatchStatus returns the health of the pod watch
+func (d *Controller) K8SGetWatchStatus() WatchStatus {
+	return d.podWatch.Status()
+}
+
 // The rest of logic/code are taken from kubernetes/client-go/examples/workqueue
 func newController(queue workqueue.RateLimitingInterface, indexer cache.Indexer, informer cache.Controller) *controller {
 	return &controller{
@@ -258,12 +388,29 @@ func (d *Controller) handlePodUpdate(key string) error {
 
 		log.Tracef("Update for pod %s: %+v, %+v", podName, pod.Status, pod.Spec)
 
+		var priority int32
+		if pod.Spec.Priority != nil {
+			priority = *pod.Spec.Priority
+		}
+
+		branchENIs, err := podBranchENIs(pod)
+		if err != nil {
+			log.Warnf("Invalid %s annotation of pod %s, its branch ENIs are unknown: %v", PodENIAnnotation, podName, err)
+		}
+
 		// Save pod info
 		d.workerPods[key] = &K8SPodInfo{
-			Name:      podName,
-			Namespace: pod.GetNamespace(),
-			IP:        pod.Status.PodIP,
-			UID:       string(pod.GetUID()),
+			Name:              podName,
+			Namespace:         pod.GetNamespace(),
+			IP:                pod.Status.PodIP,
+			UID:               string(pod.GetUID()),
+			SubnetHint:        pod.GetAnnotations()[PodSubnetAnnotation],
+			Priority:          priority,
+			Labels:            pod.GetLabels(),
+			EgressOnly:        pod.GetAnnotations()[PodEgressOnlyAnnotation] == "true",
+			IPFamily:          pod.GetAnnotations()[PodIPFamilyAnnotation],
+			BranchENIs:        branchENIs,
+			BranchENIsUnknown: err != nil,
 		}
 
 		log.Infof("Add/Update for Pod %s on my node, namespace = %s, IP = %s", podName, d.workerPods[key].Namespace, d.workerPods[key].IP)
@@ -320,6 +467,7 @@ func (d *Controller) run(threadiness int, stopCh chan struct{}) {
 
 	log.Info("Synced successfully with APIServer")
 	d.synced = true
+	d.podWatch.SetSynced(true)
 
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(d.runWorker, time.Second, stopCh)
@@ -333,3 +481,16 @@ func (d *Controller) runWorker() {
 	for d.processNextItem() {
 	}
 }
+
+// podBranchENIs returns the branch ENIs of the vpc.amazonaws.com/pod-eni annotation of the pod, if it has one
+func podBranchENIs(pod *v1.Pod) ([]PodBranchENI, error) {
+	annotation, ok := pod.GetAnnotations()[PodENIAnnotation]
+	if !ok || annotation == "" {
+		return nil, nil
+	}
+	var branchENIs []PodBranchENI
+	if err := json.Unmarshal([]byte(annotation), &branchENIs); err != nil {
+		return nil, err
+	}
+	return branchENIs, nil
+}
@@ -59,3 +59,64 @@ func (m *MockK8SAPIs) K8SGetLocalPodIPs() ([]*k8sapi.K8SPodInfo, error) {
 func (mr *MockK8SAPIsMockRecorder) K8SGetLocalPodIPs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "K8SGetLocalPodIPs", reflect.TypeOf((*MockK8SAPIs)(nil).K8SGetLocalPodIPs))
 }
+
+// K8SGetNodeScaleDownReason mocks base method
+func (m *MockK8SAPIs) K8SGetNodeScaleDownReason() (string, error) {
+	ret := m.ctrl.Call(m, "K8SGetNodeScaleDownReason")
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// K8SGetNodeScaleDownReason indicates an expected call of K8SGetNodeScaleDownReason
+func (mr *MockK8SAPIsMockRecorder) K8SGetNodeScaleDownReason() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "K8SGetNodeScaleDownReason", reflect.TypeOf((*MockK8SAPIs)(nil).K8SGetNodeScaleDownReason))
+}
+
+// K8SGetPodInfo mocks base method
+func (m *MockK8SAPIs) K8SGetPodInfo(arg0, arg1 string) *k8sapi.K8SPodInfo {
+	ret := m.ctrl.Call(m, "K8SGetPodInfo", arg0, arg1)
+	ret0, _ := ret[0].(*k8sapi.K8SPodInfo)
+	return ret0
+}
+
+// K8SGetPodInfo indicates an expected call of K8SGetPodInfo
+func (mr *MockK8SAPIsMockRecorder) K8SGetPodInfo(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "K8SGetPodInfo", reflect.TypeOf((*MockK8SAPIs)(nil).K8SGetPodInfo), arg0, arg1)
+}
+
+// K8SGetPodSubnetHint mocks base method
+func (m *MockK8SAPIs) K8SGetPodSubnetHint(arg0, arg1 string) string {
+	ret := m.ctrl.Call(m, "K8SGetPodSubnetHint", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// K8SGetPodSubnetHint indicates an expected call of K8SGetPodSubnetHint
+func (mr *MockK8SAPIsMockRecorder) K8SGetPodSubnetHint(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "K8SGetPodSubnetHint", reflect.TypeOf((*MockK8SAPIs)(nil).K8SGetPodSubnetHint), arg0, arg1)
+}
+
+// K8SGetWatchStatus mocks base method
+func (m *MockK8SAPIs) K8SGetWatchStatus() k8sapi.WatchStatus {
+	ret := m.ctrl.Call(m, "K8SGetWatchStatus")
+	ret0, _ := ret[0].(k8sapi.WatchStatus)
+	return ret0
+}
+
+// K8SGetWatchStatus indicates an expected call of K8SGetWatchStatus
+func (mr *MockK8SAPIsMockRecorder) K8SGetWatchStatus() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "K8SGetWatchStatus", reflect.TypeOf((*MockK8SAPIs)(nil).K8SGetWatchStatus))
+}
+
+// K8SRecordPodEvent mocks base method
+func (m *MockK8SAPIs) K8SRecordPodEvent(arg0, arg1, arg2, arg3 string) error {
+	ret := m.ctrl.Call(m, "K8SRecordPodEvent", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// K8SRecordPodEvent indicates an expected call of K8SRecordPodEvent
+func (mr *MockK8SAPIsMockRecorder) K8SRecordPodEvent(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "K8SRecordPodEvent", reflect.TypeOf((*MockK8SAPIs)(nil).K8SRecordPodEvent), arg0, arg1, arg2, arg3)
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package k8sapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WatchStatus is the health of a watch of the API server, for introspection
+type WatchStatus struct {
+	// Started is true once the watch is started, and Synced once its cache is synced with the API server
+	Started bool
+	Synced  bool
+	// LastResourceVersion is the resource version of the last object processed, at LastEvent
+	LastResourceVersion string
+	LastEvent           time.Time
+	Events              int64
+	// ListErrors and WatchErrors count the failed list and watch calls and the error events of the watches
+	ListErrors    int64
+	WatchErrors   int64
+	LastError     string `json:",omitempty"`
+	LastErrorTime time.Time
+}
+
+// WatchTracker keeps the WatchStatus of a watch
+type WatchTracker struct {
+	status WatchStatus
+	lock   sync.RWMutex
+}
+
+// Start records that the watch is started
+func (t *WatchTracker) Start() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.Started = true
+}
+
+// SetSynced records whether the cache of the watch is synced
+func (t *WatchTracker) SetSynced(synced bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.Synced = synced
+}
+
+// RecordEvent records that obj was processed
+func (t *WatchTracker) RecordEvent(obj interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.Events++
+	t.status.LastEvent = time.Now()
+	if accessor, err := meta.Accessor(obj); err == nil {
+		t.status.LastResourceVersion = accessor.GetResourceVersion()
+	}
+}
+
+// RecordListError records a failed list call
+func (t *WatchTracker) RecordListError(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.ListErrors++
+	t.recordErrorUnsafe(err)
+}
+
+// RecordWatchError records a failed watch call or a watch error event
+func (t *WatchTracker) RecordWatchError(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.WatchErrors++
+	t.recordErrorUnsafe(err)
+}
+
+func (t *WatchTracker) recordErrorUnsafe(err error) {
+	t.status.LastError = err.Error()
+	t.status.LastErrorTime = time.Now()
+}
+
+// Status returns the current status of the watch
+func (t *WatchTracker) Status() WatchStatus {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.status
+}
+
+// TrackListWatch wraps a ListWatch so that the tracker counts its errors
+func TrackListWatch(lw *cache.ListWatch, tracker *WatchTracker) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			obj, err := lw.ListFunc(options)
+			if err != nil {
+				tracker.RecordListError(err)
+			}
+			return obj, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := lw.WatchFunc(options)
+			if err != nil {
+				tracker.RecordWatchError(err)
+				return nil, err
+			}
+			return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+				if event.Type == watch.Error {
+					tracker.RecordWatchError(apiStatusError(event.Object))
+				}
+				return event, true
+			}), nil
+		},
+		DisableChunking: lw.DisableChunking,
+	}
+}
+
+// apiStatusError returns the error carried by the object of a watch error event
+func apiStatusError(obj runtime.Object) error {
+	if status, ok := obj.(*metav1.Status); ok {
+		return errors.Errorf("watch error event: %s", status.Message)
+	}
+	return errors.New("watch error event")
+}
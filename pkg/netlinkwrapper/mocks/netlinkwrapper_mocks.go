@@ -84,6 +84,19 @@ func (mr *MockNetLinkMockRecorder) AddrList(arg0, arg1 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddrList", reflect.TypeOf((*MockNetLink)(nil).AddrList), arg0, arg1)
 }
 
+// ConntrackDeleteFilter mocks base method
+func (m *MockNetLink) ConntrackDeleteFilter(arg0 netlink.ConntrackTableType, arg1 netlink.InetFamily, arg2 netlink.CustomConntrackFilter) (uint, error) {
+	ret := m.ctrl.Call(m, "ConntrackDeleteFilter", arg0, arg1, arg2)
+	ret0, _ := ret[0].(uint)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConntrackDeleteFilter indicates an expected call of ConntrackDeleteFilter
+func (mr *MockNetLinkMockRecorder) ConntrackDeleteFilter(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConntrackDeleteFilter", reflect.TypeOf((*MockNetLink)(nil).ConntrackDeleteFilter), arg0, arg1, arg2)
+}
+
 // LinkAdd mocks base method
 func (m *MockNetLink) LinkAdd(arg0 netlink.Link) error {
 	ret := m.ctrl.Call(m, "LinkAdd", arg0)
@@ -256,6 +269,19 @@ func (mr *MockNetLinkMockRecorder) RouteList(arg0, arg1 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RouteList", reflect.TypeOf((*MockNetLink)(nil).RouteList), arg0, arg1)
 }
 
+// RouteListFiltered mocks base method
+func (m *MockNetLink) RouteListFiltered(arg0 int, arg1 *netlink.Route, arg2 uint64) ([]netlink.Route, error) {
+	ret := m.ctrl.Call(m, "RouteListFiltered", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]netlink.Route)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RouteListFiltered indicates an expected call of RouteListFiltered
+func (mr *MockNetLinkMockRecorder) RouteListFiltered(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RouteListFiltered", reflect.TypeOf((*MockNetLink)(nil).RouteListFiltered), arg0, arg1, arg2)
+}
+
 // RouteReplace mocks base method
 func (m *MockNetLink) RouteReplace(arg0 *netlink.Route) error {
 	ret := m.ctrl.Call(m, "RouteReplace", arg0)
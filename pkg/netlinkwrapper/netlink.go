@@ -43,6 +43,8 @@ type NetLink interface {
 	LinkSetDown(link netlink.Link) error
 	// RouteList gets a list of routes in the system.
 	RouteList(link netlink.Link, family int) ([]netlink.Route, error)
+	// RouteListFiltered gets a list of routes in the system matching the filter, e.g. `ip route show table $table`
+	RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error)
 	// RouteAdd will add a route to the route table
 	RouteAdd(route *netlink.Route) error
 	// RouteReplace will replace the route in the route table
@@ -63,6 +65,10 @@ type NetLink interface {
 	RuleList(family int) ([]netlink.Rule, error)
 	// LinkSetMTU is equivalent to `ip link set dev $link mtu $mtu`
 	LinkSetMTU(link netlink.Link, mtu int) error
+	// ConntrackDeleteFilter is equivalent to `conntrack -D [table] [family] [filter]`, it returns the number of
+	// entries deleted
+	ConntrackDeleteFilter(table netlink.ConntrackTableType, family netlink.InetFamily,
+		filter netlink.CustomConntrackFilter) (uint, error)
 }
 
 type netLink struct {
@@ -113,6 +119,10 @@ func (*netLink) RouteList(link netlink.Link, family int) ([]netlink.Route, error
 	return netlink.RouteList(link, family)
 }
 
+func (*netLink) RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error) {
+	return netlink.RouteListFiltered(family, filter, filterMask)
+}
+
 func (*netLink) RouteAdd(route *netlink.Route) error {
 	return netlink.RouteAdd(route)
 }
@@ -157,6 +167,11 @@ func (*netLink) LinkSetMTU(link netlink.Link, mtu int) error {
 	return netlink.LinkSetMTU(link, mtu)
 }
 
+func (*netLink) ConntrackDeleteFilter(table netlink.ConntrackTableType, family netlink.InetFamily,
+	filter netlink.CustomConntrackFilter) (uint, error) {
+	return netlink.ConntrackDeleteFilter(table, family, filter)
+}
+
 // IsNotExistsError returns true if the error type is syscall.ESRCH
 // This helps us determine if we should ignore this error as the route
 // that we want to cleanup has been deleted already routing table
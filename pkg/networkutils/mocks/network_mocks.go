@@ -21,6 +21,7 @@ import (
 	net "net"
 	reflect "reflect"
 
+	networkutils "github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	gomock "github.com/golang/mock/gomock"
 	netlink "github.com/vishvananda/netlink"
 )
@@ -48,6 +49,31 @@ func (m *MockNetworkAPIs) EXPECT() *MockNetworkAPIsMockRecorder {
 	return m.recorder
 }
 
+// AddLinkIPv4Address mocks base method
+func (m *MockNetworkAPIs) AddLinkIPv4Address(arg0, arg1, arg2 string) error {
+	ret := m.ctrl.Call(m, "AddLinkIPv4Address", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddLinkIPv4Address indicates an expected call of AddLinkIPv4Address
+func (mr *MockNetworkAPIsMockRecorder) AddLinkIPv4Address(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLinkIPv4Address", reflect.TypeOf((*MockNetworkAPIs)(nil).AddLinkIPv4Address), arg0, arg1, arg2)
+}
+
+// DeleteConntrackEntries mocks base method
+func (m *MockNetworkAPIs) DeleteConntrackEntries(arg0 string) (uint, error) {
+	ret := m.ctrl.Call(m, "DeleteConntrackEntries", arg0)
+	ret0, _ := ret[0].(uint)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteConntrackEntries indicates an expected call of DeleteConntrackEntries
+func (mr *MockNetworkAPIsMockRecorder) DeleteConntrackEntries(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteConntrackEntries", reflect.TypeOf((*MockNetworkAPIs)(nil).DeleteConntrackEntries), arg0)
+}
+
 // DeleteRuleListBySrc mocks base method
 func (m *MockNetworkAPIs) DeleteRuleListBySrc(arg0 net.IPNet) error {
 	ret := m.ctrl.Call(m, "DeleteRuleListBySrc", arg0)
@@ -60,6 +86,32 @@ func (mr *MockNetworkAPIsMockRecorder) DeleteRuleListBySrc(arg0 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRuleListBySrc", reflect.TypeOf((*MockNetworkAPIs)(nil).DeleteRuleListBySrc), arg0)
 }
 
+// GetENIRouteTable mocks base method
+func (m *MockNetworkAPIs) GetENIRouteTable(arg0 string, arg1 int, arg2 string) (networkutils.ENIRouteTable, error) {
+	ret := m.ctrl.Call(m, "GetENIRouteTable", arg0, arg1, arg2)
+	ret0, _ := ret[0].(networkutils.ENIRouteTable)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetENIRouteTable indicates an expected call of GetENIRouteTable
+func (mr *MockNetworkAPIsMockRecorder) GetENIRouteTable(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetENIRouteTable", reflect.TypeOf((*MockNetworkAPIs)(nil).GetENIRouteTable), arg0, arg1, arg2)
+}
+
+// GetEgressOnlyIPs mocks base method
+func (m *MockNetworkAPIs) GetEgressOnlyIPs() ([]string, error) {
+	ret := m.ctrl.Call(m, "GetEgressOnlyIPs")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEgressOnlyIPs indicates an expected call of GetEgressOnlyIPs
+func (mr *MockNetworkAPIsMockRecorder) GetEgressOnlyIPs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEgressOnlyIPs", reflect.TypeOf((*MockNetworkAPIs)(nil).GetEgressOnlyIPs))
+}
+
 // GetExcludeSNATCIDRs mocks base method
 func (m *MockNetworkAPIs) GetExcludeSNATCIDRs() []string {
 	ret := m.ctrl.Call(m, "GetExcludeSNATCIDRs")
@@ -72,6 +124,43 @@ func (mr *MockNetworkAPIsMockRecorder) GetExcludeSNATCIDRs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExcludeSNATCIDRs", reflect.TypeOf((*MockNetworkAPIs)(nil).GetExcludeSNATCIDRs))
 }
 
+// GetHostVethIPv4Addresses mocks base method
+func (m *MockNetworkAPIs) GetHostVethIPv4Addresses(arg0 string) ([]string, error) {
+	ret := m.ctrl.Call(m, "GetHostVethIPv4Addresses", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHostVethIPv4Addresses indicates an expected call of GetHostVethIPv4Addresses
+func (mr *MockNetworkAPIsMockRecorder) GetHostVethIPv4Addresses(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHostVethIPv4Addresses", reflect.TypeOf((*MockNetworkAPIs)(nil).GetHostVethIPv4Addresses), arg0)
+}
+
+// GetIptablesBackend mocks base method
+func (m *MockNetworkAPIs) GetIptablesBackend() networkutils.IptablesBackend {
+	ret := m.ctrl.Call(m, "GetIptablesBackend")
+	ret0, _ := ret[0].(networkutils.IptablesBackend)
+	return ret0
+}
+
+// GetIptablesBackend indicates an expected call of GetIptablesBackend
+func (mr *MockNetworkAPIsMockRecorder) GetIptablesBackend() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIptablesBackend", reflect.TypeOf((*MockNetworkAPIs)(nil).GetIptablesBackend))
+}
+
+// GetPodRoutingPolicy mocks base method
+func (m *MockNetworkAPIs) GetPodRoutingPolicy(arg0 []string) networkutils.PodRoutingPolicy {
+	ret := m.ctrl.Call(m, "GetPodRoutingPolicy", arg0)
+	ret0, _ := ret[0].(networkutils.PodRoutingPolicy)
+	return ret0
+}
+
+// GetPodRoutingPolicy indicates an expected call of GetPodRoutingPolicy
+func (mr *MockNetworkAPIsMockRecorder) GetPodRoutingPolicy(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPodRoutingPolicy", reflect.TypeOf((*MockNetworkAPIs)(nil).GetPodRoutingPolicy), arg0)
+}
+
 // GetRuleList mocks base method
 func (m *MockNetworkAPIs) GetRuleList() ([]netlink.Rule, error) {
 	ret := m.ctrl.Call(m, "GetRuleList")
@@ -98,6 +187,32 @@ func (mr *MockNetworkAPIsMockRecorder) GetRuleListBySrc(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRuleListBySrc", reflect.TypeOf((*MockNetworkAPIs)(nil).GetRuleListBySrc), arg0, arg1)
 }
 
+// HasLinkIPv4Address mocks base method
+func (m *MockNetworkAPIs) HasLinkIPv4Address(arg0, arg1 string) (bool, error) {
+	ret := m.ctrl.Call(m, "HasLinkIPv4Address", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HasLinkIPv4Address indicates an expected call of HasLinkIPv4Address
+func (mr *MockNetworkAPIsMockRecorder) HasLinkIPv4Address(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasLinkIPv4Address", reflect.TypeOf((*MockNetworkAPIs)(nil).HasLinkIPv4Address), arg0, arg1)
+}
+
+// HasPodHostRoute mocks base method
+func (m *MockNetworkAPIs) HasPodHostRoute(arg0 string) (bool, error) {
+	ret := m.ctrl.Call(m, "HasPodHostRoute", arg0)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HasPodHostRoute indicates an expected call of HasPodHostRoute
+func (mr *MockNetworkAPIsMockRecorder) HasPodHostRoute(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasPodHostRoute", reflect.TypeOf((*MockNetworkAPIs)(nil).HasPodHostRoute), arg0)
+}
+
 // SetupENINetwork mocks base method
 func (m *MockNetworkAPIs) SetupENINetwork(arg0, arg1 string, arg2 int, arg3 string) error {
 	ret := m.ctrl.Call(m, "SetupENINetwork", arg0, arg1, arg2, arg3)
@@ -122,6 +237,30 @@ func (mr *MockNetworkAPIsMockRecorder) SetupHostNetwork(arg0, arg1, arg2, arg3 i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupHostNetwork", reflect.TypeOf((*MockNetworkAPIs)(nil).SetupHostNetwork), arg0, arg1, arg2, arg3)
 }
 
+// SetupPodEgressOnly mocks base method
+func (m *MockNetworkAPIs) SetupPodEgressOnly(arg0 string) error {
+	ret := m.ctrl.Call(m, "SetupPodEgressOnly", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetupPodEgressOnly indicates an expected call of SetupPodEgressOnly
+func (mr *MockNetworkAPIsMockRecorder) SetupPodEgressOnly(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupPodEgressOnly", reflect.TypeOf((*MockNetworkAPIs)(nil).SetupPodEgressOnly), arg0)
+}
+
+// TeardownPodEgressOnly mocks base method
+func (m *MockNetworkAPIs) TeardownPodEgressOnly(arg0 string) error {
+	ret := m.ctrl.Call(m, "TeardownPodEgressOnly", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TeardownPodEgressOnly indicates an expected call of TeardownPodEgressOnly
+func (mr *MockNetworkAPIsMockRecorder) TeardownPodEgressOnly(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TeardownPodEgressOnly", reflect.TypeOf((*MockNetworkAPIs)(nil).TeardownPodEgressOnly), arg0)
+}
+
 // UpdateRuleListBySrc mocks base method
 func (m *MockNetworkAPIs) UpdateRuleListBySrc(arg0 []netlink.Rule, arg1 net.IPNet, arg2 []string, arg3 bool) error {
 	ret := m.ctrl.Call(m, "UpdateRuleListBySrc", arg0, arg1, arg2, arg3)
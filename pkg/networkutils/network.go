@@ -14,19 +14,23 @@
 package networkutils
 
 import (
+	"crypto/sha1"
 	"encoding/binary"
 	"encoding/csv"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"math"
 	"net"
 	"os"
+	"os/exec"
 	"reflect"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/envsetting"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/retry"
 
 	"github.com/pkg/errors"
@@ -89,6 +93,19 @@ const (
 	// - Calico uses 0xffff0000.
 	defaultConnmark = 0x80
 
+	// envPodEgressMark is the name of the environment variable that sets a mark on the packets that pods send, so that
+	// the rules of a host firewall can match pod traffic. It must not overlap the connmark above. When it is not set
+	// or set to 0, pod packets are not marked, and the marking rule is removed if it was installed.
+	envPodEgressMark = "AWS_VPC_K8S_CNI_POD_EGRESS_MARK"
+
+	// podEgressMarkComment is the comment of the rule marking pod packets, used to find the rules to clean up
+	podEgressMarkComment = "AWS, pod egress mark"
+
+	// egressOnlyChain is the filter chain dropping the connections opened to egress-only pods, jumped to by the rule
+	// with egressOnlyComment at the top of the FORWARD chain
+	egressOnlyChain   = "AWS-EGRESS-ONLY"
+	egressOnlyComment = "AWS, egress-only pods"
+
 	// envMTU gives a way to configure the MTU size for new ENIs attached. Range is from 576 to 9001.
 	envMTU = "AWS_VPC_ENI_MTU"
 
@@ -119,6 +136,58 @@ type NetworkAPIs interface {
 	GetRuleListBySrc(ruleList []netlink.Rule, src net.IPNet) ([]netlink.Rule, error)
 	UpdateRuleListBySrc(ruleList []netlink.Rule, src net.IPNet, toCIDRs []string, toFlag bool) error
 	DeleteRuleListBySrc(src net.IPNet) error
+	GetENIRouteTable(eniMAC string, eniTable int, eniSubnetCIDR string) (ENIRouteTable, error)
+	GetHostVethIPv4Addresses(hostVethName string) ([]string, error)
+	// HasPodHostRoute returns whether the main route table still routes the pod IP address to a host side veth
+	HasPodHostRoute(ip string) (bool, error)
+	// HasLinkIPv4Address returns whether the IP address is assigned to the interface with the MAC address
+	HasLinkIPv4Address(mac string, ip string) (bool, error)
+	// AddLinkIPv4Address assigns the IP address, with the prefix length of subnetCIDR, to the interface with the MAC address
+	AddLinkIPv4Address(mac string, ip string, subnetCIDR string) error
+	// GetPodRoutingPolicy returns how the traffic of the pods is routed and SNAT'd, given the CIDRs of the VPC
+	GetPodRoutingPolicy(vpcCIDRs []string) PodRoutingPolicy
+	// DeleteConntrackEntries deletes the connection tracking entries of the connections from and to the IP address,
+	// and returns how many were deleted
+	DeleteConntrackEntries(ip string) (uint, error)
+	// SetupPodEgressOnly drops the packets forwarded to the IP address that are not part of a connection it opened
+	SetupPodEgressOnly(ip string) error
+	// TeardownPodEgressOnly removes the rule installed by SetupPodEgressOnly for the IP address, if any
+	TeardownPodEgressOnly(ip string) error
+	// GetEgressOnlyIPs returns the IP addresses that SetupPodEgressOnly installed a rule for
+	GetEgressOnlyIPs() ([]string, error)
+	// GetIptablesBackend returns the iptables backend the rules are programmed with, and whether the other backend
+	// also has rules
+	GetIptablesBackend() IptablesBackend
+}
+
+const (
+	// IptablesModeLegacy and IptablesModeNFTables are the backends of iptables, named by "iptables --version" since
+	// iptables 1.8
+	IptablesModeLegacy   = "legacy"
+	IptablesModeNFTables = "nf_tables"
+)
+
+// IptablesBackend describes the iptables backend the rules of ipamd are programmed with, for introspection
+type IptablesBackend struct {
+	// Version is the output of "iptables --version", and Mode the backend it uses
+	Version string `json:",omitempty"`
+	Mode    string `json:",omitempty"`
+	// Fallback is true when the version names no backend, the iptables versions before 1.8 only have the legacy one
+	Fallback bool
+	// OtherModeRules is the number of rules of the other backend. When it has rules, another component, like
+	// kube-proxy, uses it, and only the rules of one of the backends are applied: Mismatch is then true.
+	OtherModeRules int
+	Mismatch       bool
+	Error          string `json:",omitempty"`
+}
+
+// ENIRouteTable contains the routes found in the route table of an ENI
+type ENIRouteTable struct {
+	Table int
+	// Gateway is the gateway of the default route of the table, empty when the table has no default route
+	Gateway       string `json:",omitempty"`
+	Routes        []string
+	MissingRoutes []string
 }
 
 type linuxNetwork struct {
@@ -133,7 +202,11 @@ type linuxNetwork struct {
 	ns          nswrapper.NS
 	newIptables func() (iptablesIface, error)
 	mainENIMark uint32
-	openFile    func(name string, flag int, perm os.FileMode) (stringWriteCloser, error)
+	// podEgressMark is set on the packets pods send, 0 if disabled
+	podEgressMark uint32
+	openFile      func(name string, flag int, perm os.FileMode) (stringWriteCloser, error)
+	// runCommand runs a command and returns its output
+	runCommand func(name string, args ...string) (string, error)
 }
 
 type iptablesIface interface {
@@ -165,6 +238,7 @@ func New() NetworkAPIs {
 		typeOfSNAT:             typeOfSNAT(),
 		nodePortSupportEnabled: nodePortSupportEnabled(),
 		mainENIMark:            getConnmark(),
+		podEgressMark:          getPodEgressMark(),
 		mtu:                    GetEthernetMTU(""),
 
 		netLink: netlinkwrapper.NewNetLink(),
@@ -176,6 +250,10 @@ func New() NetworkAPIs {
 		openFile: func(name string, flag int, perm os.FileMode) (stringWriteCloser, error) {
 			return os.OpenFile(name, flag, perm)
 		},
+		runCommand: func(name string, args ...string) (string, error) {
+			out, err := exec.Command(name, args...).CombinedOutput()
+			return string(out), err
+		},
 	}
 }
 
@@ -419,6 +497,12 @@ func (n *linuxNetwork) SetupHostNetwork(vpcCIDR *net.IPNet, vpcCIDRs []*string,
 		},
 	})
 
+	podEgressMarkRules, err := n.podEgressMarkRules(ipt)
+	if err != nil {
+		return err
+	}
+	iptableRules = append(iptableRules, podEgressMarkRules...)
+
 	// remove pre-1.3 AWS SNAT rules
 	iptableRules = append(iptableRules, iptablesRule{
 		name:        fmt.Sprintf("rule for primary address %s", primaryAddr),
@@ -493,6 +577,54 @@ func listCurrentSNATRules(ipt iptablesIface) ([]iptablesRule, error) {
 	return toClear, nil
 }
 
+// podEgressMarkRules returns the rule marking the packets pods send, and the marking rules with another mark to clean
+// up, for instance after the mark was changed or disabled
+func (n *linuxNetwork) podEgressMarkRules(ipt iptablesIface) ([]iptablesRule, error) {
+	var desired []string
+	var rules []iptablesRule
+	if n.podEgressMark != 0 {
+		desired = []string{
+			"-i", "eni+", "-m", "comment", "--comment", podEgressMarkComment,
+			"-j", "MARK", "--set-xmark", fmt.Sprintf("%#x/%#x", n.podEgressMark, n.podEgressMark),
+		}
+		rules = append(rules, iptablesRule{
+			name:        "mark for pod egress",
+			shouldExist: true,
+			table:       "mangle",
+			chain:       "PREROUTING",
+			rule:        desired,
+		})
+	}
+
+	existing, err := ipt.List("mangle", "PREROUTING")
+	if err != nil {
+		return nil, errors.Wrap(err, "host network setup: failed to list iptables mangle chain PREROUTING")
+	}
+	for _, rule := range existing {
+		if !strings.Contains(rule, podEgressMarkComment) {
+			continue
+		}
+		r := csv.NewReader(strings.NewReader(rule))
+		r.Comma = ' '
+		ruleSpec, err := r.Read()
+		if err != nil {
+			return nil, errors.Wrapf(err, "host network setup: failed to parse iptables mangle chain PREROUTING rule %s", rule)
+		}
+		if len(ruleSpec) < 2 || reflect.DeepEqual(ruleSpec[2:], desired) {
+			continue
+		}
+		log.Debugf("host network setup: found stale pod egress mark rule: %v", ruleSpec)
+		rules = append(rules, iptablesRule{
+			name:        "stale mark for pod egress",
+			shouldExist: false,
+			table:       "mangle",
+			chain:       "PREROUTING",
+			rule:        ruleSpec[2:], // drop action and chain name
+		})
+	}
+	return rules, nil
+}
+
 func containChainExistErr(err error) bool {
 	return strings.Contains(err.Error(), "Chain already exists")
 }
@@ -529,20 +661,19 @@ func containsNoSuchRule(err error) bool {
 	return false
 }
 
-// GetConfigForDebug returns the active values of the configuration env vars (for debugging purposes).
-func GetConfigForDebug() map[string]interface{} {
-	return map[string]interface{}{
-		envExternalSNAT:     useExternalSNAT(),
-		envExcludeSNATCIDRs: getExcludeSNATCIDRs(),
-		envNodePortSupport:  nodePortSupportEnabled(),
-		envConnmark:         getConnmark(),
-		envRandomizeSNAT:    typeOfSNAT(),
+// GetConfigForDebug returns the active values of the configuration env vars, with their source and default values
+// (for debugging purposes).
+func GetConfigForDebug() map[string]envsetting.Setting {
+	return map[string]envsetting.Setting{
+		envExternalSNAT:     envsetting.New(envExternalSNAT, useExternalSNAT(), false),
+		envExcludeSNATCIDRs: envsetting.New(envExcludeSNATCIDRs, getExcludeSNATCIDRs(), []string{}),
+		envNodePortSupport:  envsetting.New(envNodePortSupport, nodePortSupportEnabled(), true),
+		envConnmark:         envsetting.New(envConnmark, getConnmark(), uint32(defaultConnmark)),
+		envPodEgressMark:    envsetting.New(envPodEgressMark, getPodEgressMark(), uint32(0)),
+		envRandomizeSNAT:    envsetting.New(envRandomizeSNAT, typeOfSNAT(), randomHashSNAT),
 	}
 }
 
-// UseExternalSNAT returns whether SNAT of secondary ENI IPs should be handled with an external
-// NAT gateway rather than on node. Failure to parse the setting will result in a log and the
-// setting will be disabled.
 func (n *linuxNetwork) UseExternalSNAT() bool {
 	return useExternalSNAT()
 }
@@ -637,6 +768,28 @@ func getConnmark() uint32 {
 	return defaultConnmark
 }
 
+// getPodEgressMark returns the mark to set on pod packets, or 0 if it is not set, is invalid, or overlaps the connmark
+func getPodEgressMark() uint32 {
+	podEgressMark := os.Getenv(envPodEgressMark)
+	if podEgressMark == "" {
+		return 0
+	}
+	mark, err := strconv.ParseInt(podEgressMark, 0, 64)
+	if err != nil {
+		log.Errorf("Failed to parse %s; pod packets will not be marked: %v", envPodEgressMark, err)
+		return 0
+	}
+	if mark > math.MaxUint32 || mark < 0 {
+		log.Errorf("%s out of range; pod packets will not be marked", envPodEgressMark)
+		return 0
+	}
+	if connmark := getConnmark(); uint32(mark)&connmark != 0 {
+		log.Errorf("%s %#x overlaps %s %#x; pod packets will not be marked", envPodEgressMark, mark, envConnmark, connmark)
+		return 0
+	}
+	return uint32(mark)
+}
+
 // LinkByMac returns linux netlink based on interface MAC
 func LinkByMac(mac string, netLink netlinkwrapper.NetLink, retryInterval time.Duration) (netlink.Link, error) {
 	// The adapter might not be immediately available, so we perform retries
@@ -738,23 +891,7 @@ func setupENINetwork(eniIP string, eniMAC string, eniTable int, eniSubnetCIDR st
 	}
 
 	log.Debugf("Setting up ENI's default gateway %v", gw)
-	routes := []netlink.Route{
-		// Add a direct link route for the host's ENI IP only
-		{
-			LinkIndex: deviceNumber,
-			Dst:       &net.IPNet{IP: gw, Mask: net.CIDRMask(32, 32)},
-			Scope:     netlink.SCOPE_LINK,
-			Table:     eniTable,
-		},
-		// Route all other traffic via the host's ENI IP
-		{
-			LinkIndex: deviceNumber,
-			Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
-			Scope:     netlink.SCOPE_UNIVERSE,
-			Gw:        gw,
-			Table:     eniTable,
-		},
-	}
+	routes := eniRoutes(deviceNumber, gw, eniTable)
 	for _, r := range routes {
 		err := netLink.RouteDel(&r)
 		if err != nil && !netlinkwrapper.IsNotExistsError(err) {
@@ -796,6 +933,205 @@ func setupENINetwork(eniIP string, eniMAC string, eniTable int, eniSubnetCIDR st
 	return nil
 }
 
+// eniRoutes returns the routes set up in the route table of a secondary ENI
+func eniRoutes(linkIndex int, gw net.IP, eniTable int) []netlink.Route {
+	return []netlink.Route{
+		// Add a direct link route for the host's ENI IP only
+		{
+			LinkIndex: linkIndex,
+			Dst:       &net.IPNet{IP: gw, Mask: net.CIDRMask(32, 32)},
+			Scope:     netlink.SCOPE_LINK,
+			Table:     eniTable,
+		},
+		// Route all other traffic via the host's ENI IP
+		{
+			LinkIndex: linkIndex,
+			Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+			Scope:     netlink.SCOPE_UNIVERSE,
+			Gw:        gw,
+			Table:     eniTable,
+		},
+	}
+}
+
+// GetENIRouteTable reads the route table of an ENI from the host and reports the expected routes that are missing.
+// The primary ENI (table 0) uses the main route table, where only a default route is expected.
+func (n *linuxNetwork) GetENIRouteTable(eniMAC string, eniTable int, eniSubnetCIDR string) (ENIRouteTable, error) {
+	return getENIRouteTable(eniMAC, eniTable, eniSubnetCIDR, n.netLink)
+}
+
+// GetHostVethIPv4Addresses returns the pod IPs routed to the host side veth of a pod interface
+func (n *linuxNetwork) GetHostVethIPv4Addresses(hostVethName string) ([]string, error) {
+	return getHostVethIPv4Addresses(hostVethName, n.netLink)
+}
+
+func getHostVethIPv4Addresses(hostVethName string, netLink netlinkwrapper.NetLink) ([]string, error) {
+	link, err := netLink.LinkByName(hostVethName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getHostVethIPv4Addresses: failed to find link %s", hostVethName)
+	}
+	routes, err := netLink.RouteList(link, unix.AF_INET)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getHostVethIPv4Addresses: failed to list routes of link %s", hostVethName)
+	}
+	var ips []string
+	for _, r := range routes {
+		if r.Scope != netlink.SCOPE_LINK || r.Dst == nil {
+			continue
+		}
+		if ones, bits := r.Dst.Mask.Size(); ones == 32 && bits == 32 {
+			ips = append(ips, r.Dst.IP.String())
+		}
+	}
+	return ips, nil
+}
+
+// HasPodHostRoute returns whether the main route table still routes the pod IP address to a host side veth
+func (n *linuxNetwork) HasPodHostRoute(ip string) (bool, error) {
+	return hasPodHostRoute(ip, n.netLink)
+}
+
+func hasPodHostRoute(ip string, netLink netlinkwrapper.NetLink) (bool, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() == nil {
+		return false, errors.Errorf("hasPodHostRoute: invalid IPv4 address %s", ip)
+	}
+	dst := &net.IPNet{IP: addr.To4(), Mask: net.CIDRMask(32, 32)}
+	routes, err := netLink.RouteListFiltered(unix.AF_INET, &netlink.Route{Table: mainRoutingTable, Dst: dst},
+		netlink.RT_FILTER_TABLE|netlink.RT_FILTER_DST)
+	if err != nil {
+		return false, errors.Wrapf(err, "hasPodHostRoute: failed to list the routes to %s", ip)
+	}
+	return len(routes) > 0, nil
+}
+
+// HasLinkIPv4Address returns whether the IP address is assigned to the interface with the MAC address
+func (n *linuxNetwork) HasLinkIPv4Address(mac string, ip string) (bool, error) {
+	return hasLinkIPv4Address(mac, ip, n.netLink)
+}
+
+func hasLinkIPv4Address(mac string, ip string, netLink netlinkwrapper.NetLink) (bool, error) {
+	link, err := LinkByMac(mac, netLink, retryLinkByMacInterval)
+	if err != nil {
+		return false, errors.Wrapf(err, "hasLinkIPv4Address: failed to find the link with mac address %s", mac)
+	}
+	addrs, err := netLink.AddrList(link, unix.AF_INET)
+	if err != nil {
+		return false, errors.Wrapf(err, "hasLinkIPv4Address: failed to list addresses of link %s", link.Attrs().Name)
+	}
+	for _, addr := range addrs {
+		if addr.IP.String() == ip {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AddLinkIPv4Address assigns the IP address, with the prefix length of subnetCIDR, to the interface with the MAC address
+func (n *linuxNetwork) AddLinkIPv4Address(mac string, ip string, subnetCIDR string) error {
+	return addLinkIPv4Address(mac, ip, subnetCIDR, n.netLink)
+}
+
+func addLinkIPv4Address(mac string, ip string, subnetCIDR string, netLink netlinkwrapper.NetLink) error {
+	_, subnet, err := net.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return errors.Wrapf(err, "addLinkIPv4Address: invalid subnet CIDR %s", subnetCIDR)
+	}
+	addrIP := net.ParseIP(ip)
+	if addrIP == nil {
+		return errors.Errorf("addLinkIPv4Address: invalid IP address %s", ip)
+	}
+	link, err := LinkByMac(mac, netLink, retryLinkByMacInterval)
+	if err != nil {
+		return errors.Wrapf(err, "addLinkIPv4Address: failed to find the link with mac address %s", mac)
+	}
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: addrIP, Mask: subnet.Mask}}
+	if err := netLink.AddrAdd(link, addr); err != nil {
+		return errors.Wrapf(err, "addLinkIPv4Address: failed to add IP address %s to link %s", addr, link.Attrs().Name)
+	}
+	return nil
+}
+
+// GenerateHostVethName returns a name to be used on the host-side veth device.
+func GenerateHostVethName(prefix, namespace, podname string) string {
+	h := sha1.New()
+	h.Write([]byte(fmt.Sprintf("%s.%s", namespace, podname)))
+	return fmt.Sprintf("%s%s", prefix, hex.EncodeToString(h.Sum(nil))[:11])
+}
+
+// GenerateInterfaceHostVethName returns a name to be used on the host-side veth device of an additional interface of
+// a pod.
+func GenerateInterfaceHostVethName(prefix, namespace, podname, ifName string) string {
+	return GenerateHostVethName(prefix, namespace, podname+"."+ifName)
+}
+
+func getENIRouteTable(eniMAC string, eniTable int, eniSubnetCIDR string, netLink netlinkwrapper.NetLink) (ENIRouteTable, error) {
+	link, err := LinkByMac(eniMAC, netLink, 0)
+	if err != nil {
+		return ENIRouteTable{}, errors.Wrapf(err, "getENIRouteTable: failed to find the link which uses MAC address %s", eniMAC)
+	}
+	linkIndex := link.Attrs().Index
+
+	var expected []netlink.Route
+	table := eniTable
+	if eniTable == 0 {
+		table = mainRoutingTable
+		expected = []netlink.Route{{
+			LinkIndex: linkIndex,
+			Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+			Table:     table,
+		}}
+	} else {
+		_, ipnet, err := net.ParseCIDR(eniSubnetCIDR)
+		if err != nil {
+			return ENIRouteTable{}, errors.Wrapf(err, "getENIRouteTable: invalid IPv4 CIDR block %s", eniSubnetCIDR)
+		}
+		gw, err := incrementIPv4Addr(ipnet.IP)
+		if err != nil {
+			return ENIRouteTable{}, errors.Wrapf(err, "getENIRouteTable: failed to define gateway address from %v", ipnet.IP)
+		}
+		expected = eniRoutes(linkIndex, gw, table)
+	}
+
+	routes, err := netLink.RouteListFiltered(unix.AF_INET, &netlink.Route{Table: table, LinkIndex: linkIndex},
+		netlink.RT_FILTER_TABLE|netlink.RT_FILTER_OIF)
+	if err != nil {
+		return ENIRouteTable{}, errors.Wrapf(err, "getENIRouteTable: failed to list routes in table %d", table)
+	}
+
+	result := ENIRouteTable{Table: table, Routes: make([]string, 0, len(routes)), MissingRoutes: []string{}}
+	for _, r := range routes {
+		result.Routes = append(result.Routes, r.String())
+		if routeDst(r) == "0.0.0.0/0" && r.Gw != nil {
+			result.Gateway = r.Gw.String()
+		}
+	}
+	for _, e := range expected {
+		if !containsRoute(routes, e) {
+			result.MissingRoutes = append(result.MissingRoutes, e.String())
+		}
+	}
+	return result, nil
+}
+
+// containsRoute returns true if a route with the same destination and gateway as r is in the list
+func containsRoute(routes []netlink.Route, r netlink.Route) bool {
+	for _, route := range routes {
+		if routeDst(route) == routeDst(r) && route.Gw.Equal(r.Gw) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeDst returns the destination of a route, netlink leaves it empty for the default route
+func routeDst(r netlink.Route) string {
+	if r.Dst == nil {
+		return "0.0.0.0/0"
+	}
+	return r.Dst.String()
+}
+
 // incrementIPv4Addr returns incremented IPv4 address
 func incrementIPv4Addr(ip net.IP) (net.IP, error) {
 	ip4 := ip.To4()
@@ -817,6 +1153,301 @@ func (n *linuxNetwork) GetRuleList() ([]netlink.Rule, error) {
 	return n.netLink.RuleList(unix.AF_INET)
 }
 
+// PodRule is an IP rule that the CNI plugin adds on the host for a pod
+type PodRule struct {
+	Priority int
+	// From is set to the pod IP on the rule of the traffic from the pod, and To on the rule of the traffic to it
+	From  string `json:",omitempty"`
+	To    string `json:",omitempty"`
+	Table int
+	// Missing is true when the host has no such rule
+	Missing bool `json:",omitempty"`
+}
+
+// CheckPodRules returns the IP rules of the pod with IP podIP whose traffic is routed with route table table, and
+// whether each of them is in ruleList. The traffic from pods on the primary ENI, table 0, needs no rule.
+func CheckPodRules(ruleList []netlink.Rule, podIP string, table int) []PodRule {
+	ip := net.ParseIP(podIP)
+	rules := []PodRule{{Priority: toPodRulePriority, To: podIP, Table: mainRoutingTable, Missing: true}}
+	if table > 0 {
+		rules = append(rules, PodRule{Priority: fromPodRulePriority, From: podIP, Table: table, Missing: true})
+	}
+	for _, rule := range ruleList {
+		for i := range rules {
+			if rule.Priority != rules[i].Priority || rule.Table != rules[i].Table {
+				continue
+			}
+			ipNet := rule.Dst
+			if rules[i].From != "" {
+				ipNet = rule.Src
+			}
+			if ipNet != nil && ipNet.IP.Equal(ip) {
+				rules[i].Missing = false
+			}
+		}
+	}
+	return rules
+}
+
+// PodRoute describes how the traffic of a pod to some destinations is routed, for introspection
+type PodRoute struct {
+	Destinations []string
+	// Priority is the priority of the IP rule of the pod that routes the traffic, 0 when no rule of the pod matches
+	// it, and Table the route table the traffic is routed with
+	Priority int `json:",omitempty"`
+	Table    string
+	// Interface is the interface the traffic leaves the host from
+	Interface string
+	// SNAT is true when the source of the traffic is translated to the primary IP of the node
+	SNAT bool
+}
+
+// PodRoutingPolicy describes how the traffic of the pods of the node is routed and SNAT'd, for introspection
+type PodRoutingPolicy struct {
+	ExternalSNAT     bool
+	ExcludeSNATCIDRs []string `json:",omitempty"`
+	// RandomizeSNAT is how the source ports of SNAT'd traffic are chosen: "hashrandom", "prng" or "none"
+	RandomizeSNAT   string `json:",omitempty"`
+	NodePortSupport bool
+	IntraNode       PodRoute
+	// CrossNode is the traffic to the VPC and to the CIDRs excluded from SNAT, OffVPC the traffic to anywhere else
+	CrossNode PodRoute
+	OffVPC    PodRoute
+}
+
+// GetPodRoutingPolicy returns how the traffic of the pods is routed and SNAT'd, by the rules the CNI plugin adds for
+// each pod and the SNAT rules of SetupHostNetwork. Pods on the primary ENI have no rule from their IP, their traffic
+// is routed with the main table.
+func (n *linuxNetwork) GetPodRoutingPolicy(vpcCIDRs []string) PodRoutingPolicy {
+	policy := PodRoutingPolicy{
+		ExternalSNAT:     n.UseExternalSNAT(),
+		ExcludeSNATCIDRs: n.GetExcludeSNATCIDRs(),
+		NodePortSupport:  n.nodePortSupportEnabled,
+		IntraNode: PodRoute{
+			Destinations: []string{"pods of the node"},
+			Priority:     toPodRulePriority,
+			Table:        "main",
+			Interface:    "host veth of the destination pod",
+		},
+		CrossNode: PodRoute{
+			Destinations: append(append([]string{}, vpcCIDRs...), n.GetExcludeSNATCIDRs()...),
+			Priority:     fromPodRulePriority,
+			Table:        "route table of the ENI of the pod",
+			Interface:    "ENI of the pod",
+		},
+	}
+	if policy.ExternalSNAT {
+		// The rule from the pod matches all destinations, the traffic leaves from the ENI of the pod untranslated
+		policy.OffVPC = policy.CrossNode
+		policy.OffVPC.Destinations = []string{"0.0.0.0/0"}
+		return policy
+	}
+	switch n.typeOfSNAT {
+	case randomHashSNAT:
+		policy.RandomizeSNAT = "hashrandom"
+	case randomPRNGSNAT:
+		policy.RandomizeSNAT = "prng"
+	default:
+		policy.RandomizeSNAT = "none"
+	}
+	policy.OffVPC = PodRoute{
+		Destinations: []string{"0.0.0.0/0"},
+		Table:        "main",
+		Interface:    "primary ENI",
+		SNAT:         true,
+	}
+	return policy
+}
+
+// DeleteConntrackEntries deletes the connection tracking entries whose original source is the IP address, the
+// connections it opened, or whose reply source is the IP address, the connections to it including those DNAT'd to it
+func (n *linuxNetwork) DeleteConntrackEntries(ip string) (uint, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() == nil {
+		return 0, errors.Errorf("invalid IPv4 address %q", ip)
+	}
+	var deleted uint
+	for _, filterType := range []netlink.ConntrackFilterType{netlink.ConntrackOrigSrcIP, netlink.ConntrackReplySrcIP} {
+		filter := &netlink.ConntrackFilter{}
+		if err := filter.AddIP(filterType, addr); err != nil {
+			return deleted, errors.Wrapf(err, "failed to filter the conntrack entries of %s", ip)
+		}
+		count, err := n.netLink.ConntrackDeleteFilter(netlink.ConntrackTable, unix.AF_INET, filter)
+		deleted += count
+		if err != nil {
+			return deleted, errors.Wrapf(err, "failed to delete the conntrack entries of %s", ip)
+		}
+	}
+	return deleted, nil
+}
+
+// egressOnlyRule returns the rule of the egress-only chain dropping the packets to the IP address that are not part of
+// a connection it opened, or related to one
+func egressOnlyRule(ip string) []string {
+	return []string{"-d", ip + "/32", "-m", "conntrack", "!", "--ctstate", "RELATED,ESTABLISHED", "-j", "DROP"}
+}
+
+// egressOnlyChainExists returns whether the egress-only chain was created
+func egressOnlyChainExists(ipt iptablesIface) (bool, error) {
+	chains, err := ipt.ListChains("filter")
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list iptables filter chains")
+	}
+	for _, chain := range chains {
+		if chain == egressOnlyChain {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetupPodEgressOnly adds a rule for the IP address to the egress-only chain, creating the chain and the jump to it
+// from the FORWARD chain if needed. The traffic from the host itself, like the kubelet probes, does not go through
+// the FORWARD chain and still reaches the pod.
+func (n *linuxNetwork) SetupPodEgressOnly(ip string) error {
+	if addr := net.ParseIP(ip); addr == nil || addr.To4() == nil {
+		return errors.Errorf("invalid IPv4 address %q", ip)
+	}
+	ipt, err := n.newIptables()
+	if err != nil {
+		return errors.Wrap(err, "egress-only setup: failed to create iptables")
+	}
+	exists, err := egressOnlyChainExists(ipt)
+	if err != nil {
+		return errors.Wrap(err, "egress-only setup")
+	}
+	if !exists {
+		log.Debugf("Egress-only setup: iptables -N %s", egressOnlyChain)
+		if err := ipt.NewChain("filter", egressOnlyChain); err != nil {
+			return errors.Wrapf(err, "egress-only setup: failed to add chain %s", egressOnlyChain)
+		}
+	}
+	jump := []string{"-m", "comment", "--comment", egressOnlyComment, "-j", egressOnlyChain}
+	exists, err = ipt.Exists("filter", "FORWARD", jump...)
+	if err != nil {
+		return errors.Wrap(err, "egress-only setup: failed to check the jump to the egress-only chain")
+	}
+	if !exists {
+		if err := ipt.Insert("filter", "FORWARD", 1, jump...); err != nil {
+			return errors.Wrap(err, "egress-only setup: failed to add the jump to the egress-only chain")
+		}
+	}
+	rule := egressOnlyRule(ip)
+	exists, err = ipt.Exists("filter", egressOnlyChain, rule...)
+	if err != nil {
+		return errors.Wrapf(err, "egress-only setup: failed to check the rule of %s", ip)
+	}
+	if exists {
+		return nil
+	}
+	log.Infof("Dropping the connections opened to egress-only IP %s", ip)
+	if err := ipt.Append("filter", egressOnlyChain, rule...); err != nil {
+		return errors.Wrapf(err, "egress-only setup: failed to add the rule of %s", ip)
+	}
+	return nil
+}
+
+// TeardownPodEgressOnly removes the rule of the IP address from the egress-only chain
+func (n *linuxNetwork) TeardownPodEgressOnly(ip string) error {
+	ipt, err := n.newIptables()
+	if err != nil {
+		return errors.Wrap(err, "egress-only teardown: failed to create iptables")
+	}
+	exists, err := egressOnlyChainExists(ipt)
+	if err != nil {
+		return errors.Wrap(err, "egress-only teardown")
+	}
+	if !exists {
+		return nil
+	}
+	rule := egressOnlyRule(ip)
+	exists, err = ipt.Exists("filter", egressOnlyChain, rule...)
+	if err != nil {
+		return errors.Wrapf(err, "egress-only teardown: failed to check the rule of %s", ip)
+	}
+	if !exists {
+		return nil
+	}
+	log.Infof("No longer dropping the connections opened to egress-only IP %s", ip)
+	if err := ipt.Delete("filter", egressOnlyChain, rule...); err != nil {
+		return errors.Wrapf(err, "egress-only teardown: failed to delete the rule of %s", ip)
+	}
+	return nil
+}
+
+// GetEgressOnlyIPs returns the IP addresses of the rules of the egress-only chain
+func (n *linuxNetwork) GetEgressOnlyIPs() ([]string, error) {
+	ipt, err := n.newIptables()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create iptables")
+	}
+	exists, err := egressOnlyChainExists(ipt)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	rules, err := ipt.List("filter", egressOnlyChain)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list iptables filter chain %s", egressOnlyChain)
+	}
+	var ips []string
+	for _, rule := range rules {
+		fields := strings.Fields(rule)
+		for i := 0; i < len(fields)-1; i++ {
+			if fields[i] == "-d" {
+				ips = append(ips, strings.TrimSuffix(fields[i+1], "/32"))
+				break
+			}
+		}
+	}
+	return ips, nil
+}
+
+// GetIptablesBackend returns the backend of the iptables binary the rules are programmed with, and counts the rules of
+// the other backend with iptables-legacy-save or iptables-nft-save
+func (n *linuxNetwork) GetIptablesBackend() IptablesBackend {
+	var backend IptablesBackend
+	version, err := n.runCommand("iptables", "--version")
+	if err != nil {
+		backend.Error = errors.Wrap(err, "failed to get the iptables version").Error()
+		return backend
+	}
+	backend.Version = strings.TrimSpace(version)
+	otherSave := ""
+	switch {
+	case strings.Contains(backend.Version, "("+IptablesModeNFTables+")"):
+		backend.Mode = IptablesModeNFTables
+		otherSave = "iptables-legacy-save"
+	case strings.Contains(backend.Version, "("+IptablesModeLegacy+")"):
+		backend.Mode = IptablesModeLegacy
+		otherSave = "iptables-nft-save"
+	default:
+		// There is no other backend to look at
+		backend.Mode = IptablesModeLegacy
+		backend.Fallback = true
+		return backend
+	}
+
+	rules, err := n.runCommand(otherSave)
+	if err != nil {
+		backend.Error = errors.Wrapf(err, "failed to list the rules of the other backend with %s", otherSave).Error()
+		return backend
+	}
+	for _, line := range strings.Split(rules, "\n") {
+		if strings.HasPrefix(line, "-A ") {
+			backend.OtherModeRules++
+		}
+	}
+	if backend.OtherModeRules > 0 {
+		backend.Mismatch = true
+		log.Warnf("iptables uses the %s backend, but %s lists %d rules: the rules of only one of them are applied",
+			backend.Mode, otherSave, backend.OtherModeRules)
+	}
+	return backend
+}
+
 // GetRuleListBySrc returns IP rules with matching source IP
 func (n *linuxNetwork) GetRuleListBySrc(ruleList []netlink.Rule, src net.IPNet) ([]netlink.Rule, error) {
 	var srcRuleList []netlink.Rule
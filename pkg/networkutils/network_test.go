@@ -139,6 +139,158 @@ func TestSetupENINetworkPrimary(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGetENIRouteTable(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	hwAddr, err := net.ParseMAC(testMAC2)
+	assert.NoError(t, err)
+	mockLinkAttrs := &netlink.LinkAttrs{
+		HardwareAddr: hwAddr,
+		Index:        3,
+	}
+	eth1 := mock_netlink.NewMockLink(ctrl)
+	mockNetLink.EXPECT().LinkList().Return([]netlink.Link{eth1}, nil)
+	eth1.EXPECT().Attrs().Return(mockLinkAttrs).AnyTimes()
+
+	// Only the gateway route is present, the default route is missing
+	gw := net.ParseIP("10.10.0.1").To4()
+	gwRoute := netlink.Route{
+		LinkIndex: 3,
+		Dst:       &net.IPNet{IP: gw, Mask: net.CIDRMask(32, 32)},
+		Scope:     netlink.SCOPE_LINK,
+		Table:     testTable,
+	}
+	mockNetLink.EXPECT().RouteListFiltered(unix.AF_INET, &netlink.Route{Table: testTable, LinkIndex: 3},
+		netlink.RT_FILTER_TABLE|netlink.RT_FILTER_OIF).Return([]netlink.Route{gwRoute}, nil)
+
+	routeTable, err := getENIRouteTable(testMAC2, testTable, testeniSubnet, mockNetLink)
+	assert.NoError(t, err)
+	assert.Equal(t, testTable, routeTable.Table)
+	assert.Equal(t, "", routeTable.Gateway)
+	assert.Equal(t, 1, len(routeTable.Routes))
+	assert.Equal(t, 1, len(routeTable.MissingRoutes))
+	assert.Contains(t, routeTable.MissingRoutes[0], "Gw: 10.10.0.1")
+
+	// Both routes are present
+	defaultRoute := netlink.Route{
+		LinkIndex: 3,
+		Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+		Gw:        gw,
+		Table:     testTable,
+	}
+	mockNetLink.EXPECT().LinkList().Return([]netlink.Link{eth1}, nil)
+	mockNetLink.EXPECT().RouteListFiltered(unix.AF_INET, &netlink.Route{Table: testTable, LinkIndex: 3},
+		netlink.RT_FILTER_TABLE|netlink.RT_FILTER_OIF).Return([]netlink.Route{gwRoute, defaultRoute}, nil)
+
+	routeTable, err = getENIRouteTable(testMAC2, testTable, testeniSubnet, mockNetLink)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.10.0.1", routeTable.Gateway)
+	assert.Empty(t, routeTable.MissingRoutes)
+}
+
+func TestGetHostVethIPv4Addresses(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	hostVeth := mock_netlink.NewMockLink(ctrl)
+	mockNetLink.EXPECT().LinkByName("eni1234").Return(hostVeth, nil)
+	podRoute := netlink.Route{
+		Dst:   &net.IPNet{IP: net.ParseIP("10.10.10.20").To4(), Mask: net.CIDRMask(32, 32)},
+		Scope: netlink.SCOPE_LINK,
+	}
+	otherRoute := netlink.Route{
+		Dst:   &net.IPNet{IP: net.ParseIP("10.10.0.0").To4(), Mask: net.CIDRMask(16, 32)},
+		Scope: netlink.SCOPE_LINK,
+	}
+	mockNetLink.EXPECT().RouteList(hostVeth, unix.AF_INET).Return([]netlink.Route{podRoute, otherRoute}, nil)
+
+	ips, err := getHostVethIPv4Addresses("eni1234", mockNetLink)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.10.10.20"}, ips)
+
+	assert.Equal(t, GenerateHostVethName("eni", "ns", "pod.eth1"), GenerateInterfaceHostVethName("eni", "ns", "pod", "eth1"))
+	assert.NotEqual(t, GenerateHostVethName("eni", "ns", "pod"), GenerateInterfaceHostVethName("eni", "ns", "pod", "eth1"))
+}
+
+func TestHasPodHostRoute(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	dst := &net.IPNet{IP: net.ParseIP("10.10.10.20").To4(), Mask: net.CIDRMask(32, 32)}
+	filter := &netlink.Route{Table: mainRoutingTable, Dst: dst}
+	mockNetLink.EXPECT().RouteListFiltered(unix.AF_INET, filter, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_DST).
+		Return([]netlink.Route{{Dst: dst, LinkIndex: 5, Scope: netlink.SCOPE_LINK}}, nil)
+	found, err := hasPodHostRoute("10.10.10.20", mockNetLink)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	mockNetLink.EXPECT().RouteListFiltered(unix.AF_INET, filter, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_DST).
+		Return(nil, nil)
+	found, err = hasPodHostRoute("10.10.10.20", mockNetLink)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, err = hasPodHostRoute("not-an-ip", mockNetLink)
+	assert.Error(t, err)
+}
+
+func TestDeleteConntrackEntries(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{netLink: mockNetLink}
+	conntrackTable := netlink.ConntrackTableType(netlink.ConntrackTable)
+	podIP := net.ParseIP("10.10.10.20")
+	origSrc := &netlink.ConntrackFilter{}
+	_ = origSrc.AddIP(netlink.ConntrackOrigSrcIP, podIP)
+	replySrc := &netlink.ConntrackFilter{}
+	_ = replySrc.AddIP(netlink.ConntrackReplySrcIP, podIP)
+	gomock.InOrder(
+		mockNetLink.EXPECT().ConntrackDeleteFilter(conntrackTable, netlink.InetFamily(unix.AF_INET), origSrc).
+			Return(uint(2), nil),
+		mockNetLink.EXPECT().ConntrackDeleteFilter(conntrackTable, netlink.InetFamily(unix.AF_INET), replySrc).
+			Return(uint(3), nil),
+	)
+	deleted, err := ln.DeleteConntrackEntries("10.10.10.20")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(5), deleted)
+
+	mockNetLink.EXPECT().ConntrackDeleteFilter(conntrackTable, netlink.InetFamily(unix.AF_INET), origSrc).
+		Return(uint(0), errors.New("operation not permitted"))
+	_, err = ln.DeleteConntrackEntries("10.10.10.20")
+	assert.Error(t, err)
+
+	_, err = ln.DeleteConntrackEntries("not-an-ip")
+	assert.Error(t, err)
+}
+
+func TestLinkIPv4Address(t *testing.T) {
+	ctrl, mockNetLink, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	hwAddr, err := net.ParseMAC(testMAC1)
+	assert.NoError(t, err)
+	eth0 := mock_netlink.NewMockLink(ctrl)
+	eth0.EXPECT().Attrs().Return(&netlink.LinkAttrs{HardwareAddr: hwAddr, Name: "eth0"}).AnyTimes()
+	mockNetLink.EXPECT().LinkList().Return([]netlink.Link{eth0}, nil).Times(3)
+
+	mockNetLink.EXPECT().AddrList(eth0, unix.AF_INET).Return([]netlink.Addr{}, nil)
+	found, err := hasLinkIPv4Address(testMAC1, testeniIP, mockNetLink)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	testeniAddr := &net.IPNet{IP: testENINetIP, Mask: testENINetIPNet.Mask}
+	mockNetLink.EXPECT().AddrAdd(eth0, &netlink.Addr{IPNet: testeniAddr}).Return(nil)
+	err = addLinkIPv4Address(testMAC1, testeniIP, testeniSubnet, mockNetLink)
+	assert.NoError(t, err)
+
+	mockNetLink.EXPECT().AddrList(eth0, unix.AF_INET).Return([]netlink.Addr{{IPNet: testeniAddr}}, nil)
+	found, err = hasLinkIPv4Address(testMAC1, testeniIP, mockNetLink)
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
+
 func TestSetupHostNetworkNodePortDisabled(t *testing.T) {
 	ctrl, mockNetLink, _, mockNS, mockIptables := setup(t)
 	defer ctrl.Finish()
@@ -264,6 +416,156 @@ func TestUpdateRuleListBySrc(t *testing.T) {
 	}
 }
 
+func TestCheckPodRules(t *testing.T) {
+	podIP := net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(32, 32)}
+	vpcCIDR := net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(16, 32)}
+	ruleList := []netlink.Rule{
+		{Priority: toPodRulePriority, Dst: &podIP, Table: mainRoutingTable},
+		// The rule of another table does not count
+		{Priority: fromPodRulePriority, Src: &podIP, Dst: &vpcCIDR, Table: 3},
+	}
+
+	assert.Equal(t, []PodRule{{Priority: toPodRulePriority, To: "10.0.0.5", Table: mainRoutingTable}},
+		CheckPodRules(ruleList, "10.0.0.5", 0))
+	assert.Equal(t, []PodRule{
+		{Priority: toPodRulePriority, To: "10.0.0.5", Table: mainRoutingTable},
+		{Priority: fromPodRulePriority, From: "10.0.0.5", Table: 2, Missing: true},
+	}, CheckPodRules(ruleList, "10.0.0.5", 2))
+	assert.False(t, CheckPodRules(ruleList, "10.0.0.5", 3)[1].Missing)
+	assert.True(t, CheckPodRules(ruleList, "10.0.0.6", 0)[0].Missing)
+}
+
+func TestGetPodRoutingPolicy(t *testing.T) {
+	_ = os.Setenv(envExternalSNAT, "false")
+	_ = os.Setenv(envExcludeSNATCIDRs, "10.12.0.0/16")
+	defer os.Unsetenv(envExcludeSNATCIDRs)
+	ln := &linuxNetwork{typeOfSNAT: randomPRNGSNAT, nodePortSupportEnabled: true}
+
+	policy := ln.GetPodRoutingPolicy([]string{"10.0.0.0/16"})
+	assert.False(t, policy.ExternalSNAT)
+	assert.Equal(t, "prng", policy.RandomizeSNAT)
+	assert.Equal(t, toPodRulePriority, policy.IntraNode.Priority)
+	assert.False(t, policy.IntraNode.SNAT)
+	assert.Equal(t, []string{"10.0.0.0/16", "10.12.0.0/16"}, policy.CrossNode.Destinations)
+	assert.Equal(t, fromPodRulePriority, policy.CrossNode.Priority)
+	assert.False(t, policy.CrossNode.SNAT)
+	assert.True(t, policy.OffVPC.SNAT)
+	assert.Equal(t, "primary ENI", policy.OffVPC.Interface)
+
+	// With external SNAT, the traffic out of the VPC leaves from the ENI of the pod untranslated
+	_ = os.Setenv(envExternalSNAT, "true")
+	defer os.Unsetenv(envExternalSNAT)
+	policy = ln.GetPodRoutingPolicy([]string{"10.0.0.0/16"})
+	assert.True(t, policy.ExternalSNAT)
+	assert.Equal(t, "", policy.RandomizeSNAT)
+	assert.Equal(t, []string{"10.0.0.0/16"}, policy.CrossNode.Destinations)
+	assert.Equal(t, fromPodRulePriority, policy.OffVPC.Priority)
+	assert.Equal(t, "ENI of the pod", policy.OffVPC.Interface)
+	assert.False(t, policy.OffVPC.SNAT)
+}
+
+func TestSetupHostNetworkPodEgressMark(t *testing.T) {
+	ctrl, mockNetLink, _, mockNS, mockIptables := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{
+		useExternalSNAT: true,
+		mainENIMark:     defaultConnmark,
+		podEgressMark:   0x100,
+		mtu:             testMTU,
+		netLink:         mockNetLink,
+		ns:              mockNS,
+		newIptables: func() (iptablesIface, error) {
+			return mockIptables, nil
+		},
+	}
+	setupHostNetwork := func() {
+		mockPrimaryInterfaceLookup(ctrl, mockNetLink)
+		mockNetLink.EXPECT().LinkSetMTU(gomock.Any(), testMTU).Return(nil)
+		var hostRule netlink.Rule
+		mockNetLink.EXPECT().NewRule().Return(&hostRule)
+		mockNetLink.EXPECT().RuleDel(&hostRule)
+		var mainENIRule netlink.Rule
+		mockNetLink.EXPECT().NewRule().Return(&mainENIRule)
+		mockNetLink.EXPECT().RuleDel(&mainENIRule)
+
+		var vpcCIDRs []*string
+		err := ln.SetupHostNetwork(testENINetIPNet, vpcCIDRs, loopback, &testENINetIP)
+		assert.NoError(t, err)
+	}
+
+	setupHostNetwork()
+	assert.Equal(t, map[string]map[string][][]string{
+		"mangle": {
+			"PREROUTING": [][]string{
+				{
+					"-i", "eni+", "-m", "comment", "--comment", "AWS, pod egress mark",
+					"-j", "MARK", "--set-xmark", "0x100/0x100",
+				},
+			},
+		},
+	}, mockIptables.dataplaneState)
+
+	// The rule with the previous mark is replaced
+	ln.podEgressMark = 0x200
+	setupHostNetwork()
+	assert.Equal(t, [][]string{
+		{
+			"-i", "eni+", "-m", "comment", "--comment", "AWS, pod egress mark",
+			"-j", "MARK", "--set-xmark", "0x200/0x200",
+		},
+	}, mockIptables.dataplaneState["mangle"]["PREROUTING"])
+
+	// Disabled, the rule is removed
+	ln.podEgressMark = 0
+	setupHostNetwork()
+	assert.Empty(t, mockIptables.dataplaneState["mangle"]["PREROUTING"])
+}
+
+func TestGetPodEgressMark(t *testing.T) {
+	defer os.Unsetenv(envPodEgressMark)
+	_ = os.Setenv(envPodEgressMark, "0x100")
+	assert.Equal(t, uint32(0x100), getPodEgressMark())
+	// Overlaps the default connmark
+	_ = os.Setenv(envPodEgressMark, "0x180")
+	assert.Equal(t, uint32(0), getPodEgressMark())
+	_ = os.Setenv(envPodEgressMark, "mark")
+	assert.Equal(t, uint32(0), getPodEgressMark())
+}
+
+func TestPodEgressOnly(t *testing.T) {
+	mockIptables := newMockIptables()
+	ln := &linuxNetwork{
+		newIptables: func() (iptablesIface, error) {
+			return mockIptables, nil
+		},
+	}
+
+	ips, err := ln.GetEgressOnlyIPs()
+	assert.NoError(t, err)
+	assert.Empty(t, ips)
+	assert.NoError(t, ln.TeardownPodEgressOnly("10.0.0.1"))
+
+	assert.NoError(t, ln.SetupPodEgressOnly("10.0.0.1"))
+	assert.NoError(t, ln.SetupPodEgressOnly("10.0.0.2"))
+	// Set up again by a duplicate ADD
+	assert.NoError(t, ln.SetupPodEgressOnly("10.0.0.1"))
+	assert.Error(t, ln.SetupPodEgressOnly("not-an-ip"))
+	assert.Equal(t, [][]string{
+		{"-d", "10.0.0.1/32", "-m", "conntrack", "!", "--ctstate", "RELATED,ESTABLISHED", "-j", "DROP"},
+		{"-d", "10.0.0.2/32", "-m", "conntrack", "!", "--ctstate", "RELATED,ESTABLISHED", "-j", "DROP"},
+	}, mockIptables.dataplaneState["filter"][egressOnlyChain])
+	ips, err = ln.GetEgressOnlyIPs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, ips)
+
+	assert.NoError(t, ln.TeardownPodEgressOnly("10.0.0.1"))
+	assert.NoError(t, ln.TeardownPodEgressOnly("10.0.0.1"))
+	ips, err = ln.GetEgressOnlyIPs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2"}, ips)
+}
+
 func TestSetupHostNetworkNodePortEnabled(t *testing.T) {
 	ctrl, mockNetLink, _, mockNS, mockIptables := setup(t)
 	defer ctrl.Finish()
@@ -585,6 +887,41 @@ func TestIncrementIPv4Addr(t *testing.T) {
 	}
 }
 
+func TestGetIptablesBackend(t *testing.T) {
+	testCases := []struct {
+		name     string
+		outputs  map[string]string
+		expected IptablesBackend
+	}{
+		{"nf_tables", map[string]string{
+			"iptables":             "iptables v1.8.4 (nf_tables)\n",
+			"iptables-legacy-save": "*filter\n:INPUT ACCEPT [0:0]\nCOMMIT\n",
+		}, IptablesBackend{Version: "iptables v1.8.4 (nf_tables)", Mode: IptablesModeNFTables}},
+		{"legacy with nf_tables rules", map[string]string{
+			"iptables":          "iptables v1.8.4 (legacy)",
+			"iptables-nft-save": "*nat\n-A KUBE-SERVICES -j KUBE-NODEPORTS\n-A POSTROUTING -j KUBE-POSTROUTING\nCOMMIT\n",
+		}, IptablesBackend{Version: "iptables v1.8.4 (legacy)", Mode: IptablesModeLegacy, OtherModeRules: 2, Mismatch: true}},
+		{"before 1.8", map[string]string{
+			"iptables": "iptables v1.6.1",
+		}, IptablesBackend{Version: "iptables v1.6.1", Mode: IptablesModeLegacy, Fallback: true}},
+		{"no iptables", map[string]string{}, IptablesBackend{
+			Error: "failed to get the iptables version: executable file not found"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ln := &linuxNetwork{runCommand: func(name string, args ...string) (string, error) {
+				out, ok := tc.outputs[name]
+				if !ok {
+					return "", errors.New("executable file not found")
+				}
+				return out, nil
+			}}
+			assert.Equal(t, tc.expected, ln.GetIptablesBackend())
+		})
+	}
+}
+
 type mockIptables struct {
 	// dataplaneState is a map from table name to chain name to slice of rulespecs
 	dataplaneState map[string]map[string][][]string
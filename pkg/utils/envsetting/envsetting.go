@@ -0,0 +1,71 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package envsetting describes the effective configuration of a component, for debugging purposes
+package envsetting
+
+import (
+	"os"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// SourceEnv is the source of a setting whose environment variable is set
+	SourceEnv = "env"
+	// SourceDefault is the source of a setting whose environment variable is not set
+	SourceDefault = "default"
+	// SourceDerived is the source of a value computed from other settings
+	SourceDerived = "derived"
+)
+
+// Setting is the effective value of a configuration environment variable, where it came from and its default value
+type Setting struct {
+	Value  interface{}
+	Source string
+	// EnvValue is the raw value of the environment variable when it is set. When it is invalid, Value is the default.
+	EnvValue string      `json:",omitempty"`
+	Default  interface{} `json:",omitempty"`
+}
+
+// New returns the setting of the environment variable name, given its effective value and its default value
+func New(name string, value interface{}, defaultValue interface{}) Setting {
+	setting := Setting{Value: value, Source: SourceDefault, Default: defaultValue}
+	if envValue, found := os.LookupEnv(name); found && envValue != "" {
+		setting.Source = SourceEnv
+		setting.EnvValue = envValue
+	}
+	return setting
+}
+
+// Derived returns a setting computed from other settings
+func Derived(value interface{}) Setting {
+	return Setting{Value: value, Source: SourceDerived}
+}
+
+// Choice returns the value of the environment variable name when it is one of the allowed values, and defaultValue
+// when it is not set or not allowed
+func Choice(name string, defaultValue string, allowed ...string) string {
+	value, found := os.LookupEnv(name)
+	if !found || value == "" {
+		return defaultValue
+	}
+	for _, choice := range allowed {
+		if value == choice {
+			log.Debugf("Using %s %v", name, value)
+			return value
+		}
+	}
+	log.Errorf("Invalid %s value %q, using default %q", name, value, defaultValue)
+	return defaultValue
+}
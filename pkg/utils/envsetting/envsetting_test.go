@@ -0,0 +1,49 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package envsetting
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	const name = "ENVSETTING_TEST"
+	_ = os.Unsetenv(name)
+	assert.Equal(t, Setting{Value: 1, Source: SourceDefault, Default: 1}, New(name, 1, 1))
+
+	_ = os.Setenv(name, "")
+	assert.Equal(t, SourceDefault, New(name, 1, 1).Source)
+
+	_ = os.Setenv(name, "5")
+	defer os.Unsetenv(name)
+	assert.Equal(t, Setting{Value: 5, Source: SourceEnv, EnvValue: "5", Default: 1}, New(name, 5, 1))
+
+	assert.Equal(t, Setting{Value: 60.0, Source: SourceDerived}, Derived(60.0))
+}
+
+func TestChoice(t *testing.T) {
+	const name = "ENVSETTING_CHOICE_TEST"
+	_ = os.Unsetenv(name)
+	assert.Equal(t, "a", Choice(name, "a", "a", "b"))
+
+	_ = os.Setenv(name, "b")
+	defer os.Unsetenv(name)
+	assert.Equal(t, "b", Choice(name, "a", "a", "b"))
+
+	_ = os.Setenv(name, "c")
+	assert.Equal(t, "a", Choice(name, "a", "a", "b"))
+}
@@ -84,3 +84,21 @@ func (mr *MockCNIBackendClientMockRecorder) DelNetwork(arg0, arg1 interface{}, a
 	varargs := append([]interface{}{arg0, arg1}, arg2...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DelNetwork", reflect.TypeOf((*MockCNIBackendClient)(nil).DelNetwork), varargs...)
 }
+
+// ReportNetlinkRetries mocks base method
+func (m *MockCNIBackendClient) ReportNetlinkRetries(arg0 context.Context, arg1 *rpc.NetlinkRetriesRequest, arg2 ...grpc.CallOption) (*rpc.NetlinkRetriesReply, error) {
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ReportNetlinkRetries", varargs...)
+	ret0, _ := ret[0].(*rpc.NetlinkRetriesReply)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReportNetlinkRetries indicates an expected call of ReportNetlinkRetries
+func (mr *MockCNIBackendClientMockRecorder) ReportNetlinkRetries(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportNetlinkRetries", reflect.TypeOf((*MockCNIBackendClient)(nil).ReportNetlinkRetries), varargs...)
+}
@@ -12,6 +12,12 @@ It has these top-level messages:
 	AddNetworkReply
 	DelNetworkRequest
 	DelNetworkReply
+	PodInterface
+	StaticRoute
+	NetlinkRetriesRequest
+	NetlinkRetry
+	NetlinkRetriesReply
+	PodDNS
 */
 package rpc
 
@@ -36,11 +42,20 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type AddNetworkRequest struct {
-	K8S_POD_NAME               string `protobuf:"bytes,1,opt,name=K8S_POD_NAME,json=K8SPODNAME" json:"K8S_POD_NAME,omitempty"`
-	K8S_POD_NAMESPACE          string `protobuf:"bytes,2,opt,name=K8S_POD_NAMESPACE,json=K8SPODNAMESPACE" json:"K8S_POD_NAMESPACE,omitempty"`
-	K8S_POD_INFRA_CONTAINER_ID string `protobuf:"bytes,3,opt,name=K8S_POD_INFRA_CONTAINER_ID,json=K8SPODINFRACONTAINERID" json:"K8S_POD_INFRA_CONTAINER_ID,omitempty"`
-	Netns                      string `protobuf:"bytes,4,opt,name=Netns" json:"Netns,omitempty"`
-	IfName                     string `protobuf:"bytes,5,opt,name=IfName" json:"IfName,omitempty"`
+	K8S_POD_NAME               string       `protobuf:"bytes,1,opt,name=K8S_POD_NAME,json=K8SPODNAME" json:"K8S_POD_NAME,omitempty"`
+	K8S_POD_NAMESPACE          string       `protobuf:"bytes,2,opt,name=K8S_POD_NAMESPACE,json=K8SPODNAMESPACE" json:"K8S_POD_NAMESPACE,omitempty"`
+	K8S_POD_INFRA_CONTAINER_ID string       `protobuf:"bytes,3,opt,name=K8S_POD_INFRA_CONTAINER_ID,json=K8SPODINFRACONTAINERID" json:"K8S_POD_INFRA_CONTAINER_ID,omitempty"`
+	Netns                      string       `protobuf:"bytes,4,opt,name=Netns" json:"Netns,omitempty"`
+	IfName                     string       `protobuf:"bytes,5,opt,name=IfName" json:"IfName,omitempty"`
+	K8S_POD_UID                string       `protobuf:"bytes,6,opt,name=K8S_POD_UID,json=K8SPODUID" json:"K8S_POD_UID,omitempty"`
+	AdditionalIfNames          []string     `protobuf:"bytes,7,rep,name=AdditionalIfNames" json:"AdditionalIfNames,omitempty"`
+	SubnetHint                 string       `protobuf:"bytes,8,opt,name=SubnetHint" json:"SubnetHint,omitempty"`
+	UseReservedIPs             bool         `protobuf:"varint,9,opt,name=UseReservedIPs" json:"UseReservedIPs,omitempty"`
+	HostNetwork                bool         `protobuf:"varint,10,opt,name=HostNetwork" json:"HostNetwork,omitempty"`
+	DNS                        *PodDNS      `protobuf:"bytes,11,opt,name=DNS" json:"DNS,omitempty"`
+	EgressOnly                 bool         `protobuf:"varint,12,opt,name=EgressOnly" json:"EgressOnly,omitempty"`
+	IPFamily                   string       `protobuf:"bytes,13,opt,name=IPFamily" json:"IPFamily,omitempty"`
+	Sysctls                    []*PodSysctl `protobuf:"bytes,14,rep,name=Sysctls" json:"Sysctls,omitempty"`
 }
 
 func (m *AddNetworkRequest) Reset()                    { *m = AddNetworkRequest{} }
@@ -83,13 +98,78 @@ func (m *AddNetworkRequest) GetIfName() string {
 	return ""
 }
 
+func (m *AddNetworkRequest) GetK8S_POD_UID() string {
+	if m != nil {
+		return m.K8S_POD_UID
+	}
+	return ""
+}
+
+func (m *AddNetworkRequest) GetAdditionalIfNames() []string {
+	if m != nil {
+		return m.AdditionalIfNames
+	}
+	return nil
+}
+
+func (m *AddNetworkRequest) GetSubnetHint() string {
+	if m != nil {
+		return m.SubnetHint
+	}
+	return ""
+}
+
+func (m *AddNetworkRequest) GetUseReservedIPs() bool {
+	if m != nil {
+		return m.UseReservedIPs
+	}
+	return false
+}
+
+func (m *AddNetworkRequest) GetHostNetwork() bool {
+	if m != nil {
+		return m.HostNetwork
+	}
+	return false
+}
+
+func (m *AddNetworkRequest) GetDNS() *PodDNS {
+	if m != nil {
+		return m.DNS
+	}
+	return nil
+}
+
+func (m *AddNetworkRequest) GetEgressOnly() bool {
+	if m != nil {
+		return m.EgressOnly
+	}
+	return false
+}
+
+func (m *AddNetworkRequest) GetIPFamily() string {
+	if m != nil {
+		return m.IPFamily
+	}
+	return ""
+}
+
+func (m *AddNetworkRequest) GetSysctls() []*PodSysctl {
+	if m != nil {
+		return m.Sysctls
+	}
+	return nil
+}
+
 type AddNetworkReply struct {
-	Success         bool     `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
-	IPv4Addr        string   `protobuf:"bytes,2,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
-	IPv4Subnet      string   `protobuf:"bytes,3,opt,name=IPv4Subnet" json:"IPv4Subnet,omitempty"`
-	DeviceNumber    int32    `protobuf:"varint,4,opt,name=DeviceNumber" json:"DeviceNumber,omitempty"`
-	UseExternalSNAT bool     `protobuf:"varint,5,opt,name=UseExternalSNAT" json:"UseExternalSNAT,omitempty"`
-	VPCcidrs        []string `protobuf:"bytes,6,rep,name=VPCcidrs" json:"VPCcidrs,omitempty"`
+	Success              bool            `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
+	IPv4Addr             string          `protobuf:"bytes,2,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
+	IPv4Subnet           string          `protobuf:"bytes,3,opt,name=IPv4Subnet" json:"IPv4Subnet,omitempty"`
+	DeviceNumber         int32           `protobuf:"varint,4,opt,name=DeviceNumber" json:"DeviceNumber,omitempty"`
+	UseExternalSNAT      bool            `protobuf:"varint,5,opt,name=UseExternalSNAT" json:"UseExternalSNAT,omitempty"`
+	VPCcidrs             []string        `protobuf:"bytes,6,rep,name=VPCcidrs" json:"VPCcidrs,omitempty"`
+	AdditionalInterfaces []*PodInterface `protobuf:"bytes,7,rep,name=AdditionalInterfaces" json:"AdditionalInterfaces,omitempty"`
+	StaticRoutes         []*StaticRoute  `protobuf:"bytes,8,rep,name=StaticRoutes" json:"StaticRoutes,omitempty"`
 }
 
 func (m *AddNetworkReply) Reset()                    { *m = AddNetworkReply{} }
@@ -139,6 +219,20 @@ func (m *AddNetworkReply) GetVPCcidrs() []string {
 	return nil
 }
 
+func (m *AddNetworkReply) GetAdditionalInterfaces() []*PodInterface {
+	if m != nil {
+		return m.AdditionalInterfaces
+	}
+	return nil
+}
+
+func (m *AddNetworkReply) GetStaticRoutes() []*StaticRoute {
+	if m != nil {
+		return m.StaticRoutes
+	}
+	return nil
+}
+
 type DelNetworkRequest struct {
 	K8S_POD_NAME               string `protobuf:"bytes,1,opt,name=K8S_POD_NAME,json=K8SPODNAME" json:"K8S_POD_NAME,omitempty"`
 	K8S_POD_NAMESPACE          string `protobuf:"bytes,2,opt,name=K8S_POD_NAMESPACE,json=K8SPODNAMESPACE" json:"K8S_POD_NAMESPACE,omitempty"`
@@ -188,9 +282,10 @@ func (m *DelNetworkRequest) GetReason() string {
 }
 
 type DelNetworkReply struct {
-	Success      bool   `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
-	IPv4Addr     string `protobuf:"bytes,2,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
-	DeviceNumber int32  `protobuf:"varint,3,opt,name=DeviceNumber" json:"DeviceNumber,omitempty"`
+	Success              bool            `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
+	IPv4Addr             string          `protobuf:"bytes,2,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
+	DeviceNumber         int32           `protobuf:"varint,3,opt,name=DeviceNumber" json:"DeviceNumber,omitempty"`
+	AdditionalInterfaces []*PodInterface `protobuf:"bytes,4,rep,name=AdditionalInterfaces" json:"AdditionalInterfaces,omitempty"`
 }
 
 func (m *DelNetworkReply) Reset()                    { *m = DelNetworkReply{} }
@@ -219,11 +314,233 @@ func (m *DelNetworkReply) GetDeviceNumber() int32 {
 	return 0
 }
 
+func (m *DelNetworkReply) GetAdditionalInterfaces() []*PodInterface {
+	if m != nil {
+		return m.AdditionalInterfaces
+	}
+	return nil
+}
+
+type PodInterface struct {
+	IfName       string `protobuf:"bytes,1,opt,name=IfName" json:"IfName,omitempty"`
+	IPv4Addr     string `protobuf:"bytes,2,opt,name=IPv4Addr" json:"IPv4Addr,omitempty"`
+	DeviceNumber int32  `protobuf:"varint,3,opt,name=DeviceNumber" json:"DeviceNumber,omitempty"`
+}
+
+func (m *PodInterface) Reset()                    { *m = PodInterface{} }
+func (m *PodInterface) String() string            { return proto.CompactTextString(m) }
+func (*PodInterface) ProtoMessage()               {}
+func (*PodInterface) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *PodInterface) GetIfName() string {
+	if m != nil {
+		return m.IfName
+	}
+	return ""
+}
+
+func (m *PodInterface) GetIPv4Addr() string {
+	if m != nil {
+		return m.IPv4Addr
+	}
+	return ""
+}
+
+func (m *PodInterface) GetDeviceNumber() int32 {
+	if m != nil {
+		return m.DeviceNumber
+	}
+	return 0
+}
+
+type StaticRoute struct {
+	Destination string `protobuf:"bytes,1,opt,name=Destination" json:"Destination,omitempty"`
+	Gateway     string `protobuf:"bytes,2,opt,name=Gateway" json:"Gateway,omitempty"`
+}
+
+func (m *StaticRoute) Reset()                    { *m = StaticRoute{} }
+func (m *StaticRoute) String() string            { return proto.CompactTextString(m) }
+func (*StaticRoute) ProtoMessage()               {}
+func (*StaticRoute) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *StaticRoute) GetDestination() string {
+	if m != nil {
+		return m.Destination
+	}
+	return ""
+}
+
+func (m *StaticRoute) GetGateway() string {
+	if m != nil {
+		return m.Gateway
+	}
+	return ""
+}
+
+type NetlinkRetriesRequest struct {
+	K8S_POD_NAME               string          `protobuf:"bytes,1,opt,name=K8S_POD_NAME,json=K8SPODNAME" json:"K8S_POD_NAME,omitempty"`
+	K8S_POD_NAMESPACE          string          `protobuf:"bytes,2,opt,name=K8S_POD_NAMESPACE,json=K8SPODNAMESPACE" json:"K8S_POD_NAMESPACE,omitempty"`
+	K8S_POD_INFRA_CONTAINER_ID string          `protobuf:"bytes,3,opt,name=K8S_POD_INFRA_CONTAINER_ID,json=K8SPODINFRACONTAINERID" json:"K8S_POD_INFRA_CONTAINER_ID,omitempty"`
+	Command                    string          `protobuf:"bytes,4,opt,name=Command" json:"Command,omitempty"`
+	Retries                    []*NetlinkRetry `protobuf:"bytes,5,rep,name=Retries" json:"Retries,omitempty"`
+}
+
+func (m *NetlinkRetriesRequest) Reset()                    { *m = NetlinkRetriesRequest{} }
+func (m *NetlinkRetriesRequest) String() string            { return proto.CompactTextString(m) }
+func (*NetlinkRetriesRequest) ProtoMessage()               {}
+func (*NetlinkRetriesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *NetlinkRetriesRequest) GetK8S_POD_NAME() string {
+	if m != nil {
+		return m.K8S_POD_NAME
+	}
+	return ""
+}
+
+func (m *NetlinkRetriesRequest) GetK8S_POD_NAMESPACE() string {
+	if m != nil {
+		return m.K8S_POD_NAMESPACE
+	}
+	return ""
+}
+
+func (m *NetlinkRetriesRequest) GetK8S_POD_INFRA_CONTAINER_ID() string {
+	if m != nil {
+		return m.K8S_POD_INFRA_CONTAINER_ID
+	}
+	return ""
+}
+
+func (m *NetlinkRetriesRequest) GetCommand() string {
+	if m != nil {
+		return m.Command
+	}
+	return ""
+}
+
+func (m *NetlinkRetriesRequest) GetRetries() []*NetlinkRetry {
+	if m != nil {
+		return m.Retries
+	}
+	return nil
+}
+
+type NetlinkRetry struct {
+	Operation string `protobuf:"bytes,1,opt,name=Operation" json:"Operation,omitempty"`
+	Count     int32  `protobuf:"varint,2,opt,name=Count" json:"Count,omitempty"`
+}
+
+func (m *NetlinkRetry) Reset()                    { *m = NetlinkRetry{} }
+func (m *NetlinkRetry) String() string            { return proto.CompactTextString(m) }
+func (*NetlinkRetry) ProtoMessage()               {}
+func (*NetlinkRetry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *NetlinkRetry) GetOperation() string {
+	if m != nil {
+		return m.Operation
+	}
+	return ""
+}
+
+func (m *NetlinkRetry) GetCount() int32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+type NetlinkRetriesReply struct {
+	Success bool `protobuf:"varint,1,opt,name=Success" json:"Success,omitempty"`
+}
+
+func (m *NetlinkRetriesReply) Reset()                    { *m = NetlinkRetriesReply{} }
+func (m *NetlinkRetriesReply) String() string            { return proto.CompactTextString(m) }
+func (*NetlinkRetriesReply) ProtoMessage()               {}
+func (*NetlinkRetriesReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *NetlinkRetriesReply) GetSuccess() bool {
+	if m != nil {
+		return m.Success
+	}
+	return false
+}
+
+type PodDNS struct {
+	Nameservers []string `protobuf:"bytes,1,rep,name=Nameservers" json:"Nameservers,omitempty"`
+	Domain      string   `protobuf:"bytes,2,opt,name=Domain" json:"Domain,omitempty"`
+	Search      []string `protobuf:"bytes,3,rep,name=Search" json:"Search,omitempty"`
+	Options     []string `protobuf:"bytes,4,rep,name=Options" json:"Options,omitempty"`
+}
+
+func (m *PodDNS) Reset()                    { *m = PodDNS{} }
+func (m *PodDNS) String() string            { return proto.CompactTextString(m) }
+func (*PodDNS) ProtoMessage()               {}
+func (*PodDNS) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *PodDNS) GetNameservers() []string {
+	if m != nil {
+		return m.Nameservers
+	}
+	return nil
+}
+
+func (m *PodDNS) GetDomain() string {
+	if m != nil {
+		return m.Domain
+	}
+	return ""
+}
+
+func (m *PodDNS) GetSearch() []string {
+	if m != nil {
+		return m.Search
+	}
+	return nil
+}
+
+func (m *PodDNS) GetOptions() []string {
+	if m != nil {
+		return m.Options
+	}
+	return nil
+}
+
+type PodSysctl struct {
+	Name  string `protobuf:"bytes,1,opt,name=Name" json:"Name,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=Value" json:"Value,omitempty"`
+}
+
+func (m *PodSysctl) Reset()                    { *m = PodSysctl{} }
+func (m *PodSysctl) String() string            { return proto.CompactTextString(m) }
+func (*PodSysctl) ProtoMessage()               {}
+func (*PodSysctl) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *PodSysctl) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PodSysctl) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*AddNetworkRequest)(nil), "rpc.AddNetworkRequest")
 	proto.RegisterType((*AddNetworkReply)(nil), "rpc.AddNetworkReply")
 	proto.RegisterType((*DelNetworkRequest)(nil), "rpc.DelNetworkRequest")
 	proto.RegisterType((*DelNetworkReply)(nil), "rpc.DelNetworkReply")
+	proto.RegisterType((*PodInterface)(nil), "rpc.PodInterface")
+	proto.RegisterType((*StaticRoute)(nil), "rpc.StaticRoute")
+	proto.RegisterType((*NetlinkRetriesRequest)(nil), "rpc.NetlinkRetriesRequest")
+	proto.RegisterType((*NetlinkRetry)(nil), "rpc.NetlinkRetry")
+	proto.RegisterType((*NetlinkRetriesReply)(nil), "rpc.NetlinkRetriesReply")
+	proto.RegisterType((*PodDNS)(nil), "rpc.PodDNS")
+	proto.RegisterType((*PodSysctl)(nil), "rpc.PodSysctl")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -239,6 +556,7 @@ const _ = grpc.SupportPackageIsVersion4
 type CNIBackendClient interface {
 	AddNetwork(ctx context.Context, in *AddNetworkRequest, opts ...grpc.CallOption) (*AddNetworkReply, error)
 	DelNetwork(ctx context.Context, in *DelNetworkRequest, opts ...grpc.CallOption) (*DelNetworkReply, error)
+	ReportNetlinkRetries(ctx context.Context, in *NetlinkRetriesRequest, opts ...grpc.CallOption) (*NetlinkRetriesReply, error)
 }
 
 type cNIBackendClient struct {
@@ -267,11 +585,21 @@ func (c *cNIBackendClient) DelNetwork(ctx context.Context, in *DelNetworkRequest
 	return out, nil
 }
 
+func (c *cNIBackendClient) ReportNetlinkRetries(ctx context.Context, in *NetlinkRetriesRequest, opts ...grpc.CallOption) (*NetlinkRetriesReply, error) {
+	out := new(NetlinkRetriesReply)
+	err := grpc.Invoke(ctx, "/rpc.CNIBackend/ReportNetlinkRetries", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for CNIBackend service
 
 type CNIBackendServer interface {
 	AddNetwork(context.Context, *AddNetworkRequest) (*AddNetworkReply, error)
 	DelNetwork(context.Context, *DelNetworkRequest) (*DelNetworkReply, error)
+	ReportNetlinkRetries(context.Context, *NetlinkRetriesRequest) (*NetlinkRetriesReply, error)
 }
 
 func RegisterCNIBackendServer(s *grpc.Server, srv CNIBackendServer) {
@@ -314,6 +642,24 @@ func _CNIBackend_DelNetwork_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CNIBackend_ReportNetlinkRetries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NetlinkRetriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CNIBackendServer).ReportNetlinkRetries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.CNIBackend/ReportNetlinkRetries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CNIBackendServer).ReportNetlinkRetries(ctx, req.(*NetlinkRetriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _CNIBackend_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "rpc.CNIBackend",
 	HandlerType: (*CNIBackendServer)(nil),
@@ -326,6 +672,10 @@ var _CNIBackend_serviceDesc = grpc.ServiceDesc{
 			MethodName: "DelNetwork",
 			Handler:    _CNIBackend_DelNetwork_Handler,
 		},
+		{
+			MethodName: "ReportNetlinkRetries",
+			Handler:    _CNIBackend_ReportNetlinkRetries_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "rpc.proto",
@@ -334,30 +684,57 @@ var _CNIBackend_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("rpc.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 391 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x53, 0x41, 0x8f, 0x9a, 0x40,
-	0x18, 0x95, 0xa2, 0x54, 0xbf, 0x98, 0x10, 0x27, 0x86, 0x10, 0x0f, 0x8d, 0xe1, 0x64, 0x7a, 0xf0,
-	0xd0, 0xf6, 0x60, 0x9a, 0x5e, 0x28, 0xd0, 0x84, 0x98, 0x8e, 0x64, 0xb0, 0xbd, 0x12, 0x84, 0xd9,
-	0xc4, 0x88, 0xc0, 0x0e, 0xe0, 0xae, 0xff, 0x60, 0xff, 0xdb, 0x9e, 0xf6, 0x1f, 0x6d, 0x18, 0x41,
-	0x59, 0xb8, 0xed, 0x69, 0x6f, 0xbc, 0x37, 0xef, 0x25, 0xef, 0xcd, 0x63, 0x60, 0xc4, 0xd2, 0x60,
-	0x99, 0xb2, 0x24, 0x4f, 0x90, 0xc8, 0xd2, 0x40, 0x7b, 0x16, 0x60, 0xa2, 0x87, 0x21, 0xa6, 0xf9,
-	0x43, 0xc2, 0x0e, 0x84, 0xde, 0x17, 0x34, 0xcb, 0xd1, 0x1c, 0xc6, 0xeb, 0x95, 0xeb, 0x39, 0x1b,
-	0xd3, 0xc3, 0xfa, 0x5f, 0x4b, 0x15, 0xe6, 0xc2, 0x62, 0x44, 0x60, 0xbd, 0x72, 0x9d, 0x8d, 0x59,
-	0x32, 0xe8, 0x2b, 0x4c, 0x9a, 0x0a, 0xd7, 0xd1, 0x0d, 0x4b, 0xfd, 0xc4, 0x65, 0xf2, 0x4d, 0xc6,
-	0x69, 0xf4, 0x13, 0x66, 0xb5, 0xd6, 0xc6, 0x7f, 0x88, 0xee, 0x19, 0x1b, 0xbc, 0xd5, 0x6d, 0x6c,
-	0x11, 0xcf, 0x36, 0x55, 0x91, 0x9b, 0x94, 0x8b, 0x89, 0x9f, 0x5f, 0x8f, 0x6d, 0x13, 0x4d, 0x61,
-	0x80, 0x69, 0x1e, 0x67, 0x6a, 0x9f, 0xcb, 0x2e, 0x00, 0x29, 0x20, 0xd9, 0x77, 0xd8, 0x3f, 0x52,
-	0x75, 0xc0, 0xe9, 0x0a, 0x69, 0x2f, 0x02, 0xc8, 0xcd, 0x36, 0x69, 0x74, 0x46, 0x2a, 0x7c, 0x76,
-	0x8b, 0x20, 0xa0, 0x59, 0xc6, 0x6b, 0x0c, 0x49, 0x0d, 0xd1, 0x0c, 0x86, 0xb6, 0x73, 0xfa, 0xa1,
-	0x87, 0x21, 0xab, 0xa2, 0x5f, 0x31, 0xfa, 0x02, 0x50, 0x7e, 0xbb, 0xc5, 0x2e, 0xa6, 0x79, 0x95,
-	0xb1, 0xc1, 0x20, 0x0d, 0xc6, 0x26, 0x3d, 0xed, 0x03, 0x8a, 0x8b, 0xe3, 0x8e, 0x32, 0x1e, 0x6f,
-	0x40, 0xde, 0x70, 0x68, 0x01, 0xf2, 0xbf, 0x8c, 0x5a, 0x8f, 0x39, 0x65, 0xb1, 0x1f, 0xb9, 0x58,
-	0xdf, 0xf2, 0xb8, 0x43, 0xd2, 0xa6, 0xcb, 0x24, 0xff, 0x1d, 0x23, 0xd8, 0x87, 0x2c, 0x53, 0xa5,
-	0xb9, 0x58, 0x26, 0xa9, 0x71, 0xd9, 0x69, 0x62, 0xd2, 0xe8, 0xc3, 0x2e, 0xd4, 0xbc, 0xc5, 0x7e,
-	0xeb, 0x16, 0x15, 0x90, 0x08, 0xf5, 0xb3, 0x24, 0xae, 0x77, 0xba, 0x20, 0xed, 0x00, 0x72, 0xb3,
-	0xd2, 0xfb, 0x67, 0x6a, 0xcf, 0x20, 0x76, 0x67, 0xf8, 0xf6, 0x24, 0x00, 0x18, 0xd8, 0xfe, 0xed,
-	0x07, 0x07, 0x1a, 0x87, 0xe8, 0x17, 0xc0, 0xed, 0x17, 0x41, 0xca, 0xb2, 0x7c, 0x10, 0x9d, 0x17,
-	0x30, 0x9b, 0x76, 0xf8, 0x34, 0x3a, 0x6b, 0xbd, 0xd2, 0x7d, 0x4b, 0x5e, 0xb9, 0x3b, 0xeb, 0x54,
-	0xee, 0x56, 0x45, 0xad, 0xb7, 0x93, 0xf8, 0xcb, 0xfb, 0xfe, 0x1a, 0x00, 0x00, 0xff, 0xff, 0x7c,
-	0x38, 0x53, 0x0e, 0x86, 0x03, 0x00, 0x00,
+	// 821 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x56, 0xcd, 0x8e, 0xe3, 0x44,
+	0x10, 0xc6, 0x9b, 0x5f, 0x97, 0xc3, 0x84, 0x34, 0x21, 0x6a, 0x45, 0xb0, 0x8a, 0x7c, 0x40, 0x11,
+	0xa0, 0x45, 0x0a, 0x8b, 0xb4, 0x42, 0x5c, 0xb2, 0x71, 0x96, 0xb1, 0x46, 0x38, 0x51, 0x7b, 0x32,
+	0xd7, 0xc8, 0x63, 0xf7, 0x80, 0x35, 0x8e, 0x6d, 0xba, 0x3b, 0x33, 0xe4, 0x0d, 0x78, 0x1e, 0x1e,
+	0x04, 0x89, 0x1b, 0x8f, 0xc0, 0x63, 0xa0, 0x6e, 0xdb, 0x49, 0x27, 0x19, 0x90, 0x80, 0xcb, 0xdc,
+	0xf2, 0x7d, 0x55, 0xe5, 0xae, 0xae, 0xef, 0x73, 0x39, 0x60, 0xb2, 0x3c, 0x7c, 0x95, 0xb3, 0x4c,
+	0x64, 0xa8, 0xc6, 0xf2, 0xd0, 0xfe, 0xa5, 0x0e, 0xbd, 0x69, 0x14, 0x79, 0x54, 0x3c, 0x66, 0xec,
+	0x9e, 0xd0, 0x9f, 0xb6, 0x94, 0x0b, 0x34, 0x82, 0xce, 0xd5, 0x1b, 0x7f, 0xbd, 0x5c, 0x38, 0x6b,
+	0x6f, 0xfa, 0xfd, 0x1c, 0x1b, 0x23, 0x63, 0x6c, 0x12, 0xb8, 0x7a, 0xe3, 0x2f, 0x17, 0x8e, 0x64,
+	0xd0, 0x67, 0xd0, 0xd3, 0x33, 0xfc, 0xe5, 0x74, 0x36, 0xc7, 0x2f, 0x54, 0x5a, 0xf7, 0x90, 0xa6,
+	0x68, 0xf4, 0x0d, 0x0c, 0xab, 0x5c, 0xd7, 0x7b, 0x47, 0xa6, 0xeb, 0xd9, 0xc2, 0xbb, 0x9e, 0xba,
+	0xde, 0x9c, 0xac, 0x5d, 0x07, 0xd7, 0x54, 0xd1, 0xa0, 0x28, 0x52, 0xf1, 0x7d, 0xd8, 0x75, 0x50,
+	0x1f, 0x1a, 0x1e, 0x15, 0x29, 0xc7, 0x75, 0x95, 0x56, 0x00, 0x34, 0x80, 0xa6, 0x7b, 0xe7, 0x05,
+	0x1b, 0x8a, 0x1b, 0x8a, 0x2e, 0x11, 0x7a, 0x09, 0x56, 0x75, 0xd2, 0xca, 0x75, 0x70, 0x53, 0x05,
+	0xcd, 0xe2, 0xd1, 0x2b, 0xd7, 0x41, 0x5f, 0xa8, 0xcb, 0xc6, 0x22, 0xce, 0xd2, 0x20, 0x29, 0x6a,
+	0x38, 0x6e, 0x8d, 0x6a, 0x63, 0x93, 0x9c, 0x07, 0xd0, 0x4b, 0x00, 0x7f, 0x7b, 0x9b, 0x52, 0x71,
+	0x19, 0xa7, 0x02, 0xb7, 0x8b, 0x19, 0x1c, 0x18, 0xf4, 0x29, 0x5c, 0xac, 0x38, 0x25, 0x94, 0x53,
+	0xf6, 0x40, 0x23, 0x77, 0xc9, 0xb1, 0x39, 0x32, 0xc6, 0x6d, 0x72, 0xc2, 0xa2, 0x11, 0x58, 0x97,
+	0x19, 0x17, 0xe5, 0x8c, 0x31, 0xa8, 0x24, 0x9d, 0x42, 0x9f, 0x40, 0xcd, 0xf1, 0x7c, 0x6c, 0x8d,
+	0x8c, 0xb1, 0x35, 0xb1, 0x5e, 0x49, 0x8d, 0x96, 0x59, 0xe4, 0x78, 0x3e, 0x91, 0xbc, 0x6c, 0x64,
+	0xfe, 0x03, 0xa3, 0x9c, 0x2f, 0xd2, 0x64, 0x87, 0x3b, 0xaa, 0x5e, 0x63, 0xd0, 0x10, 0xda, 0xee,
+	0xf2, 0x5d, 0xb0, 0x89, 0x93, 0x1d, 0x7e, 0x5f, 0xb5, 0xb9, 0xc7, 0x68, 0x0c, 0x2d, 0x7f, 0xc7,
+	0x43, 0x91, 0x70, 0x7c, 0x31, 0xaa, 0x8d, 0xad, 0xc9, 0x45, 0xf5, 0xf8, 0x82, 0x26, 0x55, 0xd8,
+	0xfe, 0xed, 0x05, 0x74, 0x75, 0x2b, 0xe4, 0xc9, 0x0e, 0x61, 0x68, 0xf9, 0xdb, 0x30, 0xa4, 0x9c,
+	0x2b, 0x0f, 0xb4, 0x49, 0x05, 0x8b, 0x33, 0x1f, 0x5e, 0x4f, 0xa3, 0x88, 0x95, 0xba, 0xef, 0xb1,
+	0xec, 0x57, 0xfe, 0x2e, 0x46, 0x55, 0x0a, 0xac, 0x31, 0xc8, 0x86, 0x8e, 0x43, 0x1f, 0xe2, 0x90,
+	0x7a, 0xdb, 0xcd, 0x2d, 0x65, 0x4a, 0xdb, 0x06, 0x39, 0xe2, 0xd0, 0x18, 0xba, 0x2b, 0x4e, 0xe7,
+	0x3f, 0x0b, 0xca, 0xd2, 0x20, 0xf1, 0xbd, 0xe9, 0xb5, 0xd2, 0xba, 0x4d, 0x4e, 0x69, 0xd9, 0xc9,
+	0xcd, 0x72, 0x16, 0xc6, 0x11, 0xe3, 0xb8, 0xa9, 0xb4, 0xdc, 0x63, 0x34, 0x87, 0xbe, 0xa6, 0x6b,
+	0x2a, 0x28, 0xbb, 0x0b, 0xc2, 0x52, 0x73, 0x6b, 0xd2, 0xab, 0x46, 0xb1, 0x8f, 0x90, 0x27, 0xd3,
+	0xd1, 0x6b, 0xe8, 0xf8, 0x22, 0x10, 0x71, 0x48, 0xb2, 0xad, 0xa0, 0x1c, 0xb7, 0x55, 0xf9, 0x07,
+	0xaa, 0x5c, 0x0b, 0x90, 0xa3, 0x2c, 0xfb, 0x77, 0x03, 0x7a, 0x0e, 0x4d, 0x9e, 0xed, 0xbb, 0xa5,
+	0x4b, 0x58, 0x3f, 0x91, 0x70, 0x00, 0x4d, 0x42, 0x03, 0x9e, 0xa5, 0xd5, 0x1b, 0x56, 0x20, 0xfb,
+	0x57, 0x03, 0xba, 0xfa, 0x9d, 0xfe, 0xbb, 0x49, 0x4e, 0x4d, 0x50, 0x7b, 0xc2, 0x04, 0x7f, 0x27,
+	0x5f, 0xfd, 0x5f, 0xc9, 0x67, 0xdf, 0x41, 0x47, 0xcf, 0xd2, 0xd6, 0x87, 0x71, 0xb4, 0x3e, 0xfe,
+	0x67, 0xbb, 0xb6, 0x0b, 0x96, 0x66, 0x00, 0xf9, 0xde, 0x3b, 0x94, 0x8b, 0x38, 0x0d, 0x64, 0x47,
+	0xe5, 0x59, 0x3a, 0x25, 0x27, 0xf7, 0x5d, 0x20, 0xe8, 0x63, 0xb0, 0x2b, 0xcf, 0xab, 0xa0, 0xfd,
+	0xa7, 0x01, 0x1f, 0x79, 0x54, 0x24, 0x71, 0x7a, 0x4f, 0xa8, 0x60, 0x31, 0xe5, 0xcf, 0xcf, 0x3f,
+	0x18, 0x5a, 0xb3, 0x6c, 0xb3, 0x09, 0xd2, 0xa8, 0xb4, 0x4f, 0x05, 0xd1, 0xe7, 0xd0, 0x2a, 0xbb,
+	0xc6, 0x0d, 0x4d, 0x2a, 0xed, 0x42, 0x3b, 0x52, 0x65, 0xd8, 0x6f, 0xa1, 0xa3, 0x07, 0xd0, 0xc7,
+	0x60, 0x2e, 0x72, 0xca, 0xf4, 0xa1, 0x1d, 0x08, 0xf9, 0x41, 0x98, 0x65, 0xdb, 0x54, 0xa8, 0x0b,
+	0x35, 0x48, 0x01, 0xec, 0x2f, 0xe1, 0xc3, 0xd3, 0x69, 0xfd, 0xa3, 0x33, 0x6d, 0x01, 0xcd, 0x62,
+	0xc3, 0x4a, 0x95, 0xd4, 0xba, 0x97, 0xeb, 0x9a, 0xc9, 0x3c, 0xb9, 0x41, 0x74, 0x4a, 0xda, 0xc5,
+	0xc9, 0x36, 0x41, 0x9c, 0x96, 0x43, 0x2c, 0x91, 0xe4, 0x7d, 0x1a, 0xb0, 0xf0, 0x47, 0x5c, 0x53,
+	0x45, 0x25, 0x92, 0xa7, 0x2e, 0x72, 0xd9, 0x6c, 0x61, 0x54, 0x93, 0x54, 0xd0, 0xfe, 0x1a, 0xcc,
+	0xfd, 0xe2, 0x45, 0x08, 0xea, 0x9a, 0x07, 0xd5, 0x6f, 0x79, 0xbb, 0x9b, 0x20, 0xd9, 0xd2, 0xf2,
+	0xa4, 0x02, 0x4c, 0xfe, 0x30, 0x00, 0x66, 0x9e, 0xfb, 0x36, 0x08, 0xef, 0x69, 0x1a, 0xa1, 0x6f,
+	0x01, 0x0e, 0x7b, 0x1a, 0x0d, 0xd4, 0x68, 0xcf, 0xbe, 0xe1, 0xc3, 0xfe, 0x19, 0x9f, 0x27, 0x3b,
+	0xfb, 0x3d, 0x59, 0x7d, 0x78, 0x81, 0xcb, 0xea, 0xb3, 0x2d, 0x35, 0xec, 0x9f, 0xf1, 0x45, 0xb5,
+	0x07, 0x7d, 0x42, 0xf3, 0x8c, 0x89, 0xe3, 0x71, 0xa3, 0xe1, 0xa9, 0xc0, 0x07, 0xc7, 0x0e, 0xf1,
+	0x93, 0x31, 0xf5, 0xbc, 0xdb, 0xa6, 0xfa, 0x2f, 0xf2, 0xd5, 0x5f, 0x03, 0x00, 0xe6, 0xf5, 0xac,
+	0x23, 0x98, 0x08, 0x00, 0x00,
 }
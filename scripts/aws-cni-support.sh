@@ -27,6 +27,62 @@ curl http://localhost:61679/v1/pods         > ${LOG_DIR}/pod.out
 curl http://localhost:61679/v1/networkutils-env-settings > ${LOG_DIR}/networkutils-env.out
 curl http://localhost:61679/v1/ipamd-env-settings > ${LOG_DIR}/ipamd-env.out
 curl http://localhost:61679/v1/eni-configs  > ${LOG_DIR}/eni-configs.out
+curl http://localhost:61679/v1/routes       > ${LOG_DIR}/routes.out
+curl http://localhost:61679/v1/reconcile-status > ${LOG_DIR}/reconcile-status.out
+curl http://localhost:61679/v1/cooldown     > ${LOG_DIR}/cooldown.out
+curl http://localhost:61679/v1/credentials  > ${LOG_DIR}/credentials.out
+curl http://localhost:61679/v1/metadata-wait > ${LOG_DIR}/metadata-wait.out
+curl http://localhost:61679/v1/ec2-api-latency > ${LOG_DIR}/ec2-api-latency.out
+curl http://localhost:61679/v1/primary-eni > ${LOG_DIR}/primary-eni.out
+curl http://localhost:61679/v1/near-full-enis > ${LOG_DIR}/near-full-enis.out
+curl http://localhost:61679/v1/k8s-status > ${LOG_DIR}/k8s-status.out
+curl http://localhost:61679/v1/duplicate-adds > ${LOG_DIR}/duplicate-adds.out
+curl http://localhost:61679/v1/pod-trace > ${LOG_DIR}/pod-trace.out
+curl http://localhost:61679/v1/subnet-full > ${LOG_DIR}/subnet-full.out
+curl http://localhost:61679/v1/networking-mode > ${LOG_DIR}/networking-mode.out
+curl http://localhost:61679/v1/add-retries > ${LOG_DIR}/add-retries.out
+curl http://localhost:61679/v1/orphaned-by-sandbox > ${LOG_DIR}/orphaned-by-sandbox.out
+curl http://localhost:61679/v1/attach-limit > ${LOG_DIR}/attach-limit.out
+curl http://localhost:61679/v1/del-verify > ${LOG_DIR}/del-verify.out
+curl http://localhost:61679/v1/host-network-adds > ${LOG_DIR}/host-network-adds.out
+curl http://localhost:61679/v1/subnet-selection > ${LOG_DIR}/subnet-selection.out
+curl http://localhost:61679/v1/eni-consistency > ${LOG_DIR}/eni-consistency.out
+curl http://localhost:61679/v1/sg-drift > ${LOG_DIR}/sg-drift.out
+curl http://localhost:61679/v1/namespace-usage > ${LOG_DIR}/namespace-usage.out
+curl http://localhost:61679/v1/pod-routing > ${LOG_DIR}/pod-routing.out
+curl http://localhost:61679/v1/eni-deletes > ${LOG_DIR}/eni-deletes.out
+curl http://localhost:61679/v1/ip-classification > ${LOG_DIR}/ip-classification.out
+curl http://localhost:61679/v1/conntrack-cleanup > ${LOG_DIR}/conntrack-cleanup.out
+curl http://localhost:61679/v1/connectivity > ${LOG_DIR}/connectivity.out
+curl http://localhost:61679/v1/pool-growth-batches > ${LOG_DIR}/pool-growth-batches.out
+curl http://localhost:61679/v1/lock-stats > ${LOG_DIR}/lock-stats.out
+curl http://localhost:61679/v1/activity > ${LOG_DIR}/activity.out
+curl http://localhost:61679/v1/detach-blocked > ${LOG_DIR}/detach-blocked.out
+curl http://localhost:61679/v1/pod-cidrs > ${LOG_DIR}/pod-cidrs.out
+curl http://localhost:61679/v1/netlink-retries > ${LOG_DIR}/netlink-retries.out
+curl http://localhost:61679/v1/legacy-enis > ${LOG_DIR}/legacy-enis.out
+curl http://localhost:61679/v1/del-failures > ${LOG_DIR}/del-failures.out
+curl http://localhost:61679/v1/same-pod-ip-reuse > ${LOG_DIR}/same-pod-ip-reuse.out
+curl http://localhost:61679/v1/egress-only-pods > ${LOG_DIR}/egress-only-pods.out
+curl http://localhost:61679/v1/network-topology > ${LOG_DIR}/network-topology.out
+curl http://localhost:61679/v1/partial-allocations > ${LOG_DIR}/partial-allocations.out
+curl http://localhost:61679/v1/cni-stats > ${LOG_DIR}/cni-stats.out
+curl http://localhost:61679/v1/cluster-info > ${LOG_DIR}/cluster-info.out
+curl http://localhost:61679/v1/startup-profile > ${LOG_DIR}/startup-profile.out
+curl http://localhost:61679/v1/address-families > ${LOG_DIR}/address-families.out
+curl http://localhost:61679/v1/efficiency > ${LOG_DIR}/efficiency.out
+curl http://localhost:61679/v1/ip-family-mismatches > ${LOG_DIR}/ip-family-mismatches.out
+curl http://localhost:61679/v1/trunk-topology > ${LOG_DIR}/trunk-topology.out
+curl http://localhost:61679/v1/branch-eni-cleanup > ${LOG_DIR}/branch-eni-cleanup.out
+curl http://localhost:61679/v1/iptables-backend > ${LOG_DIR}/iptables-backend.out
+curl http://localhost:61679/v1/subnet-distribution > ${LOG_DIR}/subnet-distribution.out
+curl http://localhost:61679/v1/datastore-size > ${LOG_DIR}/datastore-size.out
+curl http://localhost:61679/v1/alloc-recoveries > ${LOG_DIR}/alloc-recoveries.out
+curl http://localhost:61679/v1/diagnose > ${LOG_DIR}/diagnose.out
+curl http://localhost:61679/v1/detached-eni-pods > ${LOG_DIR}/detached-eni-pods.out
+curl http://localhost:61679/v1/ip-ages > ${LOG_DIR}/ip-ages.out
+curl http://localhost:61679/v1/eniconfig-changes > ${LOG_DIR}/eniconfig-changes.out
+curl http://localhost:61679/v1/effective-policy > ${LOG_DIR}/effective-policy.out
 
 # Metrics
 curl http://localhost:61678/metrics 2>&1 > ${LOG_DIR}/metrics.out
@@ -74,6 +74,11 @@ cp aws-cni-support.sh "$HOST_CNI_BIN_PATH"
 
 sed -i s/__VETHPREFIX__/"${AWS_VPC_K8S_CNI_VETHPREFIX:-"eni"}"/g 10-aws.conflist
 sed -i s/__MTU__/"${AWS_VPC_ENI_MTU:-"9001"}"/g 10-aws.conflist
+sed -i s/__NETLINK_RETRIES__/"${AWS_VPC_K8S_CNI_NETLINK_RETRIES:-"0"}"/g 10-aws.conflist
+sed -i s/__POD_SYSCTLS__/"${AWS_VPC_K8S_CNI_POD_SYSCTLS:-}"/g 10-aws.conflist
+# eth1,eth2 -> "eth1","eth2"
+ADDITIONAL_INTERFACES=$(echo "${AWS_VPC_K8S_CNI_ADDITIONAL_INTERFACES:-}" | sed 's/[^,][^,]*/"&"/g')
+sed -i s/__ADDITIONAL_INTERFACES__/"${ADDITIONAL_INTERFACES}"/g 10-aws.conflist
 cp 10-aws.conflist "$HOST_CNI_CONFDIR_PATH"
 
 echo " ok."